@@ -0,0 +1,149 @@
+//
+// acme.go -- https with certificates from let's encrypt or another acme ca
+//
+
+package srnd
+
+import (
+	"crypto/tls"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acme challenges we can answer
+const (
+	// http-01 on acme_http_bind and tls-alpn-01 on acme_https_bind
+	ACMEChallengeHTTP = "http-01"
+	// a txt record acme_dns_hook puts up
+	ACMEChallengeDNS = "dns-01"
+)
+
+type acmeServer struct {
+	// certificates for the https server
+	tlsConfig *tls.Config
+	// answers http-01 challenges if we use them and redirects everything else to https
+	httpHandler http.Handler
+	// gets certificates over dns-01, nil for http-01
+	dns *acmeDNSManager
+	// where we serve https
+	httpsAddr string
+	// where we serve httpHandler, empty for nowhere
+	httpAddr string
+	// max-age of the Strict-Transport-Security header, 0 to not send it
+	hsts int
+}
+
+// tell browsers to only come back over https
+func (self *acmeServer) withHSTS(h http.Handler) http.Handler {
+	if self.hsts <= 0 {
+		return h
+	}
+	value := "max-age=" + strconv.Itoa(self.hsts)
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(wr, r)
+	})
+}
+
+// serve h over https, getting and renewing certificates as needed
+func (self *acmeServer) Serve(h http.Handler) {
+	if self.dns != nil {
+		go self.dns.Run()
+	}
+	if self.httpAddr != "" {
+		go func() {
+			log.Println("redirecting to https on", self.httpAddr)
+			err := http.ListenAndServe(self.httpAddr, self.httpHandler)
+			if err != nil {
+				log.Println("cannot serve acme challenges", err)
+			}
+		}()
+	}
+	srv := &http.Server{
+		Addr:      self.httpsAddr,
+		Handler:   self.withHSTS(h),
+		TLSConfig: self.tlsConfig,
+	}
+	log.Println("serving https on", self.httpsAddr)
+	err := srv.ListenAndServeTLS("", "")
+	if err != nil {
+		log.Fatalf("failed to serve https on %s %s", self.httpsAddr, err)
+	}
+}
+
+// send everything to the same place over https
+func redirectHTTPS(wr http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(wr, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
+
+// create the acme server from frontend config, nil if it is disabled
+// certificates are for acme_domains and get kept in acme_cache
+func acmeServerFromConfig(config map[string]string) *acmeServer {
+	if config["acme"] != "1" {
+		return nil
+	}
+	var domains []string
+	for _, domain := range strings.Split(config["acme_domains"], ",") {
+		domain = strings.TrimSpace(domain)
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		log.Println("acme enabled but acme_domains is empty, not serving https")
+		return nil
+	}
+	srv := &acmeServer{
+		httpsAddr: config["acme_https_bind"],
+		httpAddr:  config["acme_http_bind"],
+		hsts:      mapGetInt(config, "hsts", 0),
+	}
+	var client *acme.Client
+	if directory := config["acme_directory"]; directory != "" {
+		client = &acme.Client{DirectoryURL: directory}
+	}
+	switch challenge := config["acme_challenge"]; challenge {
+	case "", ACMEChallengeHTTP:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(config["acme_cache"]),
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Email:      config["acme_email"],
+			Client:     client,
+		}
+		srv.tlsConfig = manager.TLSConfig()
+		// no fallback handler redirects everything else to https
+		srv.httpHandler = manager.HTTPHandler(nil)
+	case ACMEChallengeDNS:
+		if config["acme_dns_hook"] == "" {
+			log.Println("acme_challenge is dns-01 but acme_dns_hook is empty, not serving https")
+			return nil
+		}
+		if client == nil {
+			client = &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+		}
+		srv.dns = &acmeDNSManager{
+			client:  client,
+			domains: domains,
+			email:   config["acme_email"],
+			cache:   config["acme_cache"],
+			hook:    config["acme_dns_hook"],
+		}
+		srv.tlsConfig = srv.dns.TLSConfig()
+		srv.httpHandler = http.HandlerFunc(redirectHTTPS)
+	default:
+		log.Println("acme_challenge is", challenge, "but it should be", ACMEChallengeHTTP, "or", ACMEChallengeDNS, "not serving https")
+		return nil
+	}
+	log.Println("getting certificates over acme for", strings.Join(domains, ", "))
+	return srv
+}
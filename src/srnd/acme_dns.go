@@ -0,0 +1,257 @@
+//
+// acme_dns.go -- certificates over the acme dns-01 challenge, for hosts a ca can't reach on port 80 or 443
+//
+// we don't talk to dns providers ourselves, acme_dns_hook is run to put the txt record up and take it down:
+//   hook present example.tld <value>   sets _acme-challenge.example.tld to value and returns once it is live
+//   hook cleanup example.tld <value>   removes it again
+//
+
+package srnd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/acme"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renew certificates this long before they expire
+const acmeRenewBefore = time.Hour * 24 * 30
+
+// how long one try at getting a certificate may take, hooks included
+const acmeOrderTimeout = time.Minute * 10
+
+var ACMENoDNSChallenge = errors.New("the acme ca offers no dns-01 challenge")
+var ACMENoCertificate = errors.New("no certificate yet")
+
+// gets and renews one certificate for all its domains over dns-01
+type acmeDNSManager struct {
+	client  *acme.Client
+	domains []string
+	email   string
+	// where the account key and certificate are kept
+	cache string
+	hook  string
+	// the certificate we serve
+	access sync.RWMutex
+	cert   *tls.Certificate
+}
+
+func (self *acmeDNSManager) accountFile() string {
+	return filepath.Join(self.cache, "dns01-account.pem")
+}
+
+func (self *acmeDNSManager) certFile() string {
+	return filepath.Join(self.cache, "dns01-cert.pem")
+}
+
+// the certificate for a tls handshake
+func (self *acmeDNSManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	if self.cert == nil {
+		return nil, ACMENoCertificate
+	}
+	return self.cert, nil
+}
+
+func (self *acmeDNSManager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: self.GetCertificate, MinVersion: tls.VersionTLS12}
+}
+
+// does the certificate need to be renewed?
+func (self *acmeDNSManager) needsRenewal() bool {
+	self.access.RLock()
+	defer self.access.RUnlock()
+	return self.cert == nil || time.Until(self.cert.Leaf.NotAfter) < acmeRenewBefore
+}
+
+// keep a certificate, renewing it when it gets old
+func (self *acmeDNSManager) Run() {
+	err := os.MkdirAll(self.cache, 0700)
+	if err != nil {
+		log.Println("cannot make acme cache", self.cache, err)
+	}
+	err = self.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("cannot load acme certificate from", self.certFile(), err)
+	}
+	for {
+		if self.needsRenewal() {
+			log.Println("getting a certificate over acme dns-01 for", strings.Join(self.domains, ", "))
+			err = self.obtain()
+			if err == nil {
+				log.Println("got a certificate over acme dns-01 for", strings.Join(self.domains, ", "))
+			} else {
+				log.Println("failed to get a certificate over acme dns-01", err)
+			}
+		}
+		time.Sleep(time.Hour * 12)
+	}
+}
+
+// load the certificate we got before
+func (self *acmeDNSManager) load() error {
+	data, err := ioutil.ReadFile(self.certFile())
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	self.access.Lock()
+	self.cert = &cert
+	self.access.Unlock()
+	return nil
+}
+
+// load our acme account key or make one
+func (self *acmeDNSManager) accountKey() (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(self.accountFile())
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no key in " + self.accountFile())
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(self.accountFile(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	return key, err
+}
+
+// run the dns hook, it says what went wrong on its output
+func (self *acmeDNSManager) runHook(ctx context.Context, action, domain, value string) error {
+	out, err := exec.CommandContext(ctx, self.hook, action, domain, value).CombinedOutput()
+	if err != nil {
+		return errors.New("acme_dns_hook " + action + " " + domain + " failed: " + err.Error() + " " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// answer the dns-01 challenge of one authorization
+func (self *acmeDNSManager) authorize(ctx context.Context, url string) error {
+	z, err := self.client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return ACMENoDNSChallenge
+	}
+	value, err := self.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	domain := z.Identifier.Value
+	err = self.runHook(ctx, "present", domain, value)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := self.runHook(context.Background(), "cleanup", domain, value); err != nil {
+			log.Println(err)
+		}
+	}()
+	_, err = self.client.Accept(ctx, chal)
+	if err == nil {
+		_, err = self.client.WaitAuthorization(ctx, z.URI)
+	}
+	return err
+}
+
+// get a new certificate and keep it in the cache
+func (self *acmeDNSManager) obtain() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeOrderTimeout)
+	defer cancel()
+	if self.client.Key == nil {
+		self.client.Key, err = self.accountKey()
+		if err != nil {
+			return
+		}
+	}
+	account := new(acme.Account)
+	if self.email != "" {
+		account.Contact = []string{"mailto:" + self.email}
+	}
+	_, err = self.client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return
+	}
+	order, err := self.client.AuthorizeOrder(ctx, acme.DomainIDs(self.domains...))
+	if err != nil {
+		return
+	}
+	for _, url := range order.AuthzURLs {
+		err = self.authorize(ctx, url)
+		if err != nil {
+			return
+		}
+	}
+	order, err = self.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: self.domains}, key)
+	if err != nil {
+		return
+	}
+	der, _, err := self.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, c := range der {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	err = ioutil.WriteFile(self.certFile(), data, 0600)
+	if err == nil {
+		err = self.load()
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package srnd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestACMEServerFromConfig(t *testing.T) {
+
+	conf := map[string]string{"acme": "1", "acme_domains": "test.tld", "acme_challenge": "dns-01", "acme_dns_hook": "/bin/true"}
+	srv := acmeServerFromConfig(conf)
+	if srv == nil || srv.dns == nil {
+		t.Error("dns-01 not used")
+	}
+	conf["acme_dns_hook"] = ""
+	if acmeServerFromConfig(conf) != nil {
+		t.Error("dns-01 without a hook used")
+	}
+	conf["acme_challenge"] = "dns-02"
+	if acmeServerFromConfig(conf) != nil {
+		t.Error("unknown challenge used")
+	}
+	conf["acme_challenge"] = "http-01"
+	srv = acmeServerFromConfig(conf)
+	if srv == nil || srv.dns != nil {
+		t.Error("http-01 not used")
+	}
+
+}
+
+// write a certificate that expires after d where the dns-01 manager keeps it
+func testACMECert(t *testing.T, m *acmeDNSManager, d time.Duration) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: m.domains, NotBefore: time.Now(), NotAfter: time.Now().Add(d)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	if err = ioutil.WriteFile(m.certFile(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestACMEDNSRenewal(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	m := &acmeDNSManager{domains: []string{"test.tld"}, cache: dir}
+	if !m.needsRenewal() {
+		t.Error("no certificate does not need one")
+	}
+	if _, err := m.GetCertificate(nil); err != ACMENoCertificate {
+		t.Error("served a certificate we don't have", err)
+	}
+	testACMECert(t, m, time.Hour*24*80)
+	if err := m.load(); err != nil {
+		t.Fatal(err)
+	}
+	if m.needsRenewal() {
+		t.Error("fresh certificate needs renewal")
+	}
+	if cert, err := m.GetCertificate(nil); err != nil || cert.Leaf.DNSNames[0] != "test.tld" {
+		t.Error("certificate not served", err)
+	}
+	testACMECert(t, m, time.Hour*24*10)
+	m.load()
+	if !m.needsRenewal() {
+		t.Error("old certificate does not need renewal")
+	}
+
+}
@@ -0,0 +1,79 @@
+//
+// addrkeys.go -- rotate and forget the keys behind encrypted addresses
+//
+
+package srnd
+
+import (
+	"log"
+	"time"
+)
+
+// return true if an encrypted address has to keep its key because a ban hangs on it
+func encAddrPinned(db Database, encaddr string) bool {
+	banned, err := db.CheckEncIPBanned(encaddr)
+	if err == nil && !banned {
+		banned, err = db.CheckEncAddrShadowBanned(encaddr)
+	}
+	return banned || err != nil
+}
+
+// give every address key made before rotateBefore a new key and forget every one made before purgeBefore
+// 0 skips either, banned addresses keep their key so the ban still holds
+func rotateAddrKeys(db Database, rotateBefore, purgeBefore int64) (rotated, purged int) {
+	if purgeBefore > 0 {
+		encaddrs, err := db.GetEncAddrsMadeBefore(purgeBefore)
+		if err != nil {
+			log.Println("failed to get address keys to purge", err)
+		}
+		for _, encaddr := range encaddrs {
+			if encAddrPinned(db, encaddr) {
+				continue
+			}
+			err = db.DeleteEncAddress(encaddr)
+			if err == nil {
+				purged++
+			} else {
+				log.Println("failed to purge address key", err)
+			}
+		}
+	}
+	if rotateBefore > 0 {
+		encaddrs, err := db.GetEncAddrsMadeBefore(rotateBefore)
+		if err != nil {
+			log.Println("failed to get address keys to rotate", err)
+		}
+		for _, encaddr := range encaddrs {
+			if encAddrPinned(db, encaddr) {
+				continue
+			}
+			_, err = db.RotateEncAddress(encaddr)
+			if err == nil {
+				rotated++
+			} else {
+				log.Println("failed to rotate address key", err)
+			}
+		}
+	}
+	return
+}
+
+// unix time days ago, 0 if days is 0
+func daysAgo(days int) int64 {
+	if days <= 0 {
+		return 0
+	}
+	return time.Now().Add(-time.Duration(days) * time.Hour * 24).Unix()
+}
+
+// rotate and purge address keys every hour forever
+func (self *NNTPDaemon) addrKeysMainloop(rotateDays, purgeDays int) {
+	log.Printf("rotating address keys after %d days, purging after %d days", rotateDays, purgeDays)
+	for {
+		rotated, purged := rotateAddrKeys(self.database, daysAgo(rotateDays), daysAgo(purgeDays))
+		if rotated > 0 || purged > 0 {
+			log.Println("rotated", rotated, "address keys, purged", purged)
+		}
+		time.Sleep(time.Hour)
+	}
+}
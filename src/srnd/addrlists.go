@@ -0,0 +1,215 @@
+//
+// addrlists.go -- check frontend posters against dnsbls and the tor exit list
+//
+
+package srnd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// what to do with posters on a list
+const (
+	// make them solve a captcha even if the newsgroup does not want one
+	AddrListCaptcha = "captcha"
+	// don't let them post
+	AddrListReject = "reject"
+	// let them post but mark the post with the lists they are on
+	AddrListTag = "tag"
+)
+
+// name of the tor exit list in listings
+const torExitList = "tor"
+
+var AddrListed = errors.New("your address is on a block list")
+
+// a dnsbl and what we do with posters on it
+type addrList struct {
+	zone   string
+	action string
+}
+
+// return true if we know what to do with posters on a list
+func validAddrListAction(action string) bool {
+	return action == AddrListCaptcha || action == AddrListReject || action == AddrListTag
+}
+
+// the name we look up to check ip against a dnsbl zone
+func dnsblQuery(ip net.IP, zone string) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for idx := 3; idx >= 0; idx-- {
+			labels = append(labels, fmt.Sprintf("%d", ip4[idx]))
+		}
+	} else {
+		ip16 := ip.To16()
+		for idx := 15; idx >= 0; idx-- {
+			labels = append(labels, fmt.Sprintf("%x", ip16[idx]&0x0f), fmt.Sprintf("%x", ip16[idx]>>4))
+		}
+	}
+	return strings.Join(labels, ".") + "." + zone
+}
+
+type addrLists struct {
+	dnsbls []addrList
+	// what we do with tor exits, empty to not check
+	torAction string
+	// where we get the tor exit list from
+	torURL string
+	// how long we remember what lists an address is on
+	ttl time.Duration
+	db  Database
+	// resolves dnsbl queries
+	resolve func(string) ([]string, error)
+	access  sync.RWMutex
+	// ip -> true for every tor exit
+	exits map[string]bool
+}
+
+// the lists addr is on, looked up again once the cached listing expires
+func (self *addrLists) Lookup(addr string) (lists []string) {
+	if self == nil {
+		return
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return
+	}
+	lists, cached, err := self.db.GetAddrListing(addr)
+	if err == nil && cached {
+		return
+	}
+	lists = nil
+	if len(self.torAction) > 0 {
+		self.access.RLock()
+		exit := self.exits[ip.String()]
+		self.access.RUnlock()
+		if exit {
+			lists = append(lists, torExitList)
+		}
+	}
+	for _, l := range self.dnsbls {
+		// any answer means listed
+		found, err := self.resolve(dnsblQuery(ip, l.zone))
+		if err == nil && len(found) > 0 {
+			lists = append(lists, l.zone)
+		}
+	}
+	err = self.db.CacheAddrListing(addr, lists, time.Now().Add(self.ttl).Unix())
+	if err != nil {
+		log.Println("failed to cache address listing", err)
+	}
+	return
+}
+
+// what we do with posters on a list
+func (self *addrLists) Action(list string) string {
+	if list == torExitList {
+		return self.torAction
+	}
+	for _, l := range self.dnsbls {
+		if l.zone == list {
+			return l.action
+		}
+	}
+	return ""
+}
+
+// the lists in lists that want action
+func (self *addrLists) Matching(lists []string, action string) (matched []string) {
+	if self == nil {
+		return
+	}
+	for _, list := range lists {
+		if self.Action(list) == action {
+			matched = append(matched, list)
+		}
+	}
+	return
+}
+
+// fetch the tor exit list
+func (self *addrLists) updateExits() (err error) {
+	var resp *http.Response
+	resp, err = http.Get(self.torURL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New("tor exit list: " + resp.Status)
+	}
+	exits := make(map[string]bool)
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		ip := net.ParseIP(strings.TrimSpace(sc.Text()))
+		if ip != nil {
+			exits[ip.String()] = true
+		}
+	}
+	err = sc.Err()
+	if err == nil {
+		self.access.Lock()
+		self.exits = exits
+		self.access.Unlock()
+		log.Println("loaded", len(exits), "tor exits")
+	}
+	return
+}
+
+// keep the tor exit list up to date forever
+func (self *addrLists) Run() {
+	if self == nil || len(self.torAction) == 0 {
+		return
+	}
+	for {
+		err := self.updateExits()
+		if err != nil {
+			log.Println("failed to update tor exit list", err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// create the address lists from config, nil if there is nothing to check
+func addrListsFromConfig(conf map[string]string, db Database) *addrLists {
+	if conf["enable"] != "1" {
+		return nil
+	}
+	lists := &addrLists{
+		torURL:  conf["tor_exit_url"],
+		ttl:     time.Second * time.Duration(mapGetInt(conf, "cache_time", 3600)),
+		db:      db,
+		resolve: net.LookupHost,
+		exits:   make(map[string]bool),
+	}
+	// zone:action,zone:action
+	for _, entry := range splitList(conf["dnsbls"]) {
+		l := addrList{zone: entry, action: AddrListReject}
+		idx := strings.LastIndex(entry, ":")
+		if idx > 0 {
+			l = addrList{zone: entry[:idx], action: entry[idx+1:]}
+		}
+		if validAddrListAction(l.action) {
+			lists.dnsbls = append(lists.dnsbls, l)
+		} else {
+			log.Println("invalid action for dnsbl", l.zone, l.action)
+		}
+	}
+	if validAddrListAction(conf["tor_exits"]) && len(lists.torURL) > 0 {
+		lists.torAction = conf["tor_exits"]
+	}
+	if len(lists.dnsbls) == 0 && len(lists.torAction) == 0 {
+		return nil
+	}
+	log.Printf("address lists enabled, %d dnsbls, tor exits: %q", len(lists.dnsbls), lists.torAction)
+	return lists
+}
@@ -0,0 +1,19 @@
+package srnd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDNSBLQuery(t *testing.T) {
+
+	q := dnsblQuery(net.ParseIP("127.0.0.2"), "dnsbl.example")
+	if q != "2.0.0.127.dnsbl.example" {
+		t.Error("bad ipv4 dnsbl query", q)
+	}
+	q = dnsblQuery(net.ParseIP("2001:db8::1"), "dnsbl.example")
+	if q != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.dnsbl.example" {
+		t.Error("bad ipv6 dnsbl query", q)
+	}
+
+}
@@ -0,0 +1,306 @@
+//
+// api.go -- versioned json read api for boards, catalogs, threads and posts
+//
+
+package srnd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// a board in the board list of the api
+type apiBoard struct {
+	Newsgroup   string `json:"newsgroup"`
+	Pages       int64  `json:"pages"`
+	Description string `json:"description"`
+}
+
+// write a json error with a status code
+func api_v1_error(wr http.ResponseWriter, code int, err error) {
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	wr.WriteHeader(code)
+	json.NewEncoder(wr).Encode(map[string]string{"error": err.Error()})
+}
+
+// write a buffered model or 404 if nothing was rendered
+func api_v1_model(wr http.ResponseWriter, buff *bytes.Buffer, what string) {
+	if buff.Len() == 0 {
+		api_v1_error(wr, 404, errors.New("no such "+what))
+		return
+	}
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	io.Copy(wr, buff)
+}
+
+// GET /api/v1/boards
+func (self *httpFrontend) handle_api_v1_boards(wr http.ResponseWriter, r *http.Request) {
+	boards := []apiBoard{}
+	for _, group := range self.daemon.database.GetAllNewsgroups() {
+		if !self.AllowNewsgroup(group) || group == "ctl" {
+			continue
+		}
+		if banned, _ := self.daemon.database.NewsgroupBanned(group); banned {
+			continue
+		}
+		settings, _ := self.daemon.database.GetBoardSettings(group)
+		boards = append(boards, apiBoard{Newsgroup: group, Pages: self.daemon.database.GetGroupPageCount(group), Description: settings.Description})
+	}
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	json.NewEncoder(wr).Encode(boards)
+}
+
+// GET /api/v1/board/{group}/page/{n}
+func (self *httpFrontend) handle_api_v1_board(wr http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	page, err := strconv.Atoi(vars["page"])
+	if err != nil || page < 0 {
+		api_v1_error(wr, 400, errors.New("bad page number"))
+		return
+	}
+	if !self.AllowNewsgroup(group) || group == "ctl" || !self.daemon.database.HasNewsgroup(group) {
+		api_v1_error(wr, 404, errors.New("no such board"))
+		return
+	}
+	if int64(page) >= self.daemon.database.GetGroupPageCount(group) {
+		api_v1_error(wr, 404, errors.New("no such page"))
+		return
+	}
+	var buff bytes.Buffer
+	template.genBoardPage(self.attachments, self.prefix, self.name, group, page, &buff, self.daemon.database, true)
+	api_v1_model(wr, &buff, "page")
+}
+
+// GET /api/v1/catalog/{group}
+func (self *httpFrontend) handle_api_v1_catalog(wr http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	if !self.AllowNewsgroup(group) || group == "ctl" || !self.daemon.database.HasNewsgroup(group) {
+		api_v1_error(wr, 404, errors.New("no such board"))
+		return
+	}
+	var buff bytes.Buffer
+	template.genCatalog(self.prefix, self.name, group, &buff, self.daemon.database, true)
+	api_v1_model(wr, &buff, "board")
+}
+
+// GET /api/v1/thread/{msgid}
+// takes the message-id or its hash of any post in the thread
+// with ?since=<unix time> only replies posted after then are sent
+func (self *httpFrontend) handle_api_v1_thread(wr http.ResponseWriter, r *http.Request) {
+	msgid := mux.Vars(r)["msgid"]
+	if !ValidMessageID(msgid) {
+		e, err := self.daemon.database.GetMessageIDByHash(msgid)
+		if err != nil || e.MessageID() == "" {
+			api_v1_error(wr, 404, errors.New("no such thread"))
+			return
+		}
+		msgid = e.MessageID()
+	}
+	root, group, _, err := self.daemon.database.GetInfoForMessage(msgid)
+	if err != nil || group == "ctl" {
+		api_v1_error(wr, 404, errors.New("no such thread"))
+		return
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		ts, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			api_v1_error(wr, 400, errors.New("bad since"))
+			return
+		}
+		self.api_v1_thread_since(wr, root, ts)
+		return
+	}
+	var buff bytes.Buffer
+	template.genThread(self.attachments, ArticleEntry{root, group}, self.prefix, self.name, &buff, self.daemon.database, true)
+	api_v1_model(wr, &buff, "thread")
+}
+
+// send the replies to a thread posted after since
+func (self *httpFrontend) api_v1_thread_since(wr http.ResponseWriter, root string, since int64) {
+	db := self.daemon.database
+	now := timeNow()
+	posts := []PostModel{}
+	for _, p := range db.GetThreadReplyPostModelsSince(self.prefix, root, since) {
+		loadBacklinks(p, db)
+		posts = append(posts, p)
+	}
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	json.NewEncoder(wr).Encode(map[string]interface{}{"root": root, "posts": posts, "now": now})
+}
+
+// GET /api/v1/preview/{hash}
+// a single post by the short hash quotes use, for hover previews
+func (self *httpFrontend) handle_api_v1_preview(wr http.ResponseWriter, r *http.Request) {
+	e, err := self.daemon.database.GetMessageIDByShortHash(mux.Vars(r)["hash"])
+	if err != nil || e.MessageID() == "" || e.Newsgroup() == "ctl" || !self.AllowNewsgroup(e.Newsgroup()) {
+		api_v1_error(wr, 404, errors.New("no such post"))
+		return
+	}
+	p := self.daemon.database.GetPostModel(self.prefix, e.MessageID())
+	if p == nil {
+		api_v1_error(wr, 404, errors.New("no such post"))
+		return
+	}
+	loadBacklinks(p, self.daemon.database)
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	json.NewEncoder(wr).Encode(p)
+}
+
+// a file in a json post, data is base64
+type apiPostFile struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	Spoiler bool   `json:"spoiler"`
+}
+
+// a post made through the api as json
+type apiPost struct {
+	Newsgroup   string        `json:"newsgroup"`
+	Reference   string        `json:"reference"`
+	Name        string        `json:"name"`
+	Email       string        `json:"email"`
+	Subject     string        `json:"subject"`
+	Message     string        `json:"message"`
+	Files       []apiPostFile `json:"files"`
+	Dubs        bool          `json:"dubs"`
+	ProofOfWork string        `json:"pow"`
+	CaptchaID   string        `json:"captcha_id"`
+	Captcha     string        `json:"captcha"`
+	// hex ed25519 seed to sign the post with
+	SigningKey string `json:"signing_key"`
+	// lets the poster delete the post, one is made up if empty
+	Password string `json:"password"`
+	// url of a file for us to download and attach
+	AttachmentURL string `json:"attachment_url"`
+	// ids of finished uploads from /upload to attach
+	UploadIDs []string `json:"upload_ids"`
+	// make every attachment a spoiler, files can also be spoilers one by one
+	Spoiler bool `json:"spoiler"`
+	// the session's post token, only needed to post as the session, also taken in X-Post-Token
+	PostToken string `json:"post_token"`
+}
+
+// biggest post the api reads, files in json posts are base64 in it
+const apiPostMaxSize = 64 * 1024 * 1024
+
+// can posts go to newsgroup, like handle_poster checks
+func (self *httpFrontend) apiPostNewsgroup(wr http.ResponseWriter, newsgroup string) bool {
+	if !self.AllowNewsgroup(newsgroup) || !newsgroupValidFormat(newsgroup) {
+		api_v1_error(wr, 403, errors.New("bad newsgroup"))
+		return false
+	}
+	return true
+}
+
+// POST /api/v1/post
+// takes json or the same multipart form as the html form with the newsgroup in ?newsgroup=
+func (self *httpFrontend) handle_api_v1_post(wr http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(wr, r.Body, apiPostMaxSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		board := r.URL.Query().Get("newsgroup")
+		if !self.apiPostNewsgroup(wr, board) {
+			return
+		}
+		self.handle_postform(wr, r, board, true, true)
+		return
+	}
+	// a cross-site form can't send json, only text/plain, urlencoded and multipart
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		api_v1_error(wr, 415, errors.New("post json as application/json or a multipart form"))
+		return
+	}
+	var p apiPost
+	err := json.NewDecoder(r.Body).Decode(&p)
+	r.Body.Close()
+	if err != nil {
+		api_v1_error(wr, 400, err)
+		return
+	}
+	if !self.apiPostNewsgroup(wr, p.Newsgroup) {
+		return
+	}
+	if len(p.Files) > self.attachmentLimit {
+		api_v1_error(wr, 400, fmt.Errorf("too many files, at most %d", self.attachmentLimit))
+		return
+	}
+	pr := &postRequest{
+		Group:         p.Newsgroup,
+		Reference:     p.Reference,
+		Name:          p.Name,
+		Email:         p.Email,
+		Subject:       p.Subject,
+		Message:       p.Message,
+		Dubs:          p.Dubs,
+		ProofOfWork:   p.ProofOfWork,
+		Frontend:      self.name,
+		Destination:   r.Header.Get("X-I2P-DestHash"),
+		signingKey:    parseSigningKey(p.SigningKey),
+		Password:      p.Password,
+		AttachmentURL: p.AttachmentURL,
+		UploadIDs:     p.UploadIDs,
+		Spoiler:       p.Spoiler,
+	}
+	pr.IpAddress, err = extractRealIP(r)
+	if err != nil {
+		api_v1_error(wr, 400, err)
+		return
+	}
+	if self.attachments {
+		for _, f := range p.Files {
+			_, err = base64.StdEncoding.DecodeString(f.Data)
+			if err != nil {
+				api_v1_error(wr, 400, errors.New("bad file data for "+f.Name))
+				return
+			}
+			// attachments are kept base64 until the article is made
+			pr.Attachments = append(pr.Attachments, postAttachment{Filename: f.Name, Filetype: f.Type, Filedata: f.Data, Spoiler: f.Spoiler})
+		}
+	}
+	sess, _ := self.store.Get(r, self.name)
+	token := p.PostToken
+	if token == "" {
+		token = r.Header.Get("X-Post-Token")
+	}
+	// the session's cookies only count for posts from our pages, others post as nobody
+	asSession := self.checkPostOrigin(r, sess, token, "") == nil
+	if asSession {
+		pr.modExempt = self.modui.CheckSession(r, "mod-"+pr.Group)
+	} else {
+		sess = sessions.NewSession(self.store, self.name)
+	}
+	ok := self.checkPostCaptcha(sess, pr, p.CaptchaID, p.Captcha)
+	if asSession {
+		sess.Save(r, wr)
+	}
+	if !ok {
+		api_v1_error(wr, 403, errors.New("bad captcha"))
+		return
+	}
+	b := func() {
+		wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+		wr.WriteHeader(403)
+		json.NewEncoder(wr).Encode(map[string]interface{}{"error": "banned", "appeal_url": self.prefix + "appeal"})
+	}
+	e := func(err error) {
+		api_v1_error(wr, 400, err)
+	}
+	s := func(nntp NNTPMessage) {
+		root := nntp.Headers().Get("References", nntp.MessageID())
+		wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+		wr.WriteHeader(201)
+		json.NewEncoder(wr).Encode(map[string]interface{}{"message_id": nntp.MessageID(), "url": postAnchorURL(self.prefix, nntp.MessageID(), root), "delete_password": pr.Password})
+	}
+	self.handle_postRequest(pr, b, e, s, self.enableBoardCreation)
+}
@@ -0,0 +1,95 @@
+//
+// appeal.go -- banned posters asking the mods to lift their ban
+//
+
+package srnd
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const BanAppealPending = "pending"
+const BanAppealUpheld = "upheld"
+
+// longest appeal text we store
+const maxBanAppealText = 2048
+
+var BanAppealExists = errors.New("you already appealed this ban")
+
+// show the appeal form to a banned poster and handle its submission
+func (self *httpFrontend) handle_appeal(wr http.ResponseWriter, r *http.Request) {
+	param := map[string]interface{}{
+		"prefix":       self.prefix,
+		"redirect_url": self.prefix,
+		"i18n":         i18nForRequest(r, ""),
+	}
+	fail := func(code int, reason string) {
+		wr.WriteHeader(code)
+		param["reason"] = reason
+		template.writeTemplate("post_fail.mustache", param, wr)
+	}
+	addr, err := extractRealIP(r)
+	if err != nil || strings.HasPrefix(addr, "127.") {
+		// tor and i2p posters have no address to unban
+		fail(400, "cannot tell who you are")
+		return
+	}
+	encaddr, err := self.daemon.database.GetEncAddress(addr)
+	if err != nil {
+		fail(500, err.Error())
+		return
+	}
+	banned, err := self.daemon.database.CheckIPBanned(addr)
+	if err == nil && !banned {
+		banned, err = self.daemon.database.CheckEncIPBanned(encaddr)
+	}
+	if err != nil {
+		fail(500, err.Error())
+		return
+	}
+	appeal, err := self.daemon.database.GetBanAppeal(encaddr)
+	if err != nil {
+		fail(500, err.Error())
+		return
+	}
+	if !banned {
+		if appeal != nil {
+			// the ban it was for is gone, a new ban gets a new appeal
+			self.daemon.database.DeleteBanAppeal(encaddr)
+		}
+		fail(400, "you are not banned")
+		return
+	}
+	param["appeal"] = appeal
+	if r.Method != "POST" {
+		template.writeTemplate("appeal.mustache", param, wr)
+		return
+	}
+	if appeal != nil {
+		fail(409, BanAppealExists.Error())
+		return
+	}
+	text := strings.TrimSpace(r.FormValue("appeal"))
+	if len(text) == 0 {
+		fail(400, "no appeal given")
+		return
+	}
+	if len(text) > maxBanAppealText {
+		fail(400, "appeal too long")
+		return
+	}
+	err = self.daemon.database.AddBanAppeal(encaddr, text)
+	if err == BanAppealExists {
+		fail(409, err.Error())
+		return
+	} else if err != nil {
+		fail(500, err.Error())
+		return
+	}
+	log.Println("ban appeal from", encaddr)
+	wr.WriteHeader(201)
+	template.writeTemplate("post_success.mustache", param, wr)
+}
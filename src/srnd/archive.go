@@ -0,0 +1,177 @@
+//
+// archive.go -- static html archive of expired threads
+//
+
+package srnd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// a thread in the archive index of a board
+type archivedThread struct {
+	Hash    string `json:"hash"`
+	Subject string `json:"subject"`
+	// when the thread was started, formatted
+	Date     string `json:"date"`
+	Archived int64  `json:"archived"`
+	Replies  int    `json:"replies"`
+	URL      string `json:"url"`
+}
+
+// renders threads to static html before they expire
+type threadArchiver struct {
+	// where archived threads go, served under prefix/archive/
+	dir         string
+	prefix      string
+	frontend    string
+	attachments bool
+	database    Database
+	store       ArticleStore
+	// guards the board indexes
+	access sync.Mutex
+}
+
+// the directory a board's archive goes in
+func (self *threadArchiver) boardDir(group string) string {
+	return filepath.Join(self.dir, group)
+}
+
+// point links at the archive instead of pages and thumbnails that go away with the thread
+func (self *threadArchiver) rewriteLinks(markup, group string) string {
+	return strings.NewReplacer(
+		self.prefix+"thm/", self.prefix+"archive/thm/",
+		self.prefix+"thread-", self.prefix+"archive/"+group+"/thread-",
+	).Replace(markup)
+}
+
+// render a thread to the archive, does nothing if nil
+func (self *threadArchiver) Archive(root string) {
+	if self == nil {
+		return
+	}
+	_, group, _, err := self.database.GetInfoForMessage(root)
+	if err != nil || group == "ctl" || !newsgroupValidFormat(group) {
+		return
+	}
+	var buff bytes.Buffer
+	th := template.genArchivedThread(self.attachments, root, group, self.prefix, self.frontend, &buff, self.database)
+	if th == nil {
+		log.Println("cannot archive thread", root, "in", group)
+		return
+	}
+	EnsureDir(self.boardDir(group))
+	EnsureDir(filepath.Join(self.dir, "thm"))
+	hash := HashMessageID(root)
+	err = ioutil.WriteFile(filepath.Join(self.boardDir(group), "thread-"+hash+".html"), []byte(self.rewriteLinks(buff.String(), group)), 0644)
+	if err != nil {
+		log.Println("failed to archive thread", root, err)
+		return
+	}
+	// keep the thumbnails, full size attachments are gone for good
+	posts := append([]PostModel{th.OP()}, th.Replies()...)
+	for _, p := range posts {
+		for _, att := range self.database.GetPostAttachments(p.MessageID()) {
+			thm := self.store.ThumbnailFilepath(att)
+			err = copyFile(thm, filepath.Join(self.dir, "thm", filepath.Base(thm)))
+			if err != nil {
+				log.Println("failed to archive thumbnail", thm, err)
+			}
+		}
+	}
+	op := th.OP()
+	self.addToIndex(group, archivedThread{
+		Hash:     hash,
+		Subject:  op.Subject(),
+		Date:     op.Date(),
+		Archived: timeNow(),
+		Replies:  len(th.Replies()),
+		URL:      self.prefix + "archive/" + group + "/thread-" + hash + ".html",
+	})
+	log.Println("archived thread", root, "in", group)
+}
+
+// put a thread into a board's archive index and render it
+func (self *threadArchiver) addToIndex(group string, entry archivedThread) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	// newest first
+	threads := []archivedThread{entry}
+	fname := filepath.Join(self.boardDir(group), "index.json")
+	data, err := ioutil.ReadFile(fname)
+	if err == nil {
+		var older []archivedThread
+		err = json.Unmarshal(data, &older)
+		if err != nil {
+			log.Println("bad archive index for", group, err)
+		}
+		for _, t := range older {
+			if t.Hash != entry.Hash {
+				threads = append(threads, t)
+			}
+		}
+	}
+	data, err = json.Marshal(threads)
+	if err == nil {
+		err = ioutil.WriteFile(fname, data, 0644)
+	}
+	if err != nil {
+		log.Println("failed to write archive index for", group, err)
+		return
+	}
+	var buff bytes.Buffer
+	template.genArchiveIndex(self.prefix, self.frontend, group, threads, &buff)
+	err = ioutil.WriteFile(filepath.Join(self.boardDir(group), "index.html"), buff.Bytes(), 0644)
+	if err != nil {
+		log.Println("failed to write archive index for", group, err)
+	}
+}
+
+// copy a file
+func copyFile(src, dst string) (err error) {
+	var in, out *os.File
+	in, err = os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err = os.Create(dst)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(out, in)
+	out.Close()
+	if err != nil {
+		DelFile(dst)
+	}
+	return
+}
+
+// create the archiver from the frontend config, nil if we don't archive
+func threadArchiverFromConfig(conf map[string]string, db Database, store ArticleStore) *threadArchiver {
+	if conf["enable"] != "1" || conf["static_archive"] != "1" {
+		return nil
+	}
+	dir := conf["static_archive_dir"]
+	if dir == "" {
+		dir = filepath.Join(conf["webroot"], "archive")
+	}
+	EnsureDir(dir)
+	log.Println("archiving expired threads to", dir)
+	return &threadArchiver{
+		dir:         dir,
+		prefix:      conf["prefix"],
+		frontend:    conf["name"],
+		attachments: mapGetInt(conf, "allow_files", 1) == 1,
+		database:    db,
+		store:       store,
+	}
+}
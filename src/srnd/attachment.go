@@ -9,6 +9,7 @@ import (
 	"crypto/sha512"
 	"encoding/base32"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
 	"log"
@@ -74,9 +75,10 @@ func (self *nntpAttachment) Reset() {
 
 func (self *nntpAttachment) ToModel(prefix string) AttachmentModel {
 	return &attachment{
-		prefix: prefix,
-		Path:   self.Filepath(),
-		Name:   self.Filename(),
+		prefix:  prefix,
+		Path:    self.Filepath(),
+		Name:    self.Filename(),
+		Spoiled: attachmentSpoiled(self),
 	}
 }
 
@@ -171,6 +173,60 @@ func (self *nntpAttachment) Header() textproto.MIMEHeader {
 	return self.header
 }
 
+// a kind of file browsers play
+type mediaPlayer struct {
+	// audio or video, the element it plays in
+	element string
+	mime    string
+}
+
+// extensions of files browsers play, mime.TypeByExtension depends on the system having these
+var mediaPlayers = map[string]mediaPlayer{
+	".mp3":  {"audio", "audio/mpeg"},
+	".ogg":  {"audio", "audio/ogg"},
+	".oga":  {"audio", "audio/ogg"},
+	".opus": {"audio", "audio/ogg"},
+	".flac": {"audio", "audio/flac"},
+	".wav":  {"audio", "audio/wav"},
+	".m4a":  {"audio", "audio/mp4"},
+	".webm": {"video", "video/webm"},
+	".mp4":  {"video", "video/mp4"},
+	".ogv":  {"video", "video/ogg"},
+}
+
+// how browsers can play the file inline, an empty element if they can't
+func mediaPlayerFor(fname string) mediaPlayer {
+	return mediaPlayers[strings.ToLower(filepath.Ext(fname))]
+}
+
+// mime part header marking an attachment as a spoiler, it goes out with the article so other nodes hide it too
+const spoilerHeader = "X-Spoiler"
+
+// did the poster mark this attachment as a spoiler?
+// the hex of the sha512 an attachment file is named after, false if the name isn't one of ours
+func attachmentFileHash(fname string) (string, bool) {
+	if idx := strings.Index(fname, "."); idx > 0 {
+		fname = fname[:idx]
+	}
+	h, err := base32.StdEncoding.DecodeString(fname)
+	if err != nil || len(h) != sha512.Size {
+		return "", false
+	}
+	return hex.EncodeToString(h), true
+}
+
+func attachmentSpoiled(att NNTPAttachment) bool {
+	hdr := att.Header()
+	return hdr != nil && hdr.Get(spoilerHeader) == "1"
+}
+
+// mark an attachment as a spoiler
+func spoilAttachment(att NNTPAttachment) {
+	if hdr := att.Header(); hdr != nil {
+		hdr.Set(spoilerHeader, "1")
+	}
+}
+
 // create a plaintext attachment
 func createPlaintextAttachment(msg []byte) NNTPAttachment {
 	header := make(textproto.MIMEHeader)
@@ -274,6 +330,12 @@ func readAttachmentFromMimePartAndStore(part *multipart.Part, store ArticleStore
 	if store == nil {
 		return att
 	}
+	if store.FileBanned(att.hash) {
+		// never put banned files into the store
+		log.Println("not storing banned attachment", att.filename)
+		DelFile(fpath)
+		return att
+	}
 	att_fpath := filepath.Join(store.AttachmentDir(), att.filepath)
 	if !CheckFile(att_fpath) {
 		// attachment isn't there
@@ -0,0 +1,49 @@
+package srnd
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestSpoilAttachment(t *testing.T) {
+
+	att := createAttachment("text/plain", "spoilers.txt", strings.NewReader("aGVsbG8="))
+	if attachmentSpoiled(att) {
+		t.Error("attachments should not start as spoilers")
+	}
+	spoilAttachment(att)
+	if !attachmentSpoiled(att) || !att.ToModel("/").Spoiler() {
+		t.Error("spoiled attachment should be a spoiler", att.Header())
+	}
+
+}
+
+func TestMediaPlayerFor(t *testing.T) {
+
+	if p := mediaPlayerFor("ABCD.WEBM"); p.element != "video" || p.mime != "video/webm" {
+		t.Error("webm should play in a video", p)
+	}
+	if p := mediaPlayerFor("song.opus"); p.element != "audio" {
+		t.Error("opus should play in an audio", p)
+	}
+	if p := mediaPlayerFor("image.png"); p.element != "" {
+		t.Error("images are not played", p)
+	}
+
+}
+
+func TestAttachmentFileHash(t *testing.T) {
+
+	att := createAttachment("text/plain", "a.txt", strings.NewReader("aGVsbG8="))
+	hash, ok := attachmentFileHash(att.Filepath())
+	if !ok || hash != hex.EncodeToString(att.Hash()) {
+		t.Errorf("hash of %s is %q, want %x", att.Filepath(), hash, att.Hash())
+	}
+	for _, fname := range []string{"index.html", "", ".jpg", "AAAA.png"} {
+		if _, ok := attachmentFileHash(fname); ok {
+			t.Errorf("%q is not an attachment file", fname)
+		}
+	}
+
+}
@@ -0,0 +1,220 @@
+//
+// backup.go -- snapshotting the database and spool to a directory and loading it back
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// databases that can write all they have to a file and load it back
+type databaseExporter interface {
+	Export(fname string) error
+	Import(fname string) error
+}
+
+// name of the database dump and the manifest in a backup directory
+const backupDumpFile = "database.dump"
+const backupManifestFile = "backup.json"
+
+// what is in a backup, written last so a backup without one is not complete
+type backupManifest struct {
+	Made     int64            `json:"made"`
+	Database string           `json:"database"`
+	Files    map[string]int   `json:"files"`
+	Bytes    map[string]int64 `json:"bytes"`
+}
+
+// the directories a backup holds, by the name they have in it
+func backupDirs(conf *SRNdConfig) map[string]string {
+	return map[string]string{
+		"articles":    conf.store["store_dir"],
+		"attachments": conf.store["attachments_dir"],
+		"thumbs":      conf.store["thumbs_dir"],
+	}
+}
+
+// hardlink src to dst, copy it if we can't or were told to
+func linkOrCopy(src, dst string, copyFiles bool) error {
+	if !copyFiles && os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// put every file under src into dst, keeping subdirectories
+// files removed while we walk are left out, articles expire while the daemon runs
+func mirrorDir(src, dst string, copyFiles bool) (files int, bytes int64, err error) {
+	err = os.MkdirAll(dst, 0755)
+	if err != nil {
+		return
+	}
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		err = linkOrCopy(path, target, copyFiles)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	return
+}
+
+// true if dir has nothing in it or isn't there
+func dirEmpty(dir string) bool {
+	names, err := ioutil.ReadDir(dir)
+	return err != nil || len(names) == 0
+}
+
+// snapshot the database and the article, attachment and thumbnail files into a new directory
+// usage: backup [-copy] dir
+// the database is dumped before the files, so anything that comes in meanwhile has its file
+// in the backup but not its row, and restore puts those back by reindexing
+// files are hardlinked so it takes no extra space on the same filesystem, -copy always copies them
+func BackupTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	copyFiles := flags.Bool("copy", false, "copy files even when they can be hardlinked")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: backup [-copy] dir")
+	}
+	dir := flags.Arg(0)
+	if !dirEmpty(dir) {
+		return errors.New(dir + " is not empty")
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	exp, ok := db.(databaseExporter)
+	if !ok {
+		return errors.New("cannot back up a " + conf.database["type"] + " database")
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+	manifest := backupManifest{
+		Made:     time.Now().Unix(),
+		Database: conf.database["type"],
+		Files:    make(map[string]int),
+		Bytes:    make(map[string]int64),
+	}
+	fmt.Fprintln(w, "dumping the", manifest.Database, "database")
+	err = exp.Export(filepath.Join(dir, backupDumpFile))
+	if err != nil {
+		return
+	}
+	for name, src := range backupDirs(conf) {
+		var files int
+		var bytes int64
+		files, bytes, err = mirrorDir(src, filepath.Join(dir, name), *copyFiles)
+		if err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+		manifest.Files[name] = files
+		manifest.Bytes[name] = bytes
+		fmt.Fprintf(w, "%s: %d files, %s\n", name, files, formatBytes(uint64(bytes)))
+	}
+	var data []byte
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, backupManifestFile), data, 0600)
+	}
+	if err == nil {
+		fmt.Fprintln(w, "backup done in", dir)
+	}
+	return
+}
+
+// load a backup made by BackupTool, the daemon should not be running
+// usage: restore [-copy] [-force] dir
+// refuses to put it over a spool that has articles in it unless -force is given
+func RestoreTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	copyFiles := flags.Bool("copy", false, "copy files even when they can be hardlinked")
+	force := flags.Bool("force", false, "restore even if store_dir has articles in it")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: restore [-copy] [-force] dir")
+	}
+	dir := flags.Arg(0)
+	var manifest backupManifest
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if os.IsNotExist(err) {
+		return errors.New(dir + " has no " + backupManifestFile + ", it is not a complete backup")
+	} else if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return errors.New(backupManifestFile + ": " + err.Error())
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	if manifest.Database != conf.database["type"] {
+		return errors.New("backup is of a " + manifest.Database + " database but srnd.ini uses " + conf.database["type"])
+	}
+	dirs := backupDirs(conf)
+	if !*force && !dirEmpty(dirs["articles"]) {
+		return errors.New(dirs["articles"] + " has articles in it, use -force to restore over them")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	exp, ok := db.(databaseExporter)
+	if !ok {
+		return errors.New("cannot restore a " + conf.database["type"] + " database")
+	}
+	fmt.Fprintln(w, "restoring backup made", time.Unix(manifest.Made, 0).UTC().Format(time.RFC3339))
+	err = exp.Import(filepath.Join(dir, backupDumpFile))
+	if err != nil {
+		return
+	}
+	// the backup may be from before the last schema change
+	db.CreateTables()
+	for name, dst := range dirs {
+		var files int
+		var bytes int64
+		files, bytes, err = mirrorDir(filepath.Join(dir, name), dst, *copyFiles)
+		if err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+		fmt.Fprintf(w, "%s: %d files, %s\n", name, files, formatBytes(uint64(bytes)))
+	}
+	fmt.Fprintln(w, "reindexing articles that came in while the backup was made")
+	ReindexTool()
+	return
+}
@@ -0,0 +1,37 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMirrorDir(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "a"), []byte("aaa"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "sub", "b"), []byte("bb"), 0600)
+	for _, copyFiles := range []bool{false, true} {
+		dst := filepath.Join(dir, "dst"+strconv.FormatBool(copyFiles))
+		files, bytes, err := mirrorDir(src, dst, copyFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files != 2 || bytes != 5 {
+			t.Errorf("mirrored %d files of %d bytes, want 2 of 5", files, bytes)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dst, "sub", "b"))
+		if string(data) != "bb" {
+			t.Errorf("sub/b is %q", data)
+		}
+	}
+	if !dirEmpty(filepath.Join(dir, "missing")) || dirEmpty(src) {
+		t.Error("dirEmpty is wrong")
+	}
+
+}
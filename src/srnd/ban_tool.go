@@ -0,0 +1,239 @@
+//
+// ban_tool.go -- adding, lifting and listing bans from the shell
+//
+
+package srnd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// what srnd ban can ban
+var banKinds = []string{"ip", "cidr", "encaddr", "article", "file", "newsgroup"}
+
+func validBanKind(kind string) bool {
+	for _, k := range banKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// check a value can be banned as kind, cleaned up the way the database keeps it
+func banValue(kind, value string) (string, error) {
+	switch kind {
+	case "ip":
+		if net.ParseIP(value) == nil {
+			return "", errors.New("bad ip address " + value)
+		}
+	case "cidr":
+		_, ipnet, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", errors.New("bad cidr " + value)
+		}
+		value = ipnet.String()
+	case "encaddr":
+		if !validBanlistEntry(BanlistEncAddr, value) {
+			return "", errors.New("bad encrypted address " + value)
+		}
+	case "article":
+		if !ValidMessageID(value) {
+			return "", errors.New("bad message-id " + value)
+		}
+	case "file":
+		value = strings.ToLower(value)
+		if !validBanlistEntry(BanlistFile, value) {
+			return "", errors.New("bad sha512 " + value)
+		}
+	case "newsgroup":
+		if !newsgroupValidFormat(value) {
+			return "", errors.New("bad newsgroup " + value)
+		}
+	default:
+		return "", errors.New("no such kind of ban " + kind + ", one of " + strings.Join(banKinds, " "))
+	}
+	return value, nil
+}
+
+func banAdd(db Database, kind, value string) (err error) {
+	var banned bool
+	switch kind {
+	case "ip", "cidr":
+		banned, err = db.CheckIPBanned(value)
+		if err == nil && !banned {
+			err = db.BanAddr(value)
+		}
+	case "encaddr":
+		banned, err = db.CheckEncIPBanned(value)
+		if err == nil && !banned {
+			err = db.BanEncAddr(value)
+		}
+	case "article":
+		err = db.BanArticle(value, "banned by operator")
+	case "file":
+		err = db.BanAttachment(value)
+	case "newsgroup":
+		banned, err = db.NewsgroupBanned(value)
+		if err == nil && !banned {
+			err = db.BanNewsgroup(value)
+		}
+	}
+	return
+}
+
+func banRemove(db Database, kind, value string) (err error) {
+	switch kind {
+	case "ip", "cidr":
+		err = db.UnbanCIDR(value)
+	case "encaddr":
+		err = db.UnbanEncAddr(value)
+	case "article":
+		err = db.UnbanArticle(value)
+	case "file":
+		err = db.UnbanAttachment(value)
+	case "newsgroup":
+		err = db.UnbanNewsgroup(value)
+	}
+	return
+}
+
+// when an address ban runs out, for people
+func banExpires(b AddrBan) string {
+	if b.Expires < 0 {
+		return "never"
+	}
+	return time.Unix(b.Expires, 0).UTC().Format(time.RFC3339)
+}
+
+// print every ban of a kind, or of every kind
+func banList(w io.Writer, db Database, kind string) (err error) {
+	if kind == "" || kind == "ip" || kind == "cidr" {
+		var bans []AddrBan
+		bans, err = db.GetAddrBans()
+		if err != nil {
+			return
+		}
+		for _, b := range bans {
+			k := "ip"
+			if isnet, _ := IsSubnet(b.Addr); isnet {
+				k = "cidr"
+			}
+			if kind == "" || kind == k {
+				fmt.Fprintf(w, "%s %s made %s expires %s\n", k, b.Addr, time.Unix(b.Made, 0).UTC().Format(time.RFC3339), banExpires(b))
+			}
+		}
+	}
+	if kind == "" || kind == "encaddr" {
+		var bans []AddrBan
+		bans, err = db.GetEncAddrBans()
+		if err != nil {
+			return
+		}
+		for _, b := range bans {
+			fmt.Fprintf(w, "encaddr %s made %s expires %s\n", b.Addr, time.Unix(b.Made, 0).UTC().Format(time.RFC3339), banExpires(b))
+		}
+	}
+	if kind == "" || kind == "article" {
+		var banned map[string]string
+		banned, err = db.GetBannedArticles()
+		if err != nil {
+			return
+		}
+		msgids := make([]string, 0, len(banned))
+		for msgid := range banned {
+			msgids = append(msgids, msgid)
+		}
+		sort.Strings(msgids)
+		for _, msgid := range msgids {
+			fmt.Fprintf(w, "article %s %s\n", msgid, banned[msgid])
+		}
+	}
+	if kind == "" || kind == "file" {
+		var hashes []string
+		hashes, err = db.GetBannedAttachments()
+		if err != nil {
+			return
+		}
+		for _, hash := range hashes {
+			fmt.Fprintf(w, "file %s\n", hash)
+		}
+	}
+	if kind == "" || kind == "newsgroup" {
+		var groups []string
+		groups, err = db.GetBannedNewsgroups()
+		if err != nil {
+			return
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			fmt.Fprintf(w, "newsgroup %s\n", group)
+		}
+	}
+	return
+}
+
+// add, lift or list bans
+// usage: add|rm kind value ... or list [kind], kind is one of banKinds
+func BanTool(w io.Writer, args []string) (err error) {
+	usage := errors.New("usage: ban add|rm " + strings.Join(banKinds, "|") + " value ... or ban list [kind]")
+	if len(args) == 0 {
+		return usage
+	}
+	action := args[0]
+	if action == "list" {
+		if len(args) > 2 {
+			return usage
+		}
+		kind := ""
+		if len(args) == 2 {
+			kind = args[1]
+			if !validBanKind(kind) {
+				return usage
+			}
+		}
+		conf := ReadConfig()
+		if conf == nil {
+			return errors.New("cannot load config")
+		}
+		db := toolDatabase(conf)
+		defer db.Close()
+		return banList(w, db, kind)
+	}
+	if (action != "add" && action != "rm") || len(args) < 3 {
+		return usage
+	}
+	kind := args[1]
+	var values []string
+	for _, v := range args[2:] {
+		v, err = banValue(kind, v)
+		if err != nil {
+			return
+		}
+		values = append(values, v)
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	for _, v := range values {
+		if action == "add" {
+			err = banAdd(db, kind, v)
+		} else {
+			err = banRemove(db, kind, v)
+		}
+		if err != nil {
+			return errors.New(kind + " " + v + ": " + err.Error())
+		}
+		fmt.Fprintln(w, action, kind, v)
+	}
+	return
+}
@@ -0,0 +1,22 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestBanValue(t *testing.T) {
+
+	if v, err := banValue("cidr", "10.1.2.3/16"); err != nil || v != "10.1.0.0/16" {
+		t.Error("bad cidr", v, err)
+	}
+	if _, err := banValue("ip", "10.1.2.3/16"); err == nil {
+		t.Error("a cidr is not an ip")
+	}
+	if _, err := banValue("article", "nope"); err == nil {
+		t.Error("bad message-ids should not be banned")
+	}
+	if _, err := banValue("pubkey", "aa"); err == nil {
+		t.Error("unknown kinds should fail")
+	}
+
+}
@@ -0,0 +1,187 @@
+//
+// banner.go -- rotating per board banners kept in the webroot
+//
+
+package srnd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// banners for boards without their own go in webroot/banners/default
+const defaultBanners = "default"
+
+// biggest banner an admin can upload
+const bannerMaxSize = 1024 * 1024
+
+// file extensions banners can have
+var bannerExts = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true}
+
+// a banner in the mod ui
+type bannerFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// return true if name can be a banner file in a banner dir
+func validBannerName(name string) bool {
+	return len(name) > 0 && filepath.Base(name) == name && !strings.HasPrefix(name, ".") && bannerExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// return true if group can have banners
+func validBannerGroup(group string) bool {
+	return group == defaultBanners || newsgroupValidFormat(group)
+}
+
+// the directory banners for a newsgroup are kept in
+func bannerDir(webroot, group string) string {
+	return filepath.Join(webroot, "banners", group)
+}
+
+// names of the banners a newsgroup has of its own
+func listBanners(webroot, group string) (banners []string) {
+	files, err := ioutil.ReadDir(bannerDir(webroot, group))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !f.IsDir() && validBannerName(f.Name()) {
+			banners = append(banners, f.Name())
+		}
+	}
+	sort.Strings(banners)
+	return
+}
+
+// GET /banner/{newsgroup}
+// redirect to a random banner for the newsgroup, pages use this as their banner image
+func (self *httpFrontend) handle_banner(wr http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["newsgroup"]
+	if !validBannerGroup(group) {
+		wr.WriteHeader(404)
+		return
+	}
+	banners := listBanners(self.webroot_dir, group)
+	if len(banners) == 0 {
+		group = defaultBanners
+		banners = listBanners(self.webroot_dir, group)
+	}
+	if len(banners) == 0 {
+		wr.WriteHeader(404)
+		return
+	}
+	wr.Header().Set("Cache-Control", "no-store")
+	http.Redirect(wr, r, self.prefix+"banners/"+group+"/"+banners[rand.Intn(len(banners))], http.StatusFound)
+}
+
+// save an uploaded banner under a name from its contents, returns the name
+func saveBanner(webroot, group string, data []byte) (name string, err error) {
+	if len(data) > bannerMaxSize {
+		return "", errors.New("banner is too big")
+	}
+	ctype := http.DetectContentType(data)
+	if !strings.HasPrefix(ctype, "image/") {
+		return "", errors.New("banner is not an image")
+	}
+	ext := "." + strings.TrimPrefix(ctype, "image/")
+	if !bannerExts[ext] {
+		return "", errors.New("banners can't be " + ctype)
+	}
+	h := sha256.Sum256(data)
+	name = hexify(h[:8]) + ext
+	dir := bannerDir(webroot, group)
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	return
+}
+
+// the banners of a newsgroup with their urls
+func (self httpModUI) banners(group string) (banners []bannerFile) {
+	for _, name := range listBanners(self.daemon.conf.frontend["webroot"], group) {
+		banners = append(banners, bannerFile{Name: name, URL: self.prefix + "banners/" + group + "/" + name})
+	}
+	return
+}
+
+// GET /mod/banners/{newsgroup}
+func (self httpModUI) ServeModBanners(wr http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["newsgroup"]
+	self.serveAuthedPage(wr, r, "admin", "modbanners.mustache", func() map[string]interface{} {
+		param := map[string]interface{}{"newsgroup": group}
+		if !validBannerGroup(group) {
+			param["error"] = "no such board"
+		} else {
+			param["banners"] = self.banners(group)
+		}
+		return param
+	})
+}
+
+// POST /mod/banners/{newsgroup} with the image in banner
+func (self httpModUI) HandleBannerUpload(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("admin", func(path string) {
+		group := mux.Vars(r)["newsgroup"]
+		resp := make(map[string]interface{})
+		var err error
+		var data []byte
+		if !validBannerGroup(group) {
+			err = errors.New("no such board")
+		}
+		if err == nil {
+			r.Body = http.MaxBytesReader(wr, r.Body, bannerMaxSize*2)
+			f, _, ferr := r.FormFile("banner")
+			err = ferr
+			if err == nil {
+				data, err = ioutil.ReadAll(f)
+				f.Close()
+			}
+		}
+		var name string
+		if err == nil {
+			name, err = saveBanner(self.daemon.conf.frontend["webroot"], group, data)
+		}
+		if err == nil {
+			log.Println("banner", name, "added to", group)
+			resp["banners"] = self.banners(group)
+		} else {
+			resp["error"] = err.Error()
+		}
+		json.NewEncoder(wr).Encode(resp)
+	}, wr, r)
+}
+
+// GET /mod/banners/{newsgroup}/del/{name}
+func (self httpModUI) HandleBannerDelete(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("admin", func(path string) {
+		vars := mux.Vars(r)
+		group := vars["newsgroup"]
+		name := vars["name"]
+		resp := make(map[string]interface{})
+		var err error
+		if !validBannerGroup(group) || !validBannerName(name) {
+			err = errors.New("no such banner")
+		} else {
+			err = os.Remove(filepath.Join(bannerDir(self.daemon.conf.frontend["webroot"], group), name))
+		}
+		if err == nil {
+			log.Println("banner", name, "removed from", group)
+			resp["banners"] = self.banners(group)
+		} else {
+			resp["error"] = err.Error()
+		}
+		json.NewEncoder(wr).Encode(resp)
+	}, wr, r)
+}
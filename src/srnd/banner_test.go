@@ -0,0 +1,18 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestValidBannerName(t *testing.T) {
+
+	if !validBannerName("abc.PNG") {
+		t.Error("png banners should be valid")
+	}
+	for _, name := range []string{"../abc.png", ".png", "abc.html", ""} {
+		if validBannerName(name) {
+			t.Error("banner name should not be valid", name)
+		}
+	}
+
+}
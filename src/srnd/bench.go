@@ -0,0 +1,183 @@
+//
+// bench.go -- measuring how fast the configured store and database are, for capacity planning
+//
+
+package srnd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// how long a phase of the benchmark took for how many operations and bytes
+type benchResult struct {
+	Name  string
+	Ops   int
+	Bytes int64
+	Took  time.Duration
+}
+
+func (self benchResult) String() string {
+	secs := self.Took.Seconds()
+	if secs <= 0 {
+		secs = 1e-9
+	}
+	line := fmt.Sprintf("%-18s %6d ops in %-12s %10.1f ops/s", self.Name, self.Ops, self.Took.Round(time.Millisecond), float64(self.Ops)/secs)
+	if self.Bytes > 0 {
+		line += fmt.Sprintf(" %10s/s", formatBytes(uint64(float64(self.Bytes)/secs)))
+	}
+	return line
+}
+
+// time f over n operations
+func benchRun(name string, n int, f func(i int) (int64, error)) (res benchResult, err error) {
+	res.Name = name
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		var written int64
+		written, err = f(i)
+		if err != nil {
+			return
+		}
+		res.Bytes += written
+		res.Ops++
+	}
+	res.Took = time.Since(start)
+	return
+}
+
+// make a synthetic article, a thread of threadSize posts per root, with an attachment of attSize random bytes
+func benchArticle(idx, threadSize, attSize int, group, instance string, roots []string) (NNTPMessage, error) {
+	msgid := genMessageID(instance)
+	nntp := newPlaintextArticle(fmt.Sprintf("benchmark post %d\n\nlorem ipsum dolor sit amet", idx), "bench@"+instance, fmt.Sprintf("bench %d", idx), "bench", instance, msgid, group)
+	if idx%threadSize != 0 {
+		nntp.Headers().Set("References", roots[idx/threadSize])
+	}
+	if attSize > 0 {
+		data := make([]byte, attSize)
+		_, err := io.ReadFull(rand.Reader, data)
+		if err != nil {
+			return nil, err
+		}
+		att := createAttachment("application/octet-stream", fmt.Sprintf("bench-%d.bin", idx), bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(data))))
+		if att == nil {
+			return nil, errors.New("cannot make attachment")
+		}
+		nntp.Attach(att)
+	}
+	nntp.Pack()
+	return nntp, nil
+}
+
+// write synthetic articles with attachments into a newsgroup of their own and time the store and database
+// usage: [-n articles] [-attachment bytes] [-thread posts] [-group newsgroup] [-keep]
+// everything it made is deleted afterwards unless -keep is given
+func BenchTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := flags.Int("n", 1000, "articles to make")
+	attSize := flags.Int("attachment", 64*1024, "bytes of the attachment of each article, 0 for none")
+	threadSize := flags.Int("thread", 10, "posts per thread")
+	group := flags.String("group", "overchan.srnd.bench", "newsgroup the articles go in, it should not be used for anything else")
+	keep := flags.Bool("keep", false, "keep the articles instead of deleting them afterwards")
+	flags.Parse(args)
+	if *n < 1 || *threadSize < 1 || *attSize < 0 || !newsgroupValidFormat(*group) {
+		return errors.New("usage: bench [-n articles] [-attachment bytes] [-thread posts] [-group newsgroup] [-keep]")
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	instance := conf.daemon["instance_name"]
+	db := toolDatabase(conf)
+	defer db.Close()
+	db.CreateTables()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+
+	fmt.Fprintf(w, "making %d articles with %s attachments in %s\n", *n, formatBytes(uint64(*attSize)), *group)
+	articles := make([]NNTPMessage, 0, *n)
+	var roots []string
+	for i := 0; i < *n; i++ {
+		var nntp NNTPMessage
+		nntp, err = benchArticle(i, *threadSize, *attSize, *group, instance, roots)
+		if err != nil {
+			return
+		}
+		if i%*threadSize == 0 {
+			roots = append(roots, nntp.MessageID())
+		}
+		articles = append(articles, nntp)
+	}
+	if !*keep {
+		defer func() {
+			for _, nntp := range articles {
+				for _, att := range nntp.Attachments() {
+					DelFile(store.AttachmentFilepath(att.Filepath()))
+				}
+				db.DeleteArticle(nntp.MessageID())
+				DelFile(store.GetFilename(nntp.MessageID()))
+			}
+			for _, root := range roots {
+				db.DeleteThread(root)
+			}
+			fmt.Fprintln(w, "deleted the benchmark articles")
+		}()
+	}
+
+	perpage := 10
+	pages := (len(roots) + perpage - 1) / perpage
+	phases := []struct {
+		name string
+		f    func(i int) (int64, error)
+	}{
+		{"store write", func(i int) (int64, error) {
+			nntp := articles[i]
+			for _, att := range nntp.Attachments() {
+				if e := att.Save(store.AttachmentDir()); e != nil {
+					return 0, e
+				}
+			}
+			f := store.CreateFile(nntp.MessageID())
+			if f == nil {
+				return 0, errors.New("cannot create " + nntp.MessageID())
+			}
+			e := nntp.WriteTo(f)
+			f.Close()
+			if st, _ := os.Stat(store.GetFilename(nntp.MessageID())); st != nil {
+				return st.Size(), e
+			}
+			return 0, e
+		}},
+		{"store read", func(i int) (int64, error) {
+			r, e := store.OpenMessage(articles[i].MessageID())
+			if e != nil {
+				return 0, e
+			}
+			defer r.Close()
+			return io.Copy(ioutil.Discard, r)
+		}},
+		{"RegisterArticle", func(i int) (int64, error) {
+			return 0, db.RegisterArticle(articles[i])
+		}},
+		{"GetGroupForPage", func(i int) (int64, error) {
+			db.GetGroupForPage("/", "bench", *group, i%pages, perpage)
+			return 0, nil
+		}},
+	}
+	for _, phase := range phases {
+		var res benchResult
+		res, err = benchRun(phase.name, *n, phase.f)
+		if err != nil {
+			return errors.New(phase.name + ": " + err.Error())
+		}
+		fmt.Fprintln(w, res)
+	}
+	return
+}
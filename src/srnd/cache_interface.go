@@ -34,6 +34,9 @@ func NewCache(cache_type, host, port, user, password string, config map[string]s
 	if cache_type == "file" {
 		return NewFileCache(prefix, webroot, name, threads, attachments, db, store)
 	}
+	if cache_type == "static" {
+		return NewStaticCache(prefix, webroot, name, threads, attachments, db, store)
+	}
 	if cache_type == "null" {
 		return NewNullCache(prefix, webroot, name, attachments, db, store)
 	}
@@ -0,0 +1,275 @@
+//
+// captcha.go -- captcha services and policy for posting from the web frontend
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dchest/captcha"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// every post needs a captcha
+	CaptchaAlways = "always"
+	// no post needs a captcha
+	CaptchaNever = "never"
+	// posts need proof of work instead of a captcha
+	CaptchaProofOfWork = "pow"
+)
+
+// a service that hands out captcha challenges and checks solutions
+type CaptchaService interface {
+	// name of the provider, "builtin" or "external"
+	Provider() string
+	// create a new challenge, return its id
+	NewChallenge() string
+	// get the url of the challenge with this id, empty if there is none
+	ChallengeURL(prefix, id string) string
+	// get the public key the poster's browser needs, empty if there is none
+	SiteKey() string
+	// name of the post form field the solution is in
+	ResponseField() string
+	// check a solution to the challenge with this id from addr
+	Verify(id, solution, addr string) bool
+}
+
+// captcha images we make ourselves
+type builtinCaptcha struct{}
+
+func (self builtinCaptcha) Provider() string {
+	return "builtin"
+}
+
+func (self builtinCaptcha) NewChallenge() string {
+	return captcha.New()
+}
+
+func (self builtinCaptcha) ChallengeURL(prefix, id string) string {
+	return fmt.Sprintf("%scaptcha/%s.png", prefix, id)
+}
+
+func (self builtinCaptcha) SiteKey() string {
+	return ""
+}
+
+func (self builtinCaptcha) ResponseField() string {
+	return "captcha"
+}
+
+func (self builtinCaptcha) Verify(id, solution, addr string) bool {
+	return captcha.VerifyString(id, solution)
+}
+
+// a captcha provider with a recaptcha style siteverify endpoint
+// the poster's browser solves it and gives us a token we check with the provider
+type externalCaptcha struct {
+	verify_url     string
+	challenge_url  string
+	secret         string
+	site_key       string
+	response_field string
+	client         *http.Client
+}
+
+func (self externalCaptcha) Provider() string {
+	return "external"
+}
+
+func (self externalCaptcha) NewChallenge() string {
+	// the provider makes the challenge
+	return ""
+}
+
+func (self externalCaptcha) ChallengeURL(prefix, id string) string {
+	return self.challenge_url
+}
+
+func (self externalCaptcha) SiteKey() string {
+	return self.site_key
+}
+
+func (self externalCaptcha) ResponseField() string {
+	return self.response_field
+}
+
+func (self externalCaptcha) Verify(id, solution, addr string) bool {
+	if len(solution) == 0 {
+		return false
+	}
+	form := url.Values{}
+	form.Set("secret", self.secret)
+	form.Set("response", solution)
+	if len(addr) > 0 {
+		form.Set("remoteip", addr)
+	}
+	resp, err := self.client.PostForm(self.verify_url, form)
+	if err != nil {
+		log.Println("failed to verify captcha with", self.verify_url, err)
+		return false
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		log.Println("bad captcha verify response from", self.verify_url, err)
+		return false
+	}
+	return result.Success
+}
+
+// create the captcha service from config
+func captchaServiceFromConfig(conf map[string]string) CaptchaService {
+	if conf["type"] == "external" {
+		if len(conf["verify_url"]) == 0 {
+			log.Println("external captcha has no verify_url, using builtin captcha")
+			return builtinCaptcha{}
+		}
+		field := conf["response_field"]
+		if len(field) == 0 {
+			field = "captcha"
+		}
+		return externalCaptcha{
+			verify_url:     conf["verify_url"],
+			challenge_url:  conf["challenge_url"],
+			secret:         conf["secret"],
+			site_key:       conf["site_key"],
+			response_field: field,
+			client: &http.Client{
+				Timeout: time.Second * 10,
+			},
+		}
+	}
+	return builtinCaptcha{}
+}
+
+// decides which posts need a captcha
+// a policy is "always", "never", "pow" or a score at or above which a captcha is needed
+type captchaPolicy struct {
+	// policy for groups without their own
+	fallback string
+	// newsgroup -> policy
+	groups map[string]string
+	// board settings from the mod ui win over the config if not nil
+	database Database
+}
+
+// return true if policy is something captchaPolicy understands, empty is the fallback
+func validCaptchaPolicy(policy string) bool {
+	if policy == "" || policy == CaptchaAlways || policy == CaptchaNever || policy == CaptchaProofOfWork {
+		return true
+	}
+	_, err := strconv.ParseFloat(policy, 64)
+	return err == nil
+}
+
+// get the policy for a newsgroup
+func (self captchaPolicy) Policy(newsgroup string) string {
+	if self.database != nil {
+		s, err := self.database.GetBoardSettings(newsgroup)
+		if err == nil && s.Captcha != "" {
+			return s.Captcha
+		}
+	}
+	policy, ok := self.groups[newsgroup]
+	if ok {
+		return policy
+	}
+	return self.fallback
+}
+
+// do posts to newsgroup need proof of work?
+func (self captchaPolicy) ProofOfWork(newsgroup string) bool {
+	return self.Policy(newsgroup) == CaptchaProofOfWork
+}
+
+// do we need a captcha for a post to newsgroup with this spam score?
+func (self captchaPolicy) Required(newsgroup string, score float64) bool {
+	policy := self.Policy(newsgroup)
+	if policy == CaptchaNever || policy == CaptchaProofOfWork {
+		return false
+	} else if policy == CaptchaAlways || policy == "" {
+		return true
+	}
+	threshold, err := strconv.ParseFloat(policy, 64)
+	if err != nil {
+		log.Println("invalid captcha policy", policy, "for", newsgroup)
+		return true
+	}
+	return score >= threshold
+}
+
+// captchas that sessions solved, kept on our side so a replayed session cookie can't post more than it was allowed
+type solvedCaptchas struct {
+	access sync.Mutex
+	// how long and for how many posts a solved captcha is good for
+	lifetime time.Duration
+	posts    int
+	// session id -> what is left of what it solved
+	sessions map[string]*solvedCaptcha
+}
+
+type solvedCaptcha struct {
+	until time.Time
+	posts int
+}
+
+// a solved captcha good for posts posts within lifetime, nil if solved captchas are not remembered
+func newSolvedCaptchas(lifetime time.Duration, posts int) *solvedCaptchas {
+	if lifetime <= 0 || posts <= 0 {
+		return nil
+	}
+	return &solvedCaptchas{
+		lifetime: lifetime,
+		posts:    posts,
+		sessions: make(map[string]*solvedCaptcha),
+	}
+}
+
+// remember that session id solved a captcha at now
+func (self *solvedCaptchas) Remember(id string, now time.Time) {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	// forget the ones that ran out so we don't grow forever
+	for k, s := range self.sessions {
+		if !now.Before(s.until) {
+			delete(self.sessions, k)
+		}
+	}
+	self.sessions[id] = &solvedCaptcha{until: now.Add(self.lifetime), posts: self.posts}
+}
+
+// use up a post of the captcha session id solved
+// return true if there was one left
+func (self *solvedCaptchas) Use(id string, now time.Time) bool {
+	if self == nil {
+		return false
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	s, ok := self.sessions[id]
+	if !ok {
+		return false
+	}
+	if !now.Before(s.until) {
+		delete(self.sessions, id)
+		return false
+	}
+	s.posts--
+	if s.posts <= 0 {
+		delete(self.sessions, id)
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaptchaPolicy(t *testing.T) {
+
+	p := captchaPolicy{
+		fallback: CaptchaAlways,
+		groups: map[string]string{
+			"overchan.quiet": CaptchaNever,
+			"overchan.busy":  "0.7",
+		},
+	}
+	if !p.Required("overchan.test", 0) {
+		t.Error("fallback policy should need a captcha")
+	}
+	if p.Required("overchan.quiet", 1) {
+		t.Error("never policy should not need a captcha")
+	}
+	if p.Required("overchan.busy", 0.5) || !p.Required("overchan.busy", 0.7) {
+		t.Error("score policy should need a captcha at or above the threshold only")
+	}
+
+}
+
+func TestSolvedCaptchas(t *testing.T) {
+
+	if newSolvedCaptchas(0, 3) != nil || newSolvedCaptchas(time.Minute, 0) != nil {
+		t.Error("solved captchas should be off without a lifetime and posts")
+	}
+	s := newSolvedCaptchas(time.Minute, 2)
+	now := time.Now()
+	if s.Use("session", now) {
+		t.Error("a session that solved nothing can't skip the captcha")
+	}
+	s.Remember("session", now)
+	if !s.Use("session", now) || !s.Use("session", now) {
+		t.Error("a solved captcha should be good for 2 posts")
+	}
+	if s.Use("session", now) {
+		t.Error("a replayed session should not get more posts than it solved for")
+	}
+	s.Remember("session", now)
+	if s.Use("session", now.Add(time.Minute)) {
+		t.Error("a solved captcha should run out after its lifetime")
+	}
+	s.Remember("old", now)
+	s.Remember("new", now.Add(time.Hour))
+	if len(s.sessions) != 1 {
+		t.Error("sessions that ran out should be forgotten", s.sessions)
+	}
+
+}
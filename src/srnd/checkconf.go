@@ -0,0 +1,518 @@
+//
+// checkconf.go -- checking srnd.ini and feeds.ini line by line and printing the config srnd would run with
+//
+
+package srnd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// an option in an ini file and where it is
+type iniOption struct {
+	File    string
+	Line    int
+	Section string
+	Key     string
+	Value   string
+}
+
+// where an option is set, file:line or the environment variable
+func (self *iniOption) Where() string {
+	if self.Line == 0 {
+		return self.File
+	}
+	return fmt.Sprintf("%s:%d", self.File, self.Line)
+}
+
+// an ini file read the way configparser reads it, but keeping line numbers
+type iniFile struct {
+	Name string
+	// section -> line it starts on
+	Sections map[string]int
+	Options  []iniOption
+}
+
+// find an option, nil if it is not set
+func (self *iniFile) Get(section, key string) *iniOption {
+	for idx := range self.Options {
+		if self.Options[idx].Section == section && self.Options[idx].Key == key {
+			return &self.Options[idx]
+		}
+	}
+	return nil
+}
+
+// the options of a section as a map like configparser gives us
+func (self *iniFile) Map(section string) map[string]string {
+	opts := make(map[string]string)
+	for _, opt := range self.Options {
+		if opt.Section == section {
+			opts[opt.Key] = opt.Value
+		}
+	}
+	return opts
+}
+
+// set the options environ and the command line override like ReadConfig does
+// they keep the variable name or "command line" as where they are from
+func (self *iniFile) applyOverrides(environ []string) {
+	// sections only the environment or command line has are printed after the file's
+	next := 1
+	for _, line := range self.Sections {
+		if line >= next {
+			next = line + 1
+		}
+	}
+	for _, opt := range self.Options {
+		if opt.Line >= next {
+			next = opt.Line + 1
+		}
+	}
+	set := func(section, key, value, from string) {
+		if _, ok := self.Sections[section]; !ok {
+			self.Sections[section] = next
+			next++
+		}
+		if opt := self.Get(section, key); opt != nil {
+			opt.File, opt.Line, opt.Value = from, 0, value
+		} else {
+			self.Options = append(self.Options, iniOption{from, 0, section, key, value})
+		}
+	}
+	for _, section := range configSections {
+		for key, value := range configEnvOverrides(section, self.Map(section), environ) {
+			set(section, key, value, configEnvName(section, key))
+		}
+	}
+	for section, opts := range configFlags {
+		for key, value := range opts {
+			set(section, key, value, "command line")
+		}
+	}
+}
+
+// set the secrets _file options point at like ReadConfig does, they are from where the _file option is
+// returns the secrets that could not be read
+func (self *iniFile) readSecrets(sections []string, environ []string) (problems []string) {
+	for _, section := range sections {
+		opts := self.Map(section)
+		secrets, err := readSecretOptions(section, opts, configOverrides(section, opts, environ))
+		if err != nil {
+			problems = append(problems, self.Name+": "+err.Error())
+			continue
+		}
+		for key, secret := range secrets {
+			from := *self.Get(section, key+secretFileSuffix)
+			if opt := self.Get(section, key); opt != nil {
+				opt.File, opt.Line, opt.Value = from.File, from.Line, secret
+			} else {
+				self.Options = append(self.Options, iniOption{from.File, from.Line, section, key, secret})
+			}
+		}
+	}
+	return
+}
+
+// read an ini file, lines we can't make sense of are returned as problems
+func parseINI(fname string, r io.Reader) (ini *iniFile, problems []string) {
+	ini = &iniFile{Name: fname, Sections: make(map[string]int)}
+	sc := bufio.NewScanner(r)
+	section := ""
+	lineno := 0
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				problems = append(problems, fmt.Sprintf("%s:%d: bad section header %q", fname, lineno, line))
+				continue
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := ini.Sections[section]; ok {
+				problems = append(problems, fmt.Sprintf("%s:%d: section [%s] appears again, the first one is on line %d", fname, lineno, section, ini.Sections[section]))
+				continue
+			}
+			ini.Sections[section] = lineno
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 1 {
+			problems = append(problems, fmt.Sprintf("%s:%d: expected key = value, got %q", fname, lineno, line))
+			continue
+		}
+		if section == "" {
+			problems = append(problems, fmt.Sprintf("%s:%d: option outside of any section", fname, lineno))
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		if prev := ini.Get(section, key); prev != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s in [%s] is already set on line %d", fname, lineno, key, section, prev.Line))
+			continue
+		}
+		ini.Options = append(ini.Options, iniOption{fname, lineno, section, key, strings.TrimSpace(line[idx+1:])})
+	}
+	return
+}
+
+// kinds of values options take
+const (
+	confBool = "0 or 1"
+	confInt  = "a whole number"
+	confAddr = "host:port, unix:/path or systemd[:name]"
+	confDir  = "a directory that exists"
+	confFile = "a file that exists"
+)
+
+// what options we know the type of, section -> key -> kind or the values it can take separated by |
+var confOptionKinds = map[string]map[string]string{
+	"nntp": {
+		"bind":                   confAddr,
+		"sync_on_start":          confBool,
+		"allow_anon":             confBool,
+		"allow_anon_attachments": confBool,
+		"allow_attachments":      confBool,
+		"require_tls":            confBool,
+		"anon_nntp":              confBool,
+		"archive":                confBool,
+		"article_lifetime":       confInt,
+		"cycle_replies":          confInt,
+		"keep_rejected":          confBool,
+		"feeds_reload":           confInt,
+		"log_level":              LogDebug + "|" + LogInfo + "|" + LogError,
+	},
+	"articles": {
+		"store_dir":             confDir,
+		"incoming_dir":          confDir,
+		"attachments_dir":       confDir,
+		"thumbs_dir":            confDir,
+		"trash_hours":           confInt,
+		"convert_bin":           confFile,
+		"ffmpegthumbnailer_bin": confFile,
+		"sox_bin":               confFile,
+		"compression":           "0|1|" + ArticleCompressionNone + "|" + ArticleCompressionGzip + "|" + ArticleCompressionZstd,
+	},
+	"database": {
+		"type": "postgres|redis",
+	},
+	"cache": {
+		"type": "file|static|null|redis",
+	},
+	"pprof": {
+		"enable": confBool,
+	},
+	"tor": {
+		"enable": confBool,
+	},
+	"frontend": {
+		"enable":             confBool,
+		"bind":               confAddr,
+		"allow_files":        confBool,
+		"regen_on_start":     confBool,
+		"regen_threads":      confInt,
+		"minimize_html":      confBool,
+		"template_reload":    confInt,
+		"static_archive":     confBool,
+		"markup":             MarkupPlain + "|" + MarkupBasic + "|" + MarkupMarkdown,
+		"op_delete":          confInt,
+		"require_post_token": confBool,
+		"remember_poster":    confBool,
+		"read_only":          confBool,
+		"max_subject":        confInt,
+		"max_name":           confInt,
+		"max_message":        confInt,
+		"max_lines":          confInt,
+		"thread_page_size":   confInt,
+		"url_attachments":    confBool,
+		"resumable_uploads":  confBool,
+		"acme":               confBool,
+		"acme_challenge":     ACMEChallengeHTTP + "|" + ACMEChallengeDNS,
+		"acme_dns_hook":      confFile,
+		"hsts":               confInt,
+		"gzip_level":         confInt,
+		"mod_nntp_login":     confBool,
+		"json-api":           confBool,
+	},
+	"remote_deletes": {
+		"default": RemoteDeleteHonor + "|" + RemoteDeleteQueue + "|" + RemoteDeleteIgnore,
+	},
+	"api": {
+		"srnd":     confAddr,
+		"tls":      confBool,
+		"tls_cert": confFile,
+		"tls_key":  confFile,
+		"tls_ca":   confFile,
+	},
+	"addr_keys": {
+		"rotate_days": confInt,
+		"purge_days":  confInt,
+	},
+}
+
+// what options of every feed-* section we know the type of
+var confFeedOptionKinds = map[string]string{
+	"proxy-type":       "none|socks4a|socks",
+	"port":             confInt,
+	"sync":             confBool,
+	"sync-interval":    confInt,
+	"connections":      confInt,
+	"priority":         confInt,
+	"keepalive":        confInt,
+	"delete_policy":    RemoteDeleteHonor + "|" + RemoteDeleteQueue + "|" + RemoteDeleteIgnore,
+	"moderated_intake": confBool,
+	"trust_after":      confInt,
+	"disabletls":       confBool,
+}
+
+// what options of every [group "name"] section we know the type of
+var confGroupOptionKinds = map[string]string{
+	"article_lifetime": confInt,
+	"attachments":      confBool,
+	"threads":          confInt,
+	"cycle_replies":    confInt,
+}
+
+// options whose values are not printed
+func confSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"password", "passwd", "secret", "privkey"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// check a value is of a kind, empty if it is
+func confCheckValue(kind, value string) string {
+	switch kind {
+	case confBool:
+		if value != "0" && value != "1" {
+			return "should be " + kind
+		}
+	case confInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "should be " + kind
+		}
+	case confAddr:
+		if strings.HasPrefix(value, "unix:") || value == "systemd" || strings.HasPrefix(value, "systemd:") {
+			return ""
+		}
+		_, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return "should be " + kind
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return "has a bad port " + port
+		}
+	case confDir:
+		if st, err := os.Stat(value); err != nil || !st.IsDir() {
+			return "should be " + kind
+		}
+	case confFile:
+		if st, err := os.Stat(value); err != nil || st.IsDir() {
+			return "should be " + kind
+		}
+	default:
+		for _, v := range strings.Split(kind, "|") {
+			if v == value {
+				return ""
+			}
+		}
+		return "should be one of " + strings.Replace(kind, "|", " ", -1)
+	}
+	return ""
+}
+
+// check the options of an ini file against what we know of them
+func (self *iniFile) checkKinds(section string, kinds map[string]string) (problems []string) {
+	for _, opt := range self.Options {
+		if opt.Section != section {
+			continue
+		}
+		kind, ok := kinds[opt.Key]
+		if !ok {
+			continue
+		}
+		// empty means unset for paths and addresses
+		if opt.Value == "" && (kind == confAddr || kind == confDir || kind == confFile) {
+			continue
+		}
+		if msg := confCheckValue(kind, opt.Value); msg != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s in [%s] is %q, it %s", opt.Where(), opt.Key, opt.Section, opt.Value, msg))
+		}
+	}
+	return
+}
+
+// check srnd.ini
+func checkSRNdINI(ini *iniFile) (problems []string) {
+	for _, section := range []string{"nntp", "database", "articles"} {
+		if _, ok := ini.Sections[section]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: no [%s] section", ini.Name, section))
+		}
+	}
+	if len(problems) > 0 {
+		return
+	}
+	conf := &SRNdConfig{
+		daemon:   ini.Map("nntp"),
+		store:    ini.Map("articles"),
+		database: ini.Map("database"),
+	}
+	for _, p := range conf.Problems() {
+		problems = append(problems, ini.Name+": "+p)
+	}
+	sections := make([]string, 0, len(confOptionKinds))
+	for section := range confOptionKinds {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		problems = append(problems, ini.checkKinds(section, confOptionKinds[section])...)
+	}
+	var groups []string
+	for section := range ini.Sections {
+		if strings.HasPrefix(section, "group ") {
+			groups = append(groups, section)
+		}
+	}
+	sort.Strings(groups)
+	for _, section := range groups {
+		newsgroup, ok := groupSectionName(section)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s:%d: [%s] should be [group \"newsgroup.name\"]", ini.Name, ini.Sections[section], section))
+			continue
+		}
+		problems = append(problems, ini.checkKinds(section, confGroupOptionKinds)...)
+		if opt := ini.Get(section, "captcha"); opt != nil && !validCaptchaPolicy(opt.Value) {
+			problems = append(problems, fmt.Sprintf("%s: captcha for %s is %q, it should be always, never, pow or a spam score", opt.Where(), newsgroup, opt.Value))
+		}
+	}
+	if ini.Map("frontend")["enable"] == "1" {
+		if opt := ini.Get("frontend", "templates"); opt != nil {
+			if msg := confCheckValue(confDir, opt.Value); msg != "" {
+				problems = append(problems, fmt.Sprintf("%s: templates in [frontend] is %q, it %s", opt.Where(), opt.Value, msg))
+			}
+		}
+	}
+	if opt := ini.Get("crypto", "tls-hostname"); opt != nil && (opt.Value == "" || strings.HasPrefix(opt.Value, "!")) {
+		problems = append(problems, fmt.Sprintf("%s: set tls-hostname in [crypto] to the hostname or ip address of this server", opt.Where()))
+	}
+	if opt := ini.Get("frontend", "acme_challenge"); opt != nil && opt.Value == ACMEChallengeDNS && ini.Map("frontend")["acme_dns_hook"] == "" {
+		problems = append(problems, fmt.Sprintf("%s: acme_challenge in [frontend] is dns-01 but acme_dns_hook in [frontend] is not set", opt.Where()))
+	}
+	if opt := ini.Get("api", "srnd"); opt != nil && opt.Value != "" && ini.Map("api")["secret"] == "" {
+		problems = append(problems, fmt.Sprintf("%s: srnd in [api] takes frontends but secret in [api] is not set", opt.Where()))
+	}
+	return
+}
+
+// check a feeds file, every feed-name section needs a name section with its policy
+func checkFeedsINI(ini *iniFile) (problems []string) {
+	var feeds []string
+	for section := range ini.Sections {
+		if strings.HasPrefix(section, "feed-") {
+			feeds = append(feeds, section)
+		}
+	}
+	sort.Strings(feeds)
+	problems = ini.readSecrets(feeds, nil)
+	for _, section := range feeds {
+		problems = append(problems, ini.checkKinds(section, confFeedOptionKinds)...)
+		if _, ok := ini.Sections[section[5:]]; !ok {
+			problems = append(problems, fmt.Sprintf("%s:%d: [%s] has no [%s] section with its newsgroup policy", ini.Name, ini.Sections[section], section, section[5:]))
+		}
+	}
+	return
+}
+
+// print the options of an ini file grouped by section, secrets masked
+func (self *iniFile) Print(w io.Writer) {
+	fmt.Fprintf(w, "# %s\n", self.Name)
+	sections := make([]string, 0, len(self.Sections))
+	for section := range self.Sections {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return self.Sections[sections[i]] < self.Sections[sections[j]]
+	})
+	for _, section := range sections {
+		fmt.Fprintf(w, "[%s]\n", section)
+		for _, opt := range self.Options {
+			if opt.Section != section {
+				continue
+			}
+			value := opt.Value
+			if value != "" && confSecret(opt.Key) {
+				value = "********"
+			}
+			if opt.Line == 0 {
+				fmt.Fprintf(w, "%s = %s # from %s\n", opt.Key, value, opt.File)
+			} else {
+				fmt.Fprintf(w, "%s = %s\n", opt.Key, value)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// read and check an ini file
+func checkINIFile(fname string, check func(*iniFile) []string) (ini *iniFile, problems []string) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	defer f.Close()
+	ini, problems = parseINI(fname, f)
+	return ini, append(problems, check(ini)...)
+}
+
+// read and check srnd.ini, feeds.ini and the feeds directory
+func checkConfFiles() (files []*iniFile, problems []string) {
+	fname := "srnd.ini"
+	if os.Getenv("SRND_INI_PATH") != "" && CheckFile(os.Getenv("SRND_INI_PATH")) {
+		fname = os.Getenv("SRND_INI_PATH")
+	}
+	srnd, problems := checkINIFile(fname, func(ini *iniFile) []string {
+		ini.applyOverrides(os.Environ())
+		return append(ini.readSecrets(configSections, os.Environ()), checkSRNdINI(ini)...)
+	})
+	if srnd != nil {
+		files = append(files, srnd)
+		for _, name := range feedFiles(srnd.Map("nntp")) {
+			feeds, p := checkINIFile(name, checkFeedsINI)
+			problems = append(problems, p...)
+			if feeds != nil {
+				files = append(files, feeds)
+			}
+		}
+	}
+	return
+}
+
+// check srnd.ini, feeds.ini and the feeds directory, print what srnd would run with and every problem
+// returns how many problems there are
+func CheckConfTool(w io.Writer) int {
+	files, problems := checkConfFiles()
+	for _, ini := range files {
+		ini.Print(w)
+	}
+	for _, p := range problems {
+		fmt.Fprintln(w, "error:", p)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "config ok")
+	}
+	return len(problems)
+}
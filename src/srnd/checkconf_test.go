@@ -0,0 +1,19 @@
+package srnd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+
+	ini, problems := parseINI("srnd.ini", strings.NewReader("# comment\n[nntp]\nbind = 127.0.0.1:1199\nallow_anon = yes\nnonsense\n[nntp]\n"))
+	if len(problems) != 2 || !strings.HasPrefix(problems[0], "srnd.ini:5:") || !strings.HasPrefix(problems[1], "srnd.ini:6:") {
+		t.Error("bad parse problems", problems)
+	}
+	problems = ini.checkKinds("nntp", confOptionKinds["nntp"])
+	if len(problems) != 1 || !strings.HasPrefix(problems[0], "srnd.ini:4: allow_anon") {
+		t.Error("bad kind problems", problems)
+	}
+
+}
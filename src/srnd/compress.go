@@ -0,0 +1,316 @@
+//
+// compress.go -- gzip for pages we send, pre-compressed static files and compressed articles
+//
+
+package srnd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// content types worth compressing
+var compressibleTypes = []string{"text/html", "text/json", "text/css", "text/plain", "application/json", "application/javascript", "text/javascript", "image/svg+xml"}
+
+func compressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// does the client take this content encoding?
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != encoding {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzips the response if it turns out to be something worth compressing
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level   int
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (self *gzipResponseWriter) decide(code int) {
+	if self.decided {
+		return
+	}
+	self.decided = true
+	h := self.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if code == http.StatusNotModified || code == http.StatusNoContent || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	gz, err := gzip.NewWriterLevel(self.ResponseWriter, self.level)
+	if err != nil {
+		return
+	}
+	self.gz = gz
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+}
+
+func (self *gzipResponseWriter) WriteHeader(code int) {
+	self.decide(code)
+	self.ResponseWriter.WriteHeader(code)
+}
+
+func (self *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !self.decided {
+		if self.Header().Get("Content-Type") == "" {
+			self.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		self.WriteHeader(http.StatusOK)
+	}
+	if self.gz == nil {
+		return self.ResponseWriter.Write(data)
+	}
+	return self.gz.Write(data)
+}
+
+func (self *gzipResponseWriter) Close() {
+	if self.gz != nil {
+		self.gz.Close()
+	}
+}
+
+// gzip responses for clients that take it, level 0 to not compress
+func compressResponses(level int, h http.Handler) http.Handler {
+	if level == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		// the live ui websocket needs the raw connection
+		if r.Header.Get("Upgrade") != "" || r.Method == "HEAD" || !acceptsEncoding(r, "gzip") {
+			h.ServeHTTP(wr, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: wr, level: level}
+		h.ServeHTTP(gw, r)
+		gw.Close()
+	})
+}
+
+// encodings we look for next to static files, best first
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// serve foo.css.br or foo.css.gz from dir in place of foo.css when the client takes them
+func precompressedFiles(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		upath := path.Clean("/" + r.URL.Path)
+		fpath := filepath.Join(dir, filepath.FromSlash(upath))
+		for _, enc := range precompressedEncodings {
+			if !acceptsEncoding(r, enc.encoding) {
+				continue
+			}
+			f, err := os.Open(fpath + enc.ext)
+			if err != nil {
+				continue
+			}
+			st, err := f.Stat()
+			if err != nil || st.IsDir() {
+				f.Close()
+				continue
+			}
+			ctype := mime.TypeByExtension(filepath.Ext(fpath))
+			if ctype == "" {
+				ctype = "application/octet-stream"
+			}
+			wr.Header().Set("Content-Type", ctype)
+			wr.Header().Set("Content-Encoding", enc.encoding)
+			wr.Header().Add("Vary", "Accept-Encoding")
+			http.ServeContent(wr, r, upath, st.ModTime(), f)
+			f.Close()
+			return
+		}
+		h.ServeHTTP(wr, r)
+	})
+}
+
+// formats articles can be stored in, set by compression in [articles]
+const (
+	ArticleCompressionNone = "none"
+	ArticleCompressionGzip = "gzip"
+	ArticleCompressionZstd = "zstd"
+)
+
+var articleCompressions = []string{ArticleCompressionNone, ArticleCompressionGzip, ArticleCompressionZstd}
+
+// the format compression in [articles] asks for, 0 and 1 are from before there was a choice
+func articleCompressionFromConfig(val string) string {
+	switch val {
+	case "", "0":
+		return ArticleCompressionNone
+	case "1":
+		return ArticleCompressionGzip
+	}
+	return val
+}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// the format of a stored article from its first 4 bytes
+func sniffArticleCompression(head []byte) string {
+	if len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b {
+		return ArticleCompressionGzip
+	} else if bytes.HasPrefix(head, zstdMagic) {
+		return ArticleCompressionZstd
+	}
+	return ArticleCompressionNone
+}
+
+// a stored article decompressed as we read it, closing it closes the file
+type articleReader struct {
+	io.Reader
+	f    *os.File
+	done func()
+}
+
+func (self *articleReader) Close() error {
+	if self.done != nil {
+		self.done()
+	}
+	return self.f.Close()
+}
+
+// open a stored article in whatever format it is in, so a spool can mix them
+func openArticleFile(fname string) (io.ReadCloser, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(zstdMagic))
+	r := &articleReader{Reader: br, f: f}
+	switch sniffArticleCompression(head) {
+	case ArticleCompressionGzip:
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(br)
+		if err == nil {
+			r.Reader = gz
+			r.done = func() { gz.Close() }
+		}
+	case ArticleCompressionZstd:
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			r.Reader = zr
+			r.done = zr.Close
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// the size of a stored article once decompressed
+func articleFileSize(fname string) (int64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	head := make([]byte, len(zstdMagic))
+	n, _ := io.ReadFull(f, head)
+	switch sniffArticleCompression(head[:n]) {
+	case ArticleCompressionGzip:
+		// gzip ends with the size mod 4GiB, far past what we take for an article
+		trailer := make([]byte, 4)
+		_, err = f.ReadAt(trailer, st.Size()-4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.LittleEndian.Uint32(trailer)), nil
+	case ArticleCompressionZstd:
+		// our zstd frames are streamed so they don't say, count it
+		var r io.ReadCloser
+		r, err = openArticleFile(fname)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		return io.Copy(ioutil.Discard, r)
+	}
+	return st.Size(), nil
+}
+
+// an article being stored compressed, closing it finishes the stream and closes the file
+type articleWriter struct {
+	io.Writer
+	f    *os.File
+	done func() error
+}
+
+func (self *articleWriter) Close() (err error) {
+	if self.done != nil {
+		err = self.done()
+	}
+	if e := self.f.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// write an article to f in a format
+func compressArticleFile(f *os.File, format string) (io.WriteCloser, error) {
+	w := &articleWriter{Writer: f, f: f}
+	switch format {
+	case ArticleCompressionNone:
+	case ArticleCompressionGzip:
+		gz := gzip.NewWriter(f)
+		w.Writer = gz
+		w.done = gz.Close
+	case ArticleCompressionZstd:
+		zw, err := zstd.NewWriter(f, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w.Writer = zw
+		w.done = zw.Close
+	default:
+		return nil, errors.New("no such article compression " + format)
+	}
+	return w, nil
+}
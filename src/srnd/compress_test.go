@@ -0,0 +1,19 @@
+package srnd
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Accept-Encoding", "gzip;q=0, br")
+	if acceptsEncoding(r, "gzip") {
+		t.Error("gzip with q=0 should not be taken")
+	}
+	if !acceptsEncoding(r, "br") {
+		t.Error("br should be taken")
+	}
+
+}
@@ -13,6 +13,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -31,11 +32,29 @@ type FeedConfig struct {
 	Name             string
 	sync_interval    time.Duration
 	connections      int
+	// lower is preferred when pulling articles, feeds with a higher value are only used as fallback
+	priority int
+	// probe idle connections this often and drop them if they don't answer, 0 to disable
+	keepalive time.Duration
+	// what to do with remote deletes from this peer, empty to use the default
+	delete_policy string
+	// hold articles from this peer for the mods until it is trusted
+	moderated_intake bool
+	// trust a peer in moderated intake after this many approved articles in a row, 0 to never
+	trust_after int
 }
 
 type APIConfig struct {
 	srndAddr     string
 	frontendAddr string
+	// shared by srnd and its split frontends
+	secret string
+	// tls on the api socket, without it srnd only takes frontends over unix sockets and loopback
+	tls     bool
+	tlsCert string
+	tlsKey  string
+	// what srnd's certificate is checked against on the frontend, empty for the system's roots
+	tlsCA string
 }
 
 type CryptoConfig struct {
@@ -54,6 +73,7 @@ type ProfilingConfig struct {
 type SRNdConfig struct {
 	daemon   map[string]string
 	crypto   *CryptoConfig
+	tor      *TorConfig
 	store    map[string]string
 	database map[string]string
 	cache    map[string]string
@@ -62,6 +82,47 @@ type SRNdConfig struct {
 	system   map[string]string
 	worker   map[string]string
 	pprof    *ProfilingConfig
+	api      *APIConfig
+
+	// remote delete policy, "default" and per pubkey
+	remote_deletes map[string]string
+	// spam filter settings and newsgroup -> threshold
+	spam            map[string]string
+	spam_thresholds map[string]string
+	// flood detection settings
+	flood map[string]string
+	// captcha settings and newsgroup -> captcha policy
+	captcha        map[string]string
+	captcha_groups map[string]string
+	// proof of work settings for newsgroups with the pow captcha policy
+	pow map[string]string
+	// posting cooldown settings and newsgroup -> cooldown
+	cooldown        map[string]string
+	cooldown_groups map[string]string
+	// frontend rate limits per address
+	ratelimit map[string]string
+	// where mods get told about reports, quarantines and auto bans
+	notify map[string]string
+	// how long the keys behind encrypted addresses live
+	addr_keys map[string]string
+	// daily posting limits per signing key
+	quota map[string]string
+	// dnsbls and tor exit list checked for frontend posters
+	dnsbl map[string]string
+	// per country posting policy
+	geoip map[string]string
+	// newsgroup -> locale its pages are rendered in
+	board_locales map[string]string
+	// newsgroup -> markup engine for post bodies
+	board_markup map[string]string
+	// link preview settings
+	link_previews map[string]string
+	// newsgroup -> settings of its [group "name"] section
+	groups map[string]GroupConfig
+	// [nntp], [database] and [articles] as checked by LoadConfig
+	nntp     NNTPConfig
+	db       DatabaseConfig
+	articles StoreConfig
 }
 
 // check for config files
@@ -110,6 +171,8 @@ func GenFeedsConfig() error {
 	sect.Add("host", "dummy")
 	sect.Add("port", "119")
 	sect.Add("connections", "1")
+	sect.Add("priority", "0")
+	sect.Add("keepalive", "60")
 
 	sect = conf.NewSection("dummy")
 	sect.Add("overchan.*", "1")
@@ -126,6 +189,7 @@ func GenSRNdConfig() *configparser.Configuration {
 	// nntp related section
 	sect := conf.NewSection("nntp")
 	sect.Add("instance_name", "test.srndv2.tld")
+	// host:port, unix:/path/to/socket or systemd[:name] for a socket from socket activation
 	sect.Add("bind", "127.0.0.1:1199")
 	sect.Add("sync_on_start", "1")
 	sect.Add("allow_anon", "0")
@@ -133,15 +197,49 @@ func GenSRNdConfig() *configparser.Configuration {
 	sect.Add("allow_attachments", "1")
 	sect.Add("require_tls", "1")
 	sect.Add("anon_nntp", "0")
+	// a file per peer, merged with feeds.ini
 	sect.Add("feeds", filepath.Join(".", "feeds.d"))
+	// seconds between checks for changed feed files, changes are applied like on SIGHUP, 0 to not check
+	sect.Add("feeds_reload", "5")
 	sect.Add("archive", "0")
 	sect.Add("article_lifetime", "0")
+	// replies a cycling thread keeps
+	// a [group "newsgroup.name"] section can set article_lifetime, attachments, captcha, threads
+	// and cycle_replies for one newsgroup
+	sect.Add("cycle_replies", "300")
+	// keep articles our filters and bans reject for the mods instead of dropping them
+	sect.Add("keep_rejected", "0")
+	// debug, info or error for only what went wrong
+	sect.Add("log_level", "info")
+
+	// running the web frontend as its own process with "srnd frontend"
+	// srnd takes frontends here, unix:/path/to/socket or host:port, the frontend connects to it, empty for none
+	// the frontend can share srnd's database and articles directory or keep its own,
+	// then it gets the articles from srnd
+	sect = conf.NewSection("api")
+	sect.Add("srnd", "")
+	sect.Add("secret", hexify(nacl.RandBytes(16)))
+	// tls on the socket, needed when srnd is not on a unix socket or loopback
+	// srnd uses tls_cert and tls_key, the frontend checks them against tls_ca or the system's roots
+	sect.Add("tls", "0")
+	sect.Add("tls_cert", "")
+	sect.Add("tls_key", "")
+	sect.Add("tls_ca", "")
 
 	// profiling settings
 	sect = conf.NewSection("pprof")
 	sect.Add("enable", "0")
 	sect.Add("bind", "127.0.0.1:17000")
 
+	// tor control port settings
+	sect = conf.NewSection("tor")
+	sect.Add("enable", "0")
+	sect.Add("control", "127.0.0.1:9051")
+	sect.Add("password", "")
+	sect.Add("keyfile", "onion.key")
+	sect.Add("hostname_file", "onion.hostname")
+	sect.Add("port", "119")
+
 	// crypto related section
 	sect = conf.NewSection("crypto")
 	sect.Add("tls-keyname", "overchan")
@@ -155,14 +253,22 @@ func GenSRNdConfig() *configparser.Configuration {
 	sect.Add("incoming_dir", "/tmp/articles")
 	sect.Add("attachments_dir", "webroot/img")
 	sect.Add("thumbs_dir", "webroot/thm")
+	// deleted articles wait here trash_hours to be restored, empty trash_dir to delete for good
+	sect.Add("trash_dir", "trash")
+	// articles kept with keep_rejected wait here for the mods, apart from the articles we serve
+	sect.Add("rejected_dir", "rejected")
+	sect.Add("trash_hours", "24")
 	sect.Add("convert_bin", "/usr/bin/convert")
 	sect.Add("ffmpegthumbnailer_bin", "/usr/bin/ffmpeg")
 	sect.Add("sox_bin", "/usr/bin/sox")
 	sect.Add("placeholder_thumbnail", "contrib/static/placeholder.png")
-	sect.Add("compression", "0")
+	// none, gzip or zstd for articles stored from now on, srnd recompress converts the ones already stored
+	sect.Add("compression", ArticleCompressionNone)
 
 	// database backend config
 	sect = conf.NewSection("database")
+	// any password or secret here and in other sections can be read from elsewhere instead,
+	// password_file = /path, env:NAME or exec:command that prints it
 	// defaults to redis if enabled
 	if RedisEnabled() {
 		sect.Add("type", "redis")
@@ -183,7 +289,7 @@ func GenSRNdConfig() *configparser.Configuration {
 
 	// cache backend config
 	sect = conf.NewSection("cache")
-	// defaults to file
+	// defaults to file, static renders the whole site to the webroot for nginx or a cdn to serve
 	sect.Add("type", "file")
 
 	// baked in static html frontend
@@ -192,22 +298,228 @@ func GenSRNdConfig() *configparser.Configuration {
 	sect.Add("allow_files", "1")
 	sect.Add("regen_on_start", "0")
 	sect.Add("regen_threads", "1")
+	// host:port, unix:/path/to/socket or systemd[:name] for a socket from socket activation
 	sect.Add("bind", "[::]:18000")
 	sect.Add("name", "web.srndv2.test")
 	sect.Add("webroot", "webroot")
 	sect.Add("minimize_html", "0")
+	// path or url the site is at, a path other than / is served there too for proxies that don't strip it
 	sect.Add("prefix", "/")
+	// comma separated addresses or networks of reverse proxies whose X-Real-IP and X-Forwarded-For we believe
+	// tor connecting straight to the frontend comes from loopback too, use none if nothing proxies for us
+	sect.Add("trusted_proxies", "127.0.0.0/8,::1")
 	sect.Add("static_files", "contrib")
 	sect.Add("templates", "contrib/templates/default")
+	// per newsgroup overrides go in board_templates/overchan.x/, empty to disable
+	sect.Add("board_templates", "")
+	// seconds between checks for changed templates, 0 to only reload on SIGHUP
+	sect.Add("template_reload", "5")
+	// css theme from static/themes/ or webroot/themes/ for readers who did not pick one
+	sect.Add("theme", "")
+	// render threads to static html under /archive/ before they expire
+	sect.Add("static_archive", "0")
+	sect.Add("static_archive_dir", "webroot/archive")
+	// how post bodies are formatted, plain, basic or markdown
+	sect.Add("markup", "basic")
 	sect.Add("translations", "contrib/translations")
 	sect.Add("locale", "en")
 	sect.Add("domain", "localhost")
+	sect.Add("report_interval", "60")
+	// seconds posters can delete their own posts with their password, 0 to not let them
+	sect.Add("op_delete", "1800")
+	// posts from the form need the session's token, static pages ask /post_token for it and /postform/board has it filled in
+	sect.Add("require_post_token", "1")
+	// remember the name and options posters use in a signed cookie, /postform/board fills them in
+	sect.Add("remember_poster", "1")
+	// mirror content without taking posts, forms are left out and posting answers 403, articles still come over nntp
+	sect.Add("read_only", "0")
+	// most characters posts from the web can have in each field and most lines in the message, 0 for no limit
+	sect.Add("max_subject", "256")
+	sect.Add("max_name", "128")
+	sect.Add("max_message", "1048576")
+	sect.Add("max_lines", "0")
+	// replies per page of /thread/{id}/page/{n} for long threads
+	sect.Add("thread_page_size", "100")
+	// let posters give a url for the frontend to download and attach instead of uploading
+	// fetched through a socks4a proxy like feeds are, none to connect directly to public addresses
+	sect.Add("url_attachments", "0")
+	sect.Add("url_attachment_proxy_type", "socks4a")
+	sect.Add("url_attachment_proxy_addr", "127.0.0.1:9050")
+	sect.Add("url_attachment_max_size", "8388608")
+	sect.Add("url_attachment_types", "image/,video/,audio/")
+	sect.Add("url_attachment_timeout", "60")
+	// take big attachments in chunks through the tus protocol at /upload, posts attach them with upload_id
+	sect.Add("resumable_uploads", "0")
+	sect.Add("resumable_upload_max_size", "67108864")
+	// bytes all uploads in progress can add up to, 0 for no limit
+	sect.Add("resumable_upload_max_total", "1073741824")
+	// seconds unfinished or unused uploads are kept
+	sect.Add("resumable_upload_expire", "86400")
+	// serve https on acme_https_bind with certificates for acme_domains from an acme ca, let's encrypt by default
+	// acme_http_bind answers http-01 challenges and redirects to https, tls-alpn-01 works on acme_https_bind
+	// bind stays plain http for tor, i2p or a local proxy so it can't be the same as either
+	sect.Add("acme", "0")
+	sect.Add("acme_domains", "")
+	sect.Add("acme_email", "")
+	sect.Add("acme_cache", "acme")
+	sect.Add("acme_directory", "")
+	sect.Add("acme_https_bind", ":443")
+	sect.Add("acme_http_bind", ":80")
+	// http-01 for the challenges above, or dns-01 for a txt record acme_dns_hook puts up,
+	// it is run as "hook present domain value" and "hook cleanup domain value"
+	sect.Add("acme_challenge", "http-01")
+	sect.Add("acme_dns_hook", "")
+	// max-age of Strict-Transport-Security on https, 0 to not send it
+	sect.Add("hsts", "31536000")
+	// gzip level for html, json and css we send, 0 to not compress
+	// static files with a .br or .gz copy next to them are sent as that instead
+	sect.Add("gzip_level", "5")
+	sect.Add("mod_nntp_login", "0")
+	// nntp logins that may log in as a mod and the mod pubkey each acts as, login=pubkey comma separated
+	// the logins need the mod role, give it with srnd tool user role login mod
+	sect.Add("mod_nntp_users", "")
+	sect.Add("mod_privkey", "")
+	// newsgroups never shown on /overboard, comma separated
+	sect.Add("overboard_exclude", "")
 	sect.Add("json-api", "0")
 	sect.Add("json-api-username", "fucking-change-this-value")
 	sect.Add("json-api-password", "seriously-fucking-change-this-value")
 	secret_bytes := nacl.RandBytes(8)
 	secret := base32.StdEncoding.EncodeToString(secret_bytes)
 	sect.Add("api-secret", secret)
+	// secret for name##password tripcodes, changing it changes every secure tripcode
+	sect.Add("tripcode_secret", hexify(nacl.RandBytes(32)))
+
+	// what to do with deletes from other nodes: honor, queue or ignore
+	// add a line per pubkey to override the default for that pubkey
+	sect = conf.NewSection("remote_deletes")
+	sect.Add("default", "honor")
+
+	// posts scoring at or above threshold are held for the mods
+	sect = conf.NewSection("spam")
+	sect.Add("enable", "0")
+	sect.Add("file", "spam.json")
+	sect.Add("threshold", "0.9")
+
+	// per newsgroup spam thresholds
+	sect = conf.NewSection("spam_thresholds")
+
+	// more than posts from one address or duplicates of one body within
+	// window seconds gets the address banned for ban seconds and the posts held for the mods
+	sect = conf.NewSection("flood")
+	sect.Add("enable", "0")
+	sect.Add("window", "60")
+	sect.Add("posts", "10")
+	sect.Add("duplicates", "3")
+	sect.Add("ban", "600")
+
+	// captcha for posting from the web frontend, type is builtin or external
+	// external uses a recaptcha style verify_url with secret and site_key
+	// policy is always, never, pow for proof of work instead or a spam score at or above which a captcha is needed
+	// a solved captcha is good for solved_posts more posts within solved_time seconds
+	sect = conf.NewSection("captcha")
+	sect.Add("type", "builtin")
+	sect.Add("verify_url", "")
+	sect.Add("secret", "")
+	sect.Add("site_key", "")
+	sect.Add("response_field", "captcha")
+	sect.Add("policy", "always")
+	sect.Add("solved_time", "0")
+	sect.Add("solved_posts", "0")
+
+	// per newsgroup captcha policy
+	sect = conf.NewSection("captcha_groups")
+
+	// proof of work needs bits leading zero bits, one more per load_posts posts to the
+	// newsgroup in the last hour and one less per reputation_posts earlier posts of the poster
+	sect = conf.NewSection("pow")
+	sect.Add("bits", "20")
+	sect.Add("min_bits", "16")
+	sect.Add("max_bits", "28")
+	sect.Add("load_posts", "50")
+	sect.Add("reputation_posts", "20")
+	sect.Add("window", "600")
+
+	// one address can post to a newsgroup once every post seconds and make
+	// threads new threads per hour, 0 to disable, mods are exempt
+	sect = conf.NewSection("cooldown")
+	sect.Add("post", "0")
+	sect.Add("threads", "0")
+
+	// per newsgroup cooldown as post,threads
+	sect = conf.NewSection("cooldown_groups")
+
+	// how many requests one address can make to the web frontend as burst,seconds
+	// a burst is allowed every that many seconds, then the address gets 429 until it's over
+	// counters are kept in the database so every frontend shares them
+	sect = conf.NewSection("ratelimit")
+	sect.Add("enable", "0")
+	sect.Add("post", "10,60")
+	sect.Add("search", "20,60")
+	sect.Add("api", "120,60")
+	// every chunk of a resumable upload counts
+	sect.Add("upload", "600,60")
+
+	// tell mods about events (report, quarantine, autoban, all if empty) by POSTing
+	// json to each of the comma separated webhooks and emailing smtp_to via smtp_addr
+	sect = conf.NewSection("notify")
+	sect.Add("enable", "0")
+	sect.Add("events", "")
+	sect.Add("webhooks", "")
+	sect.Add("smtp_addr", "")
+	sect.Add("smtp_from", "")
+	sect.Add("smtp_to", "")
+	sect.Add("smtp_user", "")
+	sect.Add("smtp_password", "")
+
+	// give the key behind each encrypted address a new one after rotate_days and forget
+	// it after purge_days, 0 to keep forever, banned addresses keep theirs
+	sect = conf.NewSection("addr_keys")
+	sect.Add("rotate_days", "0")
+	sect.Add("purge_days", "0")
+
+	// one signing key can make posts posts with attachments attachments per day,
+	// keys mods marked trusted get the trusted_ limits, 0 for no limit
+	sect = conf.NewSection("quota")
+	sect.Add("enable", "0")
+	sect.Add("posts", "100")
+	sect.Add("attachments", "50")
+	sect.Add("trusted_posts", "1000")
+	sect.Add("trusted_attachments", "500")
+
+	// check frontend posters against comma separated dnsbls as zone:action and the tor
+	// exit list, action is captcha, reject or tag, results are cached for cache_time seconds
+	sect = conf.NewSection("dnsbl")
+	sect.Add("enable", "0")
+	sect.Add("dnsbls", "")
+	sect.Add("tor_exits", "")
+	sect.Add("tor_exit_url", "https://check.torproject.org/torbulkexitlist")
+	sect.Add("cache_time", "3600")
+
+	// look up frontend posters in a maxmind geoip database, posters from the comma separated
+	// country codes in captcha need a captcha and ones in block can't post, record puts the
+	// country into posts encrypted like the address so mods can see it
+	sect = conf.NewSection("geoip")
+	sect.Add("enable", "0")
+	sect.Add("database", "GeoLite2-Country.mmdb")
+	sect.Add("captcha", "")
+	sect.Add("block", "")
+	sect.Add("record", "0")
+
+	// per newsgroup locale for readers whose browser does not ask for one
+	sect = conf.NewSection("board_locales")
+
+	// per newsgroup markup engine
+	sect = conf.NewSection("board_markup")
+
+	// show previews for links to the comma separated domains, fetched by the daemon
+	// only on the comma separated boards, or on every board if empty
+	sect = conf.NewSection("link_previews")
+	sect.Add("enable", "0")
+	sect.Add("domains", "")
+	sect.Add("boards", "")
+	sect.Add("max_age", "3600")
+	sect.Add("timeout", "10")
 
 	return conf
 }
@@ -240,6 +552,15 @@ func SaveFeeds(feeds []FeedConfig) (err error) {
 		sect.Add("username", feed.username)
 		sect.Add("password", feed.passwd)
 		sect.Add("connections", fmt.Sprintf("%d", feed.connections))
+		sect.Add("priority", fmt.Sprintf("%d", feed.priority))
+		sect.Add("keepalive", fmt.Sprintf("%d", int(feed.keepalive/time.Second)))
+		if len(feed.delete_policy) > 0 {
+			sect.Add("delete_policy", feed.delete_policy)
+		}
+		if feed.moderated_intake {
+			sect.Add("moderated_intake", "1")
+			sect.Add("trust_after", fmt.Sprintf("%d", feed.trust_after))
+		}
 		sect = conf.NewSection(feed.Name)
 		for k, v := range feed.policy.rules {
 			sect.Add(k, v)
@@ -248,8 +569,19 @@ func SaveFeeds(feeds []FeedConfig) (err error) {
 	return configparser.Save(conf, "feeds.ini")
 }
 
-// read config files
+// read config files, exits with every problem found if they can't be used
 func ReadConfig() *SRNdConfig {
+	conf, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conf
+}
+
+// read config files, the error is ConfigErrors with every problem found if they can't be used
+func LoadConfig() (*SRNdConfig, error) {
+
+	var problems []string
 
 	// begin read srnd.ini
 
@@ -264,8 +596,14 @@ func ReadConfig() *SRNdConfig {
 	var s *configparser.Section
 	conf, err := configparser.Read(fname)
 	if err != nil {
-		log.Fatal("cannot read config file ", fname)
-		return nil
+		return nil, ConfigErrors{fmt.Sprintf("cannot read %s: %s", fname, err)}
+	}
+	// SRND_SECTION_KEY wins over what is in the file, and the command line over both
+	applyConfigEnv(conf, os.Environ())
+	applyConfigFlags(conf)
+	// then secrets are read from where their _file options say
+	if err = readConfigSecrets(conf, os.Environ()); err != nil {
+		problems = append(problems, err.Error())
 	}
 	var sconf SRNdConfig
 
@@ -277,6 +615,36 @@ func ReadConfig() *SRNdConfig {
 		sconf.pprof.bind = opts["bind"]
 	}
 
+	s, err = conf.Section("api")
+	if err == nil {
+		opts := s.Options()
+		sconf.api = new(APIConfig)
+		sconf.api.srndAddr = opts["srnd"]
+		sconf.api.secret = opts["secret"]
+		sconf.api.tls = opts["tls"] == "1"
+		sconf.api.tlsCert = opts["tls_cert"]
+		sconf.api.tlsKey = opts["tls_key"]
+		sconf.api.tlsCA = opts["tls_ca"]
+	}
+
+	s, err = conf.Section("tor")
+	if err == nil {
+		opts := s.Options()
+		sconf.tor = new(TorConfig)
+		sconf.tor.enable = opts["enable"] == "1"
+		sconf.tor.control = opts["control"]
+		if sconf.tor.control == "" {
+			sconf.tor.control = "127.0.0.1:9051"
+		}
+		sconf.tor.password = opts["password"]
+		sconf.tor.keyfile = opts["keyfile"]
+		if sconf.tor.keyfile == "" {
+			sconf.tor.keyfile = "onion.key"
+		}
+		sconf.tor.hostfile = opts["hostname_file"]
+		sconf.tor.port = opts["port"]
+	}
+
 	s, err = conf.Section("crypto")
 	if err == nil {
 		opts := s.Options()
@@ -284,7 +652,7 @@ func ReadConfig() *SRNdConfig {
 		k := opts["tls-keyname"]
 		h := opts["tls-hostname"]
 		if strings.HasPrefix(h, "!") || len(h) == 0 {
-			log.Fatal("please set tls-hostname to be the hostname or ip address of your server")
+			problems = append(problems, "set tls-hostname in [crypto] to the hostname or ip address of this server")
 		} else {
 			sconf.crypto.hostname = h
 			sconf.crypto.privkey_file = k + "-" + h + ".key"
@@ -295,22 +663,27 @@ func ReadConfig() *SRNdConfig {
 		// we have no crypto section
 		log.Println("!!! we will not use encryption for nntp as no crypto section is specified in srnd.ini")
 	}
+	// sections we can't run without, what is missing from them is checked once all are read
+	missing := false
+
 	s, err = conf.Section("nntp")
 	if err != nil {
-		log.Println("no section 'nntp' in srnd.ini")
-		return nil
+		problems = append(problems, fmt.Sprintf("no [nntp] section in %s", fname))
+		missing = true
+		sconf.daemon = make(map[string]string)
+	} else {
+		sconf.daemon = s.Options()
 	}
 
-	sconf.daemon = s.Options()
-
 	s, err = conf.Section("database")
 	if err != nil {
-		log.Println("no section 'database' in srnd.ini")
-		return nil
+		problems = append(problems, fmt.Sprintf("no [database] section in %s", fname))
+		missing = true
+		sconf.database = make(map[string]string)
+	} else {
+		sconf.database = s.Options()
 	}
 
-	sconf.database = s.Options()
-
 	s, err = conf.Section("cache")
 	if err != nil {
 		log.Println("no section 'cache' in srnd.ini")
@@ -323,12 +696,13 @@ func ReadConfig() *SRNdConfig {
 
 	s, err = conf.Section("articles")
 	if err != nil {
-		log.Println("no section 'articles' in srnd.ini")
-		return nil
+		problems = append(problems, fmt.Sprintf("no [articles] section in %s", fname))
+		missing = true
+		sconf.store = make(map[string]string)
+	} else {
+		sconf.store = s.Options()
 	}
 
-	sconf.store = s.Options()
-
 	// frontend config
 
 	s, err = conf.Section("frontend")
@@ -353,44 +727,224 @@ func ReadConfig() *SRNdConfig {
 		}
 	}
 
-	// begin load feeds.ini
+	s, err = conf.Section("remote_deletes")
+	if err == nil {
+		sconf.remote_deletes = s.Options()
+	} else {
+		// honor everything by default
+		sconf.remote_deletes = make(map[string]string)
+	}
 
-	fname = "feeds.ini"
+	s, err = conf.Section("spam")
+	if err == nil {
+		sconf.spam = s.Options()
+	} else {
+		sconf.spam = make(map[string]string)
+	}
 
-	if os.Getenv("SRND_FEEDS_INI_PATH") != "" {
-		if CheckFile(os.Getenv("SRND_FEEDS_INI_PATH")) {
-			log.Printf("found feeds config at %s...", os.Getenv("SRND_FEEDS_INI_PATH"))
-			fname = os.Getenv("SRND_FEEDS_INI_PATH")
-		}
+	s, err = conf.Section("spam_thresholds")
+	if err == nil {
+		sconf.spam_thresholds = s.Options()
+	} else {
+		sconf.spam_thresholds = make(map[string]string)
 	}
 
-	confs, err := feedParse(fname)
-	if err != nil {
-		log.Fatal("failed to parse", fname, err)
+	s, err = conf.Section("flood")
+	if err == nil {
+		sconf.flood = s.Options()
+	} else {
+		sconf.flood = make(map[string]string)
 	}
 
-	sconf.feeds = append(sconf.feeds, confs...)
+	s, err = conf.Section("captcha")
+	if err == nil {
+		sconf.captcha = s.Options()
+	} else {
+		sconf.captcha = make(map[string]string)
+	}
 
-	var feeds_ok bool
-	// check for feeds option
-	fname, feeds_ok = sconf.daemon["feeds"]
+	s, err = conf.Section("captcha_groups")
+	if err == nil {
+		sconf.captcha_groups = s.Options()
+	} else {
+		sconf.captcha_groups = make(map[string]string)
+	}
 
-	if feeds_ok {
-		// load feeds dir first
-		feeds, err := filepath.Glob(filepath.Join(fname, "*.ini"))
-		if err == nil {
-			for _, f := range feeds {
-				log.Println("load feed", f)
-				confs, err := feedParse(f)
-				if err != nil {
-					log.Fatal("failed to parse feed", f, err)
-				}
-				sconf.feeds = append(sconf.feeds, confs...)
+	s, err = conf.Section("pow")
+	if err == nil {
+		sconf.pow = s.Options()
+	} else {
+		sconf.pow = make(map[string]string)
+	}
+
+	s, err = conf.Section("cooldown")
+	if err == nil {
+		sconf.cooldown = s.Options()
+	} else {
+		sconf.cooldown = make(map[string]string)
+	}
+
+	s, err = conf.Section("cooldown_groups")
+	if err == nil {
+		sconf.cooldown_groups = s.Options()
+	} else {
+		sconf.cooldown_groups = make(map[string]string)
+	}
+
+	s, err = conf.Section("ratelimit")
+	if err == nil {
+		sconf.ratelimit = s.Options()
+	} else {
+		sconf.ratelimit = make(map[string]string)
+	}
+
+	s, err = conf.Section("notify")
+	if err == nil {
+		sconf.notify = s.Options()
+	} else {
+		sconf.notify = make(map[string]string)
+	}
+
+	s, err = conf.Section("addr_keys")
+	if err == nil {
+		sconf.addr_keys = s.Options()
+	} else {
+		sconf.addr_keys = make(map[string]string)
+	}
+
+	s, err = conf.Section("quota")
+	if err == nil {
+		sconf.quota = s.Options()
+	} else {
+		sconf.quota = make(map[string]string)
+	}
+
+	s, err = conf.Section("dnsbl")
+	if err == nil {
+		sconf.dnsbl = s.Options()
+	} else {
+		sconf.dnsbl = make(map[string]string)
+	}
+
+	s, err = conf.Section("geoip")
+	if err == nil {
+		sconf.geoip = s.Options()
+	} else {
+		sconf.geoip = make(map[string]string)
+	}
+
+	s, err = conf.Section("board_locales")
+	if err == nil {
+		sconf.board_locales = s.Options()
+	} else {
+		sconf.board_locales = make(map[string]string)
+	}
+
+	s, err = conf.Section("board_markup")
+	if err == nil {
+		sconf.board_markup = s.Options()
+	} else {
+		sconf.board_markup = make(map[string]string)
+	}
+
+	s, err = conf.Section("link_previews")
+	if err == nil {
+		sconf.link_previews = s.Options()
+	} else {
+		sconf.link_previews = make(map[string]string)
+	}
+
+	var groupProblems []string
+	sconf.groups, groupProblems = groupsParse(conf)
+	problems = append(problems, groupProblems...)
+	// a captcha in a group's section wins over captcha_groups
+	for group, g := range sconf.groups {
+		if g.captcha != "" {
+			sconf.captcha_groups[group] = g.captcha
+		}
+	}
+
+	// begin load feeds.ini and the feeds directory
+
+	for _, fname = range feedFiles(sconf.daemon) {
+		log.Println("load feeds from", fname)
+		confs, err := feedParse(fname)
+		if errs, ok := err.(ConfigErrors); ok {
+			for _, p := range errs {
+				problems = append(problems, fname+": "+p)
 			}
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read %s: %s", fname, err))
 		}
+		sconf.feeds = mergeFeeds(sconf.feeds, confs, fname)
 	}
 
-	return &sconf
+	// values of the kinds we know, then what the daemon can't start without
+	for _, section := range configSections {
+		if s, err = conf.Section(section); err == nil {
+			problems = append(problems, confKindProblems(section, s.Options(), confOptionKinds[section])...)
+		}
+	}
+	if _, err := parseModNNTPUsers(sconf.frontend["mod_nntp_users"]); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if !missing {
+		var p []string
+		sconf.nntp, p = parseNNTPConfig(sconf.daemon)
+		problems = append(problems, p...)
+		sconf.db, p = parseDatabaseConfig(sconf.database)
+		problems = append(problems, p...)
+		sconf.articles, p = parseStoreConfig(sconf.store)
+		problems = append(problems, p...)
+	}
+	if len(problems) > 0 {
+		return nil, ConfigErrors(problems)
+	}
+	return &sconf, nil
+}
+
+// where feeds.ini is, SRND_FEEDS_INI_PATH if that file exists
+func feedsINIPath() string {
+	if CheckFile(os.Getenv("SRND_FEEDS_INI_PATH")) {
+		return os.Getenv("SRND_FEEDS_INI_PATH")
+	}
+	return "feeds.ini"
+}
+
+// feeds.ini and every peer file in the feeds directory, feeds in [nntp] or feeds.d next to feeds.ini
+// files starting with . are skipped so a peer file can be written under another name and renamed in place
+func feedFiles(daemon map[string]string) (files []string) {
+	files = append(files, feedsINIPath())
+	dir, ok := daemon["feeds"]
+	if !ok {
+		dir = filepath.Join(filepath.Dir(feedsINIPath()), "feeds.d")
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.ini"))
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(filepath.Base(name), ".") {
+			files = append(files, name)
+		}
+	}
+	return
+}
+
+// add the feeds of a file, a feed with a name we have already is replaced by the one loaded later
+func mergeFeeds(feeds, more []FeedConfig, fname string) []FeedConfig {
+	for _, conf := range more {
+		replaced := false
+		for idx := range feeds {
+			if feeds[idx].Name == conf.Name {
+				log.Println("feed", conf.Name, "in", fname, "replaces the one loaded before")
+				feeds[idx] = conf
+				replaced = true
+			}
+		}
+		if !replaced {
+			feeds = append(feeds, conf)
+		}
+	}
+	return feeds
 }
 
 func feedParse(fname string) (confs []FeedConfig, err error) {
@@ -406,9 +960,13 @@ func feedParse(fname string) (confs []FeedConfig, err error) {
 	var num_sections int
 	num_sections = len(sections)
 
+	// every problem with the feeds of the file, they are only loaded if there are none
+	var problems []string
+
 	if num_sections > 0 {
 		// load feeds
 		for _, sect := range sections {
+			problems = append(problems, confKindProblems(sect.Name(), sect.Options(), confFeedOptionKinds)...)
 			var fconf FeedConfig
 			// check for proxy settings
 			val := sect.ValueOf("proxy-type")
@@ -437,7 +995,35 @@ func feedParse(fname string) (confs []FeedConfig, err error) {
 			// concurrent connection count
 			fconf.connections = mapGetInt(sect.Options(), "connections", 1)
 
-			// username / password auth
+			// pull priority, 0 is the most preferred
+			fconf.priority = mapGetInt(sect.Options(), "priority", 0)
+			if fconf.priority < 0 {
+				fconf.priority = 0
+			}
+
+			// keepalive interval in seconds
+			fconf.keepalive = time.Second * time.Duration(mapGetInt(sect.Options(), "keepalive", 60))
+
+			// remote delete policy for this peer
+			fconf.delete_policy = sect.ValueOf("delete_policy")
+			if len(fconf.delete_policy) > 0 && !validRemoteDeletePolicy(fconf.delete_policy) {
+				log.Println("invalid delete_policy for", sect.Name(), fconf.delete_policy)
+				fconf.delete_policy = ""
+			}
+
+			// moderated intake for new or untrusted peers
+			fconf.moderated_intake = sect.ValueOf("moderated_intake") == "1"
+			fconf.trust_after = mapGetInt(sect.Options(), "trust_after", 0)
+
+			// username / password auth, password_file to read it from elsewhere
+			secrets, err := readSecretOptions(sect.Name(), sect.Options(), nil)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			for k, v := range secrets {
+				sect.Add(k, v)
+			}
 			fconf.username = sect.ValueOf("username")
 			fconf.passwd = sect.ValueOf("password")
 			fconf.tls_off = sect.ValueOf("disabletls") == "1"
@@ -454,7 +1040,8 @@ func feedParse(fname string) (confs []FeedConfig, err error) {
 			}
 			feed_sect, err := conf.Section(sect_name)
 			if err != nil {
-				log.Fatal("no section", sect_name, "in ", fname)
+				problems = append(problems, fmt.Sprintf("[%s] has no [%s] section with its newsgroup policy", sect.Name(), sect_name))
+				continue
 			}
 			opts := feed_sect.Options()
 			fconf.policy.rules = make(map[string]string)
@@ -464,36 +1051,26 @@ func feedParse(fname string) (confs []FeedConfig, err error) {
 			confs = append(confs, fconf)
 		}
 	}
+	if len(problems) > 0 {
+		return nil, ConfigErrors(problems)
+	}
 	return
 }
 
-// fatals on failed validation
+// fatals on failed validation with every problem found
 func (self *SRNdConfig) Validate() {
-	// check for daemon section entries
-	daemon_param := []string{"bind", "instance_name", "allow_anon", "allow_anon_attachments"}
-	for _, p := range daemon_param {
-		_, ok := self.daemon[p]
-		if !ok {
-			log.Fatalf("in section [nntp], no parameter '%s' provided", p)
-		}
-	}
-
-	// check validity of store directories
-	store_dirs := []string{"store", "incoming", "attachments", "thumbs"}
-	for _, d := range store_dirs {
-		k := d + "_dir"
-		_, ok := self.store[k]
-		if !ok {
-			log.Fatalf("in section [store], no parameter '%s' provided", k)
-		}
+	if problems := self.Problems(); len(problems) > 0 {
+		log.Fatal(ConfigErrors(problems))
 	}
+}
 
-	// check database parameters existing
-	db_param := []string{"host", "port", "user", "password", "type", "schema"}
-	for _, p := range db_param {
-		_, ok := self.database[p]
-		if !ok {
-			log.Fatalf("in section [database], no parameter '%s' provided", p)
-		}
-	}
+// what is missing or wrong in [nntp], [database] and [articles], empty if nothing is
+func (self *SRNdConfig) Problems() (problems []string) {
+	_, p := parseNNTPConfig(self.daemon)
+	problems = append(problems, p...)
+	_, p = parseDatabaseConfig(self.database)
+	problems = append(problems, p...)
+	_, p = parseStoreConfig(self.store)
+	problems = append(problems, p...)
+	return
 }
@@ -0,0 +1,90 @@
+//
+// config_env.go -- overriding options of srnd.ini with environment variables
+//
+
+package srnd
+
+import (
+	"github.com/majestrate/configparser"
+	"log"
+	"strings"
+)
+
+// every section of srnd.ini we read, the environment can set options in any of them
+var configSections = []string{
+	"pprof", "api", "tor", "crypto", "nntp", "database", "cache", "articles", "frontend",
+	"remote_deletes", "spam", "spam_thresholds", "flood", "captcha", "captcha_groups",
+	"pow", "cooldown", "cooldown_groups", "ratelimit", "notify", "addr_keys", "quota",
+	"dnsbl", "geoip", "board_locales", "board_markup", "link_previews",
+}
+
+// uppercase with everything but letters and digits as _
+func configEnvPart(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+// the environment variable that overrides an option in srnd.ini, SRND_DATABASE_PASSWORD for password in [database]
+func configEnvName(section, key string) string {
+	return "SRND_" + configEnvPart(section) + "_" + configEnvPart(key)
+}
+
+// the options of a section set in environ, key -> value
+// a variable goes to the section with the longest name that fits, so SRND_SPAM_THRESHOLDS_X is not x in [spam]
+// keys already in opts or in confOptionKinds keep their name, any other key is taken lowercased
+func configEnvOverrides(section string, opts map[string]string, environ []string) map[string]string {
+	prefix := "SRND_" + configEnvPart(section) + "_"
+	keys := make(map[string]string)
+	for key := range confOptionKinds[section] {
+		keys[configEnvName(section, key)] = key
+	}
+	for key := range opts {
+		keys[configEnvName(section, key)] = key
+	}
+	overrides := make(map[string]string)
+	for _, kv := range environ {
+		idx := strings.Index(kv, "=")
+		if idx < 0 || !strings.HasPrefix(kv[:idx], prefix) || len(kv[:idx]) == len(prefix) {
+			continue
+		}
+		name := kv[:idx]
+		longer := false
+		for _, other := range configSections {
+			p := "SRND_" + configEnvPart(other) + "_"
+			longer = longer || (len(p) > len(prefix) && strings.HasPrefix(name, p))
+		}
+		if longer {
+			continue
+		}
+		key, ok := keys[name]
+		if !ok {
+			key = strings.ToLower(name[len(prefix):])
+		}
+		overrides[key] = kv[idx+1:]
+	}
+	return overrides
+}
+
+// set the options of srnd.ini that environ overrides, adding sections that are not in the file
+func applyConfigEnv(conf *configparser.Configuration, environ []string) {
+	for _, section := range configSections {
+		var opts map[string]string
+		s, err := conf.Section(section)
+		if err == nil {
+			opts = s.Options()
+		} else {
+			s = nil
+		}
+		for key, value := range configEnvOverrides(section, opts, environ) {
+			if s == nil {
+				s = conf.NewSection(section)
+			}
+			s.Add(key, value)
+			log.Printf("%s in [%s] set from %s", key, section, configEnvName(section, key))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package srnd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigEnvOverrides(t *testing.T) {
+
+	environ := []string{
+		"SRND_DATABASE_PASSWORD=hunter2",
+		"SRND_CRYPTO_TLS_HOSTNAME=test.tld",
+		"SRND_SPAM_THRESHOLDS_OVERCHAN_TEST=5",
+		"SRND_INI_PATH=/etc/srnd.ini",
+		"SRND_DATABASE_=nothing",
+	}
+	if o := configEnvOverrides("database", nil, environ); len(o) != 1 || o["password"] != "hunter2" {
+		t.Error("bad [database] overrides", o)
+	}
+	if o := configEnvOverrides("crypto", nil, environ); o["tls-hostname"] != "test.tld" {
+		t.Error("known key not matched", o)
+	}
+	if o := configEnvOverrides("spam", nil, environ); len(o) != 0 {
+		t.Error("[spam_thresholds] taken for [spam]", o)
+	}
+	if o := configEnvOverrides("spam_thresholds", map[string]string{"overchan.test": "3"}, environ); o["overchan.test"] != "5" {
+		t.Error("key in the file not matched", o)
+	}
+
+	ini, _ := parseINI("srnd.ini", strings.NewReader("[database]\ntype = postgres\npassword = secret\n"))
+	ini.applyOverrides(environ)
+	if opt := ini.Get("database", "password"); opt == nil || opt.Value != "hunter2" || opt.Where() != "SRND_DATABASE_PASSWORD" {
+		t.Error("password not overridden", opt)
+	}
+	if _, ok := ini.Sections["crypto"]; !ok || ini.Map("crypto")["tls-hostname"] != "test.tld" {
+		t.Error("section only in the environment not added")
+	}
+
+}
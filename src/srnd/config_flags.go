@@ -0,0 +1,126 @@
+//
+// config_flags.go -- command line flags that override srnd.ini
+//
+
+package srnd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/majestrate/configparser"
+	"strings"
+)
+
+// options set on the command line, section -> key -> value
+// they win over the environment, which wins over srnd.ini
+var configFlags = make(map[string]map[string]string)
+
+// flags of their own for the options most often set on the command line, anything else goes through -set
+// no secrets, the command line of a process is visible to every user on the host
+var configFlagOptions = []struct {
+	flag, section, key, usage string
+}{
+	{"bind", "nntp", "bind", "address to take nntp connections on"},
+	{"instance-name", "nntp", "instance_name", "name of this instance"},
+	{"loglevel", "nntp", "log_level", "debug, info or error"},
+	{"db-type", "database", "type", "postgres or redis"},
+	{"db-host", "database", "host", "database host or socket directory"},
+	{"db-port", "database", "port", "database port"},
+	{"db-user", "database", "user", "database user"},
+	{"db-schema", "database", "schema", "database schema"},
+	{"store-dir", "articles", "store_dir", "directory articles are stored in"},
+	{"incoming-dir", "articles", "incoming_dir", "directory articles come in to"},
+	{"attachments-dir", "articles", "attachments_dir", "directory attachments are stored in"},
+	{"thumbs-dir", "articles", "thumbs_dir", "directory thumbnails are stored in"},
+	{"frontend-bind", "frontend", "bind", "address the web frontend listens on"},
+	{"webroot", "frontend", "webroot", "directory the web frontend renders to"},
+}
+
+// -set section.key=value, given as many times as needed
+type configFlagValues []string
+
+func (self *configFlagValues) String() string {
+	return strings.Join(*self, " ")
+}
+
+func (self *configFlagValues) Set(v string) error {
+	section, key, value, err := parseConfigFlag(v)
+	if err == nil {
+		setConfigFlag(section, key, value)
+		*self = append(*self, v)
+	}
+	return err
+}
+
+// split section.key=value, a [group "name"] section is given as group "name".key=value
+func parseConfigFlag(v string) (section, key, value string, err error) {
+	eq := strings.Index(v, "=")
+	if eq < 0 {
+		err = errors.New("expected section.key=value, got " + v)
+		return
+	}
+	name := v[:eq]
+	value = v[eq+1:]
+	dot := strings.Index(name, ".")
+	if strings.HasPrefix(name, `group "`) {
+		if end := strings.Index(name, `".`); end > 0 {
+			dot = end + 1
+		}
+	}
+	if dot < 1 || dot == len(name)-1 {
+		err = errors.New("expected section.key=value, got " + v)
+		return
+	}
+	section, key = name[:dot], name[dot+1:]
+	return
+}
+
+func setConfigFlag(section, key, value string) {
+	if configFlags[section] == nil {
+		configFlags[section] = make(map[string]string)
+	}
+	configFlags[section][key] = value
+}
+
+// parse the flags of a command that override srnd.ini, they are used every time the config is read after this
+// returns the arguments after the flags
+func ParseConfigFlags(name string, args []string) []string {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, opt := range configFlagOptions {
+		flags.String(opt.flag, "", fmt.Sprintf("%s, %s in [%s]", opt.usage, opt.key, opt.section))
+	}
+	var set configFlagValues
+	flags.Var(&set, "set", "section.key=value to set any option in srnd.ini, can be given more than once")
+	flags.Parse(args)
+	flags.Visit(func(f *flag.Flag) {
+		for _, opt := range configFlagOptions {
+			if opt.flag == f.Name {
+				setConfigFlag(opt.section, opt.key, f.Value.String())
+			}
+		}
+	})
+	return flags.Args()
+}
+
+// the options of a section the environment and the command line set, the command line wins
+func configOverrides(section string, opts map[string]string, environ []string) map[string]string {
+	overrides := configEnvOverrides(section, opts, environ)
+	for key, value := range configFlags[section] {
+		overrides[key] = value
+	}
+	return overrides
+}
+
+// set the options of srnd.ini the command line sets, adding sections that are not in the file
+func applyConfigFlags(conf *configparser.Configuration) {
+	for section, opts := range configFlags {
+		s, err := conf.Section(section)
+		if err != nil {
+			s = conf.NewSection(section)
+		}
+		for key, value := range opts {
+			s.Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package srnd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConfigFlags(t *testing.T) {
+
+	section, key, value, err := parseConfigFlag(`group "overchan.random".captcha=always`)
+	if err != nil || section != `group "overchan.random"` || key != "captcha" || value != "always" {
+		t.Error("bad group flag", section, key, value, err)
+	}
+	section, key, value, err = parseConfigFlag("nntp.bind=[::]:1119")
+	if err != nil || section != "nntp" || key != "bind" || value != "[::]:1119" {
+		t.Error("bad flag", section, key, value, err)
+	}
+	for _, v := range []string{"nntp", "nntp.bind", ".bind=x", "nntp.=x"} {
+		if _, _, _, err = parseConfigFlag(v); err == nil {
+			t.Error("took", v)
+		}
+	}
+
+	defer func() { configFlags = make(map[string]map[string]string) }()
+	args := ParseConfigFlags("run", []string{"-bind", "127.0.0.1:1119", "-set", "database.host=db", "extra"})
+	if len(args) != 1 || args[0] != "extra" {
+		t.Error("bad arguments left", args)
+	}
+	environ := []string{"SRND_NNTP_BIND=0.0.0.0:119", "SRND_NNTP_INSTANCE_NAME=test.srndv2"}
+	overrides := configOverrides("nntp", map[string]string{"bind": "[::]:119"}, environ)
+	if overrides["bind"] != "127.0.0.1:1119" || overrides["instance_name"] != "test.srndv2" {
+		t.Error("flags do not win over the environment", overrides)
+	}
+	if overrides = configOverrides("database", nil, nil); overrides["host"] != "db" {
+		t.Error("-set not applied", overrides)
+	}
+
+	var buf bytes.Buffer
+	w := errorLogWriter{&buf}
+	w.Write([]byte("connected to peer\n"))
+	w.Write([]byte("failed to connect to peer\n"))
+	if buf.String() != "failed to connect to peer\n" {
+		t.Error("bad error log", buf.String())
+	}
+
+}
@@ -0,0 +1,46 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigProblems(t *testing.T) {
+
+	conf := &SRNdConfig{
+		daemon:   map[string]string{"bind": "[::]:1119", "instance_name": "test.tld", "allow_anon": "0", "allow_anon_attachments": "0"},
+		store:    map[string]string{"store_dir": "articles", "incoming_dir": "incoming", "attachments_dir": "att", "thumbs_dir": "thm"},
+		database: map[string]string{"host": "localhost", "port": "5432", "user": "srnd", "password": "", "type": "postgres", "schema": "srnd"},
+	}
+	if p := conf.Problems(); len(p) != 0 {
+		t.Error("complete config has problems", p)
+	}
+	delete(conf.store, "thumbs_dir")
+	if p := conf.Problems(); len(p) != 1 || !strings.Contains(p[0], "thumbs_dir") {
+		t.Error("missing thumbs_dir not found", p)
+	}
+
+}
+
+func TestFeedFiles(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	for _, name := range []string{"b.ini", "a.ini", ".a.ini", "notes.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+	files := feedFiles(map[string]string{"feeds": dir})
+	want := []string{feedsINIPath(), filepath.Join(dir, "a.ini"), filepath.Join(dir, "b.ini")}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("got feed files %q, want %q", files, want)
+	}
+
+	feeds := mergeFeeds(nil, []FeedConfig{{Name: "a", Addr: "a.tld:119"}, {Name: "b"}}, "feeds.ini")
+	feeds = mergeFeeds(feeds, []FeedConfig{{Name: "a", Addr: "other.tld:119"}}, "a.ini")
+	if len(feeds) != 2 || feeds[0].Addr != "other.tld:119" {
+		t.Error("feed in feeds.d did not replace the one in feeds.ini", feeds)
+	}
+
+}
@@ -0,0 +1,182 @@
+//
+// config_validate.go -- checking the config srnd starts with, every problem is reported at once
+//
+
+package srnd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// every problem found loading the config, so they can all be fixed before starting again
+type ConfigErrors []string
+
+func (self ConfigErrors) Error() string {
+	var b strings.Builder
+	if len(self) == 1 {
+		b.WriteString("the config has a problem:\n")
+	} else {
+		fmt.Fprintf(&b, "the config has %d problems:\n", len(self))
+	}
+	for _, p := range self {
+		fmt.Fprintf(&b, "  %s\n", p)
+	}
+	b.WriteString("fix them and start again, srnd checkconf shows where every option comes from")
+	return b.String()
+}
+
+// [nntp] checked, the numbers srnd reads from it with their defaults
+type NNTPConfig struct {
+	bind             string
+	instance_name    string
+	article_lifetime int
+	cycle_replies    int
+	feeds_reload     int
+}
+
+// [database] checked, what srnd connects to
+type DatabaseConfig struct {
+	kind   string
+	schema string
+	host   string
+	// 0 for the default one, or a unix socket in host
+	port int
+	user string
+}
+
+// the port to connect to, empty for the default
+func (self DatabaseConfig) Port() string {
+	if self.port == 0 {
+		return ""
+	}
+	return strconv.Itoa(self.port)
+}
+
+// [articles] checked, where the store keeps things and for how long
+type StoreConfig struct {
+	store_dir       string
+	incoming_dir    string
+	attachments_dir string
+	thumbs_dir      string
+	trash_hours     int
+}
+
+// problem for each option of keys a section does not have
+func confRequire(section string, opts map[string]string, keys ...string) (problems []string) {
+	for _, key := range keys {
+		if _, ok := opts[key]; !ok {
+			problems = append(problems, fmt.Sprintf("in section [%s], no parameter '%s' provided", section, key))
+		}
+	}
+	return
+}
+
+// a number of an option like mapGetInt, but a negative one is a problem
+// not a number is left to the kind of the option, checked with the rest
+func confNonNegative(section string, opts map[string]string, key string, fallback int, problems *[]string) int {
+	n := mapGetInt(opts, key, fallback)
+	if n < 0 {
+		*problems = append(*problems, fmt.Sprintf("%s in [%s] is %d, it should not be negative", key, section, n))
+	}
+	return n
+}
+
+// the problems here are the ones checkconf can't see from the options alone, kinds are checked apart
+func parseNNTPConfig(opts map[string]string) (conf NNTPConfig, problems []string) {
+	problems = confRequire("nntp", opts, "bind", "instance_name", "allow_anon", "allow_anon_attachments")
+	conf.bind = opts["bind"]
+	conf.instance_name = opts["instance_name"]
+	if _, ok := opts["instance_name"]; ok && strings.TrimSpace(conf.instance_name) == "" {
+		problems = append(problems, "instance_name in [nntp] is empty, set it to the name of this node")
+	}
+	conf.article_lifetime = confNonNegative("nntp", opts, "article_lifetime", 0, &problems)
+	conf.cycle_replies = confNonNegative("nntp", opts, "cycle_replies", 300, &problems)
+	conf.feeds_reload = confNonNegative("nntp", opts, "feeds_reload", 0, &problems)
+	return
+}
+
+// the schema each database type takes
+var databaseSchemas = map[string]string{
+	"postgres": "srnd",
+	"redis":    "single",
+}
+
+func parseDatabaseConfig(opts map[string]string) (conf DatabaseConfig, problems []string) {
+	problems = confRequire("database", opts, "host", "port", "user", "password", "type", "schema")
+	conf.kind = opts["type"]
+	conf.schema = opts["schema"]
+	conf.host = opts["host"]
+	conf.user = opts["user"]
+	// no port is the default one, or a unix socket in host
+	if v := opts["port"]; v != "" {
+		var err error
+		conf.port, err = strconv.Atoi(v)
+		if err != nil || conf.port < 1 || conf.port > 65535 {
+			problems = append(problems, fmt.Sprintf("port in [database] is %q, it should be a port from 1 to 65535", v))
+		}
+	}
+	_, schemaSet := opts["schema"]
+	if schema, ok := databaseSchemas[conf.kind]; ok && schemaSet && conf.schema != schema {
+		problems = append(problems, fmt.Sprintf("schema in [database] is %q, a %s database takes schema %s", conf.schema, conf.kind, schema))
+	}
+	if conf.kind == "redis" && !RedisEnabled() {
+		problems = append(problems, "type in [database] is redis but this srnd is built without redis, use postgres or build it with redis")
+	}
+	return
+}
+
+// a directory the store makes if it is not there, if it is a file the store would remove it to make it
+func confStoreDir(key, dir string) string {
+	if strings.TrimSpace(dir) == "" {
+		return fmt.Sprintf("%s in [articles] is empty, set it to a directory", key)
+	}
+	if st, err := os.Stat(dir); err == nil && !st.IsDir() {
+		return fmt.Sprintf("%s in [articles] is %q which is a file, it should be a directory", key, dir)
+	}
+	return ""
+}
+
+func parseStoreConfig(opts map[string]string) (conf StoreConfig, problems []string) {
+	problems = confRequire("articles", opts, "store_dir", "incoming_dir", "attachments_dir", "thumbs_dir")
+	conf.store_dir = opts["store_dir"]
+	conf.incoming_dir = opts["incoming_dir"]
+	conf.attachments_dir = opts["attachments_dir"]
+	conf.thumbs_dir = opts["thumbs_dir"]
+	for _, key := range []string{"store_dir", "incoming_dir", "attachments_dir", "thumbs_dir"} {
+		if v, ok := opts[key]; ok {
+			if msg := confStoreDir(key, v); msg != "" {
+				problems = append(problems, msg)
+			}
+		}
+	}
+	conf.trash_hours = confNonNegative("articles", opts, "trash_hours", 24, &problems)
+	return
+}
+
+// check the options of a section against what we know of them, like checkconf does for the files
+// paths are left to checkconf and doctor, the store makes its directories and tools can be missing
+func confKindProblems(section string, opts map[string]string, kinds map[string]string) (problems []string) {
+	var keys []string
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		kind, ok := kinds[key]
+		if !ok || kind == confDir || kind == confFile {
+			continue
+		}
+		value := opts[key]
+		if value == "" && kind == confAddr {
+			continue
+		}
+		if msg := confCheckValue(kind, value); msg != "" {
+			problems = append(problems, fmt.Sprintf("%s in [%s] is %q, it %s", key, section, value, msg))
+		}
+	}
+	return
+}
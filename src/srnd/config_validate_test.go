@@ -0,0 +1,43 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+
+	_, problems := parseDatabaseConfig(map[string]string{"host": "localhost", "port": "99999", "user": "srnd", "password": "", "type": "postgres", "schema": "single"})
+	if len(problems) != 2 || !strings.Contains(problems[0], "port") || !strings.Contains(problems[1], "schema") {
+		t.Error("expected problems with port and schema, got", problems)
+	}
+	db, problems := parseDatabaseConfig(map[string]string{"host": "/var/run/postgresql", "port": "", "user": "", "password": "", "type": "postgres", "schema": "srnd"})
+	if len(problems) != 0 || db.Port() != "" {
+		t.Error("socket database has problems", problems, db)
+	}
+
+	dir, done := testDir(t)
+	defer done()
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, []byte("x"), 0600)
+	store, problems := parseStoreConfig(map[string]string{"store_dir": dir, "incoming_dir": file, "attachments_dir": "", "thumbs_dir": filepath.Join(dir, "thm"), "trash_hours": "-1"})
+	if len(problems) != 3 || store.trash_hours != -1 {
+		t.Error("expected problems with incoming_dir, attachments_dir and trash_hours, got", problems)
+	}
+	nntp, _ := parseNNTPConfig(map[string]string{})
+	if nntp.cycle_replies != 300 || nntp.article_lifetime != 0 {
+		t.Error("bad defaults", nntp)
+	}
+
+	problems = confKindProblems("nntp", map[string]string{"log_level": "loud", "bind": "[::]:70000", "allow_anon": "1"}, confOptionKinds["nntp"])
+	if len(problems) != 2 {
+		t.Error("expected problems with bind and log_level, got", problems)
+	}
+	report := ConfigErrors(problems).Error()
+	if !strings.Contains(report, "2 problems") || !strings.Contains(report, "log_level") || !strings.Contains(report, "bind") {
+		t.Error("bad report", report)
+	}
+
+}
@@ -0,0 +1,153 @@
+//
+// cooldown.go -- per newsgroup limits on how fast one address can post
+//
+
+package srnd
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var PostCooldown = errors.New("you are posting too fast, try again later")
+var ThreadCooldown = errors.New("you made too many threads recently, try again later")
+
+// limits for one newsgroup
+type cooldownLimits struct {
+	// time between posts from one address, 0 to disable
+	post time.Duration
+	// new threads per hour from one address, 0 to disable
+	threads int
+}
+
+// parse "post seconds,threads per hour"
+func parseCooldownLimits(str string, fallback cooldownLimits) (limits cooldownLimits) {
+	limits = fallback
+	parts := strings.Split(str, ",")
+	if len(parts) > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err == nil {
+			limits.post = time.Duration(n) * time.Second
+		}
+	}
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err == nil {
+			limits.threads = n
+		}
+	}
+	return
+}
+
+type postCooldown struct {
+	access sync.Mutex
+	// limits for newsgroups without their own
+	fallback cooldownLimits
+	// newsgroup -> limits
+	groups map[string]cooldownLimits
+	// newsgroup and address -> last post
+	last map[string]time.Time
+	// newsgroup and address -> threads made in the last hour
+	threads map[string][]time.Time
+	// last time we expired everything
+	expired time.Time
+}
+
+func newPostCooldown(fallback cooldownLimits, groups map[string]cooldownLimits) *postCooldown {
+	return &postCooldown{
+		fallback: fallback,
+		groups:   groups,
+		last:     make(map[string]time.Time),
+		threads:  make(map[string][]time.Time),
+	}
+}
+
+// get the limits for a newsgroup
+func (self *postCooldown) Limits(newsgroup string) cooldownLimits {
+	limits, ok := self.groups[newsgroup]
+	if ok {
+		return limits
+	}
+	return self.fallback
+}
+
+// drop thread times older than an hour
+func recentThreads(times []time.Time, now time.Time) []time.Time {
+	idx := 0
+	for idx < len(times) && now.Sub(times[idx]) >= time.Hour {
+		idx++
+	}
+	return times[idx:]
+}
+
+// check if addr may post to newsgroup now and remember that it did if so
+func (self *postCooldown) Allow(newsgroup, addr string, thread bool, now time.Time) error {
+	if self == nil {
+		return nil
+	}
+	limits := self.Limits(newsgroup)
+	if limits.post <= 0 && limits.threads <= 0 {
+		return nil
+	}
+	key := newsgroup + " " + addr
+	self.access.Lock()
+	defer self.access.Unlock()
+	if now.Sub(self.expired) >= time.Hour {
+		self.expire(now)
+	}
+	if limits.post > 0 {
+		t, ok := self.last[key]
+		if ok && now.Sub(t) < limits.post {
+			return PostCooldown
+		}
+	}
+	if thread && limits.threads > 0 {
+		times := recentThreads(self.threads[key], now)
+		if len(times) >= limits.threads {
+			return ThreadCooldown
+		}
+		self.threads[key] = append(times, now)
+	}
+	self.last[key] = now
+	return nil
+}
+
+// forget everything that no longer limits anyone, must hold the lock
+func (self *postCooldown) expire(now time.Time) {
+	self.expired = now
+	for k, t := range self.last {
+		// no newsgroup waits longer than an hour between posts
+		if now.Sub(t) >= time.Hour {
+			delete(self.last, k)
+		}
+	}
+	for k, times := range self.threads {
+		times = recentThreads(times, now)
+		if len(times) == 0 {
+			delete(self.threads, k)
+		} else {
+			self.threads[k] = times
+		}
+	}
+}
+
+// create the posting cooldown from config, nil if no newsgroup has one
+func postCooldownFromConfig(conf *SRNdConfig) *postCooldown {
+	fallback := cooldownLimits{
+		post:    time.Duration(mapGetInt(conf.cooldown, "post", 0)) * time.Second,
+		threads: mapGetInt(conf.cooldown, "threads", 0),
+	}
+	groups := make(map[string]cooldownLimits)
+	for group, str := range conf.cooldown_groups {
+		groups[group] = parseCooldownLimits(str, fallback)
+	}
+	if fallback.post <= 0 && fallback.threads <= 0 && len(groups) == 0 {
+		return nil
+	}
+	log.Printf("posting cooldown enabled, post=%s threads=%d per hour, %d newsgroups with their own", fallback.post, fallback.threads, len(groups))
+	return newPostCooldown(fallback, groups)
+}
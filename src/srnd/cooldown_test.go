@@ -0,0 +1,38 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostCooldown(t *testing.T) {
+
+	c := newPostCooldown(cooldownLimits{post: time.Minute}, map[string]cooldownLimits{
+		"overchan.slow": parseCooldownLimits("0,2", cooldownLimits{}),
+	})
+	now := time.Now()
+	if c.Allow("overchan.test", "addr", false, now) != nil {
+		t.Error("first post not allowed")
+	}
+	if c.Allow("overchan.test", "addr", false, now.Add(time.Second)) != PostCooldown {
+		t.Error("post within cooldown allowed")
+	}
+	if c.Allow("overchan.test", "other", false, now.Add(time.Second)) != nil {
+		t.Error("cooldown applied to another address")
+	}
+	if c.Allow("overchan.test", "addr", false, now.Add(time.Minute)) != nil {
+		t.Error("post after cooldown not allowed")
+	}
+	for i := 0; i < 2; i++ {
+		if c.Allow("overchan.slow", "addr", true, now) != nil {
+			t.Error("thread under the limit not allowed")
+		}
+	}
+	if c.Allow("overchan.slow", "addr", true, now) != ThreadCooldown {
+		t.Error("thread over the limit allowed")
+	}
+	if c.Allow("overchan.slow", "addr", true, now.Add(time.Hour)) != nil {
+		t.Error("thread an hour later not allowed")
+	}
+
+}
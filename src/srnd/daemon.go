@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,6 +25,30 @@ import (
 type feedState struct {
 	Config FeedConfig
 	Paused bool
+	// health counters for this feed
+	Stats *feedStats `json:"-"`
+	// guards Config.policy, it changes while the feed runs
+	policy_access sync.RWMutex
+}
+
+// the config of this feed with the policy it has now
+func (self *feedState) config() FeedConfig {
+	self.policy_access.RLock()
+	defer self.policy_access.RUnlock()
+	return self.Config
+}
+
+func (self *feedState) setPolicy(policy FeedPolicy) {
+	self.policy_access.Lock()
+	self.Config.policy = policy
+	self.policy_access.Unlock()
+}
+
+// a request to pull an article from our feeds
+type articleRequest struct {
+	entry ArticleEntry
+	// only ask feeds with a priority worse than this, -1 for all feeds
+	after int
 }
 
 // the status of a feed that we are persisting
@@ -74,18 +99,22 @@ type NNTPDaemon struct {
 	mod           ModEngine
 	expire        ExpirationCore
 	listener      net.Listener
+	tor           *torController
+	spam          *spamFilter
+	notify        *modNotifier
 	debug         bool
 	sync_on_start bool
-	// anon settings
-	allow_anon             bool
-	allow_anon_attachments bool
-
-	// do we allow attachments from remote?
-	allow_attachments bool
+	// anon, attachment and rejected article settings, replaced on reload
+	posting_mtx sync.RWMutex
+	posting     postingConfig
 
 	running bool
 	// http frontend
 	frontend Frontend
+	// serves split frontends on [api] srnd
+	rpc *rpcServer
+	// srnd when we are a split frontend, nil when we are srnd
+	remote *rpcClient
 
 	//cache driver
 	cache CacheInterface
@@ -112,8 +141,8 @@ type NNTPDaemon struct {
 	infeed_load chan string
 	// channel for broadcasting a message to all feeds given their newsgroup, message_id
 	send_all_feeds chan ArticleEntry
-	// channel for broadcasting an ARTICLE command to all feeds in reader mode
-	ask_for_article chan ArticleEntry
+	// channel for broadcasting an ARTICLE command to feeds in reader mode
+	ask_for_article chan articleRequest
 	// operation of daemon done after sending bool down this channel
 	done chan bool
 
@@ -122,23 +151,74 @@ type NNTPDaemon struct {
 	send_articles_mtx sync.RWMutex
 	send_articles     []ArticleEntry
 	ask_articles_mtx  sync.RWMutex
-	ask_articles      []ArticleEntry
+	ask_articles      []articleRequest
 
 	pump_ticker       *time.Ticker
 	expiration_ticker *time.Ticker
-	article_lifetime  time.Duration
+	// hours articles are kept, 0 for forever, atomic since a reload changes it
+	article_lifetime int64
+	// hours deleted articles stay in the trash, 0 for forever, atomic like article_lifetime
+	trash_hours int64
+	// one reload at a time, SIGHUP and changed feed files both reload
+	reload_mtx sync.Mutex
+	// newsgroup -> its [group "name"] section, replaced on reload
+	groups_mtx sync.RWMutex
+	groups     map[string]GroupConfig
+	// guards conf.feeds, replaced on reload
+	feeds_mtx sync.RWMutex
+}
+
+// what articles from anons and with attachments we take
+type postingConfig struct {
+	allow_anon             bool
+	allow_anon_attachments bool
+	// do we allow attachments from remote?
+	allow_attachments bool
+	// do we keep rejected articles for the mods?
+	keep_rejected bool
+}
+
+func postingConfigFrom(opts map[string]string) postingConfig {
+	return postingConfig{
+		allow_anon:             opts["allow_anon"] == "1",
+		allow_anon_attachments: opts["allow_anon_attachments"] == "1",
+		allow_attachments:      opts["allow_attachments"] == "1",
+		keep_rejected:          opts["keep_rejected"] == "1",
+	}
+}
+
+// the posting settings we have now
+func (self *NNTPDaemon) postingConfig() postingConfig {
+	self.posting_mtx.RLock()
+	defer self.posting_mtx.RUnlock()
+	return self.posting
+}
+
+// every feed in the config, not to be modified
+func (self *NNTPDaemon) feedConfigs() []FeedConfig {
+	self.feeds_mtx.RLock()
+	defer self.feeds_mtx.RUnlock()
+	return self.conf.feeds
 }
 
 func (self NNTPDaemon) End() {
 	if self.listener != nil {
 		self.listener.Close()
 	}
+	if self.tor != nil {
+		self.tor.Close()
+	}
 	if self.database != nil {
 		self.database.Close()
 	}
 	if self.cache != nil {
 		self.cache.Close()
 	}
+	if self.rpc != nil {
+		self.rpc.Close()
+	}
+	// what it learned since the last save
+	self.spam.Flush()
 	self.done <- true
 }
 
@@ -286,7 +366,7 @@ func (self *NNTPDaemon) storeFeedsConfig() (err error) {
 	feeds := self.activeFeeds()
 	var feedconfigs []FeedConfig
 	for _, status := range feeds {
-		feedconfigs = append(feedconfigs, status.State.Config)
+		feedconfigs = append(feedconfigs, status.State.config())
 	}
 	err = SaveFeeds(feedconfigs)
 	return
@@ -320,10 +400,14 @@ func (self *NNTPDaemon) modifyFeedPolicy(feedname string, policy FeedPolicy) (er
 // remove a persisted feed from the daemon
 // does not modify feeds.ini
 func (self *NNTPDaemon) removeFeed(feedname string) (err error) {
+	if self.remote != nil {
+		return FeedsOnSrnd
+	}
+	// get its connections while it is still registered
+	status := self.getFeedStatus(feedname)
 	// deregister feed first so it doesn't reconnect immediately
 	self.deregister_feed <- feedname
 	// deregister all connections for this feed
-	status := self.getFeedStatus(feedname)
 	for _, nntp := range status.Conns {
 		go nntp.QuitAndWait()
 	}
@@ -344,12 +428,19 @@ func (self *NNTPDaemon) getFeedStatus(feedname string) (status *feedStatus) {
 // add a feed to be persisted by the daemon
 // does not modify feeds.ini
 func (self *NNTPDaemon) addFeed(conf FeedConfig) (err error) {
+	if self.remote != nil {
+		return FeedsOnSrnd
+	}
 	self.register_feed <- conf
 	return
 }
 
 // get an immutable list of all active feeds
 func (self *NNTPDaemon) activeFeeds() (feeds []*feedStatus) {
+	if self.remote != nil {
+		// srnd has them
+		return
+	}
 	chnl := make(chan []*feedStatus)
 	// query feeds
 	self.get_feeds <- chnl
@@ -360,15 +451,30 @@ func (self *NNTPDaemon) activeFeeds() (feeds []*feedStatus) {
 	return
 }
 
-func (self *NNTPDaemon) persistFeed(conf FeedConfig, mode string, n int) {
+// get a health report for every active feed
+func (self *NNTPDaemon) feedHealth() (reports []feedHealth) {
+	if self.remote != nil {
+		if err := self.remote.Call("feeds", nil, &reports); err != nil {
+			log.Println("failed to get feeds from srnd", err)
+		}
+		return
+	}
+	for _, status := range self.activeFeeds() {
+		reports = append(reports, status.Health())
+	}
+	return
+}
+
+func (self *NNTPDaemon) persistFeed(state *feedState, mode string, n int) {
+	conf := state.config()
 	log.Println(conf.Name, "persisting in", mode, "mode")
 	backoff := time.Second
 	for {
 		if self.running {
 			// get the status of this feed
 			status := self.getFeedStatus(conf.Name)
-			if !status.Exists {
-				// our feed was removed
+			if !status.Exists || status.State != state {
+				// our feed was removed, or replaced by a reload
 				// let's die
 				log.Println(conf.Name, "ended", mode, "mode")
 				return
@@ -383,15 +489,22 @@ func (self *NNTPDaemon) persistFeed(conf FeedConfig, mode string, n int) {
 			// do we want to do a pull based sync?
 
 			if mode == "sync" {
-				// yeh, do it
-				self.syncPull(conf.proxy_type, conf.proxy_addr, conf.Addr)
+				better := self.preferredFeed(conf)
+				if better == "" {
+					// yeh, do it
+					self.syncPull(conf.proxy_type, conf.proxy_addr, conf.Addr)
+				} else {
+					log.Println(conf.Name, "skipping sync, preferring", better)
+				}
 				// sleep for the sleep interval and continue
 				log.Println(conf.Name, "waiting for", conf.sync_interval, "before next sync")
 				time.Sleep(conf.sync_interval)
 				continue
 			}
+			stats := status.State.Stats
 			conn, err := self.dialOut(conf.proxy_type, conf.proxy_addr, conf.Addr)
 			if err != nil {
+				stats.Error(err)
 				log.Println(conf.Name, "failed to dial out", err.Error())
 				log.Println(conf.Name, "back off for", backoff, "seconds")
 				time.Sleep(backoff)
@@ -402,9 +515,12 @@ func (self *NNTPDaemon) persistFeed(conf FeedConfig, mode string, n int) {
 				continue
 			}
 			nntp := createNNTPConnection(conf.Addr)
-			nntp.policy = conf.policy
+			nntp.policy = status.State.config().policy
 			nntp.feedname = conf.Name
 			nntp.name = fmt.Sprintf("%s-%d-%s", conf.Name, n, mode)
+			nntp.stats = stats
+			nntp.priority = conf.priority
+			nntp.keepalive = conf.keepalive
 			stream, reader, use_tls, err := nntp.outboundHandshake(textproto.NewConn(conn), &conf)
 			if err == nil {
 				if mode == "reader" && !reader {
@@ -412,14 +528,17 @@ func (self *NNTPDaemon) persistFeed(conf FeedConfig, mode string, n int) {
 					conn.Close()
 				} else {
 					self.register_connection <- nntp
+					stats.Connected()
 					// success connecting, reset backoff
 					backoff = time.Second
 					// run connection
 					nntp.runConnection(self, false, stream, reader, use_tls, mode, conn, &conf)
 					// deregister connection
+					stats.Disconnected()
 					self.deregister_connection <- nntp
 				}
 			} else {
+				stats.Error(err)
 				log.Println("error doing outbound hanshake", err)
 			}
 		}
@@ -475,13 +594,25 @@ func (self *NNTPDaemon) Run() {
 
 	self.bind_addr = self.conf.daemon["bind"]
 
-	listener, err := net.Listen("tcp", self.bind_addr)
+	listener, err := listenBind(self.bind_addr)
 	if err != nil {
 		log.Fatal("failed to bind to", self.bind_addr, err)
 	}
 	self.listener = listener
 	log.Printf("SRNd NNTPD bound at %s", listener.Addr())
 
+	if self.conf.tor != nil && self.conf.tor.enable {
+		log.Println("setting up tor onion service via control port", self.conf.tor.control)
+		bind := listener.Addr().String()
+		if listener.Addr().Network() == "unix" {
+			bind = "unix:" + bind
+		}
+		self.tor, err = setupTorOnion(self.conf.tor, bind)
+		if err != nil {
+			log.Println("failed to set up tor onion service, continuing without it:", err)
+		}
+	}
+
 	if self.conf.pprof != nil && self.conf.pprof.enable {
 		addr := self.conf.pprof.bind
 		log.Println("pprof enabled, binding to", addr)
@@ -504,33 +635,32 @@ func (self *NNTPDaemon) Run() {
 	self.get_feeds = make(chan chan []*feedStatus)
 	self.get_feed = make(chan *feedStatusQuery)
 	self.modify_feed_policy = make(chan *modifyFeedPolicyEvent)
-	self.ask_for_article = make(chan ArticleEntry)
+	self.ask_for_article = make(chan articleRequest)
 
 	self.pump_ticker = time.NewTicker(time.Millisecond * 100)
 	if self.conf.daemon["archive"] == "1" {
 		log.Println("running in archive mode")
 		self.expire = nil
 	} else {
-		self.expire = createExpirationCore(self.database, self.store)
+		self.expire = createExpirationCore(self.database, self.store, threadArchiverFromConfig(self.conf.frontend, self.database, self.store))
 	}
 	self.sync_on_start = self.conf.daemon["sync_on_start"] == "1"
 	self.instance_name = self.conf.daemon["instance_name"]
-	self.allow_anon = self.conf.daemon["allow_anon"] == "1"
-	self.allow_anon_attachments = self.conf.daemon["allow_anon_attachments"] == "1"
-	self.allow_attachments = self.conf.daemon["allow_attachments"] == "1"
+	self.posting = postingConfigFrom(self.conf.daemon)
 
 	// do we enable the frontend?
 	if self.conf.frontend["enable"] == "1" {
-		log.Printf("frontend %s enabled", self.conf.frontend["name"])
-
-		cache_host := self.conf.cache["host"]
-		cache_port := self.conf.cache["port"]
-		cache_user := self.conf.cache["user"]
-		cache_passwd := self.conf.cache["password"]
-		self.cache = NewCache(self.conf.cache["type"], cache_host, cache_port, cache_user, cache_passwd, self.conf.frontend, self.database, self.store)
+		self.startFrontend()
+	}
 
-		self.frontend = NewHTTPFrontend(self, self.cache, self.conf.frontend, self.conf.worker["url"])
-		go self.frontend.Mainloop()
+	// do we take split frontends?
+	if self.conf.api != nil && self.conf.api.srndAddr != "" {
+		self.rpc, err = newRPCServer(self, self.conf.api)
+		if err != nil {
+			log.Fatal("failed to bind api socket to ", self.conf.api.srndAddr, err)
+		}
+		log.Println("taking frontends at", self.conf.api.srndAddr)
+		go self.rpc.Serve()
 	}
 
 	// set up admin user if it's specified in the config
@@ -548,7 +678,7 @@ func (self *NNTPDaemon) Run() {
 		}
 	}
 
-	log.Println("we have", len(self.conf.feeds), "feeds")
+	log.Println("we have", len(self.feedConfigs()), "feeds")
 
 	defer self.listener.Close()
 	// run expiration mainloop
@@ -556,29 +686,37 @@ func (self *NNTPDaemon) Run() {
 		log.Println("we are an archive, not expiring posts")
 	} else {
 		go self.expire.Mainloop()
-		lifetime := mapGetInt(self.conf.daemon, "article_lifetime", 0)
-		if lifetime > 0 {
-			self.article_lifetime = time.Duration(lifetime) * time.Hour
-			since := 0 - (self.article_lifetime)
-			self.expire.ExpireBefore(time.Now().Add(since))
-			self.expiration_ticker = time.NewTicker(time.Minute)
-			go func() {
-				for {
-					_, ok := <-self.expiration_ticker.C
-					if ok {
-						t := time.Now()
-						self.expire.ExpireBefore(t.Add(since))
-					} else {
-						return
-					}
+		atomic.StoreInt64(&self.article_lifetime, int64(self.conf.nntp.article_lifetime))
+		self.expireOld()
+		// ticks even with no lifetime so a reload can set one
+		self.expiration_ticker = time.NewTicker(time.Minute)
+		go func() {
+			for {
+				_, ok := <-self.expiration_ticker.C
+				if ok {
+					self.expireOld()
+				} else {
+					return
 				}
-			}()
-		}
+			}
+		}()
+	}
+	atomic.StoreInt64(&self.trash_hours, int64(self.conf.articles.trash_hours))
+	if len(self.conf.store["trash_dir"]) > 0 {
+		go self.trashMainloop()
+	}
+	rotateDays := mapGetInt(self.conf.addr_keys, "rotate_days", 0)
+	purgeDays := mapGetInt(self.conf.addr_keys, "purge_days", 0)
+	if rotateDays > 0 || purgeDays > 0 {
+		go self.addrKeysMainloop(rotateDays, purgeDays)
 	}
 	// we are now running
 	self.running = true
 	// start polling feeds
 	go self.pollfeeds()
+	if interval := self.conf.nntp.feeds_reload; interval > 0 {
+		go self.watchFeeds(time.Duration(interval) * time.Second)
+	}
 	threads := 8
 	go func() {
 		// if we have no initial posts create one
@@ -614,7 +752,7 @@ func (self *NNTPDaemon) Run() {
 	}()
 	// register feeds from config
 	log.Println("registering feeds")
-	for _, f := range self.conf.feeds {
+	for _, f := range self.feedConfigs() {
 		self.register_feed <- f
 	}
 
@@ -629,7 +767,37 @@ func (self *NNTPDaemon) Run() {
 	<-self.done
 }
 
+// expire articles older than article_lifetime or the lifetime their newsgroup has
+func (self *NNTPDaemon) expireOld() {
+	old, err := postsPastLifetime(self.database, self.groupConfigs(), int(atomic.LoadInt64(&self.article_lifetime)))
+	if err != nil {
+		log.Println("failed to expire older posts", err)
+	}
+	for _, msgid := range old {
+		self.expire.ExpirePost(msgid)
+	}
+}
+
+func (self *NNTPDaemon) startFrontend() {
+	log.Printf("frontend %s enabled", self.conf.frontend["name"])
+
+	cache_host := self.conf.cache["host"]
+	cache_port := self.conf.cache["port"]
+	cache_user := self.conf.cache["user"]
+	cache_passwd := self.conf.cache["password"]
+	self.cache = NewCache(self.conf.cache["type"], cache_host, cache_port, cache_user, cache_passwd, self.conf.frontend, self.database, self.store)
+
+	self.frontend = NewHTTPFrontend(self, self.cache, self.conf.frontend, self.conf.worker["url"])
+	go self.frontend.Mainloop()
+}
+
 func (self *NNTPDaemon) syncAllMessages() {
+	if self.remote != nil {
+		if err := self.remote.Call("sync", nil, nil); err != nil {
+			log.Println("failed to have srnd sync", err)
+		}
+		return
+	}
 	log.Println("syncing all messages to all feeds")
 	for _, article := range self.database.GetAllArticles() {
 		if self.store.HasArticle(article.MessageID()) {
@@ -642,6 +810,18 @@ func (self *NNTPDaemon) syncAllMessages() {
 // load a message from the infeed directory
 func (self *NNTPDaemon) loadFromInfeed(msgid string) {
 	log.Println("load from infeed", msgid)
+	if self.remote != nil {
+		// srnd processes it and tells us when it's in
+		// it comes along in case srnd can't see our articles directory
+		data, err := readArticle(self.store, msgid)
+		if err == nil {
+			err = self.remote.Call("submit", rpcArticle{MessageID: msgid, Article: data}, nil)
+		}
+		if err != nil {
+			log.Println("failed to submit", msgid, "to srnd", err)
+		}
+		return
+	}
 	self.infeed_load <- msgid
 }
 
@@ -690,7 +870,13 @@ func (self *NNTPDaemon) pollfeeds() {
 			if ok {
 				// yeh
 				// replace the policy
-				feedstate.Config.policy = ev.policy
+				feedstate.setPolicy(ev.policy)
+				// and use it on the connections we have
+				for _, conn := range self.activeConnections {
+					if conn.feedname == name {
+						conn.setPolicy(ev.policy)
+					}
+				}
 				if ev.resultChnl != nil {
 					// we need to inform the caller about the feed being changed successfully
 					ev.resultChnl <- &modifyFeedPolicyResult{
@@ -729,23 +915,25 @@ func (self *NNTPDaemon) pollfeeds() {
 			// send response
 			chnl <- feeds
 		case feedconfig := <-self.register_feed:
-			self.loadedFeeds[feedconfig.Name] = &feedState{
+			state := &feedState{
 				Config: feedconfig,
 				// TODO: make starting paused configurable
 				Paused: false,
+				Stats:  new(feedStats),
 			}
+			self.loadedFeeds[feedconfig.Name] = state
 			log.Println("daemon registered feed", feedconfig.Name)
 			// persist feeds
 			if feedconfig.sync {
-				go self.persistFeed(feedconfig, "sync", 0)
+				go self.persistFeed(state, "sync", 0)
 			}
 			n := feedconfig.connections
 			if n < 1 {
 				n = 1
 			}
 			for n > 0 {
-				go self.persistFeed(feedconfig, "stream", n)
-				go self.persistFeed(feedconfig, "reader", n)
+				go self.persistFeed(state, "stream", n)
+				go self.persistFeed(state, "reader", n)
 				n--
 			}
 		case feedname := <-self.deregister_feed:
@@ -759,7 +947,10 @@ func (self *NNTPDaemon) pollfeeds() {
 		case outfeed := <-self.register_connection:
 			self.activeConnections[outfeed.name] = outfeed
 		case outfeed := <-self.deregister_connection:
-			delete(self.activeConnections, outfeed.name)
+			// a feed that was reconnected has a new connection by this name already
+			if self.activeConnections[outfeed.name] == outfeed {
+				delete(self.activeConnections, outfeed.name)
+			}
 		case <-self.pump_ticker.C:
 			go self.pump_article_requests()
 		}
@@ -776,14 +967,26 @@ func (self *NNTPDaemon) pump_article_requests() {
 		self.send_all_feeds <- entry
 	}
 	articles = nil
+	var requests []articleRequest
 	self.ask_articles_mtx.Lock()
-	articles = append(articles, self.ask_articles...)
+	requests = append(requests, self.ask_articles...)
 	self.ask_articles = nil
 	self.ask_articles_mtx.Unlock()
-	for _, entry := range articles {
-		self.ask_for_article <- entry
+	for _, req := range requests {
+		self.ask_for_article <- req
+	}
+	requests = nil
+}
+
+// expire the oldest replies of a cycling thread that has more than cycle_replies replies
+func (self *NNTPDaemon) cycleThread(group, root string) {
+	limit := self.groupConfig(group).CycleReplies(self.conf.nntp.cycle_replies)
+	replies := self.database.GetThreadReplies(root, 0, 0)
+	for len(replies) > limit {
+		log.Println("cycle", replies[0], "out of", root)
+		self.expire.ExpirePost(replies[0])
+		replies = replies[1:]
 	}
-	articles = nil
 }
 
 func (self *NNTPDaemon) poll(worker int) {
@@ -806,12 +1009,16 @@ func (self *NNTPDaemon) poll(worker int) {
 				if err == nil {
 					rollover = tpp * ppb
 				}
+				rollover = self.groupConfig(group).Threads(rollover)
 				if self.expire != nil {
 					// expire posts
 					self.expire.ExpireGroup(group, rollover)
+					if ref != "" && self.database.CheckThreadFlag(ref, ThreadCycle) {
+						self.cycleThread(group, ref)
+					}
 				}
-				// send to mod panel
-				if group == "ctl" {
+				// send to mod panel, a split frontend runs its own
+				if group == "ctl" && self.frontend != nil {
 					modchnl <- msgid
 				}
 				// federate
@@ -822,17 +1029,29 @@ func (self *NNTPDaemon) poll(worker int) {
 						self.frontend.PostsChan() <- frontendPost{msgid, ref, group}
 					}
 				}
+				if self.rpc != nil {
+					self.rpc.broadcast("post", rpcArticle{MessageID: msgid, Reference: ref, Newsgroup: group})
+				}
 			}
 		case nntp := <-self.send_all_feeds:
 			group := nntp.Newsgroup()
 			if self.Federate() {
 				sz, _ := self.store.GetMessageSize(nntp.MessageID())
 				feeds := self.activeFeeds()
+				// one lookup for every feed
+				offered, err := self.database.FeedsOffered(nntp.MessageID())
+				if err != nil {
+					log.Println("failed to check which feeds", nntp.MessageID(), "was offered to", err)
+				}
 				if feeds != nil {
 					for _, f := range feeds {
+						if offered[f.State.Config.Name] {
+							// peer already has or refused it
+							continue
+						}
 						var send []*nntpConnection
 						for _, feed := range f.Conns {
-							if feed.policy.AllowsNewsgroup(group) {
+							if feed.allowsNewsgroup(group) {
 								if strings.HasSuffix(feed.name, "-stream") {
 									send = append(send, feed)
 								}
@@ -845,24 +1064,39 @@ func (self *NNTPDaemon) poll(worker int) {
 					}
 				}
 			}
-		case nntp := <-self.ask_for_article:
-			feeds := self.activeFeeds()
-			if feeds != nil {
-				for _, f := range feeds {
-					var send []*nntpConnection
-					for _, feed := range f.Conns {
-						if feed.policy.AllowsNewsgroup(nntp.Newsgroup()) {
-							if strings.HasSuffix(feed.name, "-reader") {
-								send = append(send, feed)
-							}
+		case req := <-self.ask_for_article:
+			if req.after >= 0 && self.store.HasArticle(req.entry.MessageID()) {
+				// another feed gave it to us in the meantime
+				break
+			}
+			// ask only the most preferred feeds that can give it to us
+			best := -1
+			var ask []*nntpConnection
+			for _, f := range self.activeFeeds() {
+				prio := f.State.Config.priority
+				if prio <= req.after || (best != -1 && prio > best) {
+					continue
+				}
+				var send []*nntpConnection
+				for _, feed := range f.Conns {
+					if feed.allowsNewsgroup(req.entry.Newsgroup()) {
+						if strings.HasSuffix(feed.name, "-reader") {
+							send = append(send, feed)
 						}
 					}
-					minconn := lowestBacklogConnection(send)
-					if minconn != nil {
-						minconn.askForArticle(nntp.MessageID())
+				}
+				minconn := lowestBacklogConnection(send)
+				if minconn != nil {
+					if best == -1 || prio < best {
+						best = prio
+						ask = nil
 					}
+					ask = append(ask, minconn)
 				}
 			}
+			for _, conn := range ask {
+				conn.askForArticle(req.entry)
+			}
 		}
 	}
 	log.Println("worker", worker, "done")
@@ -882,11 +1116,127 @@ func lowestBacklogConnection(conns []*nntpConnection) (minconn *nntpConnection)
 }
 
 func (self *NNTPDaemon) askForArticle(e ArticleEntry) {
+	self.askForArticleFallback(e, -1)
+}
+
+// ask for an article only from feeds with a worse priority than after
+func (self *NNTPDaemon) askForArticleFallback(e ArticleEntry, after int) {
 	self.ask_articles_mtx.Lock()
-	self.ask_articles = append(self.ask_articles, e)
+	self.ask_articles = append(self.ask_articles, articleRequest{e, after})
 	self.ask_articles_mtx.Unlock()
 }
 
+// return the name of the most preferred connected feed that we would rather sync from than this one
+// it has to carry every newsgroup we have that this feed carries, else a sync still gets us something
+// returns empty string if there is none
+func (self *NNTPDaemon) preferredFeed(conf FeedConfig) (name string) {
+	groups := self.database.GetAllNewsgroups()
+	best := conf.priority
+	for _, f := range self.activeFeeds() {
+		other := f.State.config()
+		if other.priority >= best || len(f.Conns) == 0 {
+			continue
+		}
+		carries := true
+		for _, group := range groups {
+			if conf.policy.AllowsNewsgroup(group) && !other.policy.AllowsNewsgroup(group) {
+				carries = false
+				break
+			}
+		}
+		if carries {
+			best = other.priority
+			name = other.Name
+		}
+	}
+	return
+}
+
+// let a quarantined post through
+// teaches the spam filter it was fine or counts towards trusting the feed it came from
+func (self *NNTPDaemon) approveQuarantined(q QuarantinedArticle) (err error) {
+	msgid := q.MessageID
+	nntp := self.store.GetMessage(msgid)
+	if nntp == nil {
+		return errors.New("we don't have " + msgid)
+	}
+	err = self.database.UnquarantineArticle(msgid)
+	if err == nil {
+		// skips the spam filter
+		err = self.database.RegisterArticle(nntp)
+	}
+	if err == nil {
+		if q.Feed == "" {
+			self.spam.Learn(nntp, false)
+		} else {
+			err = self.settleIntake(q.Feed, true)
+		}
+		// publish it like it just came in
+		self.loadFromInfeed(msgid)
+	}
+	return
+}
+
+// throw away a quarantined post
+// teaches the spam filter it was spam or resets the clean streak of the feed it came from
+func (self *NNTPDaemon) rejectQuarantined(q QuarantinedArticle) (err error) {
+	msgid := q.MessageID
+	reason := "spam"
+	if q.Feed == "" {
+		self.spam.Learn(self.store.GetMessage(msgid), true)
+	} else {
+		reason = "rejected from " + q.Feed
+	}
+	err = self.database.UnquarantineArticle(msgid)
+	if err == nil {
+		err = self.database.BanArticle(msgid, reason)
+	}
+	if err == nil && q.Feed != "" {
+		err = self.settleIntake(q.Feed, false)
+	}
+	DelFile(self.store.GetFilename(msgid))
+	return
+}
+
+// remember which feed a ctl message came in from
+func (self *NNTPDaemon) rememberCtlPeer(msgid, feedname string) {
+	err := self.database.SetCtlFeed(msgid, feedname)
+	if err != nil {
+		log.Println("failed to remember which feed", msgid, "came from", err)
+	}
+}
+
+// get the policy for deletes signed by pubkey in a ctl message and if we made it ourselves
+// ctl messages we made ourselves are always honored
+func (self *NNTPDaemon) remoteDeletePolicy(pubkey, ctl_msgid string) (string, bool) {
+	feedname, remote, err := self.database.TakeCtlFeed(ctl_msgid)
+	if err != nil {
+		// we don't know where it came from, don't trust it like our own
+		log.Println("failed to get which feed", ctl_msgid, "came from", err)
+		remote = true
+	}
+	if !remote {
+		return RemoteDeleteHonor, true
+	}
+	// pubkey overrides peer
+	policy := self.conf.remote_deletes[pubkey]
+	if validRemoteDeletePolicy(policy) {
+		return policy, false
+	}
+	if feedname != "" {
+		for _, f := range self.activeFeeds() {
+			if f.State.Config.Name == feedname && len(f.State.Config.delete_policy) > 0 {
+				return f.State.Config.delete_policy, false
+			}
+		}
+	}
+	policy = self.conf.remote_deletes["default"]
+	if validRemoteDeletePolicy(policy) {
+		return policy, false
+	}
+	return RemoteDeleteHonor, false
+}
+
 func (self *NNTPDaemon) sendAllFeeds(e ArticleEntry) {
 	self.send_articles_mtx.Lock()
 	self.send_articles = append(self.send_articles, e)
@@ -925,7 +1275,7 @@ func (self *NNTPDaemon) acceptloop() {
 }
 
 func (self *NNTPDaemon) Federate() (federate bool) {
-	federate = len(self.conf.feeds) > 0
+	federate = len(self.feedConfigs()) > 0
 	return
 }
 
@@ -964,17 +1314,15 @@ func (self *NNTPDaemon) Setup() {
 	// check that are configs exist
 	CheckConfig()
 	log.Println("loading config...")
-	// read the config
-	self.conf = ReadConfig()
-	if self.conf == nil {
-		log.Fatal("failed to load config")
-	}
-	// validate the config
-	log.Println("validating configs...")
-	self.conf.Validate()
+	// read and validate the config, every problem in it is reported before we give up
+	conf, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	self.conf = conf
 	log.Println("configs are valid")
-
-	var err error
+	setLogLevel(self.conf.daemon["log_level"])
+	self.groups = self.conf.groups
 
 	log.Println("Reading translation files")
 	translation_dir := self.conf.frontend["translations"]
@@ -983,15 +1331,21 @@ func (self *NNTPDaemon) Setup() {
 	}
 	locale := self.conf.frontend["locale"]
 	InitI18n(locale, translation_dir)
+	SetBoardLocales(self.conf.board_locales)
+	markup := self.conf.frontend["markup"]
+	if markup != "" && !validMarkup(markup) {
+		log.Println("invalid markup engine", markup, "using", MarkupBasic)
+	}
+	SetMarkup(markup, self.conf.board_markup)
+	SetLinkPreviews(linkPreviewerFromConfig(self.conf))
+	SetTrustedProxies(self.conf.frontend["trusted_proxies"])
 
-	db_host := self.conf.database["host"]
-	db_port := self.conf.database["port"]
-	db_user := self.conf.database["user"]
+	db := self.conf.db
 	db_passwd := self.conf.database["password"]
 
 	// set up database stuff
 	log.Println("connecting to database...")
-	self.database = NewDatabase(self.conf.database["type"], self.conf.database["schema"], db_host, db_port, db_user, db_passwd)
+	self.database = NewDatabase(db.kind, db.schema, db.host, db.Port(), db.user, db_passwd)
 	log.Println("ensure that the database is created...")
 	self.database.CreateTables()
 
@@ -1005,11 +1359,44 @@ func (self *NNTPDaemon) Setup() {
 
 	// set up store
 	log.Println("set up article store...")
-	self.store = createArticleStore(self.conf.store, self.database)
+	self.spam = spamFilterFromConfig(self.conf)
+	if self.spam != nil {
+		go self.spam.Run(spamSaveInterval)
+	}
+	self.notify = modNotifierFromConfig(self.conf)
+	if self.notify != nil {
+		go self.notify.Run()
+	}
+	self.store = createArticleStore(self.conf.store, self.database, self.spam, floodDetectorFromConfig(self.conf), self.notify, pubkeyQuotaFromConfig(self.conf))
 
 	self.mod = modEngine{
-		store:    self.store,
-		database: self.database,
-		chnl:     make(chan string),
+		store:        self.store,
+		database:     self.database,
+		chnl:         make(chan string),
+		deletePolicy: self.remoteDeletePolicy,
+		spam:         self.spam,
+		notify:       self.notify,
+		deleted:      self.articleDeleted,
+	}
+}
+
+// reload the frontend's templates, on SIGHUP
+func (self *NNTPDaemon) ReloadTemplates() {
+	if self.frontend != nil {
+		log.Println("reloading templates")
+		self.frontend.ReloadTemplates()
+	}
+	if self.rpc != nil {
+		self.rpc.broadcast("reload", nil)
+	}
+}
+
+// tell the frontend a post was deleted
+func (self *NNTPDaemon) articleDeleted(msgid, root, group string) {
+	if self.frontend != nil && self.frontend.AllowNewsgroup(group) {
+		self.frontend.ArticleDeleted(msgid, root, group)
+	}
+	if self.rpc != nil {
+		self.rpc.broadcast("delete", rpcArticle{MessageID: msgid, Reference: root, Newsgroup: group})
 	}
 }
@@ -4,6 +4,7 @@
 package srnd
 
 import (
+	"fmt"
 	"log"
 	"net"
 	"time"
@@ -49,6 +50,194 @@ type PostingStats struct {
 	History []PostingStatsEntry
 }
 
+// a report about a post sent to the mods
+type PostReport struct {
+	MessageID string `json:"message_id"`
+	Newsgroup string `json:"newsgroup"`
+	Reason    string `json:"reason"`
+	Reported  int64  `json:"reported"`
+}
+
+// a post held back by the spam filter or moderated intake
+type QuarantinedArticle struct {
+	MessageID   string  `json:"message_id"`
+	Newsgroup   string  `json:"newsgroup"`
+	Score       float64 `json:"score"`
+	Quarantined int64   `json:"quarantined"`
+	// feed in moderated intake it came from, empty if the spam filter held it
+	Feed string `json:"feed,omitempty"`
+}
+
+// hash of the message-id of a quarantined post, for urls
+func (self QuarantinedArticle) Hash() string {
+	return HashMessageID(self.MessageID)
+}
+
+// an article our filters or bans rejected, kept for the mods
+type RejectedArticle struct {
+	MessageID string `json:"message_id"`
+	Newsgroup string `json:"newsgroup"`
+	Reason    string `json:"reason"`
+	// feed it came from, empty if we don't know
+	Feed     string `json:"feed,omitempty"`
+	Rejected int64  `json:"rejected"`
+}
+
+// hash of the message-id of a rejected article, for urls
+func (self RejectedArticle) Hash() string {
+	return HashMessageID(self.MessageID)
+}
+
+// how far a feed in moderated intake got towards being trusted
+type FeedIntake struct {
+	Feed string `json:"feed"`
+	// approved articles in a row
+	Streak  int64 `json:"streak"`
+	Trusted bool  `json:"trusted"`
+}
+
+// a post that quotes another one
+type Backlink struct {
+	MessageID string
+	// root of the thread the quoting post is in
+	Root      string
+	Newsgroup string
+}
+
+// the url of the quoting post
+func (self Backlink) URL(prefix string) string {
+	return fmt.Sprintf("%sthread-%s.html#%s", prefix, HashMessageID(self.Root), HashMessageID(self.MessageID))
+}
+
+// per newsgroup settings admins change from the mod ui
+type BoardSettings struct {
+	Newsgroup      string `json:"newsgroup"`
+	Pages          int    `json:"pages"`
+	ThreadsPerPage int    `json:"threads_per_page"`
+	// can posts from the frontend have attachments
+	Attachments bool `json:"attachments"`
+	// captcha policy like in captcha_groups, empty for the configured one
+	Captcha     string `json:"captcha"`
+	Description string `json:"description"`
+	// play audio and video attachments in the page instead of only linking them
+	InlineMedia bool `json:"inline_media"`
+	// replies each thread shows on board pages
+	PreviewReplies int `json:"preview_replies"`
+}
+
+// the settings a newsgroup has until an admin changes them
+func defaultBoardSettings(group string) BoardSettings {
+	return BoardSettings{
+		Newsgroup:      group,
+		Pages:          10,
+		ThreadsPerPage: 10,
+		Attachments:    true,
+		InlineMedia:    true,
+		PreviewReplies: defaultPreviewReplies,
+	}
+}
+
+// a mod permission one pubkey handed to another
+type ModGrant struct {
+	Granter    string `json:"granter"`
+	Pubkey     string `json:"pubkey"`
+	Newsgroup  string `json:"newsgroup"`
+	Permission string `json:"permission"`
+	Granted    int64  `json:"granted"`
+}
+
+// a deleted article waiting in the trash to be restored
+type TrashedArticle struct {
+	MessageID string `json:"message_id"`
+	Newsgroup string `json:"newsgroup"`
+	// root post of its thread, empty for root posts
+	Root        string   `json:"root"`
+	Attachments []string `json:"attachments"`
+	Trashed     int64    `json:"trashed"`
+}
+
+// return true if this started a thread
+func (self TrashedArticle) IsRoot() bool {
+	return self.Root == "" || self.Root == self.MessageID
+}
+
+// what an nntp login may do
+const (
+	// read articles only
+	NNTPRoleReader = "reader"
+	// read and POST
+	NNTPRolePoster = "poster"
+	// read, POST, IHAVE and stream, what every login could do before there were roles
+	NNTPRoleFeeder = "feeder"
+	// read and POST, and log in to the mod panel as the key mod_nntp_users gives it
+	NNTPRoleMod = "mod"
+)
+
+// return true if role is an nntp login role we know about
+func validNNTPRole(role string) bool {
+	return role == NNTPRoleReader || role == NNTPRolePoster || role == NNTPRoleFeeder || role == NNTPRoleMod
+}
+
+// an nntp login
+type NNTPUser struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// disabled logins are turned away
+	Disabled bool `json:"disabled"`
+}
+
+// a ban on an ip address or cidr
+type AddrBan struct {
+	Addr    string `json:"addr"`
+	Made    int64  `json:"made"`
+	Expires int64  `json:"expires"`
+}
+
+// a banned poster asking the mods to lift their ban
+type BanAppeal struct {
+	EncAddr  string `json:"encaddr"`
+	Text     string `json:"text"`
+	Status   string `json:"status"`
+	Appealed int64  `json:"appealed"`
+}
+
+// return true if no mod has answered this appeal yet
+func (self BanAppeal) Pending() bool {
+	return self.Status == BanAppealPending
+}
+
+// a ban we merged from a subscribed ban list
+type BanlistEntry struct {
+	// pubkey of the ban list it came from
+	Source string `json:"source"`
+	// one of BanlistFile, BanlistPubkey or BanlistEncAddr
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	// true if we banned it because of this entry, false if it was already banned
+	Applied bool  `json:"applied"`
+	Added   int64 `json:"added"`
+}
+
+// how big a thread is, for the catalog
+type ThreadCounts struct {
+	Replies int64
+	// attachments on the root post and all replies
+	Images int64
+}
+
+// what to search posts for
+type SearchParams struct {
+	Text string
+	// empty for every newsgroup
+	Newsgroup string
+	// only posts made at or after / before these unix times, 0 for no bound
+	After  int64
+	Before int64
+	Offset int
+	// 0 for every match
+	Limit int
+}
+
 type Database interface {
 	Close()
 	CreateTables()
@@ -68,6 +257,9 @@ type Database interface {
 	BanNewsgroup(group string) error
 	UnbanNewsgroup(group string) error
 
+	// get every banned newsgroup
+	GetBannedNewsgroups() ([]string, error)
+
 	// delete an entire newsgroup
 	// delete from the article store too
 	NukeNewsgroup(group string, store ArticleStore)
@@ -79,6 +271,13 @@ type Database interface {
 	// return an article entry or nil when it doesn't exist + and error if it happened
 	GetMessageIDByHash(hash string) (ArticleEntry, error)
 
+	// get an article given at least the first 10 characters of the hash of its MessageID
+	GetMessageIDByShortHash(hash string) (ArticleEntry, error)
+
+	// get the posts that quote an article, oldest first
+	// kept up to date when articles are registered and deleted
+	GetBacklinks(msgid string) ([]Backlink, error)
+
 	// get root message_id, newsgroup, pageno for a post regardless if it's rootpost or not
 	GetInfoForMessage(msgid string) (string, string, int64, error)
 
@@ -99,6 +298,19 @@ type Database interface {
 	// if N <= 0 then count all we have now
 	CountPostsInGroup(group string, time_frame int64) int64
 
+	// get the number of threads in a newsgroup
+	CountThreadsInGroup(group string) int64
+
+	// get when the newsgroup last got a post
+	GetLastPostTimeInGroup(group string) int64
+
+	// get when a thread last got a reply, sage or not
+	GetThreadLastPostTime(root string) int64
+
+	// count a hit on a rate limit counter
+	// returns how many hits it had in the current window of window seconds
+	HitRateLimit(counter string, window int64) (int64, error)
+
 	// get all replies to a thread
 	// if last > 0 then get that many of the last replies
 	// start at reply number start
@@ -107,6 +319,13 @@ type Database interface {
 	// count the number of replies to this thread
 	CountThreadReplies(root_message_id string) int64
 
+	// count the replies to this thread posted after a time
+	CountThreadRepliesSince(root_message_id string, since int64) int64
+
+	// get reply and image counts for every thread in a newsgroup at once
+	// maps root message-id -> counts
+	GetGroupThreadCounts(newsgroup string) (map[string]ThreadCounts, error)
+
 	// get all attachments for this message
 	GetPostAttachments(message_id string) []string
 
@@ -142,6 +361,12 @@ type Database interface {
 	// prefix is injected into the post models
 	GetThreadReplyPostModels(prefix, rootMessageID string, start, limit int) []PostModel
 
+	// get a page of PostModels for replies to a thread, oldest first, skipping offset of them
+	GetThreadReplyPostModelsPage(prefix, rootMessageID string, offset, limit int) []PostModel
+
+	// get the PostModels for replies to a thread posted after a time, oldest first
+	GetThreadReplyPostModelsSince(prefix, rootMessageID string, since int64) []PostModel
+
 	// get a post model for a post
 	// prefix is injected into the post model
 	GetPostModel(prefix, messageID string) PostModel
@@ -179,12 +404,58 @@ type Database interface {
 	// remote a pubkey to they can't mod a newsgroup
 	UnMarkModPubkeyCanModGroup(pubkey, newsgroup string) error
 
+	// check if a mod with this pubkey has a permission on the given newsgroup
+	// true if it was granted directly, the pubkey can mod the whole newsgroup or is a global mod
+	CheckModPubkeyPermission(pubkey, newsgroup, perm string) bool
+
+	// grant a single mod permission on a newsgroup to a pubkey
+	GrantModPubkeyPermission(pubkey, newsgroup, perm string) error
+
+	// revoke a single mod permission on a newsgroup from a pubkey
+	RevokeModPubkeyPermission(pubkey, newsgroup, perm string) error
+
+	// get all single mod permissions a pubkey was granted on a newsgroup
+	GetModPubkeyPermissions(pubkey, newsgroup string) ([]string, error)
+
+	// remember that granter gave pubkey a mod permission on a newsgroup
+	RecordModGrant(granter, pubkey, newsgroup, perm string) error
+
+	// forget who gave pubkey a mod permission on a newsgroup
+	ForgetModGrant(pubkey, newsgroup, perm string) error
+
+	// get every mod permission granter handed out that was not revoked
+	GetModGrantsBy(granter string) ([]ModGrant, error)
+
+	// get every mod permission pubkey was handed and who by
+	GetModGrantsTo(pubkey string) ([]ModGrant, error)
+
+	// move every mod and admin privilege of oldkey to newkey and mark oldkey expired, all or nothing
+	RotateModPubkey(oldkey, newkey string) error
+
+	// return true if this mod pubkey was rotated out
+	ModPubkeyExpired(pubkey string) bool
+
 	// ban an article
 	BanArticle(messageID, reason string) error
 
+	// let a banned article back in
+	UnbanArticle(messageID string) error
+
+	// remember that an article and its attachments went into the trash
+	TrashArticle(messageID, newsgroup, root string, attachments []string) error
+
+	// get every article in the trash, oldest first
+	GetTrashedArticles() ([]TrashedArticle, error)
+
+	// forget an article was in the trash, after it was restored or emptied
+	ForgetTrashedArticle(messageID string) error
+
 	// check if an article is banned or not
 	ArticleBanned(messageID string) bool
 
+	// get every banned article, message-id -> why it was banned
+	GetBannedArticles() (map[string]string, error)
+
 	// Get ip address given the encrypted version
 	// return emtpy string if we don't have it
 	GetIPAddress(encAddr string) (string, error)
@@ -204,6 +475,24 @@ type Database interface {
 	// ban an encrypted ip address from the remote
 	BanEncAddr(encAddr string) error
 
+	// ban an encrypted ip address from the remote until the unix time expires
+	BanEncAddrUntil(encAddr string, expires int64) error
+
+	// get every banned address, address range and encrypted address
+	GetBannedAddrs() ([]string, error)
+
+	// get every ip address and cidr ban with when it was made
+	GetAddrBans() ([]AddrBan, error)
+
+	// get every ip address and cidr ban that covers an ip address
+	ExplainIPBan(addr string) ([]AddrBan, error)
+
+	// lift the ban on exactly this ip address or cidr, NoSuchBan if there is none
+	UnbanCIDR(cidr string) error
+
+	// get every encrypted ip address ban that has not run out
+	GetEncAddrBans() ([]AddrBan, error)
+
 	// return the encrypted version of an IPAddress
 	// if it's not already there insert it into the database
 	GetEncAddress(addr string) (string, error)
@@ -212,6 +501,16 @@ type Database interface {
 	// return empty string if we don't have it
 	GetEncKey(encAddr string) (string, error)
 
+	// get every encrypted address whose key was made before the unix time
+	GetEncAddrsMadeBefore(before int64) ([]string, error)
+
+	// give the address behind an encrypted address a new key and encrypted address
+	// the old encrypted address can no longer be decrypted, returns the new one
+	RotateEncAddress(encAddr string) (string, error)
+
+	// forget the address behind an encrypted address
+	DeleteEncAddress(encAddr string) error
+
 	// delete an article from the database
 	DeleteArticle(msg_id string) error
 
@@ -224,6 +523,12 @@ type Database interface {
 	// get pages per board for a newsgroup
 	GetPagesPerBoard(group string) (int, error)
 
+	// get the settings of a newsgroup, the defaults if it has none
+	GetBoardSettings(group string) (BoardSettings, error)
+
+	// store the settings of a newsgroup
+	SetBoardSettings(settings BoardSettings) error
+
 	// get every newsgroup we know of
 	GetAllNewsgroups() []string
 
@@ -264,6 +569,21 @@ type Database interface {
 	// check if an nntp login credential given a user exists
 	CheckNNTPUserExists(username string) (bool, error)
 
+	// get an nntp login, nil if there is none
+	GetNNTPUser(username string) (*NNTPUser, error)
+
+	// get every nntp login
+	GetNNTPUsers() ([]NNTPUser, error)
+
+	// change the password of an nntp login
+	SetNNTPPassword(username, passwd string) error
+
+	// change what an nntp login may do, one of the NNTPRole constants
+	SetNNTPUserRole(username, role string) error
+
+	// turn an nntp login away or let it back in
+	SetNNTPUserDisabled(username string, disabled bool) error
+
 	// get the message ids of an article that has this header with the given value
 	GetMessageIDByHeader(name, value string) ([]string, error)
 
@@ -276,20 +596,178 @@ type Database interface {
 	// get all message-ids posted by poster with encrypted ip
 	GetMessageIDByEncryptedIP(encaddr string) ([]string, error)
 
+	// get all message-ids of articles signed by pubkey
+	GetMessageIDByPubkey(pubkey string) ([]string, error)
+
+	// get every signed article, message-id -> pubkey it was signed by
+	GetSignedArticles() (map[string]string, error)
+
 	// check if this public key is banned from posting
 	PubkeyIsBanned(pubkey string) (bool, error)
 
 	// ban a public key from posting
 	BanPubkey(pubkey string) error
 
+	// let a public key post again
+	UnbanPubkey(pubkey string) error
+
+	// unban an encrypted ip address
+	UnbanEncAddr(encAddr string) error
+
+	// shadow ban an encrypted ip address, their posts are only shown to them
+	ShadowBanEncAddr(encAddr string) error
+
+	// lift a shadow ban on an encrypted ip address
+	UnshadowBanEncAddr(encAddr string) error
+
+	// return true if this encrypted ip address is shadow banned
+	CheckEncAddrShadowBanned(encAddr string) (bool, error)
+
+	// record an appeal of the ban on an encrypted ip address, BanAppealExists if it already appealed
+	AddBanAppeal(encAddr, text string) error
+
+	// get the appeal of the ban on an encrypted ip address, nil if it has none
+	GetBanAppeal(encAddr string) (*BanAppeal, error)
+
+	// get all appeals no mod has answered yet, oldest first
+	GetPendingBanAppeals() ([]BanAppeal, error)
+
+	// mark the appeal of an encrypted ip address as upheld, it cannot appeal again
+	UpholdBanAppeal(encAddr string) error
+
+	// forget the appeal of an encrypted ip address, done when its ban is lifted
+	DeleteBanAppeal(encAddr string) error
+
+	// set or clear a flag (sticky, lock or cycle) on the thread with this root post
+	SetThreadFlag(root_message_id, newsgroup, flag string, on bool) error
+
+	// return true if the thread with this root post has a flag set
+	CheckThreadFlag(root_message_id, flag string) bool
+
+	// get the root posts of all threads in a newsgroup with a flag set, oldest flag first
+	GetThreadsWithFlag(newsgroup, flag string) ([]string, error)
+
+	// ban an attachment given the hex of its sha512
+	BanAttachment(hash string) error
+
+	// unban an attachment given the hex of its sha512
+	UnbanAttachment(hash string) error
+
+	// check if an attachment is banned given the hex of its sha512
+	AttachmentBanned(hash string) (bool, error)
+
+	// get the hex of the sha512 of every banned attachment
+	GetBannedAttachments() ([]string, error)
+
+	// get the hex of the sha512 of every attachment some article has
+	GetAttachmentHashes() (map[string]bool, error)
+
+	// check if any article has an attachment given the hex of its sha512
+	AttachmentReferenced(hash string) (bool, error)
+
 	// get all message-id posted before a time
 	GetPostsBefore(t time.Time) ([]string, error)
 
+	// get the message ids of the posts in a newsgroup posted before a time
+	GetPostsBeforeInGroup(group string, t time.Time) ([]string, error)
+
 	// get statistics about posting in a time slice
 	GetPostingStats(granularity, begin, end int64) (PostingStats, error)
 
 	// peform search query
 	SearchQuery(prefix, group string, text string) ([]PostModel, error)
+
+	// search post bodies newest first, also returns how many posts matched in total
+	SearchPosts(prefix string, q SearchParams) ([]PostModel, int64, error)
+
+	// remember that the peer of a feed already has or refused an article
+	MarkArticleOffered(feedname, msgid string) error
+
+	// the feeds whose peers already have or refused an article
+	FeedsOffered(msgid string) (map[string]bool, error)
+
+	// forget all articles we offered to a feed so they are offered again
+	ClearOfferedArticles(feedname string) error
+
+	// remember the feed a ctl message came in from, empty for inbound connections
+	// kept until the ctl message is handled so its deletes get that feed's policy after a restart
+	SetCtlFeed(msgid, feedname string) error
+
+	// get and forget the feed a ctl message came in from
+	// remote is false for ctl messages that did not come in over nntp
+	TakeCtlFeed(msgid string) (feedname string, remote bool, err error)
+
+	// record a report about a post
+	AddReport(msgid, newsgroup, reason string) error
+
+	// get all open reports in a newsgroup, all newsgroups if empty string
+	GetReports(newsgroup string) ([]PostReport, error)
+
+	// dismiss all reports about a post
+	DismissReports(msgid string) error
+
+	// hold back a post the spam filter gave this score
+	QuarantineArticle(msgid, newsgroup string, score float64) error
+
+	// get all quarantined posts in a newsgroup, all newsgroups if empty string
+	GetQuarantinedArticles(newsgroup string) ([]QuarantinedArticle, error)
+
+	// forget that a post was quarantined
+	UnquarantineArticle(msgid string) error
+
+	// remember an article we rejected and kept for the mods
+	RecordRejectedArticle(msgid, newsgroup, reason, feed string) error
+
+	// get every rejected article we kept, newest first
+	GetRejectedArticles() ([]RejectedArticle, error)
+
+	// forget a rejected article after it was released or purged
+	ForgetRejectedArticle(msgid string) error
+
+	// remember which address lists addr is on until expires
+	CacheAddrListing(addr string, lists []string, expires int64) error
+
+	// get the address lists addr is on, cached is false if we did not look recently
+	GetAddrListing(addr string) (lists []string, cached bool, err error)
+
+	// mark a signing key trusted for higher posting quotas or not
+	SetPubkeyTrusted(pubkey string, trusted bool) error
+
+	// return true if mods marked this signing key trusted
+	PubkeyTrusted(pubkey string) (bool, error)
+
+	// hold a post from a feed in moderated intake for the mods
+	HoldArticleFromFeed(msgid, newsgroup, feed string) error
+
+	// get the intake state of a feed, zero value if we have none
+	GetFeedIntake(feed string) (FeedIntake, error)
+
+	// store the intake state of a feed
+	SetFeedIntake(intake FeedIntake) error
+
+	// merge bans from the ban list signed by this pubkey
+	SubscribeBanlist(pubkey string) error
+
+	// stop merging bans from the ban list signed by this pubkey
+	UnsubscribeBanlist(pubkey string) error
+
+	// check if we merge bans from the ban list signed by this pubkey
+	BanlistSubscribed(pubkey string) bool
+
+	// get the pubkeys of all ban lists we merge
+	GetBanlistSubscriptions() ([]string, error)
+
+	// record that a ban came from the ban list of source
+	AddBanlistEntry(source, kind, value string, applied bool) error
+
+	// get all bans we merged from the ban list of source
+	GetBanlistEntries(source string) ([]BanlistEntry, error)
+
+	// forget a ban we merged from the ban list of source
+	RemoveBanlistEntry(source, kind, value string) error
+
+	// check if any ban list we merged has this ban
+	BanlistEntryListed(kind, value string) bool
 }
 
 func NewDatabase(db_type, schema, host, port, user, password string) Database {
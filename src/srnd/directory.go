@@ -0,0 +1,112 @@
+//
+// directory.go -- every board we carry with how active it is
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ways the board directory can be sorted
+var directorySorts = map[string]func(a, b boardPageRow) bool{
+	"activity": func(a, b boardPageRow) bool { return a.Day+a.Hour*24 > b.Day+b.Hour*24 },
+	"name":     func(a, b boardPageRow) bool { return a.Board < b.Board },
+	"threads":  func(a, b boardPageRow) bool { return a.Threads > b.Threads },
+	"posts":    func(a, b boardPageRow) bool { return a.All > b.All },
+	"last":     func(a, b boardPageRow) bool { return a.LastPost > b.LastPost },
+}
+
+// sorts boardPageRows with a function
+type boardRowSorter struct {
+	rows boardPageRows
+	less func(a, b boardPageRow) bool
+}
+
+func (self boardRowSorter) Len() int {
+	return len(self.rows)
+}
+
+func (self boardRowSorter) Less(i, j int) bool {
+	return self.less(self.rows[i], self.rows[j])
+}
+
+func (self boardRowSorter) Swap(i, j int) {
+	self.rows.Swap(i, j)
+}
+
+// sort board rows by one of directorySorts, by activity if we don't know it
+func sortBoardRows(rows boardPageRows, by string) {
+	less, ok := directorySorts[by]
+	if !ok {
+		less = directorySorts["activity"]
+	}
+	sort.Stable(boardRowSorter{rows, less})
+}
+
+// does a board match a directory filter
+func (self boardPageRow) Matches(filter string) bool {
+	filter = strings.ToLower(filter)
+	return strings.Contains(self.Board, filter) || strings.Contains(strings.ToLower(self.Description), filter)
+}
+
+// every board we show with its stats
+func (self *httpFrontend) boardDirectory(filter string) (rows boardPageRows) {
+	db := self.daemon.database
+	for _, group := range db.GetAllNewsgroups() {
+		if group == "ctl" || !self.AllowNewsgroup(group) {
+			continue
+		}
+		if banned, _ := db.NewsgroupBanned(group); banned {
+			continue
+		}
+		settings, _ := db.GetBoardSettings(group)
+		row := boardPageRow{
+			Board:       group,
+			Hour:        db.CountPostsInGroup(group, 3600),
+			Day:         db.CountPostsInGroup(group, 86400),
+			All:         db.CountPostsInGroup(group, 0),
+			Threads:     db.CountThreadsInGroup(group),
+			LastPost:    db.GetLastPostTimeInGroup(group),
+			Description: settings.Description,
+		}
+		if filter == "" || row.Matches(filter) {
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// GET /directory?sort=activity|name|threads|posts|last&q=filter&t=json
+func (self *httpFrontend) handle_directory(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := strings.TrimSpace(q.Get("q"))
+	by := q.Get("sort")
+	if _, ok := directorySorts[by]; !ok {
+		by = "activity"
+	}
+	rows := self.boardDirectory(filter)
+	sortBoardRows(rows, by)
+	if q.Get("t") == "json" {
+		wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
+		json.NewEncoder(wr).Encode(rows)
+		return
+	}
+	tr := i18nForRequest(r, "")
+	template.writeTemplate("directory.mustache", map[string]interface{}{
+		"prefix": self.prefix,
+		"boards": rows,
+		"filter": filter,
+		"sort":   by,
+		"i18n":   tr,
+		"navbar": template.renderTemplate("navbar.mustache", map[string]interface{}{
+			"name":     "Boards",
+			"frontend": self.name,
+			"prefix":   self.prefix,
+			"i18n":     tr,
+		}),
+	}, wr)
+}
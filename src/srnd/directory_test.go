@@ -0,0 +1,22 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestSortBoardRows(t *testing.T) {
+
+	rows := boardPageRows{
+		{Board: "overchan.b", Threads: 1, LastPost: 20},
+		{Board: "overchan.a", Threads: 5, LastPost: 10},
+	}
+	sortBoardRows(rows, "name")
+	if rows[0].Board != "overchan.a" {
+		t.Error("bad sort by name", rows)
+	}
+	sortBoardRows(rows, "last")
+	if rows[0].Board != "overchan.b" {
+		t.Error("bad sort by last post", rows)
+	}
+
+}
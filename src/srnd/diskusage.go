@@ -0,0 +1,16 @@
+// +build !windows
+
+package srnd
+
+import "syscall"
+
+// get the size and free space of the filesystem path is on
+func diskUsage(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(path, &st)
+	if err == nil {
+		total = st.Blocks * uint64(st.Bsize)
+		free = st.Bavail * uint64(st.Bsize)
+	}
+	return
+}
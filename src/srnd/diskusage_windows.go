@@ -0,0 +1,11 @@
+// +build windows
+
+package srnd
+
+import "errors"
+
+// get the size and free space of the filesystem path is on
+func diskUsage(path string) (total, free uint64, err error) {
+	err = errors.New("disk usage is not supported on windows")
+	return
+}
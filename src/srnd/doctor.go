@@ -0,0 +1,241 @@
+//
+// doctor.go -- checking a node's config, tools, directories and data and saying what to fix
+//
+
+package srnd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// how many articles and files the consistency checks look at
+const doctorSampleSize = 200
+
+// databases that can tell us if they answer
+type databasePinger interface {
+	Ping() error
+}
+
+// what a check found
+type doctorFinding struct {
+	Failed bool
+	Check  string
+	Detail string
+	// what to do about it
+	Fix string
+}
+
+type doctorReport []doctorFinding
+
+func (self *doctorReport) ok(check, detail string) {
+	*self = append(*self, doctorFinding{Check: check, Detail: detail})
+}
+
+func (self *doctorReport) fail(check, detail, fix string) {
+	*self = append(*self, doctorFinding{Failed: true, Check: check, Detail: detail, Fix: fix})
+}
+
+// how many checks failed
+func (self doctorReport) Failures() (n int) {
+	for _, f := range self {
+		if f.Failed {
+			n++
+		}
+	}
+	return
+}
+
+func (self doctorReport) Print(w io.Writer) {
+	for _, f := range self {
+		if f.Failed {
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", f.Check, f.Detail)
+			if f.Fix != "" {
+				fmt.Fprintf(w, "       fix: %s\n", f.Fix)
+			}
+		} else {
+			fmt.Fprintf(w, "[ ok ] %s: %s\n", f.Check, f.Detail)
+		}
+	}
+}
+
+// at most n of items picked at random
+func doctorSample(items []string, n int) []string {
+	if len(items) <= n {
+		return items
+	}
+	sample := make([]string, n)
+	for idx, pick := range rand.Perm(len(items))[:n] {
+		sample[idx] = items[pick]
+	}
+	return sample
+}
+
+// is there an executable at path?
+func (self *doctorReport) checkBinary(key, path string) {
+	st, err := os.Stat(path)
+	if err != nil {
+		self.fail("binary "+key, err.Error(), "install it or set "+key+" in [articles] of srnd.ini")
+	} else if st.Mode()&0111 == 0 {
+		self.fail("binary "+key, path+" is not executable", "chmod +x "+path)
+	} else {
+		self.ok("binary "+key, path)
+	}
+}
+
+// is there a directory at path we can write to?
+func (self *doctorReport) checkDir(key, path string) {
+	st, err := os.Stat(path)
+	if err != nil {
+		self.fail("directory "+key, err.Error(), "create "+path+" or set "+key+" in srnd.ini")
+		return
+	}
+	if !st.IsDir() {
+		self.fail("directory "+key, path+" is not a directory", "set "+key+" to a directory")
+		return
+	}
+	f, err := ioutil.TempFile(path, ".doctor")
+	if err != nil {
+		self.fail("directory "+key, "cannot write to "+path+": "+err.Error(), "give the user srnd runs as write access to "+path)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	self.ok("directory "+key, path)
+}
+
+// check a node and report what is wrong with it
+func Doctor(conf *SRNdConfig) (report doctorReport) {
+	problems := conf.Problems()
+	for _, p := range problems {
+		report.fail("config", p, "add it to srnd.ini, a fresh one is made if you move the old one away")
+	}
+	if len(problems) > 0 {
+		return
+	}
+	report.ok("config", "srnd.ini has everything we need")
+
+	for _, key := range []string{"convert_bin", "ffmpegthumbnailer_bin", "sox_bin"} {
+		report.checkBinary(key, conf.store[key])
+	}
+	for _, key := range []string{"store_dir", "incoming_dir", "attachments_dir", "thumbs_dir"} {
+		report.checkDir(key, conf.store[key])
+	}
+	if conf.store["trash_dir"] != "" {
+		report.checkDir("trash_dir", conf.store["trash_dir"])
+	}
+	if conf.daemon["keep_rejected"] == "1" && conf.store["rejected_dir"] != "" {
+		report.checkDir("rejected_dir", conf.store["rejected_dir"])
+	}
+	if conf.frontend["enable"] == "1" {
+		report.checkDir("webroot", conf.frontend["webroot"])
+		if st, err := os.Stat(conf.frontend["templates"]); err != nil || !st.IsDir() {
+			report.fail("templates", "no templates at "+conf.frontend["templates"], "set templates in [frontend] to the contrib/templates directory you use")
+		} else {
+			report.ok("templates", conf.frontend["templates"])
+		}
+	}
+	if conf.api != nil && conf.api.srndAddr != "" && conf.api.secret == "" {
+		report.fail("api", "srnd takes frontends but has no secret", "set secret in [api]")
+	}
+	if conf.api != nil && conf.api.srndAddr != "" && conf.api.tls {
+		if _, err := conf.api.serverTLS(); err != nil {
+			report.fail("api tls", err.Error(), "set tls_cert and tls_key in [api] to srnd's certificate and key")
+		}
+	}
+
+	db := toolDatabase(conf)
+	defer db.Close()
+	if p, ok := db.(databasePinger); ok {
+		if err := p.Ping(); err != nil {
+			report.fail("database", err.Error(), "check host, port, user and password in [database] and that the database is running")
+			return
+		}
+	}
+	report.ok("database", fmt.Sprintf("%s answers in %s", conf.database["type"], databaseLatency(db)))
+
+	// articles the database has but the spool doesn't, and the other way around
+	var articles []string
+	for _, e := range db.GetAllArticles() {
+		articles = append(articles, e.MessageID())
+	}
+	var missing int
+	sample := doctorSample(articles, doctorSampleSize)
+	for _, msgid := range sample {
+		if !CheckFile(filepath.Join(conf.store["store_dir"], msgid)) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		report.fail("articles", fmt.Sprintf("%d of %d articles we sampled from the database are not in the spool", missing, len(sample)), "restore store_dir from a backup")
+	} else {
+		report.ok("articles", fmt.Sprintf("%d articles sampled from the database are in the spool", len(sample)))
+	}
+	var files []string
+	if f, err := os.Open(conf.store["store_dir"]); err == nil {
+		names, _ := f.Readdirnames(0)
+		f.Close()
+		for _, name := range names {
+			if ValidMessageID(name) {
+				files = append(files, name)
+			}
+		}
+	}
+	missing = 0
+	sample = doctorSample(files, doctorSampleSize)
+	for _, msgid := range sample {
+		if !db.HasArticle(msgid) && !db.ArticleBanned(msgid) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		report.fail("spool", fmt.Sprintf("%d of %d articles we sampled from the spool are not in the database", missing, len(sample)), "put them back with srnd tool reindex")
+	} else {
+		report.ok("spool", fmt.Sprintf("%d articles sampled from the spool are in the database", len(sample)))
+	}
+
+	// thumbnails
+	var atts []string
+	if f, err := os.Open(conf.store["attachments_dir"]); err == nil {
+		atts, _ = f.Readdirnames(0)
+		f.Close()
+	}
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	missing = 0
+	sample = doctorSample(atts, doctorSampleSize)
+	for _, fname := range sample {
+		if !CheckFile(store.ThumbnailFilepath(fname)) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		report.fail("thumbnails", fmt.Sprintf("%d of %d attachments we sampled have no thumbnail", missing, len(sample)), "make them with srnd tool rebuild-thumbnails")
+	} else {
+		report.ok("thumbnails", fmt.Sprintf("%d attachments sampled have thumbnails", len(sample)))
+	}
+	return
+}
+
+// srnd doctor, returns how many checks failed
+func DoctorTool(w io.Writer) int {
+	conf, err := LoadConfig()
+	if err != nil {
+		var report doctorReport
+		if errs, ok := err.(ConfigErrors); ok {
+			for _, p := range errs {
+				report.fail("config", p, "fix it in srnd.ini, srnd checkconf shows where every option comes from")
+			}
+		} else {
+			report.fail("config", err.Error(), "")
+		}
+		report.Print(w)
+		return report.Failures()
+	}
+	report := Doctor(conf)
+	report.Print(w)
+	return report.Failures()
+}
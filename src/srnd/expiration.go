@@ -25,8 +25,8 @@ type ExpirationCore interface {
 	Mainloop()
 }
 
-func createExpirationCore(database Database, store ArticleStore) ExpirationCore {
-	return expire{database, store, make(chan deleteEvent, 1024)}
+func createExpirationCore(database Database, store ArticleStore, archive *threadArchiver) ExpirationCore {
+	return expire{database, store, make(chan deleteEvent, 1024), archive}
 }
 
 type deleteEvent string
@@ -44,6 +44,8 @@ type expire struct {
 	store    ArticleStore
 	// channel to send delete requests down
 	delChan chan deleteEvent
+	// renders threads to static html before they go, nil to not archive
+	archive *threadArchiver
 }
 
 func (self expire) ExpirePost(messageID string) {
@@ -66,11 +68,16 @@ func (self expire) ExpireGroup(newsgroup string, keep int) {
 	log.Println("Expire group", newsgroup, keep)
 	threads := self.database.GetRootPostsForExpiration(newsgroup, keep)
 	for _, root := range threads {
+		if self.database.CheckThreadFlag(root, ThreadSticky) {
+			// sticky threads stay
+			continue
+		}
 		self.ExpireThread(root)
 	}
 }
 
 func (self expire) ExpireThread(rootMsgid string) {
+	self.archive.Archive(rootMsgid)
 	replies, err := self.database.GetMessageIDByHeader("References", rootMsgid)
 	if err == nil {
 		for _, reply := range replies {
@@ -127,27 +134,31 @@ func (self expire) ExpireOrphans() {
 
 func (self expire) Mainloop() {
 	for {
-		ev := <-self.delChan
-		log.Println("expire", ev.MessageID())
-		atts := self.database.GetPostAttachments(ev.MessageID())
-		// remove all attachments
-		if atts != nil {
-			for _, att := range atts {
-				img := self.store.AttachmentFilepath(att)
-				os.Remove(img)
-				thm := self.store.ThumbnailFilepath(att)
-				os.Remove(thm)
-			}
-		}
-		err := self.database.BanArticle(ev.MessageID(), "expired")
-		if err != nil {
-			log.Println("failed to ban for expiration", err)
-		}
-		err = self.database.DeleteArticle(ev.MessageID())
-		if err != nil {
-			log.Println("failed to delete article", err)
+		self.remove(<-self.delChan)
+	}
+}
+
+// delete an article, its attachments and its database entries now
+func (self expire) remove(ev deleteEvent) {
+	log.Println("expire", ev.MessageID())
+	atts := self.database.GetPostAttachments(ev.MessageID())
+	// remove all attachments
+	if atts != nil {
+		for _, att := range atts {
+			img := self.store.AttachmentFilepath(att)
+			os.Remove(img)
+			thm := self.store.ThumbnailFilepath(att)
+			os.Remove(thm)
 		}
-		// remove article
-		os.Remove(ev.Path())
 	}
+	err := self.database.BanArticle(ev.MessageID(), "expired")
+	if err != nil {
+		log.Println("failed to ban for expiration", err)
+	}
+	err = self.database.DeleteArticle(ev.MessageID())
+	if err != nil {
+		log.Println("failed to delete article", err)
+	}
+	// remove article
+	os.Remove(ev.Path())
 }
@@ -0,0 +1,215 @@
+//
+// expire_tool.go -- applying retention from the shell now instead of waiting for the daemon to get to it
+//
+
+package srnd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// what expiring would remove
+type expirePlan struct {
+	// roots of threads that go with all their replies
+	threads map[string][]string
+	// replies past article_lifetime, and articles whose files or roots are gone
+	posts []string
+	// files in attachments_dir no article has
+	attachments []string
+	// trashed articles kept longer than trash_hours
+	trash []TrashedArticle
+}
+
+// trashed articles trashed before a time
+func staleTrash(trashed []TrashedArticle, before time.Time) (stale []TrashedArticle) {
+	for _, t := range trashed {
+		if t.Trashed < before.Unix() {
+			stale = append(stale, t)
+		}
+	}
+	return
+}
+
+// files younger than this are left alone, the daemon saves attachments before it registers their article
+const orphanAttachmentAge = time.Hour
+
+// attachment files older than minAge no article in the database has
+// files not named after a hash are not ours and are never orphans
+func orphanAttachments(db Database, store ArticleStore, minAge time.Duration) (orphans []string, err error) {
+	var infos []os.FileInfo
+	infos, err = ioutil.ReadDir(store.AttachmentDir())
+	if err != nil {
+		return
+	}
+	var have map[string]bool
+	have, err = db.GetAttachmentHashes()
+	if err != nil {
+		return
+	}
+	before := time.Now().Add(-minAge)
+	for _, info := range infos {
+		hash, ok := attachmentFileHash(info.Name())
+		if ok && info.Mode().IsRegular() && info.ModTime().Before(before) && !have[hash] {
+			orphans = append(orphans, info.Name())
+		}
+	}
+	sort.Strings(orphans)
+	return
+}
+
+// work out what retention in conf removes right now, the same rules the daemon expires by
+func planExpiration(conf *SRNdConfig, db Database, store ArticleStore) (plan expirePlan) {
+	plan.threads = make(map[string][]string)
+	seen := make(map[string]bool)
+	addThread := func(root string) {
+		if _, ok := plan.threads[root]; ok {
+			return
+		}
+		replies, _ := db.GetMessageIDByHeader("References", root)
+		plan.threads[root] = replies
+		seen[root] = true
+		for _, reply := range replies {
+			seen[reply] = true
+		}
+	}
+	addPost := func(msgid string) {
+		if !seen[msgid] {
+			seen[msgid] = true
+			plan.posts = append(plan.posts, msgid)
+		}
+	}
+	// an archive keeps its articles
+	if conf.daemon["archive"] != "1" {
+		// threads pushed off the last page
+		for _, group := range db.GetAllNewsgroups() {
+			rollover := 100
+			tpp, err := db.GetThreadsPerPage(group)
+			ppb, err := db.GetPagesPerBoard(group)
+			if err == nil {
+				rollover = tpp * ppb
+			}
+			rollover = conf.groups[group].Threads(rollover)
+			for _, root := range db.GetRootPostsForExpiration(group, rollover) {
+				if !db.CheckThreadFlag(root, ThreadSticky) {
+					addThread(root)
+				}
+			}
+		}
+		// articles past their lifetime
+		old, err := postsPastLifetime(db, conf.groups, mapGetInt(conf.daemon, "article_lifetime", 0))
+		if err != nil {
+			log.Println("failed to get older posts", err)
+		}
+		for _, msgid := range old {
+			hdr := store.GetHeaders(msgid)
+			ref := ""
+			if hdr != nil {
+				ref = hdr.Get("References", "")
+			}
+			if ref == "" || ref == msgid {
+				addThread(msgid)
+			} else {
+				addPost(msgid)
+			}
+		}
+	}
+	// articles we lost the file of, replies we lost the root of
+	for _, e := range db.GetAllArticles() {
+		msgid := e.MessageID()
+		if seen[msgid] {
+			continue
+		}
+		hdr := store.GetHeaders(msgid)
+		if hdr == nil {
+			addPost(msgid)
+		} else if ref := hdr.Get("References", ""); ref != "" && !store.HasArticle(ref) {
+			addPost(msgid)
+		}
+	}
+	var err error
+	plan.attachments, err = orphanAttachments(db, store, orphanAttachmentAge)
+	if err != nil {
+		log.Println("failed to find orphan attachments", err)
+	}
+	trashHours := mapGetInt(conf.store, "trash_hours", 24)
+	if len(conf.store["trash_dir"]) > 0 && trashHours > 0 {
+		trashed, err := db.GetTrashedArticles()
+		if err != nil {
+			log.Println("failed to get trashed articles", err)
+		}
+		plan.trash = staleTrash(trashed, time.Now().Add(-time.Duration(trashHours)*time.Hour))
+	}
+	return
+}
+
+// print the plan, one line for each thing removed
+func (self expirePlan) Print(w io.Writer, verb string) {
+	roots := make([]string, 0, len(self.threads))
+	for root := range self.threads {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	var articles int
+	for _, root := range roots {
+		fmt.Fprintf(w, "thread %s with %d replies\n", root, len(self.threads[root]))
+		articles += 1 + len(self.threads[root])
+	}
+	for _, msgid := range self.posts {
+		fmt.Fprintf(w, "article %s\n", msgid)
+	}
+	for _, fname := range self.attachments {
+		fmt.Fprintf(w, "attachment %s\n", fname)
+	}
+	for _, t := range self.trash {
+		fmt.Fprintf(w, "trash %s trashed %s\n", t.MessageID, time.Unix(t.Trashed, 0).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "%s %d threads, %d articles, %d orphan attachments and %d trashed articles\n", verb, len(roots), articles+len(self.posts), len(self.attachments), len(self.trash))
+}
+
+// expire what retention says should go now and print what was removed
+// usage: [-dry-run]
+func ExpireTool(args []string) {
+	flags := flag.NewFlagSet("expire", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print what would be removed and remove nothing")
+	flags.Parse(args)
+	conf := ReadConfig()
+	db := toolDatabase(conf)
+	defer db.Close()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	plan := planExpiration(conf, db, store)
+	if *dryRun {
+		plan.Print(os.Stdout, "would remove")
+		return
+	}
+	core := expire{database: db, store: store, archive: threadArchiverFromConfig(conf.frontend, db, store)}
+	for root, replies := range plan.threads {
+		core.archive.Archive(root)
+		for _, reply := range replies {
+			core.remove(deleteEvent(store.GetFilename(reply)))
+		}
+		core.remove(deleteEvent(store.GetFilename(root)))
+		db.DeleteThread(root)
+	}
+	for _, msgid := range plan.posts {
+		core.remove(deleteEvent(store.GetFilename(msgid)))
+	}
+	for _, fname := range plan.attachments {
+		DelFile(store.AttachmentFilepath(fname))
+		DelFile(store.ThumbnailFilepath(fname))
+	}
+	for _, t := range plan.trash {
+		store.EmptyTrash(t.MessageID, t.Attachments)
+		err := db.ForgetTrashedArticle(t.MessageID)
+		if err != nil {
+			log.Println("failed to forget trashed article", t.MessageID, err)
+		}
+	}
+	plan.Print(os.Stdout, "removed")
+}
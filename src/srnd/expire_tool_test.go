@@ -0,0 +1,20 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleTrash(t *testing.T) {
+
+	now := time.Unix(100000, 0)
+	trashed := []TrashedArticle{
+		{MessageID: "<old@test>", Trashed: now.Unix() - 7200},
+		{MessageID: "<new@test>", Trashed: now.Unix() - 60},
+	}
+	stale := staleTrash(trashed, now.Add(-time.Hour))
+	if len(stale) != 1 || stale[0].MessageID != "<old@test>" {
+		t.Error("bad stale trash", stale)
+	}
+
+}
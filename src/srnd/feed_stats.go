@@ -0,0 +1,130 @@
+//
+// feed_stats.go -- per feed health tracking
+//
+
+package srnd
+
+import (
+	"sync"
+	"time"
+)
+
+// running counters for a persisted feed
+// shared by all connections that belong to the feed
+// all methods are safe to call on nil
+type feedStats struct {
+	access sync.Mutex
+	// number of connections currently up
+	connected int
+	// last time a connection to this feed came up
+	lastConnected time.Time
+	// last error we had with this feed
+	lastError     string
+	lastErrorTime time.Time
+	// article transfer counters
+	articlesSent     int64
+	articlesReceived int64
+	bytesSent        int64
+	bytesReceived    int64
+}
+
+// a snapshot of a feed's health for reporting
+type feedHealth struct {
+	Name             string    `json:"name"`
+	Addr             string    `json:"addr"`
+	Paused           bool      `json:"paused"`
+	Connected        int       `json:"connected"`
+	LastConnected    time.Time `json:"last_connected"`
+	LastError        string    `json:"last_error"`
+	LastErrorTime    time.Time `json:"last_error_time"`
+	Backlog          int64     `json:"backlog"`
+	Pending          int       `json:"pending"`
+	ArticlesSent     int64     `json:"articles_sent"`
+	ArticlesReceived int64     `json:"articles_received"`
+	BytesSent        int64     `json:"bytes_sent"`
+	BytesReceived    int64     `json:"bytes_received"`
+}
+
+func (self *feedStats) Connected() {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	self.connected++
+	self.lastConnected = time.Now()
+	self.access.Unlock()
+}
+
+func (self *feedStats) Disconnected() {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	if self.connected > 0 {
+		self.connected--
+	}
+	self.access.Unlock()
+}
+
+// record an error that happened on this feed
+func (self *feedStats) Error(err error) {
+	if self == nil || err == nil {
+		return
+	}
+	self.access.Lock()
+	self.lastError = err.Error()
+	self.lastErrorTime = time.Now()
+	self.access.Unlock()
+}
+
+// record that we sent an article of sz bytes
+func (self *feedStats) Sent(sz int64) {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	self.articlesSent++
+	self.bytesSent += sz
+	self.access.Unlock()
+}
+
+// record that we got an article of sz bytes
+func (self *feedStats) Received(sz int64) {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	self.articlesReceived++
+	self.bytesReceived += sz
+	self.access.Unlock()
+}
+
+// make a health report from a feed's status
+func (status *feedStatus) Health() (h feedHealth) {
+	if status.State == nil {
+		return
+	}
+	h.Name = status.State.Config.Name
+	h.Addr = status.State.Config.Addr
+	h.Paused = status.State.Paused
+	for _, c := range status.Conns {
+		h.Backlog += c.GetBacklog()
+		c.pending_access.Lock()
+		h.Pending += len(c.pending)
+		c.pending_access.Unlock()
+	}
+	st := status.State.Stats
+	if st != nil {
+		st.access.Lock()
+		h.Connected = st.connected
+		h.LastConnected = st.lastConnected
+		h.LastError = st.lastError
+		h.LastErrorTime = st.lastErrorTime
+		h.ArticlesSent = st.articlesSent
+		h.ArticlesReceived = st.articlesReceived
+		h.BytesSent = st.bytesSent
+		h.BytesReceived = st.bytesReceived
+		st.access.Unlock()
+	}
+	return
+}
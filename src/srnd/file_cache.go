@@ -3,6 +3,7 @@ package srnd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -37,6 +38,50 @@ type FileCache struct {
 	regenThreadLock  sync.RWMutex
 	regenBoardLock   sync.RWMutex
 	regenCatalogLock sync.RWMutex
+
+	// render the whole site on start and json catalogs too
+	// so webroot can be served by itself
+	static bool
+}
+
+// writes to a temporary file and moves it in place on close
+// so whatever serves the webroot never sees half a page
+type atomicFile struct {
+	*os.File
+	fname string
+}
+
+func createAtomicFile(fname string) (*atomicFile, error) {
+	f, err := os.Create(fname + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{f, fname}, nil
+}
+
+func (self *atomicFile) Close() (err error) {
+	err = self.File.Close()
+	if err == nil {
+		err = os.Rename(self.File.Name(), self.fname)
+	}
+	if err != nil {
+		os.Remove(self.File.Name())
+	}
+	return
+}
+
+// render a page to a file in the webroot
+func (self *FileCache) writeFile(fname string, gen func(io.Writer)) {
+	wr, err := createAtomicFile(fname)
+	if err != nil {
+		log.Println("did not write", fname, err)
+		return
+	}
+	gen(wr)
+	err = wr.Close()
+	if err != nil {
+		log.Println("did not write", fname, err)
+	}
 }
 
 func (self *FileCache) DeleteBoardMarkup(group string) {
@@ -80,8 +125,12 @@ func (self *FileCache) getFilenameForBoardPage(boardname string, pageno int, jso
 	return filepath.Join(self.webroot_dir, fname)
 }
 
-func (self *FileCache) getFilenameForCatalog(boardname string) string {
-	fname := fmt.Sprintf("catalog-%s.html", boardname)
+func (self *FileCache) getFilenameForCatalog(boardname string, json bool) string {
+	ext := "html"
+	if json {
+		ext = "json"
+	}
+	fname := fmt.Sprintf("catalog-%s.%s", boardname, ext)
 	return filepath.Join(self.webroot_dir, fname)
 }
 
@@ -105,13 +154,9 @@ func (self *FileCache) RegenAll() {
 }
 
 func (self *FileCache) regenLongTerm() {
-	wr, err := os.Create(filepath.Join(self.webroot_dir, "history.html"))
-	defer wr.Close()
-	if err != nil {
-		log.Println("cannot render history graph", err)
-		return
-	}
-	template.genGraphs(self.prefix, wr, self.database)
+	self.writeFile(filepath.Join(self.webroot_dir, "history.html"), func(wr io.Writer) {
+		template.genGraphs(self.prefix, wr, self.database)
+	})
 }
 
 func (self *FileCache) pollLongTerm() {
@@ -183,14 +228,9 @@ func (self *FileCache) RegenerateBoard(group string) {
 func (self *FileCache) regenerateThread(root ArticleEntry, json bool) {
 	msgid := root.MessageID()
 	if self.store.HasArticle(msgid) {
-		fname := self.getFilenameForThread(msgid, json)
-		wr, err := os.Create(fname)
-		defer wr.Close()
-		if err != nil {
-			log.Println("did not write", fname, err)
-			return
-		}
-		template.genThread(self.attachments, root, self.prefix, self.name, wr, self.database, json)
+		self.writeFile(self.getFilenameForThread(msgid, json), func(wr io.Writer) {
+			template.genThread(self.attachments, root, self.prefix, self.name, wr, self.database, json)
+		})
 	} else {
 		log.Println("don't have root post", msgid, "not regenerating thread")
 	}
@@ -198,94 +238,66 @@ func (self *FileCache) regenerateThread(root ArticleEntry, json bool) {
 
 // regenerate just a page on a board
 func (self *FileCache) regenerateBoardPage(board string, page int, json bool) {
-	fname := self.getFilenameForBoardPage(board, page, json)
-	wr, err := os.Create(fname)
-	defer wr.Close()
-	if err != nil {
-		log.Println("error generating board page", page, "for", board, err)
-		return
-	}
-	template.genBoardPage(self.attachments, self.prefix, self.name, board, page, wr, self.database, json)
+	self.writeFile(self.getFilenameForBoardPage(board, page, json), func(wr io.Writer) {
+		template.genBoardPage(self.attachments, self.prefix, self.name, board, page, wr, self.database, json)
+	})
 }
 
 // regenerate the catalog for a board
 func (self *FileCache) regenerateCatalog(board string) {
-	fname := self.getFilenameForCatalog(board)
-	wr, err := os.Create(fname)
-	defer wr.Close()
-	if err != nil {
-		log.Println("error generating catalog for", board, err)
-		return
+	self.writeFile(self.getFilenameForCatalog(board, false), func(wr io.Writer) {
+		template.genCatalog(self.prefix, self.name, board, wr, self.database, false)
+	})
+	if self.static {
+		self.writeFile(self.getFilenameForCatalog(board, true), func(wr io.Writer) {
+			template.genCatalog(self.prefix, self.name, board, wr, self.database, true)
+		})
 	}
-	template.genCatalog(self.prefix, self.name, board, wr, self.database)
 }
 
 // regenerate the front page
 func (self *FileCache) RegenFrontPage() {
-	indexwr, err1 := os.Create(filepath.Join(self.webroot_dir, "index.html"))
-	defer indexwr.Close()
-	if err1 != nil {
-		log.Println("cannot render front page", err1)
+	indexwr, err := createAtomicFile(filepath.Join(self.webroot_dir, "index.html"))
+	if err != nil {
+		log.Println("cannot render front page", err)
 		return
 	}
-	boardswr, err2 := os.Create(filepath.Join(self.webroot_dir, "boards.html"))
-	defer boardswr.Close()
-	if err2 != nil {
-		log.Println("cannot render board list page", err2)
+	boardswr, err := createAtomicFile(filepath.Join(self.webroot_dir, "boards.html"))
+	if err != nil {
+		indexwr.Close()
+		log.Println("cannot render board list page", err)
 		return
 	}
 
 	template.genFrontPage(10, self.prefix, self.name, indexwr, boardswr, self.database)
+	indexwr.Close()
+	boardswr.Close()
 
-	j_boardswr, err2 := os.Create(filepath.Join(self.webroot_dir, "boards.json"))
-	g := self.database.GetAllNewsgroups()
-	err := json.NewEncoder(j_boardswr).Encode(g)
-	if err != nil {
-		log.Println("cannot render boards.json", err)
-	}
-
+	self.writeFile(filepath.Join(self.webroot_dir, "boards.json"), func(wr io.Writer) {
+		g := self.database.GetAllNewsgroups()
+		err := json.NewEncoder(wr).Encode(g)
+		if err != nil {
+			log.Println("cannot render boards.json", err)
+		}
+	})
 }
 
 // regenerate the overboard
 func (self *FileCache) regenUkko() {
-
-	// markup
-	fname := filepath.Join(self.webroot_dir, "ukko.html")
-	wr, err := os.Create(fname)
-	defer wr.Close()
-	if err != nil {
-		log.Println("error generating ukko markup", err)
-		return
-	}
-	template.genUkko(self.prefix, self.name, wr, self.database, false)
-
-	// json
-	fname = filepath.Join(self.webroot_dir, "ukko.json")
-	wr, err = os.Create(fname)
-	defer wr.Close()
-	if err != nil {
-		log.Println("error generating ukko json", err)
-		return
-	}
-	template.genUkko(self.prefix, self.name, wr, self.database, true)
-	i := 0
-	for i < 10 {
-		fname := fmt.Sprintf("ukko-%d.html", i)
-		jname := fmt.Sprintf("ukko-%d.json", i)
-		f, err := os.Create(fname)
-		if err != nil {
-			log.Println("Failed to create html ukko", i, err)
-			return
-		}
-		defer f.Close()
-		template.genUkkoPaginated(self.prefix, self.name, f, self.database, i, false)
-		j, err := os.Create(jname)
-		if err != nil {
-			log.Printf("failed to create json ukko", i, err)
-			return
-		}
-		defer j.Close()
-		template.genUkkoPaginated(self.prefix, self.name, j, self.database, i, true)
+	self.writeFile(filepath.Join(self.webroot_dir, "ukko.html"), func(wr io.Writer) {
+		template.genUkko(self.prefix, self.name, wr, self.database, false)
+	})
+	self.writeFile(filepath.Join(self.webroot_dir, "ukko.json"), func(wr io.Writer) {
+		template.genUkko(self.prefix, self.name, wr, self.database, true)
+	})
+	for i := 0; i < 10; i++ {
+		page := i
+		self.writeFile(filepath.Join(self.webroot_dir, fmt.Sprintf("ukko-%d.html", page)), func(wr io.Writer) {
+			template.genUkkoPaginated(self.prefix, self.name, wr, self.database, page, false)
+		})
+		self.writeFile(filepath.Join(self.webroot_dir, fmt.Sprintf("ukko-%d.json", page)), func(wr io.Writer) {
+			template.genUkkoPaginated(self.prefix, self.name, wr, self.database, page, true)
+		})
 	}
 }
 
@@ -318,6 +330,18 @@ func (self *FileCache) Start() {
 	}
 	// run long term regen jobs
 	go self.pollLongTerm()
+	if self.static {
+		go self.regenSite()
+	}
+}
+
+// render every page we have so the webroot is complete
+func (self *FileCache) regenSite() {
+	log.Println("rendering the whole site to", self.webroot_dir)
+	self.RegenFrontPage()
+	self.regenUkko()
+	self.regenLongTerm()
+	self.RegenAll()
 }
 
 func (self *FileCache) Regen(msg ArticleEntry) {
@@ -342,6 +366,18 @@ func (self *FileCache) Close() {
 }
 
 func NewFileCache(prefix, webroot, name string, threads int, attachments bool, db Database, store ArticleStore) CacheInterface {
+	return newFileCache(prefix, webroot, name, threads, attachments, db, store)
+}
+
+// a file cache that renders the whole site so nginx or a cdn can serve the webroot
+// the daemon then only has to take posts and regenerate what changed
+func NewStaticCache(prefix, webroot, name string, threads int, attachments bool, db Database, store ArticleStore) CacheInterface {
+	cache := newFileCache(prefix, webroot, name, threads, attachments, db, store)
+	cache.static = true
+	return cache
+}
+
+func newFileCache(prefix, webroot, name string, threads int, attachments bool, db Database, store ArticleStore) *FileCache {
 	cache := new(FileCache)
 
 	cache.regenBoardTicker = time.NewTicker(time.Second * 10)
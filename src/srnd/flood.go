@@ -0,0 +1,155 @@
+//
+// flood.go -- detect bursts of posts from one address or of one body
+//
+
+package srnd
+
+import (
+	"crypto/sha512"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a post we saw recently
+type floodPost struct {
+	msgid     string
+	newsgroup string
+	// encrypted address it came from, empty if we don't know
+	encaddr string
+	posted  time.Time
+}
+
+type floodDetector struct {
+	access sync.Mutex
+	// how far back we look
+	window time.Duration
+	// max posts from one address within window
+	posts int
+	// max posts with the same body within window
+	duplicates int
+	// how long offending addresses are banned
+	ban time.Duration
+	// encrypted address -> recent posts
+	addrs map[string][]floodPost
+	// hash of body -> recent posts
+	bodies map[string][]floodPost
+	// last time we expired everything
+	expired time.Time
+}
+
+func newFloodDetector(window time.Duration, posts, duplicates int, ban time.Duration) *floodDetector {
+	return &floodDetector{
+		window:     window,
+		posts:      posts,
+		duplicates: duplicates,
+		ban:        ban,
+		addrs:      make(map[string][]floodPost),
+		bodies:     make(map[string][]floodPost),
+	}
+}
+
+// drop posts older than window
+func (self *floodDetector) recent(posts []floodPost, now time.Time) []floodPost {
+	idx := 0
+	for idx < len(posts) && now.Sub(posts[idx].posted) > self.window {
+		idx++
+	}
+	return posts[idx:]
+}
+
+// record a post seen at now under key in m
+// return the burst of posts including this one if there are more than limit of them
+func (self *floodDetector) record(m map[string][]floodPost, key string, post floodPost, limit int) (burst []floodPost) {
+	posts := append(self.recent(m[key], post.posted), post)
+	if limit > 0 && len(posts) > limit {
+		// start counting again so we only report each burst once
+		burst = posts
+		posts = nil
+	}
+	if len(posts) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = posts
+	}
+	return
+}
+
+// record a post and check if it is part of a flood
+// returns the posts in the flood
+func (self *floodDetector) Check(nntp NNTPMessage, now time.Time) (burst []floodPost) {
+	if self == nil {
+		return
+	}
+	encaddr := nntp.Addr()
+	post := floodPost{
+		msgid:     nntp.MessageID(),
+		newsgroup: nntp.Newsgroup(),
+		encaddr:   encaddr,
+		posted:    now,
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	if now.Sub(self.expired) > self.window {
+		self.expire(now)
+	}
+	if len(encaddr) > 0 {
+		burst = self.record(self.addrs, encaddr, post, self.posts)
+	}
+	body := strings.TrimSpace(nntp.Message())
+	if len(body) > 0 {
+		h := sha512.Sum512([]byte(body))
+		dupes := self.record(self.bodies, hexify(h[:]), post, self.duplicates)
+		if len(dupes) > len(burst) {
+			burst = dupes
+		}
+	}
+	return
+}
+
+// the addresses the posts of a flood came from, each once
+// a flood of one body can come from many addresses
+func floodAddrs(burst []floodPost) (addrs []string) {
+	seen := make(map[string]bool)
+	for _, post := range burst {
+		if len(post.encaddr) > 0 && !seen[post.encaddr] {
+			seen[post.encaddr] = true
+			addrs = append(addrs, post.encaddr)
+		}
+	}
+	return
+}
+
+// expire everything older than window so we don't grow forever
+func (self *floodDetector) expire(now time.Time) {
+	self.expired = now
+	for _, m := range []map[string][]floodPost{self.addrs, self.bodies} {
+		for k, posts := range m {
+			posts = self.recent(posts, now)
+			if len(posts) == 0 {
+				delete(m, k)
+			} else {
+				m[k] = posts
+			}
+		}
+	}
+}
+
+// how long an address that floods is banned for
+func (self *floodDetector) BanDuration() time.Duration {
+	return self.ban
+}
+
+// create the flood detector from config, nil if it is disabled
+func floodDetectorFromConfig(conf *SRNdConfig) *floodDetector {
+	if conf.flood["enable"] != "1" {
+		return nil
+	}
+	window := mapGetInt(conf.flood, "window", 60)
+	posts := mapGetInt(conf.flood, "posts", 10)
+	duplicates := mapGetInt(conf.flood, "duplicates", 3)
+	ban := mapGetInt(conf.flood, "ban", 600)
+	log.Printf("flood detection enabled, posts=%d duplicates=%d window=%ds ban=%ds", posts, duplicates, window, ban)
+	return newFloodDetector(time.Duration(window)*time.Second, posts, duplicates, time.Duration(ban)*time.Second)
+}
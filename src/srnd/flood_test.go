@@ -0,0 +1,44 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostLimits(t *testing.T) {
+
+	limits := postLimits{subject: 5, lines: 2}
+	if limits.Check(&postRequest{Subject: "héllo", Message: "a\nb\n"}) != nil {
+		t.Error("post within limits should pass")
+	}
+	if limits.Check(&postRequest{Subject: "hello!"}) == nil {
+		t.Error("long subject should not pass")
+	}
+	if limits.Check(&postRequest{Message: "a\nb\nc"}) == nil {
+		t.Error("too many lines should not pass")
+	}
+
+}
+
+func TestFloodAddrs(t *testing.T) {
+
+	f := newFloodDetector(time.Minute, 10, 2, time.Minute)
+	now := time.Now()
+	var burst []floodPost
+	for i, addr := range []string{"addr-a", "addr-b", "addr-c"} {
+		nntp := newPlaintextArticle("the same body", "", "", "", "test.tld", genMessageID("test.tld"), "overchan.test")
+		nntp.(*nntpArticle).headers.Set("X-Encrypted-Ip", addr)
+		burst = f.Check(nntp, now.Add(time.Duration(i)*time.Second))
+		if i < 2 && len(burst) > 0 {
+			t.Error("flood before the limit", i, burst)
+		}
+	}
+	addrs := floodAddrs(burst)
+	if len(burst) != 3 || len(addrs) != 3 || addrs[0] != "addr-a" || addrs[2] != "addr-c" {
+		t.Error("a flood of one body should ban every address in it", addrs, burst)
+	}
+	if len(floodAddrs([]floodPost{{encaddr: "x"}, {encaddr: "x"}, {}})) != 1 {
+		t.Error("addresses of a flood should be there once")
+	}
+
+}
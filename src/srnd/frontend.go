@@ -34,4 +34,10 @@ type Frontend interface {
 
 	// trigger a manual regen of indexes for a root post
 	Regen(msg ArticleEntry)
+
+	// tell the frontend a post in a thread with root post root was deleted
+	ArticleDeleted(msgid, root, group string)
+
+	// reload templates from disk and regen everything
+	ReloadTemplates()
 }
@@ -22,6 +22,9 @@ import (
 	"mime"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,6 +38,10 @@ type postAttachment struct {
 	Filename string `json:"name"`
 	Filedata string `json:"data"`
 	Filetype string `json:"type"`
+	// hide it until clicked
+	Spoiler bool `json:"spoiler"`
+	// what comes after attachment_ in the form part it came in
+	field string
 }
 
 // an api post request
@@ -51,6 +58,23 @@ type postRequest struct {
 	Dubs         bool              `json:"dubs"`
 	Message      string            `json:"message"`
 	ExtraHeaders map[string]string `json:"headers"`
+	ProofOfWork  string            `json:"pow"`
+	// lets the poster delete the post, one is made up if empty
+	Password string `json:"password"`
+	// url of a file for us to download and attach
+	AttachmentURL string `json:"attachment_url"`
+	// every attachment is a spoiler
+	Spoiler bool `json:"spoiler"`
+	// finished resumable uploads to attach
+	UploadIDs []string `json:"upload_ids"`
+	// logged in mods skip the posting cooldown
+	modExempt bool
+	// address lists the poster is on, looked up when the post is made if nil
+	addrListing []string
+	// country code of the poster, looked up when the post is made if empty
+	country string
+	// hex ed25519 seed to sign the post with instead of a tripcode in the name
+	signingKey []byte
 }
 
 // regenerate a newsgroup page
@@ -85,21 +109,48 @@ type liveChan struct {
 	resultchnl chan *liveChan
 	// subbed newsgroup
 	newsgroup string
+	// subbed thread's root message-id, empty for the whole newsgroup
+	thread string
 	// have we solved captcha?
 	captcha bool
 	// our ip address
 	IP string
 }
 
+// return true if this livechan is subbed to a post in group with root post root
+func (lc *liveChan) Wants(root, group string) bool {
+	if lc.newsgroup != "" && lc.newsgroup != group {
+		return false
+	}
+	return lc.thread == "" || lc.thread == root
+}
+
 // inform this livechan that we got a new post
 func (lc *liveChan) Inform(post PostModel) {
 	if lc.postchnl != nil {
-		if lc.newsgroup == "" || lc.newsgroup == post.Board() {
+		root := post.Reference()
+		if root == "" {
+			root = post.MessageID()
+		}
+		if lc.Wants(root, post.Board()) {
 			lc.postchnl <- post
 		}
 	}
 }
 
+// inform this livechan that a post was deleted
+func (lc *liveChan) InformDelete(post frontendPost) {
+	if lc.datachnl != nil && lc.Wants(post.Reference(), post.Newsgroup()) {
+		msg, _ := json.Marshal(map[string]string{
+			"Type":      "delete",
+			"Msgid":     post.MessageID(),
+			"Newsgroup": post.Newsgroup(),
+			"Root":      post.Reference(),
+		})
+		lc.datachnl <- msg
+	}
+}
+
 func (lc *liveChan) SendError(err error) {
 	msg, _ := json.Marshal(map[string]string{
 		"Type":  "error",
@@ -137,7 +188,7 @@ func (lc *liveChan) SendBanned() {
 func (lc *liveChan) handleMessage(front *httpFrontend, cmd *liveCommand) {
 
 	if cmd.Captcha != nil {
-		lc.captcha = captcha.VerifyString(cmd.Captcha.ID, cmd.Captcha.Solution)
+		lc.captcha = front.captcha.Verify(cmd.Captcha.ID, cmd.Captcha.Solution, "")
 		// send captcha result
 		msg, _ := json.Marshal(map[string]interface{}{
 			"Type":    "captcha",
@@ -153,6 +204,9 @@ func (lc *liveChan) handleMessage(front *httpFrontend, cmd *liveCommand) {
 		if lc.newsgroup != "" {
 			cmd.Post.Group = lc.newsgroup
 		}
+		if lc.thread != "" {
+			cmd.Post.Reference = lc.thread
+		}
 		cmd.Post.ExtraHeaders = map[string]string{"X-Livechan": "1"}
 		front.handle_postRequest(cmd.Post, lc.SendBanned, lc.SendError, lc.PostSuccess, false)
 	} else if cmd.Captcha == nil {
@@ -182,6 +236,14 @@ type httpFrontend struct {
 	webroot_dir  string
 	template_dir string
 	static_dir   string
+	// per newsgroup template overrides, empty for none
+	board_template_dir string
+	// css theme for readers who did not pick one, empty for none
+	defaultTheme string
+	// static archive of expired threads, empty for none
+	archive_dir string
+	// how often we check for changed templates, 0 to not check
+	templateReload time.Duration
 
 	regen_threads  int
 	regen_on_start bool
@@ -203,6 +265,7 @@ type httpFrontend struct {
 	attachmentLimit int
 
 	liveui_chnl       chan PostModel
+	liveui_delete     chan frontendPost
 	liveui_register   chan *liveChan
 	liveui_deregister chan *liveChan
 	end_liveui        chan bool
@@ -210,6 +273,60 @@ type httpFrontend struct {
 	// maps uuid -> liveChan
 	liveui_chans     map[string]*liveChan
 	liveui_usercount int
+
+	// rate limit for reporting posts
+	reportLimit *reportLimiter
+
+	// allow mods to log in with nntp credentials
+	modNNTPLogin bool
+	// the mod pubkey each nntp login that may log in acts as
+	modNNTPUsers map[string]string
+	// hex encoded node mod key, signs ctl for mods without a key in their session
+	modKey string
+
+	// captcha for posting
+	captcha       CaptchaService
+	captchaPolicy captchaPolicy
+	// what is left of the captchas sessions solved, nil to need one for every post
+	captchaSolved *solvedCaptchas
+	// checks proof of work for newsgroups that want it instead of a captcha
+	pow *powVerifier
+	// server secret for secure tripcodes, empty to disable them
+	tripcodeSecret string
+	// posts of shadow banned posters
+	shadow *shadowPosts
+	// how fast one address can post and make threads
+	cooldown *postCooldown
+	// dnsbls and tor exits, nil to not check
+	addrLists *addrLists
+	// per country posting policy, nil to not check
+	geoip *geoPolicy
+	// newsgroups never shown on the overboard
+	overboardExclude map[string]bool
+	// how long posters can delete their posts, 0 if they can't
+	opDeleteWindow time.Duration
+	// do posts from the form need the session's post token
+	requirePostToken bool
+	// keep what posters put in the form for the next post
+	rememberPoster bool
+	// replies per page of a paged thread
+	threadPageSize int
+	// mirror content without taking posts
+	readOnly bool
+	// etags for rendered pages
+	validator *pageValidator
+	// gzip level for responses, 0 for none
+	gzipLevel int
+	// per address limits on posting, searching and the api, nil for none
+	rateLimit *frontendRateLimiter
+	// how long post fields can be
+	postLimits postLimits
+	// downloads attachments posters give a url for, nil if we don't
+	urlFetch *urlFetcher
+	// resumable uploads, nil if we don't take them
+	uploads *uploadStore
+	// https with acme certificates, nil for none
+	acme *acmeServer
 }
 
 // do we allow this newsgroup?
@@ -227,22 +344,56 @@ func (self *httpFrontend) Regen(msg ArticleEntry) {
 	self.cache.Regen(msg)
 }
 
+func (self *httpFrontend) ArticleDeleted(msgid, root, group string) {
+	if self.liveui_delete != nil {
+		self.liveui_delete <- frontendPost{msgid, root, group}
+	}
+}
+
+func (self *httpFrontend) ReloadTemplates() {
+	template.reloadAllTemplates()
+	self.regenAll()
+}
+
+// reload templates when their files change and regen everything with them
+func (self *httpFrontend) watchTemplates() {
+	if self.templateReload <= 0 {
+		return
+	}
+	for {
+		time.Sleep(self.templateReload)
+		if template.reloadChangedTemplates() {
+			self.regenAll()
+		}
+	}
+}
+
 func (self httpFrontend) regenAll() {
+	self.validator.changed()
 	self.cache.RegenAll()
 }
 
 func (self *httpFrontend) regenerateBoard(group string) {
+	self.validator.changed()
 	self.cache.RegenerateBoard(group)
 }
 
 func (self httpFrontend) deleteThreadMarkup(root_post_id string) {
+	self.validator.changed()
 	self.cache.DeleteThreadMarkup(root_post_id)
 }
 
 func (self httpFrontend) deleteBoardMarkup(group string) {
+	self.validator.changed()
 	self.cache.DeleteBoardMarkup(group)
 }
 
+// regen after a mod event, pages can change without a new post so drop our etags too
+func (self httpFrontend) regenOnModEvent(newsgroup, msgid, root string, page int) {
+	self.validator.changed()
+	self.cache.RegenOnModEvent(newsgroup, msgid, root, page)
+}
+
 // load post model and inform live ui
 func (self *httpFrontend) informLiveUI(msgid, ref, group string) {
 	// root post
@@ -290,7 +441,14 @@ func (self *httpFrontend) poll_liveui() {
 					go func() {
 						var threads []ThreadModel
 						group := live.newsgroup
-						if group == "" {
+						if live.thread != "" {
+							// for thread
+							op := self.daemon.database.GetPostModel(self.prefix, live.thread)
+							if op != nil {
+								th := createThreadModel(op)
+								threads = append(threads, th)
+							}
+						} else if group == "" {
 							// for ukko
 							ents := self.daemon.database.GetLastBumpedThreads("", 5)
 							if ents != nil {
@@ -337,10 +495,19 @@ func (self *httpFrontend) poll_liveui() {
 					go livechan.Inform(model)
 				}
 			}
+		case post, ok := <-self.liveui_delete:
+			if ok {
+				for _, livechan := range self.liveui_chans {
+					go livechan.InformDelete(post)
+				}
+			}
 		case <-self.end_liveui:
 			livechnl := self.liveui_chnl
 			self.liveui_chnl = nil
 			close(livechnl)
+			delchnl := self.liveui_delete
+			self.liveui_delete = nil
+			close(delchnl)
 			chnl := self.liveui_register
 			self.liveui_register = nil
 			close(chnl)
@@ -385,7 +552,7 @@ func (self *httpFrontend) poll() {
 					if err == nil {
 						err = writeMIMEHeader(f, hdr)
 						if err == nil {
-							err = self.daemon.store.ProcessMessageBody(f, hdr, r)
+							err = self.daemon.store.ProcessMessageBody(f, hdr, r, "")
 						}
 					}
 				}
@@ -427,14 +594,67 @@ func (self *httpFrontend) poll() {
 	}
 }
 
+// tell a poster how much proof of work a post to a newsgroup needs
+func (self *httpFrontend) handle_pow_difficulty(wr http.ResponseWriter, r *http.Request) {
+	board := strings.ToLower(r.URL.Query().Get("newsgroup"))
+	resp := make(map[string]interface{})
+	if !newsgroupValidFormat(board) {
+		wr.WriteHeader(400)
+		resp["error"] = "bad newsgroup: " + board
+	} else if self.captchaPolicy.ProofOfWork(board) {
+		var encaddr string
+		ip, err := extractRealIP(r)
+		if err == nil && len(ip) > 0 {
+			encaddr, _ = self.daemon.database.GetEncAddress(ip)
+		}
+		resp["bits"] = self.pow.Required(self.daemon.database, board, encaddr)
+		resp["time"] = timeNow()
+	} else {
+		resp["bits"] = 0
+	}
+	wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
+	json.NewEncoder(wr).Encode(resp)
+}
+
+// show shadow banned posters the threads they posted in with their posts in them
+// everyone else gets the cached page
+func (self *httpFrontend) shadowHandler(cache http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		f := mux.Vars(r)["f"]
+		if strings.HasPrefix(f, "thread-") && !self.shadow.Empty() {
+			ip, err := extractRealIP(r)
+			if err == nil && len(ip) > 0 {
+				root, posts := self.shadow.Thread(ip, f[7:])
+				if len(posts) > 0 {
+					wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+					wr.Header().Set("Cache-Control", "no-store")
+					template.genShadowThread(self.attachments, root, posts[0].Board(), self.prefix, self.name, wr, self.daemon.database, posts)
+					return
+				}
+			}
+		}
+		cache.ServeHTTP(wr, r)
+	})
+}
+
 // create a new captcha, return as json object
 func (self *httpFrontend) new_captcha_json(wr http.ResponseWriter, r *http.Request) {
-	captcha_id := captcha.New()
+	captcha_id := self.captcha.NewChallenge()
 	resp := make(map[string]string)
 	// the captcha id
 	resp["id"] = captcha_id
 	// url of the image
-	resp["url"] = fmt.Sprintf("%scaptcha/%s.png", self.prefix, captcha_id)
+	resp["url"] = self.captcha.ChallengeURL(self.prefix, captcha_id)
+	// what the browser needs for external captchas
+	resp["provider"] = self.captcha.Provider()
+	resp["site_key"] = self.captcha.SiteKey()
+	resp["field"] = self.captcha.ResponseField()
+	sess, err := self.store.Get(r, self.name)
+	if err == nil {
+		// the form needs this too and javascript already asks us for the captcha
+		resp["post_token"] = postToken(sess)
+		sess.Save(r, wr)
+	}
 	wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
 	enc := json.NewEncoder(wr)
 	enc.Encode(&resp)
@@ -444,6 +664,7 @@ func (self *httpFrontend) new_captcha_json(wr http.ResponseWriter, r *http.Reque
 func (self *httpFrontend) handle_newboard(wr http.ResponseWriter, r *http.Request) {
 	param := make(map[string]interface{})
 	param["prefix"] = self.prefix
+	param["i18n"] = i18nForRequest(r, "")
 	io.WriteString(wr, template.renderTemplate("newboard.mustache", param))
 }
 
@@ -486,6 +707,9 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 
 	var captcha_retry bool
 	var captcha_solution, captcha_id string
+	var post_token, honeypot string
+	// attachment form parts with their spoiler box ticked, the box can come before the file
+	spoilers := make(map[string]bool)
 	var url string
 	url = fmt.Sprintf("%s-0.html", board)
 	var part_buff bytes.Buffer
@@ -505,6 +729,7 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 							Filename: att.Filename(),
 							Filetype: att.Mime(),
 							Filedata: att.Filedata(),
+							field:    strings.TrimPrefix(partname, "attachment_"),
 						}
 						pr.Attachments = append(pr.Attachments, pa)
 					}
@@ -518,6 +743,8 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 				pr.Subject = part_buff.String()
 			} else if partname == "name" {
 				pr.Name = part_buff.String()
+			} else if partname == "email" {
+				pr.Email = part_buff.String()
 			} else if partname == "message" {
 				pr.Message = part_buff.String()
 			} else if partname == "reference" {
@@ -529,10 +756,28 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 				}
 			} else if partname == "captcha_id" {
 				captcha_id = part_buff.String()
-			} else if partname == "captcha" {
+			} else if partname == self.captcha.ResponseField() {
 				captcha_solution = part_buff.String()
+			} else if partname == "pow" {
+				pr.ProofOfWork = part_buff.String()
 			} else if partname == "dubs" {
 				pr.Dubs = part_buff.String() == "on"
+			} else if partname == "signing_key" {
+				pr.signingKey = parseSigningKey(part_buff.String())
+			} else if partname == "password" {
+				pr.Password = part_buff.String()
+			} else if partname == "spoiler" {
+				pr.Spoiler = part_buff.String() == "on"
+			} else if strings.HasPrefix(partname, "spoiler_") {
+				spoilers[strings.TrimPrefix(partname, "spoiler_")] = part_buff.String() == "on"
+			} else if partname == "attachment_url" {
+				pr.AttachmentURL = strings.TrimSpace(part_buff.String())
+			} else if partname == "upload_id" {
+				pr.UploadIDs = append(pr.UploadIDs, strings.TrimSpace(part_buff.String()))
+			} else if partname == postTokenField {
+				post_token = part_buff.String()
+			} else if partname == postHoneypotField {
+				honeypot = part_buff.String()
 			}
 
 			// we done
@@ -554,17 +799,25 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 			break
 		}
 	}
+	for idx := range pr.Attachments {
+		pr.Attachments[idx].Spoiler = spoilers[pr.Attachments[idx].field]
+	}
+
+	pr.modExempt = self.modui.CheckSession(r, "mod-"+board)
 
 	sess, _ := self.store.Get(r, self.name)
-	if checkCaptcha && len(captcha_id) == 0 {
-		cid, ok := sess.Values["captcha_id"]
-		if ok {
-			captcha_id = cid.(string)
+	err = self.checkPostOrigin(r, sess, post_token, honeypot)
+	if err != nil {
+		log.Println("rejecting post from", pr.IpAddress, err)
+		wr.WriteHeader(403)
+		if sendJson {
+			json.NewEncoder(wr).Encode(map[string]interface{}{"error": err.Error()})
+		} else {
+			io.WriteString(wr, err.Error())
 		}
-		sess.Values["captcha_id"] = ""
+		return
 	}
-
-	if checkCaptcha && !captcha.VerifyString(captcha_id, captcha_solution) {
+	if checkCaptcha && !self.checkPostCaptcha(sess, pr, captcha_id, captcha_solution) {
 		// captcha is not valid
 		captcha_retry = true
 	} else {
@@ -581,6 +834,7 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 		sess.Values["posts"] = posts
 	}
 	sess.Save(r, wr)
+	self.savePosterPrefs(wr, r, pr)
 
 	// make error template param
 	resp_map := make(map[string]interface{})
@@ -606,6 +860,7 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 			// retry the post with a new captcha
 			resp_map = make(map[string]interface{})
 			resp_map["prefix"] = self.prefix
+			resp_map["i18n"] = i18nForRequest(r, board)
 			resp_map["redirect_url"] = self.prefix + url
 			resp_map["reason"] = "captcha incorrect"
 			io.WriteString(wr, template.renderTemplate("post_fail.mustache", resp_map))
@@ -616,10 +871,10 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 	b := func() {
 		if sendJson {
 			wr.WriteHeader(403)
-			json.NewEncoder(wr).Encode(map[string]interface{}{"error": "banned"})
+			json.NewEncoder(wr).Encode(map[string]interface{}{"error": "banned", "appeal_url": self.prefix + "appeal"})
 		} else {
 			wr.WriteHeader(403)
-			io.WriteString(wr, "banned")
+			io.WriteString(wr, "banned, you can appeal at "+self.prefix+"appeal")
 		}
 	}
 
@@ -631,6 +886,7 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 		} else {
 			resp_map["reason"] = err.Error()
 			resp_map["prefix"] = self.prefix
+			resp_map["i18n"] = i18nForRequest(r, board)
 			resp_map["redirect_url"] = self.prefix + url
 			io.WriteString(wr, template.renderTemplate("post_fail.mustache", resp_map))
 		}
@@ -642,34 +898,71 @@ func (self *httpFrontend) handle_postform(wr http.ResponseWriter, r *http.Reques
 		// determine the root post so we can redirect to the thread for it
 		msg_id := nntp.Headers().Get("References", nntp.MessageID())
 		// render response as success
-		url := fmt.Sprintf("%sthread-%s.html", self.prefix, HashMessageID(msg_id))
+		url := postAnchorURL(self.prefix, nntp.MessageID(), msg_id)
 		if sendJson {
-			json.NewEncoder(wr).Encode(map[string]interface{}{"message_id": nntp.MessageID(), "url": url, "error": nil})
+			json.NewEncoder(wr).Encode(map[string]interface{}{"message_id": nntp.MessageID(), "url": url, "delete_password": pr.Password, "error": nil})
 		} else {
-			io.WriteString(wr, template.renderTemplate("post_success.mustache", map[string]interface{}{"prefix": self.prefix, "message_id": nntp.MessageID(), "redirect_url": url}))
+			// the post is not in the thread until the daemon processed it, so we wait for it first
+			redirect := postedURL(self.prefix, nntp.MessageID(), msg_id, board, wantsNoko(pr.Email))
+			io.WriteString(wr, template.renderTemplate("post_success.mustache", map[string]interface{}{"prefix": self.prefix, "message_id": nntp.MessageID(), "redirect_url": redirect, "post_url": url, "delete_password": pr.Password, "i18n": i18nForRequest(r, board)}))
 		}
 	}
 	self.handle_postRequest(pr, b, e, s, self.enableBoardCreation)
 }
 
+// decide if a post needs a captcha and check the one it came with if so
+// returns false if the poster has to try again
+func (self *httpFrontend) checkPostCaptcha(sess *sessions.Session, pr *postRequest, captcha_id, captcha_solution string) bool {
+	pr.addrListing = self.addrLists.Lookup(pr.IpAddress)
+	need := self.captchaPolicy.Required(pr.Group, self.daemon.spam.Score(pr.Subject+" "+pr.Message))
+	if !need && !pr.modExempt && len(self.addrLists.Matching(pr.addrListing, AddrListCaptcha)) > 0 {
+		// listed posters always get one
+		need = true
+	}
+	pr.country = self.geoip.Country(pr.IpAddress)
+	if !need && !pr.modExempt && self.geoip.Captcha(pr.country) {
+		need = true
+	}
+	if !need || self.useSolvedCaptcha(sess) {
+		// none needed or solved one recently
+		return true
+	}
+	if len(captcha_id) == 0 {
+		cid, ok := sess.Values["captcha_id"]
+		if ok {
+			captcha_id = cid.(string)
+		}
+		sess.Values["captcha_id"] = ""
+	}
+	if !self.captcha.Verify(captcha_id, captcha_solution, pr.IpAddress) {
+		return false
+	}
+	self.rememberSolvedCaptcha(sess)
+	return true
+}
+
 // turn a post request into an nntp article write it to temp dir and tell daemon
 func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e errorFunc, s successFunc, createGroup bool) {
 	var err error
-	if len(pr.Attachments) > self.attachmentLimit {
-		err = errors.New("too many attachments")
-		e(err)
+	if self.readOnly {
+		e(ReadOnlyFrontend)
 		return
 	}
 	pr.Message = strings.Trim(pr.Message, "\r")
 	m := strings.Trim(pr.Message, "\n\t ")
-	if len(pr.Attachments) == 0 && len(m) == 0 {
+	if len(pr.Attachments) == 0 && len(pr.AttachmentURL) == 0 && len(pr.UploadIDs) == 0 && len(m) == 0 {
 		err = errors.New("no post message")
 		e(err)
 		return
 	}
+	err = self.postLimits.Check(pr)
+	if err != nil {
+		e(err)
+		return
+	}
 	nntp := new(nntpArticle)
 	defer nntp.Reset()
-	var banned bool
+	var banned, shadowbanned bool
 	nntp.headers = make(ArticleHeaders)
 	address := pr.IpAddress
 	// check for banned
@@ -685,6 +978,33 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 			return
 		}
 	}
+	if len(address) > 0 && self.addrLists != nil {
+		listing := pr.addrListing
+		if listing == nil {
+			listing = self.addrLists.Lookup(address)
+		}
+		rejected := self.addrLists.Matching(listing, AddrListReject)
+		if !pr.modExempt && len(rejected) > 0 {
+			log.Println("rejecting post from", address, "listed in", rejected)
+			e(AddrListed)
+			return
+		}
+		for _, list := range self.addrLists.Matching(listing, AddrListTag) {
+			if list == torExitList {
+				nntp.headers.Set("X-Tor-Poster", "1")
+			} else {
+				nntp.headers.Add("X-DNSBL", list)
+			}
+		}
+	}
+	country := pr.country
+	if len(address) > 0 && len(country) == 0 {
+		country = self.geoip.Country(address)
+	}
+	if !pr.modExempt && self.geoip.Blocked(country) {
+		e(GeoBlocked)
+		return
+	}
 	if len(address) == 0 {
 		address = "Tor"
 	}
@@ -696,13 +1016,31 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 			nntp.headers.Set("X-Tor-Poster", "1")
 		} else {
 			address, err = self.daemon.database.GetEncAddress(address)
+			if err == nil {
+				// timed bans from flood detection are on the encrypted address
+				banned, err = self.daemon.database.CheckEncIPBanned(address)
+				if banned {
+					b()
+					return
+				}
+			}
+			if err == nil {
+				// shadow banned posters think their post went through
+				shadowbanned, err = self.daemon.database.CheckEncAddrShadowBanned(address)
+			}
+			if err == nil && len(country) > 0 && self.geoip.Record() {
+				var key string
+				key, err = self.daemon.database.GetEncKey(address)
+				if err == nil {
+					nntp.headers.Set("X-Encrypted-Country", encCountry(country, key))
+				}
+			}
 			if err == nil {
 				nntp.headers.Set("X-Encrypted-IP", address)
 			} else {
 				e(err)
 				return
 			}
-			// TODO: add x-tor-poster header for tor exits
 		}
 	}
 
@@ -724,6 +1062,62 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		return
 	}
 
+	if !pr.modExempt && len(nntp.headers.Get("X-Encrypted-IP", "")) > 0 {
+		// tor posters all look the same so only posters we have an address for get a cooldown
+		err = self.cooldown.Allow(board, nntp.headers.Get("X-Encrypted-IP", ""), len(pr.Reference) == 0, time.Now())
+		if err != nil {
+			e(err)
+			return
+		}
+	}
+
+	if self.captchaPolicy.ProofOfWork(board) {
+		encaddr := nntp.headers.Get("X-Encrypted-IP", "")
+		bits := self.pow.Required(self.daemon.database, board, encaddr)
+		err = self.pow.Verify(pr.ProofOfWork, board, pr.Message, bits, time.Now())
+		if err != nil {
+			e(err)
+			return
+		}
+		nntp.headers.Set("X-Proof-Of-Work", pr.ProofOfWork)
+	}
+
+	// files from urls and uploads only once we know the poster may post, fetching costs us
+	if len(pr.AttachmentURL) > 0 && self.attachments {
+		var att postAttachment
+		att, err = self.urlFetch.Fetch(pr.AttachmentURL)
+		if err != nil {
+			e(err)
+			return
+		}
+		pr.Attachments = append(pr.Attachments, att)
+	}
+	for _, id := range pr.UploadIDs {
+		if len(pr.Attachments) >= self.attachmentLimit || !self.attachments {
+			break
+		}
+		var att postAttachment
+		att, err = self.uploads.Take(id)
+		if err != nil {
+			e(err)
+			return
+		}
+		pr.Attachments = append(pr.Attachments, att)
+	}
+	if len(pr.Attachments) > self.attachmentLimit {
+		err = errors.New("too many attachments")
+		e(err)
+		return
+	}
+	if len(pr.Attachments) > 0 {
+		settings, _ := self.daemon.database.GetBoardSettings(pr.Group)
+		if !self.daemon.groupConfig(pr.Group).Attachments(settings.Attachments) {
+			err = errors.New("this board does not allow attachments")
+			e(err)
+			return
+		}
+	}
+
 	// if we don't have an address for the poster try checking for i2p httpd headers
 	if len(pr.Destination) == i2pDestHashLen() {
 		nntp.headers.Set("X-I2P-DestHash", pr.Destination)
@@ -733,6 +1127,10 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	if len(ref) > 0 {
 		if ValidMessageID(ref) {
 			if self.daemon.database.HasArticleLocal(ref) {
+				if !pr.modExempt && self.daemon.database.CheckThreadFlag(ref, ThreadLocked) {
+					e(errors.New("thread is locked"))
+					return
+				}
 				nntp.headers.Set("References", ref)
 			} else {
 				e(errors.New("article referenced not locally available"))
@@ -752,12 +1150,6 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		e(errors.New("no message"))
 		return
 	}
-	// TODO: make configurable
-	if len(pr.Message) > 1024*1024 {
-		e(errors.New("your message is too big"))
-		return
-	}
-
 	if len(pr.Frontend) == 0 {
 		// :-DDD
 		pr.Frontend = "mongo.db.is.web.scale"
@@ -771,10 +1163,6 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	// set subject
 	if len(subject) == 0 {
 		subject = "None"
-	} else if len(subject) > 256 {
-		// subject too big
-		e(errors.New("Subject is too long"))
-		return
 	}
 
 	nntp.headers.Set("Subject", subject)
@@ -790,15 +1178,37 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	if len(name) == 0 {
 		name = "Anonymous"
 	} else {
+		var secret string
 		idx := strings.Index(name, "#")
-		// tripcode
 		if idx >= 0 {
-			tripcode_privkey = parseTripcodeSecret(name[idx+1:])
+			secret = name[idx+1:]
 			name = strings.Trim(name[:idx], "\t ")
 			if name == "" {
 				name = "Anonymous"
 			}
 		}
+		// nobody gets to type a tripcode into their name
+		name = strings.Replace(name, "!", "\u01c3", -1)
+		// tripcode
+		if idx >= 0 {
+			if strings.HasPrefix(secret, "#") {
+				// name##password is a secure tripcode
+				if len(self.tripcodeSecret) == 0 {
+					e(errors.New("secure tripcodes are disabled"))
+					return
+				}
+				name += " " + secureTripcode(secret[1:], self.tripcodeSecret)
+			} else if strings.HasPrefix(secret, "!") {
+				// name#!password is a classic tripcode
+				name += " " + classicTripcode(secret[1:])
+			} else {
+				// name#secret signs the post with an ed25519 key
+				tripcode_privkey = parseTripcodeSecret(secret)
+			}
+		}
+	}
+	if len(pr.signingKey) == nacl.CryptoSignSeedLen() {
+		tripcode_privkey = pr.signingKey
 	}
 	if len(name) > 128 {
 		// name too long
@@ -813,6 +1223,14 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 
 	nntp.headers.Set("From", nntpSanitize(fmt.Sprintf("%s <poster@%s>", name, pr.Frontend)))
 	nntp.headers.Set("Message-ID", msgid)
+	if self.opDeleteWindow > 0 {
+		if len(pr.Password) == 0 {
+			pr.Password = randStr(16)
+		}
+		nntp.headers.Set(deleteHashHeader, opDeleteHash(self.secret, msgid, pr.Password))
+	} else {
+		pr.Password = ""
+	}
 
 	// set message
 	nntp.message = createPlaintextAttachment([]byte(pr.Message))
@@ -836,6 +1254,9 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 			// add attachment
 			if len(att.Filedata) > 0 {
 				a := createAttachment(att.Filetype, att.Filename, strings.NewReader(att.Filedata))
+				if att.Spoiler || pr.Spoiler {
+					spoilAttachment(a)
+				}
 				nntp.Attach(a)
 				err = a.Save(self.daemon.store.AttachmentDir())
 				if err == nil {
@@ -864,10 +1285,32 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	}
 	// pack it before sending so that the article is well formed
 	nntp.Pack()
+	if shadowbanned {
+		// only the poster gets to see it, don't store or federate it
+		root := nntp.Reference()
+		if root == "" {
+			root = nntp.MessageID()
+		}
+		self.shadow.Add(pr.IpAddress, root, PostModelFromMessage(root, self.prefix, nntp))
+		log.Println("shadow banned post", nntp.MessageID(), "from", address)
+		s(nntp)
+		return
+	}
+	// set if the spam filter held it back
+	var quarantined bool
 	// sign if needed
 	if len(tripcode_privkey) == nacl.CryptoSignSeedLen() {
-		err = self.daemon.store.RegisterPost(nntp)
-		if err != nil {
+		// it is not signed yet, but it counts against the quota of the key it will be signed with
+		kp := nacl.LoadSignKey(tripcode_privkey)
+		if kp == nil {
+			e(errors.New("failed to load signing key"))
+			return
+		}
+		pubkey := hexify(kp.Public())
+		kp.Free()
+		err = self.daemon.store.RegisterPostAs(nntp, pubkey)
+		quarantined = err == ArticleQuarantined
+		if err != nil && !quarantined {
 			e(err)
 			return
 		}
@@ -882,6 +1325,10 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 		}
 	} else {
 		err = self.daemon.store.RegisterPost(nntp)
+		if err == ArticleQuarantined {
+			quarantined = true
+			err = nil
+		}
 	}
 	if err != nil {
 		e(err)
@@ -898,7 +1345,11 @@ func (self *httpFrontend) handle_postRequest(pr *postRequest, b bannedFunc, e er
 	} else {
 		err = nntp.WriteTo(f)
 		f.Close()
-		if err == nil {
+		if err == nil && quarantined {
+			// stays on disk until a mod lets it through
+			e(ArticleQuarantined)
+			return
+		} else if err == nil {
 			self.daemon.loadFromInfeed(nntp.MessageID())
 			s(nntp)
 			return
@@ -930,13 +1381,42 @@ func (self httpFrontend) handle_poster(wr http.ResponseWriter, r *http.Request)
 	}
 }
 
+// remember that this session solved a captcha so the next posts can skip it
+// the session only gets an id, what is left of it is kept here
+func (self *httpFrontend) rememberSolvedCaptcha(s *sessions.Session) {
+	if self.captchaSolved != nil {
+		id := randStr(32)
+		s.Values["captcha_session"] = id
+		self.captchaSolved.Remember(id, time.Now())
+	}
+}
+
+// use up a post from a captcha this session solved before
+// return true if there was one left
+func (self *httpFrontend) useSolvedCaptcha(s *sessions.Session) bool {
+	id, _ := s.Values["captcha_session"].(string)
+	if len(id) == 0 {
+		return false
+	}
+	if self.captchaSolved.Use(id, time.Now()) {
+		return true
+	}
+	delete(s.Values, "captcha_session")
+	return false
+}
+
 func (self *httpFrontend) new_captcha(wr http.ResponseWriter, r *http.Request) {
 	s, err := self.store.Get(r, self.name)
 	if err == nil {
-		captcha_id := captcha.New()
+		captcha_id := self.captcha.NewChallenge()
 		s.Values["captcha_id"] = captcha_id
 		s.Save(r, wr)
-		redirect_url := fmt.Sprintf("%scaptcha/%s.png", self.prefix, captcha_id)
+		redirect_url := self.captcha.ChallengeURL(self.prefix, captcha_id)
+		if len(redirect_url) == 0 {
+			// the provider has no image for us
+			http.NotFound(wr, r)
+			return
+		}
 
 		// redirect to the image
 		http.Redirect(wr, r, redirect_url, 302)
@@ -1126,7 +1606,7 @@ func (self *httpFrontend) handle_liveapi(w http.ResponseWriter, r *http.Request)
 						if err == nil {
 							// decode success
 							res["success"] = false
-							if captcha.VerifyString(c.ID, c.Solution) {
+							if self.captcha.Verify(c.ID, c.Solution, ip) {
 								// successful captcha
 								res["success"] = true
 								s.Values["captcha"] = true
@@ -1239,6 +1719,58 @@ func (self *httpFrontend) handle_api(wr http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parse comma separated newsgroups to leave off the overboard, overchan. is optional
+func parseOverboardExclude(str string) map[string]bool {
+	exclude := make(map[string]bool)
+	for _, group := range splitList(str) {
+		if !strings.HasPrefix(group, "overchan.") {
+			group = "overchan." + group
+		}
+		if newsgroupValidFormat(group) {
+			exclude[group] = true
+		}
+	}
+	return exclude
+}
+
+// the overboard with every newsgroup but the ones the user excluded
+// ?exclude=a,b remembers the excluded newsgroups in a cookie, ?exclude= clears them
+// ?page=n for more pages
+func (self *httpFrontend) handle_overboard(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	if page > 10 {
+		wr.WriteHeader(404)
+		return
+	}
+	excluded := ""
+	if _, ok := q["exclude"]; ok {
+		excluded = q.Get("exclude")
+		http.SetCookie(wr, &http.Cookie{
+			Name:   "overboard_exclude",
+			Value:  url.QueryEscape(excluded),
+			Path:   self.prefix,
+			MaxAge: 365 * 24 * 3600,
+		})
+	} else if c, err := r.Cookie("overboard_exclude"); err == nil {
+		excluded, _ = url.QueryUnescape(c.Value)
+	}
+	exclude := parseOverboardExclude(excluded)
+	for group := range self.overboardExclude {
+		exclude[group] = true
+	}
+	isjson := strings.HasSuffix(r.URL.Path, ".json")
+	if isjson {
+		wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
+	} else {
+		wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	template.genOverboard(self.prefix, self.name, wr, self.daemon.database, page, exclude, isjson)
+}
+
 // upgrade to web sockets and subscribe to all new posts
 func (self *httpFrontend) handle_liveui(w http.ResponseWriter, r *http.Request) {
 
@@ -1261,6 +1793,34 @@ func (self *httpFrontend) handle_liveui(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// obtain a new channel for reading post models
+	// ?board=overchan.x or ?thread=msgid, /live?x is the same as ?board=overchan.x
+	board, thread := "", ""
+	q := r.URL.Query()
+	if q.Get("thread") != "" {
+		thread = q.Get("thread")
+		if !ValidMessageID(thread) {
+			e, err := self.daemon.database.GetMessageIDByHash(thread)
+			if err == nil {
+				thread = e.MessageID()
+			}
+		}
+		var root string
+		root, board, _, err = self.daemon.database.GetInfoForMessage(thread)
+		if err == nil && root != "" {
+			thread = root
+		}
+	} else if q.Get("board") != "" {
+		board = q.Get("board")
+	} else if r.URL.RawQuery != "" {
+		board = "overchan." + r.URL.RawQuery
+	}
+	if err != nil || board == "ctl" {
+		w.WriteHeader(404)
+		io.WriteString(w, "no such thread")
+		return
+	}
+
 	conn, err := self.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		// problem
@@ -1268,12 +1828,7 @@ func (self *httpFrontend) handle_liveui(w http.ResponseWriter, r *http.Request)
 		io.WriteString(w, err.Error())
 		return
 	}
-	// obtain a new channel for reading post models
-	board := ""
-	if r.URL.RawQuery != "" {
-		board = "overchan." + r.URL.RawQuery
-	}
-	livechnl := self.subscribe(board, IpAddress)
+	livechnl := self.subscribe(board, thread, IpAddress)
 	if livechnl == nil {
 		// shutting down
 		conn.Close()
@@ -1318,14 +1873,15 @@ func (self *httpFrontend) handle_liveui(w http.ResponseWriter, r *http.Request)
 	conn.Close()
 }
 
-// get a chan that is subscribed to all new posts in a newsgroup
-func (self *httpFrontend) subscribe(board, ip string) chan *liveChan {
+// get a chan that is subscribed to all new posts in a newsgroup or thread
+func (self *httpFrontend) subscribe(board, thread, ip string) chan *liveChan {
 	if self.liveui_register == nil {
 		return nil
 	} else {
 		live := new(liveChan)
 		live.IP = ip
 		live.newsgroup = board
+		live.thread = thread
 		live.resultchnl = make(chan *liveChan)
 		live.datachnl = make(chan []byte, 8)
 		self.liveui_register <- live
@@ -1339,6 +1895,8 @@ func (self *httpFrontend) Mainloop() {
 		log.Fatalf("no such template folder %s", self.template_dir)
 	}
 	template.changeTemplateDir(self.template_dir)
+	template.changeBoardTemplateDir(self.board_template_dir)
+	template.database = self.daemon.database
 
 	// set up handler mux
 	self.httpmux = mux.NewRouter()
@@ -1361,11 +1919,38 @@ func (self *httpFrontend) Mainloop() {
 	// modui handlers
 	m.Path("/mod/").HandlerFunc(self.modui.ServeModPage).Methods("GET")
 	m.Path("/mod/feeds").HandlerFunc(self.modui.ServeModPage).Methods("GET")
+	m.Path("/mod/posts").HandlerFunc(self.modui.ServeModPosts).Methods("GET")
+	m.Path("/mod/reports").HandlerFunc(self.modui.ServeModReports).Methods("GET")
+	m.Path("/mod/bans").HandlerFunc(self.modui.ServeModBans).Methods("GET")
+	m.Path("/mod/appeals").HandlerFunc(self.modui.ServeModAppeals).Methods("GET")
+	m.Path("/mod/appeal/lift/{encaddr}").HandlerFunc(self.modui.HandleLiftAppeal).Methods("GET")
+	m.Path("/mod/appeal/uphold/{encaddr}").HandlerFunc(self.modui.HandleUpholdAppeal).Methods("GET")
+	m.Path("/mod/quarantine").HandlerFunc(self.modui.ServeModQuarantine).Methods("GET")
+	m.Path("/mod/quarantine/approve/{hash}").HandlerFunc(self.modui.HandleApproveQuarantined).Methods("GET")
+	m.Path("/mod/quarantine/reject/{hash}").HandlerFunc(self.modui.HandleRejectQuarantined).Methods("GET")
+	m.Path("/mod/quarantine/bulk/{action:approve|reject}").HandlerFunc(self.modui.HandleBulkQuarantined).Methods("GET")
+	m.Path("/mod/rejected").HandlerFunc(self.modui.ServeModRejected).Methods("GET")
+	m.Path("/mod/rejected/view/{hash}").HandlerFunc(self.modui.HandleViewRejected).Methods("GET")
+	m.Path("/mod/rejected/bulk/{action:release|purge}").HandlerFunc(self.modui.HandleBulkRejected).Methods("GET")
+	m.Path("/mod/rejected/{action:release|purge}/{hash}").HandlerFunc(self.modui.HandleRejected).Methods("GET")
+	m.Path("/mod/board/{newsgroup}").HandlerFunc(self.modui.ServeModBoard).Methods("GET")
+	m.Path("/mod/board/{newsgroup}").HandlerFunc(self.modui.HandleBoardSettings).Methods("POST")
+	m.Path("/mod/banners/{newsgroup}").HandlerFunc(self.modui.ServeModBanners).Methods("GET")
+	m.Path("/mod/banners/{newsgroup}").HandlerFunc(self.modui.HandleBannerUpload).Methods("POST")
+	m.Path("/mod/banners/{newsgroup}/del/{name}").HandlerFunc(self.modui.HandleBannerDelete).Methods("GET")
+	m.Path("/mod/stats").HandlerFunc(self.modui.ServeModStats).Methods("GET")
 	m.Path("/mod/keygen").HandlerFunc(self.modui.HandleKeyGen).Methods("GET")
+	m.Path("/mod/challenge").HandlerFunc(self.modui.HandleChallenge).Methods("GET")
 	m.Path("/mod/login").HandlerFunc(self.modui.HandleLogin).Methods("POST")
+	m.Path("/mod/logout").HandlerFunc(self.modui.HandleLogout).Methods("POST")
 	m.Path("/mod/del/{article_hash}").HandlerFunc(self.modui.HandleDeletePost).Methods("GET")
+	m.Path("/mod/{flag:sticky|lock|cycle}/{article_hash}").HandlerFunc(self.modui.HandleThreadFlag).Methods("GET")
+	m.Path("/mod/purge/{kind:pubkey|encaddr}/{value}").HandlerFunc(self.modui.HandlePurgePoster).Methods("GET")
+	m.Path("/mod/{action:grant|revoke}/{pubkey}/{newsgroup}/{permission}").HandlerFunc(self.modui.HandleModGrant).Methods("GET")
+	m.Path("/mod/dismiss/{article_hash}").HandlerFunc(self.modui.HandleDismissReports).Methods("GET")
 	m.Path("/mod/ban/{address}").HandlerFunc(self.modui.HandleBanAddress).Methods("GET")
 	m.Path("/mod/unban/{address}").HandlerFunc(self.modui.HandleUnbanAddress).Methods("GET")
+	m.Path("/mod/shadowban/{address}").HandlerFunc(self.modui.HandleShadowBanAddress).Methods("GET")
 	m.Path("/mod/addkey/{pubkey}").HandlerFunc(self.modui.HandleAddPubkey).Methods("GET")
 	m.Path("/mod/delkey/{pubkey}").HandlerFunc(self.modui.HandleDelPubkey).Methods("GET")
 	m.Path("/mod/admin/{action}").HandlerFunc(self.modui.HandleAdminCommand).Methods("GET", "POST")
@@ -1376,22 +1961,61 @@ func (self *httpFrontend) Mainloop() {
 		io.WriteString(w, "User-Agent: *\nDisallow: /\n")
 	})).Methods("GET")
 
-	m.Path("/thm/{f}").Handler(http.FileServer(http.Dir(self.webroot_dir)))
-	m.Path("/img/{f}").Handler(http.FileServer(http.Dir(self.webroot_dir)))
-	m.Path("/{f}.html").Handler(cache_handler).Methods("GET", "HEAD")
-	m.Path("/{f}.json").Handler(cache_handler).Methods("GET", "HEAD")
-	m.PathPrefix("/static/").Handler(http.FileServer(http.Dir(self.static_dir)))
-	m.Path("/post/{f}").HandlerFunc(self.handle_poster).Methods("POST")
+	m.Path("/thm/{f}").Handler(contentAddressed(http.FileServer(http.Dir(self.webroot_dir))))
+	m.Path("/img/{f}").Handler(contentAddressed(http.FileServer(http.Dir(self.webroot_dir))))
+	m.Path("/thread/{id}/page/{page:[0-9]+}").HandlerFunc(self.handle_thread_page).Methods("GET")
+	m.Path("/thread/{id}/last{n:[0-9]+}").HandlerFunc(self.handle_thread_last).Methods("GET")
+	// under /vichan/ so the boards.json we always had keeps working
+	m.Path("/vichan/boards.json").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_vichan_boards)).Methods("GET")
+	m.Path("/vichan/{board}/catalog.json").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_vichan_catalog)).Methods("GET")
+	m.Path("/{f}.html").Handler(self.shadowHandler(self.validatePages(cache_handler))).Methods("GET", "HEAD")
+	m.Path("/{f}.json").Handler(self.validatePages(cache_handler)).Methods("GET", "HEAD")
+	m.PathPrefix("/static/").Handler(cacheControlled(staticCacheControl, precompressedFiles(self.static_dir, http.FileServer(http.Dir(self.static_dir)))))
+	if self.archive_dir != "" {
+		m.PathPrefix("/archive/").Handler(http.StripPrefix("/archive/", http.FileServer(http.Dir(self.archive_dir))))
+	}
+	m.Path("/banner/{newsgroup}").HandlerFunc(self.handle_banner).Methods("GET")
+	m.PathPrefix("/banners/").Handler(cacheControlled(staticCacheControl, http.FileServer(http.Dir(self.webroot_dir))))
+	m.Path("/theme.css").HandlerFunc(self.handle_theme_css).Methods("GET", "HEAD")
+	m.Path("/theme").HandlerFunc(self.handle_theme).Methods("GET")
+	m.Path("/watch").HandlerFunc(self.handle_watch).Methods("GET")
+	m.Path("/directory").HandlerFunc(self.handle_directory).Methods("GET")
+	m.Path("/post/{f}").HandlerFunc(self.writable(self.rateLimited(rateLimitPost, self.handle_poster))).Methods("POST")
+	m.Path("/posted/{hash}").HandlerFunc(self.handle_posted).Methods("GET")
+	m.Path("/delete").HandlerFunc(self.writable(self.rateLimited(rateLimitPost, self.handle_opdelete))).Methods("POST")
+	if self.uploads != nil && !self.readOnly {
+		m.Path("/upload").HandlerFunc(self.handle_upload_options).Methods("OPTIONS")
+		m.Path("/upload").HandlerFunc(self.rateLimited(rateLimitPost, self.handle_upload_create)).Methods("POST")
+		m.Path("/upload/{id:[0-9a-f]+}").HandlerFunc(self.handle_upload_head).Methods("HEAD")
+		m.Path("/upload/{id:[0-9a-f]+}").HandlerFunc(self.rateLimited(rateLimitUpload, self.handle_upload_patch)).Methods("PATCH")
+		m.Path("/upload/{id:[0-9a-f]+}").HandlerFunc(self.handle_upload_delete).Methods("DELETE")
+	}
+	m.Path("/post_token").HandlerFunc(self.handle_post_token).Methods("GET")
+	m.Path("/postform/{board}").HandlerFunc(self.writable(self.handle_postform_page)).Methods("GET")
 	m.Path("/captcha/new").HandlerFunc(self.new_captcha_json).Methods("GET")
+	m.Path("/pow/difficulty").HandlerFunc(self.handle_pow_difficulty).Methods("GET")
 	m.Path("/captcha/img").HandlerFunc(self.new_captcha).Methods("GET")
 	m.Path("/captcha/{f}").Handler(captcha.Server(350, 175)).Methods("GET")
-	m.Path("/new/").HandlerFunc(self.handle_newboard).Methods("GET")
-	m.Path("/api/{meth}").HandlerFunc(self.handle_api).Methods("POST", "GET")
+	m.Path("/new/").HandlerFunc(self.writable(self.handle_newboard)).Methods("GET")
+	m.Path("/report/{hash}").HandlerFunc(self.handle_report).Methods("GET", "POST")
+	m.Path("/appeal").HandlerFunc(self.handle_appeal).Methods("GET", "POST")
+	// versioned json api
+	m.Path("/api/v1/boards").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_boards)).Methods("GET")
+	m.Path("/api/v1/board/{group}/page/{page:[0-9]+}").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_board)).Methods("GET")
+	m.Path("/api/v1/catalog/{group}").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_catalog)).Methods("GET")
+	m.Path("/api/v1/thread/{msgid}").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_thread)).Methods("GET")
+	m.Path("/api/v1/preview/{hash:[0-9a-f]+}").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_preview)).Methods("GET")
+	m.Path("/api/v1/watch").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api_v1_watch)).Methods("GET")
+	m.Path("/api/v1/post").HandlerFunc(self.writable(self.rateLimited(rateLimitPost, self.handle_api_v1_post))).Methods("POST")
+	m.Path("/api/{meth}").HandlerFunc(self.rateLimited(rateLimitAPI, self.handle_api)).Methods("POST", "GET")
+	m.Path("/search").HandlerFunc(self.rateLimited(rateLimitSearch, self.handle_search)).Methods("GET")
+	m.Path("/overboard").HandlerFunc(self.handle_overboard).Methods("GET")
+	m.Path("/overboard.json").HandlerFunc(self.handle_overboard).Methods("GET")
 	// live ui websocket
 	m.Path("/live").HandlerFunc(self.handle_liveui).Methods("GET")
 	// live ui page
 	m.Path("/livechan/").HandlerFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		template.writeTemplate("live.mustache", map[string]interface{}{"prefix": self.prefix}, w)
+		template.writeTemplate("live.mustache", map[string]interface{}{"prefix": self.prefix, "i18n": i18nForRequest(r, "")}, w)
 	})).Methods("GET", "HEAD")
 	// live ui api endpoint
 	m.Path("/livechan/api/{meth}").HandlerFunc(self.handle_liveapi).Methods("GET", "POST")
@@ -1401,7 +2025,7 @@ func (self *httpFrontend) Mainloop() {
 	var err error
 
 	// run daemon's mod engine with our frontend
-	go RunModEngine(self.daemon.mod, self.cache.RegenOnModEvent)
+	go RunModEngine(self.daemon.mod, self.regenOnModEvent)
 
 	// start cache
 	self.cache.Start()
@@ -1414,12 +2038,23 @@ func (self *httpFrontend) Mainloop() {
 
 	// poll liveui
 	go self.poll_liveui()
+	go self.addrLists.Run()
+	go self.uploads.Run()
+	go self.watchTemplates()
 
 	// start webserver here
 	log.Printf("frontend %s binding to %s", self.name, self.bindaddr)
 
+	handler := compressResponses(self.gzipLevel, prefixHandler(self.prefix, self.httpmux))
+	if self.acme != nil {
+		go self.acme.Serve(handler)
+	}
+
 	// serve it!
-	err = http.ListenAndServe(self.bindaddr, self.httpmux)
+	listener, err := listenBind(self.bindaddr)
+	if err == nil {
+		err = http.Serve(listener, localRemoteAddr(handler))
+	}
 	if err != nil {
 		log.Fatalf("failed to bind frontend %s %s", self.name, err)
 	}
@@ -1437,6 +2072,7 @@ func (self *httpFrontend) endLiveUI() {
 // create a new http based frontend
 func NewHTTPFrontend(daemon *NNTPDaemon, cache CacheInterface, config map[string]string, url string) Frontend {
 	template.Minimize = config["minimize_html"] == "1"
+	template.ReadOnly = config["read_only"] == "1"
 	front := new(httpFrontend)
 	front.daemon = daemon
 	front.cache = cache
@@ -1446,6 +2082,15 @@ func NewHTTPFrontend(daemon *NNTPDaemon, cache CacheInterface, config map[string
 	front.webroot_dir = config["webroot"]
 	front.static_dir = config["static_files"]
 	front.template_dir = config["templates"]
+	front.board_template_dir = config["board_templates"]
+	front.defaultTheme = config["theme"]
+	if config["static_archive"] == "1" {
+		front.archive_dir = config["static_archive_dir"]
+		if front.archive_dir == "" {
+			front.archive_dir = filepath.Join(front.webroot_dir, "archive")
+		}
+	}
+	front.templateReload = time.Second * time.Duration(mapGetInt(config, "template_reload", 0))
 	front.prefix = config["prefix"]
 	front.regen_on_start = config["regen_on_start"] == "1"
 	front.enableBoardCreation = config["board_creation"] == "1"
@@ -1461,7 +2106,47 @@ func NewHTTPFrontend(daemon *NNTPDaemon, cache CacheInterface, config map[string
 		},
 	}
 	front.attachmentLimit = 5
+	front.reportLimit = newReportLimiter(time.Second * time.Duration(mapGetInt(config, "report_interval", 60)))
+	front.modNNTPLogin = config["mod_nntp_login"] == "1"
+	// checked when the config was loaded
+	front.modNNTPUsers, _ = parseModNNTPUsers(config["mod_nntp_users"])
+	front.modKey = config["mod_privkey"]
+	front.captcha = captchaServiceFromConfig(daemon.conf.captcha)
+	front.captchaPolicy = captchaPolicy{
+		fallback: daemon.conf.captcha["policy"],
+		groups:   daemon.conf.captcha_groups,
+		database: daemon.database,
+	}
+	front.captchaSolved = newSolvedCaptchas(time.Second*time.Duration(mapGetInt(daemon.conf.captcha, "solved_time", 0)), mapGetInt(daemon.conf.captcha, "solved_posts", 0))
+	front.pow = powVerifierFromConfig(daemon.conf.pow)
 	front.secret = config["api-secret"]
+	front.rateLimit = frontendRateLimiterFromConfig(daemon.conf, daemon.database, front.secret)
+	front.opDeleteWindow = time.Second * time.Duration(mapGetInt(config, "op_delete", 1800))
+	front.requirePostToken = mapGetInt(config, "require_post_token", 1) == 1
+	front.rememberPoster = mapGetInt(config, "remember_poster", 1) == 1
+	front.gzipLevel = mapGetInt(config, "gzip_level", 5)
+	front.postLimits = postLimitsFromConfig(config)
+	front.threadPageSize = mapGetInt(config, "thread_page_size", 100)
+	front.readOnly = template.ReadOnly
+	if front.threadPageSize < 1 {
+		front.threadPageSize = 100
+	}
+	front.urlFetch = urlFetcherFromConfig(config, daemon)
+	front.uploads = uploadStoreFromConfig(config, daemon.store.TempDir())
+	front.acme = acmeServerFromConfig(config)
+	front.tripcodeSecret = config["tripcode_secret"]
+	front.shadow = newShadowPosts()
+	front.validator = newPageValidator()
+	if linkPreviews != nil {
+		linkPreviews.regen = func(thread ArticleEntry) {
+			front.validator.changed()
+			front.cache.Regen(thread)
+		}
+	}
+	front.cooldown = postCooldownFromConfig(daemon.conf)
+	front.addrLists = addrListsFromConfig(daemon.conf.dnsbl, daemon.database)
+	front.geoip = geoPolicyFromConfig(daemon.conf.geoip)
+	front.overboardExclude = parseOverboardExclude(config["overboard_exclude"])
 	front.store = sessions.NewCookieStore([]byte(front.secret))
 	front.store.Options = &sessions.Options{
 		// TODO: detect http:// etc in prefix
@@ -1474,6 +2159,7 @@ func NewHTTPFrontend(daemon *NNTPDaemon, cache CacheInterface, config map[string
 
 	// liveui related members
 	front.liveui_chnl = make(chan PostModel, 128)
+	front.liveui_delete = make(chan frontendPost, 128)
 	front.liveui_register = make(chan *liveChan)
 	front.liveui_deregister = make(chan *liveChan)
 	front.liveui_chans = make(map[string]*liveChan)
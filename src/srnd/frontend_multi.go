@@ -21,6 +21,20 @@ func (self multiFrontend) Regen(msg ArticleEntry) {
 	}
 }
 
+func (self multiFrontend) ArticleDeleted(msgid, root, group string) {
+	for _, front := range self.frontends {
+		if front.AllowNewsgroup(group) {
+			front.ArticleDeleted(msgid, root, group)
+		}
+	}
+}
+
+func (self multiFrontend) ReloadTemplates() {
+	for _, front := range self.frontends {
+		front.ReloadTemplates()
+	}
+}
+
 func (self multiFrontend) Mainloop() {
 	for idx := range self.frontends {
 		go self.frontends[idx].Mainloop()
@@ -0,0 +1,126 @@
+//
+// geoip.go -- per country posting policy from a maxmind geoip database
+//
+
+package srnd
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"github.com/oschwald/maxminddb-golang"
+	"log"
+	"net"
+	"strings"
+)
+
+var GeoBlocked = errors.New("posting from your country is not allowed")
+
+type geoPolicy struct {
+	db *maxminddb.Reader
+	// country code -> true if posters from there need a captcha
+	captcha map[string]bool
+	// country code -> true if posters from there can't post
+	block map[string]bool
+	// put the encrypted country into posts for mods
+	record bool
+}
+
+// the upper case iso code of the country addr is in, empty if we don't know
+func (self *geoPolicy) Country(addr string) string {
+	if self == nil {
+		return ""
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	err := self.db.Lookup(ip, &rec)
+	if err != nil {
+		log.Println("geoip lookup failed", err)
+		return ""
+	}
+	return strings.ToUpper(rec.Country.ISOCode)
+}
+
+// return true if posters from country need a captcha
+func (self *geoPolicy) Captcha(country string) bool {
+	return self != nil && self.captcha[country]
+}
+
+// return true if posters from country can't post
+func (self *geoPolicy) Blocked(country string) bool {
+	return self != nil && self.block[country]
+}
+
+// return true if we put the country of posters into their posts
+func (self *geoPolicy) Record() bool {
+	return self != nil && self.record
+}
+
+// parse comma separated country codes
+func parseCountryCodes(str string) map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range splitList(str) {
+		codes[strings.ToUpper(code)] = true
+	}
+	return codes
+}
+
+// pad for the country of a poster, derived from the key of their encrypted address
+// so whoever can decrypt the address can decrypt the country
+func countryPad(key string) []byte {
+	pad := sha512.Sum512([]byte("country " + key))
+	return pad[:]
+}
+
+// encrypt a country code with the key of the poster's encrypted address
+func encCountry(country, key string) string {
+	if len(country) == 0 || len(country) > sha512.Size {
+		return ""
+	}
+	pad := countryPad(key)
+	res := make([]byte, len(country))
+	for idx := range res {
+		res[idx] = country[idx] ^ pad[idx]
+	}
+	return base64.StdEncoding.EncodeToString(res)
+}
+
+// decrypt a country code encrypted with encCountry
+func decCountry(enc, key string) string {
+	enc_bytes, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(enc_bytes) > sha512.Size {
+		return ""
+	}
+	pad := countryPad(key)
+	for idx := range enc_bytes {
+		enc_bytes[idx] ^= pad[idx]
+	}
+	return string(enc_bytes)
+}
+
+// create the geoip policy from config, nil if disabled or the database can't be opened
+func geoPolicyFromConfig(conf map[string]string) *geoPolicy {
+	if conf["enable"] != "1" {
+		return nil
+	}
+	db, err := maxminddb.Open(conf["database"])
+	if err != nil {
+		log.Println("cannot open geoip database", conf["database"], err)
+		return nil
+	}
+	policy := &geoPolicy{
+		db:      db,
+		captcha: parseCountryCodes(conf["captcha"]),
+		block:   parseCountryCodes(conf["block"]),
+		record:  conf["record"] == "1",
+	}
+	log.Printf("geoip policy enabled, %d countries need a captcha, %d blocked", len(policy.captcha), len(policy.block))
+	return policy
+}
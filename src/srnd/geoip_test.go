@@ -0,0 +1,19 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestEncCountry(t *testing.T) {
+
+	key, _ := newAddrEnc("10.0.0.1")
+	enc := encCountry("DE", key)
+	if enc == "" || decCountry(enc, key) != "DE" {
+		t.Error("country did not survive encryption", enc)
+	}
+	codes := parseCountryCodes("de, us,")
+	if len(codes) != 2 || !codes["DE"] || !codes["US"] {
+		t.Error("bad country codes", codes)
+	}
+
+}
@@ -0,0 +1,178 @@
+//
+// group_config.go -- [group "name"] sections of srnd.ini, settings for a single newsgroup
+//
+
+package srnd
+
+import (
+	"fmt"
+	"github.com/majestrate/configparser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// what a newsgroup's [group "name"] section sets, what it leaves out comes from the rest of the config
+type GroupConfig struct {
+	Newsgroup string
+	// hours its articles are kept, 0 for forever, nil for article_lifetime in [nntp]
+	lifetime *int
+	// can posts to it have attachments, nil for allow_attachments in [nntp] and its board settings
+	attachments *bool
+	// captcha policy like in captcha_groups, empty for the one it has there
+	captcha string
+	// threads it keeps before the oldest expire, 0 for pages * threads per page in its board settings
+	threads int
+	// replies a cycling thread keeps, 0 for cycle_replies in [nntp]
+	cycle_replies int
+}
+
+// the hours its articles are kept given article_lifetime in [nntp]
+func (self GroupConfig) Lifetime(lifetime int) int {
+	if self.lifetime != nil {
+		return *self.lifetime
+	}
+	return lifetime
+}
+
+// can posts to it have attachments given what we allow otherwise
+func (self GroupConfig) Attachments(allow bool) bool {
+	if self.attachments != nil {
+		return *self.attachments
+	}
+	return allow
+}
+
+// threads it keeps given what its board settings keep
+func (self GroupConfig) Threads(threads int) int {
+	if self.threads > 0 {
+		return self.threads
+	}
+	return threads
+}
+
+// replies a cycling thread keeps given cycle_replies in [nntp]
+func (self GroupConfig) CycleReplies(replies int) int {
+	if self.cycle_replies > 0 {
+		return self.cycle_replies
+	}
+	return replies
+}
+
+// the settings of a newsgroup's [group "name"] section, none set if it has none
+func (self *NNTPDaemon) groupConfig(newsgroup string) GroupConfig {
+	self.groups_mtx.RLock()
+	defer self.groups_mtx.RUnlock()
+	return self.groups[newsgroup]
+}
+
+// every [group "name"] section, not to be modified
+func (self *NNTPDaemon) groupConfigs() map[string]GroupConfig {
+	self.groups_mtx.RLock()
+	defer self.groups_mtx.RUnlock()
+	return self.groups
+}
+
+// can posts to newsgroup have attachments, its [group] section wins over allow_attachments
+func (self *NNTPDaemon) allowAttachments(newsgroup string) bool {
+	return self.groupConfig(newsgroup).Attachments(self.postingConfig().allow_attachments)
+}
+
+// the newsgroup of a [group "name"] section
+func groupSectionName(section string) (newsgroup string, ok bool) {
+	if !strings.HasPrefix(section, "group ") {
+		return
+	}
+	name := strings.TrimSpace(section[6:])
+	if len(name) < 3 || name[0] != '"' || name[len(name)-1] != '"' {
+		return
+	}
+	newsgroup = name[1 : len(name)-1]
+	ok = newsgroupValidFormat(newsgroup)
+	return
+}
+
+// parse the options of a newsgroup's section, options that don't parse are returned as problems and left unset
+func parseGroupConfig(newsgroup string, opts map[string]string) (conf GroupConfig, problems []string) {
+	conf.Newsgroup = newsgroup
+	num := func(key string) (n int, ok bool) {
+		v, set := opts[key]
+		if !set {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("%s in [group %q] is %q, it should be %s", key, newsgroup, v, confInt))
+			return 0, false
+		}
+		return n, true
+	}
+	if n, ok := num("article_lifetime"); ok {
+		conf.lifetime = &n
+	}
+	if v, ok := opts["attachments"]; ok {
+		if v == "0" || v == "1" {
+			allow := v == "1"
+			conf.attachments = &allow
+		} else {
+			problems = append(problems, fmt.Sprintf("attachments in [group %q] is %q, it should be %s", newsgroup, v, confBool))
+		}
+	}
+	if v, ok := opts["captcha"]; ok {
+		if validCaptchaPolicy(v) {
+			conf.captcha = v
+		} else {
+			problems = append(problems, fmt.Sprintf("captcha in [group %q] is %q, it should be always, never, pow or a spam score", newsgroup, v))
+		}
+	}
+	conf.threads, _ = num("threads")
+	conf.cycle_replies, _ = num("cycle_replies")
+	return
+}
+
+// read every [group "name"] section, newsgroup -> its settings
+func groupsParse(conf *configparser.Configuration) (groups map[string]GroupConfig, problems []string) {
+	groups = make(map[string]GroupConfig)
+	// a superset of the group sections whether Find takes a glob or a regexp
+	sections, _ := conf.Find("group*")
+	for _, sect := range sections {
+		newsgroup, ok := groupSectionName(sect.Name())
+		if !ok {
+			if strings.HasPrefix(sect.Name(), "group ") {
+				problems = append(problems, fmt.Sprintf("[%s] is not [group \"newsgroup.name\"]", sect.Name()))
+			}
+			continue
+		}
+		g, p := parseGroupConfig(newsgroup, sect.Options())
+		groups[newsgroup] = g
+		problems = append(problems, p...)
+	}
+	return
+}
+
+// message-ids of the posts past their lifetime, per newsgroup if any [group] section sets one
+func postsPastLifetime(db Database, groups map[string]GroupConfig, lifetime int) (msgids []string, err error) {
+	perGroup := false
+	for _, g := range groups {
+		perGroup = perGroup || g.lifetime != nil
+	}
+	if !perGroup {
+		if lifetime > 0 {
+			msgids, err = db.GetPostsBefore(time.Now().Add(-time.Duration(lifetime) * time.Hour))
+		}
+		return
+	}
+	for _, group := range db.GetAllNewsgroups() {
+		hours := groups[group].Lifetime(lifetime)
+		if hours <= 0 {
+			continue
+		}
+		var old []string
+		old, err = db.GetPostsBeforeInGroup(group, time.Now().Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			return
+		}
+		msgids = append(msgids, old...)
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestGroupConfig(t *testing.T) {
+
+	if g, ok := groupSectionName(`group "overchan.random"`); !ok || g != "overchan.random" {
+		t.Error("bad newsgroup from section", g, ok)
+	}
+	for _, section := range []string{"group overchan.random", `group ""`, "captcha_groups", `group "bad group"`} {
+		if _, ok := groupSectionName(section); ok {
+			t.Error("took", section, "for a group section")
+		}
+	}
+	g, problems := parseGroupConfig("overchan.random", map[string]string{
+		"article_lifetime": "0",
+		"attachments":      "0",
+		"captcha":          "sometimes",
+		"threads":          "-1",
+		"cycle_replies":    "50",
+	})
+	if len(problems) != 2 {
+		t.Error("expected problems with captcha and threads, got", problems)
+	}
+	if g.Lifetime(24) != 0 || g.Attachments(true) || g.Threads(100) != 100 || g.CycleReplies(300) != 50 || g.captcha != "" {
+		t.Error("bad group config", g)
+	}
+	var none GroupConfig
+	if none.Lifetime(24) != 24 || !none.Attachments(true) || none.Threads(100) != 100 || none.CycleReplies(300) != 300 {
+		t.Error("unset group config does not fall back")
+	}
+
+}
@@ -0,0 +1,129 @@
+//
+// httpcache.go -- etags and cache-control so browsers and proxies don't make us render unchanged pages
+//
+
+package srnd
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// cache-control for each kind of thing we serve
+const (
+	// pages change with every post, always ask us but let us say 304
+	pageCacheControl = "public, no-cache"
+	// thumbnails and attachments are named by their hash and never change
+	fileCacheControl = "public, max-age=31536000, immutable"
+	// js, css and images shipped with the templates
+	staticCacheControl = "public, max-age=3600"
+)
+
+// caches regenerate pages on tickers, don't hand out an etag for a page
+// that changed so recently the markup might not have caught up yet
+const pageSettleTime = time.Second * 30
+
+// tracks changes to rendered pages that don't move any bump time
+// like mod deletions, thread flags and template reloads
+type pageValidator struct {
+	epoch int64
+}
+
+func newPageValidator() *pageValidator {
+	return &pageValidator{epoch: time.Now().UnixNano()}
+}
+
+// invalidate every etag we gave out
+func (self *pageValidator) changed() {
+	atomic.StoreInt64(&self.epoch, time.Now().UnixNano())
+}
+
+// make a weak etag for a page last changed at posted, weak as the body may be compressed
+func (self *pageValidator) etag(kind string, posted int64) string {
+	return fmt.Sprintf("W/\"%s-%x-%x\"", kind, posted, atomic.LoadInt64(&self.epoch))
+}
+
+// does the request already have what we would send?
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modtime.Truncate(time.Second).After(ims)
+	}
+	return false
+}
+
+// when the page for a file in the webroot last changed, 0 if we can't tell
+func (self *httpFrontend) pageLastPost(file string) (kind string, posted int64) {
+	db := self.daemon.database
+	file = strings.TrimSuffix(strings.TrimSuffix(file, ".html"), ".json")
+	if strings.HasPrefix(file, "thread-") {
+		e, err := db.GetMessageIDByHash(getThreadHash(file))
+		if err == nil && e.MessageID() != "" {
+			return "thread", db.GetThreadLastPostTime(e.MessageID())
+		}
+	} else if strings.HasPrefix(file, "catalog-") {
+		group := strings.TrimPrefix(file, "catalog-")
+		if db.HasNewsgroup(group) {
+			return "catalog", db.GetLastPostTimeInGroup(group)
+		}
+	} else if !strings.HasPrefix(file, "ukko-") {
+		group, page := getGroupAndPage(file)
+		if page >= 0 && db.HasNewsgroup(group) {
+			return fmt.Sprintf("board%d", page), db.GetLastPostTimeInGroup(group)
+		}
+	}
+	return
+}
+
+// answer with 304 for rendered pages the client already has
+func (self *httpFrontend) validatePages(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Cache-Control", pageCacheControl)
+		_, file := filepath.Split(r.URL.Path)
+		kind, posted := self.pageLastPost(file)
+		modtime := time.Unix(0, atomic.LoadInt64(&self.validator.epoch))
+		if t := time.Unix(posted, 0); t.After(modtime) {
+			modtime = t
+		}
+		if posted > 0 && time.Since(modtime) > pageSettleTime {
+			etag := self.validator.etag(kind, posted)
+			if notModified(r, etag, modtime) {
+				wr.Header().Set("ETag", etag)
+				wr.WriteHeader(http.StatusNotModified)
+				return
+			}
+			wr.Header().Set("ETag", etag)
+			wr.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+		}
+		h.ServeHTTP(wr, r)
+	})
+}
+
+// serve files with a cache-control policy, http.FileServer does the 304s
+func cacheControlled(policy string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Cache-Control", policy)
+		h.ServeHTTP(wr, r)
+	})
+}
+
+// thumbnails and attachments are content addressed so their name is their etag
+func contentAddressed(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		_, file := filepath.Split(r.URL.Path)
+		wr.Header().Set("ETag", "\""+file+"\"")
+		cacheControlled(fileCacheControl, h).ServeHTTP(wr, r)
+	})
+}
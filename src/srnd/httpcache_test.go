@@ -0,0 +1,24 @@
+package srnd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+
+	v := newPageValidator()
+	etag := v.etag("thread", 100)
+	r, _ := http.NewRequest("GET", "http://example.com/thread-abc.html", nil)
+	r.Header.Set("If-None-Match", strings.TrimPrefix(etag, "W/"))
+	if !notModified(r, etag, time.Unix(100, 0)) {
+		t.Error("matching etag should be not modified")
+	}
+	v.changed()
+	if notModified(r, v.etag("thread", 100), time.Unix(100, 0)) {
+		t.Error("etag should change after a mod event")
+	}
+
+}
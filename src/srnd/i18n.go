@@ -5,6 +5,7 @@ import (
 	"golang.org/x/text/language"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"path/filepath"
 	"strings"
 )
@@ -21,6 +22,37 @@ type i18n struct {
 
 var i18nProvider *i18n = nil
 
+// every locale we have translations for, the default first
+var i18nCatalogs []*i18n
+
+// picks the best of i18nCatalogs for a locale preference
+var i18nMatcher language.Matcher
+
+// newsgroup -> locale its pages are rendered in when the reader has no preference
+var i18nBoardLocales = make(map[string]string)
+
+// load a translation file
+func loadI18n(fname string, tag language.Tag) (*i18n, error) {
+	conf, err := configparser.Read(fname)
+	if err != nil {
+		return nil, err
+	}
+	formats, err := conf.Section("formats")
+	if err != nil {
+		return nil, err
+	}
+	translations, err := conf.Section("strings")
+	if err != nil {
+		return nil, err
+	}
+	return &i18n{
+		translation_dir: filepath.Dir(fname),
+		formats:         formats.Options(),
+		translations:    translations.Options(),
+		locale:          tag,
+	}, nil
+}
+
 //Read all .ini files in dir, where the filenames are BCP 47 tags
 //Use the language matcher to get the best match for the locale preference
 func InitI18n(locale, dir string) {
@@ -46,26 +78,67 @@ func InitI18n(locale, dir string) {
 	tag, _, _ := matcher.Match(pref)
 
 	fname := filepath.Join(dir, tag.String()+".ini")
-	conf, err := configparser.Read(fname)
+	i18nProvider, err = loadI18n(fname, tag)
 	if err != nil {
-		log.Fatal("cannot read translation file for", tag.String(), err)
+		log.Fatal("cannot read translation file for ", tag.String(), " ", err)
 	}
 
-	formats, err := conf.Section("formats")
-	if err != nil {
-		log.Fatal("Cannot read formats sections in translations for", tag.String(), err)
+	// load every other locale for readers who prefer it
+	i18nCatalogs = []*i18n{i18nProvider}
+	langs := []language.Tag{tag}
+	for _, t := range serverLangs {
+		if t == tag {
+			continue
+		}
+		catalog, err := loadI18n(filepath.Join(dir, t.String()+".ini"), t)
+		if err == nil {
+			i18nCatalogs = append(i18nCatalogs, catalog)
+			langs = append(langs, t)
+		} else if t != language.AmericanEnglish {
+			log.Println("cannot read translation file for", t.String(), err)
+		}
 	}
-	translations, err := conf.Section("strings")
+	i18nMatcher = language.NewMatcher(langs)
+}
+
+// set the locales newsgroups are rendered in, newsgroup -> locale
+func SetBoardLocales(locales map[string]string) {
+	i18nBoardLocales = locales
+}
+
+// the best translations we have for a list of locale preferences, the default if none fit
+func i18nForLocales(prefs ...language.Tag) *i18n {
+	if i18nMatcher == nil || len(prefs) == 0 {
+		return i18nProvider
+	}
+	_, idx, conf := i18nMatcher.Match(prefs...)
+	if conf == language.No || idx >= len(i18nCatalogs) {
+		return i18nProvider
+	}
+	return i18nCatalogs[idx]
+}
+
+// the translations a newsgroup's pages are rendered with
+func i18nForBoard(group string) *i18n {
+	locale, ok := i18nBoardLocales[group]
+	if !ok {
+		return i18nProvider
+	}
+	tag, err := language.Parse(locale)
 	if err != nil {
-		log.Fatal("Cannot read strings sections in translations for", tag.String(), err)
+		return i18nProvider
 	}
+	return i18nForLocales(tag)
+}
 
-	i18nProvider = &i18n{
-		translation_dir: dir,
-		formats:         formats.Options(),
-		translations:    translations.Options(),
-		locale:          tag,
+// the translations for a page rendered for one reader
+// their Accept-Language wins over the newsgroup's locale
+func i18nForRequest(r *http.Request, group string) *i18n {
+	prefs, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err == nil && len(prefs) > 0 {
+		return i18nForLocales(prefs...)
 	}
+	return i18nForBoard(group)
 }
 
 func (self *i18n) Translate(key string) string {
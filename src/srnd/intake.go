@@ -0,0 +1,78 @@
+//
+// intake.go -- hold articles from new or untrusted peers until the mods approve them
+//
+
+package srnd
+
+import (
+	"log"
+)
+
+// get the config of a feed by name, nil if we have no such feed
+func (self *NNTPDaemon) feedConfig(feedname string) *FeedConfig {
+	feeds := self.feedConfigs()
+	for idx := range feeds {
+		if feeds[idx].Name == feedname {
+			return &feeds[idx]
+		}
+	}
+	return nil
+}
+
+// return the feed to hold articles from or empty string if they are published right away
+func (self *NNTPDaemon) intakeHold(feedname string) string {
+	if feedname == "" {
+		return ""
+	}
+	conf := self.feedConfig(feedname)
+	if conf == nil || !conf.moderated_intake {
+		return ""
+	}
+	intake, err := self.database.GetFeedIntake(feedname)
+	if err != nil {
+		// hold it, a mod can still let it through
+		log.Println("failed to get intake of", feedname, err)
+		return feedname
+	}
+	if intake.Trusted {
+		return ""
+	}
+	return feedname
+}
+
+// count a mod decision on an article from a feed in moderated intake
+// a clean streak of trust_after approvals promotes the feed, a rejection starts over
+func (self *NNTPDaemon) settleIntake(feedname string, approved bool) (err error) {
+	var intake FeedIntake
+	intake, err = self.database.GetFeedIntake(feedname)
+	if err != nil || intake.Trusted {
+		return
+	}
+	if approved {
+		intake.Streak++
+		conf := self.feedConfig(feedname)
+		if conf != nil && conf.trust_after > 0 && intake.Streak >= int64(conf.trust_after) {
+			log.Println(feedname, "approved", intake.Streak, "articles in a row, trusting it")
+			intake.Trusted = true
+		}
+	} else {
+		intake.Streak = 0
+	}
+	err = self.database.SetFeedIntake(intake)
+	return
+}
+
+// get the intake state of every feed in moderated intake
+func (self *NNTPDaemon) feedIntakes() (intakes []FeedIntake, err error) {
+	for _, conf := range self.feedConfigs() {
+		if conf.moderated_intake {
+			var intake FeedIntake
+			intake, err = self.database.GetFeedIntake(conf.Name)
+			if err != nil {
+				return
+			}
+			intakes = append(intakes, intake)
+		}
+	}
+	return
+}
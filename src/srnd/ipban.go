@@ -0,0 +1,65 @@
+//
+// ipban.go -- listing and explaining ip and ip range bans
+//
+
+package srnd
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var NoSuchBan = errors.New("no such ban")
+
+// parse an address made by ZeroIPString
+func parseZeroIP(str string) net.IP {
+	if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+		return net.ParseIP(str[1 : len(str)-1])
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) != 4 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	for idx, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 255 {
+			return nil
+		}
+		ip[idx] = byte(n)
+	}
+	return ip
+}
+
+// turn a range between two addresses made by ZeroIPString back into a cidr
+// ranges that are not a cidr come back as "start - end"
+func ipRangeCIDR(start, end string) string {
+	min := parseZeroIP(start)
+	max := parseZeroIP(end)
+	if min == nil || max == nil || len(min) != len(max) {
+		return start + " - " + end
+	}
+	bits := len(min) * 8
+	ones := 0
+	for ones < bits && (min[ones/8]>>uint(7-ones%8))&1 == (max[ones/8]>>uint(7-ones%8))&1 {
+		ones++
+	}
+	inet := &net.IPNet{IP: min, Mask: net.CIDRMask(ones, bits)}
+	lo, hi := IPNet2MinMax(inet)
+	if !lo.Equal(min) || !hi.Equal(max) {
+		return start + " - " + end
+	}
+	return inet.String()
+}
+
+// return true if a ban on addr or cidr covers ip
+func addrBanCovers(addr string, ip net.IP) bool {
+	_, inet, err := net.ParseCIDR(addr)
+	if err == nil {
+		return inet.Contains(ip)
+	}
+	banned := net.ParseIP(addr)
+	return banned != nil && banned.Equal(ip)
+}
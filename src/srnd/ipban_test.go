@@ -0,0 +1,25 @@
+package srnd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPRangeCIDR(t *testing.T) {
+
+	for _, cidr := range []string{"10.0.0.0/8", "192.168.1.0/24", "2001:db8::/32"} {
+		_, inet, _ := net.ParseCIDR(cidr)
+		min, max := IPNet2MinMax(inet)
+		got := ipRangeCIDR(ZeroIPString(min), ZeroIPString(max))
+		if got != cidr {
+			t.Error("range of", cidr, "came back as", got)
+		}
+	}
+	if ipRangeCIDR("010.000.000.001", "010.000.000.005") != "010.000.000.001 - 010.000.000.005" {
+		t.Error("range that is not a cidr turned into one")
+	}
+	if !addrBanCovers("10.0.0.0/8", net.ParseIP("10.1.2.3")) || addrBanCovers("10.0.0.0/8", net.ParseIP("11.1.2.3")) {
+		t.Error("cidr ban covers wrong addresses")
+	}
+
+}
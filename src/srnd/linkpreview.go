@@ -0,0 +1,278 @@
+//
+// linkpreview.go -- oembed and opengraph previews for links to allowed sites
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// what we show under a post for a link in it
+type linkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// only set if it is on an allowed site too
+	Image string `json:"image"`
+	Site  string `json:"site"`
+}
+
+// a fetched preview, nil if the page had none
+type cachedPreview struct {
+	preview *linkPreview
+	fetched time.Time
+}
+
+// most previews shown for one post
+const linkPreviewsPerPost = 3
+
+// most of a page we read looking for a preview
+const linkPreviewMaxBody = 512 * 1024
+
+var re_oembed_link = regexp.MustCompile(`(?i)<link[^>]+type=["']application/json\+oembed["'][^>]*>`)
+var re_meta_tag = regexp.MustCompile(`(?i)<meta[^>]+>`)
+var re_meta_property = regexp.MustCompile(`(?i)(?:property|name)=["']og:([a-z_]+)["']`)
+var re_meta_content = regexp.MustCompile(`(?i)content=["']([^"']*)["']`)
+var re_href = regexp.MustCompile(`(?i)href=["']([^"']+)["']`)
+var re_title_tag = regexp.MustCompile(`(?i)<title[^>]*>([^<]*)</title>`)
+
+type linkPreviewer struct {
+	access sync.Mutex
+	// domains we fetch previews from, subdomains included
+	domains []string
+	// newsgroups that show previews, all if empty
+	boards map[string]bool
+	// how long we keep a preview
+	maxAge time.Duration
+	client *http.Client
+	// url -> preview
+	cache map[string]cachedPreview
+	// urls being fetched now
+	pending map[string]bool
+	// called with the thread a preview was fetched for so it gets rendered again
+	regen func(ArticleEntry)
+}
+
+// the previews we show for links on newsgroups, nil for none
+var linkPreviews *linkPreviewer
+
+// set the link previewer, nil to not show previews
+func SetLinkPreviews(previewer *linkPreviewer) {
+	linkPreviews = previewer
+}
+
+// is this a link to a site we fetch previews from?
+func (self *linkPreviewer) Allowed(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range self.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// get the previews we have for links in a post's message
+// links we have nothing for yet are fetched and the thread is rendered again after
+func (self *linkPreviewer) For(group, root, message string) (previews []linkPreview) {
+	if self == nil || (len(self.boards) > 0 && !self.boards[group]) {
+		return
+	}
+	now := time.Now()
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, link := range re_external_link.FindAllString(message, -1) {
+		if len(previews) >= linkPreviewsPerPost {
+			break
+		}
+		if !self.Allowed(link) {
+			continue
+		}
+		c, ok := self.cache[link]
+		if ok && now.Sub(c.fetched) < self.maxAge {
+			if c.preview != nil {
+				previews = append(previews, *c.preview)
+			}
+		} else if !self.pending[link] {
+			self.pending[link] = true
+			go self.fetchFor(link, ArticleEntry{root, group})
+		}
+	}
+	return
+}
+
+// fetch a preview and render the thread it was for again
+func (self *linkPreviewer) fetchFor(link string, thread ArticleEntry) {
+	p := self.fetch(link)
+	self.access.Lock()
+	self.expire(time.Now())
+	self.cache[link] = cachedPreview{p, time.Now()}
+	delete(self.pending, link)
+	regen := self.regen
+	self.access.Unlock()
+	if p != nil && regen != nil {
+		regen(thread)
+	}
+}
+
+// forget previews older than max age, must hold the lock
+func (self *linkPreviewer) expire(now time.Time) {
+	for link, c := range self.cache {
+		if now.Sub(c.fetched) >= self.maxAge {
+			delete(self.cache, link)
+		}
+	}
+}
+
+// get a page from an allowed site, nil if it is not html or we can't get it
+func (self *linkPreviewer) get(link string, accept string) []byte {
+	resp, err := self.client.Get(link)
+	if err != nil {
+		log.Println("cannot fetch link preview", link, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(resp.Header.Get("Content-Type"), accept) {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBody))
+	return body
+}
+
+// make a preview for a link from its oembed or opengraph data, nil if it has none
+func (self *linkPreviewer) fetch(link string) *linkPreview {
+	body := self.get(link, "html")
+	if body == nil {
+		return nil
+	}
+	page := string(body)
+	p := &linkPreview{URL: link}
+	base, _ := url.Parse(link)
+	// oembed from the same site first
+	if tag := re_oembed_link.FindString(page); tag != "" {
+		m := re_href.FindStringSubmatch(tag)
+		if len(m) == 2 {
+			u, err := base.Parse(html.UnescapeString(m[1]))
+			if err == nil && self.Allowed(u.String()) {
+				var oembed struct {
+					Title        string `json:"title"`
+					AuthorName   string `json:"author_name"`
+					ProviderName string `json:"provider_name"`
+					ThumbnailURL string `json:"thumbnail_url"`
+				}
+				data := self.get(u.String(), "json")
+				if data != nil && json.Unmarshal(data, &oembed) == nil {
+					p.Title = oembed.Title
+					p.Description = oembed.AuthorName
+					p.Site = oembed.ProviderName
+					p.Image = oembed.ThumbnailURL
+				}
+			}
+		}
+	}
+	// fill in what is missing from opengraph
+	for _, tag := range re_meta_tag.FindAllString(page, -1) {
+		prop := re_meta_property.FindStringSubmatch(tag)
+		content := re_meta_content.FindStringSubmatch(tag)
+		if len(prop) != 2 || len(content) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(html.UnescapeString(content[1]))
+		switch strings.ToLower(prop[1]) {
+		case "title":
+			if p.Title == "" {
+				p.Title = value
+			}
+		case "description":
+			if p.Description == "" {
+				p.Description = value
+			}
+		case "image":
+			if p.Image == "" {
+				p.Image = value
+			}
+		case "site_name":
+			if p.Site == "" {
+				p.Site = value
+			}
+		}
+	}
+	if p.Title == "" {
+		m := re_title_tag.FindStringSubmatch(page)
+		if len(m) == 2 {
+			p.Title = strings.TrimSpace(html.UnescapeString(m[1]))
+		}
+	}
+	if p.Title == "" {
+		return nil
+	}
+	if p.Image != "" {
+		u, err := base.Parse(p.Image)
+		// readers' browsers load the image so it has to be from an allowed site too
+		if err == nil && self.Allowed(u.String()) {
+			p.Image = u.String()
+		} else {
+			p.Image = ""
+		}
+	}
+	if r := []rune(p.Description); len(r) > 300 {
+		p.Description = string(r[:300]) + "..."
+	}
+	return p
+}
+
+// create the link previewer from config, nil if it is disabled
+func linkPreviewerFromConfig(conf *SRNdConfig) *linkPreviewer {
+	if conf.link_previews["enable"] != "1" {
+		return nil
+	}
+	self := &linkPreviewer{
+		boards:  make(map[string]bool),
+		maxAge:  time.Duration(mapGetInt(conf.link_previews, "max_age", 3600)) * time.Second,
+		cache:   make(map[string]cachedPreview),
+		pending: make(map[string]bool),
+	}
+	self.client = &http.Client{
+		Timeout: time.Duration(mapGetInt(conf.link_previews, "timeout", 10)) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// don't let an allowed site send us anywhere else
+			if len(via) >= 5 || !self.Allowed(req.URL.String()) {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	for _, domain := range strings.Split(conf.link_previews["domains"], ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			self.domains = append(self.domains, domain)
+		}
+	}
+	for _, group := range strings.Split(conf.link_previews["boards"], ",") {
+		group = strings.TrimSpace(group)
+		if group != "" {
+			self.boards[group] = true
+		}
+	}
+	if len(self.domains) == 0 {
+		log.Println("link previews enabled but no domains allowed, not showing any")
+		return nil
+	}
+	log.Println("link previews enabled for", strings.Join(self.domains, ", "))
+	return self
+}
@@ -0,0 +1,17 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestLinkPreviewAllowed(t *testing.T) {
+
+	p := &linkPreviewer{domains: []string{"example.com"}}
+	if !p.Allowed("https://video.example.com/watch?v=1") {
+		t.Error("subdomains of allowed domains should be allowed")
+	}
+	if p.Allowed("https://badexample.com/") || p.Allowed("ftp://example.com/") {
+		t.Error("other sites and schemes should not be allowed")
+	}
+
+}
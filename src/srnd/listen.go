@@ -0,0 +1,139 @@
+//
+// listen.go -- binding to tcp, unix sockets or sockets systemd hands us
+//
+
+package srnd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the first file descriptor systemd passes
+const systemdFirstFD = 3
+
+var NoSystemdSocket = errors.New("no such socket from systemd")
+
+// sockets systemd passed us, by name and in order
+type systemdSockets struct {
+	files []*os.File
+	names []string
+}
+
+var systemdOnce sync.Once
+var systemdPassed systemdSockets
+
+// get the sockets systemd passed to this process
+// only done once as the environment is cleared after so children don't take them too
+func systemdListenFiles() systemdSockets {
+	systemdOnce.Do(func() {
+		pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		names := os.Getenv("LISTEN_FDNAMES")
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		if pid != os.Getpid() || count <= 0 {
+			return
+		}
+		systemdPassed = parseSystemdSockets(count, names)
+	})
+	return systemdPassed
+}
+
+// make the socket list for count fds with colon separated LISTEN_FDNAMES
+func parseSystemdSockets(count int, names string) (s systemdSockets) {
+	var fdnames []string
+	if names != "" {
+		fdnames = strings.Split(names, ":")
+	}
+	for idx := 0; idx < count; idx++ {
+		name := ""
+		if idx < len(fdnames) {
+			name = fdnames[idx]
+		}
+		fd := uintptr(systemdFirstFD + idx)
+		s.files = append(s.files, os.NewFile(fd, fmt.Sprintf("systemd-%d", fd)))
+		s.names = append(s.names, name)
+	}
+	return
+}
+
+// find a passed socket by its FileDescriptorName= or its index, the first one if which is empty
+func (self systemdSockets) find(which string) (*os.File, error) {
+	if which == "" && len(self.files) > 0 {
+		return self.files[0], nil
+	}
+	for idx, name := range self.names {
+		if name == which {
+			return self.files[idx], nil
+		}
+	}
+	idx, err := strconv.Atoi(which)
+	if err == nil && idx >= 0 && idx < len(self.files) {
+		return self.files[idx], nil
+	}
+	return nil, NoSystemdSocket
+}
+
+// is this bind a unix socket path?
+func isUnixBind(addr string) bool {
+	return strings.HasPrefix(addr, "unix:")
+}
+
+// listen on a bind address from config
+// unix:/path/to/socket for a unix socket, systemd or systemd:name for a socket from socket activation
+// anything else is a tcp host:port
+func listenBind(addr string) (net.Listener, error) {
+	if isUnixBind(addr) {
+		path := strings.TrimPrefix(addr, "unix:")
+		// a socket left over from last time would make us fail to bind
+		if st, err := os.Lstat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err == nil {
+			// let the group, so the reverse proxy, connect
+			err = os.Chmod(path, 0660)
+			if err != nil {
+				l.Close()
+				l = nil
+			}
+		}
+		return l, err
+	}
+	if addr == "systemd" || strings.HasPrefix(addr, "systemd:") {
+		f, err := systemdListenFiles().find(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+		if err != nil {
+			return nil, err
+		}
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// connect to a bind address from config, unix:/path/to/socket or host:port
+func dialBind(addr string) (net.Conn, error) {
+	if isUnixBind(addr) {
+		return net.DialTimeout("unix", strings.TrimPrefix(addr, "unix:"), time.Second*10)
+	}
+	return net.DialTimeout("tcp", addr, time.Second*10)
+}
+
+// requests over unix sockets have no remote address
+// make them look like they come from loopback so a proxy's forwarded headers are believed as usual
+func localRemoteAddr(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if _, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
+			r.RemoteAddr = "127.0.0.1:0"
+		}
+		h.ServeHTTP(wr, r)
+	})
+}
@@ -0,0 +1,23 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestSystemdSockets(t *testing.T) {
+
+	s := parseSystemdSockets(2, "http:nntp")
+	f, err := s.find("nntp")
+	if err != nil || f.Fd() != 4 {
+		t.Error("should find sockets by name", err)
+	}
+	f, err = s.find("")
+	if err != nil || f.Fd() != 3 {
+		t.Error("should take the first socket by default", err)
+	}
+	_, err = s.find("2")
+	if err != NoSystemdSocket {
+		t.Error("should not find sockets we were not passed")
+	}
+
+}
@@ -0,0 +1,54 @@
+//
+// loglevel.go -- how much srnd logs, log_level in [nntp]
+//
+
+package srnd
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	// every line with where in the code it was logged from
+	LogDebug = "debug"
+	// every line, the default
+	LogInfo = "info"
+	// only lines about something that went wrong
+	LogError = "error"
+)
+
+// our log lines have no level, so lines with these say something went wrong
+var logErrorWords = []string{"error", "fail", "fatal", "cannot", "can't", "invalid", "panic", "refus", "denied"}
+
+// passes on the log lines that say something went wrong and drops the rest
+type errorLogWriter struct {
+	w io.Writer
+}
+
+func (self errorLogWriter) Write(p []byte) (int, error) {
+	line := strings.ToLower(string(p))
+	for _, word := range logErrorWords {
+		if strings.Contains(line, word) {
+			return self.w.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// log at a level from log_level, unknown levels log everything
+func setLogLevel(level string) {
+	switch level {
+	case LogDebug:
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+		log.SetOutput(os.Stderr)
+	case LogError:
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(errorLogWriter{os.Stderr})
+	default:
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}
+}
@@ -18,14 +18,46 @@ var re_backlink = regexp.MustCompile(`>> ?([0-9a-f]+)`)
 var re_boardlink = regexp.MustCompile(`>>> ?/([0-9a-zA-Z\.]+)/`)
 var re_nntpboardlink = regexp.MustCompile(`news:([0-9a-zA-Z\.]+)`)
 
+const (
+	// escaped text with links
+	MarkupPlain = "plain"
+	// greentext, quotes, redtext, spoilers and code blocks
+	MarkupBasic = "basic"
+	// basic with bold, italics, strikethrough, inline code and links
+	MarkupMarkdown = "markdown"
+)
+
+// engine for newsgroups without their own
+var markupDefault = MarkupBasic
+
+// newsgroup -> markup engine
+var markupBoards map[string]string
+
+// the distinct message id hashes a post body quotes with >>hash
+func quotedHashes(message string) (hashes []string) {
+	seen := make(map[string]bool)
+	for _, m := range re_backlink.FindAllStringSubmatch(message, -1) {
+		hash := m[1]
+		if len(hash) >= 10 && len(hash) <= 40 && !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	return
+}
+
 // parse backlink
-func backlink(word string) (markup string) {
+func backlink(word, prefix string) (markup string) {
 	re := re_backlink.Copy()
 	link := re.FindString(word)
 	if len(link) > 2 {
 		link = strings.Trim(link[2:], " ")
 		if len(link) > 2 {
 			url := template.findLink(link)
+			if len(url) == 0 {
+				// not on a page we have in memory, maybe in another thread
+				url = template.resolveLink(link, prefix)
+			}
 			if len(url) == 0 {
 				return "<span class='memearrows'>&gt;&gt;" + link + "</span>"
 			}
@@ -60,10 +92,13 @@ func escapeline(line string) (markup string) {
 	return
 }
 
-func formatline(line, prefix string) (markup string) {
+func formatline(line, prefix, engine string) (markup string) {
 	if len(line) > 0 {
 		line_nospace := strings.Trim(line, " ")
-		if strings.HasPrefix(line_nospace, ">") && !strings.HasPrefix(line_nospace, ">>") {
+		if engine == MarkupPlain {
+			// just links
+			markup += formatlinks(line)
+		} else if strings.HasPrefix(line_nospace, ">") && !strings.HasPrefix(line_nospace, ">>") {
 			// le ebin meme arrows
 			markup += "<span class='memearrows'>"
 			markup += escapeline(line)
@@ -78,32 +113,156 @@ func formatline(line, prefix string) (markup string) {
 			markup += "<span class='psy'>"
 			markup += escapeline(line[2 : len(line)-2])
 			markup += "</span>"
+		} else if engine == MarkupMarkdown {
+			markup += formatinline(line, prefix, markdownInline)
 		} else {
-			// regular line
-			// for each word
-			for _, word := range strings.Split(line, " ") {
-				if re_boardlink.MatchString(word) {
-					markup += boardlink(word, prefix, re_boardlink)
-				} else if re_nntpboardlink.MatchString(word) {
-					markup += boardlink(word, prefix, re_nntpboardlink)
-				} else if re_backlink.MatchString(word) {
-					markup += backlink(word)
-				} else {
-					// linkify as needed
-					word = escapeline(word)
-					markup += re_external_link.ReplaceAllString(word, `<a href="$1">$1</a>`)
-				}
-				markup += " "
+			markup += formatinline(line, prefix, basicInline)
+		}
+	}
+	return
+}
+
+// format a piece of a regular line word by word
+func formatwords(text, prefix string) string {
+	var words []string
+	for _, word := range strings.Split(text, " ") {
+		if re_boardlink.MatchString(word) {
+			words = append(words, boardlink(word, prefix, re_boardlink))
+		} else if re_nntpboardlink.MatchString(word) {
+			words = append(words, boardlink(word, prefix, re_nntpboardlink))
+		} else if re_backlink.MatchString(word) {
+			words = append(words, backlink(word, prefix))
+		} else {
+			words = append(words, formatlinks(word))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// escape text and linkify as needed
+func formatlinks(text string) string {
+	return re_external_link.ReplaceAllString(escapeline(text), `<a href="$1">$1</a>`)
+}
+
+// markup inside a line like spoilers, the matched text is formatted again unless raw
+type inlineRule struct {
+	re    *regexp.Regexp
+	open  string
+	close string
+	// escape what is inside instead of formatting it
+	raw bool
+	// the second submatch is the url of a link around the first one
+	link bool
+}
+
+// inline markup every engine but plain has
+var basicInline = []inlineRule{
+	{re: regexp.MustCompile(`\[spoiler\](.+?)\[/spoiler\]`), open: "<span class='spoiler'>", close: "</span>"},
+	{re: regexp.MustCompile(`\|\|(.+?)\|\|`), open: "<span class='spoiler'>", close: "</span>"},
+}
+
+// inline markup of the markdown subset
+var markdownInline = append([]inlineRule{
+	{re: regexp.MustCompile("`([^`]+)`"), open: "<code>", close: "</code>", raw: true},
+	{re: regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s()]+)\)`), link: true},
+	{re: regexp.MustCompile(`\*\*(.+?)\*\*`), open: "<strong>", close: "</strong>"},
+	{re: regexp.MustCompile(`~~(.+?)~~`), open: "<del>", close: "</del>"},
+	{re: regexp.MustCompile(`\*([^*]+)\*`), open: "<em>", close: "</em>"},
+}, basicInline...)
+
+// format text applying the leftmost inline rule first, earlier rules win ties
+func formatinline(text, prefix string, rules []inlineRule) (markup string) {
+	for len(text) > 0 {
+		var loc []int
+		var rule inlineRule
+		for _, r := range rules {
+			l := r.re.FindStringSubmatchIndex(text)
+			if l != nil && (loc == nil || l[0] < loc[0]) {
+				loc, rule = l, r
 			}
 		}
+		if loc == nil {
+			markup += formatwords(text, prefix)
+			break
+		}
+		markup += formatwords(text[:loc[0]], prefix)
+		inner := text[loc[2]:loc[3]]
+		if rule.link {
+			markup += `<a class="mdlink" rel="nofollow noopener" href="` + escapeline(text[loc[4]:loc[5]]) + `">` + escapeline(inner) + "</a>"
+		} else if rule.raw {
+			markup += rule.open + escapeline(inner) + rule.close
+		} else {
+			markup += rule.open + formatinline(inner, prefix, rules) + rule.close
+		}
+		text = text[loc[1]:]
 	}
 	return
 }
 
-func memeposting(src, prefix string) (markup string) {
+// is line the start or the end of a code block
+func codefence(line string, incode bool) bool {
+	line = strings.Trim(line, " \t")
+	if strings.HasPrefix(line, "```") {
+		return true
+	}
+	if incode {
+		return line == "[/code]"
+	}
+	return line == "[code]"
+}
+
+// render a post body with a markup engine
+func formatpost(src, prefix, engine string) (markup string) {
+	incode := false
 	for _, line := range strings.Split(src, "\n") {
 		line = strings.Trim(line, "\r")
-		markup += formatline(line, prefix) + "\n"
+		if engine != MarkupPlain && codefence(line, incode) {
+			if incode {
+				markup += "</pre>\n"
+			} else {
+				markup += "<pre class='code'>"
+			}
+			incode = !incode
+		} else if incode {
+			markup += escapeline(line) + "\n"
+		} else {
+			markup += formatline(line, prefix, engine) + "\n"
+		}
+	}
+	if incode {
+		// unclosed code block
+		markup += "</pre>\n"
 	}
 	return
 }
+
+func memeposting(src, prefix string) (markup string) {
+	return formatpost(src, prefix, MarkupBasic)
+}
+
+// render a post body on a newsgroup with the engine it is set to use
+func renderMarkup(src, prefix, group string) string {
+	return formatpost(src, prefix, markupForBoard(group))
+}
+
+// set the default markup engine and the newsgroup -> engine overrides
+func SetMarkup(engine string, boards map[string]string) {
+	if validMarkup(engine) {
+		markupDefault = engine
+	}
+	markupBoards = boards
+}
+
+// the markup engine a newsgroup uses
+func markupForBoard(group string) string {
+	engine, ok := markupBoards[group]
+	if ok && validMarkup(engine) {
+		return engine
+	}
+	return markupDefault
+}
+
+// return true if we have a markup engine with this name
+func validMarkup(engine string) bool {
+	return engine == MarkupPlain || engine == MarkupBasic || engine == MarkupMarkdown
+}
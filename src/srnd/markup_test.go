@@ -0,0 +1,27 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestMarkup(t *testing.T) {
+
+	m := formatpost("a ||<b>|| c\n```\n**x**\n```", "/", MarkupBasic)
+	if m != "a <span class='spoiler'>&lt;b&gt;</span> c\n<pre class='code'>**x**\n</pre>\n" {
+		t.Error("bad basic markup", m)
+	}
+	m = formatpost("**bold** `<i>` [x](javascript:alert) [y](https://a.b/\"c)", "/", MarkupMarkdown)
+	if m != `<strong>bold</strong> <code>&lt;i&gt;</code> [x](javascript:alert) <a class="mdlink" rel="nofollow noopener" href="https://a.b/&#34;c">y</a>`+"\n" {
+		t.Error("bad markdown markup", m)
+	}
+
+}
+
+func TestQuotedHashes(t *testing.T) {
+
+	h := quotedHashes(">>0123456789 >> abcdef0123456789ab\n>>0123456789 >>abc >>>/overchan.test/")
+	if len(h) != 2 || h[0] != "0123456789" || h[1] != "abcdef0123456789ab" {
+		t.Error("bad quoted hashes", h)
+	}
+
+}
@@ -0,0 +1,355 @@
+//
+// mbox.go -- seeding boards from mbox and maildir archives and archiving them to mbox
+//
+
+package srnd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a quoted From line in an mbox body, >From or >>From and so on
+var exp_mbox_quoted_from = regexp.MustCompile(`^>+From `)
+
+// split an mbox into its messages, undoing the >From quoting
+func splitMbox(r io.Reader) (msgs [][]byte, err error) {
+	br := bufio.NewReader(r)
+	var cur *bytes.Buffer
+	for {
+		var line string
+		line, err = br.ReadString('\n')
+		if len(line) > 0 {
+			if strings.HasPrefix(line, "From ") {
+				if cur != nil {
+					msgs = append(msgs, cur.Bytes())
+				}
+				cur = new(bytes.Buffer)
+			} else if cur != nil {
+				if exp_mbox_quoted_from.MatchString(line) {
+					line = line[1:]
+				}
+				cur.WriteString(line)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if cur != nil {
+		msgs = append(msgs, cur.Bytes())
+	}
+	return
+}
+
+// read every message of a maildir, or every file in a directory that isn't one
+func readMaildir(dir string) (msgs [][]byte, err error) {
+	var files []string
+	for _, sub := range []string{"cur", "new"} {
+		names, _ := filepath.Glob(filepath.Join(dir, sub, "*"))
+		files = append(files, names...)
+	}
+	if len(files) == 0 {
+		files, err = filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			return
+		}
+	}
+	sort.Strings(files)
+	for _, fname := range files {
+		st, err := os.Stat(fname)
+		if err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, data)
+	}
+	return
+}
+
+// message-ids in a References or In-Reply-To header
+func mailMessageIDs(hdr string) (ids []string) {
+	for _, f := range strings.Fields(hdr) {
+		if strings.HasPrefix(f, "<") && strings.HasSuffix(f, ">") {
+			ids = append(ids, f)
+		}
+	}
+	return
+}
+
+// undo a part's Content-Transfer-Encoding
+func mailDecodeBody(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return ioutil.ReadAll(body)
+}
+
+// the text of a mail or a part of one, the first text/plain part if it has several
+func mailText(contentType, encoding string, body io.Reader) (string, error) {
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediatype, "multipart/") {
+		text, err := mailDecodeBody(body, encoding)
+		return string(text), err
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return "", err
+		}
+		mediatype, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediatype == "" || mediatype == "text/plain" || strings.HasPrefix(mediatype, "multipart/") {
+			return mailText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+		}
+	}
+}
+
+// a mail turned into an article
+type importedMail struct {
+	msgid string
+	// message-ids it replies to, the root of its thread first
+	parents []string
+	nntp    NNTPMessage
+	posted  time.Time
+}
+
+// turn a mail into an article for group, keeping its message-id if we can use it
+func importMail(raw []byte, group, instance string) (m importedMail, err error) {
+	var msg *mail.Message
+	msg, err = mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return
+	}
+	m.msgid = strings.TrimSpace(msg.Header.Get("Message-Id"))
+	if !ValidMessageID(m.msgid) {
+		// the same mail gets the same one, so importing twice doesn't make copies
+		m.msgid = fmt.Sprintf("<%x@%s>", sha1.Sum(raw), instance)
+	}
+	m.parents = mailMessageIDs(msg.Header.Get("References"))
+	m.parents = append(m.parents, mailMessageIDs(msg.Header.Get("In-Reply-To"))...)
+	m.posted, err = msg.Header.Date()
+	if err != nil {
+		m.posted = time.Now()
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	name, email := "Anonymous", "poster@"+instance
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		email = from.Address
+		if from.Name != "" {
+			name = from.Name
+		}
+	}
+	var text string
+	text, err = mailText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return
+	}
+	m.nntp = newPlaintextArticle(text, email, subject, name, instance, m.msgid, group)
+	m.nntp.Headers().Set("Date", m.posted.UTC().Format(time.RFC1123Z))
+	return
+}
+
+// import an mbox file or maildir into a newsgroup
+// threads follow the References of the mails, replies to mails we don't have start threads
+func ImportMboxTool(path, group string) {
+	if !newsgroupValidFormat(group) {
+		log.Println("invalid newsgroup", group)
+		return
+	}
+	conf := ReadConfig()
+	instance := conf.daemon["instance_name"]
+	var raw [][]byte
+	st, err := os.Stat(path)
+	if err == nil && st.IsDir() {
+		raw, err = readMaildir(path)
+	} else if err == nil {
+		var f *os.File
+		f, err = os.Open(path)
+		if err == nil {
+			raw, err = splitMbox(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		log.Println("failed to read", path, err)
+		return
+	}
+	var mails []importedMail
+	for idx, data := range raw {
+		m, err := importMail(data, group, instance)
+		if err != nil {
+			log.Println("skipping message", idx+1, "of", path, err)
+			continue
+		}
+		mails = append(mails, m)
+	}
+	// parents before replies
+	sort.SliceStable(mails, func(i, j int) bool {
+		return mails[i].posted.Before(mails[j].posted)
+	})
+	db := toolDatabase(conf)
+	defer db.Close()
+	db.CreateTables()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	// message-id -> root of its thread
+	roots := make(map[string]string)
+	var imported, skipped int
+	for _, m := range mails {
+		root := ""
+		for _, parent := range m.parents {
+			if r, ok := roots[parent]; ok {
+				root = r
+				break
+			}
+			// imported before
+			if r, g, _, err := db.GetInfoForMessage(parent); err == nil && g == group && r != "" {
+				root = r
+				break
+			}
+		}
+		if root == "" {
+			roots[m.msgid] = m.msgid
+		} else {
+			roots[m.msgid] = root
+			m.nntp.Headers().Set("References", root)
+		}
+		if db.HasArticle(m.msgid) || store.HasArticle(m.msgid) {
+			skipped++
+			continue
+		}
+		f := store.CreateFile(m.msgid)
+		if f == nil {
+			skipped++
+			continue
+		}
+		err = m.nntp.WriteTo(f)
+		f.Close()
+		if err == nil {
+			err = db.RegisterArticle(m.nntp)
+		}
+		if err != nil {
+			log.Println("failed to import", m.msgid, err)
+			DelFile(store.GetFilename(m.msgid))
+			skipped++
+			continue
+		}
+		imported++
+	}
+	log.Printf("imported %d of %d messages into %s, %d skipped", imported, len(raw), group, skipped)
+}
+
+// write a message to an mbox, quoting lines that would start a new one
+func writeMboxMessage(w io.Writer, sender string, date time.Time, msg io.Reader) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "From %s %s\n", sender, date.UTC().Format(time.ANSIC))
+	br := bufio.NewReader(msg)
+	end := "\n"
+	for {
+		var line string
+		line, err = br.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(line, "\r\n")
+			line = strings.TrimSuffix(line, "\n")
+			if strings.HasPrefix(line, "From ") || exp_mbox_quoted_from.MatchString(line) {
+				bw.WriteString(">")
+			}
+			bw.WriteString(line)
+			bw.WriteString("\n")
+			end = line
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		return
+	}
+	// messages end with an empty line
+	if end != "" {
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// write the articles of a newsgroup, or of one thread in it, to w as an mbox
+func ExportMboxTool(w io.Writer, group, thread string) {
+	conf := ReadConfig()
+	db := toolDatabase(conf)
+	defer db.Close()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	var msgids []string
+	if thread == "" {
+		chnl := make(chan ArticleEntry)
+		go func() {
+			db.GetAllArticlesInGroup(group, chnl)
+			close(chnl)
+		}()
+		for e := range chnl {
+			msgids = append(msgids, e.MessageID())
+		}
+	} else if ValidMessageID(thread) && db.HasArticle(thread) {
+		msgids = append([]string{thread}, db.GetThreadReplies(thread, 0, 0)...)
+	} else {
+		log.Println("no such thread", thread)
+		return
+	}
+	var exported int
+	for _, msgid := range msgids {
+		hdr := store.GetHeaders(msgid)
+		if hdr == nil {
+			continue
+		}
+		if thread != "" && hdr.Get("Newsgroups", "") != group {
+			continue
+		}
+		sender := "nntpchan@" + conf.daemon["instance_name"]
+		if from, err := mail.ParseAddress(hdr.Get("From", "")); err == nil && from.Address != "" {
+			sender = from.Address
+		}
+		date, err := mail.ParseDate(hdr.Get("Date", ""))
+		if err != nil {
+			date = time.Unix(0, 0)
+		}
+		r, err := store.OpenMessage(msgid)
+		if err == nil {
+			err = writeMboxMessage(w, sender, date, r)
+			r.Close()
+		}
+		if err != nil {
+			log.Println("failed to export", msgid, err)
+			continue
+		}
+		exported++
+	}
+	log.Println("exported", exported, "articles from", group)
+}
@@ -0,0 +1,39 @@
+package srnd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMbox(t *testing.T) {
+
+	mbox := "From a@test Mon Jan  1 00:00:00 2024\nSubject: one\n\n>From the start\n\nFrom b@test Mon Jan  1 00:00:01 2024\nSubject: two\n\n>>From quoted\n"
+	msgs, err := splitMbox(strings.NewReader(mbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatal("expected 2 messages got", len(msgs))
+	}
+	if string(msgs[0]) != "Subject: one\n\nFrom the start\n\n" || string(msgs[1]) != "Subject: two\n\n>From quoted\n" {
+		t.Errorf("bad split %q %q", msgs[0], msgs[1])
+	}
+
+}
+
+func TestWriteMboxMessage(t *testing.T) {
+
+	var buff strings.Builder
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeMboxMessage(&buff, "a@test", date, strings.NewReader("Subject: one\r\n\r\nFrom here\r\n>From there"))
+	writeMboxMessage(&buff, "b@test", date, strings.NewReader("Subject: two\n\nbye\n"))
+	msgs, err := splitMbox(strings.NewReader(buff.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || string(msgs[0]) != "Subject: one\n\nFrom here\n>From there\n\n" || string(msgs[1]) != "Subject: two\n\nbye\n\n" {
+		t.Errorf("bad mbox %q", buff.String())
+	}
+
+}
@@ -5,6 +5,7 @@ package srnd
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha512"
 	"errors"
 	"fmt"
@@ -13,10 +14,16 @@ import (
 	"log"
 	"mime"
 	"mime/multipart"
+	"net/textproto"
 	"strings"
 	"time"
 )
 
+// most bytes of a signed article we hold in memory to check its signature
+const maxSignedArticle = 128 * 1024 * 1024
+
+var SignedArticleTooBig = errors.New("signed article is too big")
+
 type ArticleHeaders map[string][]string
 
 func (self ArticleHeaders) Has(key string) bool {
@@ -419,39 +426,49 @@ func (self *nntpArticle) WriteBody(wr io.Writer) (err error) {
 }
 
 // verify a signed message's body
-// innerHandler must close reader when done
-// returns error if one happens while verifying article
-func verifyMessage(pk, sig string, body io.Reader, innerHandler func(map[string][]string, io.Reader)) (err error) {
+// returns the inner message's header and body if the signature is valid
+func verifyMessage(pk, sig string, body io.Reader) (hdr textproto.MIMEHeader, inner io.Reader, err error) {
 	log.Println("unwrapping signed message from", pk)
 	pk_bytes := unhex(pk)
 	sig_bytes := unhex(sig)
+	if len(pk_bytes) != 32 || len(sig_bytes) != 64 {
+		err = errors.New("invalid pubkey or signature")
+		return
+	}
 	h := sha512.New()
-	pr, pw := io.Pipe()
-	// read header
-	// handle inner body
-	go func(hdr_reader *io.PipeReader) {
-		r := bufio.NewReader(hdr_reader)
-		hdr, err := readMIMEHeader(r)
-		if err == nil {
-			innerHandler(hdr, r)
-		}
-		hdr_reader.Close()
-	}(pr)
-	body = io.TeeReader(body, pw)
-	// copy body 128 bytes at a time
-	var buff [128]byte
-	_, err = io.CopyBuffer(h, body, buff[:])
+	buff := new(bytes.Buffer)
+	// nothing in the inner message is looked at before we know who signed it
+	n, err := io.Copy(io.MultiWriter(h, buff), io.LimitReader(body, maxSignedArticle+1))
+	if err != nil {
+		return
+	}
+	if n > maxSignedArticle {
+		err = SignedArticleTooBig
+		return
+	}
+	hash := h.Sum(nil)
+	log.Printf("hash=%s", hexify(hash))
+	log.Printf("sig=%s", hexify(sig_bytes))
+	if !nacl.CryptoVerifyFucky(hash, sig_bytes, pk_bytes) {
+		err = errors.New("invalid signature")
+		return
+	}
+	log.Println("signature is valid :^)")
+	r := bufio.NewReader(buff)
+	hdr, err = readMIMEHeader(r)
 	if err == nil {
-		hash := h.Sum(nil)
-		log.Printf("hash=%s", hexify(hash))
-		log.Printf("sig=%s", hexify(sig_bytes))
-		if nacl.CryptoVerifyFucky(hash, sig_bytes, pk_bytes) {
-			log.Println("signature is valid :^)")
-		} else {
-			err = errors.New("invalid signature")
-		}
+		inner = r
+	}
+	return
+}
+
+// check that the inner message of a signed article is the article we got
+// so a signed message can't be passed off under another message-id or newsgroup
+func checkSignedInner(outer NNTPMessage, inner textproto.MIMEHeader) (err error) {
+	if getMessageID(inner) != outer.MessageID() {
+		err = fmt.Errorf("inner message-id %s does not match %s", getMessageID(inner), outer.MessageID())
+	} else if inner.Get("Newsgroups") != outer.Newsgroup() {
+		err = fmt.Errorf("inner newsgroup %s does not match %s", inner.Get("Newsgroups"), outer.Newsgroup())
 	}
-	// flush pipe
-	pw.Close()
 	return
 }
@@ -6,14 +6,17 @@ package srnd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 )
 
+// a mod key that was rotated out can't be given privileges again
+var ModKeyExpired = errors.New("mod key was rotated out")
+
 // regenerate pages function
 type RegenFunc func(newsgroup, msgid, root string, page int)
 
@@ -31,16 +34,73 @@ type ModUI interface {
 	// return true if it can otherwise false
 	CheckKey(privkey, scope string) (bool, error)
 
+	// check if the request has a mod session allowed to access scope
+	CheckSession(r *http.Request, scope string) bool
+
 	// serve the base page
 	ServeModPage(wr http.ResponseWriter, r *http.Request)
+	// serve the recent posts page
+	ServeModPosts(wr http.ResponseWriter, r *http.Request)
+	// serve the open reports page
+	ServeModReports(wr http.ResponseWriter, r *http.Request)
+	// serve the bans page
+	ServeModBans(wr http.ResponseWriter, r *http.Request)
+	// serve the pending ban appeals
+	ServeModAppeals(wr http.ResponseWriter, r *http.Request)
+	// lift a ban someone appealed
+	HandleLiftAppeal(wr http.ResponseWriter, r *http.Request)
+	// keep a ban someone appealed
+	HandleUpholdAppeal(wr http.ResponseWriter, r *http.Request)
+	// serve the posts held by the spam filter
+	ServeModQuarantine(wr http.ResponseWriter, r *http.Request)
+	// let a quarantined post through
+	HandleApproveQuarantined(wr http.ResponseWriter, r *http.Request)
+	// throw away a quarantined post
+	HandleRejectQuarantined(wr http.ResponseWriter, r *http.Request)
+	// approve or reject every quarantined post at once
+	HandleBulkQuarantined(wr http.ResponseWriter, r *http.Request)
+	// serve articles we rejected and kept for the mods
+	ServeModRejected(wr http.ResponseWriter, r *http.Request)
+	// show a rejected article as it came in
+	HandleViewRejected(wr http.ResponseWriter, r *http.Request)
+	// release or purge a rejected article
+	HandleRejected(wr http.ResponseWriter, r *http.Request)
+	// release or purge every rejected article at once
+	HandleBulkRejected(wr http.ResponseWriter, r *http.Request)
+	// serve the settings page of a board
+	ServeModBoard(wr http.ResponseWriter, r *http.Request)
+	// change the settings of a board
+	HandleBoardSettings(wr http.ResponseWriter, r *http.Request)
+	// serve the banners of a board
+	ServeModBanners(wr http.ResponseWriter, r *http.Request)
+	// add a banner to a board
+	HandleBannerUpload(wr http.ResponseWriter, r *http.Request)
+	// remove a banner from a board
+	HandleBannerDelete(wr http.ResponseWriter, r *http.Request)
+	// serve the node stats page
+	ServeModStats(wr http.ResponseWriter, r *http.Request)
+	// hand out a challenge to sign for pubkey login
+	HandleChallenge(wr http.ResponseWriter, r *http.Request)
 	// handle a login POST request
 	HandleLogin(wr http.ResponseWriter, r *http.Request)
+	// handle a logout POST request
+	HandleLogout(wr http.ResponseWriter, r *http.Request)
 	// handle a delete article request
 	HandleDeletePost(wr http.ResponseWriter, r *http.Request)
+	// handle a sticky, lock or cycle thread request
+	HandleThreadFlag(wr http.ResponseWriter, r *http.Request)
+	// handle a purge of everything a poster posted
+	HandlePurgePoster(wr http.ResponseWriter, r *http.Request)
+	// handle a signed grant or revoke of a mod permission
+	HandleModGrant(wr http.ResponseWriter, r *http.Request)
+	// handle a dismiss reports request
+	HandleDismissReports(wr http.ResponseWriter, r *http.Request)
 	// handle a ban address request
 	HandleBanAddress(wr http.ResponseWriter, r *http.Request)
 	// handle an unban address request
 	HandleUnbanAddress(wr http.ResponseWriter, r *http.Request)
+	// handle a shadow ban address request
+	HandleShadowBanAddress(wr http.ResponseWriter, r *http.Request)
 	// handle add a pubkey
 	HandleAddPubkey(wr http.ResponseWriter, r *http.Request)
 	// handle removing a pubkey
@@ -77,11 +137,20 @@ func (self simpleModEvent) Action() string {
 }
 
 func (self simpleModEvent) Reason() string {
+	// everything after the target
+	parts := strings.SplitN(string(self), " ", 3)
+	if len(parts) == 3 {
+		return parts[2]
+	}
 	return ""
 }
 
 func (self simpleModEvent) Target() string {
-	return strings.Split(string(self), " ")[1]
+	parts := strings.Split(string(self), " ")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
 }
 
 func (self simpleModEvent) Scope() string {
@@ -94,16 +163,235 @@ func (self simpleModEvent) Expires() int64 {
 	return -1
 }
 
+// fine grained mod permissions a pubkey can be granted per newsgroup
+const (
+	ModPermDeletePost   = "delete-post"
+	ModPermDeleteThread = "delete-thread"
+	ModPermBanIP        = "ban-ip"
+	ModPermBanFile      = "ban-file"
+	ModPermSticky       = "sticky"
+	ModPermLock         = "lock"
+	ModPermPurge        = "purge"
+	ModPermCycle        = "cycle"
+	// can grant and revoke the default mod permissions on the newsgroup, only admins grant it
+	ModPermOwner = "owner"
+)
+
+// the default mod permissions, board owners can hand these out
+var ModPermissions = []string{ModPermDeletePost, ModPermDeleteThread, ModPermBanIP, ModPermBanFile, ModPermSticky, ModPermLock, ModPermPurge, ModPermCycle}
+
+// return true if perm is a mod permission we know about
+func validModPermission(perm string) bool {
+	if perm == ModPermOwner {
+		return true
+	}
+	for _, p := range ModPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// flags mods can set on a thread, also the ctl command that sets them
+const (
+	// shown before every other thread on the board and never expires
+	ThreadSticky = "sticky"
+	// takes no more replies
+	ThreadLocked = "lock"
+	// drops its oldest replies instead of growing past the reply limit
+	ThreadCycle = "cycle"
+)
+
+// all thread flags we know about
+var ThreadFlags = []string{ThreadSticky, ThreadLocked, ThreadCycle}
+
+// get the mod permission needed to set a thread flag
+func threadFlagPermission(flag string) string {
+	switch flag {
+	case ThreadSticky:
+		return ModPermSticky
+	case ThreadLocked:
+		return ModPermLock
+	case ThreadCycle:
+		return ModPermCycle
+	}
+	return ""
+}
+
+// what we do with deletes of posts that come from other nodes
+const (
+	// delete the post if the pubkey may delete it
+	RemoteDeleteHonor = "honor"
+	// put the post into the report queue for local mods to decide
+	RemoteDeleteQueue = "queue"
+	// leave the post alone
+	RemoteDeleteIgnore = "ignore"
+)
+
+// return true if policy is a remote delete policy we know about
+func validRemoteDeletePolicy(policy string) bool {
+	return policy == RemoteDeleteHonor || policy == RemoteDeleteQueue || policy == RemoteDeleteIgnore
+}
+
+// kinds of bans a ban list can carry
+const (
+	// hex of an attachment's sha512
+	BanlistFile = "file"
+	// a poster's signing pubkey
+	BanlistPubkey = "pubkey"
+	// an encrypted ip address
+	BanlistEncAddr = "encaddr"
+)
+
+// return true if this is a well formed ban list entry
+func validBanlistEntry(kind, value string) bool {
+	switch kind {
+	case BanlistFile:
+		return len(value) == 128 && len(unhex(value)) == 64
+	case BanlistPubkey:
+		return len(value) == 64 && len(unhex(value)) == 32
+	case BanlistEncAddr:
+		return len(value) > 0 && len(value) <= 255 && !strings.ContainsAny(value, " :\t")
+	}
+	return false
+}
+
+// check if a ban of this kind is in place
+func banInPlace(db Database, kind, value string) (banned bool, err error) {
+	switch kind {
+	case BanlistFile:
+		banned, err = db.AttachmentBanned(value)
+	case BanlistPubkey:
+		banned, err = db.PubkeyIsBanned(value)
+	case BanlistEncAddr:
+		banned, err = db.CheckEncIPBanned(value)
+	}
+	return
+}
+
+// put a ban of this kind in place
+func applyBan(db Database, kind, value string) error {
+	switch kind {
+	case BanlistFile:
+		return db.BanAttachment(value)
+	case BanlistPubkey:
+		return db.BanPubkey(value)
+	case BanlistEncAddr:
+		return db.BanEncAddr(value)
+	}
+	return nil
+}
+
+// lift a ban of this kind
+func liftBan(db Database, kind, value string) error {
+	switch kind {
+	case BanlistFile:
+		return db.UnbanAttachment(value)
+	case BanlistPubkey:
+		return db.UnbanPubkey(value)
+	case BanlistEncAddr:
+		return db.UnbanEncAddr(value)
+	}
+	return nil
+}
+
+// create an overchan-banlist mod event, adds a ban to our ban list
+func overchanBanlist(kind, value string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-banlist %s:%s", kind, value))
+}
+
+// create an overchan-banlist-remove mod event, removes a ban from our ban list
+func overchanBanlistRemove(kind, value string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-banlist-remove %s:%s", kind, value))
+}
+
+// create a ban-file mod event, bans the attachment with this sha512
+func banFile(hash string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("ban-file %s", hash))
+}
+
 // create an overchan-delete mod event
 func overchanDelete(msgid string) ModEvent {
 	return simpleModEvent(fmt.Sprintf("delete %s", msgid))
 }
 
+// create a sticky, lock or cycle mod event, clears the flag if on is false
+func overchanThreadFlag(flag, msgid string, on bool) ModEvent {
+	if on {
+		return simpleModEvent(fmt.Sprintf("%s %s", flag, msgid))
+	}
+	return simpleModEvent(fmt.Sprintf("%s %s off", flag, msgid))
+}
+
 // create an overchan-inet-ban mod event
 func overchanInetBan(encAddr, key string, expire int64) ModEvent {
 	return simpleModEvent(fmt.Sprintf("overchan-inet-ban %s:%s:%d", encAddr, key, expire))
 }
 
+// longest report reason we keep
+const maxReportReason = 256
+
+// create an overchan-report mod event
+func overchanReport(msgid, reason string) ModEvent {
+	// reason must fit on the mod line
+	reason = strings.Join(strings.Fields(reason), " ")
+	if len(reason) > maxReportReason {
+		reason = reason[:maxReportReason]
+	}
+	return simpleModEvent(fmt.Sprintf("overchan-report %s %s", msgid, reason))
+}
+
+// create an overchan-report-dismiss mod event
+func overchanReportDismiss(msgid string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-report-dismiss %s", msgid))
+}
+
+// create an overchan-mod-grant mod event
+func overchanModGrant(pubkey, newsgroup, perm string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-mod-grant %s:%s:%s", pubkey, newsgroup, perm))
+}
+
+// parse the pubkey:newsgroup:permission target of a grant or revoke
+func parseModGrantTarget(target string) (pubkey, newsgroup, perm string, ok bool) {
+	parts := strings.Split(target, ":")
+	ok = len(parts) == 3 && len(parts[0]) == 64 && newsgroupValidFormat(parts[1]) && validModPermission(parts[2])
+	if ok {
+		pubkey, newsgroup, perm = parts[0], parts[1], parts[2]
+	}
+	return
+}
+
+// create an overchan-mod-revoke mod event
+func overchanModRevoke(pubkey, newsgroup, perm string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-mod-revoke %s:%s:%s", pubkey, newsgroup, perm))
+}
+
+// create an overchan-purge mod event
+// deletes everything a poster of this ban list kind ever posted and bans them
+func overchanPurge(kind, value string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-purge %s:%s", kind, value))
+}
+
+// return true if we can purge a poster of this kind
+func validPurgeTarget(kind, value string) bool {
+	return (kind == BanlistPubkey || kind == BanlistEncAddr) && validBanlistEntry(kind, value)
+}
+
+// get the message-ids of everything a poster of this kind posted
+func getPosterMessageIDs(db Database, kind, value string) ([]string, error) {
+	if kind == BanlistPubkey {
+		return db.GetMessageIDByPubkey(value)
+	}
+	return db.GetMessageIDByEncryptedIP(value)
+}
+
+// create an overchan-mod-rotate mod event
+// must be signed by the key being rotated out
+func overchanModRotate(newkey string) ModEvent {
+	return simpleModEvent(fmt.Sprintf("overchan-mod-rotate %s", newkey))
+}
+
 // moderation message
 // wraps multiple mod events
 // is turned into an NNTPMessage later
@@ -153,12 +441,57 @@ type ModEngine interface {
 	MessageChan() chan string
 	// delete post of a poster
 	DeletePost(msgid string, regen RegenFunc) error
+	// delete a post its poster took back, without banning it
+	SelfDeletePost(msgid string, regen RegenFunc) error
 	// ban a cidr
 	BanAddress(cidr string) error
+	// ban an attachment by its hex sha512 and purge any copies we have
+	BanFile(hash string) error
 	// do we allow this public key to delete this message-id ?
 	AllowDelete(pubkey, msgid string) bool
 	// do we allow this public key to do inet-ban?
 	AllowBan(pubkey string) bool
+	// do we allow this public key to purge a poster from every newsgroup?
+	AllowPurge(pubkey string) bool
+	// ban a poster by pubkey or encrypted address and delete everything they posted
+	// returns the message-ids we deleted
+	PurgePoster(kind, value string, regen RegenFunc) ([]string, error)
+	// do we allow this public key to grant and revoke a mod permission on a newsgroup?
+	AllowGrant(pubkey, newsgroup, perm string) bool
+	// grant a mod permission on a newsgroup to a public key, remembering who granted it
+	GrantPermission(granter, pubkey, newsgroup, perm string) error
+	// revoke a mod permission on a newsgroup from a public key
+	RevokePermission(pubkey, newsgroup, perm string) error
+	// do we allow this public key to hand its privileges to a new key?
+	AllowRotate(pubkey string) bool
+	// move all privileges of oldkey to newkey and expire oldkey
+	RotateKey(oldkey, newkey string) error
+	// was this public key rotated out?
+	KeyExpired(pubkey string) bool
+	// do we merge the ban list signed by this public key?
+	BanlistSubscribed(pubkey string) bool
+	// merge a ban from the ban list of source
+	MergeBanlistEntry(source, kind, value string) error
+	// drop a ban we merged from the ban list of source, lifting it if no other list has it
+	DropBanlistEntry(source, kind, value string) error
+	// drop every ban we merged from the ban list of source and unsubscribe
+	RollbackBanlist(source string) error
+	// put a report about a post into the mod queue
+	Report(msgid, reason string) error
+	// remove all reports about a post from the mod queue
+	DismissReports(msgid string) error
+	// get the remote delete policy for deletes signed by pubkey in the ctl message ctl_msgid
+	// local is true if we made the ctl message ourselves
+	DeletePolicy(pubkey, ctl_msgid string) (policy string, local bool)
+	// teach the spam filter that a post our mods deleted was spam
+	LearnSpam(msgid string)
+	// put a delete we did not honor into the mod queue for review
+	QueueDelete(msgid, pubkey string) error
+	// do we allow this public key to set a flag on the thread of this message-id?
+	AllowThreadFlag(pubkey, msgid, flag string) bool
+	// set or clear a flag on the thread of this message-id
+	// returns the thread's root post and newsgroup
+	SetThreadFlag(msgid, flag string, on bool) (string, string, error)
 	// load a mod message
 	LoadMessage(msgid string) NNTPMessage
 }
@@ -167,6 +500,14 @@ type modEngine struct {
 	database Database
 	store    ArticleStore
 	chnl     chan string
+	// gets the remote delete policy, honor everything if nil
+	deletePolicy func(pubkey, ctl_msgid string) (string, bool)
+	// learns from deletes and dismissed reports, nil if disabled
+	spam *spamFilter
+	// tells mods about new reports, nil to tell nobody
+	notify *modNotifier
+	// called for every post we delete, nil to tell nobody
+	deleted func(msgid, root, group string)
 }
 
 func (self modEngine) LoadMessage(msgid string) NNTPMessage {
@@ -181,7 +522,25 @@ func (self modEngine) BanAddress(cidr string) (err error) {
 	return self.database.BanAddr(cidr)
 }
 
-func (self modEngine) DeletePost(msgid string, regen RegenFunc) (err error) {
+func (self modEngine) BanFile(hash string) (err error) {
+	err = self.database.BanAttachment(hash)
+	if err == nil {
+		err = self.store.PurgeAttachment(unhex(hash))
+	}
+	return
+}
+
+func (self modEngine) DeletePost(msgid string, regen RegenFunc) error {
+	return self.removePost(msgid, regen, true)
+}
+
+// the poster took it back, it is not spam so we neither ban nor learn from it
+func (self modEngine) SelfDeletePost(msgid string, regen RegenFunc) error {
+	return self.removePost(msgid, regen, false)
+}
+
+// remove a post and its replies if it is a root post with all their files, ban them if ban is set
+func (self modEngine) removePost(msgid string, regen RegenFunc, ban bool) (err error) {
 	hdr, err := self.database.GetHeadersForMessage(msgid)
 	var delposts []string
 	var page int64
@@ -210,25 +569,9 @@ func (self modEngine) DeletePost(msgid string, regen RegenFunc) (err error) {
 		}
 	}
 	delposts = append(delposts, msgid)
-	// get list of files to delete
-	var delfiles []string
+	// trash or delete all files
 	for _, delmsg := range delposts {
-		article := self.store.GetFilename(delmsg)
-		delfiles = append(delfiles, article)
-		// get attachments for post
-		atts := self.database.GetPostAttachments(delmsg)
-		if atts != nil {
-			for _, att := range atts {
-				img := self.store.AttachmentFilepath(att)
-				thm := self.store.ThumbnailFilepath(att)
-				delfiles = append(delfiles, img, thm)
-			}
-		}
-	}
-	// delete all files
-	for _, f := range delfiles {
-		log.Printf("delete file: %s", f)
-		os.Remove(f)
+		discardArticle(self.database, self.store, delmsg, group)
 	}
 
 	if rootmsgid != "" {
@@ -241,8 +584,12 @@ func (self modEngine) DeletePost(msgid string, regen RegenFunc) (err error) {
 		if err != nil {
 			log.Println(err)
 		}
-		// ban article
-		self.database.BanArticle(delmsg, "deleted by moderator")
+		if ban {
+			self.database.BanArticle(delmsg, "deleted by moderator")
+		}
+		if self.deleted != nil {
+			self.deleted(delmsg, ref, group)
+		}
 	}
 	regen(group, msgid, ref, int(page))
 	return nil
@@ -254,7 +601,197 @@ func (self modEngine) AllowBan(pubkey string) bool {
 		// admins can do whatever
 		return true
 	}
-	return self.database.CheckModPubkeyGlobal(pubkey)
+	// inet bans are not scoped so they need to be granted on overchan
+	return self.database.CheckModPubkeyPermission(pubkey, "overchan", ModPermBanIP)
+}
+
+func (self modEngine) AllowPurge(pubkey string) bool {
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	if is_admin || self.database.CheckModPubkeyGlobal(pubkey) {
+		return true
+	}
+	// purges are not scoped so they need to be granted on overchan
+	return self.database.CheckModPubkeyPermission(pubkey, "overchan", ModPermPurge)
+}
+
+func (self modEngine) PurgePoster(kind, value string, regen RegenFunc) (deleted []string, err error) {
+	// ban first so nothing new gets in while we delete
+	err = applyBan(self.database, kind, value)
+	if err != nil {
+		return
+	}
+	var msgids []string
+	msgids, err = getPosterMessageIDs(self.database, kind, value)
+	for _, msgid := range msgids {
+		if !self.database.HasArticleLocal(msgid) {
+			// went with a thread we already deleted
+			continue
+		}
+		err = self.DeletePost(msgid, regen)
+		if err != nil {
+			return
+		}
+		deleted = append(deleted, msgid)
+	}
+	return
+}
+
+func (self modEngine) AllowGrant(pubkey, newsgroup, perm string) bool {
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	if is_admin {
+		return true
+	}
+	// owners hand out the default permissions on their own board and nothing else
+	if perm == ModPermOwner || newsgroup == "overchan" || self.database.ModPubkeyExpired(pubkey) {
+		return false
+	}
+	return self.OwnsGroup(pubkey, newsgroup)
+}
+
+// return true if pubkey was made owner of newsgroup
+// unlike other permissions this is never implied by global or full board mod rights
+func (self modEngine) OwnsGroup(pubkey, newsgroup string) bool {
+	perms, _ := self.database.GetModPubkeyPermissions(pubkey, newsgroup)
+	for _, perm := range perms {
+		if perm == ModPermOwner {
+			return true
+		}
+	}
+	return false
+}
+
+func (self modEngine) GrantPermission(granter, pubkey, newsgroup, perm string) (err error) {
+	if self.database.ModPubkeyExpired(pubkey) {
+		return ModKeyExpired
+	}
+	err = self.database.GrantModPubkeyPermission(pubkey, newsgroup, perm)
+	if err == nil {
+		err = self.database.RecordModGrant(granter, pubkey, newsgroup, perm)
+	}
+	return
+}
+
+func (self modEngine) AllowRotate(pubkey string) bool {
+	if self.database.ModPubkeyExpired(pubkey) {
+		return false
+	}
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	return is_admin || self.database.CheckModPubkeyGlobal(pubkey) || self.database.CheckModPubkey(pubkey)
+}
+
+func (self modEngine) RotateKey(oldkey, newkey string) error {
+	if self.database.ModPubkeyExpired(newkey) {
+		return ModKeyExpired
+	}
+	return self.database.RotateModPubkey(oldkey, newkey)
+}
+
+func (self modEngine) KeyExpired(pubkey string) bool {
+	return self.database.ModPubkeyExpired(pubkey)
+}
+
+func (self modEngine) BanlistSubscribed(pubkey string) bool {
+	return self.database.BanlistSubscribed(pubkey)
+}
+
+func (self modEngine) MergeBanlistEntry(source, kind, value string) (err error) {
+	var banned bool
+	banned, err = banInPlace(self.database, kind, value)
+	if err != nil {
+		return
+	}
+	// a ban another ban list brought in counts as ours too
+	applied := !banned || self.database.BanlistEntryListed(kind, value)
+	if !banned {
+		err = applyBan(self.database, kind, value)
+	}
+	if err == nil {
+		err = self.database.AddBanlistEntry(source, kind, value, applied)
+	}
+	return
+}
+
+func (self modEngine) dropBanlistEntry(e BanlistEntry) (err error) {
+	err = self.database.RemoveBanlistEntry(e.Source, e.Kind, e.Value)
+	if err == nil && e.Applied && !self.database.BanlistEntryListed(e.Kind, e.Value) {
+		// no ban list has it any more and we didn't have it before
+		err = liftBan(self.database, e.Kind, e.Value)
+	}
+	return
+}
+
+func (self modEngine) DropBanlistEntry(source, kind, value string) (err error) {
+	var entries []BanlistEntry
+	entries, err = self.database.GetBanlistEntries(source)
+	for _, e := range entries {
+		if err == nil && e.Kind == kind && e.Value == value {
+			err = self.dropBanlistEntry(e)
+		}
+	}
+	return
+}
+
+func (self modEngine) RollbackBanlist(source string) (err error) {
+	err = self.database.UnsubscribeBanlist(source)
+	if err != nil {
+		return
+	}
+	var entries []BanlistEntry
+	entries, err = self.database.GetBanlistEntries(source)
+	for _, e := range entries {
+		if err == nil {
+			err = self.dropBanlistEntry(e)
+		}
+	}
+	return
+}
+
+func (self modEngine) RevokePermission(pubkey, newsgroup, perm string) (err error) {
+	err = self.database.RevokeModPubkeyPermission(pubkey, newsgroup, perm)
+	if err == nil {
+		err = self.database.ForgetModGrant(pubkey, newsgroup, perm)
+	}
+	return
+}
+
+func (self modEngine) Report(msgid, reason string) (err error) {
+	if len(reason) > maxReportReason {
+		reason = reason[:maxReportReason]
+	}
+	var group string
+	_, group, _, err = self.database.GetInfoForMessage(msgid)
+	if err == nil {
+		err = self.database.AddReport(msgid, group, reason)
+	}
+	if err == nil {
+		self.notify.Notify(ModNotification{Event: NotifyReport, Newsgroup: group, MessageID: msgid, Reason: reason})
+	}
+	return
+}
+
+func (self modEngine) DismissReports(msgid string) error {
+	if self.spam != nil {
+		// the mods looked at it and kept it
+		self.spam.Learn(self.store.GetMessage(msgid), false)
+	}
+	return self.database.DismissReports(msgid)
+}
+
+func (self modEngine) DeletePolicy(pubkey, ctl_msgid string) (string, bool) {
+	if self.deletePolicy == nil {
+		return RemoteDeleteHonor, true
+	}
+	return self.deletePolicy(pubkey, ctl_msgid)
+}
+
+func (self modEngine) LearnSpam(msgid string) {
+	if self.spam != nil {
+		self.spam.Learn(self.store.GetMessage(msgid), true)
+	}
+}
+
+func (self modEngine) QueueDelete(msgid, pubkey string) error {
+	return self.Report(msgid, "remote delete from "+pubkey)
 }
 
 func (self modEngine) AllowDelete(pubkey, msgid string) (allow bool) {
@@ -268,15 +805,44 @@ func (self modEngine) AllowDelete(pubkey, msgid string) (allow bool) {
 		return true
 	}
 	// check for scoped permissions
-	_, group, _, err := self.database.GetInfoForMessage(msgid)
+	root, group, _, err := self.database.GetInfoForMessage(msgid)
 	if err == nil && newsgroupValidFormat(group) {
-		allow = self.database.CheckModPubkeyCanModGroup(pubkey, group)
+		perm := ModPermDeletePost
+		if root == "" || root == msgid {
+			// deleting a root post takes the whole thread with it
+			perm = ModPermDeleteThread
+		}
+		allow = self.database.CheckModPubkeyPermission(pubkey, group, perm)
 	} else if err != nil {
 		log.Println("db error in mod engine while checking permissions", err)
 	}
 	return
 }
 
+func (self modEngine) AllowThreadFlag(pubkey, msgid, flag string) bool {
+	is_admin, _ := self.database.CheckAdminPubkey(pubkey)
+	if is_admin || self.database.CheckModPubkeyGlobal(pubkey) {
+		return true
+	}
+	_, group, _, err := self.database.GetInfoForMessage(msgid)
+	if err != nil {
+		log.Println("db error in mod engine while checking permissions", err)
+		return false
+	}
+	return newsgroupValidFormat(group) && self.database.CheckModPubkeyPermission(pubkey, group, threadFlagPermission(flag))
+}
+
+func (self modEngine) SetThreadFlag(msgid, flag string, on bool) (root, group string, err error) {
+	root, group, _, err = self.database.GetInfoForMessage(msgid)
+	if err == nil {
+		if root == "" {
+			root = msgid
+		}
+		err = self.database.SetThreadFlag(root, group, flag, on)
+	}
+	return
+}
+
 // run a mod engine logic mainloop
 func RunModEngine(mod ModEngine, regen RegenFunc) {
 
@@ -285,80 +851,267 @@ func RunModEngine(mod ModEngine, regen RegenFunc) {
 		msgid := <-chnl
 		nntp := mod.LoadMessage(msgid)
 		if nntp == nil {
+			// also happens if the signature or inner message did not check out
 			log.Println("failed to load mod message", msgid)
 			continue
 		}
 		// sanity check
 		if nntp.Newsgroup() == "ctl" {
-			pubkey := nntp.Pubkey()
-			for _, line := range strings.Split(nntp.Message(), "\n") {
-				line = strings.Trim(line, "\r\t\n ")
-				ev := ParseModEvent(line)
-				action := ev.Action()
-				if action == "delete" {
-					msgid := ev.Target()
-					if !ValidMessageID(msgid) {
-						// invalid message-id
-						log.Println("invalid message-id for mod delete", msgid, "from", pubkey)
-						continue
-					}
-					// this is a delete action
-					if mod.AllowDelete(pubkey, msgid) {
-						err := mod.DeletePost(msgid, regen)
-						if err != nil {
-							log.Println(msgid, err)
-						}
-					} else {
-						log.Printf("pubkey=%s will not delete %s not trusted", pubkey, msgid)
-					}
-				} else if action == "overchan-inet-ban" {
-					// ban action
-					target := ev.Target()
-					if target[0] == '[' {
-						// probably a literal ipv6 rangeban
-						if mod.AllowBan(pubkey) {
-							err := mod.BanAddress(target)
-							if err != nil {
-								log.Println("failed to do literal ipv6 range ban on", target, err)
-							}
-						} else {
-							log.Println("ignoring literal ipv6 rangeban from", pubkey, "as they are not allowed to ban")
-						}
-						continue
-					}
-					parts := strings.Split(target, ":")
-					if len(parts) == 3 {
-						// encrypted ip
-						encaddr, key := parts[0], parts[1]
-						cidr := decAddr(encaddr, key)
-						if cidr == "" {
-							log.Println("failed to decrypt inet ban")
-						} else if mod.AllowBan(pubkey) {
-							err := mod.BanAddress(cidr)
-							if err != nil {
-								log.Println("failed to do range ban on", cidr, err)
-							}
-						} else {
-							log.Println("ingoring encrypted-ip inet ban from", pubkey, "as they are not allowed to ban")
-						}
-					} else if len(parts) == 1 {
-						// literal cidr
-						cidr := parts[0]
-						if mod.AllowBan(pubkey) {
-							err := mod.BanAddress(cidr)
-							if err != nil {
-								log.Println("failed to do literal range ban on", cidr, err)
-							}
-						} else {
-							log.Println("ingoring literal cidr range ban from", pubkey, "as they are not allowed to ban")
-						}
-					} else {
-						log.Printf("invalid overchan-inet-ban: target=%s", target)
-					}
-				} else {
-					log.Println("invalid mod action", action, "from", pubkey)
+			handleModMessage(mod, nntp, regen)
+		}
+	}
+}
+
+// parse the body of a ctl message into mod events
+// blank lines and lines starting with # are skipped
+func ParseModMessage(body string) (mm ModMessage) {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.Trim(line, "\r\t\n ")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		mm = append(mm, ParseModEvent(line))
+	}
+	return
+}
+
+// do every mod event in a ctl message, in order
+func handleModMessage(mod ModEngine, nntp NNTPMessage, regen RegenFunc) {
+	msgid := nntp.MessageID()
+	pubkey := nntp.Pubkey()
+	// before anything else so where it came from is forgotten
+	policy, local := mod.DeletePolicy(pubkey, msgid)
+	if mod.KeyExpired(pubkey) {
+		log.Println("ignoring mod message", msgid, "from expired key", pubkey)
+		return
+	}
+	for _, ev := range ParseModMessage(nntp.Message()) {
+		if !handleModEvent(mod, pubkey, policy, local, ev, regen) {
+			// nothing else in this message counts
+			break
+		}
+	}
+}
+
+// do one mod event signed by pubkey with remote delete policy for deletes
+// local is true for ctl messages we made, only their deletes teach the spam filter
+// returns false if the rest of the message is to be ignored
+func handleModEvent(mod ModEngine, pubkey, policy string, local bool, ev ModEvent, regen RegenFunc) bool {
+	action := ev.Action()
+	switch action {
+	case "delete":
+		msgid := ev.Target()
+		if !ValidMessageID(msgid) {
+			// invalid message-id
+			log.Println("invalid message-id for mod delete", msgid, "from", pubkey)
+			return true
+		}
+		// this is a delete action
+		if !mod.AllowDelete(pubkey, msgid) {
+			log.Printf("pubkey=%s will not delete %s not trusted", pubkey, msgid)
+		} else if policy == RemoteDeleteQueue {
+			err := mod.QueueDelete(msgid, pubkey)
+			if err != nil {
+				log.Println("failed to queue delete of", msgid, err)
+			}
+		} else if policy == RemoteDeleteIgnore {
+			log.Printf("pubkey=%s will not delete %s remote deletes ignored", pubkey, msgid)
+		} else {
+			if local {
+				// our mods didn't want it
+				mod.LearnSpam(msgid)
+			}
+			err := mod.DeletePost(msgid, regen)
+			if err != nil {
+				log.Println(msgid, err)
+			}
+		}
+	case "overchan-inet-ban":
+		// ban action
+		target := ev.Target()
+		if len(target) > 0 && target[0] == '[' {
+			// probably a literal ipv6 rangeban
+			if mod.AllowBan(pubkey) {
+				err := mod.BanAddress(target)
+				if err != nil {
+					log.Println("failed to do literal ipv6 range ban on", target, err)
+				}
+			} else {
+				log.Println("ignoring literal ipv6 rangeban from", pubkey, "as they are not allowed to ban")
+			}
+			return true
+		}
+		parts := strings.Split(target, ":")
+		if len(parts) == 3 {
+			// encrypted ip
+			encaddr, key := parts[0], parts[1]
+			cidr := decAddr(encaddr, key)
+			if cidr == "" {
+				log.Println("failed to decrypt inet ban")
+			} else if mod.AllowBan(pubkey) {
+				err := mod.BanAddress(cidr)
+				if err != nil {
+					log.Println("failed to do range ban on", cidr, err)
 				}
+			} else {
+				log.Println("ingoring encrypted-ip inet ban from", pubkey, "as they are not allowed to ban")
+			}
+		} else if len(parts) == 1 {
+			// literal cidr
+			cidr := parts[0]
+			if mod.AllowBan(pubkey) {
+				err := mod.BanAddress(cidr)
+				if err != nil {
+					log.Println("failed to do literal range ban on", cidr, err)
+				}
+			} else {
+				log.Println("ingoring literal cidr range ban from", pubkey, "as they are not allowed to ban")
+			}
+		} else {
+			log.Printf("invalid overchan-inet-ban: target=%s", target)
+		}
+	case "ban-file":
+		// attachment ban, target is the hex sha512
+		hash := strings.ToLower(ev.Target())
+		if !validBanlistEntry(BanlistFile, hash) {
+			log.Printf("invalid ban-file: target=%s", hash)
+			return true
+		}
+		if !mod.AllowBan(pubkey) {
+			log.Println("ignoring ban-file from", pubkey, "as they are not allowed to ban")
+			return true
+		}
+		err := mod.BanFile(hash)
+		if err != nil {
+			log.Println("failed to ban file", hash, err)
+		}
+	case "overchan-report":
+		// only reports from mods who could delete the post, unsigned ones would flood the queue
+		msgid := ev.Target()
+		if !ValidMessageID(msgid) {
+			log.Println("invalid message-id for report", msgid)
+			return true
+		}
+		if !mod.AllowDelete(pubkey, msgid) {
+			log.Printf("pubkey=%s will not report %s not trusted", pubkey, msgid)
+			return true
+		}
+		err := mod.Report(msgid, ev.Reason())
+		if err != nil {
+			log.Println("failed to record report on", msgid, err)
+		}
+	case "overchan-report-dismiss":
+		msgid := ev.Target()
+		if !ValidMessageID(msgid) {
+			log.Println("invalid message-id for report dismissal", msgid, "from", pubkey)
+			return true
+		}
+		// only those who could delete the post can dismiss reports on it
+		if mod.AllowDelete(pubkey, msgid) {
+			err := mod.DismissReports(msgid)
+			if err != nil {
+				log.Println("failed to dismiss reports on", msgid, err)
+			}
+		} else {
+			log.Printf("pubkey=%s will not dismiss reports on %s not trusted", pubkey, msgid)
+		}
+	case "overchan-purge":
+		// purge a poster, target is kind:value
+		parts := strings.SplitN(ev.Target(), ":", 2)
+		if len(parts) != 2 || !validPurgeTarget(parts[0], parts[1]) {
+			log.Printf("invalid %s: target=%s", action, ev.Target())
+			return true
+		}
+		if !mod.AllowPurge(pubkey) {
+			log.Println("ignoring purge from", pubkey, "as they are not allowed to purge")
+		} else if policy != RemoteDeleteHonor {
+			// a purge is too many deletes to queue one by one
+			log.Printf("pubkey=%s will not purge %s remote deletes not honored", pubkey, ev.Target())
+		} else {
+			deleted, err := mod.PurgePoster(parts[0], parts[1], regen)
+			log.Println("purged", len(deleted), "posts of", ev.Target())
+			if err != nil {
+				log.Println("failed to purge", ev.Target(), err)
 			}
 		}
+	case "overchan-mod-grant", "overchan-mod-revoke":
+		// permission change, target is pubkey:newsgroup:permission
+		target, group, perm, ok := parseModGrantTarget(ev.Target())
+		if !ok {
+			log.Printf("invalid %s: target=%s", action, ev.Target())
+			return true
+		}
+		if !mod.AllowGrant(pubkey, group, perm) {
+			log.Println("ignoring", action, "from", pubkey, "as they are not allowed to change", perm, "on", group)
+			return true
+		}
+		var err error
+		if action == "overchan-mod-grant" {
+			err = mod.GrantPermission(pubkey, target, group, perm)
+		} else {
+			err = mod.RevokePermission(target, group, perm)
+		}
+		if err != nil {
+			log.Println("failed to", action, ev.Target(), err)
+		}
+	case "overchan-banlist", "overchan-banlist-remove":
+		// ban list entry, target is kind:value
+		parts := strings.SplitN(ev.Target(), ":", 2)
+		if len(parts) != 2 || !validBanlistEntry(parts[0], parts[1]) {
+			log.Printf("invalid %s: target=%s", action, ev.Target())
+			return true
+		}
+		if !mod.BanlistSubscribed(pubkey) {
+			// not a ban list we merge
+			return true
+		}
+		var err error
+		if action == "overchan-banlist" {
+			err = mod.MergeBanlistEntry(pubkey, parts[0], parts[1])
+		} else {
+			err = mod.DropBanlistEntry(pubkey, parts[0], parts[1])
+		}
+		if err != nil {
+			log.Println("failed to", action, ev.Target(), "from", pubkey, err)
+		}
+	case "overchan-mod-rotate":
+		// key rotation, target is the new pubkey
+		newkey := ev.Target()
+		if len(newkey) != 64 || len(unhex(newkey)) != 32 || newkey == pubkey {
+			log.Printf("invalid overchan-mod-rotate: target=%s", newkey)
+			return true
+		}
+		if !mod.AllowRotate(pubkey) {
+			log.Println("ignoring key rotation from", pubkey, "as it has nothing to rotate")
+			return true
+		}
+		err := mod.RotateKey(pubkey, newkey)
+		if err == nil {
+			log.Println("mod key", pubkey, "rotated to", newkey)
+			// nothing else in this message counts
+			return false
+		}
+		log.Println("failed to rotate mod key", pubkey, "to", newkey, err)
+	case ThreadSticky, ThreadLocked, ThreadCycle:
+		// thread flag, target is any post in the thread, "off" after it clears the flag
+		msgid := ev.Target()
+		if !ValidMessageID(msgid) {
+			log.Printf("invalid %s: target=%s", action, msgid)
+			return true
+		}
+		if !mod.AllowThreadFlag(pubkey, msgid, action) {
+			log.Printf("pubkey=%s will not %s %s not trusted", pubkey, action, msgid)
+			return true
+		}
+		root, group, err := mod.SetThreadFlag(msgid, action, ev.Reason() != "off")
+		if err == nil {
+			// nothing was deleted, regen the thread and the first page it now sorts onto
+			regen(group, "", root, 0)
+		} else {
+			log.Println("failed to", action, msgid, err)
+		}
+	default:
+		log.Println("invalid mod action", action, "from", pubkey)
 	}
+	return true
 }
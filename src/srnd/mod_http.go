@@ -7,17 +7,21 @@
 package srnd
 
 import (
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/majestrate/nacl"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -33,13 +37,37 @@ type httpModUI struct {
 	store            *sessions.CookieStore
 	prefix           string
 	mod_prefix       string
+	nntpLogin        bool
+	// nntp login -> mod pubkey it logs in as
+	nntpMods        map[string]string
+	modKey          string
+	regenOnModEvent RegenFunc
 }
 
 func createHttpModUI(frontend *httpFrontend) httpModUI {
-	return httpModUI{frontend.regenAll, frontend.Regen, frontend.regenerateBoard, frontend.deleteThreadMarkup, frontend.deleteBoardMarkup, make(chan NNTPMessage), frontend.daemon, frontend.daemon.store, frontend.store, frontend.prefix, frontend.prefix + "mod/"}
+	return httpModUI{frontend.regenAll, frontend.Regen, frontend.regenerateBoard, frontend.deleteThreadMarkup, frontend.deleteBoardMarkup, make(chan NNTPMessage), frontend.daemon, frontend.daemon.store, frontend.store, frontend.prefix, frontend.prefix + "mod/", frontend.modNNTPLogin, frontend.modNNTPUsers, frontend.modKey, frontend.regenOnModEvent}
 
 }
 
+// parse the mod_nntp_users option, comma separated login=pubkey pairs
+// nntp logins not in it can't log in as a mod
+func parseModNNTPUsers(val string) (users map[string]string, err error) {
+	users = make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idx := strings.Index(pair, "=")
+		if idx <= 0 || len(pair[idx+1:]) != 64 {
+			err = fmt.Errorf("bad mod_nntp_users entry %q, it should be login=pubkey", pair)
+			return
+		}
+		users[pair[:idx]] = strings.ToLower(pair[idx+1:])
+	}
+	return
+}
+
 func extractGroup(param map[string]interface{}) string {
 	return extractParam(param, "newsgroup")
 }
@@ -215,6 +243,316 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 				return "bad newsgroup: " + group, nil
 			}
 		}
+	} else if funcname == "pubkey.grant" || funcname == "pubkey.revoke" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			group := extractGroup(param)
+			perm := extractParam(param, "permission")
+			if group == "" {
+				// global permission
+				group = "overchan"
+			}
+			if len(pubkey) != 64 {
+				return "bad pubkey: " + pubkey, nil
+			} else if !newsgroupValidFormat(group) {
+				return "bad newsgroup: " + group, nil
+			} else if !validModPermission(perm) {
+				return "bad permission: " + perm, nil
+			}
+			log.Println(funcname, perm, "on", group, "for", pubkey)
+			var err error
+			if funcname == "pubkey.grant" {
+				err = self.daemon.database.GrantModPubkeyPermission(pubkey, group, perm)
+			} else {
+				err = self.daemon.database.RevokeModPubkeyPermission(pubkey, group, perm)
+				if err == nil {
+					err = self.daemon.database.ForgetModGrant(pubkey, group, perm)
+				}
+			}
+			if err == nil {
+				return "okay", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "pubkey.grants" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			if len(pubkey) != 64 {
+				return "bad pubkey: " + pubkey, nil
+			}
+			// what this pubkey handed out and where its own permissions came from
+			by, err := self.daemon.database.GetModGrantsBy(pubkey)
+			if err != nil {
+				return "error", err
+			}
+			to, err := self.daemon.database.GetModGrantsTo(pubkey)
+			if err != nil {
+				return "error", err
+			}
+			return map[string]interface{}{"granted_by": by, "granted_to": to}, nil
+		}
+	} else if funcname == "pubkey.perms" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			group := extractGroup(param)
+			if group == "" {
+				group = "overchan"
+			}
+			return self.daemon.database.GetModPubkeyPermissions(pubkey, group)
+		}
+	} else if funcname == "report.list" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetReports(extractGroup(param))
+		}
+	} else if funcname == "report.dismiss" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			msgid := extractParam(param, "message-id")
+			if !ValidMessageID(msgid) {
+				return "bad message-id: " + msgid, nil
+			}
+			err := self.daemon.database.DismissReports(msgid)
+			if err == nil {
+				return "dismissed", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "post.delete" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			msgid := extractParam(param, "message-id")
+			if !ValidMessageID(msgid) {
+				return "bad message-id: " + msgid, nil
+			}
+			if len(self.modKey) == 0 {
+				return "no mod_privkey set in frontend config", nil
+			}
+			// sign a delete with the node key and feed it, we honor it when it comes back
+			nntp, err := signArticle(wrapModMessage(ModMessage{overchanDelete(msgid)}), unhex(self.modKey))
+			if err == nil {
+				self.modMessageChan <- nntp
+				return "deleted", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "file.ban" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			hash := strings.ToLower(extractParam(param, "hash"))
+			if !validBanlistEntry(BanlistFile, hash) {
+				return "bad sha512: " + hash, nil
+			}
+			if len(self.modKey) == 0 {
+				return "no mod_privkey set in frontend config", nil
+			}
+			// sign a ban-file with the node key and feed it, we ban it when it comes back
+			nntp, err := signArticle(wrapModMessage(ModMessage{banFile(hash)}), unhex(self.modKey))
+			if err == nil {
+				self.modMessageChan <- nntp
+				return "banned", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "banlist.add" || funcname == "banlist.remove" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			kind := extractParam(param, "kind")
+			value := extractParam(param, "value")
+			if !validBanlistEntry(kind, value) {
+				return "bad ban list entry: " + kind + ":" + value, nil
+			}
+			if len(self.modKey) == 0 {
+				return "no mod_privkey set in frontend config", nil
+			}
+			var err error
+			var ev ModEvent
+			if funcname == "banlist.add" {
+				err = applyBan(self.daemon.database, kind, value)
+				ev = overchanBanlist(kind, value)
+			} else {
+				err = liftBan(self.daemon.database, kind, value)
+				ev = overchanBanlistRemove(kind, value)
+			}
+			if err != nil {
+				return "error", err
+			}
+			// publish to everyone subscribed to our ban list
+			nntp, err := signArticle(wrapModMessage(ModMessage{ev}), unhex(self.modKey))
+			if err == nil {
+				self.modMessageChan <- nntp
+				return "published", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "banlist.subscribe" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			if len(unhex(pubkey)) != 32 {
+				return "bad pubkey: " + pubkey, nil
+			}
+			err := self.daemon.database.SubscribeBanlist(pubkey)
+			if err == nil {
+				return "subscribed", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "banlist.unsubscribe" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			pubkey := extractParam(param, "pubkey")
+			var err error
+			if extractParam(param, "rollback") == "1" {
+				// also lift every ban that list brought in
+				err = self.daemon.mod.RollbackBanlist(pubkey)
+			} else {
+				err = self.daemon.database.UnsubscribeBanlist(pubkey)
+			}
+			if err == nil {
+				return "unsubscribed", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "banlist.list" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			subs, err := self.daemon.database.GetBanlistSubscriptions()
+			if err != nil {
+				return nil, err
+			}
+			// pubkey -> bans we merged from it
+			lists := make(map[string][]BanlistEntry)
+			for _, pubkey := range subs {
+				lists[pubkey], err = self.daemon.database.GetBanlistEntries(pubkey)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return lists, nil
+		}
+	} else if funcname == "shadowban.add" || funcname == "shadowban.del" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			encaddr := extractParam(param, "encaddr")
+			if len(encaddr) == 0 {
+				return "no encaddr given", nil
+			}
+			var err error
+			if funcname == "shadowban.add" {
+				err = self.daemon.database.ShadowBanEncAddr(encaddr)
+			} else {
+				err = self.daemon.database.UnshadowBanEncAddr(encaddr)
+			}
+			if err == nil {
+				return "ok", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "ban.list" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetAddrBans()
+		}
+	} else if funcname == "ban.explain" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			addr := extractParam(param, "addr")
+			if net.ParseIP(addr) == nil {
+				return "bad address: " + addr, nil
+			}
+			// every rule that matched, empty if not banned
+			return self.daemon.database.ExplainIPBan(addr)
+		}
+	} else if funcname == "ban.remove" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			cidr := extractParam(param, "cidr")
+			isnet, _ := IsSubnet(cidr)
+			if !isnet && net.ParseIP(cidr) == nil {
+				return "bad cidr: " + cidr, nil
+			}
+			log.Println("ban.remove", cidr)
+			err := self.daemon.database.UnbanCIDR(cidr)
+			if err == nil {
+				return "removed", nil
+			} else {
+				return "error", err
+			}
+		}
+	} else if funcname == "post.country" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			// decrypt the country a post was made from
+			msgid := extractParam(param, "message-id")
+			if !ValidMessageID(msgid) {
+				return "bad message-id: " + msgid, nil
+			}
+			hdr, err := self.daemon.database.GetHeadersForMessage(msgid)
+			if hdr == nil {
+				return nil, err
+			}
+			enc := hdr.Get("X-Encrypted-Country", "")
+			encip := hdr.Get("X-Encrypted-Ip", hdr.Get("X-Encrypted-IP", ""))
+			if enc == "" || encip == "" {
+				return msgid + " has no country", nil
+			}
+			key, err := self.daemon.database.GetEncKey(encip)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"message-id": msgid, "country": decCountry(enc, key)}, nil
+		}
+	} else if funcname == "pubkey.trust" || funcname == "pubkey.untrust" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			// higher posting quotas for this key
+			pubkey := extractParam(param, "pubkey")
+			if len(pubkey) != 64 {
+				return "bad pubkey: " + pubkey, nil
+			}
+			trusted := funcname == "pubkey.trust"
+			log.Println("setting pubkey", pubkey, "trusted:", trusted)
+			err := self.daemon.database.SetPubkeyTrusted(pubkey, trusted)
+			return map[string]interface{}{"pubkey": pubkey, "trusted": trusted}, err
+		}
+	} else if funcname == "intake.list" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.feedIntakes()
+		}
+	} else if funcname == "intake.trust" || funcname == "intake.untrust" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			feed := extractParam(param, "feed")
+			conf := self.daemon.feedConfig(feed)
+			if conf == nil || !conf.moderated_intake {
+				return "no feed in moderated intake named " + feed, nil
+			}
+			intake := FeedIntake{Feed: feed, Trusted: funcname == "intake.trust"}
+			log.Println("setting intake of", feed, "trusted:", intake.Trusted)
+			return intake, self.daemon.database.SetFeedIntake(intake)
+		}
+	} else if funcname == "trash.list" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			return self.daemon.database.GetTrashedArticles()
+		}
+	} else if funcname == "trash.restore" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			// a whole thread by message-id or everything a nuke took by newsgroup
+			msgid := extractParam(param, "message-id")
+			group := extractGroup(param)
+			if msgid == "" && group == "" {
+				return "no message-id or newsgroup given", nil
+			} else if msgid != "" && !ValidMessageID(msgid) {
+				return "bad message-id: " + msgid, nil
+			}
+			log.Println("restoring from trash", msgid, group)
+			restored, err := self.daemon.restoreFromTrash(msgid, group)
+			return map[string]interface{}{"restored": restored}, err
+		}
+	} else if funcname == "addrkeys.purge" {
+		return func(param map[string]interface{}) (interface{}, error) {
+			days, err := strconv.Atoi(extractParam(param, "days"))
+			if err != nil || days <= 0 {
+				return "bad days: " + extractParam(param, "days"), nil
+			}
+			log.Println("purging address keys older than", days, "days")
+			_, purged := rotateAddrKeys(self.daemon.database, 0, daysAgo(days))
+			return map[string]interface{}{"purged": purged}, nil
+		}
 	} else if funcname == "pubkey.del" {
 		return func(param map[string]interface{}) (interface{}, error) {
 			pubkey := extractParam(param, "pubkey")
@@ -311,6 +649,10 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 			feeds := self.daemon.activeFeeds()
 			return feeds, nil
 		}
+	} else if funcname == "feed.status" {
+		return func(_ map[string]interface{}) (interface{}, error) {
+			return self.daemon.feedHealth(), nil
+		}
 	} else if funcname == "feed.sync" {
 		return func(_ map[string]interface{}) (interface{}, error) {
 			go self.daemon.syncAllMessages()
@@ -322,6 +664,16 @@ func (self httpModUI) getAdminFunc(funcname string) AdminFunc {
 			self.daemon.removeFeed(name)
 			return "okay", nil
 		}
+	} else if funcname == "feed.offers.clear" {
+		// forget what a feed's peer has so everything is offered again on next sync
+		return func(param map[string]interface{}) (interface{}, error) {
+			name := extractParam(param, "name")
+			err := self.daemon.database.ClearOfferedArticles(name)
+			if err != nil {
+				return nil, err
+			}
+			return "okay", nil
+		}
 	} else if funcname == "store.expire" {
 		return func(_ map[string]interface{}) (interface{}, error) {
 			if self.daemon.expire == nil {
@@ -406,6 +758,11 @@ func (self httpModUI) CheckPubkey(pubkey, scope string) (bool, error) {
 		if self.daemon.database.CheckModPubkeyCanModGroup(pubkey, group) {
 			return true, nil
 		}
+		// mods with only some permissions on this board
+		perms, _ := self.daemon.database.GetModPubkeyPermissions(pubkey, group)
+		if len(perms) > 0 {
+			return true, nil
+		}
 	} else if scope == "login" {
 		// check if a user can log in
 		return self.daemon.database.CheckModPubkey(pubkey), nil
@@ -437,9 +794,17 @@ func (self httpModUI) getSession(r *http.Request) *sessions.Session {
 }
 
 // get the session's private key as bytes or nil if we don't have it
+// nntp logins with the mod role sign with the node mod key, other sessions need their own key
 func (self httpModUI) getSessionPrivkeyBytes(r *http.Request) []byte {
 	s := self.getSession(r)
 	k, ok := s.Values["privkey"]
+	if !ok && len(self.modKey) > 0 {
+		if u, has_user := s.Values["nntp_user"]; has_user {
+			if mod, _ := self.nntpModUser(u.(string)); mod {
+				k, ok = self.modKey, true
+			}
+		}
+	}
 	if ok {
 		privkey_bytes, err := hex.DecodeString(k.(string))
 		if err == nil {
@@ -456,15 +821,33 @@ func (self httpModUI) getSessionPrivkeyBytes(r *http.Request) []byte {
 // otherwise redirect to login page
 func (self httpModUI) checkSession(r *http.Request, scope string) bool {
 	s := self.getSession(r)
-	k, ok := s.Values["privkey"]
-	if ok {
-		ok, err := self.CheckKey(k.(string), scope)
-		if err != nil {
-			return false
+	var ok bool
+	var err error
+	if k, has := s.Values["privkey"]; has {
+		ok, err = self.CheckKey(k.(string), scope)
+	} else if k, has := s.Values["pubkey"]; has {
+		// logged in by signing a challenge or as the mod key of an nntp login
+		ok, err = self.CheckPubkey(k.(string), scope)
+		if u, has := s.Values["nntp_user"]; has && ok && err == nil {
+			// the login can be disabled or lose its role while logged in
+			ok, err = self.nntpModUser(u.(string))
 		}
-		return ok
 	}
-	return false
+	return ok && err == nil
+}
+
+// can an nntp login log in to the mod panel?
+// it has to be enabled and have the mod role, being in mod_nntp_users is checked apart
+func (self httpModUI) nntpModUser(username string) (bool, error) {
+	user, err := self.daemon.database.GetNNTPUser(username)
+	if err != nil || user == nil {
+		return false, err
+	}
+	return !user.Disabled && user.Role == NNTPRoleMod, nil
+}
+
+func (self httpModUI) CheckSession(r *http.Request, scope string) bool {
+	return self.checkSession(r, scope)
 }
 
 func (self httpModUI) writeTemplate(wr http.ResponseWriter, r *http.Request, name string) {
@@ -478,6 +861,7 @@ func (self httpModUI) writeTemplateParam(wr http.ResponseWriter, r *http.Request
 	param[csrf.TemplateTag] = csrf.TemplateField(r)
 	param["prefix"] = self.prefix
 	param["mod_prefix"] = self.mod_prefix
+	param["i18n"] = i18nForRequest(r, "")
 	io.WriteString(wr, template.renderTemplate(name, param))
 }
 
@@ -545,6 +929,11 @@ func (self httpModUI) HandleUnbanAddress(wr http.ResponseWriter, r *http.Request
 				// TODO: rangebans
 				err = self.daemon.database.UnbanAddr(addr)
 				if err == nil {
+					// a later ban gets a new appeal
+					encaddr, _ := self.daemon.database.GetEncAddress(addr)
+					if encaddr != "" {
+						self.daemon.database.DeleteBanAppeal(encaddr)
+					}
 					resp["result"] = fmt.Sprintf("%s was unbanned", addr)
 				} else {
 					resp["error"] = err.Error()
@@ -625,6 +1014,29 @@ func (self httpModUI) handleBanAddress(msg ArticleEntry, r *http.Request) map[st
 	return resp
 }
 
+// shadow ban the poster of a post, this stays on our node
+func (self httpModUI) handleShadowBanAddress(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	msgid := msg.MessageID()
+	hdr, err := self.daemon.database.GetHeadersForMessage(msgid)
+	if hdr == nil {
+		resp["error"] = fmt.Sprintf("could not load headers for %s: %s", msgid, err)
+		return resp
+	}
+	encip := strings.Trim(hdr.Get("X-Encrypted-Ip", hdr.Get("X-Encrypted-IP", "")), "\t ")
+	if len(encip) == 0 {
+		resp["error"] = fmt.Sprintf("%s has no IP, cannot shadow ban", msgid)
+		return resp
+	}
+	err = self.daemon.database.ShadowBanEncAddr(encip)
+	if err == nil {
+		resp["banned"] = fmt.Sprintf("We shadow banned %s", encip)
+	} else {
+		resp["error"] = err.Error()
+	}
+	return resp
+}
+
 func (self httpModUI) handleDeletePost(msg ArticleEntry, r *http.Request) map[string]interface{} {
 	var mm ModMessage
 	resp := make(map[string]interface{})
@@ -673,43 +1085,673 @@ func (self httpModUI) handleDeletePost(msg ArticleEntry, r *http.Request) map[st
 	return resp
 }
 
+// set or clear a thread flag on the thread of a post, ?off=1 clears it
+func (self httpModUI) handleThreadFlag(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	flag := mux.Vars(r)["flag"]
+	on := r.URL.Query().Get("off") != "1"
+	mm := ModMessage{overchanThreadFlag(flag, msg.MessageID(), on)}
+	privkey_bytes := self.getSessionPrivkeyBytes(r)
+	if privkey_bytes == nil {
+		resp["error"] = "no private key in session"
+		return resp
+	}
+	// the mod engine does it here once it's stored like any other ctl message
+	nntp, err := signArticle(wrapModMessage(mm), privkey_bytes)
+	if err == nil {
+		self.modMessageChan <- nntp
+		resp[flag] = on
+	} else {
+		resp["error"] = fmt.Sprintf("signing error: %s", err.Error())
+	}
+	return resp
+}
+
 // ban the address of a poster
 func (self httpModUI) HandleBanAddress(wr http.ResponseWriter, r *http.Request) {
 	self.asAuthedWithMessage("ban", self.handleBanAddress, wr, r)
 }
 
+func (self httpModUI) HandleShadowBanAddress(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("ban", self.handleShadowBanAddress, wr, r)
+}
+
+// purge a poster by pubkey or encrypted address
+// with propagate=1 it goes out as a signed ctl message so every node that trusts us purges too
+func (self httpModUI) HandlePurgePoster(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("ban", func(path string) {
+		vars := mux.Vars(r)
+		kind, value := vars["kind"], vars["value"]
+		resp := make(map[string]interface{})
+		if !validPurgeTarget(kind, value) {
+			resp["error"] = fmt.Sprintf("cannot purge %s %s", kind, value)
+		} else if r.URL.Query().Get("propagate") == "1" {
+			privkey_bytes := self.getSessionPrivkeyBytes(r)
+			// plain deletes first for nodes that don't know purges
+			var mm ModMessage
+			msgids, err := getPosterMessageIDs(self.daemon.database, kind, value)
+			for _, msgid := range msgids {
+				mm = append(mm, overchanDelete(msgid))
+			}
+			mm = append(mm, overchanPurge(kind, value))
+			if err != nil {
+				resp["error"] = err.Error()
+			} else if privkey_bytes == nil {
+				resp["error"] = "no private key in session"
+			} else {
+				// the mod engine does it here once it's stored like any other ctl message
+				nntp, err := signArticle(wrapModMessage(mm), privkey_bytes)
+				if err == nil {
+					self.modMessageChan <- nntp
+					resp["purged"] = msgids
+				} else {
+					resp["error"] = fmt.Sprintf("signing error: %s", err.Error())
+				}
+			}
+		} else {
+			deleted, err := self.daemon.mod.PurgePoster(kind, value, self.regenOnModEvent)
+			resp["purged"] = deleted
+			if err != nil {
+				resp["error"] = err.Error()
+			}
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+// sign a grant or revoke of a mod permission, the mod engine checks the session key may do it
+func (self httpModUI) HandleModGrant(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("login", func(path string) {
+		vars := mux.Vars(r)
+		resp := make(map[string]interface{})
+		pubkey, group, perm, ok := parseModGrantTarget(vars["pubkey"] + ":" + vars["newsgroup"] + ":" + vars["permission"])
+		privkey_bytes := self.getSessionPrivkeyBytes(r)
+		if !ok {
+			resp["error"] = "bad grant"
+		} else if privkey_bytes == nil {
+			resp["error"] = "no private key in session"
+		} else {
+			ev := overchanModGrant(pubkey, group, perm)
+			if vars["action"] == "revoke" {
+				ev = overchanModRevoke(pubkey, group, perm)
+			}
+			nntp, err := signArticle(wrapModMessage(ModMessage{ev}), privkey_bytes)
+			if err == nil {
+				self.modMessageChan <- nntp
+				resp[vars["action"]] = ev.Target()
+			} else {
+				resp["error"] = fmt.Sprintf("signing error: %s", err.Error())
+			}
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+func (self httpModUI) HandleThreadFlag(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleThreadFlag, wr, r)
+}
+
 // delete a post
 func (self httpModUI) HandleDeletePost(wr http.ResponseWriter, r *http.Request) {
 	self.asAuthedWithMessage("login", self.handleDeletePost, wr, r)
 }
 
+func (self httpModUI) handleDismissReports(msg ArticleEntry, r *http.Request) map[string]interface{} {
+	resp := make(map[string]interface{})
+	msgid := msg.MessageID()
+	err := self.daemon.database.DismissReports(msgid)
+	if err != nil {
+		resp["error"] = err.Error()
+		return resp
+	}
+	resp["dismissed"] = msgid
+	privkey_bytes := self.getSessionPrivkeyBytes(r)
+	if privkey_bytes == nil {
+		log.Println("failed to get private keys from session, not federating")
+	} else {
+		// tell everyone else
+		nntp, err := signArticle(wrapModMessage(ModMessage{overchanReportDismiss(msgid)}), privkey_bytes)
+		if err == nil {
+			self.modMessageChan <- nntp
+		} else {
+			resp["error"] = fmt.Sprintf("signing error: %s", err.Error())
+		}
+	}
+	return resp
+}
+
+// dismiss all reports on a post
+func (self httpModUI) HandleDismissReports(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithMessage("login", self.handleDismissReports, wr, r)
+}
+
+// hand out a random challenge for the session to sign with a mod key
+func (self httpModUI) HandleChallenge(wr http.ResponseWriter, r *http.Request) {
+	challenge := hexify(nacl.RandBytes(32))
+	sess := self.getSession(r)
+	sess.Values["challenge"] = challenge
+	sess.Save(r, wr)
+	enc := json.NewEncoder(wr)
+	enc.Encode(map[string]interface{}{"challenge": challenge})
+}
+
+// check a signature made by pubkey over the sha512 of the challenge
+func verifyModChallenge(challenge, pubkey, sig string) bool {
+	pk := unhex(pubkey)
+	sig_bytes := unhex(sig)
+	if len(pk) != nacl.CryptoSignPublicLen() || len(sig_bytes) == 0 {
+		return false
+	}
+	h := sha512.Sum512(unhex(challenge))
+	return nacl.CryptoVerifyFucky(h[:], sig_bytes, pk)
+}
+
 func (self httpModUI) HandleLogin(wr http.ResponseWriter, r *http.Request) {
 	privkey := r.FormValue("privkey")
+	pubkey := r.FormValue("pubkey")
+	username := r.FormValue("username")
+	sess := self.getSession(r)
 	msg := "failed login: "
-	if len(privkey) == 0 {
-		msg += "no key"
-	} else {
+	if len(privkey) > 0 {
 		ok, err := self.CheckKey(privkey, "login")
 		if err != nil {
 			msg += fmt.Sprintf("%s", err)
 		} else if ok {
 			msg = "login okay"
-			sess := self.getSession(r)
 			sess.Values["privkey"] = privkey
-			sess.Save(r, wr)
 		} else {
 			msg += "invalid key"
 		}
+	} else if len(pubkey) > 0 {
+		challenge, _ := sess.Values["challenge"].(string)
+		// a challenge is only good for one try
+		delete(sess.Values, "challenge")
+		if len(challenge) == 0 {
+			msg += "no challenge"
+		} else if !verifyModChallenge(challenge, pubkey, r.FormValue("sig")) {
+			msg += "bad signature"
+		} else {
+			ok, err := self.CheckPubkey(pubkey, "login")
+			if err != nil {
+				msg += fmt.Sprintf("%s", err)
+			} else if ok {
+				msg = "login okay"
+				sess.Values["pubkey"] = pubkey
+			} else {
+				msg += "invalid key"
+			}
+		}
+	} else if len(username) > 0 {
+		pubkey, mapped := self.nntpMods[username]
+		if !self.nntpLogin || len(self.modKey) == 0 {
+			msg += "nntp login disabled"
+		} else if !mapped {
+			msg += "invalid login"
+		} else {
+			ok, err := self.nntpModUser(username)
+			if err == nil && ok {
+				ok, err = self.daemon.database.CheckNNTPLogin(username, r.FormValue("password"))
+			}
+			if err == nil && ok {
+				// the login can do what its mod key can
+				ok, err = self.CheckPubkey(pubkey, "login")
+			}
+			if err != nil {
+				msg += fmt.Sprintf("%s", err)
+			} else if ok {
+				msg = "login okay"
+				sess.Values["nntp_user"] = username
+				sess.Values["pubkey"] = pubkey
+			} else {
+				msg += "invalid login"
+			}
+		}
+	} else {
+		msg += "no key"
 	}
+	sess.Save(r, wr)
 	self.writeTemplateParam(wr, r, "modlogin_result.mustache", map[string]interface{}{"message": msg, csrf.TemplateTag: csrf.TemplateField(r)})
 }
 
+func (self httpModUI) HandleLogout(wr http.ResponseWriter, r *http.Request) {
+	sess := self.getSession(r)
+	delete(sess.Values, "privkey")
+	delete(sess.Values, "pubkey")
+	delete(sess.Values, "nntp_user")
+	sess.Save(r, wr)
+	http.Redirect(wr, r, self.mod_prefix, http.StatusSeeOther)
+}
+
 func (self httpModUI) HandleKeyGen(wr http.ResponseWriter, r *http.Request) {
 	pk, sk := newSignKeypair()
 	tripcode := makeTripcode(pk)
 	self.writeTemplateParam(wr, r, "keygen.mustache", map[string]interface{}{"public": pk, "secret": sk, "tripcode": tripcode})
 }
 
+// serve a mod page if the session is logged in otherwise the login page
+func (self httpModUI) serveAuthedPage(wr http.ResponseWriter, r *http.Request, scope, name string, getParam func() map[string]interface{}) {
+	if self.checkSession(r, scope) {
+		wr.Header().Set("X-CSRF-Token", csrf.Token(r))
+		self.writeTemplateParam(wr, r, name, getParam())
+	} else if self.checkSession(r, "login") {
+		wr.WriteHeader(403)
+	} else {
+		self.writeTemplate(wr, r, "modlogin.mustache")
+	}
+}
+
+// serve the last posts on boards this session can moderate
+func (self httpModUI) ServeModPosts(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "login", "modposts.mustache", func() map[string]interface{} {
+		var posts []PostModel
+		for _, p := range self.daemon.database.GetLastPostedPostModels(self.prefix, 50) {
+			if self.checkSession(r, "mod-"+p.Board()) {
+				posts = append(posts, p)
+			}
+		}
+		return map[string]interface{}{"posts": posts}
+	})
+}
+
+// serve open reports on boards this session can moderate
+func (self httpModUI) ServeModReports(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "login", "modreports.mustache", func() map[string]interface{} {
+		param := make(map[string]interface{})
+		reports, err := self.daemon.database.GetReports("")
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		var list []map[string]interface{}
+		for _, rep := range reports {
+			if !self.checkSession(r, "mod-"+rep.Newsgroup) {
+				continue
+			}
+			list = append(list, map[string]interface{}{
+				"report":    rep,
+				"post_hash": HashMessageID(rep.MessageID),
+				"post":      self.daemon.database.GetPostModel(self.prefix, rep.MessageID),
+			})
+		}
+		param["reports"] = list
+		return param
+	})
+}
+
+// serve every banned address
+func (self httpModUI) ServeModBans(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "ban", "modbans.mustache", func() map[string]interface{} {
+		param := make(map[string]interface{})
+		bans, err := self.daemon.database.GetBannedAddrs()
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		param["bans"] = bans
+		addr_bans, err := self.daemon.database.GetAddrBans()
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		param["addr_bans"] = addr_bans
+		return param
+	})
+}
+
+// serve ban appeals no mod has answered yet
+func (self httpModUI) ServeModAppeals(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "ban", "modappeals.mustache", func() map[string]interface{} {
+		param := make(map[string]interface{})
+		appeals, err := self.daemon.database.GetPendingBanAppeals()
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		param["appeals"] = appeals
+		return param
+	})
+}
+
+// answer the pending appeal of the encrypted address in the url
+func (self httpModUI) asAuthedWithAppeal(handler func(string) error, result string, wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("ban", func(path string) {
+		encaddr := mux.Vars(r)["encaddr"]
+		resp := make(map[string]interface{})
+		appeal, err := self.daemon.database.GetBanAppeal(encaddr)
+		if err != nil {
+			resp["error"] = err.Error()
+		} else if appeal == nil || !appeal.Pending() {
+			resp["error"] = fmt.Sprintf("no pending appeal from %s", encaddr)
+		} else if err = handler(encaddr); err != nil {
+			resp["error"] = err.Error()
+		} else {
+			resp[result] = encaddr
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+// lift the ban on an encrypted address and forget its appeal
+func (self httpModUI) liftBan(encaddr string) (err error) {
+	err = self.daemon.database.UnbanEncAddr(encaddr)
+	if err != nil {
+		return
+	}
+	addr, _ := self.daemon.database.GetIPAddress(encaddr)
+	if addr != "" {
+		var banned bool
+		banned, err = self.daemon.database.CheckIPBanned(addr)
+		if err == nil && banned {
+			// range bans stay, they cover more than this poster
+			err = self.daemon.database.UnbanAddr(addr)
+		}
+		if err != nil {
+			return
+		}
+	}
+	log.Println("lifted ban on", encaddr, "after appeal")
+	return self.daemon.database.DeleteBanAppeal(encaddr)
+}
+
+func (self httpModUI) HandleLiftAppeal(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithAppeal(self.liftBan, "lifted", wr, r)
+}
+
+func (self httpModUI) HandleUpholdAppeal(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithAppeal(self.daemon.database.UpholdBanAppeal, "upheld", wr, r)
+}
+
+// serve posts held by the spam filter on boards this session can moderate
+func (self httpModUI) ServeModQuarantine(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "login", "modquarantine.mustache", func() map[string]interface{} {
+		param := make(map[string]interface{})
+		held, err := self.daemon.database.GetQuarantinedArticles("")
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		var list []QuarantinedArticle
+		for _, q := range held {
+			if self.checkSession(r, "mod-"+q.Newsgroup) {
+				list = append(list, q)
+			}
+		}
+		param["quarantined"] = list
+		return param
+	})
+}
+
+// do something with a quarantined post if we can moderate its board
+func (self httpModUI) asAuthedWithQuarantined(handler func(QuarantinedArticle) error, result string, wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("login", func(path string) {
+		hash := mux.Vars(r)["hash"]
+		resp := make(map[string]interface{})
+		held, err := self.daemon.database.GetQuarantinedArticles("")
+		var found *QuarantinedArticle
+		for idx := range held {
+			if held[idx].Hash() == hash {
+				found = &held[idx]
+				break
+			}
+		}
+		if err != nil {
+			resp["error"] = err.Error()
+		} else if found == nil {
+			resp["error"] = fmt.Sprintf("no quarantined post %s", hash)
+		} else if !self.checkSession(r, "mod-"+found.Newsgroup) {
+			resp["error"] = fmt.Sprintf("you don't have permission to moderate '%s'", found.Newsgroup)
+		} else if err = handler(*found); err != nil {
+			resp["error"] = err.Error()
+		} else {
+			resp[result] = found.MessageID
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+func (self httpModUI) HandleApproveQuarantined(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithQuarantined(self.daemon.approveQuarantined, "approved", wr, r)
+}
+
+func (self httpModUI) HandleRejectQuarantined(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthedWithQuarantined(self.daemon.rejectQuarantined, "rejected", wr, r)
+}
+
+// approve or reject every quarantined post on boards we can moderate
+// limited to one feed or newsgroup with ?feed= and ?newsgroup=
+func (self httpModUI) HandleBulkQuarantined(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("login", func(path string) {
+		action := mux.Vars(r)["action"]
+		handler := self.daemon.approveQuarantined
+		if action == "reject" {
+			handler = self.daemon.rejectQuarantined
+		}
+		feed := r.URL.Query().Get("feed")
+		newsgroup := r.URL.Query().Get("newsgroup")
+		resp := make(map[string]interface{})
+		held, err := self.daemon.database.GetQuarantinedArticles(newsgroup)
+		var done, failed []string
+		for _, q := range held {
+			if feed != "" && q.Feed != feed {
+				continue
+			}
+			if !self.checkSession(r, "mod-"+q.Newsgroup) {
+				continue
+			}
+			if handler(q) == nil {
+				done = append(done, q.MessageID)
+			} else {
+				failed = append(failed, q.MessageID)
+			}
+		}
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+		resp[action+"d"] = done
+		resp["failed"] = failed
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+// serve articles we rejected and kept, for boards this session can moderate
+func (self httpModUI) ServeModRejected(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "login", "modrejected.mustache", func() map[string]interface{} {
+		param := make(map[string]interface{})
+		rejected, err := self.daemon.database.GetRejectedArticles()
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		var list []RejectedArticle
+		for _, a := range rejected {
+			if self.checkSession(r, "mod-"+a.Newsgroup) {
+				list = append(list, a)
+			}
+		}
+		param["rejected"] = list
+		return param
+	})
+}
+
+// find a rejected article by hash that this session can moderate
+func (self httpModUI) findRejected(r *http.Request, hash string) (found *RejectedArticle, err error) {
+	var rejected []RejectedArticle
+	rejected, err = self.daemon.database.GetRejectedArticles()
+	for idx := range rejected {
+		if rejected[idx].Hash() == hash {
+			found = &rejected[idx]
+			break
+		}
+	}
+	if err == nil && found == nil {
+		err = fmt.Errorf("no rejected article %s", hash)
+	} else if err == nil && !self.checkSession(r, "mod-"+found.Newsgroup) {
+		err = fmt.Errorf("you don't have permission to moderate '%s'", found.Newsgroup)
+	}
+	return
+}
+
+// show a rejected article as it came in
+func (self httpModUI) HandleViewRejected(wr http.ResponseWriter, r *http.Request) {
+	if !self.CheckSession(r, "login") {
+		wr.WriteHeader(403)
+		return
+	}
+	found, err := self.findRejected(r, mux.Vars(r)["hash"])
+	var rc io.ReadCloser
+	if err == nil {
+		rc, err = self.daemon.store.OpenRejected(found.MessageID)
+	}
+	if err != nil {
+		wr.WriteHeader(404)
+		io.WriteString(wr, err.Error())
+		return
+	}
+	wr.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	io.Copy(wr, rc)
+	rc.Close()
+}
+
+// release or purge one rejected article
+func (self httpModUI) HandleRejected(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("login", func(path string) {
+		vars := mux.Vars(r)
+		action := vars["action"]
+		resp := make(map[string]interface{})
+		found, err := self.findRejected(r, vars["hash"])
+		if err == nil {
+			if action == "release" {
+				err = self.daemon.releaseRejected(found.MessageID)
+			} else {
+				err = self.daemon.purgeRejected(found.MessageID)
+			}
+		}
+		if err == nil {
+			resp[action+"d"] = found.MessageID
+		} else {
+			resp["error"] = err.Error()
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+// release or purge every rejected article on boards we can moderate
+// limited to one feed or newsgroup with ?feed= and ?newsgroup=
+func (self httpModUI) HandleBulkRejected(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("login", func(path string) {
+		action := mux.Vars(r)["action"]
+		handler := self.daemon.purgeRejected
+		if action == "release" {
+			handler = self.daemon.releaseRejected
+		}
+		feed := r.URL.Query().Get("feed")
+		newsgroup := r.URL.Query().Get("newsgroup")
+		resp := make(map[string]interface{})
+		rejected, err := self.daemon.database.GetRejectedArticles()
+		var done, failed []string
+		for _, a := range rejected {
+			if (feed != "" && a.Feed != feed) || (newsgroup != "" && a.Newsgroup != newsgroup) {
+				continue
+			}
+			if !self.checkSession(r, "mod-"+a.Newsgroup) {
+				continue
+			}
+			if handler(a.MessageID) == nil {
+				done = append(done, a.MessageID)
+			} else {
+				failed = append(failed, a.MessageID)
+			}
+		}
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+		resp[action+"d"] = done
+		resp["failed"] = failed
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
+// parse board settings from the settings form
+func parseBoardSettings(group string, form url.Values) (s BoardSettings, err error) {
+	s = defaultBoardSettings(group)
+	s.Pages, err = strconv.Atoi(form.Get("pages"))
+	if err != nil || s.Pages < 1 || s.Pages > 100 {
+		err = errors.New("pages must be between 1 and 100")
+		return
+	}
+	s.ThreadsPerPage, err = strconv.Atoi(form.Get("threads_per_page"))
+	if err != nil || s.ThreadsPerPage < 1 || s.ThreadsPerPage > 100 {
+		err = errors.New("threads per page must be between 1 and 100")
+		return
+	}
+	s.Attachments = form.Get("attachments") == "1"
+	s.InlineMedia = form.Get("inline_media") == "1"
+	if preview := form.Get("preview_replies"); preview != "" {
+		s.PreviewReplies, err = strconv.Atoi(preview)
+		if err != nil || s.PreviewReplies < 0 || s.PreviewReplies > 50 {
+			err = errors.New("preview replies must be between 0 and 50")
+			return
+		}
+	}
+	s.Captcha = strings.TrimSpace(form.Get("captcha"))
+	if !validCaptchaPolicy(s.Captcha) {
+		err = fmt.Errorf("invalid captcha policy '%s'", s.Captcha)
+		return
+	}
+	s.Description = strings.TrimSpace(form.Get("description"))
+	if len(s.Description) > 1024 {
+		err = errors.New("description too long")
+	}
+	return
+}
+
+// serve the settings of a board to admins
+func (self httpModUI) ServeModBoard(wr http.ResponseWriter, r *http.Request) {
+	newsgroup := mux.Vars(r)["newsgroup"]
+	self.serveAuthedPage(wr, r, "admin", "modboard.mustache", func() map[string]interface{} {
+		param := map[string]interface{}{"newsgroup": newsgroup}
+		if !newsgroupValidFormat(newsgroup) || !self.daemon.database.HasNewsgroup(newsgroup) {
+			param["error"] = "no such board"
+			return param
+		}
+		settings, err := self.daemon.database.GetBoardSettings(newsgroup)
+		if err != nil {
+			param["error"] = err.Error()
+		}
+		param["settings"] = settings
+		return param
+	})
+}
+
+// change the settings of a board and regenerate it
+func (self httpModUI) HandleBoardSettings(wr http.ResponseWriter, r *http.Request) {
+	self.asAuthed("admin", func(path string) {
+		newsgroup := mux.Vars(r)["newsgroup"]
+		resp := make(map[string]interface{})
+		var settings BoardSettings
+		err := r.ParseForm()
+		if err == nil && (!newsgroupValidFormat(newsgroup) || !self.daemon.database.HasNewsgroup(newsgroup)) {
+			err = errors.New("no such board")
+		}
+		if err == nil {
+			settings, err = parseBoardSettings(newsgroup, r.PostForm)
+		}
+		if err == nil {
+			err = self.daemon.database.SetBoardSettings(settings)
+		}
+		if err == nil {
+			log.Println("board settings of", newsgroup, "changed")
+			go self.regenGroup(newsgroup)
+			resp["settings"] = settings
+		} else {
+			resp["error"] = err.Error()
+		}
+		enc := json.NewEncoder(wr)
+		enc.Encode(resp)
+	}, wr, r)
+}
+
 func (self httpModUI) ServeModPage(wr http.ResponseWriter, r *http.Request) {
 	if self.checkSession(r, "login") {
 		wr.Header().Set("X-CSRF-Token", csrf.Token(r))
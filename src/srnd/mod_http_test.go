@@ -0,0 +1,69 @@
+package srnd
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseBoardSettings(t *testing.T) {
+
+	s, err := parseBoardSettings("overchan.test", url.Values{"pages": {"5"}, "threads_per_page": {"15"}, "captcha": {"0.5"}})
+	if err != nil || s.Pages != 5 || s.ThreadsPerPage != 15 || s.Attachments || s.Captcha != "0.5" {
+		t.Error("bad board settings", s, err)
+	}
+	_, err = parseBoardSettings("overchan.test", url.Values{"pages": {"5"}, "threads_per_page": {"15"}, "captcha": {"sometimes"}})
+	if err == nil {
+		t.Error("invalid captcha policy accepted")
+	}
+
+}
+
+func TestParseModNNTPUsers(t *testing.T) {
+
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789ABCDEF"
+	users, err := parseModNNTPUsers("alice=" + key + ", bob=" + key[:64])
+	if err != nil || len(users) != 2 || users["alice"] != strings.ToLower(key) {
+		t.Error("bad mod nntp users", users, err)
+	}
+	users, err = parseModNNTPUsers("")
+	if err != nil || len(users) != 0 {
+		t.Error("no mod nntp users should map nobody", users, err)
+	}
+	for _, bad := range []string{"alice", "=" + key, "alice=short"} {
+		if _, err = parseModNNTPUsers(bad); err == nil {
+			t.Error("bad mod nntp users accepted", bad)
+		}
+	}
+
+}
+
+// a database with a few nntp logins
+type modLoginDB struct {
+	Database
+	users map[string]*NNTPUser
+}
+
+func (self *modLoginDB) GetNNTPUser(username string) (*NNTPUser, error) {
+	return self.users[username], nil
+}
+
+func TestNNTPModUser(t *testing.T) {
+
+	db := &modLoginDB{users: map[string]*NNTPUser{
+		"mod":      {Username: "mod", Role: NNTPRoleMod},
+		"disabled": {Username: "disabled", Role: NNTPRoleMod, Disabled: true},
+		"feeder":   {Username: "feeder", Role: NNTPRoleFeeder},
+	}}
+	ui := httpModUI{daemon: &NNTPDaemon{database: db}}
+	for _, username := range []string{"mod", "disabled", "feeder", "nobody"} {
+		ok, err := ui.nntpModUser(username)
+		if err != nil {
+			t.Error(err)
+		}
+		if ok != (username == "mod") {
+			t.Error("nntp login", username, "may log in as a mod:", ok)
+		}
+	}
+
+}
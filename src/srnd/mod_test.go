@@ -0,0 +1,131 @@
+package srnd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModEventReport(t *testing.T) {
+
+	ev := ParseModEvent(overchanReport("<test@localhost>", "spam\nand\tmore  spam").String())
+	if ev.Action() != "overchan-report" {
+		t.Error("bad report action", ev.Action())
+	}
+	if ev.Target() != "<test@localhost>" {
+		t.Error("bad report target", ev.Target())
+	}
+	if ev.Reason() != "spam and more spam" {
+		t.Error("bad report reason", ev.Reason())
+	}
+
+}
+
+func TestModEventRotate(t *testing.T) {
+
+	newkey := "9d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f80"
+	ev := ParseModEvent(overchanModRotate(newkey).String())
+	if ev.Action() != "overchan-mod-rotate" {
+		t.Error("bad rotate action", ev.Action())
+	}
+	if ev.Target() != newkey {
+		t.Error("bad rotate target", ev.Target())
+	}
+
+}
+
+func TestParseModMessage(t *testing.T) {
+
+	mm := ParseModMessage("delete <a@localhost>\r\n\r\n# a comment\r\noverchan-report <b@localhost> spam spam\n  \n")
+	if len(mm) != 2 {
+		t.Fatal("wrong number of mod events", len(mm))
+	}
+	if mm[0].Action() != "delete" || mm[0].Target() != "<a@localhost>" {
+		t.Error("bad delete event", mm[0])
+	}
+	if mm[1].Action() != "overchan-report" || mm[1].Reason() != "spam spam" {
+		t.Error("bad report event", mm[1])
+	}
+
+}
+
+func TestThreadFlagEvent(t *testing.T) {
+
+	ev := ParseModEvent(overchanThreadFlag(ThreadSticky, "<a@localhost>", true).String())
+	if ev.Action() != ThreadSticky || ev.Target() != "<a@localhost>" || ev.Reason() == "off" {
+		t.Error("bad sticky event", ev)
+	}
+	ev = ParseModEvent(overchanThreadFlag(ThreadLocked, "<a@localhost>", false).String())
+	if ev.Action() != ThreadLocked || ev.Reason() != "off" {
+		t.Error("bad unlock event", ev)
+	}
+	for _, flag := range ThreadFlags {
+		if !validModPermission(threadFlagPermission(flag)) {
+			t.Error("no mod permission for thread flag", flag)
+		}
+	}
+
+}
+
+func TestParseModGrantTarget(t *testing.T) {
+
+	key := strings.Repeat("a", 64)
+	pubkey, group, perm, ok := parseModGrantTarget(key + ":overchan.test:" + ModPermOwner)
+	if !ok || pubkey != key || group != "overchan.test" || perm != ModPermOwner {
+		t.Error("owner grant not parsed", pubkey, group, perm, ok)
+	}
+	_, _, _, ok = parseModGrantTarget(key + ":overchan.test:root")
+	if ok {
+		t.Error("unknown permission parsed")
+	}
+	_, _, _, ok = parseModGrantTarget("abc:overchan.test:" + ModPermSticky)
+	if ok {
+		t.Error("short pubkey parsed")
+	}
+
+}
+
+func TestPurgeEvent(t *testing.T) {
+
+	key := strings.Repeat("ab", 32)
+	ev := ParseModEvent(overchanPurge(BanlistPubkey, key).String())
+	parts := strings.SplitN(ev.Target(), ":", 2)
+	if ev.Action() != "overchan-purge" || len(parts) != 2 || !validPurgeTarget(parts[0], parts[1]) {
+		t.Error("bad purge event", ev)
+	}
+	if validPurgeTarget(BanlistFile, strings.Repeat("ab", 64)) {
+		t.Error("files are not posters")
+	}
+	if validPurgeTarget(BanlistEncAddr, "a:b") {
+		t.Error("bad encrypted address allowed")
+	}
+
+}
+
+// a mod engine that trusts one pubkey and records reports
+type reportModEngine struct {
+	ModEngine
+	trusted  string
+	reported []string
+}
+
+func (self *reportModEngine) AllowDelete(pubkey, msgid string) bool {
+	return pubkey != "" && pubkey == self.trusted
+}
+
+func (self *reportModEngine) Report(msgid, reason string) error {
+	self.reported = append(self.reported, msgid)
+	return nil
+}
+
+func TestModEventReportTrusted(t *testing.T) {
+
+	mod := &reportModEngine{trusted: "mod"}
+	ev := overchanReport("<test@localhost>", "spam")
+	for _, pubkey := range []string{"", "stranger", "mod"} {
+		handleModEvent(mod, pubkey, RemoteDeleteHonor, false, ev, nil)
+	}
+	if len(mod.reported) != 1 {
+		t.Error("reports taken from untrusted pubkeys", mod.reported)
+	}
+
+}
@@ -0,0 +1,148 @@
+//
+// modclient.go -- moderating from the shell, signed ctl messages posted over nntp
+//
+
+package srnd
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/textproto"
+	"strings"
+)
+
+// the mod events for an action from the command line
+func modClientEvents(action string, targets []string) (mm ModMessage, err error) {
+	if len(targets) == 0 {
+		return nil, errors.New("nothing to " + action)
+	}
+	switch action {
+	case "delete":
+		for _, msgid := range targets {
+			if !ValidMessageID(msgid) {
+				return nil, errors.New("bad message-id " + msgid)
+			}
+			mm = append(mm, overchanDelete(msgid))
+		}
+	case ThreadSticky, ThreadLocked, ThreadCycle:
+		// sticky <msgid> [off]
+		on := true
+		if len(targets) == 2 && targets[1] == "off" {
+			on = false
+		} else if len(targets) != 1 {
+			return nil, errors.New("usage: " + action + " message-id [off]")
+		}
+		if !ValidMessageID(targets[0]) {
+			return nil, errors.New("bad message-id " + targets[0])
+		}
+		mm = append(mm, overchanThreadFlag(action, targets[0], on))
+	case "ban":
+		for _, cidr := range targets {
+			if strings.Count(cidr, ":") > 0 {
+				return nil, errors.New("only ipv4 ranges can be banned from the shell, got " + cidr)
+			}
+			mm = append(mm, simpleModEvent("overchan-inet-ban "+cidr))
+		}
+	case "ban-key":
+		for _, pk := range targets {
+			if !validModKey(pk) {
+				return nil, errors.New("bad public key " + pk)
+			}
+			mm = append(mm, overchanBanlist(BanlistPubkey, pk))
+		}
+	case "ban-file":
+		for _, hash := range targets {
+			hash = strings.ToLower(hash)
+			if !validBanlistEntry(BanlistFile, hash) {
+				return nil, errors.New("bad sha512 " + hash)
+			}
+			mm = append(mm, banFile(hash))
+		}
+	default:
+		return nil, errors.New("no such action " + action)
+	}
+	return
+}
+
+// post an article to an nntp server, logging in first if we have a username
+func nntpPost(server, user, passwd string, nntp NNTPMessage) (err error) {
+	c, err := dialBind(server)
+	if err != nil {
+		return
+	}
+	conn := textproto.NewConn(c)
+	defer conn.Close()
+	_, _, err = conn.ReadCodeLine(20)
+	if err == nil && user != "" {
+		err = conn.PrintfLine("AUTHINFO USER %s", user)
+		if err == nil {
+			_, _, err = conn.ReadCodeLine(381)
+		}
+		if err == nil {
+			err = conn.PrintfLine("AUTHINFO PASS %s", passwd)
+		}
+		if err == nil {
+			_, _, err = conn.ReadCodeLine(281)
+		}
+	}
+	if err == nil {
+		err = conn.PrintfLine("POST")
+	}
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(340)
+	}
+	if err != nil {
+		return
+	}
+	w := conn.DotWriter()
+	err = nntp.WriteTo(w)
+	w.Close()
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(240)
+	}
+	conn.PrintfLine("QUIT")
+	return
+}
+
+// sign mod events with a local secret key and post them to our daemon or a peer
+// usage: [-key file] [-server addr] [-user name -pass password] delete|sticky|lock|cycle|ban|ban-key|ban-file target ...
+func ModClientTool(args []string) (err error) {
+	flags := flag.NewFlagSet("mod", flag.ExitOnError)
+	keyfile := flags.String("key", "mod.key", "file with the hex secret key to sign with, see srnd keygen")
+	server := flags.String("server", "", "nntp server to post to, host:port or unix:/path, empty for the bind in srnd.ini")
+	user := flags.String("user", "", "nntp login, srnd takes posts from logged in users only")
+	passwd := flags.String("pass", "", "nntp password")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		return errors.New("usage: mod [-key file] [-server addr] [-user name -pass password] delete|sticky|lock|cycle|ban|ban-key|ban-file target ...")
+	}
+	mm, err := modClientEvents(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(*keyfile)
+	if err != nil {
+		return
+	}
+	seed := unhex(strings.TrimSpace(string(data)))
+	if len(seed) != 32 {
+		return errors.New("no secret key in " + *keyfile)
+	}
+	if *server == "" {
+		conf := ReadConfig()
+		if conf == nil {
+			return errors.New("cannot load config")
+		}
+		*server = conf.daemon["bind"]
+	}
+	nntp, err := signArticle(wrapModMessage(mm), seed)
+	if err == nil {
+		err = nntpPost(*server, *user, *passwd, nntp)
+	}
+	if err == nil {
+		log.Println("posted", nntp.MessageID(), "to", *server)
+	}
+	return
+}
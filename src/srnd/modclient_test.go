@@ -0,0 +1,24 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestModClientEvents(t *testing.T) {
+
+	mm, err := modClientEvents("delete", []string{"<a@test>", "<b@test>"})
+	if err != nil || len(mm) != 2 || mm[0].String() != "delete <a@test>" {
+		t.Error("bad delete", mm, err)
+	}
+	mm, err = modClientEvents(ThreadSticky, []string{"<a@test>", "off"})
+	if err != nil || len(mm) != 1 || mm[0].String() != "sticky <a@test> off" {
+		t.Error("bad sticky", mm, err)
+	}
+	if _, err = modClientEvents("delete", []string{"nope"}); err == nil {
+		t.Error("bad message-ids should not be posted")
+	}
+	if _, err = modClientEvents("explode", []string{"<a@test>"}); err == nil {
+		t.Error("unknown actions should fail")
+	}
+
+}
@@ -26,6 +26,13 @@ type AttachmentModel interface {
 	Source() string
 	Filename() string
 	Hash() string
+	// the poster asked for it to be hidden until clicked
+	Spoiler() bool
+	// browsers can play it in an <audio> or <video>, the thumbnail is the poster
+	IsAudio() bool
+	IsVideo() bool
+	// mime type for the player's <source>
+	MimeType() string
 }
 
 // for individual posts
@@ -38,6 +45,7 @@ type PostModel interface {
 	PostHash() string
 	ShortHash() string
 	PostURL() string
+	ReportURL() string
 	Frontend() string
 	Subject() string
 	Name() string
@@ -48,6 +56,8 @@ type PostModel interface {
 	Board() string
 	Sage() bool
 	Pubkey() string
+	// classic or secure tripcode the poster put after their name, empty if none
+	Trip() string
 	Reference() string
 	ReferenceHash() string
 
@@ -78,6 +88,10 @@ type PostModel interface {
 	Index() int
 	// set post index
 	SetIndex(idx int)
+
+	// links to the posts that quote this one
+	Backlinks() []LinkModel
+	SetBacklinks(links []LinkModel)
 }
 
 // interface for models that have a navbar
@@ -99,6 +113,8 @@ type ThreadModel interface {
 	// return a short version of the thread
 	// does not include all replies
 	Truncate() ThreadModel
+	// set how many replies Truncate keeps and the counts from the database it reports omitted posts from
+	SetPreview(replies int, counts ThreadCounts)
 
 	// number of posts in this thread
 	PostCount() int
@@ -115,6 +131,13 @@ type ThreadModel interface {
         // returns true if this thread has truncated images
         HasOmittedImages() bool
 
+	// is this thread sticky?
+	IsSticky() bool
+	// is this thread locked?
+	IsLocked() bool
+	// does this thread drop its oldest replies?
+	IsCycle() bool
+
 	// update the thread's replies
 	Update(db Database)
 	// is this thread dirty and needing updating?
@@ -133,6 +156,9 @@ type BoardModel interface {
 	Name() string
 	Threads() []ThreadModel
 
+	// url of a random banner for this board
+	Banner() string
+
 	AllowFiles() bool
 	SetAllowFiles(files bool)
 
@@ -166,6 +192,7 @@ type CatalogModel interface {
 type CatalogItemModel interface {
 	OP() PostModel
 	ReplyCount() string
+	ImageCount() string
 	Page() string
 }
 
@@ -201,6 +228,17 @@ type boardPageRow struct {
 	Hour  int64
 	Day   int64
 	All   int64
+	Threads     int64
+	LastPost    int64
+	Description string
+}
+
+// when the board last got a post, formatted
+func (self boardPageRow) LastActivity() string {
+	if self.LastPost <= 0 {
+		return ""
+	}
+	return time.Unix(self.LastPost, 0).UTC().Format(time.RFC1123)
 }
 
 type boardPageRows []boardPageRow
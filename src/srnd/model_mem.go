@@ -8,6 +8,7 @@ package srnd
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -24,6 +25,7 @@ type catalogModel struct {
 type catalogItemModel struct {
 	page       int
 	replycount int
+	imagecount int
 	op         PostModel
 }
 
@@ -42,7 +44,7 @@ func (self *catalogModel) Navbar() string {
 }
 
 func (self *catalogModel) MarshalJSON() (b []byte, err error) {
-	return nil, nil
+	return json.Marshal(self.threads)
 }
 
 func (self *catalogModel) Frontend() string {
@@ -73,6 +75,19 @@ func (self *catalogItemModel) ReplyCount() string {
 	return strconv.Itoa(self.replycount)
 }
 
+func (self *catalogItemModel) ImageCount() string {
+	return strconv.Itoa(self.imagecount)
+}
+
+func (self *catalogItemModel) MarshalJSON() (b []byte, err error) {
+	return json.Marshal(map[string]interface{}{
+		"op":      self.op,
+		"page":    self.page,
+		"replies": self.replycount,
+		"images":  self.imagecount,
+	})
+}
+
 type boardModel struct {
 	allowFiles bool
 	frontend   string
@@ -128,6 +143,10 @@ func (self *boardModel) Board() string {
 	return self.board
 }
 
+func (self *boardModel) Banner() string {
+	return self.prefix + "banner/" + self.board
+}
+
 func (self *boardModel) PageList() []LinkModel {
 	var links []LinkModel
 	for i := 0; i < self.pages; i++ {
@@ -185,9 +204,17 @@ func (self *boardModel) Update(db Database) {
 	perpage, _ := db.GetThreadsPerPage(self.board)
 	// refetch all on this page
 	model := db.GetGroupForPage(self.prefix, self.frontend, self.board, self.page, int(perpage))
+	settings, _ := db.GetBoardSettings(self.board)
+	counts, err := db.GetGroupThreadCounts(self.board)
+	if err != nil {
+		log.Println("failed to get thread counts for", self.board, err)
+	}
 	for _, th := range model.Threads() {
 		// XXX: do we really need to update it again?
 		th.Update(db)
+		if c, ok := counts[th.OP().MessageID()]; ok {
+			th.SetPreview(settings.PreviewReplies, c)
+		}
 	}
 	self.threads = model.Threads()
 }
@@ -219,6 +246,9 @@ type post struct {
 	PostPrefix       string
 	index            int
 	Type             string
+	backlinks        []LinkModel
+	// urls of the posts quoting this one
+	QuotedBy []string
 }
 
 func (self *post) Index() int {
@@ -248,6 +278,10 @@ func (self *post) MarshalJSON() (b []byte, err error) {
 	}
 	self.PostMarkup = self.RenderPost()
 	self.PostPrefix = self.Prefix()
+	self.QuotedBy = nil
+	for _, l := range self.backlinks {
+		self.QuotedBy = append(self.QuotedBy, l.LinkURL())
+	}
 	// for liveui
 	self.Type = "Post"
 	self.Newsgroup = self.board
@@ -255,9 +289,10 @@ func (self *post) MarshalJSON() (b []byte, err error) {
 }
 
 type attachment struct {
-	prefix string
-	Path   string
-	Name   string
+	prefix  string
+	Path    string
+	Name    string
+	Spoiled bool `json:"Spoiler"`
 }
 
 func (self *attachment) MarshalJSON() (b []byte, err error) {
@@ -284,6 +319,22 @@ func (self *attachment) Filename() string {
 	return self.Name
 }
 
+func (self *attachment) Spoiler() bool {
+	return self.Spoiled
+}
+
+func (self *attachment) IsAudio() bool {
+	return mediaPlayerFor(self.Path).element == "audio"
+}
+
+func (self *attachment) IsVideo() bool {
+	return mediaPlayerFor(self.Path).element == "video"
+}
+
+func (self *attachment) MimeType() string {
+	return mediaPlayerFor(self.Path).mime
+}
+
 func PostModelFromMessage(parent, prefix string, nntp NNTPMessage) PostModel {
 	p := new(post)
 	p.PostName = nntp.Name()
@@ -335,6 +386,10 @@ func (self *post) Pubkey() string {
 	return ""
 }
 
+func (self *post) Trip() string {
+	return nameTripcode(self.PostName)
+}
+
 func (self *post) Sage() bool {
 	return self.sage
 }
@@ -399,6 +454,10 @@ func (self *post) PostURL() string {
 	return fmt.Sprintf("%sthread-%s.html#%s", self.Prefix(), HashMessageID(self.Parent), self.PostHash())
 }
 
+func (self *post) ReportURL() string {
+	return fmt.Sprintf("%sreport/%s", self.Prefix(), self.PostHash())
+}
+
 func (self *post) Prefix() string {
 	if len(self.prefix) == 0 {
 		// fall back if not set
@@ -471,8 +530,25 @@ func (self *post) Truncate() PostModel {
 	}
 }
 
+func (self *post) Backlinks() []LinkModel {
+	return self.backlinks
+}
+
+func (self *post) SetBacklinks(links []LinkModel) {
+	self.backlinks = links
+}
+
+// previews for links in the message to sites we allow
+func (self *post) LinkPreviews() []linkPreview {
+	root := self.Parent
+	if root == "" {
+		root = self.Message_id
+	}
+	return linkPreviews.For(self.board, root, self.PostMessage)
+}
+
 func (self *post) RenderShortBody() string {
-	return memeposting(self.PostMessage, self.prefix)
+	return renderMarkup(self.PostMessage, self.prefix, self.board)
 }
 
 func (self *post) RenderBodyPre() string {
@@ -482,7 +558,7 @@ func (self *post) RenderBodyPre() string {
 func (self *post) RenderBody() string {
 	// :^)
 	if len(self.message_rendered) == 0 {
-		self.message_rendered = memeposting(self.PostMessage, self.prefix)
+		self.message_rendered = renderMarkup(self.PostMessage, self.prefix, self.board)
 	}
 	return self.message_rendered
 }
@@ -495,8 +571,24 @@ type thread struct {
 	dirty               bool
 	truncatedPostCount  int
 	truncatedImageCount int
+	sticky              bool
+	locked              bool
+	cycle               bool
+	// replies before the first one we have, for pages of a thread
+	offset int
+	// replies kept by Truncate and the thread's counts, nil for the default
+	preview *threadPreview
 }
 
+// how a thread is shown on its board's pages
+type threadPreview struct {
+	replies int
+	counts  ThreadCounts
+}
+
+// replies a thread shows on board pages unless the board says otherwise
+const defaultPreviewReplies = 5
+
 func (self *thread) MarshalJSON() (b []byte, err error) {
 	posts := []PostModel{self.OP()}
 	posts = append(posts, self.Replies()...)
@@ -528,6 +620,18 @@ func (self *thread) Board() string {
 	return self.Posts[0].Board()
 }
 
+func (self *thread) IsSticky() bool {
+	return self.sticky
+}
+
+func (self *thread) IsLocked() bool {
+	return self.locked
+}
+
+func (self *thread) IsCycle() bool {
+	return self.cycle
+}
+
 func (self *thread) BoardURL() string {
 	return fmt.Sprintf("%s%s-0.html", self.Prefix(), self.Board())
 }
@@ -574,7 +678,7 @@ func (self *thread) Replies() []PostModel {
 		// inject post index
 		for idx, post := range self.Posts[1:] {
 			if post != nil {
-				post.SetIndex(idx + 1)
+				post.SetIndex(self.offset + idx + 1)
 				replies = append(replies, post)
 			}
 		}
@@ -591,22 +695,47 @@ func (self *thread) SetAllowFiles(allow bool) {
 	self.allowFiles = allow
 }
 
+func (self *thread) SetPreview(replies int, counts ThreadCounts) {
+	self.preview = &threadPreview{
+		replies: replies,
+		counts:  counts,
+	}
+}
+
 func (self *thread) Truncate() ThreadModel {
-	trunc := 5
-	if len(self.Posts) > trunc {
+	trunc := defaultPreviewReplies
+	replies := int64(len(self.Posts) - 1)
+	images := int64(self.ImageCount())
+	if self.preview != nil {
+		trunc = self.preview.replies
+		// the database knows about replies we may not have loaded
+		replies = self.preview.counts.Replies
+		images = self.preview.counts.Images
+	}
+	if len(self.Posts)-1 > trunc || replies > int64(trunc) {
+		keep := trunc
+		if keep > len(self.Posts)-1 {
+			keep = len(self.Posts) - 1
+		}
 		t := &thread{
 			allowFiles: self.allowFiles,
 			links:      self.links,
-			Posts:      append([]PostModel{self.Posts[0]}, self.Posts[len(self.Posts)-trunc:]...),
+			Posts:      append([]PostModel{self.Posts[0]}, self.Posts[len(self.Posts)-keep:]...),
 			prefix:     self.prefix,
 			dirty:      false,
+			sticky:     self.sticky,
+			locked:     self.locked,
+			cycle:      self.cycle,
+			offset:     int(replies) - keep,
 		}
 		imgs := 0
 		for _, p := range t.Posts {
 			imgs += p.NumAttachments()
 		}
-		t.truncatedPostCount = len(self.Posts) - trunc
-		t.truncatedImageCount = self.ImageCount() - imgs
+		t.truncatedPostCount = int(replies) - keep
+		if missing := images - int64(imgs); missing > 0 {
+			t.truncatedImageCount = int(missing)
+		}
 		return t
 	}
 	return self
@@ -631,10 +760,33 @@ func (self *thread) HasOmittedImages() bool {
 func (self *thread) Update(db Database) {
 	root := self.Posts[0].MessageID()
 	self.Posts = append([]PostModel{self.Posts[0]}, db.GetThreadReplyPostModels(self.prefix, root, 0, 0)...)
+	self.sticky = db.CheckThreadFlag(root, ThreadSticky)
+	self.locked = db.CheckThreadFlag(root, ThreadLocked)
+	self.cycle = db.CheckThreadFlag(root, ThreadCycle)
+	for _, p := range self.Posts {
+		loadBacklinks(p, db)
+	}
 	self.dirty = false
 	updateLinkCacheForThread(self)
 }
 
+// put links to the posts quoting p into it
+func loadBacklinks(p PostModel, db Database) {
+	quoting, err := db.GetBacklinks(p.MessageID())
+	if err != nil {
+		log.Println("failed to get backlinks for", p.MessageID(), err)
+		return
+	}
+	var links []LinkModel
+	for _, q := range quoting {
+		links = append(links, linkModel{
+			text: ">>" + ShorterHashMessageID(q.MessageID),
+			link: q.URL(p.Prefix()),
+		})
+	}
+	p.SetBacklinks(links)
+}
+
 type linkModel struct {
 	text string
 	link string
@@ -0,0 +1,28 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestThreadTruncate(t *testing.T) {
+
+	th := &thread{Posts: []PostModel{&post{}}}
+	for idx := 0; idx < 8; idx++ {
+		th.Posts = append(th.Posts, &post{})
+	}
+	short := th.Truncate()
+	if len(short.Replies()) != defaultPreviewReplies || short.MissingPostCount() != 3 {
+		t.Error("bad default preview", len(short.Replies()), short.MissingPostCount())
+	}
+	th.SetPreview(2, ThreadCounts{Replies: 10, Images: 4})
+	short = th.Truncate()
+	if len(short.Replies()) != 2 || short.MissingPostCount() != 8 || short.MissingImageCount() != 4 {
+		t.Error("preview should use the board's reply count and the database counts", len(short.Replies()), short.MissingPostCount(), short.MissingImageCount())
+	}
+	th.Posts = th.Posts[:6]
+	th.preview = nil
+	if th.Truncate().HasOmittedReplies() {
+		t.Error("threads with no more replies than the preview should not omit any")
+	}
+
+}
@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,8 +17,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+var FeedNotResponding = errors.New("feed did not answer keepalive")
+
 type nntpStreamEvent string
 
 func (ev nntpStreamEvent) MessageID() string {
@@ -58,11 +62,13 @@ type nntpConnection struct {
 	selected_article string
 	// the policy for federation
 	policy FeedPolicy
+	// guards policy, the daemon changes it on reload
+	policy_access sync.RWMutex
 	// lock help when expecting non pipelined activity
 	access sync.Mutex
 
 	// ARTICLE <message-id>
-	article chan string
+	article chan ArticleEntry
 	// CHECK <message-id>
 	check chan syncEvent
 	// TAKETHIS <message-id>
@@ -80,12 +86,45 @@ type nntpConnection struct {
 	authenticated bool
 	// the username that is authenticated
 	username string
+	// what the login may do, empty if we did not log in with a username and may do everything
+	role string
 	// send a channel down this channel to be informed when streaming/reader dies when commanded by QuitAndWait()
 	die chan chan bool
 	// remote address of this connections
 	addr net.Addr
 	// pending backlog of bytes to transfer
 	backlog int64
+	// health counters of the feed we belong to, nil for inbound
+	stats *feedStats
+	// pull priority of the feed we belong to
+	priority int
+	// how long we stay idle before probing the remote end, 0 for never
+	keepalive time.Duration
+	// tells the streaming writer to send a keepalive probe
+	probe chan bool
+}
+
+// can we take POST from this connection?
+func (self *nntpConnection) canPost() bool {
+	return self.authenticated && self.role != NNTPRoleReader
+}
+
+// can we take IHAVE and streaming from this connection?
+func (self *nntpConnection) canFeed() bool {
+	return self.authenticated && (self.role == "" || self.role == NNTPRoleFeeder)
+}
+
+// does this connection's policy let this newsgroup through?
+func (self *nntpConnection) allowsNewsgroup(newsgroup string) bool {
+	self.policy_access.RLock()
+	defer self.policy_access.RUnlock()
+	return self.policy.AllowsNewsgroup(newsgroup)
+}
+
+func (self *nntpConnection) setPolicy(policy FeedPolicy) {
+	self.policy_access.Lock()
+	self.policy = policy
+	self.policy_access.Unlock()
 }
 
 // get message backlog in bytes
@@ -105,6 +144,7 @@ func (self *nntpConnection) MarshalJSON() (data []byte, err error) {
 	jmap["mode"] = self.mode
 	jmap["name"] = self.name
 	jmap["authed"] = self.authenticated
+	jmap["role"] = self.role
 	jmap["group"] = self.group
 	jmap["backlog"] = self.backlog
 	data, err = json.Marshal(jmap)
@@ -118,9 +158,10 @@ func createNNTPConnection(addr string) *nntpConnection {
 	}
 	return &nntpConnection{
 		hostname: host,
-		article:  make(chan string, 1024),
+		article:  make(chan ArticleEntry, 1024),
 		takethis: make(chan syncEvent, 1024),
 		check:    make(chan syncEvent, 1024),
+		probe:    make(chan bool, 1),
 		pending:  make(map[string]syncEvent),
 	}
 }
@@ -345,6 +386,18 @@ func (self *nntpConnection) messageSetProcessed(msgid string) {
 	self.pending_access.Unlock()
 }
 
+// remember that our feed's peer has or refused this article so we don't offer it again
+func (self *nntpConnection) rememberOffered(daemon *NNTPDaemon, msgid string) {
+	if self.feedname == "" || !ValidMessageID(msgid) {
+		// inbound connection
+		return
+	}
+	err := daemon.database.MarkArticleOffered(self.feedname, msgid)
+	if err != nil {
+		log.Println(self.name, "failed to remember offer of", msgid, err)
+	}
+}
+
 // handle streaming events
 // this function should send only
 func (self *nntpConnection) handleStreaming(daemon *NNTPDaemon, conn *textproto.Conn) (err error) {
@@ -361,6 +414,9 @@ func (self *nntpConnection) handleStreaming(daemon *NNTPDaemon, conn *textproto.
 		case ev := <-self.takethis:
 			self.messageSetPendingState(ev.msgid, "takethis", ev.sz)
 			err = self.handleStreamEvent(nntpTAKETHIS(ev.msgid), daemon, conn)
+		case <-self.probe:
+			// no-op, the reply is consumed by handleLine
+			err = conn.PrintfLine("MODE STREAM")
 		}
 	}
 	return
@@ -392,6 +448,8 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 	is_signed := pubkey != ""
 	is_ctl := newsgroup == "ctl" && is_signed
 	anon_poster := torposter != "" || i2paddr != "" || encaddr == ""
+	allow_attachments := daemon.allowAttachments(newsgroup)
+	posting := daemon.postingConfig()
 
 	if !newsgroupValidFormat(newsgroup) {
 		// invalid newsgroup format
@@ -420,6 +478,10 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 		reason = "thread banned"
 		ban = true
 		return
+	} else if reference != "" && daemon.database.CheckThreadFlag(reference, ThreadLocked) {
+		reason = "thread locked"
+		// don't ban, they may not have gotten the lock yet
+		return
 	} else if daemon.database.HasArticleLocal(msgid) {
 		// we already have this article locally
 		reason = "have this article locally"
@@ -435,11 +497,11 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 		return
 	} else if anon_poster {
 		// this was posted anonymously
-		if daemon.allow_anon {
+		if posting.allow_anon {
 			if has_attachment {
 				// this has attachment
-				if daemon.allow_anon_attachments {
-					if daemon.allow_attachments {
+				if posting.allow_anon_attachments {
+					if allow_attachments {
 						// we'll allow anon attachments
 						return
 					} else {
@@ -482,7 +544,7 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 			log.Println(self.name, "wtf? invalid article")
 		}
 	}
-	if !daemon.allow_attachments {
+	if !allow_attachments {
 		// we don't want attachments
 		if is_ctl {
 			// ctl is fine
@@ -500,6 +562,17 @@ func (self *nntpConnection) checkMIMEHeaderNoAuth(daemon *NNTPDaemon, hdr textpr
 	return
 }
 
+// drop the body of an article we rejected, or keep it for the mods if our filters or bans
+// rejected it and we were not already banning it
+func (self *nntpConnection) discardRejected(daemon *NNTPDaemon, hdr textproto.MIMEHeader, body io.Reader, reason string, ban bool) (err error) {
+	msgid := getMessageID(hdr)
+	if ban && daemon.postingConfig().keep_rejected && ValidMessageID(msgid) && !daemon.database.ArticleBanned(msgid) {
+		return daemon.divertRejected(hdr, body, reason, self.feedname)
+	}
+	_, err = io.Copy(ioutil.Discard, body)
+	return
+}
+
 // store message, unpack attachments, register with daemon, send to daemon for federation
 // in that order
 func (self *nntpConnection) storeMessage(daemon *NNTPDaemon, hdr textproto.MIMEHeader, body io.Reader) (err error) {
@@ -529,14 +602,21 @@ func (self *nntpConnection) storeMessage(daemon *NNTPDaemon, hdr textproto.MIMEH
 	// now store attachments and article
 	err = writeMIMEHeader(f, hdr)
 	if err == nil {
-		err = daemon.store.ProcessMessageBody(f, hdr, body)
+		err = daemon.store.ProcessMessageBody(f, hdr, body, daemon.intakeHold(self.feedname))
 		if err == nil {
+			if hdr.Get("Newsgroups") == "ctl" {
+				daemon.rememberCtlPeer(msgid, self.feedname)
+			}
 			// tell daemon
 			daemon.loadFromInfeed(msgid)
 		}
 	}
 	f.Close()
-	if err != nil {
+	if err == ArticleQuarantined {
+		// keep it on disk for the mods to look at
+		log.Println(self.name, "quarantined", msgid)
+		err = nil
+	} else if err != nil {
 		// clean up
 		if ValidMessageID(msgid) {
 			DelFile(daemon.store.GetFilename(msgid))
@@ -562,9 +642,11 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 	} else if code == 239 {
 		// successful TAKETHIS
 		log.Println(msgid, "sent via", self.name)
+		sz, _ := daemon.store.GetMessageSize(msgid)
+		self.stats.Sent(sz)
 		self.messageSetProcessed(msgid)
+		self.rememberOffered(daemon, msgid)
 		return
-		// TODO: remember success
 	} else if code == 431 {
 		// CHECK said we would like this article later
 		self.messageSetProcessed(msgid)
@@ -572,11 +654,13 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 		// TAKETHIS failed
 		log.Println(msgid, "was not sent to", self.name, "denied:", line)
 		self.messageSetProcessed(msgid)
-		// TODO: remember denial
+		self.rememberOffered(daemon, msgid)
 	} else if code == 438 {
 		// they don't want the article
-		// TODO: remeber rejection
 		self.messageSetProcessed(msgid)
+		self.rememberOffered(daemon, msgid)
+	} else if code == 203 {
+		// reply to our keepalive probe
 	} else {
 		// handle command
 		parts := strings.Split(line, " ")
@@ -588,12 +672,12 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 					// reader mode
 					self.mode = "READER"
 					log.Println(self.name, "switched to reader mode")
-					if self.authenticated {
+					if self.canPost() {
 						conn.PrintfLine("200 Posting Permitted")
 					} else {
 						conn.PrintfLine("201 No posting Permitted")
 					}
-				} else if mode == "STREAM" && self.authenticated {
+				} else if mode == "STREAM" && self.canFeed() {
 					// wut? we're already in streaming mode
 					log.Println(self.name, "already in streaming mode")
 					conn.PrintfLine("203 Streaming enabled brah")
@@ -623,13 +707,15 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 						} else {
 							// try login
 							var valid bool
-							valid, err = daemon.database.CheckNNTPUserExists(self.username)
-							if valid {
+							var user *NNTPUser
+							user, err = daemon.database.GetNNTPUser(self.username)
+							if user != nil && !user.Disabled {
 								valid, err = daemon.database.CheckNNTPLogin(self.username, line[14:])
 							}
 							if valid {
 								// valid login
 								self.authenticated = true
+								self.role = user.Role
 								conn.PrintfLine("281 Authentication accepted")
 							} else if err == nil {
 								// invalid login
@@ -680,7 +766,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 						// discard, we do not want
 						code = 439
 						log.Println(self.name, "rejected", msgid, reason)
-						_, err = io.Copy(ioutil.Discard, r)
+						err = self.discardRejected(daemon, hdr, r, reason, ban)
 						if ban {
 							err = daemon.database.BanArticle(msgid, reason)
 						}
@@ -744,8 +830,8 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 					conn.PrintfLine("430 %s", msgid)
 				}
 			} else if cmd == "IHAVE" {
-				if !self.authenticated {
-					conn.PrintfLine("483 You have not authenticated")
+				if !self.canFeed() {
+					conn.PrintfLine("483 You have not authenticated as a feeder")
 				} else {
 					// handle IHAVE command
 					msgid := parts[1]
@@ -765,7 +851,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 							if len(reason) > 0 {
 								// discard, we do not want
 								log.Println(self.name, "rejected", msgid, reason)
-								_, err = io.Copy(ioutil.Discard, r)
+								err = self.discardRejected(daemon, hdr, r, reason, ban)
 								if ban {
 									_ = daemon.database.BanArticle(msgid, reason)
 								}
@@ -1049,7 +1135,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 				}
 				dw.Close()
 			} else if line == "POST" {
-				if !self.authenticated {
+				if !self.canPost() {
 					// needs tls to work if not logged in
 					conn.PrintfLine("440 Posting Not Allowed")
 				} else {
@@ -1091,6 +1177,7 @@ func (self *nntpConnection) handleLine(daemon *NNTPDaemon, code int, line string
 							}
 							if success && daemon.database.HasNewsgroup(newsgroup) {
 								err = self.storeMessage(daemon, hdr, r)
+								gotten = err == nil
 							}
 						}
 					}
@@ -1220,13 +1307,14 @@ func (self *nntpConnection) scrapeGroup(daemon *NNTPDaemon, conn *textproto.Conn
 }
 
 // ask for an article from the remote server
-func (self *nntpConnection) askForArticle(msgid string) {
+func (self *nntpConnection) askForArticle(e ArticleEntry) {
+	msgid := e.MessageID()
 	if self.messageIsQueued(msgid) {
 		// already queued
 	} else {
 		log.Println(self.name, "asking for", msgid)
 		self.messageSetPendingState(msgid, "queued", 0)
-		self.article <- msgid
+		self.article <- e
 	}
 }
 
@@ -1324,14 +1412,17 @@ func (self *nntpConnection) requestArticle(daemon *NNTPDaemon, conn *textproto.C
 				if len(reason) > 0 {
 					log.Println(self.name, "discarding", msgid, reason)
 					// we don't want it, discard
-					io.Copy(ioutil.Discard, dr)
+					self.discardRejected(daemon, hdr, dr, reason, ban)
 					if ban {
 						daemon.database.BanArticle(msgid, reason)
 					}
 				} else {
 					// yeh we want it open up a file to store it in
 					err = self.storeMessage(daemon, hdr, dr)
-					if err != nil {
+					if err == nil {
+						sz, _ := daemon.store.GetMessageSize(msgid)
+						self.stats.Received(sz)
+					} else {
 						log.Println(self.name, "failed to obtain article", err)
 						// probably an invalid signature or format
 						daemon.database.BanArticle(msgid, err.Error())
@@ -1354,23 +1445,51 @@ func (self *nntpConnection) requestArticle(daemon *NNTPDaemon, conn *textproto.C
 	return
 }
 
-func (self *nntpConnection) startReader(daemon *NNTPDaemon, conn *textproto.Conn) {
+// send a no-op in reader mode and wait for the reply
+func (self *nntpConnection) probeReader(nconn net.Conn, conn *textproto.Conn) (err error) {
+	nconn.SetDeadline(time.Now().Add(self.keepalive))
+	err = conn.PrintfLine("MODE READER")
+	if err == nil {
+		_, _, err = conn.ReadCodeLine(2)
+	}
+	nconn.SetDeadline(time.Time{})
+	return
+}
+
+func (self *nntpConnection) startReader(daemon *NNTPDaemon, nconn net.Conn, conn *textproto.Conn) {
 	log.Println(self.name, "run reader mode")
 	for {
 		var err error
+		var idle <-chan time.Time
+		if self.keepalive > 0 {
+			idle = time.After(self.keepalive)
+		}
 		select {
+		case <-idle:
+			err = self.probeReader(nconn, conn)
+			if err != nil {
+				log.Println(self.name, "keepalive failed, dropping connection:", err)
+				self.stats.Error(FeedNotResponding)
+				conn.Close()
+				return
+			}
 		case chnl := <-self.die:
 			// we were asked to die
 			// send quit
 			conn.PrintfLine("QUIT")
 			chnl <- true
 			break
-		case msgid := <-self.article:
+		case e := <-self.article:
 			// next article to ask for
+			msgid := e.MessageID()
 			log.Println(self.name, "obtaining", msgid)
 			self.messageSetPendingState(msgid, "article", 0)
 			err = self.requestArticle(daemon, conn, msgid)
 			self.messageSetProcessed(msgid)
+			if !daemon.store.HasArticle(msgid) && !daemon.database.ArticleBanned(msgid) {
+				// we didn't get it, try feeds we like less
+				daemon.askForArticleFallback(e, self.priority)
+			}
 			if err != nil {
 				log.Println(self.name, "error while in reader mode:", err)
 				break
@@ -1420,7 +1539,7 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 			success, err = self.modeSwitch("READER", conn)
 			if success {
 				self.mode = "READER"
-				self.startReader(daemon, conn)
+				self.startReader(daemon, nconn, conn)
 				return
 			}
 		}
@@ -1436,8 +1555,38 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 		}
 	}
 
+	// only probe outbound feeds
+	var keepalive time.Duration
+	if !inbound {
+		keepalive = self.keepalive
+	}
+	probing := false
+
 	for err == nil {
+		if keepalive > 0 {
+			nconn.SetReadDeadline(time.Now().Add(keepalive))
+		}
 		line, err = conn.ReadLine()
+		if keepalive > 0 {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				if probing {
+					// they did not answer our last probe
+					err = FeedNotResponding
+					break
+				}
+				// idle for too long, see if they are still there
+				probing = true
+				select {
+				case self.probe <- true:
+				default:
+					// a probe is already queued
+				}
+				err = nil
+				continue
+			} else if err == nil {
+				probing = false
+			}
+		}
 		if inbound && strings.HasPrefix(line, "QUIT") {
 			conn.PrintfLine("205 bai")
 			conn.Close()
@@ -1485,7 +1634,7 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 							// we'll allow posting for reader
 							conn.PrintfLine("200 Posting is Permitted awee yeh")
 						} else if mode == "STREAM" {
-							if !self.authenticated {
+							if !self.canFeed() {
 								conn.PrintfLine("483 Streaming Denied")
 							} else {
 								// set streaming mode
@@ -1538,6 +1687,7 @@ func (self *nntpConnection) runConnection(daemon *NNTPDaemon, inbound, stream, r
 	}
 	if err != io.EOF {
 		log.Println(self.name, "got error", err)
+		self.stats.Error(err)
 		if !inbound && conn != nil {
 			// send quit on outbound
 			conn.PrintfLine("QUIT")
@@ -0,0 +1,26 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestNNTPRoles(t *testing.T) {
+
+	for _, tc := range []struct {
+		authed     bool
+		role       string
+		post, feed bool
+	}{
+		{false, "", false, false},
+		{true, "", true, true},
+		{true, NNTPRoleReader, false, false},
+		{true, NNTPRolePoster, true, false},
+		{true, NNTPRoleFeeder, true, true},
+	} {
+		c := &nntpConnection{authenticated: tc.authed, role: tc.role}
+		if c.canPost() != tc.post || c.canFeed() != tc.feed {
+			t.Error("bad permissions for", tc.authed, tc.role)
+		}
+	}
+
+}
@@ -0,0 +1,79 @@
+//
+// noko.go -- sending posters to their new post once we have it
+//
+
+package srnd
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// how many times /posted/ has the browser check back before giving up and going to the thread anyway
+const postedMaxTries = 15
+
+// return true if hash is a message-id hash
+func validMessageIDHash(hash string) bool {
+	if len(hash) != 40 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// does the poster want to stay in the thread? nonoko in the options field sends them to the board
+func wantsNoko(options string) bool {
+	return !strings.Contains(strings.ToLower(options), "nonoko")
+}
+
+// url of a post in its thread
+func postAnchorURL(prefix, msgid, root string) string {
+	return fmt.Sprintf("%sthread-%s.html#%s", prefix, HashMessageID(root), HashMessageID(msgid))
+}
+
+// where to send a poster after a post, it waits for the post to be processed first
+func postedURL(prefix, msgid, root, group string, noko bool) string {
+	q := url.Values{}
+	q.Set("thread", HashMessageID(root))
+	if !noko {
+		q.Set("board", group)
+	}
+	return prefix + "posted/" + HashMessageID(msgid) + "?" + q.Encode()
+}
+
+// GET /posted/{hash}?thread=roothash
+// articles are processed after we answer the post, so wait here until the post is in before going to it
+func (self *httpFrontend) handle_posted(wr http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	q := r.URL.Query()
+	thread := q.Get("thread")
+	if !validMessageIDHash(hash) || !validMessageIDHash(thread) {
+		http.Redirect(wr, r, self.prefix, http.StatusFound)
+		return
+	}
+	target := fmt.Sprintf("%sthread-%s.html#%s", self.prefix, thread, hash)
+	if board := q.Get("board"); newsgroupValidFormat(board) {
+		target = fmt.Sprintf("%s%s-0.html", self.prefix, board)
+	}
+	tries, _ := strconv.Atoi(q.Get("tries"))
+	e, err := self.daemon.database.GetMessageIDByHash(hash)
+	if (err == nil && e.MessageID() != "") || tries >= postedMaxTries {
+		http.Redirect(wr, r, target, http.StatusFound)
+		return
+	}
+	q.Set("tries", strconv.Itoa(tries+1))
+	wr.Header().Set("Refresh", "1; url="+self.prefix+"posted/"+hash+"?"+q.Encode())
+	wr.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	wr.Header().Set("Cache-Control", "no-store")
+	wr.WriteHeader(http.StatusAccepted)
+	io.WriteString(wr, "your post is being processed, you will be taken to it in a moment\n")
+}
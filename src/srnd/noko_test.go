@@ -0,0 +1,19 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestWantsNoko(t *testing.T) {
+
+	if !wantsNoko("") || !wantsNoko("noko") || !wantsNoko("sage") {
+		t.Error("posters should stay in the thread by default")
+	}
+	if wantsNoko("NoNoko") {
+		t.Error("nonoko should send posters to the board")
+	}
+	if !validMessageIDHash(HashMessageID("<test@example.tld>")) || validMessageIDHash("../../etc") {
+		t.Error("bad message-id hash check")
+	}
+
+}
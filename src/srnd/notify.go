@@ -0,0 +1,188 @@
+//
+// notify.go -- tell mods about things waiting for them over webhooks and email
+//
+
+package srnd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// things mods get told about
+const (
+	// someone reported a post
+	NotifyReport = "report"
+	// the spam filter or flood detection held back a post
+	NotifyQuarantine = "quarantine"
+	// flood detection banned an address
+	NotifyAutoBan = "autoban"
+)
+
+// something a mod should look at
+type ModNotification struct {
+	Event     string `json:"event"`
+	Newsgroup string `json:"newsgroup,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	// encrypted address for bans
+	Target string `json:"target,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Time   int64  `json:"time"`
+}
+
+// one line summary for email subjects
+func (self ModNotification) Subject() string {
+	switch self.Event {
+	case NotifyReport:
+		return fmt.Sprintf("report on %s in %s", self.MessageID, self.Newsgroup)
+	case NotifyQuarantine:
+		return fmt.Sprintf("%s quarantined in %s", self.MessageID, self.Newsgroup)
+	case NotifyAutoBan:
+		return fmt.Sprintf("%s banned automatically", self.Target)
+	}
+	return self.Event
+}
+
+// plain text body for emails
+func (self ModNotification) Text() string {
+	var lines []string
+	lines = append(lines, self.Subject())
+	if len(self.Reason) > 0 {
+		lines = append(lines, "reason: "+self.Reason)
+	}
+	lines = append(lines, "time: "+time.Unix(self.Time, 0).UTC().Format(time.RFC1123))
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// the email we send for a notification
+func notifyMail(from string, to []string, n ModNotification) []byte {
+	var buff bytes.Buffer
+	fmt.Fprintf(&buff, "From: %s\r\n", from)
+	fmt.Fprintf(&buff, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buff, "Subject: [srnd] %s\r\n", strings.Replace(n.Subject(), "\n", " ", -1))
+	fmt.Fprintf(&buff, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buff.WriteString(n.Text())
+	return buff.Bytes()
+}
+
+type modNotifier struct {
+	// events we send, all if empty
+	events map[string]bool
+	// urls we POST json to
+	webhooks []string
+	// smtp server as host:port, no email if empty
+	smtpAddr     string
+	smtpFrom     string
+	smtpTo       []string
+	smtpUser     string
+	smtpPassword string
+	client       *http.Client
+	queue        chan ModNotification
+}
+
+// queue a notification, never blocks and does nothing on a nil notifier
+func (self *modNotifier) Notify(n ModNotification) {
+	if self == nil {
+		return
+	}
+	if len(self.events) > 0 && !self.events[n.Event] {
+		return
+	}
+	if n.Time == 0 {
+		n.Time = timeNow()
+	}
+	select {
+	case self.queue <- n:
+	default:
+		log.Println("notify queue full, dropping", n.Event, "notification")
+	}
+}
+
+// deliver queued notifications forever
+func (self *modNotifier) Run() {
+	for n := range self.queue {
+		for _, url := range self.webhooks {
+			err := self.postWebhook(url, n)
+			if err != nil {
+				log.Println("failed to notify webhook", url, err)
+			}
+		}
+		if len(self.smtpAddr) > 0 && len(self.smtpTo) > 0 {
+			err := self.sendMail(n)
+			if err != nil {
+				log.Println("failed to send notification email via", self.smtpAddr, err)
+			}
+		}
+	}
+}
+
+func (self *modNotifier) postWebhook(url string, n ModNotification) (err error) {
+	var body []byte
+	body, err = json.Marshal(n)
+	if err != nil {
+		return
+	}
+	var resp *http.Response
+	resp, err = self.client.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("webhook replied %s", resp.Status)
+		}
+	}
+	return
+}
+
+func (self *modNotifier) sendMail(n ModNotification) error {
+	var auth smtp.Auth
+	if len(self.smtpUser) > 0 {
+		host, _, _ := net.SplitHostPort(self.smtpAddr)
+		auth = smtp.PlainAuth("", self.smtpUser, self.smtpPassword, host)
+	}
+	return smtp.SendMail(self.smtpAddr, auth, self.smtpFrom, self.smtpTo, notifyMail(self.smtpFrom, self.smtpTo, n))
+}
+
+// split a comma separated list dropping empty entries
+func splitList(str string) (list []string) {
+	for _, s := range strings.Split(str, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) > 0 {
+			list = append(list, s)
+		}
+	}
+	return
+}
+
+// create the notifier from config, nil if disabled or it has nowhere to deliver
+func modNotifierFromConfig(conf *SRNdConfig) *modNotifier {
+	if conf.notify["enable"] != "1" {
+		return nil
+	}
+	n := &modNotifier{
+		events:       make(map[string]bool),
+		webhooks:     splitList(conf.notify["webhooks"]),
+		smtpAddr:     conf.notify["smtp_addr"],
+		smtpFrom:     conf.notify["smtp_from"],
+		smtpTo:       splitList(conf.notify["smtp_to"]),
+		smtpUser:     conf.notify["smtp_user"],
+		smtpPassword: conf.notify["smtp_password"],
+		client:       &http.Client{Timeout: time.Second * 30},
+		queue:        make(chan ModNotification, 128),
+	}
+	for _, ev := range splitList(conf.notify["events"]) {
+		n.events[ev] = true
+	}
+	if len(n.webhooks) == 0 && (len(n.smtpAddr) == 0 || len(n.smtpTo) == 0) {
+		log.Println("notifications enabled but no webhooks or smtp recipients set, disabling")
+		return nil
+	}
+	log.Printf("mod notifications enabled, %d webhooks, %d email recipients", len(n.webhooks), len(n.smtpTo))
+	return n
+}
@@ -0,0 +1,25 @@
+package srnd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotifyMail(t *testing.T) {
+
+	n := ModNotification{Event: NotifyReport, Newsgroup: "overchan.test", MessageID: "<a@localhost>", Reason: "spam", Time: 1}
+	mail := string(notifyMail("srnd@localhost", []string{"a@localhost", "b@localhost"}, n))
+	if !strings.Contains(mail, "To: a@localhost, b@localhost\r\n") {
+		t.Error("bad recipients", mail)
+	}
+	if !strings.Contains(mail, "Subject: [srnd] report on <a@localhost> in overchan.test\r\n") {
+		t.Error("bad subject", mail)
+	}
+	if !strings.Contains(mail, "\r\n\r\nreport on <a@localhost>") || !strings.Contains(mail, "reason: spam\r\n") {
+		t.Error("bad body", mail)
+	}
+	var notify *modNotifier
+	// a nil notifier tells nobody
+	notify.Notify(n)
+
+}
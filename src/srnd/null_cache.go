@@ -90,7 +90,7 @@ func (self *nullHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if !hasgroup {
 			goto notfound
 		}
-		template.genCatalog(self.cache.prefix, self.cache.name, group, w, self.cache.database)
+		template.genCatalog(self.cache.prefix, self.cache.name, group, w, self.cache.database, false)
 		return
 	} else {
 		group, page := getGroupAndPage(file)
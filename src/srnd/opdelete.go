@@ -0,0 +1,77 @@
+//
+// opdelete.go -- posters deleting their own posts with the password they posted with
+//
+
+package srnd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// header with the keyed hash of the poster's delete password
+const deleteHashHeader = "X-Delete-Hash"
+
+// the keyed hash of a delete password for a post, only we can check it
+func opDeleteHash(secret, msgid, password string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msgid))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// delete a post made from this frontend if password matches and it is recent enough
+func (self *httpFrontend) opDelete(msgid, password string, now time.Time) (err error) {
+	if self.opDeleteWindow <= 0 {
+		return errors.New("posters can't delete their posts here")
+	}
+	db := self.daemon.database
+	hdr, err := db.GetHeadersForMessage(msgid)
+	if err != nil || hdr == nil {
+		return errors.New("no such post")
+	}
+	hash := hdr.Get(deleteHashHeader, "")
+	if len(hash) == 0 || len(password) == 0 || !hmac.Equal([]byte(hash), []byte(opDeleteHash(self.secret, msgid, password))) {
+		return errors.New("wrong password")
+	}
+	posted, err := time.Parse(time.RFC1123Z, hdr.Get("Date", ""))
+	if err != nil || now.Sub(posted) > self.opDeleteWindow {
+		return errors.New("too late to delete this post")
+	}
+	if hdr.Get("References", "") == "" && db.CountThreadReplies(msgid) > 0 {
+		return errors.New("threads with replies can't be deleted")
+	}
+	return self.daemon.mod.SelfDeletePost(msgid, self.regenOnModEvent)
+}
+
+// POST /delete with the post's message-id or hash in msgid and the password it was made with
+func (self *httpFrontend) handle_opdelete(wr http.ResponseWriter, r *http.Request) {
+	msgid := r.FormValue("msgid")
+	if !ValidMessageID(msgid) {
+		e, err := self.daemon.database.GetMessageIDByHash(msgid)
+		if err == nil {
+			msgid = e.MessageID()
+		}
+	}
+	resp := make(map[string]interface{})
+	var err error
+	if ValidMessageID(msgid) {
+		err = self.opDelete(msgid, r.FormValue("password"), time.Now())
+	} else {
+		err = errors.New("no such post")
+	}
+	if err == nil {
+		resp["deleted"] = msgid
+	} else {
+		wr.WriteHeader(403)
+		resp["error"] = err.Error()
+	}
+	wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
+	json.NewEncoder(wr).Encode(resp)
+}
@@ -0,0 +1,90 @@
+package srnd
+
+import (
+	"testing"
+)
+
+// a database that only knows one reply and records what was done to it
+type opDeleteDB struct {
+	Database
+	deleted []string
+	banned  []string
+}
+
+func (self *opDeleteDB) GetHeadersForMessage(msgid string) (ArticleHeaders, error) {
+	hdr := make(ArticleHeaders)
+	hdr.Set("References", "<root@test>")
+	hdr.Set("Newsgroups", "overchan.test")
+	return hdr, nil
+}
+
+func (self *opDeleteDB) GetPageForRootMessage(root string) (string, int64, error) {
+	return "overchan.test", 0, nil
+}
+
+func (self *opDeleteDB) GetPostAttachments(msgid string) []string {
+	return []string{"file.png"}
+}
+
+func (self *opDeleteDB) GetInfoForMessage(msgid string) (string, string, int64, error) {
+	return "<root@test>", "overchan.test", 0, nil
+}
+
+func (self *opDeleteDB) TrashArticle(msgid, group, root string, atts []string) error {
+	return nil
+}
+
+func (self *opDeleteDB) DeleteArticle(msgid string) error {
+	self.deleted = append(self.deleted, msgid)
+	return nil
+}
+
+func (self *opDeleteDB) BanArticle(msgid, reason string) error {
+	self.banned = append(self.banned, msgid)
+	return nil
+}
+
+// a store that records which files were trashed
+type opDeleteStore struct {
+	ArticleStore
+	trashed []string
+}
+
+func (self *opDeleteStore) TrashArticle(msgid string, atts []string) error {
+	self.trashed = append(self.trashed, msgid)
+	self.trashed = append(self.trashed, atts...)
+	return nil
+}
+
+func TestSelfDeletePost(t *testing.T) {
+
+	db := new(opDeleteDB)
+	store := new(opDeleteStore)
+	spam := newSpamFilter("", 0.9, nil)
+	mod := modEngine{database: db, store: store, spam: spam}
+	regen := false
+	err := mod.SelfDeletePost("<reply@test>", func(group, msgid, root string, page int) { regen = true })
+	if err != nil {
+		t.Error(err)
+	}
+	if len(db.deleted) != 1 || db.deleted[0] != "<reply@test>" {
+		t.Error("post was not deleted", db.deleted)
+	}
+	if len(store.trashed) != 2 || store.trashed[1] != "file.png" {
+		t.Error("attachments were not removed", store.trashed)
+	}
+	if len(db.banned) != 0 {
+		t.Error("a post its poster deleted was banned", db.banned)
+	}
+	if spam.state.Spam != 0 || spam.state.Ham != 0 {
+		t.Error("a post its poster deleted was learned from", spam.state)
+	}
+	if !regen {
+		t.Error("thread was not regenerated")
+	}
+	mod.DeletePost("<reply@test>", func(group, msgid, root string, page int) {})
+	if len(db.banned) != 1 {
+		t.Error("a post a mod deleted was not banned", db.banned)
+	}
+
+}
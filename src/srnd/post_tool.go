@@ -0,0 +1,112 @@
+//
+// post_tool.go -- posting a test article through the daemon from the shell
+//
+
+package srnd
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// an attachment made from a file on disk
+func attachmentFromFile(fname string) (NNTPAttachment, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	content_type := mime.TypeByExtension(filepath.Ext(fname))
+	if content_type == "" {
+		content_type = http.DetectContentType(data)
+	}
+	att := createAttachment(content_type, filepath.Base(fname), strings.NewReader(base64.StdEncoding.EncodeToString(data)))
+	if att == nil {
+		return nil, errors.New("cannot attach " + fname)
+	}
+	return att, nil
+}
+
+// make an article and post it to the daemon over nntp, so it goes through everything a post from a peer does:
+// login role, feed policy, filters, spam scoring, bans, storing and federating
+// usage: -group newsgroup -message text|- [-attach file] [-subject s] [-name n] [-ref message-id] [-key file] [-server addr] [-user name -pass password]
+func PostTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("post", flag.ExitOnError)
+	group := flags.String("group", "", "newsgroup to post in")
+	message := flags.String("message", "", "text of the post, - to read it from stdin")
+	attach := flags.String("attach", "", "file to attach")
+	subject := flags.String("subject", "test post", "subject of the post")
+	name := flags.String("name", "srnd", "name to post as")
+	ref := flags.String("ref", "", "message-id of the thread to reply to, empty to start one")
+	keyfile := flags.String("key", "", "file with the hex secret key to sign the post with, see srnd keygen")
+	server := flags.String("server", "", "nntp server to post to, host:port or unix:/path, empty for the bind in srnd.ini")
+	user := flags.String("user", "", "nntp login, srnd takes posts from logged in users only")
+	passwd := flags.String("pass", "", "nntp password")
+	flags.Parse(args)
+	if !newsgroupValidFormat(*group) || *message == "" || flags.NArg() != 0 {
+		return errors.New("usage: post -group newsgroup -message text|- [-attach file] [-subject s] [-name n] [-ref message-id] [-key file] [-server addr] [-user name -pass password]")
+	}
+	if *ref != "" && !ValidMessageID(*ref) {
+		return errors.New("bad message-id " + *ref)
+	}
+	if *message == "-" {
+		var data []byte
+		data, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return
+		}
+		*message = string(data)
+	}
+	var seed []byte
+	if *keyfile != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(*keyfile)
+		if err != nil {
+			return
+		}
+		seed = unhex(strings.TrimSpace(string(data)))
+		if len(seed) != 32 {
+			return errors.New("no secret key in " + *keyfile)
+		}
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	if *server == "" {
+		*server = conf.daemon["bind"]
+	}
+	instance := conf.daemon["instance_name"]
+	nntp := newPlaintextArticle(*message, "poster@"+instance, *subject, *name, instance, genMessageID(instance), *group)
+	if *ref != "" {
+		nntp.Headers().Set("References", *ref)
+	}
+	if *attach != "" {
+		var att NNTPAttachment
+		att, err = attachmentFromFile(*attach)
+		if err != nil {
+			return
+		}
+		nntp.Attach(att)
+		nntp.Pack()
+	}
+	if seed != nil {
+		nntp, err = signArticle(nntp, seed)
+		if err != nil {
+			return
+		}
+	}
+	err = nntpPost(*server, *user, *passwd, nntp)
+	if err == nil {
+		fmt.Fprintln(w, "posted", nntp.MessageID(), "to", *server, "in", *group)
+	}
+	return
+}
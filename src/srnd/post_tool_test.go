@@ -0,0 +1,27 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentFromFile(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	fname := filepath.Join(dir, "note.txt")
+	ioutil.WriteFile(fname, []byte("hello"), 0600)
+	att, err := attachmentFromFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.Filename() != "note.txt" || string(att.Bytes()) != "hello" || !strings.HasPrefix(att.Mime(), "text/plain") {
+		t.Errorf("attached %s %q as %s", att.Filename(), att.Bytes(), att.Mime())
+	}
+	if _, err = attachmentFromFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("attached a missing file")
+	}
+
+}
@@ -20,7 +20,7 @@ import (
 	"log"
 	"math"
 	"net"
-	"os"
+	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -68,6 +68,29 @@ func (self *PostgresDatabase) Close() {
 	}
 }
 
+// check the database answers
+func (self *PostgresDatabase) Ping() error {
+	return self.conn.Ping()
+}
+
+// dump every table to fname with pg_dump, it runs in one transaction so the dump is consistent
+func (self *PostgresDatabase) Export(fname string) error {
+	out, err := exec.Command("pg_dump", "--clean", "--if-exists", "--no-owner", "--file", fname, "--dbname", self.db_str).CombinedOutput()
+	if err != nil {
+		return errors.New("pg_dump: " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// load a dump made by Export with psql, replacing the tables that are there
+func (self *PostgresDatabase) Import(fname string) error {
+	out, err := exec.Command("psql", "--quiet", "--single-transaction", "--set", "ON_ERROR_STOP=1", "--file", fname, "--dbname", self.db_str).CombinedOutput()
+	if err != nil {
+		return errors.New("psql: " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 func (self *PostgresDatabase) CreateTables() {
 	for {
 		version := self.getDBVersion()
@@ -94,6 +117,75 @@ func (self *PostgresDatabase) CreateTables() {
 			// upgrade to version 6
 			self.upgrade5to6()
 		} else if version == 6 {
+			// upgrade to version 7
+			self.upgrade6to7()
+		} else if version == 7 {
+			// upgrade to version 8
+			self.upgrade7to8()
+		} else if version == 8 {
+			// upgrade to version 9
+			self.upgrade8to9()
+		} else if version == 9 {
+			// upgrade to version 10
+			self.upgrade9to10()
+		} else if version == 10 {
+			// upgrade to version 11
+			self.upgrade10to11()
+		} else if version == 11 {
+			// upgrade to version 12
+			self.upgrade11to12()
+		} else if version == 12 {
+			// upgrade to version 13
+			self.upgrade12to13()
+		} else if version == 13 {
+			// upgrade to version 14
+			self.upgrade13to14()
+		} else if version == 14 {
+			// upgrade to version 15
+			self.upgrade14to15()
+		} else if version == 15 {
+			// upgrade to version 16
+			self.upgrade15to16()
+		} else if version == 16 {
+			// upgrade to version 17
+			self.upgrade16to17()
+		} else if version == 17 {
+			// upgrade to version 18
+			self.upgrade17to18()
+		} else if version == 18 {
+			// upgrade to version 19
+			self.upgrade18to19()
+		} else if version == 19 {
+			// upgrade to version 20
+			self.upgrade19to20()
+		} else if version == 20 {
+			// upgrade to version 21
+			self.upgrade20to21()
+		} else if version == 21 {
+			// upgrade to version 22
+			self.upgrade21to22()
+		} else if version == 22 {
+			// upgrade to version 23
+			self.upgrade22to23()
+		} else if version == 23 {
+			// upgrade to version 24
+			self.upgrade23to24()
+		} else if version == 24 {
+			// upgrade to version 25
+			self.upgrade24to25()
+		} else if version == 25 {
+			// upgrade to version 26
+			self.upgrade25to26()
+		} else if version == 26 {
+			// upgrade to version 27
+			self.upgrade26to27()
+		} else if version == 27 {
+			// upgrade to version 28
+			self.upgrade27to28()
+		} else if version == 28 {
+			// upgrade to version 29
+			self.upgrade28to29()
+		} else if version == 29 {
 			// we are up to date
 			log.Println("we are up to date at version", version)
 			return
@@ -193,6 +285,443 @@ func (self *PostgresDatabase) upgrade5to6() {
 	self.setDBVersion(6)
 }
 
+func (self *PostgresDatabase) upgrade6to7() {
+	log.Println("migrating... 6 -> 7")
+	tables := make(map[string]string)
+
+	// message-ids that a feed's peer already has or refused
+	tables["FeedOfferedArticles"] = `(
+                                     feedname VARCHAR(255) NOT NULL,
+                                     message_id VARCHAR(255) NOT NULL,
+                                     time_offered BIGINT NOT NULL,
+                                     PRIMARY KEY(feedname, message_id)
+                                   )`
+
+	table_order := []string{"FeedOfferedArticles"}
+	for _, t := range table_order {
+		q := tables[t]
+		// create table
+		_, err := self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", t, q))
+		if err != nil {
+			log.Fatalf("cannot create table %s, %s", t, err)
+		}
+	}
+	cmds := []string{"CREATE INDEX ON FeedOfferedArticles(message_id)"}
+	for _, cmd := range cmds {
+		_, err := self.conn.Exec(cmd)
+		if err != nil {
+			log.Fatalf("failed to execute query: %s, %s", cmd, err.Error())
+		}
+	}
+
+	self.setDBVersion(7)
+}
+
+func (self *PostgresDatabase) upgrade7to8() {
+	log.Println("migrating... 7 -> 8")
+	tables := make(map[string]string)
+
+	// reports about posts waiting for the mods
+	tables["PostReports"] = `(
+                             message_id VARCHAR(255) NOT NULL,
+                             newsgroup VARCHAR(255) NOT NULL,
+                             reason TEXT NOT NULL,
+                             time_reported BIGINT NOT NULL,
+                             id BIGSERIAL PRIMARY KEY
+                           )`
+
+	table_order := []string{"PostReports"}
+	for _, t := range table_order {
+		q := tables[t]
+		// create table
+		_, err := self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", t, q))
+		if err != nil {
+			log.Fatalf("cannot create table %s, %s", t, err)
+		}
+	}
+	cmds := []string{
+		"CREATE INDEX ON PostReports(message_id)",
+		"CREATE INDEX ON PostReports(newsgroup)",
+	}
+	for _, cmd := range cmds {
+		_, err := self.conn.Exec(cmd)
+		if err != nil {
+			log.Fatalf("failed to execute query: %s, %s", cmd, err.Error())
+		}
+	}
+
+	self.setDBVersion(8)
+}
+
+func (self *PostgresDatabase) upgrade8to9() {
+	log.Println("migrating... 8 -> 9")
+	// mod keys that were rotated out
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS ExpiredModKeys(
+                              pubkey VARCHAR(255) PRIMARY KEY,
+                              replaced_by VARCHAR(255) NOT NULL,
+                              time_expired BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table ExpiredModKeys, %s", err)
+	}
+	self.setDBVersion(9)
+}
+
+func (self *PostgresDatabase) upgrade9to10() {
+	log.Println("migrating... 9 -> 10")
+	// posts held back by the spam filter
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS QuarantinedArticles(
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              score DOUBLE PRECISION NOT NULL,
+                              time_quarantined BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table QuarantinedArticles, %s", err)
+	}
+	self.setDBVersion(10)
+}
+
+func (self *PostgresDatabase) upgrade10to11() {
+	log.Println("migrating... 10 -> 11")
+	tables := make(map[string]string)
+
+	tables["BannedPubkeys"] = `(
+                                pubkey VARCHAR(255) PRIMARY KEY,
+                                time_banned BIGINT NOT NULL
+                              )`
+
+	tables["BannedAttachments"] = `(
+                                    sha_hash VARCHAR(128) PRIMARY KEY,
+                                    time_banned BIGINT NOT NULL
+                                  )`
+
+	// ban lists of other nodes we merge
+	tables["BanlistSubscriptions"] = `(
+                                       pubkey VARCHAR(255) PRIMARY KEY,
+                                       time_subscribed BIGINT NOT NULL
+                                     )`
+
+	// every ban we merged and which ban list it came from
+	tables["BanlistEntries"] = `(
+                                 source VARCHAR(255) NOT NULL,
+                                 kind VARCHAR(16) NOT NULL,
+                                 value VARCHAR(255) NOT NULL,
+                                 applied BOOLEAN NOT NULL,
+                                 time_added BIGINT NOT NULL,
+                                 PRIMARY KEY(source, kind, value)
+                               )`
+
+	table_order := []string{"BannedPubkeys", "BannedAttachments", "BanlistSubscriptions", "BanlistEntries"}
+	for _, t := range table_order {
+		q := tables[t]
+		_, err := self.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", t, q))
+		if err != nil {
+			log.Fatalf("cannot create table %s, %s", t, err)
+		}
+	}
+	_, err := self.conn.Exec("CREATE INDEX ON BanlistEntries(kind, value)")
+	if err != nil {
+		log.Fatalf("failed to create index on BanlistEntries, %s", err)
+	}
+	self.setDBVersion(11)
+}
+
+func (self *PostgresDatabase) upgrade11to12() {
+	log.Println("migrating... 11 -> 12")
+	// addresses whose posts only they get to see
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS EncIPShadowBans(
+                              encaddr VARCHAR(255) PRIMARY KEY,
+                              made BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table EncIPShadowBans, %s", err)
+	}
+	self.setDBVersion(12)
+}
+
+func (self *PostgresDatabase) upgrade12to13() {
+	log.Println("migrating... 12 -> 13")
+	// sticky, locked and cycling threads
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS ThreadFlags(
+                              root_message_id VARCHAR(255) NOT NULL,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              flag VARCHAR(16) NOT NULL,
+                              time_set BIGINT NOT NULL,
+                              PRIMARY KEY(root_message_id, flag)
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table ThreadFlags, %s", err)
+	}
+	self.setDBVersion(13)
+}
+
+func (self *PostgresDatabase) upgrade13to14() {
+	log.Println("migrating... 13 -> 14")
+	// one appeal per banned address
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS BanAppeals(
+                              encaddr VARCHAR(255) PRIMARY KEY,
+                              appeal TEXT NOT NULL,
+                              status VARCHAR(16) NOT NULL,
+                              time_appealed BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table BanAppeals, %s", err)
+	}
+	self.setDBVersion(14)
+}
+
+func (self *PostgresDatabase) upgrade14to15() {
+	log.Println("migrating... 14 -> 15")
+	// who handed out which mod permission
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS ModGrants(
+                              pubkey VARCHAR(255) NOT NULL,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              permission VARCHAR(255) NOT NULL,
+                              granter VARCHAR(255) NOT NULL,
+                              time_granted BIGINT NOT NULL,
+                              PRIMARY KEY(pubkey, newsgroup, permission)
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table ModGrants, %s", err)
+	}
+	_, err = self.conn.Exec("CREATE INDEX IF NOT EXISTS ModGrants_granter ON ModGrants(granter)")
+	if err != nil {
+		log.Fatalf("cannot create index on ModGrants, %s", err)
+	}
+	self.setDBVersion(15)
+}
+
+func (self *PostgresDatabase) upgrade15to16() {
+	log.Println("migrating... 15 -> 16")
+	// when each address key was made so old ones can be rotated, existing ones start now
+	cmds := []string{
+		"ALTER TABLE EncryptedAddrs ADD COLUMN IF NOT EXISTS made BIGINT NOT NULL DEFAULT 0",
+		fmt.Sprintf("UPDATE EncryptedAddrs SET made = %d WHERE made = 0", timeNow()),
+	}
+	for _, cmd := range cmds {
+		_, err := self.conn.Exec(cmd)
+		if err != nil {
+			log.Fatalf("%s failed: %s", cmd, err)
+		}
+	}
+	self.setDBVersion(16)
+}
+
+func (self *PostgresDatabase) upgrade16to17() {
+	log.Println("migrating... 16 -> 17")
+	// deleted articles that can still be restored, attachments are space separated
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS TrashedArticles(
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              root_message_id VARCHAR(255) NOT NULL,
+                              attachments TEXT NOT NULL,
+                              time_trashed BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table TrashedArticles, %s", err)
+	}
+	self.setDBVersion(17)
+}
+
+func (self *PostgresDatabase) upgrade17to18() {
+	log.Println("migrating... 17 -> 18")
+	// moderated intake per feed
+	cmds := []string{
+		"ALTER TABLE QuarantinedArticles ADD COLUMN IF NOT EXISTS feed VARCHAR(255) NOT NULL DEFAULT ''",
+		`CREATE TABLE IF NOT EXISTS FeedIntake(
+                                feed VARCHAR(255) PRIMARY KEY,
+                                streak BIGINT NOT NULL,
+                                trusted BOOLEAN NOT NULL
+                              )`,
+	}
+	for _, cmd := range cmds {
+		_, err := self.conn.Exec(cmd)
+		if err != nil {
+			log.Fatalf("%s failed: %s", cmd, err)
+		}
+	}
+	self.setDBVersion(18)
+}
+
+func (self *PostgresDatabase) upgrade18to19() {
+	log.Println("migrating... 18 -> 19")
+	// signing keys with higher posting quotas
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS TrustedPubkeys(
+                              pubkey VARCHAR(255) PRIMARY KEY,
+                              time_trusted BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table TrustedPubkeys, %s", err)
+	}
+	self.setDBVersion(19)
+}
+
+func (self *PostgresDatabase) upgrade19to20() {
+	log.Println("migrating... 19 -> 20")
+	// dnsbl and tor exit lookups, lists are space separated
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS AddrListings(
+                              addr VARCHAR(255) PRIMARY KEY,
+                              lists TEXT NOT NULL,
+                              expires BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table AddrListings, %s", err)
+	}
+	self.setDBVersion(20)
+}
+
+func (self *PostgresDatabase) upgrade20to21() {
+	log.Println("migrating... 20 -> 21")
+	// articles our filters and bans rejected, kept for the mods
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS RejectedArticles(
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              newsgroup VARCHAR(255) NOT NULL,
+                              reason TEXT NOT NULL,
+                              feed VARCHAR(255) NOT NULL,
+                              time_rejected BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table RejectedArticles, %s", err)
+	}
+	self.setDBVersion(21)
+}
+
+func (self *PostgresDatabase) upgrade21to22() {
+	log.Println("migrating... 21 -> 22")
+	// per newsgroup settings from the mod ui
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS BoardSettings(
+                              newsgroup VARCHAR(255) PRIMARY KEY,
+                              pages INTEGER NOT NULL,
+                              threads_per_page INTEGER NOT NULL,
+                              attachments BOOLEAN NOT NULL,
+                              captcha VARCHAR(255) NOT NULL,
+                              description TEXT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table BoardSettings, %s", err)
+	}
+	self.setDBVersion(22)
+}
+
+func (self *PostgresDatabase) upgrade28to29() {
+	log.Println("migrating... 28 -> 29")
+	// feeds ctl messages came in from until they are handled
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS CtlFeeds(
+                              message_id VARCHAR(255) PRIMARY KEY,
+                              feedname VARCHAR(255) NOT NULL,
+                              time_received BIGINT NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table CtlFeeds, %s", err)
+	}
+	self.setDBVersion(29)
+}
+
+func (self *PostgresDatabase) upgrade27to28() {
+	log.Println("migrating... 27 -> 28")
+	// what nntp logins may do, the logins we have keep doing everything
+	cmds := []string{
+		fmt.Sprintf("ALTER TABLE NNTPUsers ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT '%s'", NNTPRoleFeeder),
+		"ALTER TABLE NNTPUsers ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE",
+	}
+	for _, cmd := range cmds {
+		_, err := self.conn.Exec(cmd)
+		if err != nil {
+			log.Fatalf("%s failed: %s", cmd, err)
+		}
+	}
+	self.setDBVersion(28)
+}
+
+func (self *PostgresDatabase) upgrade26to27() {
+	log.Println("migrating... 26 -> 27")
+	// replies threads show on board pages
+	cmd := fmt.Sprintf("ALTER TABLE BoardSettings ADD COLUMN IF NOT EXISTS preview_replies INTEGER NOT NULL DEFAULT %d", defaultPreviewReplies)
+	_, err := self.conn.Exec(cmd)
+	if err != nil {
+		log.Fatalf("%s failed: %s", cmd, err)
+	}
+	self.setDBVersion(27)
+}
+
+func (self *PostgresDatabase) upgrade25to26() {
+	log.Println("migrating... 25 -> 26")
+	// boards that play audio and video in the page
+	cmd := "ALTER TABLE BoardSettings ADD COLUMN IF NOT EXISTS inline_media BOOLEAN NOT NULL DEFAULT TRUE"
+	_, err := self.conn.Exec(cmd)
+	if err != nil {
+		log.Fatalf("%s failed: %s", cmd, err)
+	}
+	self.setDBVersion(26)
+}
+
+func (self *PostgresDatabase) upgrade24to25() {
+	log.Println("migrating... 24 -> 25")
+	// attachments the poster wants hidden until clicked
+	cmd := "ALTER TABLE ArticleAttachments ADD COLUMN IF NOT EXISTS spoiler BOOLEAN NOT NULL DEFAULT FALSE"
+	_, err := self.conn.Exec(cmd)
+	if err != nil {
+		log.Fatalf("%s failed: %s", cmd, err)
+	}
+	self.setDBVersion(25)
+}
+
+func (self *PostgresDatabase) upgrade23to24() {
+	log.Println("migrating... 23 -> 24")
+	// frontend rate limits shared between frontends
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS RateLimits(
+                              counter VARCHAR(255) PRIMARY KEY,
+                              window_start BIGINT NOT NULL,
+                              hits INTEGER NOT NULL
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table RateLimits, %s", err)
+	}
+	self.setDBVersion(24)
+}
+
+func (self *PostgresDatabase) upgrade22to23() {
+	log.Println("migrating... 22 -> 23")
+	// which posts quote which
+	_, err := self.conn.Exec(`CREATE TABLE IF NOT EXISTS Backlinks(
+                              message_id VARCHAR(255) NOT NULL,
+                              quoted_by VARCHAR(255) NOT NULL,
+                              time_posted INTEGER NOT NULL,
+                              PRIMARY KEY(message_id, quoted_by)
+                            )`)
+	if err != nil {
+		log.Fatalf("cannot create table Backlinks, %s", err)
+	}
+	_, err = self.conn.Exec("CREATE INDEX ON Backlinks(quoted_by)")
+	if err != nil {
+		log.Fatalf("cannot create index on Backlinks, %s", err)
+	}
+	// index the posts we already have
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id, message, time_posted FROM ArticlePosts WHERE message LIKE '%>>%'")
+	if err != nil {
+		log.Fatalf("cannot index backlinks, %s", err)
+	}
+	type quoting struct {
+		msgid   string
+		message string
+		posted  int64
+	}
+	var posts []quoting
+	for rows.Next() {
+		var q quoting
+		rows.Scan(&q.msgid, &q.message, &q.posted)
+		posts = append(posts, q)
+	}
+	rows.Close()
+	for _, q := range posts {
+		self.registerBacklinks(q.msgid, q.message, q.posted)
+	}
+	log.Println("indexed backlinks of", len(posts), "posts")
+	self.setDBVersion(23)
+}
+
 func (self *PostgresDatabase) upgrade4to5() {
 	log.Println("migrating... 4 -> 5")
 	cmds := []string{
@@ -472,6 +1001,20 @@ func (self *PostgresDatabase) NewsgroupBanned(group string) (banned bool, err er
 	return
 }
 
+func (self *PostgresDatabase) GetBannedNewsgroups() (groups []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT newsgroup FROM BannedGroups ORDER BY newsgroup ASC")
+	if err == nil {
+		for rows.Next() {
+			var group string
+			rows.Scan(&group)
+			groups = append(groups, group)
+		}
+		rows.Close()
+	}
+	return
+}
+
 func (self *PostgresDatabase) NukeNewsgroup(group string, store ArticleStore) {
 	// first delete all thread presences
 	_, _ = self.conn.Exec("DELETE FROM ArticleThreads WHERE newsgroup = $1", group)
@@ -487,16 +1030,8 @@ func (self *PostgresDatabase) NukeNewsgroup(group string, store ArticleStore) {
 		if ok {
 			msgid := article.MessageID()
 			log.Println("delete", msgid)
-			// remove article from store
-			fname := store.GetFilename(msgid)
-			os.Remove(fname)
-			// get all attachments
-			for _, att := range self.GetPostAttachments(msgid) {
-				// remove attachment
-				log.Println("delete attachment", att)
-				os.Remove(store.ThumbnailFilepath(att))
-				os.Remove(store.AttachmentFilepath(att))
-			}
+			// trash or remove article and attachments from store
+			discardArticle(self, store, msgid, group)
 			// delete from database
 			self.DeleteArticle(msgid)
 		} else {
@@ -551,81 +1086,299 @@ func (self *PostgresDatabase) CheckModPubkeyGlobal(pubkey string) bool {
 
 func (self *PostgresDatabase) CheckModPubkeyCanModGroup(pubkey, newsgroup string) bool {
 	var result int64
-	_ = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1 AND newsgroup = $2", pubkey, newsgroup).Scan(&result)
+	_ = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1 AND newsgroup = $2 AND permission = $3", pubkey, newsgroup, "all").Scan(&result)
 	return result > 0
 }
 
-func (self *PostgresDatabase) CountPostsInGroup(newsgroup string, time_frame int64) (result int64) {
-	if time_frame > 0 {
-		time_frame = timeNow() - time_frame
-	} else if time_frame < 0 {
-		time_frame = 0
+func (self *PostgresDatabase) CheckModPubkeyPermission(pubkey, newsgroup, perm string) bool {
+	var result int64
+	_ = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1 AND ( ( newsgroup = $2 AND permission IN ( $3, $4 ) ) OR ( newsgroup = $5 AND permission = $4 ) )", pubkey, newsgroup, perm, "all", "overchan").Scan(&result)
+	return result > 0
+}
+
+func (self *PostgresDatabase) GrantModPubkeyPermission(pubkey, newsgroup, perm string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1 AND newsgroup = $2 AND permission = $3", pubkey, newsgroup, perm).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO ModPrivs(pubkey, newsgroup, permission) VALUES($1, $2, $3)", pubkey, newsgroup, perm)
 	}
-	self.conn.QueryRow("SELECT COUNT(*) FROM ArticlePosts WHERE time_posted > $2 AND newsgroup = $1", newsgroup, time_frame).Scan(&result)
 	return
 }
 
-func (self *PostgresDatabase) CheckModPubkey(pubkey string) bool {
-	var result int64
-	self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1", pubkey).Scan(&result)
-	return result > 0
+func (self *PostgresDatabase) RevokeModPubkeyPermission(pubkey, newsgroup, perm string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModPrivs WHERE pubkey = $1 AND newsgroup = $2 AND permission = $3", pubkey, newsgroup, perm)
+	return
 }
 
-func (self *PostgresDatabase) BanArticle(messageID, reason string) error {
-	if self.ArticleBanned(messageID) {
-		log.Println(messageID, "already banned")
-		return nil
+func (self *PostgresDatabase) RotateModPubkey(oldkey, newkey string) (err error) {
+	var tx *sql.Tx
+	tx, err = self.conn.Begin()
+	if err != nil {
+		return
 	}
-	_, err := self.conn.Exec("INSERT INTO BannedArticles(message_id, time_banned, ban_reason) VALUES($1, $2, $3)", messageID, timeNow(), reason)
-	return err
+	cmds := []struct {
+		q    string
+		args []interface{}
+	}{
+		// copy over every privilege the new key doesn't already have
+		{"INSERT INTO ModPrivs(pubkey, newsgroup, permission) SELECT $2, newsgroup, permission FROM ModPrivs AS old WHERE old.pubkey = $1 AND NOT EXISTS ( SELECT 1 FROM ModPrivs WHERE pubkey = $2 AND newsgroup = old.newsgroup AND permission = old.permission )", []interface{}{oldkey, newkey}},
+		{"DELETE FROM ModPrivs WHERE pubkey = $1", []interface{}{oldkey}},
+		// grants made to and by the old key now belong to the new one
+		{"DELETE FROM ModGrants AS old WHERE old.pubkey = $1 AND EXISTS ( SELECT 1 FROM ModGrants WHERE pubkey = $2 AND newsgroup = old.newsgroup AND permission = old.permission )", []interface{}{oldkey, newkey}},
+		{"UPDATE ModGrants SET pubkey = $2 WHERE pubkey = $1", []interface{}{oldkey, newkey}},
+		{"UPDATE ModGrants SET granter = $2 WHERE granter = $1", []interface{}{oldkey, newkey}},
+		{"INSERT INTO ExpiredModKeys(pubkey, replaced_by, time_expired) VALUES($1, $2, $3)", []interface{}{oldkey, newkey, timeNow()}},
+	}
+	for _, cmd := range cmds {
+		_, err = tx.Exec(cmd.q, cmd.args...)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}
+	err = tx.Commit()
+	return
 }
 
-func (self *PostgresDatabase) ArticleBanned(messageID string) (result bool) {
-
+func (self *PostgresDatabase) ModPubkeyExpired(pubkey string) bool {
 	var count int64
-	err := self.conn.QueryRow("SELECT COUNT(message_id) FROM BannedArticles WHERE message_id = $1", messageID).Scan(&count)
+	self.conn.QueryRow("SELECT COUNT(*) FROM ExpiredModKeys WHERE pubkey = $1", pubkey).Scan(&count)
+	return count > 0
+}
+
+func (self *PostgresDatabase) RecordModGrant(granter, pubkey, newsgroup, perm string) (err error) {
+	err = self.ForgetModGrant(pubkey, newsgroup, perm)
 	if err == nil {
-		result = count > 0
-	} else {
-		log.Println("error checking if article is banned", err)
+		_, err = self.conn.Exec("INSERT INTO ModGrants(pubkey, newsgroup, permission, granter, time_granted) VALUES($1, $2, $3, $4, $5)", pubkey, newsgroup, perm, granter, timeNow())
 	}
 	return
 }
 
-func (self *PostgresDatabase) GetEncAddress(addr string) (encaddr string, err error) {
-	var count int64
-	err = self.conn.QueryRow("SELECT COUNT(addr) FROM EncryptedAddrs WHERE addr = $1", addr).Scan(&count)
+func (self *PostgresDatabase) ForgetModGrant(pubkey, newsgroup, perm string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM ModGrants WHERE pubkey = $1 AND newsgroup = $2 AND permission = $3", pubkey, newsgroup, perm)
+	return
+}
+
+func (self *PostgresDatabase) getModGrants(q string, args ...interface{}) (grants []ModGrant, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(q, args...)
 	if err == nil {
-		if count == 0 {
-			// needs to be inserted
-			var key string
-			key, encaddr = newAddrEnc(addr)
-			if len(encaddr) == 0 {
-				err = errors.New("failed to generate new encryption key")
-			} else {
-				_, err = self.conn.Exec("INSERT INTO EncryptedAddrs(enckey, encaddr, addr, addr_cidr) VALUES($1, $2, $3, cidr($4))", key, encaddr, addr, addr+"/32")
-			}
-		} else {
-			err = self.conn.QueryRow("SELECT encAddr FROM EncryptedAddrs WHERE addr = $1 LIMIT 1", addr).Scan(&encaddr)
+		for rows.Next() {
+			var g ModGrant
+			rows.Scan(&g.Granter, &g.Pubkey, &g.Newsgroup, &g.Permission, &g.Granted)
+			grants = append(grants, g)
 		}
+		rows.Close()
 	}
 	return
 }
 
-func (self *PostgresDatabase) GetEncKey(encAddr string) (enckey string, err error) {
-	err = self.conn.QueryRow("SELECT enckey FROM EncryptedAddrs WHERE encaddr = $1 LIMIT 1", encAddr).Scan(&enckey)
-	return
+func (self *PostgresDatabase) GetModGrantsBy(granter string) ([]ModGrant, error) {
+	return self.getModGrants("SELECT granter, pubkey, newsgroup, permission, time_granted FROM ModGrants WHERE granter = $1 ORDER BY time_granted ASC", granter)
 }
 
-func (self *PostgresDatabase) CheckIPBanned(addr string) (banned bool, err error) {
-	var amount int64
-	err = self.conn.QueryRow("SELECT COUNT(*) FROM IPBans WHERE addr >>= $1 ", addr).Scan(&amount)
-	banned = amount > 0
-	return
+func (self *PostgresDatabase) GetModGrantsTo(pubkey string) ([]ModGrant, error) {
+	return self.getModGrants("SELECT granter, pubkey, newsgroup, permission, time_granted FROM ModGrants WHERE pubkey = $1 ORDER BY time_granted ASC", pubkey)
 }
 
-func (self *PostgresDatabase) GetIPAddress(encaddr string) (addr string, err error) {
-	var count int64
+func (self *PostgresDatabase) GetModPubkeyPermissions(pubkey, newsgroup string) (perms []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT permission FROM ModPrivs WHERE pubkey = $1 AND newsgroup = $2", pubkey, newsgroup)
+	if err == nil {
+		for rows.Next() {
+			var perm string
+			rows.Scan(&perm)
+			if validModPermission(perm) {
+				perms = append(perms, perm)
+			}
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) CountPostsInGroup(newsgroup string, time_frame int64) (result int64) {
+	if time_frame > 0 {
+		time_frame = timeNow() - time_frame
+	} else if time_frame < 0 {
+		time_frame = 0
+	}
+	self.conn.QueryRow("SELECT COUNT(*) FROM ArticlePosts WHERE time_posted > $2 AND newsgroup = $1", newsgroup, time_frame).Scan(&result)
+	return
+}
+
+func (self *PostgresDatabase) CountThreadsInGroup(newsgroup string) (result int64) {
+	self.conn.QueryRow("SELECT COUNT(*) FROM ArticleThreads WHERE newsgroup = $1", newsgroup).Scan(&result)
+	return
+}
+
+func (self *PostgresDatabase) GetLastPostTimeInGroup(newsgroup string) (result int64) {
+	self.conn.QueryRow("SELECT last_post FROM Newsgroups WHERE name = $1", newsgroup).Scan(&result)
+	return
+}
+
+func (self *PostgresDatabase) GetThreadLastPostTime(root string) (result int64) {
+	self.conn.QueryRow("SELECT last_post FROM ArticleThreads WHERE root_message_id = $1", root).Scan(&result)
+	return
+}
+
+func (self *PostgresDatabase) HitRateLimit(counter string, window int64) (hits int64, err error) {
+	now := timeNow()
+	start := now - now%window
+	err = self.conn.QueryRow("UPDATE RateLimits SET hits = CASE WHEN window_start = $2 THEN hits + 1 ELSE 1 END, window_start = $2 WHERE counter = $1 RETURNING hits", counter, start).Scan(&hits)
+	if err == sql.ErrNoRows {
+		// new counter, drop the ones nobody used for a day while we are here
+		self.conn.Exec("DELETE FROM RateLimits WHERE window_start < $1", now-86400)
+		_, err = self.conn.Exec("INSERT INTO RateLimits(counter, window_start, hits) VALUES($1, $2, 1)", counter, start)
+		hits = 1
+	}
+	return
+}
+
+func (self *PostgresDatabase) CheckModPubkey(pubkey string) bool {
+	var result int64
+	self.conn.QueryRow("SELECT COUNT(*) FROM ModPrivs WHERE pubkey = $1", pubkey).Scan(&result)
+	return result > 0
+}
+
+func (self *PostgresDatabase) BanArticle(messageID, reason string) error {
+	if self.ArticleBanned(messageID) {
+		log.Println(messageID, "already banned")
+		return nil
+	}
+	_, err := self.conn.Exec("INSERT INTO BannedArticles(message_id, time_banned, ban_reason) VALUES($1, $2, $3)", messageID, timeNow(), reason)
+	return err
+}
+
+func (self *PostgresDatabase) ArticleBanned(messageID string) (result bool) {
+
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(message_id) FROM BannedArticles WHERE message_id = $1", messageID).Scan(&count)
+	if err == nil {
+		result = count > 0
+	} else {
+		log.Println("error checking if article is banned", err)
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetBannedArticles() (banned map[string]string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id, ban_reason FROM BannedArticles")
+	if err == nil {
+		banned = make(map[string]string)
+		for rows.Next() {
+			var msgid, reason string
+			rows.Scan(&msgid, &reason)
+			banned[msgid] = reason
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnbanArticle(messageID string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedArticles WHERE message_id = $1", messageID)
+	return
+}
+
+func (self *PostgresDatabase) TrashArticle(messageID, newsgroup, root string, attachments []string) (err error) {
+	err = self.ForgetTrashedArticle(messageID)
+	if err == nil {
+		_, err = self.conn.Exec("INSERT INTO TrashedArticles(message_id, newsgroup, root_message_id, attachments, time_trashed) VALUES($1, $2, $3, $4, $5)", messageID, newsgroup, root, strings.Join(attachments, " "), timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetTrashedArticles() (trashed []TrashedArticle, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id, newsgroup, root_message_id, attachments, time_trashed FROM TrashedArticles ORDER BY time_trashed ASC")
+	if err == nil {
+		for rows.Next() {
+			var t TrashedArticle
+			var atts string
+			rows.Scan(&t.MessageID, &t.Newsgroup, &t.Root, &atts, &t.Trashed)
+			t.Attachments = strings.Fields(atts)
+			trashed = append(trashed, t)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) ForgetTrashedArticle(messageID string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM TrashedArticles WHERE message_id = $1", messageID)
+	return
+}
+
+func (self *PostgresDatabase) GetEncAddress(addr string) (encaddr string, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(addr) FROM EncryptedAddrs WHERE addr = $1", addr).Scan(&count)
+	if err == nil {
+		if count == 0 {
+			// needs to be inserted
+			var key string
+			key, encaddr = newAddrEnc(addr)
+			if len(encaddr) == 0 {
+				err = errors.New("failed to generate new encryption key")
+			} else {
+				_, err = self.conn.Exec("INSERT INTO EncryptedAddrs(enckey, encaddr, addr, addr_cidr, made) VALUES($1, $2, $3, cidr($4), $5)", key, encaddr, addr, addr+"/32", timeNow())
+			}
+		} else {
+			err = self.conn.QueryRow("SELECT encAddr FROM EncryptedAddrs WHERE addr = $1 LIMIT 1", addr).Scan(&encaddr)
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetEncKey(encAddr string) (enckey string, err error) {
+	err = self.conn.QueryRow("SELECT enckey FROM EncryptedAddrs WHERE encaddr = $1 LIMIT 1", encAddr).Scan(&enckey)
+	return
+}
+
+func (self *PostgresDatabase) GetEncAddrsMadeBefore(before int64) (encaddrs []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT encaddr FROM EncryptedAddrs WHERE made < $1", before)
+	if err == nil {
+		for rows.Next() {
+			var encaddr string
+			rows.Scan(&encaddr)
+			encaddrs = append(encaddrs, encaddr)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) RotateEncAddress(encaddr string) (newenc string, err error) {
+	var addr, key string
+	err = self.conn.QueryRow("SELECT addr FROM EncryptedAddrs WHERE encaddr = $1 LIMIT 1", encaddr).Scan(&addr)
+	if err != nil {
+		return
+	}
+	key, newenc = newAddrEnc(addr)
+	if len(newenc) == 0 {
+		err = errors.New("failed to generate new encryption key")
+		return
+	}
+	_, err = self.conn.Exec("UPDATE EncryptedAddrs SET enckey = $1, encaddr = $2, made = $3 WHERE encaddr = $4", key, newenc, timeNow(), encaddr)
+	return
+}
+
+func (self *PostgresDatabase) DeleteEncAddress(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM EncryptedAddrs WHERE encaddr = $1", encaddr)
+	return
+}
+
+func (self *PostgresDatabase) CheckIPBanned(addr string) (banned bool, err error) {
+	var amount int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM IPBans WHERE addr >>= $1 ", addr).Scan(&amount)
+	banned = amount > 0
+	return
+}
+
+func (self *PostgresDatabase) GetIPAddress(encaddr string) (addr string, err error) {
+	var count int64
 	err = self.conn.QueryRow("SELECT COUNT(encAddr) FROM EncryptedAddrs WHERE encAddr = $1", encaddr).Scan(&count)
 	if err == nil && count > 0 {
 		err = self.conn.QueryRow("SELECT addr FROM EncryptedAddrs WHERE encAddr = $1 LIMIT 1", encaddr).Scan(&addr)
@@ -686,6 +1439,29 @@ func (self *PostgresDatabase) CountThreadReplies(root_message_id string) (repls
 	return
 }
 
+func (self *PostgresDatabase) CountThreadRepliesSince(root_message_id string, since int64) (repls int64) {
+	_ = self.conn.QueryRow("SELECT COUNT(message_id) FROM ArticlePosts WHERE ref_id = $1 AND time_posted > $2", root_message_id, since).Scan(&repls)
+	return
+}
+
+func (self *PostgresDatabase) GetGroupThreadCounts(newsgroup string) (counts map[string]ThreadCounts, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT COALESCE(NULLIF(p.ref_id, ''), p.message_id) AS root, COUNT(DISTINCT p.message_id), COUNT(a.message_id) FROM ArticlePosts p LEFT OUTER JOIN ArticleAttachments a ON a.message_id = p.message_id WHERE p.newsgroup = $1 GROUP BY root", newsgroup)
+	if err == nil {
+		counts = make(map[string]ThreadCounts)
+		for rows.Next() {
+			var root string
+			var c ThreadCounts
+			rows.Scan(&root, &c.Replies, &c.Images)
+			// don't count the root post as a reply
+			c.Replies--
+			counts[root] = c
+		}
+		rows.Close()
+	}
+	return
+}
+
 func (self *PostgresDatabase) GetRootPostsForExpiration(newsgroup string, threadcount int) (roots []string) {
 
 	rows, err := self.conn.Query("SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 AND root_message_id NOT IN ( SELECT root_message_id FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC LIMIT $2)", newsgroup, threadcount)
@@ -740,7 +1516,8 @@ func (self *PostgresDatabase) GetGroupPageCount(newsgroup string) int64 {
 func (self *PostgresDatabase) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
 	var threads []ThreadModel
 	pages := self.GetGroupPageCount(newsgroup)
-	rows, err := self.conn.Query("WITH roots(root_message_id, last_bump) AS ( SELECT root_message_id, last_bump FROM ArticleThreads WHERE newsgroup = $1 ORDER BY last_bump DESC OFFSET $2 LIMIT $3 ) SELECT p.newsgroup, p.message_id, p.name, p.subject, p.path, p.time_posted, p.message, p.addr FROM ArticlePosts p INNER JOIN roots ON ( roots.root_message_id = p.message_id ) ORDER BY roots.last_bump DESC", newsgroup, pageno*perpage, perpage)
+	// sticky threads come first
+	rows, err := self.conn.Query("WITH roots(root_message_id, last_bump, sticky) AS ( SELECT t.root_message_id, t.last_bump, EXISTS ( SELECT 1 FROM ThreadFlags f WHERE f.root_message_id = t.root_message_id AND f.flag = $4 ) AS sticky FROM ArticleThreads t WHERE t.newsgroup = $1 ORDER BY sticky DESC, t.last_bump DESC OFFSET $2 LIMIT $3 ) SELECT p.newsgroup, p.message_id, p.name, p.subject, p.path, p.time_posted, p.message, p.addr FROM ArticlePosts p INNER JOIN roots ON ( roots.root_message_id = p.message_id ) ORDER BY roots.sticky DESC, roots.last_bump DESC", newsgroup, pageno*perpage, perpage, ThreadSticky)
 	if err == nil {
 		for rows.Next() {
 
@@ -810,6 +1587,9 @@ func (self *PostgresDatabase) GetPostModel(prefix, messageID string) PostModel {
 
 func (self *PostgresDatabase) DeleteThread(msgid string) (err error) {
 	_, err = self.conn.Exec("DELETE FROM ArticleThreads WHERE root_message_id = $1", msgid)
+	if err == nil {
+		_, err = self.conn.Exec("DELETE FROM ThreadFlags WHERE root_message_id = $1", msgid)
+	}
 	return
 }
 
@@ -823,6 +1603,15 @@ func (self *PostgresDatabase) DeleteArticle(msgid string) (err error) {
 				_, err = self.conn.Exec("DELETE FROM ArticleKeys WHERE message_id = $1", msgid)
 				if err == nil {
 					_, err = self.conn.Exec("DELETE FROM ArticleAttachments WHERE message_id = $1", msgid)
+					if err == nil {
+						_, err = self.conn.Exec("DELETE FROM FeedOfferedArticles WHERE message_id = $1", msgid)
+						if err == nil {
+							_, err = self.conn.Exec("DELETE FROM CtlFeeds WHERE message_id = $1", msgid)
+						}
+						if err == nil {
+							_, err = self.conn.Exec("DELETE FROM Backlinks WHERE message_id = $1 OR quoted_by = $1", msgid)
+						}
+					}
 				}
 			}
 		}
@@ -838,32 +1627,8 @@ func (self *PostgresDatabase) GetThreadReplyPostModels(prefix, rootpost string,
 	} else {
 		rows, err = self.conn.Query("SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE message_id IN ( SELECT message_id FROM ArticlePosts WHERE ref_id = $1 ) ORDER BY time_posted ASC", rootpost)
 	}
-	offset := start
 	if err == nil {
-		for rows.Next() {
-			// TODO: this is a hack, optimize queries plz
-			if offset > 0 {
-				offset--
-				continue
-			}
-			model := new(post)
-			model.prefix = prefix
-			rows.Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
-			model.op = len(model.Parent) == 0
-			if len(model.Parent) == 0 {
-				model.Parent = model.Message_id
-			}
-			model.sage = isSage(model.PostSubject)
-			atts := self.GetPostAttachmentModels(prefix, model.Message_id)
-			if atts != nil {
-				model.Files = append(model.Files, atts...)
-			}
-			// get pubkey if it exists
-			// quiet fail
-			_ = self.conn.QueryRow("SELECT pubkey FROM ArticleKeys WHERE message_id = $1", model.Message_id).Scan(&model.Key)
-			repls = append(repls, model)
-		}
-		rows.Close()
+		repls = self.scanReplyPostModels(prefix, rows, start)
 	} else {
 		log.Println("failed to get thread replies", rootpost, err)
 	}
@@ -872,6 +1637,55 @@ func (self *PostgresDatabase) GetThreadReplyPostModels(prefix, rootpost string,
 
 }
 
+func (self *PostgresDatabase) GetThreadReplyPostModelsPage(prefix, rootpost string, offset, limit int) (repls []PostModel) {
+	rows, err := self.conn.Query("SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE ref_id = $1 ORDER BY time_posted ASC OFFSET $2 LIMIT $3", rootpost, offset, limit)
+	if err == nil {
+		repls = self.scanReplyPostModels(prefix, rows, 0)
+	} else {
+		log.Println("failed to get page of thread replies", rootpost, offset, err)
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetThreadReplyPostModelsSince(prefix, rootpost string, since int64) (repls []PostModel) {
+	rows, err := self.conn.Query("SELECT newsgroup, message_id, ref_id, name, subject, path, time_posted, message, addr FROM ArticlePosts WHERE ref_id = $1 AND time_posted > $2 ORDER BY time_posted ASC", rootpost, since)
+	if err == nil {
+		repls = self.scanReplyPostModels(prefix, rows, 0)
+	} else {
+		log.Println("failed to get thread replies since", rootpost, since, err)
+	}
+	return
+}
+
+// read post models for replies from rows and close them, skipping the first offset
+func (self *PostgresDatabase) scanReplyPostModels(prefix string, rows *sql.Rows, offset int) (repls []PostModel) {
+	for rows.Next() {
+		// TODO: this is a hack, optimize queries plz
+		if offset > 0 {
+			offset--
+			continue
+		}
+		model := new(post)
+		model.prefix = prefix
+		rows.Scan(&model.board, &model.Message_id, &model.Parent, &model.PostName, &model.PostSubject, &model.MessagePath, &model.Posted, &model.PostMessage, &model.addr)
+		model.op = len(model.Parent) == 0
+		if len(model.Parent) == 0 {
+			model.Parent = model.Message_id
+		}
+		model.sage = isSage(model.PostSubject)
+		atts := self.GetPostAttachmentModels(prefix, model.Message_id)
+		if atts != nil {
+			model.Files = append(model.Files, atts...)
+		}
+		// get pubkey if it exists
+		// quiet fail
+		_ = self.conn.QueryRow("SELECT pubkey FROM ArticleKeys WHERE message_id = $1", model.Message_id).Scan(&model.Key)
+		repls = append(repls, model)
+	}
+	rows.Close()
+	return
+}
+
 func (self *PostgresDatabase) GetThreadReplies(rootpost string, start, limit int) (repls []string) {
 	var rows *sql.Rows
 	var err error
@@ -1026,15 +1840,17 @@ func (self *PostgresDatabase) GetPostAttachments(messageID string) (atts []strin
 }
 
 func (self *PostgresDatabase) GetPostAttachmentModels(prefix, messageID string) (atts []AttachmentModel) {
-	rows, err := self.conn.Query("SELECT filepath, filename FROM ArticleAttachments WHERE message_id = $1", messageID)
+	rows, err := self.conn.Query("SELECT filepath, filename, spoiler FROM ArticleAttachments WHERE message_id = $1", messageID)
 	if err == nil {
 		for rows.Next() {
 			var fpath, fname string
-			rows.Scan(&fpath, &fname)
+			var spoiler bool
+			rows.Scan(&fpath, &fname, &spoiler)
 			atts = append(atts, &attachment{
-				prefix: prefix,
-				Path:   fpath,
-				Name:   fname,
+				prefix:  prefix,
+				Path:    fpath,
+				Name:    fname,
+				Spoiled: spoiler,
 			})
 		}
 		rows.Close()
@@ -1075,6 +1891,7 @@ func (self *PostgresDatabase) RegisterArticle(message NNTPMessage) (err error) {
 		log.Println("cannot insert article post", err)
 		return
 	}
+	self.registerBacklinks(msgid, message.Message(), message.Posted())
 
 	// set / update thread state
 	if message.OP() {
@@ -1126,7 +1943,7 @@ func (self *PostgresDatabase) RegisterArticle(message NNTPMessage) (err error) {
 		return
 	}
 	for _, att := range atts {
-		_, err = self.conn.Exec("INSERT INTO ArticleAttachments(message_id, sha_hash, filename, filepath) VALUES($1, $2, $3, $4)", msgid, hex.EncodeToString(att.Hash()), att.Filename(), att.Filepath())
+		_, err = self.conn.Exec("INSERT INTO ArticleAttachments(message_id, sha_hash, filename, filepath, spoiler) VALUES($1, $2, $3, $4, $5)", msgid, hex.EncodeToString(att.Hash()), att.Filename(), att.Filepath(), attachmentSpoiled(att))
 		if err != nil {
 			log.Println("failed to register attachment", err)
 			continue
@@ -1192,13 +2009,35 @@ func (self *PostgresDatabase) GetAllArticles() (articles []ArticleEntry) {
 }
 
 func (self *PostgresDatabase) GetPagesPerBoard(group string) (int, error) {
-	//XXX: hardcoded
-	return 10, nil
+	s, err := self.GetBoardSettings(group)
+	return s.Pages, err
 }
 
 func (self *PostgresDatabase) GetThreadsPerPage(group string) (int, error) {
-	//XXX: hardcoded
-	return 10, nil
+	s, err := self.GetBoardSettings(group)
+	return s.ThreadsPerPage, err
+}
+
+func (self *PostgresDatabase) GetBoardSettings(group string) (s BoardSettings, err error) {
+	s = defaultBoardSettings(group)
+	err = self.conn.QueryRow("SELECT pages, threads_per_page, attachments, captcha, description, inline_media, preview_replies FROM BoardSettings WHERE newsgroup = $1", group).Scan(&s.Pages, &s.ThreadsPerPage, &s.Attachments, &s.Captcha, &s.Description, &s.InlineMedia, &s.PreviewReplies)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+func (self *PostgresDatabase) SetBoardSettings(s BoardSettings) (err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("UPDATE BoardSettings SET pages = $2, threads_per_page = $3, attachments = $4, captcha = $5, description = $6, inline_media = $7, preview_replies = $8 WHERE newsgroup = $1", s.Newsgroup, s.Pages, s.ThreadsPerPage, s.Attachments, s.Captcha, s.Description, s.InlineMedia, s.PreviewReplies)
+	if err == nil {
+		var n int64
+		n, err = res.RowsAffected()
+		if err == nil && n == 0 {
+			_, err = self.conn.Exec("INSERT INTO BoardSettings(newsgroup, pages, threads_per_page, attachments, captcha, description, inline_media, preview_replies) VALUES($1, $2, $3, $4, $5, $6, $7, $8)", s.Newsgroup, s.Pages, s.ThreadsPerPage, s.Attachments, s.Captcha, s.Description, s.InlineMedia, s.PreviewReplies)
+		}
+	}
+	return
 }
 
 func (self *PostgresDatabase) GetMessageIDByHash(hash string) (article ArticleEntry, err error) {
@@ -1206,6 +2045,47 @@ func (self *PostgresDatabase) GetMessageIDByHash(hash string) (article ArticleEn
 	return
 }
 
+func (self *PostgresDatabase) GetMessageIDByShortHash(hash string) (article ArticleEntry, err error) {
+	hash = strings.ToLower(hash)
+	if len(hash) < 10 || len(hash) > 40 || strings.Trim(hash, "0123456789abcdef") != "" {
+		err = errors.New("invalid message id hash")
+		return
+	}
+	err = self.conn.QueryRow("SELECT message_id, message_newsgroup FROM Articles WHERE message_id_hash LIKE $1 LIMIT 1", hash+"%").Scan(&article[0], &article[1])
+	return
+}
+
+// remember which posts a post quotes
+func (self *PostgresDatabase) registerBacklinks(msgid, message string, posted int64) {
+	for _, hash := range quotedHashes(message) {
+		quoted, err := self.GetMessageIDByShortHash(hash)
+		if err != nil || quoted.MessageID() == msgid {
+			continue
+		}
+		_, err = self.conn.Exec("INSERT INTO Backlinks(message_id, quoted_by, time_posted) SELECT $1, $2, $3 WHERE NOT EXISTS ( SELECT 1 FROM Backlinks WHERE message_id = $1 AND quoted_by = $2 )", quoted.MessageID(), msgid, posted)
+		if err != nil {
+			log.Println("failed to register backlink from", msgid, "to", quoted.MessageID(), err)
+		}
+	}
+}
+
+func (self *PostgresDatabase) GetBacklinks(msgid string) (links []Backlink, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT p.message_id, p.ref_id, p.newsgroup FROM Backlinks b INNER JOIN ArticlePosts p ON p.message_id = b.quoted_by WHERE b.message_id = $1 ORDER BY b.time_posted ASC", msgid)
+	if err == nil {
+		for rows.Next() {
+			var l Backlink
+			rows.Scan(&l.MessageID, &l.Root, &l.Newsgroup)
+			if l.Root == "" {
+				l.Root = l.MessageID
+			}
+			links = append(links, l)
+		}
+		rows.Close()
+	}
+	return
+}
+
 func (self *PostgresDatabase) BanAddr(addr string) (err error) {
 	_, err = self.conn.Exec("INSERT INTO IPBans(addr, made, expires) VALUES($1, $2, $3)", addr, timeNow(), -1)
 	return
@@ -1219,7 +2099,7 @@ func (self *PostgresDatabase) UnbanAddr(addr string) (err error) {
 
 func (self *PostgresDatabase) CheckEncIPBanned(encaddr string) (banned bool, err error) {
 	var result int64
-	err = self.conn.QueryRow("SELECT COUNT(*) FROM EncIPBans WHERE encaddr = $1", encaddr).Scan(&result)
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM EncIPBans WHERE encaddr = $1 AND ( expires < 0 OR expires > $2 )", encaddr, timeNow()).Scan(&result)
 	banned = result > 0
 	return
 }
@@ -1229,27 +2109,93 @@ func (self *PostgresDatabase) BanEncAddr(encaddr string) (err error) {
 	return
 }
 
-func (self *PostgresDatabase) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
-	var rows *sql.Rows
-	rows, err = self.conn.Query("WITH x(min_no, max_no) AS ( SELECT MIN(message_no) AS min_no, MAX(message_no) AS max_no FROM ArticleNumbers WHERE newsgroup = $1) SELECT CASE WHEN min_no IS NULL THEN 0 ELSE min_no END AS mn FROM x UNION SELECT CASE WHEN max_no IS NULL THEN 1 ELSE max_no END AS max_no FROM x", group)
-	if rows.Next() {
-		err = rows.Scan(&first)
+func (self *PostgresDatabase) BanEncAddrUntil(encaddr string, expires int64) (err error) {
+	var banned bool
+	banned, err = self.CheckEncIPBanned(encaddr)
+	if err == nil && !banned {
+		// clear out old bans that ran out
+		_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE encaddr = $1", encaddr)
 		if err == nil {
-			if rows.Next() {
-				err = rows.Scan(&last)
-			}
+			_, err = self.conn.Exec("INSERT INTO EncIPBans(encaddr, made, expires) VALUES($1, $2, $3)", encaddr, timeNow(), expires)
 		}
 	}
-	rows.Close()
-	return
-}
-
-func (self *PostgresDatabase) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
-	err = self.conn.QueryRow("SELECT message_id FROM ArticleNumbers WHERE newsgroup = $1 AND message_no = $2 LIMIT 1", group, id).Scan(&msgid)
 	return
 }
 
-func (self *PostgresDatabase) GetNNTPIDForMessageID(group, msgid string) (id int64, err error) {
+func (self *PostgresDatabase) GetBannedAddrs() (addrs []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT addr FROM IPBans UNION SELECT encaddr FROM EncIPBans WHERE expires < 0 OR expires > $1", timeNow())
+	if err == nil {
+		for rows.Next() {
+			var addr string
+			rows.Scan(&addr)
+			addrs = append(addrs, addr)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) getAddrBans(q string, args ...interface{}) (bans []AddrBan, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query(q, args...)
+	if err == nil {
+		for rows.Next() {
+			var b AddrBan
+			rows.Scan(&b.Addr, &b.Made, &b.Expires)
+			bans = append(bans, b)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetAddrBans() ([]AddrBan, error) {
+	return self.getAddrBans("SELECT addr, made, expires FROM IPBans ORDER BY made ASC")
+}
+
+func (self *PostgresDatabase) GetEncAddrBans() ([]AddrBan, error) {
+	return self.getAddrBans("SELECT encaddr, made, expires FROM EncIPBans WHERE expires < 0 OR expires > $1 ORDER BY made ASC", timeNow())
+}
+
+func (self *PostgresDatabase) ExplainIPBan(addr string) ([]AddrBan, error) {
+	return self.getAddrBans("SELECT addr, made, expires FROM IPBans WHERE addr >>= $1 ORDER BY made ASC", addr)
+}
+
+func (self *PostgresDatabase) UnbanCIDR(cidr string) (err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("DELETE FROM IPBans WHERE addr = $1", cidr)
+	if err == nil {
+		var n int64
+		n, err = res.RowsAffected()
+		if err == nil && n == 0 {
+			err = NoSuchBan
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("WITH x(min_no, max_no) AS ( SELECT MIN(message_no) AS min_no, MAX(message_no) AS max_no FROM ArticleNumbers WHERE newsgroup = $1) SELECT CASE WHEN min_no IS NULL THEN 0 ELSE min_no END AS mn FROM x UNION SELECT CASE WHEN max_no IS NULL THEN 1 ELSE max_no END AS max_no FROM x", group)
+	if rows.Next() {
+		err = rows.Scan(&first)
+		if err == nil {
+			if rows.Next() {
+				err = rows.Scan(&last)
+			}
+		}
+	}
+	rows.Close()
+	return
+}
+
+func (self *PostgresDatabase) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
+	err = self.conn.QueryRow("SELECT message_id FROM ArticleNumbers WHERE newsgroup = $1 AND message_no = $2 LIMIT 1", group, id).Scan(&msgid)
+	return
+}
+
+func (self *PostgresDatabase) GetNNTPIDForMessageID(group, msgid string) (id int64, err error) {
 	err = self.conn.QueryRow("SELECT message_no FROM ArticleNumbers WHERE newsgroup = $1 AND message_id = $2 LIMIT 1", group, msgid).Scan(&id)
 	return
 }
@@ -1403,6 +2349,48 @@ func (self *PostgresDatabase) CheckNNTPUserExists(username string) (exists bool,
 	return
 }
 
+func (self *PostgresDatabase) GetNNTPUser(username string) (user *NNTPUser, err error) {
+	u := NNTPUser{Username: username}
+	err = self.conn.QueryRow("SELECT role, disabled FROM NNTPUsers WHERE username = $1", username).Scan(&u.Role, &u.Disabled)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err == nil {
+		user = &u
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetNNTPUsers() (users []NNTPUser, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT username, role, disabled FROM NNTPUsers ORDER BY username ASC")
+	if err == nil {
+		for rows.Next() {
+			var u NNTPUser
+			rows.Scan(&u.Username, &u.Role, &u.Disabled)
+			users = append(users, u)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) SetNNTPPassword(username, passwd string) (err error) {
+	login_salt := genLoginCredSalt()
+	login_hash := nntpLoginCredHash(passwd, login_salt)
+	_, err = self.conn.Exec("UPDATE NNTPUsers SET login_hash = $2, login_salt = $3 WHERE username = $1", username, login_hash, login_salt)
+	return
+}
+
+func (self *PostgresDatabase) SetNNTPUserRole(username, role string) (err error) {
+	_, err = self.conn.Exec("UPDATE NNTPUsers SET role = $2 WHERE username = $1", username, role)
+	return
+}
+
+func (self *PostgresDatabase) SetNNTPUserDisabled(username string, disabled bool) (err error) {
+	_, err = self.conn.Exec("UPDATE NNTPUsers SET disabled = $2 WHERE username = $1", username, disabled)
+	return
+}
+
 func (self *PostgresDatabase) GetHeadersForMessage(msgid string) (hdr ArticleHeaders, err error) {
 	var rows *sql.Rows
 	rows, err = self.conn.Query("SELECT header_name, header_value FROM NNTPHeaders WHERE header_article_message_id = $1", msgid)
@@ -1439,10 +2427,24 @@ func (self *PostgresDatabase) GetMessageIDByCIDR(cidr *net.IPNet) (msgids []stri
 func (self *PostgresDatabase) GetMessageIDByEncryptedIP(encaddr string) (msgids []string, err error) {
 	var rows *sql.Rows
 	rows, err = self.conn.Query("SELECT message_id FROM ArticlePosts WHERE addr = $1", encaddr)
-	for err == nil && rows.Next() {
-		var msgid string
-		err = rows.Scan(&msgid)
-		if err == nil {
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetMessageIDByPubkey(pubkey string) (msgids []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id FROM ArticleKeys WHERE pubkey = $1", pubkey)
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
 			msgids = append(msgids, msgid)
 		}
 		rows.Close()
@@ -1450,15 +2452,201 @@ func (self *PostgresDatabase) GetMessageIDByEncryptedIP(encaddr string) (msgids
 	return
 }
 
+func (self *PostgresDatabase) GetSignedArticles() (signed map[string]string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id, pubkey FROM ArticleKeys")
+	if err == nil {
+		signed = make(map[string]string)
+		for rows.Next() {
+			var msgid, pubkey string
+			rows.Scan(&msgid, &pubkey)
+			signed[msgid] = pubkey
+		}
+		rows.Close()
+	}
+	return
+}
+
 func (self *PostgresDatabase) BanPubkey(pubkey string) (err error) {
-	// TODO: implement
-	err = errors.New("ban pubkey not implemented")
+	var banned bool
+	banned, err = self.PubkeyIsBanned(pubkey)
+	if err == nil && !banned {
+		_, err = self.conn.Exec("INSERT INTO BannedPubkeys(pubkey, time_banned) VALUES($1, $2)", pubkey, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnbanPubkey(pubkey string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedPubkeys WHERE pubkey = $1", pubkey)
+	return
+}
+
+func (self *PostgresDatabase) PubkeyIsBanned(pubkey string) (banned bool, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM BannedPubkeys WHERE pubkey = $1", pubkey).Scan(&count)
+	banned = count > 0
+	return
+}
+
+func (self *PostgresDatabase) UnbanEncAddr(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM EncIPBans WHERE encaddr = $1", encaddr)
+	return
+}
+
+func (self *PostgresDatabase) ShadowBanEncAddr(encaddr string) (err error) {
+	var banned bool
+	banned, err = self.CheckEncAddrShadowBanned(encaddr)
+	if err == nil && !banned {
+		_, err = self.conn.Exec("INSERT INTO EncIPShadowBans(encaddr, made) VALUES($1, $2)", encaddr, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnshadowBanEncAddr(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM EncIPShadowBans WHERE encaddr = $1", encaddr)
+	return
+}
+
+func (self *PostgresDatabase) CheckEncAddrShadowBanned(encaddr string) (banned bool, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM EncIPShadowBans WHERE encaddr = $1", encaddr).Scan(&count)
+	banned = count > 0
+	return
+}
+
+func (self *PostgresDatabase) AddBanAppeal(encaddr, text string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM BanAppeals WHERE encaddr = $1", encaddr).Scan(&count)
+	if err == nil {
+		if count > 0 {
+			err = BanAppealExists
+		} else {
+			_, err = self.conn.Exec("INSERT INTO BanAppeals(encaddr, appeal, status, time_appealed) VALUES($1, $2, $3, $4)", encaddr, text, BanAppealPending, timeNow())
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetBanAppeal(encaddr string) (appeal *BanAppeal, err error) {
+	a := BanAppeal{EncAddr: encaddr}
+	err = self.conn.QueryRow("SELECT appeal, status, time_appealed FROM BanAppeals WHERE encaddr = $1", encaddr).Scan(&a.Text, &a.Status, &a.Appealed)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err == nil {
+		appeal = &a
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetPendingBanAppeals() (appeals []BanAppeal, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT encaddr, appeal, status, time_appealed FROM BanAppeals WHERE status = $1 ORDER BY time_appealed ASC", BanAppealPending)
+	if err == nil {
+		for rows.Next() {
+			var a BanAppeal
+			rows.Scan(&a.EncAddr, &a.Text, &a.Status, &a.Appealed)
+			appeals = append(appeals, a)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) UpholdBanAppeal(encaddr string) (err error) {
+	_, err = self.conn.Exec("UPDATE BanAppeals SET status = $1 WHERE encaddr = $2", BanAppealUpheld, encaddr)
+	return
+}
+
+func (self *PostgresDatabase) DeleteBanAppeal(encaddr string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BanAppeals WHERE encaddr = $1", encaddr)
+	return
+}
+
+func (self *PostgresDatabase) SetThreadFlag(root_message_id, newsgroup, flag string, on bool) (err error) {
+	if !on {
+		_, err = self.conn.Exec("DELETE FROM ThreadFlags WHERE root_message_id = $1 AND flag = $2", root_message_id, flag)
+	} else if !self.CheckThreadFlag(root_message_id, flag) {
+		_, err = self.conn.Exec("INSERT INTO ThreadFlags(root_message_id, newsgroup, flag, time_set) VALUES($1, $2, $3, $4)", root_message_id, newsgroup, flag, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) CheckThreadFlag(root_message_id, flag string) bool {
+	var count int64
+	err := self.conn.QueryRow("SELECT COUNT(*) FROM ThreadFlags WHERE root_message_id = $1 AND flag = $2", root_message_id, flag).Scan(&count)
+	if err != nil {
+		log.Println("failed to check thread flag", flag, "on", root_message_id, err)
+	}
+	return count > 0
+}
+
+func (self *PostgresDatabase) GetThreadsWithFlag(newsgroup, flag string) (roots []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT root_message_id FROM ThreadFlags WHERE newsgroup = $1 AND flag = $2 ORDER BY time_set ASC", newsgroup, flag)
+	if err == nil {
+		for rows.Next() {
+			var root string
+			rows.Scan(&root)
+			roots = append(roots, root)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) BanAttachment(hash string) (err error) {
+	var banned bool
+	banned, err = self.AttachmentBanned(hash)
+	if err == nil && !banned {
+		_, err = self.conn.Exec("INSERT INTO BannedAttachments(sha_hash, time_banned) VALUES($1, $2)", hash, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnbanAttachment(hash string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BannedAttachments WHERE sha_hash = $1", hash)
+	return
+}
+
+func (self *PostgresDatabase) AttachmentBanned(hash string) (banned bool, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM BannedAttachments WHERE sha_hash = $1", hash).Scan(&count)
+	banned = count > 0
+	return
+}
+
+func (self *PostgresDatabase) GetBannedAttachments() (hashes []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT sha_hash FROM BannedAttachments ORDER BY time_banned ASC")
+	if err == nil {
+		for rows.Next() {
+			var hash string
+			rows.Scan(&hash)
+			hashes = append(hashes, hash)
+		}
+		rows.Close()
+	}
 	return
 }
 
-func (self *PostgresDatabase) PubkeyIsBanned(pubkey string) (bool, error) {
-	// TODO: implement
-	return false, nil
+func (self *PostgresDatabase) GetAttachmentHashes() (hashes map[string]bool, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT DISTINCT sha_hash FROM ArticleAttachments")
+	if err == nil {
+		hashes = make(map[string]bool)
+		for rows.Next() {
+			var hash string
+			rows.Scan(&hash)
+			hashes[hash] = true
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) AttachmentReferenced(hash string) (referenced bool, err error) {
+	err = self.conn.QueryRow("SELECT EXISTS(SELECT 1 FROM ArticleAttachments WHERE sha_hash = $1)", hash).Scan(&referenced)
+	return
 }
 
 func (self *PostgresDatabase) GetPostsBefore(t time.Time) (msgids []string, err error) {
@@ -1475,26 +2663,345 @@ func (self *PostgresDatabase) GetPostsBefore(t time.Time) (msgids []string, err
 	return
 }
 
+func (self *PostgresDatabase) GetPostsBeforeInGroup(group string, t time.Time) (msgids []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id FROM ArticlePosts WHERE newsgroup = $1 AND time_posted < $2", group, t.Unix())
+	if err == nil {
+		for rows.Next() {
+			var msgid string
+			rows.Scan(&msgid)
+			msgids = append(msgids, msgid)
+		}
+		rows.Close()
+	}
+	return
+}
+
 func (self *PostgresDatabase) GetPostingStats(gran, begin, end int64) (st PostingStats, err error) {
 	return
 }
 
 func (self *PostgresDatabase) SearchQuery(prefix, group string, text string) (posts []PostModel, err error) {
-	posts = []PostModel{}
-	text = "%" + text + "%"
-	var rows *sql.Rows
-	if group == "" {
-		rows, err = self.conn.Query("SELECT newsgroup, message_id, ref_id, message, name, subject, time_posted FROM ArticlePosts WHERE message LIKE $1 ORDER BY time_posted DESC", text)
-	} else {
-		rows, err = self.conn.Query("SELECT newsgroup, message_id, ref_id, message, name, subject, time_posted FROM ArticlePosts WHERE newsgroup = $1 AND message LIKE $2 ORDER BY time_posted DESC", group, text)
+	posts, _, err = self.SearchPosts(prefix, SearchParams{Text: text, Newsgroup: group})
+	if posts == nil {
+		posts = []PostModel{}
+	}
+	return
+}
+
+// escape LIKE wildcards so they match literally
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+func (self *PostgresDatabase) SearchPosts(prefix string, q SearchParams) (posts []PostModel, total int64, err error) {
+	args := []interface{}{"%" + likeEscaper.Replace(q.Text) + "%"}
+	where := []string{"message ILIKE $1", "newsgroup != 'ctl'"}
+	if q.Newsgroup != "" {
+		args = append(args, q.Newsgroup)
+		where = append(where, fmt.Sprintf("newsgroup = $%d", len(args)))
+	}
+	if q.After > 0 {
+		args = append(args, q.After)
+		where = append(where, fmt.Sprintf("time_posted >= $%d", len(args)))
+	}
+	if q.Before > 0 {
+		args = append(args, q.Before)
+		where = append(where, fmt.Sprintf("time_posted < $%d", len(args)))
+	}
+	cond := strings.Join(where, " AND ")
+	err = self.conn.QueryRow("SELECT COUNT(message_id) FROM ArticlePosts WHERE "+cond, args...).Scan(&total)
+	if err != nil || total == 0 {
+		return
+	}
+	query := "SELECT newsgroup, message_id, ref_id, message, name, subject, time_posted FROM ArticlePosts WHERE " + cond + " ORDER BY time_posted DESC"
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
 	}
+	var rows *sql.Rows
+	rows, err = self.conn.Query(query, args...)
 	if err == nil {
 		for rows.Next() {
 			p := new(post)
 			rows.Scan(&p.board, &p.Message_id, &p.Parent, &p.PostMessage, &p.PostName, &p.PostSubject, &p.Posted)
+			p.prefix = prefix
+			p.op = len(p.Parent) == 0
+			if p.op {
+				p.Parent = p.Message_id
+			}
+			p.sage = isSage(p.PostSubject)
 			posts = append(posts, p)
 		}
 		rows.Close()
 	}
 	return
 }
+
+func (self *PostgresDatabase) MarkArticleOffered(feedname, msgid string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO FeedOfferedArticles(feedname, message_id, time_offered) VALUES($1, $2, $3) ON CONFLICT DO NOTHING", feedname, msgid, timeNow())
+	return
+}
+
+func (self *PostgresDatabase) FeedsOffered(msgid string) (feeds map[string]bool, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT feedname FROM FeedOfferedArticles WHERE message_id = $1", msgid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	feeds = make(map[string]bool)
+	for rows.Next() {
+		var feedname string
+		rows.Scan(&feedname)
+		feeds[feedname] = true
+	}
+	err = rows.Err()
+	return
+}
+
+func (self *PostgresDatabase) SetCtlFeed(msgid, feedname string) (err error) {
+	_, err = self.conn.Exec("INSERT INTO CtlFeeds(message_id, feedname, time_received) VALUES($1, $2, $3) ON CONFLICT DO NOTHING", msgid, feedname, timeNow())
+	return
+}
+
+func (self *PostgresDatabase) TakeCtlFeed(msgid string) (feedname string, remote bool, err error) {
+	err = self.conn.QueryRow("DELETE FROM CtlFeeds WHERE message_id = $1 RETURNING feedname", msgid).Scan(&feedname)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err == nil {
+		remote = true
+	}
+	return
+}
+
+func (self *PostgresDatabase) ClearOfferedArticles(feedname string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM FeedOfferedArticles WHERE feedname = $1", feedname)
+	return
+}
+
+func (self *PostgresDatabase) AddReport(msgid, newsgroup, reason string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM PostReports WHERE message_id = $1 AND reason = $2", msgid, reason).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO PostReports(message_id, newsgroup, reason, time_reported) VALUES($1, $2, $3, $4)", msgid, newsgroup, reason, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetReports(newsgroup string) (reports []PostReport, err error) {
+	var rows *sql.Rows
+	if newsgroup == "" {
+		rows, err = self.conn.Query("SELECT message_id, newsgroup, reason, time_reported FROM PostReports ORDER BY time_reported DESC")
+	} else {
+		rows, err = self.conn.Query("SELECT message_id, newsgroup, reason, time_reported FROM PostReports WHERE newsgroup = $1 ORDER BY time_reported DESC", newsgroup)
+	}
+	if err == nil {
+		for rows.Next() {
+			var r PostReport
+			rows.Scan(&r.MessageID, &r.Newsgroup, &r.Reason, &r.Reported)
+			reports = append(reports, r)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) DismissReports(msgid string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM PostReports WHERE message_id = $1", msgid)
+	return
+}
+
+func (self *PostgresDatabase) QuarantineArticle(msgid, newsgroup string, score float64) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM QuarantinedArticles WHERE message_id = $1", msgid).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO QuarantinedArticles(message_id, newsgroup, score, time_quarantined) VALUES($1, $2, $3, $4)", msgid, newsgroup, score, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetQuarantinedArticles(newsgroup string) (articles []QuarantinedArticle, err error) {
+	var rows *sql.Rows
+	if newsgroup == "" {
+		rows, err = self.conn.Query("SELECT message_id, newsgroup, score, time_quarantined, feed FROM QuarantinedArticles ORDER BY time_quarantined DESC")
+	} else {
+		rows, err = self.conn.Query("SELECT message_id, newsgroup, score, time_quarantined, feed FROM QuarantinedArticles WHERE newsgroup = $1 ORDER BY time_quarantined DESC", newsgroup)
+	}
+	if err == nil {
+		for rows.Next() {
+			var a QuarantinedArticle
+			rows.Scan(&a.MessageID, &a.Newsgroup, &a.Score, &a.Quarantined, &a.Feed)
+			articles = append(articles, a)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnquarantineArticle(msgid string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM QuarantinedArticles WHERE message_id = $1", msgid)
+	return
+}
+
+func (self *PostgresDatabase) RecordRejectedArticle(msgid, newsgroup, reason, feed string) (err error) {
+	err = self.ForgetRejectedArticle(msgid)
+	if err == nil {
+		_, err = self.conn.Exec("INSERT INTO RejectedArticles(message_id, newsgroup, reason, feed, time_rejected) VALUES($1, $2, $3, $4, $5)", msgid, newsgroup, reason, feed, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetRejectedArticles() (articles []RejectedArticle, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT message_id, newsgroup, reason, feed, time_rejected FROM RejectedArticles ORDER BY time_rejected DESC")
+	if err == nil {
+		for rows.Next() {
+			var a RejectedArticle
+			rows.Scan(&a.MessageID, &a.Newsgroup, &a.Reason, &a.Feed, &a.Rejected)
+			articles = append(articles, a)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) ForgetRejectedArticle(msgid string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM RejectedArticles WHERE message_id = $1", msgid)
+	return
+}
+
+func (self *PostgresDatabase) CacheAddrListing(addr string, lists []string, expires int64) (err error) {
+	// drop everything that expired while we are at it
+	_, err = self.conn.Exec("DELETE FROM AddrListings WHERE addr = $1 OR expires <= $2", addr, timeNow())
+	if err == nil {
+		_, err = self.conn.Exec("INSERT INTO AddrListings(addr, lists, expires) VALUES($1, $2, $3)", addr, strings.Join(lists, " "), expires)
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetAddrListing(addr string) (lists []string, cached bool, err error) {
+	var str string
+	err = self.conn.QueryRow("SELECT lists FROM AddrListings WHERE addr = $1 AND expires > $2", addr, timeNow()).Scan(&str)
+	if err == sql.ErrNoRows {
+		err = nil
+	} else if err == nil {
+		cached = true
+		lists = strings.Fields(str)
+	}
+	return
+}
+
+func (self *PostgresDatabase) SetPubkeyTrusted(pubkey string, trusted bool) (err error) {
+	_, err = self.conn.Exec("DELETE FROM TrustedPubkeys WHERE pubkey = $1", pubkey)
+	if err == nil && trusted {
+		_, err = self.conn.Exec("INSERT INTO TrustedPubkeys(pubkey, time_trusted) VALUES($1, $2)", pubkey, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) PubkeyTrusted(pubkey string) (trusted bool, err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM TrustedPubkeys WHERE pubkey = $1", pubkey).Scan(&count)
+	trusted = count > 0
+	return
+}
+
+func (self *PostgresDatabase) HoldArticleFromFeed(msgid, newsgroup, feed string) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM QuarantinedArticles WHERE message_id = $1", msgid).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO QuarantinedArticles(message_id, newsgroup, score, time_quarantined, feed) VALUES($1, $2, 0, $3, $4)", msgid, newsgroup, timeNow(), feed)
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetFeedIntake(feed string) (intake FeedIntake, err error) {
+	intake.Feed = feed
+	err = self.conn.QueryRow("SELECT streak, trusted FROM FeedIntake WHERE feed = $1", feed).Scan(&intake.Streak, &intake.Trusted)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+func (self *PostgresDatabase) SetFeedIntake(intake FeedIntake) (err error) {
+	var res sql.Result
+	res, err = self.conn.Exec("UPDATE FeedIntake SET streak = $2, trusted = $3 WHERE feed = $1", intake.Feed, intake.Streak, intake.Trusted)
+	if err == nil {
+		var n int64
+		n, err = res.RowsAffected()
+		if err == nil && n == 0 {
+			_, err = self.conn.Exec("INSERT INTO FeedIntake(feed, streak, trusted) VALUES($1, $2, $3)", intake.Feed, intake.Streak, intake.Trusted)
+		}
+	}
+	return
+}
+
+func (self *PostgresDatabase) SubscribeBanlist(pubkey string) (err error) {
+	if !self.BanlistSubscribed(pubkey) {
+		_, err = self.conn.Exec("INSERT INTO BanlistSubscriptions(pubkey, time_subscribed) VALUES($1, $2)", pubkey, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) UnsubscribeBanlist(pubkey string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BanlistSubscriptions WHERE pubkey = $1", pubkey)
+	return
+}
+
+func (self *PostgresDatabase) BanlistSubscribed(pubkey string) bool {
+	var count int64
+	self.conn.QueryRow("SELECT COUNT(*) FROM BanlistSubscriptions WHERE pubkey = $1", pubkey).Scan(&count)
+	return count > 0
+}
+
+func (self *PostgresDatabase) GetBanlistSubscriptions() (pubkeys []string, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT pubkey FROM BanlistSubscriptions ORDER BY time_subscribed ASC")
+	if err == nil {
+		for rows.Next() {
+			var pk string
+			rows.Scan(&pk)
+			pubkeys = append(pubkeys, pk)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) AddBanlistEntry(source, kind, value string, applied bool) (err error) {
+	var count int64
+	err = self.conn.QueryRow("SELECT COUNT(*) FROM BanlistEntries WHERE source = $1 AND kind = $2 AND value = $3", source, kind, value).Scan(&count)
+	if err == nil && count == 0 {
+		_, err = self.conn.Exec("INSERT INTO BanlistEntries(source, kind, value, applied, time_added) VALUES($1, $2, $3, $4, $5)", source, kind, value, applied, timeNow())
+	}
+	return
+}
+
+func (self *PostgresDatabase) GetBanlistEntries(source string) (entries []BanlistEntry, err error) {
+	var rows *sql.Rows
+	rows, err = self.conn.Query("SELECT source, kind, value, applied, time_added FROM BanlistEntries WHERE source = $1 ORDER BY time_added ASC", source)
+	if err == nil {
+		for rows.Next() {
+			var e BanlistEntry
+			rows.Scan(&e.Source, &e.Kind, &e.Value, &e.Applied, &e.Added)
+			entries = append(entries, e)
+		}
+		rows.Close()
+	}
+	return
+}
+
+func (self *PostgresDatabase) RemoveBanlistEntry(source, kind, value string) (err error) {
+	_, err = self.conn.Exec("DELETE FROM BanlistEntries WHERE source = $1 AND kind = $2 AND value = $3", source, kind, value)
+	return
+}
+
+func (self *PostgresDatabase) BanlistEntryListed(kind, value string) bool {
+	var count int64
+	self.conn.QueryRow("SELECT COUNT(*) FROM BanlistEntries WHERE kind = $1 AND value = $2", kind, value).Scan(&count)
+	return count > 0
+}
@@ -0,0 +1,117 @@
+//
+// postguard.go -- keeping other sites and dumb bots from submitting the post form
+//
+
+package srnd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// form field people leave empty because they never see it, bots fill it in
+const postHoneypotField = "website"
+
+// form field with the session's post token
+const postTokenField = "post_token"
+
+// a post from a page that isn't ours
+var CrossOriginPost = errors.New("post does not come from this site")
+
+// a post with the honeypot filled in
+var HoneypotPost = errors.New("post looks automated")
+
+// a post without the right token for the session
+var BadPostToken = errors.New("bad post token, reload the page and try again")
+
+// return true if the request came from a page on the host it was sent to
+// requests that say nothing about where they came from fail, browsers send at least an origin with a post
+func sameOrigin(r *http.Request) bool {
+	from := r.Header.Get("Origin")
+	if from == "" || from == "null" {
+		from = r.Referer()
+	}
+	if from == "" {
+		return false
+	}
+	u, err := url.Parse(from)
+	return err == nil && u.Host == requestHost(r)
+}
+
+// get the post token of a session, makes one if it has none
+func postToken(sess *sessions.Session) string {
+	token, _ := sess.Values[postTokenField].(string)
+	if token == "" {
+		token = randStr(32)
+		sess.Values[postTokenField] = token
+	}
+	return token
+}
+
+// the honeypot and post token fields for a post form
+// static pages don't know the session so with no token a script asks /post_token for it
+func postGuardFields(prefix, token string) string {
+	fields := fmt.Sprintf(`<input type="text" name="%s" value="" autocomplete="off" tabindex="-1" style="display:none"><input type="hidden" name="%s" value="%s">`, postHoneypotField, postTokenField, html.EscapeString(token))
+	if token == "" {
+		fields += fmt.Sprintf(`<script>(function(f){var x=new XMLHttpRequest();x.onload=function(){try{f.value=JSON.parse(x.responseText).token}catch(e){}};x.open("GET",%q);x.send()})(document.currentScript.previousSibling)</script>`, prefix+"post_token")
+	}
+	return fields
+}
+
+// put the honeypot and post token fields in a rendered post form unless its template has them already
+func guardPostForm(form, prefix, token string) string {
+	if strings.Contains(form, `name="`+postTokenField+`"`) {
+		return form
+	}
+	idx := strings.LastIndex(form, "</form>")
+	if idx == -1 {
+		return form
+	}
+	return form[:idx] + postGuardFields(prefix, token) + form[idx:]
+}
+
+// check a post token against the session's
+// an empty token is only fine if we don't require them
+func checkPostToken(sess *sessions.Session, token string, required bool) bool {
+	if token == "" {
+		return !required
+	}
+	expected, _ := sess.Values[postTokenField].(string)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// check where a post came from before we look at it
+func (self *httpFrontend) checkPostOrigin(r *http.Request, sess *sessions.Session, token, honeypot string) error {
+	if !sameOrigin(r) {
+		return CrossOriginPost
+	}
+	if honeypot != "" {
+		return HoneypotPost
+	}
+	if !checkPostToken(sess, token, self.requirePostToken) {
+		return BadPostToken
+	}
+	return nil
+}
+
+// GET /post_token
+// the session's post token for the post form's hidden field
+func (self *httpFrontend) handle_post_token(wr http.ResponseWriter, r *http.Request) {
+	sess, err := self.store.Get(r, self.name)
+	if err != nil {
+		http.Error(wr, err.Error(), 500)
+		return
+	}
+	token := postToken(sess)
+	sess.Save(r, wr)
+	wr.Header().Set("Content-Type", "text/json; encoding=UTF-8")
+	wr.Header().Set("Cache-Control", "private, no-store")
+	json.NewEncoder(wr).Encode(map[string]string{"token": token, "field": postTokenField})
+}
@@ -0,0 +1,51 @@
+package srnd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+
+	r, _ := http.NewRequest("POST", "http://example.com/post/overchan.test", nil)
+	if sameOrigin(r) {
+		t.Error("posts that don't say where they come from should not pass")
+	}
+	r.Header.Set("Origin", "null")
+	if sameOrigin(r) {
+		t.Error("posts from a null origin without a referer should not pass")
+	}
+	r.Header.Set("Referer", "http://example.com/overchan.test-0.html")
+	if !sameOrigin(r) {
+		t.Error("posts from our own pages should pass")
+	}
+	r.Header.Set("Origin", "http://evil.example")
+	if sameOrigin(r) {
+		t.Error("posts from other sites should not pass")
+	}
+
+}
+
+func TestGuardPostForm(t *testing.T) {
+
+	form := guardPostForm(`<form action="/post/overchan.test"><input name="message"></form>`, "/", "abc")
+	if !strings.Contains(form, `name="`+postHoneypotField+`"`) {
+		t.Error("no honeypot in the form", form)
+	}
+	if !strings.Contains(form, `name="`+postTokenField+`" value="abc"`) {
+		t.Error("no post token in the form", form)
+	}
+	if !strings.HasSuffix(form, "</form>") || strings.Contains(form, "<script>") {
+		t.Error("fields not put inside the form", form)
+	}
+	static := guardPostForm(`<form action="/post/overchan.test"></form>`, "/", "")
+	if !strings.Contains(static, `"/post_token"`) {
+		t.Error("static form does not ask for its token", static)
+	}
+	own := `<form><input type="hidden" name="` + postTokenField + `" value="abc"></form>`
+	if guardPostForm(own, "/", "abc") != own {
+		t.Error("form with its own fields was changed")
+	}
+
+}
@@ -0,0 +1,55 @@
+//
+// postlimits.go -- how long the fields of posts from the web can be
+//
+
+package srnd
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// limits on web posts in characters, 0 for no limit
+type postLimits struct {
+	subject int
+	name    int
+	message int
+	// lines in the message
+	lines int
+}
+
+func postLimitsFromConfig(config map[string]string) postLimits {
+	return postLimits{
+		subject: mapGetInt(config, "max_subject", 256),
+		name:    mapGetInt(config, "max_name", 128),
+		message: mapGetInt(config, "max_message", 1024*1024),
+		lines:   mapGetInt(config, "max_lines", 0),
+	}
+}
+
+func checkFieldLength(field, value string, limit int) error {
+	if limit > 0 {
+		if n := utf8.RuneCountInString(value); n > limit {
+			return fmt.Errorf("%s is too long, %d characters where %d is the most you can have", field, n, limit)
+		}
+	}
+	return nil
+}
+
+// check a post against the limits, returns an error saying what is wrong with it
+func (self postLimits) Check(pr *postRequest) (err error) {
+	err = checkFieldLength("subject", pr.Subject, self.subject)
+	if err == nil {
+		err = checkFieldLength("name", pr.Name, self.name)
+	}
+	if err == nil {
+		err = checkFieldLength("message", pr.Message, self.message)
+	}
+	if err == nil && self.lines > 0 {
+		if n := strings.Count(strings.TrimRight(pr.Message, "\n"), "\n") + 1; n > self.lines {
+			err = fmt.Errorf("message has %d lines where %d is the most you can have", n, self.lines)
+		}
+	}
+	return
+}
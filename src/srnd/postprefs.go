@@ -0,0 +1,128 @@
+//
+// postprefs.go -- remembering what a poster puts in the form, kept in a signed cookie
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// cookie holding the poster's form settings
+const posterCookie = "poster"
+
+// most boards we remember options for, the cookie has to stay small
+const posterPrefsMaxBoards = 32
+
+// what the poster last put in the form
+type posterPrefs struct {
+	Name  string
+	Email string
+	// options field by board, so sage on one board doesn't follow them everywhere
+	Boards map[string]string
+}
+
+// get the options field the poster last used on board
+func (self *posterPrefs) OptionsFor(board string) string {
+	if opts, ok := self.Boards[board]; ok {
+		return opts
+	}
+	return self.Email
+}
+
+// remember a post's form settings
+// the tripcode secret after # in the name is never kept, the cookie can be read on the poster's machine
+func (self *posterPrefs) Remember(pr *postRequest) {
+	self.Name = pr.Name
+	if idx := strings.Index(self.Name, "#"); idx >= 0 {
+		self.Name = strings.Trim(self.Name[:idx], "\t ")
+	}
+	self.Email = pr.Email
+	if self.Boards == nil {
+		self.Boards = make(map[string]string)
+	}
+	if _, ok := self.Boards[pr.Group]; !ok && len(self.Boards) >= posterPrefsMaxBoards {
+		// forget some other board, which one does not matter much
+		for board := range self.Boards {
+			delete(self.Boards, board)
+			break
+		}
+	}
+	self.Boards[pr.Group] = pr.Email
+}
+
+// get the session with the poster's settings, it lives much longer than the captcha session
+func (self *httpFrontend) posterSession(r *http.Request) *sessions.Session {
+	sess, _ := self.store.Get(r, posterCookie)
+	sess.Options = &sessions.Options{
+		Path:     self.prefix,
+		MaxAge:   365 * 24 * 3600,
+		HttpOnly: true,
+	}
+	return sess
+}
+
+// get the poster's settings, empty if they have none or remembering is off
+func (self *httpFrontend) posterPrefs(r *http.Request) (prefs posterPrefs) {
+	if !self.rememberPoster {
+		return
+	}
+	data, _ := self.posterSession(r).Values["prefs"].(string)
+	if data != "" {
+		json.Unmarshal([]byte(data), &prefs)
+	}
+	return
+}
+
+// save a post's form settings for the next one, must be called before the response is written
+func (self *httpFrontend) savePosterPrefs(wr http.ResponseWriter, r *http.Request, pr *postRequest) {
+	if !self.rememberPoster {
+		return
+	}
+	prefs := self.posterPrefs(r)
+	prefs.Remember(pr)
+	data, err := json.Marshal(prefs)
+	if err == nil {
+		sess := self.posterSession(r)
+		sess.Values["prefs"] = string(data)
+		sess.Save(r, wr)
+	}
+}
+
+// GET /postform/{board}?reference=msgid
+// the post form filled in with what the poster used last, pages embed it so they stay static
+func (self *httpFrontend) handle_postform_page(wr http.ResponseWriter, r *http.Request) {
+	board := mux.Vars(r)["board"]
+	if !newsgroupValidFormat(board) {
+		wr.WriteHeader(404)
+		template.writeTemplate("404.mustache", map[string]interface{}{"prefix": self.prefix, "i18n": i18nForRequest(r, "")}, wr)
+		return
+	}
+	ref := r.URL.Query().Get("reference")
+	if ref != "" && !ValidMessageID(ref) {
+		ref = ""
+	}
+	prefs := self.posterPrefs(r)
+	settings, _ := self.daemon.database.GetBoardSettings(board)
+	param := postFormParam(self.prefix, board, ref, self.attachments && settings.Attachments)
+	param["name"] = prefs.Name
+	param["email"] = prefs.OptionsFor(board)
+	// no javascript to ask for the post token, so it goes in the form
+	var token string
+	sess, err := self.store.Get(r, self.name)
+	if err == nil {
+		token = postToken(sess)
+		param["post_token"] = token
+		sess.Save(r, wr)
+	}
+	param["i18n"] = i18nForRequest(r, board)
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Cache-Control", "private, no-cache")
+	wr.Header().Set("Vary", "Cookie")
+	io.WriteString(wr, guardPostForm(template.renderTemplate("postform.mustache", param), self.prefix, token))
+}
@@ -0,0 +1,40 @@
+package srnd
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPosterPrefs(t *testing.T) {
+
+	var prefs posterPrefs
+	prefs.Remember(&postRequest{Name: "anon", Email: "sage", Group: "overchan.test"})
+	prefs.Remember(&postRequest{Name: "anon", Email: "", Group: "overchan.random"})
+	if prefs.OptionsFor("overchan.test") != "sage" {
+		t.Error("should remember options per board", prefs)
+	}
+	if prefs.OptionsFor("overchan.new") != "" {
+		t.Error("boards never posted on should get the last options", prefs)
+	}
+	for idx := 0; idx < posterPrefsMaxBoards*2; idx++ {
+		prefs.Remember(&postRequest{Group: "overchan.board" + strconv.Itoa(idx)})
+	}
+	if len(prefs.Boards) > posterPrefsMaxBoards {
+		t.Error("should not remember too many boards", len(prefs.Boards))
+	}
+
+}
+
+func TestPosterPrefsTripcode(t *testing.T) {
+
+	var prefs posterPrefs
+	prefs.Remember(&postRequest{Name: "anon #secret", Group: "overchan.test"})
+	if prefs.Name != "anon" {
+		t.Error("tripcode secret was remembered", prefs.Name)
+	}
+	prefs.Remember(&postRequest{Name: "##secure", Group: "overchan.test"})
+	if prefs.Name != "" {
+		t.Error("secure tripcode secret was remembered", prefs.Name)
+	}
+
+}
@@ -0,0 +1,161 @@
+//
+// pow.go -- hashcash style proof of work for posting
+//
+
+package srnd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a stamp is bits:unixtime:newsgroup:sha256 of message:nonce
+// it is valid if the sha256 of the stamp starts with at least bits zero bits
+// the message hash is of the message with all \r removed and surrounding whitespace trimmed
+
+var ProofOfWorkMissing = errors.New("proof of work required")
+var ProofOfWorkInvalid = errors.New("invalid proof of work")
+var ProofOfWorkTooWeak = errors.New("proof of work too weak")
+var ProofOfWorkExpired = errors.New("proof of work expired")
+var ProofOfWorkSpent = errors.New("proof of work already used")
+
+// get the hex sha256 of a message that a stamp commits to
+func powMessageHash(message string) string {
+	message = strings.TrimSpace(strings.Replace(message, "\r", "", -1))
+	h := sha256.Sum256([]byte(message))
+	return hexify(h[:])
+}
+
+// count the leading zero bits of a hash
+func powLeadingZeros(h []byte) (bits int) {
+	for _, b := range h {
+		if b == 0 {
+			bits += 8
+			continue
+		}
+		for b&0x80 == 0 {
+			bits++
+			b <<= 1
+		}
+		break
+	}
+	return
+}
+
+// make a stamp for a message by brute force
+func mintProofOfWork(bits int, posted time.Time, newsgroup, message string) string {
+	prefix := fmt.Sprintf("%d:%d:%s:%s:", bits, posted.Unix(), newsgroup, powMessageHash(message))
+	for nonce := uint64(0); ; nonce++ {
+		stamp := prefix + strconv.FormatUint(nonce, 16)
+		h := sha256.Sum256([]byte(stamp))
+		if powLeadingZeros(h[:]) >= bits {
+			return stamp
+		}
+	}
+}
+
+type powVerifier struct {
+	access sync.Mutex
+	// bits every post needs
+	bits int
+	// never ask for less or more than this
+	min_bits int
+	max_bits int
+	// one more bit per this many posts to a newsgroup in the last hour, 0 to disable
+	load_posts int64
+	// one less bit per this many posts the poster made before, 0 to disable
+	reputation_posts int
+	// how long a stamp is good for
+	window time.Duration
+	// stamps we took -> when they expire
+	spent map[string]time.Time
+}
+
+// get the number of bits a post to newsgroup from encaddr needs
+// encaddr is empty for posters we know nothing about
+func (self *powVerifier) Required(db Database, newsgroup, encaddr string) int {
+	bits := self.bits
+	if self.load_posts > 0 {
+		bits += int(db.CountPostsInGroup(newsgroup, 3600) / self.load_posts)
+	}
+	if self.reputation_posts > 0 && len(encaddr) > 0 {
+		posts, err := db.GetMessageIDByEncryptedIP(encaddr)
+		if err == nil {
+			bits -= len(posts) / self.reputation_posts
+		}
+	}
+	if bits < self.min_bits {
+		bits = self.min_bits
+	} else if bits > self.max_bits {
+		bits = self.max_bits
+	}
+	return bits
+}
+
+// check a stamp for a message to newsgroup that needs bits and take it
+func (self *powVerifier) Verify(stamp, newsgroup, message string, bits int, now time.Time) error {
+	if len(stamp) == 0 {
+		return ProofOfWorkMissing
+	}
+	parts := strings.Split(stamp, ":")
+	if len(parts) != 5 {
+		return ProofOfWorkInvalid
+	}
+	claimed, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ProofOfWorkInvalid
+	}
+	posted, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ProofOfWorkInvalid
+	}
+	if parts[2] != newsgroup || parts[3] != powMessageHash(message) {
+		// made for something else
+		return ProofOfWorkInvalid
+	}
+	h := sha256.Sum256([]byte(stamp))
+	if claimed < bits || powLeadingZeros(h[:]) < bits {
+		return ProofOfWorkTooWeak
+	}
+	expires := time.Unix(posted, 0).Add(self.window)
+	if now.After(expires) || time.Unix(posted, 0).After(now.Add(self.window)) {
+		return ProofOfWorkExpired
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	for s, t := range self.spent {
+		if now.After(t) {
+			delete(self.spent, s)
+		}
+	}
+	_, used := self.spent[stamp]
+	if used {
+		return ProofOfWorkSpent
+	}
+	self.spent[stamp] = expires
+	return nil
+}
+
+// create the proof of work verifier from config
+func powVerifierFromConfig(conf map[string]string) *powVerifier {
+	v := &powVerifier{
+		bits:             mapGetInt(conf, "bits", 20),
+		min_bits:         mapGetInt(conf, "min_bits", 16),
+		max_bits:         mapGetInt(conf, "max_bits", 28),
+		load_posts:       int64(mapGetInt(conf, "load_posts", 50)),
+		reputation_posts: mapGetInt(conf, "reputation_posts", 20),
+		window:           time.Second * time.Duration(mapGetInt(conf, "window", 600)),
+		spent:            make(map[string]time.Time),
+	}
+	if v.max_bits < v.min_bits {
+		log.Println("pow max_bits is less than min_bits, using min_bits")
+		v.max_bits = v.min_bits
+	}
+	return v
+}
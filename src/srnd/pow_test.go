@@ -0,0 +1,27 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProofOfWork(t *testing.T) {
+
+	v := powVerifierFromConfig(map[string]string{"bits": "8", "min_bits": "8"})
+	now := time.Now()
+	stamp := mintProofOfWork(8, now, "overchan.test", "hello\r\nworld\n")
+	if err := v.Verify(stamp, "overchan.test", "hello\nworld", 8, now); err != nil {
+		t.Error("good stamp rejected", err)
+	}
+	if err := v.Verify(stamp, "overchan.test", "hello\nworld", 8, now); err != ProofOfWorkSpent {
+		t.Error("stamp used twice", err)
+	}
+	stamp = mintProofOfWork(8, now, "overchan.test", "hello")
+	if err := v.Verify(stamp, "overchan.other", "hello", 8, now); err != ProofOfWorkInvalid {
+		t.Error("stamp for another newsgroup accepted", err)
+	}
+	if err := v.Verify(stamp, "overchan.test", "hello", 8, now.Add(time.Hour)); err != ProofOfWorkExpired {
+		t.Error("old stamp accepted", err)
+	}
+
+}
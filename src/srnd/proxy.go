@@ -0,0 +1,102 @@
+//
+// proxy.go -- running behind reverse proxies and under a path other than /
+//
+
+package srnd
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reverse proxies whose X-Real-IP, X-Forwarded-For and X-Forwarded-Host we believe
+var trustedProxies = parseTrustedProxies("127.0.0.0/8,::1")
+
+// parse comma separated addresses and networks, none for no proxies
+func parseTrustedProxies(str string) (nets []*net.IPNet) {
+	for _, s := range strings.Split(str, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" || s == "none" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Println("bad trusted proxy", s, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+// set the reverse proxies we trust, keeps the loopback default if empty
+func SetTrustedProxies(str string) {
+	if len(strings.TrimSpace(str)) > 0 {
+		trustedProxies = parseTrustedProxies(str)
+	}
+}
+
+// is the address one of our reverse proxies?
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the host the client asked for, which a proxy in front of us may have changed
+func requestHost(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil && trustedProxy(addr) {
+		host := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0])
+		if host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// serve h under the path of prefix
+// works whether or not the proxy in front of us strips the path off
+func prefixHandler(prefix string, h http.Handler) http.Handler {
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return h
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	if base == "" {
+		return h
+	}
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			http.Redirect(wr, r, base+"/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, base+"/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = strings.TrimPrefix(r.URL.Path, base)
+			r2.URL.RawPath = ""
+			r = r2
+		}
+		h.ServeHTTP(wr, r)
+	})
+}
@@ -0,0 +1,122 @@
+//
+// quota.go -- daily limits on how much one signing key can post
+//
+
+package srnd
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var PubkeyPostQuota = errors.New("this key made too many posts today, try again later")
+var PubkeyAttachmentQuota = errors.New("this key posted too many attachments today, try again later")
+
+// limits for one trust level, 0 to disable
+type quotaLimits struct {
+	// posts per day
+	posts int
+	// attachments per day
+	attachments int
+}
+
+// something a key posted
+type quotaPost struct {
+	posted      time.Time
+	attachments int
+}
+
+type pubkeyQuota struct {
+	access sync.Mutex
+	// limits for keys nobody vouched for
+	limits quotaLimits
+	// limits for keys mods marked trusted
+	trusted quotaLimits
+	// pubkey -> posts in the last day
+	posts map[string][]quotaPost
+	// last time we expired everything
+	expired time.Time
+}
+
+func newPubkeyQuota(limits, trusted quotaLimits) *pubkeyQuota {
+	return &pubkeyQuota{
+		limits:  limits,
+		trusted: trusted,
+		posts:   make(map[string][]quotaPost),
+	}
+}
+
+// drop posts older than a day
+func recentQuotaPosts(posts []quotaPost, now time.Time) []quotaPost {
+	idx := 0
+	for idx < len(posts) && now.Sub(posts[idx].posted) >= time.Hour*24 {
+		idx++
+	}
+	return posts[idx:]
+}
+
+// check if pubkey may post with this many attachments now and count it if so
+func (self *pubkeyQuota) Allow(pubkey string, attachments int, trusted bool, now time.Time) error {
+	if self == nil || pubkey == "" {
+		return nil
+	}
+	limits := self.limits
+	if trusted {
+		limits = self.trusted
+	}
+	if limits.posts <= 0 && limits.attachments <= 0 {
+		return nil
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	if now.Sub(self.expired) >= time.Hour {
+		self.expire(now)
+	}
+	posts := recentQuotaPosts(self.posts[pubkey], now)
+	if limits.posts > 0 && len(posts) >= limits.posts {
+		return PubkeyPostQuota
+	}
+	if limits.attachments > 0 && attachments > 0 {
+		total := attachments
+		for _, p := range posts {
+			total += p.attachments
+		}
+		if total > limits.attachments {
+			return PubkeyAttachmentQuota
+		}
+	}
+	self.posts[pubkey] = append(posts, quotaPost{posted: now, attachments: attachments})
+	return nil
+}
+
+// forget posts that no longer count against anyone, must hold the lock
+func (self *pubkeyQuota) expire(now time.Time) {
+	self.expired = now
+	for k, posts := range self.posts {
+		posts = recentQuotaPosts(posts, now)
+		if len(posts) == 0 {
+			delete(self.posts, k)
+		} else {
+			self.posts[k] = posts
+		}
+	}
+}
+
+// create the pubkey quota from config, nil if it is disabled
+func pubkeyQuotaFromConfig(conf *SRNdConfig) *pubkeyQuota {
+	if conf.quota["enable"] != "1" {
+		return nil
+	}
+	limits := quotaLimits{
+		posts:       mapGetInt(conf.quota, "posts", 100),
+		attachments: mapGetInt(conf.quota, "attachments", 50),
+	}
+	trusted := quotaLimits{
+		posts:       mapGetInt(conf.quota, "trusted_posts", 1000),
+		attachments: mapGetInt(conf.quota, "trusted_attachments", 500),
+	}
+	log.Printf("pubkey quota enabled, posts=%d attachments=%d per day, trusted posts=%d attachments=%d", limits.posts, limits.attachments, trusted.posts, trusted.attachments)
+	return newPubkeyQuota(limits, trusted)
+}
@@ -0,0 +1,66 @@
+package srnd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPubkeyQuota(t *testing.T) {
+
+	q := newPubkeyQuota(quotaLimits{posts: 2, attachments: 3}, quotaLimits{posts: 10, attachments: 10})
+	now := time.Now()
+	if q.Allow("key", 2, false, now) != nil {
+		t.Error("first post not allowed")
+	}
+	if q.Allow("key", 2, false, now) != PubkeyAttachmentQuota {
+		t.Error("attachments over the quota allowed")
+	}
+	if q.Allow("key", 1, false, now) != nil {
+		t.Error("post under the quota not allowed")
+	}
+	if q.Allow("key", 0, false, now) != PubkeyPostQuota {
+		t.Error("post over the quota allowed")
+	}
+	if q.Allow("key", 0, true, now) != nil {
+		t.Error("trusted key limited like an untrusted one")
+	}
+	if q.Allow("key", 0, false, now.Add(time.Hour*24)) != nil {
+		t.Error("post a day later not allowed")
+	}
+
+}
+
+// a database that trusts nobody and registers everything
+type quotaDB struct {
+	Database
+	registered int
+}
+
+func (self *quotaDB) PubkeyTrusted(pubkey string) (bool, error) {
+	return false, nil
+}
+
+func (self *quotaDB) RegisterArticle(nntp NNTPMessage) error {
+	self.registered++
+	return nil
+}
+
+func TestRegisterPostAsQuota(t *testing.T) {
+
+	db := new(quotaDB)
+	store := &articleStore{database: db, quota: newPubkeyQuota(quotaLimits{posts: 1, attachments: 10}, quotaLimits{posts: 1, attachments: 10})}
+	post := func() NNTPMessage {
+		return newPlaintextArticle("hello", "test@test.tld", "test", "test", "test.tld", genMessageID("test.tld"), "overchan.test")
+	}
+	if err := store.RegisterPostAs(post(), "key"); err != nil {
+		t.Error(err)
+	}
+	// not signed yet, but it will be with key
+	if err := store.RegisterPostAs(post(), "key"); err != PubkeyPostQuota {
+		t.Error("post over the quota of the key it will be signed with registered", err)
+	}
+	if db.registered != 1 {
+		t.Error("registered", db.registered, "posts")
+	}
+
+}
@@ -0,0 +1,127 @@
+//
+// ratelimit.go -- per address limits on posting, searching and the api
+//
+
+package srnd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var RateLimited = errors.New("too many requests, slow down")
+
+// kinds of requests we limit
+const (
+	rateLimitPost   = "post"
+	rateLimitSearch = "search"
+	rateLimitAPI    = "api"
+	rateLimitUpload = "upload"
+)
+
+// limits for kinds a config from before them does not have
+var rateLimitDefaults = map[string]string{
+	rateLimitPost:   "10,60",
+	rateLimitSearch: "20,60",
+	rateLimitAPI:    "120,60",
+	rateLimitUpload: "600,60",
+}
+
+// a burst of requests allowed per window
+type rateLimit struct {
+	burst  int64
+	window int64
+}
+
+// parse "burst,seconds", 0 burst to not limit
+func parseRateLimit(str string) (limit rateLimit) {
+	parts := strings.Split(str, ",")
+	if len(parts) != 2 {
+		return
+	}
+	burst, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return
+	}
+	window, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil || window <= 0 {
+		return
+	}
+	limit.burst = burst
+	limit.window = window
+	return
+}
+
+type frontendRateLimiter struct {
+	database Database
+	// so the counters don't have plain addresses in them
+	secret string
+	// kind of request -> limit
+	limits map[string]rateLimit
+}
+
+// count a request of some kind from addr
+// returns how long until it may make more if it is over its limit
+func (self *frontendRateLimiter) Hit(kind, addr string) (wait time.Duration) {
+	if self == nil {
+		return
+	}
+	limit := self.limits[kind]
+	if limit.burst <= 0 {
+		return
+	}
+	h := sha256.Sum256([]byte(self.secret + addr))
+	hits, err := self.database.HitRateLimit(kind+"::"+hexify(h[:16]), limit.window)
+	if err != nil {
+		// don't lock everyone out when the database is having a bad day
+		log.Println("cannot count rate limit hit", err)
+		return
+	}
+	if hits > limit.burst {
+		now := timeNow()
+		wait = time.Duration(limit.window-now%limit.window) * time.Second
+	}
+	return
+}
+
+// limit a handler by request kind, answers 429 when the address is over its limit
+func (self *httpFrontend) rateLimited(kind string, h http.HandlerFunc) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		addr, err := extractRealIP(r)
+		if err == nil {
+			wait := self.rateLimit.Hit(kind, addr)
+			if wait > 0 {
+				wr.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)))
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					api_v1_error(wr, http.StatusTooManyRequests, RateLimited)
+				} else {
+					http.Error(wr, RateLimited.Error(), http.StatusTooManyRequests)
+				}
+				return
+			}
+		}
+		h(wr, r)
+	}
+}
+
+// create the frontend rate limiter from config, nil if it is disabled
+func frontendRateLimiterFromConfig(conf *SRNdConfig, db Database, secret string) *frontendRateLimiter {
+	if conf.ratelimit["enable"] != "1" {
+		return nil
+	}
+	limits := make(map[string]rateLimit)
+	for _, kind := range []string{rateLimitPost, rateLimitSearch, rateLimitAPI, rateLimitUpload} {
+		str, ok := conf.ratelimit[kind]
+		if !ok {
+			str = rateLimitDefaults[kind]
+		}
+		limits[kind] = parseRateLimit(str)
+		log.Printf("frontend rate limit for %s: %d per %ds", kind, limits[kind].burst, limits[kind].window)
+	}
+	return &frontendRateLimiter{database: db, secret: secret, limits: limits}
+}
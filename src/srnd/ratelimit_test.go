@@ -0,0 +1,18 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestParseRateLimit(t *testing.T) {
+
+	l := parseRateLimit("10, 60")
+	if l.burst != 10 || l.window != 60 {
+		t.Error("bad rate limit", l)
+	}
+	l = parseRateLimit("10,0")
+	if l.burst != 0 {
+		t.Error("rate limit without a window should not limit", l)
+	}
+
+}
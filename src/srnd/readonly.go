@@ -0,0 +1,28 @@
+//
+// readonly.go -- mirroring content without taking posts
+//
+
+package srnd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ReadOnlyFrontend = errors.New("this site is a read only mirror, posting is disabled")
+
+// wrap a handler that takes posts so it answers 403 when we are read only
+func (self *httpFrontend) writable(h http.HandlerFunc) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if self.readOnly {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				api_v1_error(wr, http.StatusForbidden, ReadOnlyFrontend)
+			} else {
+				http.Error(wr, ReadOnlyFrontend.Error(), http.StatusForbidden)
+			}
+			return
+		}
+		h(wr, r)
+	}
+}
@@ -0,0 +1,192 @@
+//
+// recompress.go -- converting the articles already stored to another compression
+//
+
+package srnd
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// what recompress names an article it is writing, never a valid message-id so nothing else picks it up
+const recompressTempPrefix = ".recompress-"
+
+// hash what an article reads as, whatever it is stored as
+func articleDigest(fname string) ([]byte, error) {
+	r, err := openArticleFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	h := sha512.New()
+	_, err = io.Copy(h, r)
+	return h.Sum(nil), err
+}
+
+// rewrite a stored article in a format next to it, check it reads back the same, then put it in place
+// returns the size before and after
+func recompressArticle(fname, format string) (before, after int64, err error) {
+	var st os.FileInfo
+	st, err = os.Stat(fname)
+	if err != nil {
+		return
+	}
+	before = st.Size()
+	tmpname := filepath.Join(filepath.Dir(fname), recompressTempPrefix+filepath.Base(fname))
+	defer func() {
+		if err != nil {
+			DelFile(tmpname)
+		}
+	}()
+	var r io.ReadCloser
+	r, err = openArticleFile(fname)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	var f *os.File
+	f, err = os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode())
+	if err != nil {
+		return
+	}
+	var w io.WriteCloser
+	w, err = compressArticleFile(f, format)
+	if err != nil {
+		f.Close()
+		return
+	}
+	h := sha512.New()
+	_, err = io.Copy(w, io.TeeReader(r, h))
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+	var digest []byte
+	digest, err = articleDigest(tmpname)
+	if err == nil && !bytes.Equal(digest, h.Sum(nil)) {
+		err = errors.New("does not read back the same")
+	}
+	if err != nil {
+		return
+	}
+	// on disk before it replaces the original
+	f, err = os.Open(tmpname)
+	if err == nil {
+		err = f.Sync()
+		f.Close()
+	}
+	if err != nil {
+		return
+	}
+	os.Chtimes(tmpname, st.ModTime(), st.ModTime())
+	if !CheckFile(fname) {
+		// expired or deleted while we were at it
+		err = os.ErrNotExist
+		return
+	}
+	st, err = os.Stat(tmpname)
+	if err == nil {
+		after = st.Size()
+		err = os.Rename(tmpname, fname)
+	}
+	return
+}
+
+// convert every article in store_dir to a compression
+// usage: -to none|gzip|zstd [-dry-run]
+// articles already in that format are skipped, so it can be stopped and run again to pick up where it was,
+// and it is safe with the daemon running since every article is swapped in whole
+func RecompressTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("recompress", flag.ExitOnError)
+	to := flags.String("to", "", "compression to convert to, one of "+strings.Join(articleCompressions, " "))
+	dryRun := flags.Bool("dry-run", false, "count what would be converted and convert nothing")
+	flags.Parse(args)
+	valid := false
+	for _, c := range articleCompressions {
+		valid = valid || c == *to
+	}
+	if !valid || flags.NArg() != 0 {
+		return errors.New("usage: recompress -to " + strings.Join(articleCompressions, "|") + " [-dry-run]")
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	dir := conf.store["store_dir"]
+	if c := articleCompressionFromConfig(conf.store["compression"]); c != *to {
+		fmt.Fprintf(w, "compression in [articles] is %s, set it to %s too or new articles are stored as %s\n", c, *to, c)
+	}
+	var f *os.File
+	f, err = os.Open(dir)
+	if err != nil {
+		return
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		return
+	}
+	var converted, already, failed int
+	var before, after int64
+	for _, name := range names {
+		fname := filepath.Join(dir, name)
+		if strings.HasPrefix(name, recompressTempPrefix) {
+			// left over from a run that was stopped
+			DelFile(fname)
+			continue
+		}
+		if !ValidMessageID(name) {
+			continue
+		}
+		var head [4]byte
+		r, e := os.Open(fname)
+		if e != nil {
+			// expired since we listed it
+			continue
+		}
+		n, _ := io.ReadFull(r, head[:])
+		r.Close()
+		if sniffArticleCompression(head[:n]) == *to {
+			already++
+			continue
+		}
+		if *dryRun {
+			converted++
+			continue
+		}
+		b, a, e := recompressArticle(fname, *to)
+		if os.IsNotExist(e) {
+			continue
+		} else if e != nil {
+			log.Println("failed to recompress", name, e)
+			failed++
+			continue
+		}
+		converted++
+		before += b
+		after += a
+		if converted%1000 == 0 {
+			fmt.Fprintf(w, "converted %d articles\n", converted)
+		}
+	}
+	if *dryRun {
+		fmt.Fprintf(w, "would convert %d articles to %s, %d are already\n", converted, *to, already)
+		return
+	}
+	fmt.Fprintf(w, "converted %d articles to %s, %s to %s, %d were already and %d failed\n", converted, *to, formatBytes(uint64(before)), formatBytes(uint64(after)), already, failed)
+	if failed > 0 {
+		err = fmt.Errorf("%d articles failed, run it again to retry them", failed)
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecompressArticle(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	fname := filepath.Join(dir, "<test@test.tld>")
+	body := "Message-ID: <test@test.tld>\nNewsgroups: overchan.test\n\n" + strings.Repeat("hello ", 100)
+	ioutil.WriteFile(fname, []byte(body), 0600)
+	for _, format := range []string{ArticleCompressionGzip, ArticleCompressionZstd, ArticleCompressionNone} {
+		_, _, err := recompressArticle(fname, format)
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		data, _ := ioutil.ReadFile(fname)
+		if sniffArticleCompression(data) != format {
+			t.Errorf("stored as %s, want %s", sniffArticleCompression(data), format)
+		}
+		r, err := openArticleFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ = ioutil.ReadAll(r)
+		r.Close()
+		if string(data) != body {
+			t.Errorf("%s does not read back the same", format)
+		}
+		if sz, err := articleFileSize(fname); err != nil || sz != int64(len(body)) {
+			t.Errorf("%s article size is %d, want %d %v", format, sz, len(body), err)
+		}
+	}
+	if articleCompressionFromConfig("1") != ArticleCompressionGzip || articleCompressionFromConfig("0") != ArticleCompressionNone {
+		t.Error("old compression settings are not understood")
+	}
+
+}
@@ -27,11 +27,14 @@ THE SOFTWARE.
 package srnd
 
 import (
+	"bufio"
+	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/mcuadros/go-version"
 	"gopkg.in/redis.v3"
+	"io"
 	"log"
 	"math"
 	"net"
@@ -67,8 +70,25 @@ const (
 	ENCRYPTED_ADDRS_PREFIX       = APP_PREFIX + "EncryptedAddrs::"
 	ADDRS_ENCRYPTED_ADDRS_PREFIX = APP_PREFIX + "AddrsEncryptedAddrs::"
 	ENCRYPTED_IP_BAN_PREFIX      = APP_PREFIX + "EncIPBan::"
+	ENCRYPTED_IP_SHADOW_PREFIX   = APP_PREFIX + "EncIPShadowBan::"
 	IP_BAN_PREFIX                = APP_PREFIX + "IPBan::"
 	IP_RANGE_BAN_PREFIX          = APP_PREFIX + "IPRangeBan::"
+	REPORT_PREFIX                = APP_PREFIX + "Report::"
+	QUARANTINE_PREFIX            = APP_PREFIX + "Quarantine::"
+	BANNED_PUBKEY_PREFIX         = APP_PREFIX + "BannedPubkey::"
+	BANNED_ATTACHMENT_PREFIX     = APP_PREFIX + "BannedAttachment::"
+	BANLIST_ENTRIES_PREFIX       = APP_PREFIX + "BanlistEntries::"
+	THREAD_FLAGS_PREFIX          = APP_PREFIX + "ThreadFlags::"
+	BAN_APPEAL_PREFIX            = APP_PREFIX + "BanAppeal::"
+	MOD_GRANT_PREFIX             = APP_PREFIX + "ModGrant::"
+	TRASH_PREFIX                 = APP_PREFIX + "Trash::"
+	FEED_INTAKE_PREFIX           = APP_PREFIX + "FeedIntake::"
+	ADDR_LISTING_PREFIX          = APP_PREFIX + "AddrListing::"
+	REJECTED_PREFIX              = APP_PREFIX + "Rejected::"
+	BOARD_SETTINGS_PREFIX        = APP_PREFIX + "BoardSettings::"
+	SHORT_HASH_MESSAGEID_PREFIX  = APP_PREFIX + "ShortHashMessageID::"
+	RATE_LIMIT_PREFIX            = APP_PREFIX + "RateLimit::"
+	CTL_FEED_PREFIX              = APP_PREFIX + "CtlFeed::"
 )
 
 //keyrings - these can be seen as index
@@ -88,7 +108,24 @@ const (
 	MESSAGEID_HEADER_KR_PREFIX        = APP_PREFIX + "MessageIDHeaderKR::"
 	ARTICLE_ATTACHMENT_KR_PREFIX      = APP_PREFIX + "ArticleAttachmentsKR::"
 	ATTACHMENT_ARTICLE_KR_PREFIX      = APP_PREFIX + "AttachmentArticlesKR::"
+	ARTICLE_SPOILER_KR_PREFIX         = APP_PREFIX + "ArticleSpoilersKR::"
 	IP_RANGE_BAN_KR                   = APP_PREFIX + "IPRangeBanKR"
+	FEED_OFFERED_KR_PREFIX            = APP_PREFIX + "FeedOfferedKR::"
+	ARTICLE_OFFERED_KR_PREFIX         = APP_PREFIX + "ArticleOfferedKR::"
+	REPORTS_WKR                       = APP_PREFIX + "ReportsWKR"
+	GROUP_REPORTS_WKR_PREFIX          = APP_PREFIX + "GroupReportsWKR::"
+	QUARANTINE_WKR                    = APP_PREFIX + "QuarantineWKR"
+	BANLIST_SUBS_KR                   = APP_PREFIX + "BanlistSubsKR"
+	BANLIST_SOURCES_KR_PREFIX         = APP_PREFIX + "BanlistSourcesKR::"
+	GROUP_THREAD_FLAG_WKR_PREFIX      = APP_PREFIX + "GroupThreadFlagWKR::"
+	PENDING_BAN_APPEALS_WKR           = APP_PREFIX + "PendingBanAppealsWKR"
+	MOD_GRANTS_BY_KR_PREFIX           = APP_PREFIX + "ModGrantsByKR::"
+	MOD_GRANTS_TO_KR_PREFIX           = APP_PREFIX + "ModGrantsToKR::"
+	TRASH_WKR                         = APP_PREFIX + "TrashWKR"
+	TRUSTED_PUBKEYS_KR                = APP_PREFIX + "TrustedPubkeysKR"
+	REJECTED_WKR                      = APP_PREFIX + "RejectedWKR"
+	BACKLINKS_WKR_PREFIX              = APP_PREFIX + "BacklinksWKR::"
+	ARTICLE_QUOTES_KR_PREFIX          = APP_PREFIX + "ArticleQuotesKR::"
 )
 
 type RedisDB struct {
@@ -138,6 +175,88 @@ func NewRedisDatabase(host, port, password string) Database {
 
 // finalize all transactions
 // close database connections
+// check the database answers
+func (self RedisDB) Ping() error {
+	return self.client.Ping().Err()
+}
+
+// a key as redis DUMP gives it with how long it has left in milliseconds, 0 for forever
+type redisDumpEntry struct {
+	Key   string
+	TTL   int64
+	Value string
+}
+
+// write every key of ours to fname with DUMP
+// keys are dumped one by one so writes made meanwhile may or may not be in it
+func (self RedisDB) Export(fname string) (err error) {
+	var keys []string
+	keys, err = self.client.Keys(APP_PREFIX + "*").Result()
+	if err != nil {
+		return
+	}
+	var f *os.File
+	f, err = os.Create(fname)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, k := range keys {
+		var e redisDumpEntry
+		e.Key = k
+		e.Value, err = self.client.Dump(k).Result()
+		if err == redis.Nil {
+			// gone since we listed it
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		ttl, _ := self.client.PTTL(k).Result()
+		if ttl > 0 {
+			e.TTL = int64(ttl / time.Millisecond)
+		}
+		err = enc.Encode(&e)
+		if err != nil {
+			return
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	return
+}
+
+// load a dump made by Export with RESTORE, replacing the keys that are there
+func (self RedisDB) Import(fname string) (err error) {
+	var f *os.File
+	f, err = os.Open(fname)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var e redisDumpEntry
+		err = dec.Decode(&e)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+		err = self.client.Del(e.Key).Err()
+		if err == nil {
+			err = self.client.Restore(e.Key, e.TTL, e.Value).Err()
+		}
+		if err != nil {
+			return errors.New("restoring " + e.Key + ": " + err.Error())
+		}
+	}
+}
+
 func (self RedisDB) Close() {
 	if self.client != nil {
 		self.client.Close()
@@ -163,6 +282,15 @@ func (self RedisDB) NewsgroupBanned(group string) (banned bool, err error) {
 	return
 }
 
+func (self RedisDB) GetBannedNewsgroups() (groups []string, err error) {
+	var keys []string
+	keys, err = self.client.Keys(BANNED_GROUP_PREFIX + "*").Result()
+	for _, k := range keys {
+		groups = append(groups, k[len(BANNED_GROUP_PREFIX):])
+	}
+	return
+}
+
 func (self RedisDB) NukeNewsgroup(group string, store ArticleStore) {
 	// get all articles in that newsgroup
 	chnl := make(chan ArticleEntry, 24)
@@ -176,16 +304,8 @@ func (self RedisDB) NukeNewsgroup(group string, store ArticleStore) {
 		if ok {
 			msgid := article.MessageID()
 			log.Println("delete", msgid)
-			// remove article from store
-			fname := store.GetFilename(msgid)
-			os.Remove(fname)
-			// get all attachments
-			for _, att := range self.GetPostAttachments(msgid) {
-				// remove attachment
-				log.Println("delete attachment", att)
-				os.Remove(store.ThumbnailFilepath(att))
-				os.Remove(store.AttachmentFilepath(att))
-			}
+			// trash or remove article and attachments from store
+			discardArticle(self, store, msgid, group)
 		} else {
 			break
 		}
@@ -266,6 +386,35 @@ func (self RedisDB) CountPostsInGroup(newsgroup string, time_frame int64) (resul
 	return
 }
 
+func (self RedisDB) CountThreadsInGroup(newsgroup string) (result int64) {
+	result, _ = self.client.ZCard(GROUP_THREAD_POSTTIME_WKR_PREFIX + newsgroup).Result()
+	return
+}
+
+func (self RedisDB) GetLastPostTimeInGroup(newsgroup string) int64 {
+	score, _ := self.client.ZScore(GROUP_POSTTIME_WKR, newsgroup).Result()
+	return int64(score)
+}
+
+func (self RedisDB) HitRateLimit(counter string, window int64) (hits int64, err error) {
+	now := timeNow()
+	key := RATE_LIMIT_PREFIX + counter + "::" + strconv.FormatInt(now-now%window, 10)
+	hits, err = self.client.Incr(key).Result()
+	if err == nil && hits == 1 {
+		self.client.Expire(key, time.Duration(window)*time.Second)
+	}
+	return
+}
+
+func (self RedisDB) GetThreadLastPostTime(root string) int64 {
+	group, err := self.GetGroupForMessage(root)
+	if err != nil {
+		return 0
+	}
+	score, _ := self.client.ZScore(GROUP_THREAD_POSTTIME_WKR_PREFIX+group, root).Result()
+	return int64(score)
+}
+
 func (self RedisDB) CheckModPubkey(pubkey string) bool {
 	var result bool
 	result, _ = self.client.SIsMember(MOD_KEY_PREFIX+pubkey+"::Group::"+"ctl"+"::Permissions", "login").Result()
@@ -290,6 +439,56 @@ func (self RedisDB) ArticleBanned(messageID string) (result bool) {
 	return
 }
 
+func (self RedisDB) GetBannedArticles() (banned map[string]string, err error) {
+	var keys []string
+	keys, err = self.client.Keys(BANNED_ARTICLE_PREFIX + "*").Result()
+	if err != nil {
+		return
+	}
+	banned = make(map[string]string)
+	for _, k := range keys {
+		reason, _ := self.client.HGet(k, "ban_reason").Result()
+		banned[k[len(BANNED_ARTICLE_PREFIX):]] = reason
+	}
+	return
+}
+
+func (self RedisDB) UnbanArticle(messageID string) (err error) {
+	_, err = self.client.Del(BANNED_ARTICLE_PREFIX + messageID).Result()
+	return
+}
+
+func (self RedisDB) TrashArticle(messageID, newsgroup, root string, attachments []string) (err error) {
+	now := timeNow()
+	_, err = self.client.HMSet(TRASH_PREFIX+messageID, "newsgroup", newsgroup, "root", root, "attachments", strings.Join(attachments, " "), "time_trashed", strconv.FormatInt(now, 10)).Result()
+	if err == nil {
+		_, err = self.client.ZAdd(TRASH_WKR, redis.Z{Score: float64(now), Member: messageID}).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetTrashedArticles() (trashed []TrashedArticle, err error) {
+	var msgids []string
+	msgids, err = self.client.ZRange(TRASH_WKR, 0, -1).Result()
+	for _, msgid := range msgids {
+		var hashres []string
+		hashres, err = self.client.HGetAll(TRASH_PREFIX + msgid).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		t, _ := strconv.ParseInt(res["time_trashed"], 10, 64)
+		trashed = append(trashed, TrashedArticle{MessageID: msgid, Newsgroup: res["newsgroup"], Root: res["root"], Attachments: strings.Fields(res["attachments"]), Trashed: t})
+	}
+	return
+}
+
+func (self RedisDB) ForgetTrashedArticle(messageID string) (err error) {
+	self.client.ZRem(TRASH_WKR, messageID)
+	_, err = self.client.Del(TRASH_PREFIX + messageID).Result()
+	return
+}
+
 func (self RedisDB) GetEncAddress(addr string) (encaddr string, err error) {
 	var exists bool
 	exists, err = self.client.Exists(ADDRS_ENCRYPTED_ADDRS_PREFIX + addr).Result()
@@ -301,7 +500,7 @@ func (self RedisDB) GetEncAddress(addr string) (encaddr string, err error) {
 			if len(encaddr) == 0 {
 				err = errors.New("failed to generate new encryption key")
 			} else {
-				self.client.HMSet(ENCRYPTED_ADDRS_PREFIX+encaddr, "enckey", key, "encaddr", encaddr, "addr", addr)
+				self.client.HMSet(ENCRYPTED_ADDRS_PREFIX+encaddr, "enckey", key, "encaddr", encaddr, "addr", addr, "made", strconv.FormatInt(timeNow(), 10))
 				_, err = self.client.Set(ADDRS_ENCRYPTED_ADDRS_PREFIX+addr, encaddr, 0).Result()
 			}
 		} else {
@@ -316,6 +515,63 @@ func (self RedisDB) GetEncKey(encAddr string) (enckey string, err error) {
 	return
 }
 
+func (self RedisDB) GetEncAddrsMadeBefore(before int64) (encaddrs []string, err error) {
+	var keys []string
+	keys, err = self.client.Keys(ENCRYPTED_ADDRS_PREFIX + "*").Result()
+	if err != nil {
+		return
+	}
+	now := strconv.FormatInt(timeNow(), 10)
+	for _, k := range keys {
+		made, e := self.client.HGet(k, "made").Int64()
+		if e == redis.Nil {
+			// made before we kept track, its clock starts now
+			self.client.HSet(k, "made", now)
+			continue
+		} else if e == nil && made < before {
+			encaddrs = append(encaddrs, k[len(ENCRYPTED_ADDRS_PREFIX):])
+		}
+	}
+	return
+}
+
+func (self RedisDB) RotateEncAddress(encaddr string) (newenc string, err error) {
+	var addr, key string
+	addr, err = self.client.HGet(ENCRYPTED_ADDRS_PREFIX+encaddr, "addr").Result()
+	if err != nil {
+		return
+	}
+	key, newenc = newAddrEnc(addr)
+	if len(newenc) == 0 {
+		err = errors.New("failed to generate new encryption key")
+		return
+	}
+	_, err = self.client.HMSet(ENCRYPTED_ADDRS_PREFIX+newenc, "enckey", key, "encaddr", newenc, "addr", addr, "made", strconv.FormatInt(timeNow(), 10)).Result()
+	if err == nil {
+		_, err = self.client.Set(ADDRS_ENCRYPTED_ADDRS_PREFIX+addr, newenc, 0).Result()
+	}
+	if err == nil {
+		_, err = self.client.Del(ENCRYPTED_ADDRS_PREFIX + encaddr).Result()
+	}
+	return
+}
+
+func (self RedisDB) DeleteEncAddress(encaddr string) (err error) {
+	var addr string
+	addr, err = self.client.HGet(ENCRYPTED_ADDRS_PREFIX+encaddr, "addr").Result()
+	if err == redis.Nil {
+		err = nil
+		return
+	} else if err == nil {
+		current, _ := self.client.Get(ADDRS_ENCRYPTED_ADDRS_PREFIX + addr).Result()
+		if current == encaddr {
+			self.client.Del(ADDRS_ENCRYPTED_ADDRS_PREFIX + addr)
+		}
+		_, err = self.client.Del(ENCRYPTED_ADDRS_PREFIX + encaddr).Result()
+	}
+	return
+}
+
 func (self RedisDB) CheckIPBanned(addr string) (banned bool, err error) {
 	banned, err = self.client.Exists(IP_BAN_PREFIX + addr).Result()
 	if banned {
@@ -389,6 +645,52 @@ func (self RedisDB) CountThreadReplies(root_message_id string) (repls int64) {
 	return
 }
 
+func (self RedisDB) CountThreadRepliesSince(root_message_id string, since int64) (repls int64) {
+	repls, _ = self.client.ZCount(THREAD_POST_WKR+root_message_id, "("+strconv.FormatInt(since, 10), "+inf").Result()
+	return
+}
+
+func (self RedisDB) GetGroupThreadCounts(newsgroup string) (counts map[string]ThreadCounts, err error) {
+	var roots []string
+	roots, err = self.client.ZRange(GROUP_THREAD_BUMPTIME_WKR_PREFIX+newsgroup, 0, -1).Result()
+	if err != nil {
+		return
+	}
+	// get every thread's replies in one go
+	pipe := self.client.Pipeline()
+	defer pipe.Close()
+	repls := make([]*redis.StringSliceCmd, len(roots))
+	for idx, root := range roots {
+		repls[idx] = pipe.ZRange(THREAD_POST_WKR+root, 0, -1)
+	}
+	_, err = pipe.Exec()
+	if err != nil && err != redis.Nil {
+		return
+	}
+	// then count every post's attachments in one go
+	atts := make([][]*redis.IntCmd, len(roots))
+	for idx, root := range roots {
+		atts[idx] = append(atts[idx], pipe.SCard(ARTICLE_ATTACHMENT_KR_PREFIX+root))
+		for _, msgid := range repls[idx].Val() {
+			atts[idx] = append(atts[idx], pipe.SCard(ARTICLE_ATTACHMENT_KR_PREFIX+msgid))
+		}
+	}
+	_, err = pipe.Exec()
+	if err != nil && err != redis.Nil {
+		return
+	}
+	err = nil
+	counts = make(map[string]ThreadCounts)
+	for idx, root := range roots {
+		c := ThreadCounts{Replies: int64(len(repls[idx].Val()))}
+		for _, cmd := range atts[idx] {
+			c.Images += cmd.Val()
+		}
+		counts[root] = c
+	}
+	return
+}
+
 func (self RedisDB) GetRootPostsForExpiration(newsgroup string, threadcount int) (roots []string) {
 	var err error
 	roots, err = self.client.ZRange(GROUP_THREAD_POSTTIME_WKR_PREFIX+newsgroup, 0, int64(-threadcount-1)).Result()
@@ -424,10 +726,54 @@ func (self RedisDB) GetGroupPageCount(newsgroup string) int64 {
 
 // only fetches root posts
 // does not update the thread contents
+// get the root posts of the threads on a page, sticky threads come first
+func (self RedisDB) getThreadsForPage(newsgroup string, pageno, perpage int) (threadids []string, err error) {
+	var stickies []string
+	stickies, err = self.GetThreadsWithFlag(newsgroup, ThreadSticky)
+	if err != nil {
+		return
+	}
+	start := pageno * perpage
+	for idx := start; idx < len(stickies) && len(threadids) < perpage; idx++ {
+		threadids = append(threadids, stickies[idx])
+	}
+	if len(threadids) == perpage {
+		return
+	}
+	// skip the threads that are not sticky that earlier pages have
+	skip := start - len(stickies)
+	if skip < 0 {
+		skip = 0
+	}
+	var bumped []string
+	bumped, err = self.client.ZRevRange(GROUP_THREAD_BUMPTIME_WKR_PREFIX+newsgroup, 0, int64(start+perpage+len(stickies)-1)).Result()
+	for _, msgid := range bumped {
+		if len(threadids) == perpage {
+			break
+		}
+		sticky := false
+		for _, s := range stickies {
+			if s == msgid {
+				sticky = true
+				break
+			}
+		}
+		if sticky {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		threadids = append(threadids, msgid)
+	}
+	return
+}
+
 func (self RedisDB) GetGroupForPage(prefix, frontend, newsgroup string, pageno, perpage int) BoardModel {
 	var threads []ThreadModel
 	pages := self.GetGroupPageCount(newsgroup)
-	threadids, err := self.client.ZRevRange(GROUP_THREAD_BUMPTIME_WKR_PREFIX+newsgroup, int64(pageno*perpage), int64(pageno*perpage+perpage-1)).Result()
+	threadids, err := self.getThreadsForPage(newsgroup, pageno, perpage)
 	if err == nil {
 		for _, msgid := range threadids {
 			p := self.GetPostModel(prefix, msgid)
@@ -519,12 +865,20 @@ func (self RedisDB) DeleteThread(msgid string) (err error) {
 	}
 	self.client.ZRem(THREAD_BUMPTIME_WKR, msgid)
 	self.client.Del(THREAD_POST_WKR + msgid)
+	for _, flag := range ThreadFlags {
+		self.SetThreadFlag(msgid, group, flag, false)
+	}
 	self.DeleteArticle(msgid)
 
 	return
 }
 
 func (self RedisDB) DeleteArticle(msgid string) (err error) {
+	feeds, _ := self.client.SMembers(ARTICLE_OFFERED_KR_PREFIX + msgid).Result()
+	for _, f := range feeds {
+		self.client.SRem(FEED_OFFERED_KR_PREFIX+f, msgid)
+	}
+	self.client.Del(ARTICLE_OFFERED_KR_PREFIX+msgid, CTL_FEED_PREFIX+msgid)
 	p := self.GetPostModel("", msgid)
 	if p != nil {
 		if !p.OP() {
@@ -533,7 +887,14 @@ func (self RedisDB) DeleteArticle(msgid string) (err error) {
 		hash, _ := self.client.HGet(ARTICLE_PREFIX+msgid, "message_id_hash").Result()
 		if hash != "" {
 			self.client.Del(HASH_MESSAGEID_PREFIX + hash)
+			self.client.Del(SHORT_HASH_MESSAGEID_PREFIX + hash[:10])
+		}
+
+		quoted, _ := self.client.SMembers(ARTICLE_QUOTES_KR_PREFIX + msgid).Result()
+		for _, q := range quoted {
+			self.client.ZRem(BACKLINKS_WKR_PREFIX+q, msgid)
 		}
+		self.client.Del(ARTICLE_QUOTES_KR_PREFIX+msgid, BACKLINKS_WKR_PREFIX+msgid)
 
 		//self.client.Del(ARTICLE_PREFIX+msgid, ARTICLE_POST_PREFIX+msgid, ARTICLE_KEY_PREFIX+msgid)
 		self.client.ZRem(GROUP_ARTICLE_POSTTIME_WKR_PREFIX+p.Board(), msgid)
@@ -555,6 +916,7 @@ func (self RedisDB) DeleteArticle(msgid string) (err error) {
 			}
 		}
 		self.client.Del(ARTICLE_ATTACHMENT_KR_PREFIX + msgid)
+		self.client.Del(ARTICLE_SPOILER_KR_PREFIX + msgid)
 		self.client.ZRem(ARTICLE_NUMBERS_PREFIX+"group::"+p.Board(), msgid)
 	}
 	return
@@ -571,6 +933,28 @@ func (self RedisDB) GetThreadReplyPostModels(prefix, rootpost string, start, lim
 
 }
 
+func (self RedisDB) GetThreadReplyPostModelsPage(prefix, rootpost string, offset, limit int) (repls []PostModel) {
+	posts, err := self.client.ZRange(THREAD_POST_WKR+rootpost, int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		log.Println("failed to get page of thread replies", rootpost, offset, err)
+	}
+	for _, msgid := range posts {
+		repls = append(repls, self.GetPostModel(prefix, msgid))
+	}
+	return
+}
+
+func (self RedisDB) GetThreadReplyPostModelsSince(prefix, rootpost string, since int64) (repls []PostModel) {
+	posts, err := self.client.ZRangeByScore(THREAD_POST_WKR+rootpost, redis.ZRangeByScore{Min: "(" + strconv.FormatInt(since, 10), Max: "+inf"}).Result()
+	if err != nil {
+		log.Println("failed to get thread replies since", rootpost, since, err)
+	}
+	for _, msgid := range posts {
+		repls = append(repls, self.GetPostModel(prefix, msgid))
+	}
+	return
+}
+
 func (self RedisDB) GetThreadReplies(rootpost string, start, limit int) (repls []string) {
 	var err error
 	if limit < 1 {
@@ -712,11 +1096,14 @@ func (self RedisDB) GetPostAttachmentModels(prefix, messageID string) (atts []At
 
 			fpath, _ = self.client.HGet(ATTACHMENT_PREFIX+hash, "filepath").Result()
 			fname, _ = self.client.HGet(ATTACHMENT_PREFIX+hash, "filename").Result()
+			// the same file can be a spoiler in one post and not another
+			spoiler, _ := self.client.SIsMember(ARTICLE_SPOILER_KR_PREFIX+messageID, hash).Result()
 
 			atts = append(atts, &attachment{
-				prefix: prefix,
-				Path:   fpath,
-				Name:   fname,
+				prefix:  prefix,
+				Path:    fpath,
+				Name:    fname,
+				Spoiled: spoiler,
 			})
 		}
 	} else {
@@ -744,6 +1131,16 @@ func (self RedisDB) RegisterArticle(message NNTPMessage) (err error) {
 	// insert article metadata
 	pipe.HMSet(ARTICLE_PREFIX+msgid, "msgid", msgid, "message_id_hash", HashMessageID(msgid), "message_newsgroup", group, "time_obtained", strconv.Itoa(int(now)), "message_ref_id", message.Reference())
 	pipe.Set(HASH_MESSAGEID_PREFIX+HashMessageID(msgid), msgid, 0)
+	pipe.Set(SHORT_HASH_MESSAGEID_PREFIX+ShorterHashMessageID(msgid), msgid, 0)
+
+	// remember which posts this one quotes
+	for _, hash := range quotedHashes(message.Message()) {
+		quoted, err := self.GetMessageIDByShortHash(hash)
+		if err == nil && quoted.MessageID() != msgid {
+			pipe.ZAddNX(BACKLINKS_WKR_PREFIX+quoted.MessageID(), redis.Z{Score: float64(message.Posted()), Member: msgid})
+			pipe.SAdd(ARTICLE_QUOTES_KR_PREFIX+msgid, quoted.MessageID())
+		}
+	}
 
 	// update newsgroup
 	pipe.ZAddXX(GROUP_POSTTIME_WKR, redis.Z{Score: float64(now), Member: group})
@@ -802,6 +1199,9 @@ func (self RedisDB) RegisterArticle(message NNTPMessage) (err error) {
 			pipe.HSetNX(ATTACHMENT_PREFIX+hash, "sha_hash", hash)
 			pipe.HSetNX(ATTACHMENT_PREFIX+hash, "filename", att.Filename())
 			pipe.HSetNX(ATTACHMENT_PREFIX+hash, "filepath", att.Filepath())
+			if attachmentSpoiled(att) {
+				pipe.SAdd(ARTICLE_SPOILER_KR_PREFIX+msgid, hash)
+			}
 		}
 	}
 
@@ -816,7 +1216,8 @@ func (self RedisDB) RegisterArticle(message NNTPMessage) (err error) {
 // get message ids of articles with this header name and value
 //
 func (self RedisDB) GetMessageIDByHeader(name, val string) (msgids []string, err error) {
-	header := "Name::" + name + "::Value::" + val
+	// header names are registered lower case
+	header := "Name::" + strings.ToLower(name) + "::Value::" + val
 	msgids, err = self.client.SMembers(HEADER_KR_PREFIX + header).Result()
 	return
 }
@@ -854,13 +1255,46 @@ func (self RedisDB) GetAllArticles() (articles []ArticleEntry) {
 }
 
 func (self RedisDB) GetPagesPerBoard(group string) (int, error) {
-	//XXX: hardcoded
-	return 10, nil
+	s, err := self.GetBoardSettings(group)
+	return s.Pages, err
 }
 
 func (self RedisDB) GetThreadsPerPage(group string) (int, error) {
-	//XXX: hardcoded
-	return 10, nil
+	s, err := self.GetBoardSettings(group)
+	return s.ThreadsPerPage, err
+}
+
+func (self RedisDB) GetBoardSettings(group string) (s BoardSettings, err error) {
+	s = defaultBoardSettings(group)
+	var hashres []string
+	hashres, err = self.client.HGetAll(BOARD_SETTINGS_PREFIX + group).Result()
+	if err == nil && len(hashres) > 0 {
+		res := processHashResult(hashres)
+		s.Pages, _ = strconv.Atoi(res["pages"])
+		s.ThreadsPerPage, _ = strconv.Atoi(res["threads_per_page"])
+		s.Attachments = res["attachments"] == "1"
+		s.Captcha = res["captcha"]
+		s.Description = res["description"]
+		// boards set before it was a setting have it on
+		s.InlineMedia = res["inline_media"] != "0"
+		if n, err := strconv.Atoi(res["preview_replies"]); err == nil {
+			s.PreviewReplies = n
+		}
+	}
+	return
+}
+
+func (self RedisDB) SetBoardSettings(s BoardSettings) (err error) {
+	attachments := "0"
+	if s.Attachments {
+		attachments = "1"
+	}
+	inlineMedia := "0"
+	if s.InlineMedia {
+		inlineMedia = "1"
+	}
+	_, err = self.client.HMSet(BOARD_SETTINGS_PREFIX+s.Newsgroup, "pages", strconv.Itoa(s.Pages), "threads_per_page", strconv.Itoa(s.ThreadsPerPage), "attachments", attachments, "captcha", s.Captcha, "description", s.Description, "inline_media", inlineMedia, "preview_replies", strconv.Itoa(s.PreviewReplies)).Result()
+	return
 }
 
 func (self RedisDB) GetMessageIDByCIDR(cidr *net.IPNet) (msgids []string, err error) {
@@ -870,8 +1304,26 @@ func (self RedisDB) GetMessageIDByCIDR(cidr *net.IPNet) (msgids []string, err er
 }
 
 func (self RedisDB) GetMessageIDByEncryptedIP(encip string) (msgids []string, err error) {
-	// TOOD: implement
-	err = errors.New("not implemented in redis driver")
+	return self.GetMessageIDByHeader("x-encrypted-ip", encip)
+}
+
+func (self RedisDB) GetMessageIDByPubkey(pubkey string) (msgids []string, err error) {
+	return self.GetMessageIDByHeader("x-pubkey-ed25519", pubkey)
+}
+
+func (self RedisDB) GetSignedArticles() (signed map[string]string, err error) {
+	var keys []string
+	keys, err = self.client.Keys(ARTICLE_KEY_PREFIX + "*").Result()
+	if err != nil {
+		return
+	}
+	signed = make(map[string]string)
+	for _, k := range keys {
+		pubkey, e := self.client.Get(k).Result()
+		if e == nil {
+			signed[k[len(ARTICLE_KEY_PREFIX):]] = pubkey
+		}
+	}
 	return
 }
 
@@ -888,6 +1340,48 @@ func (self RedisDB) GetMessageIDByHash(hash string) (article ArticleEntry, err e
 	return
 }
 
+// only articles registered since short hashes were kept can be found
+func (self RedisDB) GetMessageIDByShortHash(hash string) (article ArticleEntry, err error) {
+	hash = strings.ToLower(hash)
+	if len(hash) < 10 || len(hash) > 40 {
+		err = errors.New("invalid message id hash")
+		return
+	}
+	var msgid string
+	msgid, err = self.client.Get(SHORT_HASH_MESSAGEID_PREFIX + hash[:10]).Result()
+	if err == nil {
+		if !strings.HasPrefix(HashMessageID(msgid), hash) {
+			err = errors.New("no such article")
+			return
+		}
+		var group string
+		group, err = self.GetGroupForMessage(msgid)
+		if err == nil {
+			article = ArticleEntry{msgid, group}
+		}
+	}
+	return
+}
+
+func (self RedisDB) GetBacklinks(msgid string) (links []Backlink, err error) {
+	var msgids []string
+	msgids, err = self.client.ZRange(BACKLINKS_WKR_PREFIX+msgid, 0, -1).Result()
+	for _, id := range msgids {
+		vals, e := self.client.HMGet(ARTICLE_POST_PREFIX+id, "ref_id", "newsgroup").Result()
+		if e != nil || len(vals) != 2 {
+			continue
+		}
+		l := Backlink{MessageID: id}
+		l.Root, _ = vals[0].(string)
+		l.Newsgroup, _ = vals[1].(string)
+		if l.Root == "" {
+			l.Root = id
+		}
+		links = append(links, l)
+	}
+	return
+}
+
 func (self RedisDB) BanAddr(addr string) (err error) {
 	isnet, ipnet := IsSubnet(addr)
 	if !isnet {
@@ -952,34 +1446,147 @@ func (self RedisDB) BanEncAddr(encaddr string) (err error) {
 	return
 }
 
-func (self RedisDB) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
-	var minres, maxres []redis.Z
-	minres, err = self.client.ZRangeWithScores(ARTICLE_NUMBERS_PREFIX+"group::"+group, 0, 0).Result()
+func (self RedisDB) BanEncAddrUntil(encaddr string, expires int64) (err error) {
+	var banned bool
+	banned, err = self.CheckEncIPBanned(encaddr)
+	if err == nil && !banned {
+		// redis drops the ban for us when it runs out
+		key := ENCRYPTED_IP_BAN_PREFIX + encaddr
+		_, err = self.client.HMSet(key, "encaddr", encaddr, "made", strconv.Itoa(int(timeNow())), "expires", strconv.FormatInt(expires, 10)).Result()
+		if err == nil {
+			_, err = self.client.ExpireAt(key, time.Unix(expires, 0)).Result()
+		}
+	}
+	return
+}
+
+func (self RedisDB) GetBannedAddrs() (addrs []string, err error) {
+	var keys []string
+	for _, prefix := range []string{IP_BAN_PREFIX, ENCRYPTED_IP_BAN_PREFIX} {
+		keys, err = self.client.Keys(prefix + "*").Result()
+		if err != nil {
+			return
+		}
+		for _, k := range keys {
+			addrs = append(addrs, k[len(prefix):])
+		}
+	}
+	keys, err = self.client.ZRange(IP_RANGE_BAN_KR, 0, -1).Result()
+	if err == nil {
+		for _, end := range keys {
+			start, _ := self.client.HGet(IP_RANGE_BAN_PREFIX+end, "start").Result()
+			addrs = append(addrs, start+" - "+end)
+		}
+	}
+	return
+}
+
+// ranges are keyed by their last address, turn them back into cidrs
+func (self RedisDB) GetAddrBans() (bans []AddrBan, err error) {
+	var keys []string
+	keys, err = self.client.Keys(IP_BAN_PREFIX + "*").Result()
 	if err != nil {
 		return
 	}
-	maxres, err = self.client.ZRangeWithScores(ARTICLE_NUMBERS_PREFIX+"group::"+group, -1, -1).Result()
-	if err != nil || len(minres) < 1 || len(maxres) < 1 {
-		return
+	for _, k := range keys {
+		made, _ := self.client.HGet(k, "made").Int64()
+		bans = append(bans, AddrBan{Addr: k[len(IP_BAN_PREFIX):], Made: made, Expires: -1})
+	}
+	keys, err = self.client.ZRange(IP_RANGE_BAN_KR, 0, -1).Result()
+	for _, end := range keys {
+		var hashres []string
+		hashres, err = self.client.HGetAll(IP_RANGE_BAN_PREFIX + end).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		made, _ := strconv.ParseInt(res["made"], 10, 64)
+		bans = append(bans, AddrBan{Addr: ipRangeCIDR(res["start"], end), Made: made, Expires: -1})
 	}
-	first = int64(minres[0].Score)
-	last = int64(maxres[0].Score)
-
 	return
 }
 
-func (self RedisDB) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
-	var msgs []string
-
-	// widen the range a bit just to be sure with float precision
-	min := float64(id) - 0.0001
-	max := float64(id) + 0.0001
-	mins := fmt.Sprintf("%f", min)
-	maxs := fmt.Sprintf("%f", max)
-
-	msgs, err = self.client.ZRangeByScore(ARTICLE_NUMBERS_PREFIX+"group::"+group, redis.ZRangeByScore{Min: mins, Max: maxs}).Result()
-	if err == nil && len(msgs) > 0 {
-		msgid = msgs[0]
+func (self RedisDB) GetEncAddrBans() (bans []AddrBan, err error) {
+	var keys []string
+	keys, err = self.client.Keys(ENCRYPTED_IP_BAN_PREFIX + "*").Result()
+	for _, k := range keys {
+		made, _ := self.client.HGet(k, "made").Int64()
+		expires, e := self.client.HGet(k, "expires").Int64()
+		if e != nil {
+			expires = -1
+		}
+		bans = append(bans, AddrBan{Addr: k[len(ENCRYPTED_IP_BAN_PREFIX):], Made: made, Expires: expires})
+	}
+	return
+}
+
+func (self RedisDB) ExplainIPBan(addr string) (bans []AddrBan, err error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		err = errors.New("Couldn't parse IP")
+		return
+	}
+	var all []AddrBan
+	all, err = self.GetAddrBans()
+	for _, b := range all {
+		if addrBanCovers(b.Addr, ip) {
+			bans = append(bans, b)
+		}
+	}
+	return
+}
+
+func (self RedisDB) UnbanCIDR(cidr string) (err error) {
+	isnet, ipnet := IsSubnet(cidr)
+	if !isnet {
+		var n int64
+		n, err = self.client.Del(IP_BAN_PREFIX + cidr).Result()
+		if err == nil && n == 0 {
+			err = NoSuchBan
+		}
+		return
+	}
+	min, max := IPNet2MinMax(ipnet)
+	start, end := ZeroIPString(min), ZeroIPString(max)
+	var range_min string
+	range_min, err = self.client.HGet(IP_RANGE_BAN_PREFIX+end, "start").Result()
+	if err == redis.Nil || (err == nil && range_min != start) {
+		err = NoSuchBan
+	} else if err == nil {
+		self.client.ZRem(IP_RANGE_BAN_KR, end)
+		_, err = self.client.Del(IP_RANGE_BAN_PREFIX + end).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetLastAndFirstForGroup(group string) (last, first int64, err error) {
+	var minres, maxres []redis.Z
+	minres, err = self.client.ZRangeWithScores(ARTICLE_NUMBERS_PREFIX+"group::"+group, 0, 0).Result()
+	if err != nil {
+		return
+	}
+	maxres, err = self.client.ZRangeWithScores(ARTICLE_NUMBERS_PREFIX+"group::"+group, -1, -1).Result()
+	if err != nil || len(minres) < 1 || len(maxres) < 1 {
+		return
+	}
+	first = int64(minres[0].Score)
+	last = int64(maxres[0].Score)
+
+	return
+}
+
+func (self RedisDB) GetMessageIDForNNTPID(group string, id int64) (msgid string, err error) {
+	var msgs []string
+
+	// widen the range a bit just to be sure with float precision
+	min := float64(id) - 0.0001
+	max := float64(id) + 0.0001
+	mins := fmt.Sprintf("%f", min)
+	maxs := fmt.Sprintf("%f", max)
+
+	msgs, err = self.client.ZRangeByScore(ARTICLE_NUMBERS_PREFIX+"group::"+group, redis.ZRangeByScore{Min: mins, Max: maxs}).Result()
+	if err == nil && len(msgs) > 0 {
+		msgid = msgs[0]
 	}
 	return
 }
@@ -1003,6 +1610,155 @@ func (self RedisDB) UnMarkModPubkeyCanModGroup(pubkey, group string) (err error)
 	return
 }
 
+func (self RedisDB) CheckModPubkeyPermission(pubkey, newsgroup, perm string) bool {
+	if self.CheckModPubkeyGlobal(pubkey) || self.CheckModPubkeyCanModGroup(pubkey, newsgroup) {
+		return true
+	}
+	result, _ := self.client.SIsMember(MOD_KEY_PREFIX+pubkey+"::Group::"+newsgroup+"::Permissions", perm).Result()
+	return result
+}
+
+func (self RedisDB) GrantModPubkeyPermission(pubkey, newsgroup, perm string) (err error) {
+	_, err = self.client.SAdd(MOD_KEY_PREFIX+pubkey+"::Group::"+newsgroup+"::Permissions", perm).Result()
+	self.client.SAdd(GROUP_MOD_KEY_REVERSE_KR_PREFIX+newsgroup, pubkey)
+	return
+}
+
+func (self RedisDB) RevokeModPubkeyPermission(pubkey, newsgroup, perm string) (err error) {
+	_, err = self.client.SRem(MOD_KEY_PREFIX+pubkey+"::Group::"+newsgroup+"::Permissions", perm).Result()
+	left, _ := self.client.SCard(MOD_KEY_PREFIX + pubkey + "::Group::" + newsgroup + "::Permissions").Result()
+	if left == 0 {
+		self.client.SRem(GROUP_MOD_KEY_REVERSE_KR_PREFIX+newsgroup, pubkey)
+	}
+	return
+}
+
+func (self RedisDB) GetModPubkeyPermissions(pubkey, newsgroup string) (perms []string, err error) {
+	var members []string
+	members, err = self.client.SMembers(MOD_KEY_PREFIX + pubkey + "::Group::" + newsgroup + "::Permissions").Result()
+	for _, perm := range members {
+		if validModPermission(perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return
+}
+
+func (self RedisDB) RotateModPubkey(oldkey, newkey string) (err error) {
+	var keys []string
+	prefix := MOD_KEY_PREFIX + oldkey + "::Group::"
+	keys, err = self.client.Keys(prefix + "*").Result()
+	if err != nil {
+		return
+	}
+	isadmin, _ := self.CheckAdminPubkey(oldkey)
+	multi := self.client.Multi()
+	defer multi.Close()
+	_, err = multi.Exec(func() error {
+		for _, k := range keys {
+			// k is prefix + group + "::Permissions"
+			group := strings.TrimSuffix(k[len(prefix):], "::Permissions")
+			newk := MOD_KEY_PREFIX + newkey + "::Group::" + group + "::Permissions"
+			multi.SUnionStore(newk, newk, k)
+			multi.Del(k)
+			multi.SRem(GROUP_MOD_KEY_REVERSE_KR_PREFIX+group, oldkey)
+			multi.SAdd(GROUP_MOD_KEY_REVERSE_KR_PREFIX+group, newkey)
+		}
+		if isadmin {
+			multi.Set(ADMIN_KEY_PREFIX+newkey, "1", 0)
+			multi.Del(ADMIN_KEY_PREFIX + oldkey)
+		}
+		multi.HMSet(MOD_KEY_PREFIX+oldkey+"::Expired", "replaced_by", newkey, "time_expired", strconv.Itoa(int(timeNow())))
+		return nil
+	})
+	if err == nil {
+		// grants made to and by the old key now belong to the new one
+		var to, by []ModGrant
+		to, err = self.GetModGrantsTo(oldkey)
+		if err == nil {
+			by, err = self.GetModGrantsBy(oldkey)
+		}
+		for _, g := range to {
+			if err == nil {
+				err = self.ForgetModGrant(g.Pubkey, g.Newsgroup, g.Permission)
+			}
+			if err == nil {
+				err = self.RecordModGrant(g.Granter, newkey, g.Newsgroup, g.Permission)
+			}
+		}
+		for _, g := range by {
+			if err == nil {
+				err = self.RecordModGrant(newkey, g.Pubkey, g.Newsgroup, g.Permission)
+			}
+		}
+	}
+	return
+}
+
+// a grant is keyed by pubkey:newsgroup:permission like the ctl message that made it
+func (self RedisDB) RecordModGrant(granter, pubkey, newsgroup, perm string) (err error) {
+	err = self.ForgetModGrant(pubkey, newsgroup, perm)
+	if err != nil {
+		return
+	}
+	target := pubkey + ":" + newsgroup + ":" + perm
+	_, err = self.client.HMSet(MOD_GRANT_PREFIX+target, "granter", granter, "time_granted", strconv.FormatInt(timeNow(), 10)).Result()
+	if err == nil {
+		self.client.SAdd(MOD_GRANTS_BY_KR_PREFIX+granter, target)
+		self.client.SAdd(MOD_GRANTS_TO_KR_PREFIX+pubkey, target)
+	}
+	return
+}
+
+func (self RedisDB) ForgetModGrant(pubkey, newsgroup, perm string) (err error) {
+	target := pubkey + ":" + newsgroup + ":" + perm
+	var granter string
+	granter, err = self.client.HGet(MOD_GRANT_PREFIX+target, "granter").Result()
+	if err == redis.Nil {
+		// never recorded
+		err = nil
+		return
+	} else if err == nil {
+		self.client.SRem(MOD_GRANTS_BY_KR_PREFIX+granter, target)
+		self.client.SRem(MOD_GRANTS_TO_KR_PREFIX+pubkey, target)
+		_, err = self.client.Del(MOD_GRANT_PREFIX + target).Result()
+	}
+	return
+}
+
+func (self RedisDB) getModGrants(kr string) (grants []ModGrant, err error) {
+	var targets []string
+	targets, err = self.client.SMembers(kr).Result()
+	for _, target := range targets {
+		pubkey, newsgroup, perm, ok := parseModGrantTarget(target)
+		if !ok {
+			continue
+		}
+		var hashres []string
+		hashres, err = self.client.HGetAll(MOD_GRANT_PREFIX + target).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		t, _ := strconv.ParseInt(res["time_granted"], 10, 64)
+		grants = append(grants, ModGrant{Granter: res["granter"], Pubkey: pubkey, Newsgroup: newsgroup, Permission: perm, Granted: t})
+	}
+	return
+}
+
+func (self RedisDB) GetModGrantsBy(granter string) ([]ModGrant, error) {
+	return self.getModGrants(MOD_GRANTS_BY_KR_PREFIX + granter)
+}
+
+func (self RedisDB) GetModGrantsTo(pubkey string) ([]ModGrant, error) {
+	return self.getModGrants(MOD_GRANTS_TO_KR_PREFIX + pubkey)
+}
+
+func (self RedisDB) ModPubkeyExpired(pubkey string) bool {
+	expired, _ := self.client.Exists(MOD_KEY_PREFIX + pubkey + "::Expired").Result()
+	return expired
+}
+
 func (self RedisDB) IsExpired(root_message_id string) bool {
 	return self.HasArticle(root_message_id) && !self.HasArticleLocal(root_message_id)
 }
@@ -1137,6 +1893,60 @@ func (self RedisDB) CheckNNTPUserExists(username string) (exists bool, err error
 	return
 }
 
+func (self RedisDB) GetNNTPUser(username string) (user *NNTPUser, err error) {
+	var hashres []string
+	hashres, err = self.client.HGetAll(NNTP_LOGIN_PREFIX + username).Result()
+	if err == nil && len(hashres) > 0 {
+		res := processHashResult(hashres)
+		user = &NNTPUser{Username: username, Role: res["role"], Disabled: res["disabled"] == "1"}
+		if user.Role == "" {
+			// made before there were roles
+			user.Role = NNTPRoleFeeder
+		}
+	}
+	return
+}
+
+func (self RedisDB) GetNNTPUsers() (users []NNTPUser, err error) {
+	var keys []string
+	keys, err = self.client.Keys(NNTP_LOGIN_PREFIX + "*").Result()
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		var user *NNTPUser
+		user, err = self.GetNNTPUser(k[len(NNTP_LOGIN_PREFIX):])
+		if err != nil {
+			return
+		}
+		if user != nil {
+			users = append(users, *user)
+		}
+	}
+	return
+}
+
+func (self RedisDB) SetNNTPPassword(username, passwd string) (err error) {
+	login_salt := genLoginCredSalt()
+	login_hash := nntpLoginCredHash(passwd, login_salt)
+	_, err = self.client.HMSet(NNTP_LOGIN_PREFIX+username, "login_hash", login_hash, "login_salt", login_salt).Result()
+	return
+}
+
+func (self RedisDB) SetNNTPUserRole(username, role string) (err error) {
+	_, err = self.client.HSet(NNTP_LOGIN_PREFIX+username, "role", role).Result()
+	return
+}
+
+func (self RedisDB) SetNNTPUserDisabled(username string, disabled bool) (err error) {
+	val := "0"
+	if disabled {
+		val = "1"
+	}
+	_, err = self.client.HSet(NNTP_LOGIN_PREFIX+username, "disabled", val).Result()
+	return
+}
+
 func (self RedisDB) clearIPRange(start, end string) {
 	ranges, _ := self.client.ZRangeByLex(IP_RANGE_BAN_KR, redis.ZRangeByScore{Min: "(" + start, Max: "[" + end}).Result()
 	for _, iprange := range ranges {
@@ -1169,8 +1979,164 @@ func (self RedisDB) CountAllArticlesInGroup(group string) (count int64, err erro
 	return
 }
 
-func (self RedisDB) BanPubkey(pubkey string) error {
-	return errors.New("ban pubkey not implemented")
+func (self RedisDB) BanPubkey(pubkey string) (err error) {
+	_, err = self.client.Set(BANNED_PUBKEY_PREFIX+pubkey, strconv.FormatInt(timeNow(), 10), 0).Result()
+	return
+}
+
+func (self RedisDB) UnbanPubkey(pubkey string) (err error) {
+	_, err = self.client.Del(BANNED_PUBKEY_PREFIX + pubkey).Result()
+	return
+}
+
+func (self RedisDB) UnbanEncAddr(encaddr string) (err error) {
+	_, err = self.client.Del(ENCRYPTED_IP_BAN_PREFIX + encaddr).Result()
+	return
+}
+
+func (self RedisDB) ShadowBanEncAddr(encaddr string) (err error) {
+	_, err = self.client.HMSet(ENCRYPTED_IP_SHADOW_PREFIX+encaddr, "encaddr", encaddr, "made", strconv.Itoa(int(timeNow()))).Result()
+	return
+}
+
+func (self RedisDB) UnshadowBanEncAddr(encaddr string) (err error) {
+	_, err = self.client.Del(ENCRYPTED_IP_SHADOW_PREFIX + encaddr).Result()
+	return
+}
+
+func (self RedisDB) CheckEncAddrShadowBanned(encaddr string) (banned bool, err error) {
+	banned, err = self.client.Exists(ENCRYPTED_IP_SHADOW_PREFIX + encaddr).Result()
+	return
+}
+
+func (self RedisDB) AddBanAppeal(encaddr, text string) (err error) {
+	now := timeNow()
+	var set bool
+	// setting the text only if there is none keeps it to one appeal
+	set, err = self.client.HSetNX(BAN_APPEAL_PREFIX+encaddr, "appeal", text).Result()
+	if err == nil {
+		if !set {
+			err = BanAppealExists
+			return
+		}
+		_, err = self.client.HMSet(BAN_APPEAL_PREFIX+encaddr, "status", BanAppealPending, "time_appealed", strconv.FormatInt(now, 10)).Result()
+		if err == nil {
+			_, err = self.client.ZAdd(PENDING_BAN_APPEALS_WKR, redis.Z{Score: float64(now), Member: encaddr}).Result()
+		}
+	}
+	return
+}
+
+func (self RedisDB) GetBanAppeal(encaddr string) (appeal *BanAppeal, err error) {
+	var hashres []string
+	hashres, err = self.client.HGetAll(BAN_APPEAL_PREFIX + encaddr).Result()
+	if err == nil && len(hashres) > 0 {
+		res := processHashResult(hashres)
+		t, _ := strconv.ParseInt(res["time_appealed"], 10, 64)
+		appeal = &BanAppeal{EncAddr: encaddr, Text: res["appeal"], Status: res["status"], Appealed: t}
+	}
+	return
+}
+
+func (self RedisDB) GetPendingBanAppeals() (appeals []BanAppeal, err error) {
+	var addrs []string
+	addrs, err = self.client.ZRange(PENDING_BAN_APPEALS_WKR, 0, -1).Result()
+	for _, encaddr := range addrs {
+		var appeal *BanAppeal
+		appeal, err = self.GetBanAppeal(encaddr)
+		if err != nil {
+			return
+		}
+		if appeal != nil {
+			appeals = append(appeals, *appeal)
+		}
+	}
+	return
+}
+
+func (self RedisDB) UpholdBanAppeal(encaddr string) (err error) {
+	var exists bool
+	exists, err = self.client.Exists(BAN_APPEAL_PREFIX + encaddr).Result()
+	if err == nil && exists {
+		_, err = self.client.HSet(BAN_APPEAL_PREFIX+encaddr, "status", BanAppealUpheld).Result()
+		self.client.ZRem(PENDING_BAN_APPEALS_WKR, encaddr)
+	}
+	return
+}
+
+func (self RedisDB) DeleteBanAppeal(encaddr string) (err error) {
+	self.client.ZRem(PENDING_BAN_APPEALS_WKR, encaddr)
+	_, err = self.client.Del(BAN_APPEAL_PREFIX + encaddr).Result()
+	return
+}
+
+func (self RedisDB) SetThreadFlag(root_message_id, newsgroup, flag string, on bool) (err error) {
+	if !on {
+		_, err = self.client.HDel(THREAD_FLAGS_PREFIX+root_message_id, flag).Result()
+		if err == nil {
+			_, err = self.client.ZRem(GROUP_THREAD_FLAG_WKR_PREFIX+flag+"::"+newsgroup, root_message_id).Result()
+		}
+	} else if !self.CheckThreadFlag(root_message_id, flag) {
+		now := timeNow()
+		_, err = self.client.HSet(THREAD_FLAGS_PREFIX+root_message_id, flag, strconv.FormatInt(now, 10)).Result()
+		if err == nil {
+			_, err = self.client.ZAdd(GROUP_THREAD_FLAG_WKR_PREFIX+flag+"::"+newsgroup, redis.Z{Score: float64(now), Member: root_message_id}).Result()
+		}
+	}
+	return
+}
+
+func (self RedisDB) CheckThreadFlag(root_message_id, flag string) bool {
+	has, err := self.client.HExists(THREAD_FLAGS_PREFIX+root_message_id, flag).Result()
+	if err != nil {
+		log.Println("failed to check thread flag", flag, "on", root_message_id, err)
+	}
+	return has
+}
+
+func (self RedisDB) GetThreadsWithFlag(newsgroup, flag string) (roots []string, err error) {
+	roots, err = self.client.ZRange(GROUP_THREAD_FLAG_WKR_PREFIX+flag+"::"+newsgroup, 0, -1).Result()
+	return
+}
+
+func (self RedisDB) BanAttachment(hash string) (err error) {
+	_, err = self.client.Set(BANNED_ATTACHMENT_PREFIX+hash, strconv.FormatInt(timeNow(), 10), 0).Result()
+	return
+}
+
+func (self RedisDB) UnbanAttachment(hash string) (err error) {
+	_, err = self.client.Del(BANNED_ATTACHMENT_PREFIX + hash).Result()
+	return
+}
+
+func (self RedisDB) AttachmentBanned(hash string) (banned bool, err error) {
+	banned, err = self.client.Exists(BANNED_ATTACHMENT_PREFIX + hash).Result()
+	return
+}
+
+func (self RedisDB) GetBannedAttachments() (hashes []string, err error) {
+	var keys []string
+	keys, err = self.client.Keys(BANNED_ATTACHMENT_PREFIX + "*").Result()
+	for _, k := range keys {
+		hashes = append(hashes, k[len(BANNED_ATTACHMENT_PREFIX):])
+	}
+	return
+}
+
+func (self RedisDB) GetAttachmentHashes() (hashes map[string]bool, err error) {
+	var keys []string
+	keys, err = self.client.Keys(ATTACHMENT_ARTICLE_KR_PREFIX + "*").Result()
+	if err == nil {
+		hashes = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			hashes[k[len(ATTACHMENT_ARTICLE_KR_PREFIX):]] = true
+		}
+	}
+	return
+}
+
+func (self RedisDB) AttachmentReferenced(hash string) (bool, error) {
+	return self.client.Exists(ATTACHMENT_ARTICLE_KR_PREFIX + hash).Result()
 }
 
 func (self RedisDB) CheckAdminPubkey(pubkey string) (isadmin bool, err error) {
@@ -1196,8 +2162,8 @@ func (self RedisDB) MarkPubkeyAdmin(pubkey string) (err error) {
 	return
 }
 
-func (self RedisDB) PubkeyIsBanned(pubkey string) (bannded bool, err error) {
-	// TODO: implement
+func (self RedisDB) PubkeyIsBanned(pubkey string) (banned bool, err error) {
+	banned, err = self.client.Exists(BANNED_PUBKEY_PREFIX + pubkey).Result()
 	return
 }
 
@@ -1207,6 +2173,12 @@ func (self RedisDB) GetPostsBefore(t time.Time) (msgids []string, err error) {
 	return
 }
 
+func (self RedisDB) GetPostsBeforeInGroup(group string, t time.Time) (msgids []string, err error) {
+	s := strconv.FormatInt(t.Unix(), 10)
+	msgids, err = self.client.ZRangeByScore(GROUP_ARTICLE_POSTTIME_WKR_PREFIX+group, redis.ZRangeByScore{Min: "0", Max: s}).Result()
+	return
+}
+
 func (self RedisDB) GetPostingStats(gran, begin, end int64) (st PostingStats, err error) {
 	err = errors.New("operation not supported by backend")
 	return
@@ -1217,6 +2189,107 @@ func (self RedisDB) SearchQuery(prefix, group string, words string) (posts []Pos
 	return
 }
 
+func (self RedisDB) SearchPosts(prefix string, q SearchParams) (posts []PostModel, total int64, err error) {
+	err = errors.New("operation not supported by backend")
+	return
+}
+
+func (self RedisDB) MarkArticleOffered(feedname, msgid string) (err error) {
+	pipe := self.client.Pipeline()
+	defer pipe.Close()
+	pipe.SAdd(FEED_OFFERED_KR_PREFIX+feedname, msgid)
+	// which feeds it is in, so they are trimmed when the article goes
+	pipe.SAdd(ARTICLE_OFFERED_KR_PREFIX+msgid, feedname)
+	_, err = pipe.Exec()
+	return
+}
+
+func (self RedisDB) FeedsOffered(msgid string) (feeds map[string]bool, err error) {
+	var names []string
+	names, err = self.client.SMembers(ARTICLE_OFFERED_KR_PREFIX + msgid).Result()
+	if err == nil {
+		feeds = make(map[string]bool)
+		for _, name := range names {
+			feeds[name] = true
+		}
+	}
+	return
+}
+
+func (self RedisDB) SetCtlFeed(msgid, feedname string) (err error) {
+	_, err = self.client.Set(CTL_FEED_PREFIX+msgid, feedname, 0).Result()
+	return
+}
+
+func (self RedisDB) TakeCtlFeed(msgid string) (feedname string, remote bool, err error) {
+	feedname, err = self.client.Get(CTL_FEED_PREFIX + msgid).Result()
+	if err == redis.Nil {
+		err = nil
+	} else if err == nil {
+		remote = true
+		_, err = self.client.Del(CTL_FEED_PREFIX + msgid).Result()
+	}
+	return
+}
+
+func (self RedisDB) ClearOfferedArticles(feedname string) (err error) {
+	_, err = self.client.Del(FEED_OFFERED_KR_PREFIX + feedname).Result()
+	return
+}
+
+// reports are stored as a hash of reason -> time reported per message-id
+func (self RedisDB) AddReport(msgid, newsgroup, reason string) (err error) {
+	now := timeNow()
+	_, err = self.client.HSet(REPORT_PREFIX+msgid, reason, strconv.FormatInt(now, 10)).Result()
+	if err == nil {
+		self.client.HSet(REPORT_PREFIX+msgid, "::Newsgroup", newsgroup)
+		self.client.ZAdd(REPORTS_WKR, redis.Z{Score: float64(now), Member: msgid})
+		self.client.ZAdd(GROUP_REPORTS_WKR_PREFIX+newsgroup, redis.Z{Score: float64(now), Member: msgid})
+	}
+	return
+}
+
+func (self RedisDB) GetReports(newsgroup string) (reports []PostReport, err error) {
+	key := REPORTS_WKR
+	if newsgroup != "" {
+		key = GROUP_REPORTS_WKR_PREFIX + newsgroup
+	}
+	var msgids []string
+	msgids, err = self.client.ZRevRange(key, 0, -1).Result()
+	for _, msgid := range msgids {
+		var hashres []string
+		hashres, err = self.client.HGetAll(REPORT_PREFIX + msgid).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		group := res["::Newsgroup"]
+		for reason, t := range res {
+			if reason == "::Newsgroup" {
+				continue
+			}
+			reported, _ := strconv.ParseInt(t, 10, 64)
+			reports = append(reports, PostReport{MessageID: msgid, Newsgroup: group, Reason: reason, Reported: reported})
+		}
+	}
+	return
+}
+
+func (self RedisDB) DismissReports(msgid string) (err error) {
+	var group string
+	group, err = self.client.HGet(REPORT_PREFIX+msgid, "::Newsgroup").Result()
+	if err == redis.Nil {
+		// no reports
+		err = nil
+		return
+	} else if err == nil {
+		self.client.ZRem(GROUP_REPORTS_WKR_PREFIX+group, msgid)
+		self.client.ZRem(REPORTS_WKR, msgid)
+		_, err = self.client.Del(REPORT_PREFIX + msgid).Result()
+	}
+	return
+}
+
 func processHashResult(hash []string) (mapRes map[string]string) {
 	mapRes = make(map[string]string)
 	max := len(hash)
@@ -1229,3 +2302,204 @@ func processHashResult(hash []string) (mapRes map[string]string) {
 func RedisEnabled() bool {
 	return true
 }
+
+func (self RedisDB) QuarantineArticle(msgid, newsgroup string, score float64) (err error) {
+	now := timeNow()
+	_, err = self.client.HMSet(QUARANTINE_PREFIX+msgid, "newsgroup", newsgroup, "score", strconv.FormatFloat(score, 'f', -1, 64), "time_quarantined", strconv.FormatInt(now, 10)).Result()
+	if err == nil {
+		_, err = self.client.ZAdd(QUARANTINE_WKR, redis.Z{Score: float64(now), Member: msgid}).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetQuarantinedArticles(newsgroup string) (articles []QuarantinedArticle, err error) {
+	var msgids []string
+	msgids, err = self.client.ZRevRange(QUARANTINE_WKR, 0, -1).Result()
+	for _, msgid := range msgids {
+		var hashres []string
+		hashres, err = self.client.HGetAll(QUARANTINE_PREFIX + msgid).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		if newsgroup != "" && res["newsgroup"] != newsgroup {
+			continue
+		}
+		score, _ := strconv.ParseFloat(res["score"], 64)
+		t, _ := strconv.ParseInt(res["time_quarantined"], 10, 64)
+		articles = append(articles, QuarantinedArticle{MessageID: msgid, Newsgroup: res["newsgroup"], Score: score, Quarantined: t, Feed: res["feed"]})
+	}
+	return
+}
+
+func (self RedisDB) UnquarantineArticle(msgid string) (err error) {
+	_, err = self.client.Del(QUARANTINE_PREFIX + msgid).Result()
+	if err == nil {
+		_, err = self.client.ZRem(QUARANTINE_WKR, msgid).Result()
+	}
+	return
+}
+
+func (self RedisDB) RecordRejectedArticle(msgid, newsgroup, reason, feed string) (err error) {
+	now := timeNow()
+	_, err = self.client.HMSet(REJECTED_PREFIX+msgid, "newsgroup", newsgroup, "reason", reason, "feed", feed, "time_rejected", strconv.FormatInt(now, 10)).Result()
+	if err == nil {
+		_, err = self.client.ZAdd(REJECTED_WKR, redis.Z{Score: float64(now), Member: msgid}).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetRejectedArticles() (articles []RejectedArticle, err error) {
+	var msgids []string
+	msgids, err = self.client.ZRevRange(REJECTED_WKR, 0, -1).Result()
+	for _, msgid := range msgids {
+		var hashres []string
+		hashres, err = self.client.HGetAll(REJECTED_PREFIX + msgid).Result()
+		if err != nil {
+			return
+		}
+		res := processHashResult(hashres)
+		t, _ := strconv.ParseInt(res["time_rejected"], 10, 64)
+		articles = append(articles, RejectedArticle{MessageID: msgid, Newsgroup: res["newsgroup"], Reason: res["reason"], Feed: res["feed"], Rejected: t})
+	}
+	return
+}
+
+func (self RedisDB) ForgetRejectedArticle(msgid string) (err error) {
+	self.client.ZRem(REJECTED_WKR, msgid)
+	_, err = self.client.Del(REJECTED_PREFIX + msgid).Result()
+	return
+}
+
+func (self RedisDB) CacheAddrListing(addr string, lists []string, expires int64) (err error) {
+	ttl := time.Duration(expires-timeNow()) * time.Second
+	if ttl > 0 {
+		_, err = self.client.Set(ADDR_LISTING_PREFIX+addr, strings.Join(lists, " "), ttl).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetAddrListing(addr string) (lists []string, cached bool, err error) {
+	var str string
+	str, err = self.client.Get(ADDR_LISTING_PREFIX + addr).Result()
+	if err == redis.Nil {
+		err = nil
+	} else if err == nil {
+		cached = true
+		lists = strings.Fields(str)
+	}
+	return
+}
+
+func (self RedisDB) SetPubkeyTrusted(pubkey string, trusted bool) (err error) {
+	if trusted {
+		_, err = self.client.SAdd(TRUSTED_PUBKEYS_KR, pubkey).Result()
+	} else {
+		_, err = self.client.SRem(TRUSTED_PUBKEYS_KR, pubkey).Result()
+	}
+	return
+}
+
+func (self RedisDB) PubkeyTrusted(pubkey string) (bool, error) {
+	return self.client.SIsMember(TRUSTED_PUBKEYS_KR, pubkey).Result()
+}
+
+func (self RedisDB) HoldArticleFromFeed(msgid, newsgroup, feed string) (err error) {
+	now := timeNow()
+	_, err = self.client.HMSet(QUARANTINE_PREFIX+msgid, "newsgroup", newsgroup, "score", "0", "time_quarantined", strconv.FormatInt(now, 10), "feed", feed).Result()
+	if err == nil {
+		_, err = self.client.ZAdd(QUARANTINE_WKR, redis.Z{Score: float64(now), Member: msgid}).Result()
+	}
+	return
+}
+
+func (self RedisDB) GetFeedIntake(feed string) (intake FeedIntake, err error) {
+	intake.Feed = feed
+	var hashres []string
+	hashres, err = self.client.HGetAll(FEED_INTAKE_PREFIX + feed).Result()
+	if err == nil {
+		res := processHashResult(hashres)
+		intake.Streak, _ = strconv.ParseInt(res["streak"], 10, 64)
+		intake.Trusted = res["trusted"] == "1"
+	}
+	return
+}
+
+func (self RedisDB) SetFeedIntake(intake FeedIntake) (err error) {
+	trusted := "0"
+	if intake.Trusted {
+		trusted = "1"
+	}
+	_, err = self.client.HMSet(FEED_INTAKE_PREFIX+intake.Feed, "streak", strconv.FormatInt(intake.Streak, 10), "trusted", trusted).Result()
+	return
+}
+
+func (self RedisDB) SubscribeBanlist(pubkey string) (err error) {
+	_, err = self.client.SAdd(BANLIST_SUBS_KR, pubkey).Result()
+	return
+}
+
+func (self RedisDB) UnsubscribeBanlist(pubkey string) (err error) {
+	_, err = self.client.SRem(BANLIST_SUBS_KR, pubkey).Result()
+	return
+}
+
+func (self RedisDB) BanlistSubscribed(pubkey string) bool {
+	subscribed, _ := self.client.SIsMember(BANLIST_SUBS_KR, pubkey).Result()
+	return subscribed
+}
+
+func (self RedisDB) GetBanlistSubscriptions() (pubkeys []string, err error) {
+	pubkeys, err = self.client.SMembers(BANLIST_SUBS_KR).Result()
+	return
+}
+
+// entries are stored as kind:value -> applied:time_added
+func (self RedisDB) AddBanlistEntry(source, kind, value string, applied bool) (err error) {
+	field := kind + ":" + value
+	var exists bool
+	exists, err = self.client.HExists(BANLIST_ENTRIES_PREFIX+source, field).Result()
+	if err == nil && !exists {
+		flag := "0"
+		if applied {
+			flag = "1"
+		}
+		_, err = self.client.HSet(BANLIST_ENTRIES_PREFIX+source, field, flag+":"+strconv.FormatInt(timeNow(), 10)).Result()
+		if err == nil {
+			_, err = self.client.SAdd(BANLIST_SOURCES_KR_PREFIX+field, source).Result()
+		}
+	}
+	return
+}
+
+func (self RedisDB) GetBanlistEntries(source string) (entries []BanlistEntry, err error) {
+	var hashres []string
+	hashres, err = self.client.HGetAll(BANLIST_ENTRIES_PREFIX + source).Result()
+	if err != nil {
+		return
+	}
+	for field, val := range processHashResult(hashres) {
+		kv := strings.SplitN(field, ":", 2)
+		fv := strings.SplitN(val, ":", 2)
+		if len(kv) != 2 || len(fv) != 2 {
+			continue
+		}
+		added, _ := strconv.ParseInt(fv[1], 10, 64)
+		entries = append(entries, BanlistEntry{Source: source, Kind: kv[0], Value: kv[1], Applied: fv[0] == "1", Added: added})
+	}
+	return
+}
+
+func (self RedisDB) RemoveBanlistEntry(source, kind, value string) (err error) {
+	field := kind + ":" + value
+	_, err = self.client.HDel(BANLIST_ENTRIES_PREFIX+source, field).Result()
+	if err == nil {
+		_, err = self.client.SRem(BANLIST_SOURCES_KR_PREFIX+field, source).Result()
+	}
+	return
+}
+
+func (self RedisDB) BanlistEntryListed(kind, value string) bool {
+	count, _ := self.client.SCard(BANLIST_SOURCES_KR_PREFIX + kind + ":" + value).Result()
+	return count > 0
+}
@@ -427,7 +427,7 @@ func (self *RedisCache) RegenerateBoard(group string) {
 func (self *RedisCache) regenerateCatalog(board string, out io.Writer) {
 	buf := new(bytes.Buffer)
 	wr := io.MultiWriter(out, buf)
-	template.genCatalog(self.prefix, self.name, board, wr, self.database)
+	template.genCatalog(self.prefix, self.name, board, wr, self.database, false)
 	key := CATALOG_PREFIX + board
 	self.cache(key, buf)
 }
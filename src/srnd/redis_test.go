@@ -0,0 +1,135 @@
+// +build !disable_redis
+
+package srnd
+
+import (
+	"gopkg.in/redis.v3"
+	"os"
+	"testing"
+	"time"
+)
+
+// a redis database to test against, the test is skipped if there is none
+// uses SRND_TEST_REDIS or localhost:6379, in a db of its own
+func testRedisDB(t *testing.T) RedisDB {
+
+	addr := os.Getenv("SRND_TEST_REDIS")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	db := RedisDB{client: redis.NewClient(&redis.Options{Addr: addr, DB: 15})}
+	if _, err := db.client.Ping().Result(); err != nil {
+		db.client.Close()
+		t.Skip("no redis at", addr, err)
+	}
+	return db
+
+}
+
+func TestRedisMessageIDByHeader(t *testing.T) {
+
+	db := testRedisDB(t)
+	defer db.Close()
+	msgid := genMessageID("test.tld")
+	nntp := newPlaintextArticle("hello", "test@test.tld", "test", "test", "test.tld", msgid, "overchan.test")
+	nntp.(*nntpArticle).headers.Set("X-Encrypted-IP", "encaddr-"+msgid)
+	nntp.(*nntpArticle).headers.Set("X-Pubkey-Ed25519", "pubkey-"+msgid)
+	if err := db.RegisterArticle(nntp); err != nil {
+		t.Fatal(err)
+	}
+	defer db.DeleteArticle(msgid)
+	msgids, err := db.GetMessageIDByEncryptedIP("encaddr-" + msgid)
+	if err != nil || len(msgids) != 1 || msgids[0] != msgid {
+		t.Error("post not found by encrypted address", msgids, err)
+	}
+	msgids, err = db.GetMessageIDByPubkey("pubkey-" + msgid)
+	if err != nil || len(msgids) != 1 || msgids[0] != msgid {
+		t.Error("post not found by pubkey", msgids, err)
+	}
+	msgids, err = db.GetMessageIDByHeader("Newsgroups", "overchan.test")
+	if err != nil || len(msgids) == 0 {
+		t.Error("post not found by a header name in any case", msgids, err)
+	}
+
+}
+
+// register a thread with a reply posted at each time, done deletes it again
+func testRedisThread(t *testing.T, db RedisDB, posted ...time.Time) (root string, replies []string, done func()) {
+
+	root = genMessageID("test.tld")
+	nntp := newPlaintextArticle("root", "test@test.tld", "test", "test", "test.tld", root, "overchan.test")
+	if err := db.RegisterArticle(nntp); err != nil {
+		t.Fatal(err)
+	}
+	for _, tm := range posted {
+		msgid := genMessageID("test.tld")
+		nntp = newPlaintextArticle("reply", "test@test.tld", "test", "test", "test.tld", msgid, "overchan.test")
+		nntp.(*nntpArticle).headers.Set("References", root)
+		nntp.(*nntpArticle).headers.Set("Date", tm.Format(time.RFC1123Z))
+		if err := db.RegisterArticle(nntp); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, msgid)
+	}
+	done = func() {
+		for _, msgid := range append(replies, root) {
+			db.DeleteArticle(msgid)
+		}
+	}
+	return
+
+}
+
+func TestRedisThreadRepliesSince(t *testing.T) {
+
+	db := testRedisDB(t)
+	defer db.Close()
+	since := time.Now().Add(-time.Hour)
+	root, replies, done := testRedisThread(t, db, since.Add(-time.Minute), since, since.Add(time.Minute))
+	defer done()
+	posts := db.GetThreadReplyPostModelsSince("/", root, since.Unix())
+	if len(posts) != 1 || posts[0].MessageID() != replies[2] {
+		t.Error("wrong replies since", len(posts))
+	}
+
+}
+
+func TestRedisThreadReplyPage(t *testing.T) {
+
+	db := testRedisDB(t)
+	defer db.Close()
+	var posted []time.Time
+	for i := 0; i < 5; i++ {
+		posted = append(posted, time.Now().Add(time.Duration(i-10)*time.Minute))
+	}
+	root, replies, done := testRedisThread(t, db, posted...)
+	defer done()
+	posts := db.GetThreadReplyPostModelsPage("/", root, 2, 2)
+	if len(posts) != 2 || posts[0].MessageID() != replies[2] || posts[1].MessageID() != replies[3] {
+		t.Error("wrong page of replies", len(posts))
+	}
+
+}
+
+func TestRedisFeedsOffered(t *testing.T) {
+
+	db := testRedisDB(t)
+	defer db.Close()
+	msgid := genMessageID("test.tld")
+	for _, feed := range []string{"feed-a", "feed-b"} {
+		if err := db.MarkArticleOffered(feed, msgid); err != nil {
+			t.Fatal(err)
+		}
+		defer db.client.SRem(FEED_OFFERED_KR_PREFIX+feed, msgid)
+	}
+	defer db.client.Del(ARTICLE_OFFERED_KR_PREFIX + msgid)
+	feeds, err := db.FeedsOffered(msgid)
+	if err != nil || len(feeds) != 2 || !feeds["feed-a"] || !feeds["feed-b"] {
+		t.Error("wrong feeds offered", feeds, err)
+	}
+	feeds, err = db.FeedsOffered(genMessageID("test.tld"))
+	if err != nil || len(feeds) != 0 {
+		t.Error("an article nobody was offered has feeds", feeds, err)
+	}
+
+}
@@ -0,0 +1,86 @@
+//
+// rejected.go -- keep articles our filters and bans rejected so mods can release them
+//
+
+package srnd
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net/textproto"
+)
+
+// keep an article we rejected for the mods instead of dropping it
+// stored as is without touching attachments in the rejected dir, never served, registered or federated
+func (self *NNTPDaemon) divertRejected(hdr textproto.MIMEHeader, body io.Reader, reason, feedname string) (err error) {
+	msgid := getMessageID(hdr)
+	f := self.store.CreateRejectedFile(msgid)
+	if f == nil {
+		_, err = io.Copy(Discard, body)
+		return
+	}
+	err = writeMIMEHeader(f, hdr)
+	if err == nil {
+		_, err = io.Copy(f, body)
+	}
+	f.Close()
+	if err == nil {
+		err = self.database.RecordRejectedArticle(msgid, hdr.Get("Newsgroups"), reason, feedname)
+	}
+	if err == nil {
+		log.Println("kept rejected article", msgid, "for the mods:", reason)
+	} else {
+		self.store.RemoveRejected(msgid)
+	}
+	return
+}
+
+// let a rejected article in like we never rejected it
+func (self *NNTPDaemon) releaseRejected(msgid string) (err error) {
+	var r io.ReadCloser
+	r, err = self.store.OpenRejected(msgid)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	// stored with its attachments this time, the rejected copy stays until it is in
+	br := bufio.NewReader(r)
+	var hdr textproto.MIMEHeader
+	hdr, err = readMIMEHeader(br)
+	if err == nil {
+		f := self.store.CreateFile(msgid)
+		if f == nil {
+			err = errors.New("cannot store " + msgid)
+		} else {
+			err = writeMIMEHeader(f, hdr)
+			if err == nil {
+				err = self.store.ProcessMessageBody(f, hdr, br, "")
+			}
+			f.Close()
+		}
+	}
+	if err != nil && err != ArticleQuarantined {
+		// it can be tried again or purged
+		DelFile(self.store.GetFilename(msgid))
+		return
+	}
+	self.store.RemoveRejected(msgid)
+	quarantined := err == ArticleQuarantined
+	err = self.database.UnbanArticle(msgid)
+	if err == nil {
+		err = self.database.ForgetRejectedArticle(msgid)
+	}
+	if err == nil && !quarantined {
+		self.loadFromInfeed(msgid)
+	}
+	return
+}
+
+// throw away a rejected article for good, it stays banned
+func (self *NNTPDaemon) purgeRejected(msgid string) (err error) {
+	err = self.database.ForgetRejectedArticle(msgid)
+	self.store.RemoveRejected(msgid)
+	return
+}
@@ -0,0 +1,127 @@
+//
+// reload.go -- applying a changed srnd.ini and feeds.ini on SIGHUP without restarting
+//
+
+package srnd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// what changed in a feed between two loads of the config
+// policy is true if what it federates changed, connections pick that up as they are
+// reconnect is true if anything else changed, it has to start over with the new config
+func feedConfigChanged(old, cur FeedConfig) (policy, reconnect bool) {
+	policy = !reflect.DeepEqual(old.policy, cur.policy)
+	old.policy = cur.policy
+	reconnect = !reflect.DeepEqual(old, cur)
+	return
+}
+
+// make the feeds we persist match the config
+// feeds that are the same or only changed their policy keep their connections
+func (self *NNTPDaemon) reloadFeeds(feeds []FeedConfig) {
+	loaded := make(map[string]FeedConfig)
+	for _, status := range self.activeFeeds() {
+		loaded[status.State.Config.Name] = status.State.config()
+	}
+	for _, conf := range feeds {
+		old, ok := loaded[conf.Name]
+		delete(loaded, conf.Name)
+		if !ok {
+			log.Println("reload adds feed", conf.Name)
+			self.addFeed(conf)
+			continue
+		}
+		policy, reconnect := feedConfigChanged(old, conf)
+		if reconnect {
+			log.Println("reload reconnects feed", conf.Name)
+			self.removeFeed(conf.Name)
+			self.addFeed(conf)
+		} else if policy {
+			log.Println("reload changes policy of feed", conf.Name)
+			err := self.modifyFeedPolicy(conf.Name, conf.policy)
+			if err != nil {
+				log.Println("failed to change policy of feed", conf.Name, err)
+			}
+		}
+	}
+	for name := range loaded {
+		log.Println("reload removes feed", name)
+		self.removeFeed(name)
+	}
+}
+
+// read the config again and apply what can change while running, on SIGHUP and when feed files change
+// feeds, retention, trash, posting, [group] and thumbnail settings apply right away,
+// anything else needs a restart, a config with problems is not loaded at all
+func (self *NNTPDaemon) Reload() {
+	self.reload_mtx.Lock()
+	defer self.reload_mtx.Unlock()
+	_, problems := checkConfFiles()
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Println("not reloading config:", p)
+		}
+		return
+	}
+	conf, err := LoadConfig()
+	if err != nil {
+		log.Println("not reloading config,", err)
+		return
+	}
+	log.Println("reloading config")
+	setLogLevel(conf.daemon["log_level"])
+	if self.remote == nil && self.running {
+		self.reloadFeeds(conf.feeds)
+		self.feeds_mtx.Lock()
+		self.conf.feeds = conf.feeds
+		self.feeds_mtx.Unlock()
+	}
+	atomic.StoreInt64(&self.article_lifetime, int64(conf.nntp.article_lifetime))
+	atomic.StoreInt64(&self.trash_hours, int64(conf.articles.trash_hours))
+	self.posting_mtx.Lock()
+	self.posting = postingConfigFrom(conf.daemon)
+	self.posting_mtx.Unlock()
+	self.groups_mtx.Lock()
+	self.groups = conf.groups
+	self.groups_mtx.Unlock()
+	if self.store != nil {
+		err := self.store.ReloadThumbnails(conf.store)
+		if err != nil {
+			log.Println("keeping old thumbnail settings,", err)
+		}
+	}
+	self.ReloadTemplates()
+}
+
+// names, sizes and times of the feed files, changes when one is added, removed or written
+func feedFilesStamp(files []string) string {
+	var stamp []string
+	for _, fname := range files {
+		if st, err := os.Stat(fname); err == nil {
+			stamp = append(stamp, fmt.Sprintf("%s %d %d", fname, st.Size(), st.ModTime().UnixNano()))
+		}
+	}
+	return strings.Join(stamp, "\n")
+}
+
+// reload when feeds.ini or a file in the feeds directory changes, so peers come and go with their files
+func (self *NNTPDaemon) watchFeeds(interval time.Duration) {
+	stamp := feedFilesStamp(feedFiles(self.conf.daemon))
+	for {
+		time.Sleep(interval)
+		cur := feedFilesStamp(feedFiles(self.conf.daemon))
+		if cur != stamp {
+			stamp = cur
+			log.Println("feed files changed")
+			self.Reload()
+		}
+	}
+}
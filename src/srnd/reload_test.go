@@ -0,0 +1,70 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestFeedConfigChanged(t *testing.T) {
+
+	old := FeedConfig{Name: "peer", Addr: "peer.tld:119", connections: 1, policy: FeedPolicy{rules: map[string]string{"overchan.*": "1"}}}
+	cur := old
+	cur.policy = FeedPolicy{rules: map[string]string{"overchan.*": "1"}}
+	if policy, reconnect := feedConfigChanged(old, cur); policy || reconnect {
+		t.Error("unchanged feed changed", policy, reconnect)
+	}
+	cur.policy = FeedPolicy{rules: map[string]string{"overchan.*": "1", "ctl": "1"}}
+	if policy, reconnect := feedConfigChanged(old, cur); !policy || reconnect {
+		t.Error("policy change not seen alone", policy, reconnect)
+	}
+	cur.Addr = "other.tld:119"
+	if _, reconnect := feedConfigChanged(old, cur); !reconnect {
+		t.Error("address change does not reconnect")
+	}
+
+}
+
+func TestPostingConfigReload(t *testing.T) {
+
+	daemon := new(NNTPDaemon)
+	daemon.posting = postingConfigFrom(map[string]string{"allow_anon": "1", "keep_rejected": "0"})
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			daemon.postingConfig()
+		}
+		done <- true
+	}()
+	daemon.posting_mtx.Lock()
+	daemon.posting = postingConfigFrom(map[string]string{"allow_anon": "0", "keep_rejected": "1"})
+	daemon.posting_mtx.Unlock()
+	<-done
+	posting := daemon.postingConfig()
+	if posting.allow_anon || !posting.keep_rejected || posting.allow_attachments {
+		t.Error("wrong posting settings after reload", posting)
+	}
+
+}
+
+func TestFeedPolicyChange(t *testing.T) {
+
+	state := &feedState{Config: FeedConfig{Name: "peer", policy: FeedPolicy{rules: map[string]string{"overchan.*": "1"}}}}
+	conn := createNNTPConnection("peer.tld:119")
+	conn.policy = state.config().policy
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			state.config()
+			conn.allowsNewsgroup("overchan.test")
+		}
+		done <- true
+	}()
+	policy := FeedPolicy{rules: map[string]string{"overchan.*": "0"}}
+	state.setPolicy(policy)
+	conn.setPolicy(policy)
+	<-done
+	conf := state.config()
+	if conf.policy.AllowsNewsgroup("overchan.test") || conn.allowsNewsgroup("overchan.test") {
+		t.Error("policy not changed")
+	}
+
+}
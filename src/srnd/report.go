@@ -0,0 +1,100 @@
+//
+// report.go -- frontend post reporting
+//
+
+package srnd
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limits how often each address can send reports
+type reportLimiter struct {
+	access sync.Mutex
+	// address -> last time it reported
+	last     map[string]time.Time
+	interval time.Duration
+}
+
+func newReportLimiter(interval time.Duration) *reportLimiter {
+	return &reportLimiter{
+		last:     make(map[string]time.Time),
+		interval: interval,
+	}
+}
+
+// return true if addr may report now and remember that it did
+func (self *reportLimiter) Allow(addr string) (allow bool) {
+	now := time.Now()
+	self.access.Lock()
+	t, ok := self.last[addr]
+	allow = !ok || now.Sub(t) >= self.interval
+	if allow {
+		self.last[addr] = now
+	}
+	// forget addresses that can report again
+	for k, t := range self.last {
+		if now.Sub(t) >= self.interval {
+			delete(self.last, k)
+		}
+	}
+	self.access.Unlock()
+	return
+}
+
+// show the report form for a post and handle its submission
+func (self *httpFrontend) handle_report(wr http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	e, err := self.daemon.database.GetMessageIDByHash(hash)
+	if err != nil || !ValidMessageID(e.MessageID()) {
+		wr.WriteHeader(404)
+		template.writeTemplate("404.mustache", map[string]interface{}{"prefix": self.prefix, "i18n": i18nForRequest(r, "")}, wr)
+		return
+	}
+	msgid := e.MessageID()
+	param := map[string]interface{}{
+		"prefix":       self.prefix,
+		"message_id":   msgid,
+		"post_hash":    hash,
+		"redirect_url": self.prefix,
+	}
+	model := self.daemon.database.GetPostModel(self.prefix, msgid)
+	param["i18n"] = i18nForRequest(r, "")
+	if model != nil {
+		param["post"] = model
+		param["i18n"] = i18nForRequest(r, model.Board())
+		param["redirect_url"] = model.PostURL()
+	}
+	if r.Method != "POST" {
+		template.writeTemplate("report.mustache", param, wr)
+		return
+	}
+	reason := r.FormValue("reason")
+	if len(reason) == 0 {
+		param["reason"] = "no reason given"
+		template.writeTemplate("post_fail.mustache", param, wr)
+		return
+	}
+	addr, _ := extractRealIP(r)
+	if !self.reportLimit.Allow(addr) {
+		wr.WriteHeader(429)
+		param["reason"] = "you are reporting too fast, try again later"
+		template.writeTemplate("post_fail.mustache", param, wr)
+		return
+	}
+	log.Println("report on", msgid, "from", addr)
+	// reports from the web stay with our mods, anyone could flood every node's queue with them
+	err = self.daemon.mod.Report(msgid, reason)
+	if err != nil {
+		log.Println("failed to record report on", msgid, err)
+		param["reason"] = "failed to record report"
+		template.writeTemplate("post_fail.mustache", param, wr)
+		return
+	}
+	wr.WriteHeader(201)
+	template.writeTemplate("post_success.mustache", param, wr)
+}
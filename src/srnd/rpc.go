@@ -0,0 +1,852 @@
+//
+// rpc.go -- running the web frontend as its own process, talking to srnd over the api socket
+//
+// the socket carries posts the frontend made for srnd to federate, new posts and deletes for
+// the frontend to render, and what only srnd knows like how its feeds are doing
+// the frontend can share srnd's database and articles directory, or keep its own on another host
+// and fetch the articles it is missing from srnd with the article, newsgroups and articles queries
+//
+// every message is a line of json, requests have an id that comes back on the reply,
+// events srnd sends to subscribed frontends have none but are numbered so a frontend
+// that reconnects gets the ones it missed
+//
+// plain connections are only taken over unix sockets and loopback, anything else needs tls
+//
+
+package srnd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// how long a frontend waits for srnd to answer
+const rpcTimeout = time.Second * 30
+
+// how many events wait to be sent to a frontend before we drop it for being too slow
+// also how many srnd keeps for frontends that reconnect
+const rpcQueueSize = 1024
+
+var RPCNotConnected = errors.New("not connected to srnd")
+var RPCBadSecret = errors.New("bad api secret")
+var RPCNotLocal = errors.New("plain api connections only go over unix sockets and loopback, set tls in [api]")
+var FeedsOnSrnd = errors.New("feeds are managed by srnd, not by a split frontend")
+
+// a request, a reply or an event
+type rpcMessage struct {
+	Method string          `json:"method,omitempty"`
+	ID     int64           `json:"id,omitempty"`
+	Seq    int64           `json:"seq,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// params of auth
+type rpcAuth struct {
+	Secret string `json:"secret"`
+}
+
+// params of submit, article, articles and of the post and delete events
+type rpcArticle struct {
+	MessageID string `json:"message_id"`
+	// root post for delete, what it replies to for post
+	Reference string `json:"reference,omitempty"`
+	Newsgroup string `json:"newsgroup"`
+	// the article for submit, so srnd does not need to see our articles directory
+	Article []byte `json:"article,omitempty"`
+}
+
+// params of subscribe, the last event the frontend got
+type rpcSubscribe struct {
+	Epoch string `json:"epoch"`
+	Seq   int64  `json:"seq"`
+}
+
+// result of subscribe
+type rpcSubscribed struct {
+	// changes every time srnd starts, event numbers from another epoch mean nothing
+	Epoch string `json:"epoch"`
+	Seq   int64  `json:"seq"`
+	// true if events since the one the frontend asked for are gone
+	Missed bool `json:"missed"`
+}
+
+// the last events srnd sent, so a frontend that reconnects gets what it missed
+type rpcBacklog struct {
+	seq    int64
+	events []rpcMessage
+}
+
+// number an event and keep it, the oldest one goes if we have too many
+func (self *rpcBacklog) Add(msg rpcMessage) rpcMessage {
+	self.seq++
+	msg.Seq = self.seq
+	self.events = append(self.events, msg)
+	if len(self.events) > rpcQueueSize {
+		self.events = append([]rpcMessage(nil), self.events[len(self.events)-rpcQueueSize:]...)
+	}
+	return msg
+}
+
+// the events after seq, ok is false if some of them are gone already
+func (self *rpcBacklog) Since(seq int64) (events []rpcMessage, ok bool) {
+	if seq > self.seq {
+		return
+	}
+	for _, msg := range self.events {
+		if msg.Seq > seq {
+			events = append(events, msg)
+		}
+	}
+	ok = seq+int64(len(events)) == self.seq
+	return
+}
+
+// can a plain connection to or from addr leave this machine?
+func rpcLocalAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.UnixAddr:
+		return true
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	}
+	return false
+}
+
+// tls for srnd's end of the api socket, nil if it is plain
+func (self *APIConfig) serverTLS() (*tls.Config, error) {
+	if !self.tls {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(self.tlsCert, self.tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// tls for a frontend's end of the api socket, nil if it is plain
+// srnd's certificate is checked against tls_ca or the system's roots
+func (self *APIConfig) clientTLS() (*tls.Config, error) {
+	if !self.tls {
+		return nil, nil
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if self.tlsCA != "" {
+		data, err := ioutil.ReadFile(self.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates in " + self.tlsCA)
+		}
+	}
+	host, _, err := net.SplitHostPort(self.srndAddr)
+	if err != nil {
+		return nil, err
+	}
+	conf.ServerName = host
+	return conf, nil
+}
+
+// a frontend connected to srnd
+type rpcConn struct {
+	conn   net.Conn
+	access sync.Mutex
+	enc    *json.Encoder
+	// events waiting to be sent, nil until it subscribes
+	queue chan rpcMessage
+}
+
+func (self *rpcConn) send(msg rpcMessage) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.conn.SetWriteDeadline(time.Now().Add(rpcTimeout))
+	return self.enc.Encode(msg)
+}
+
+func (self *rpcConn) reply(id int64, result interface{}, err error) error {
+	msg := rpcMessage{ID: id}
+	if err == nil {
+		msg.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return self.send(msg)
+}
+
+// queue an event, false if the frontend has too many waiting already
+func (self *rpcConn) push(msg rpcMessage) bool {
+	select {
+	case self.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// send queued events until the queue is closed
+func (self *rpcConn) writeLoop(queue chan rpcMessage) {
+	for msg := range queue {
+		if self.send(msg) != nil {
+			self.conn.Close()
+		}
+	}
+}
+
+// srnd's end, serves frontends on [api] srnd
+type rpcServer struct {
+	daemon   *NNTPDaemon
+	secret   string
+	listener net.Listener
+	access   sync.Mutex
+	subs     map[*rpcConn]bool
+	epoch    string
+	backlog  rpcBacklog
+}
+
+func newRPCServer(daemon *NNTPDaemon, conf *APIConfig) (*rpcServer, error) {
+	tlsConf, err := conf.serverTLS()
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenBind(conf.srndAddr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil {
+		l = tls.NewListener(l, tlsConf)
+	} else if !rpcLocalAddr(l.Addr()) {
+		l.Close()
+		return nil, RPCNotLocal
+	}
+	return &rpcServer{
+		daemon:   daemon,
+		secret:   conf.secret,
+		listener: l,
+		subs:     make(map[*rpcConn]bool),
+		epoch:    randStr(16),
+	}, nil
+}
+
+func (self *rpcServer) Serve() {
+	for {
+		c, err := self.listener.Accept()
+		if err != nil {
+			log.Println("api socket closed", err)
+			return
+		}
+		go self.handle(&rpcConn{conn: c, enc: json.NewEncoder(c)})
+	}
+}
+
+func (self *rpcServer) Close() {
+	self.listener.Close()
+}
+
+// queue an event for every subscribed frontend, never waits for one
+// frontends too far behind are dropped, they get what they missed when they reconnect
+func (self *rpcServer) broadcast(method string, params interface{}) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	msg := self.backlog.Add(rpcMessage{Method: method, Params: data})
+	for c := range self.subs {
+		if !c.push(msg) {
+			log.Println("frontend at", c.conn.RemoteAddr(), "is not keeping up, dropping it")
+			self.unsubscribe(c)
+			c.conn.Close()
+		}
+	}
+}
+
+// subscribe a frontend to events, with the ones it missed since seq of epoch if we still have them
+func (self *rpcServer) subscribe(c *rpcConn, sub rpcSubscribe) (result rpcSubscribed) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	result.Epoch = self.epoch
+	result.Seq = self.backlog.seq
+	if self.subs[c] {
+		return
+	}
+	c.queue = make(chan rpcMessage, rpcQueueSize)
+	go c.writeLoop(c.queue)
+	events, ok := self.backlog.Since(sub.Seq)
+	if sub.Epoch != self.epoch || !ok {
+		result.Missed = true
+		events = nil
+	}
+	for _, msg := range events {
+		c.push(msg)
+	}
+	self.subs[c] = true
+	return
+}
+
+// stop sending events to a frontend, access must be held
+func (self *rpcServer) unsubscribe(c *rpcConn) {
+	if self.subs[c] {
+		delete(self.subs, c)
+		close(c.queue)
+	}
+}
+
+// take an article a frontend made, if it is not in our articles directory it comes with it
+func (self *rpcServer) submit(a rpcArticle) (err error) {
+	if !ValidMessageID(a.MessageID) {
+		return errors.New("bad message-id")
+	}
+	store := self.daemon.store
+	if !store.HasArticle(a.MessageID) {
+		if len(a.Article) == 0 {
+			return errors.New("no such article " + a.MessageID)
+		}
+		err = storeArticle(store, a.MessageID, a.Article, func(r *bufio.Reader, f io.WriteCloser, hdr textproto.MIMEHeader) error {
+			return store.ProcessMessageBody(f, hdr, r, "")
+		})
+		if err == ArticleQuarantined {
+			// the mods let it in or not
+			return nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	go self.daemon.loadFromInfeed(a.MessageID)
+	return
+}
+
+func (self *rpcServer) handle(c *rpcConn) {
+	defer func() {
+		self.access.Lock()
+		self.unsubscribe(c)
+		self.access.Unlock()
+		c.conn.Close()
+	}()
+	dec := json.NewDecoder(bufio.NewReader(c.conn))
+	authed := false
+	db := self.daemon.database
+	for {
+		var msg rpcMessage
+		if dec.Decode(&msg) != nil {
+			return
+		}
+		if !authed {
+			var auth rpcAuth
+			json.Unmarshal(msg.Params, &auth)
+			if msg.Method != "auth" || self.secret == "" || subtle.ConstantTimeCompare([]byte(auth.Secret), []byte(self.secret)) != 1 {
+				log.Println("frontend from", c.conn.RemoteAddr(), "failed to authenticate")
+				c.reply(msg.ID, nil, RPCBadSecret)
+				return
+			}
+			authed = true
+			c.reply(msg.ID, Version(), nil)
+			continue
+		}
+		var result interface{}
+		var err error
+		var a rpcArticle
+		switch msg.Method {
+		case "ping":
+			result = "pong"
+		case "subscribe":
+			var sub rpcSubscribe
+			json.Unmarshal(msg.Params, &sub)
+			result = self.subscribe(c, sub)
+		case "submit":
+			json.Unmarshal(msg.Params, &a)
+			err = self.submit(a)
+			result = "okay"
+		case "article":
+			// an article we have for a frontend with its own articles directory
+			json.Unmarshal(msg.Params, &a)
+			if ValidMessageID(a.MessageID) && db.HasArticleLocal(a.MessageID) {
+				result, err = readArticle(self.daemon.store, a.MessageID)
+			} else {
+				err = errors.New("no such article " + a.MessageID)
+			}
+		case "newsgroups":
+			// how many articles each newsgroup has, frontends catching up compare them to theirs
+			counts := make(map[string]int64)
+			for _, group := range db.GetAllNewsgroups() {
+				counts[group], _ = db.CountAllArticlesInGroup(group)
+			}
+			result = counts
+		case "articles":
+			json.Unmarshal(msg.Params, &a)
+			result = articlesInGroup(db, a.Newsgroup)
+		case "feeds":
+			result = self.daemon.feedHealth()
+		case "sync":
+			go self.daemon.syncAllMessages()
+			result = "sync started"
+		default:
+			err = errors.New("no such method " + msg.Method)
+		}
+		if c.reply(msg.ID, result, err) != nil {
+			return
+		}
+	}
+}
+
+// the message-ids of every article in a newsgroup
+func articlesInGroup(db Database, group string) (msgids []string) {
+	msgids = []string{}
+	chnl := make(chan ArticleEntry)
+	go func() {
+		db.GetAllArticlesInGroup(group, chnl)
+		close(chnl)
+	}()
+	for e := range chnl {
+		msgids = append(msgids, e.MessageID())
+	}
+	return
+}
+
+// an article as it goes over the wire, uncompressed
+func readArticle(store ArticleStore, msgid string) ([]byte, error) {
+	r, err := store.OpenMessage(msgid)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// put an article into the articles directory, process writes its body and registers it
+// nothing is left behind if it fails
+func storeArticle(store ArticleStore, msgid string, data []byte, process func(r *bufio.Reader, f io.WriteCloser, hdr textproto.MIMEHeader) error) (err error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	hdr, err := readMIMEHeader(r)
+	if err != nil {
+		return
+	}
+	if getMessageIDFromArticleHeaders(ArticleHeaders(hdr)) != msgid {
+		return errors.New("article is not " + msgid)
+	}
+	f := store.CreateFile(msgid)
+	if f == nil {
+		return errors.New("cannot store " + msgid)
+	}
+	err = writeMIMEHeader(f, hdr)
+	if err == nil {
+		err = process(r, f, hdr)
+	}
+	f.Close()
+	if err != nil && err != ArticleQuarantined {
+		DelFile(store.GetFilename(msgid))
+	}
+	return
+}
+
+// a split frontend's end, keeps a connection to srnd up
+type rpcClient struct {
+	addr    string
+	secret  string
+	tls     *tls.Config
+	events  func(rpcMessage)
+	access  sync.Mutex
+	conn    net.Conn
+	enc     *json.Encoder
+	lastID  int64
+	pending map[int64]chan rpcMessage
+	// the last event we got and the epoch of srnd it is from
+	epoch string
+	seq   int64
+	// events waiting for the events func, so it can call srnd itself
+	queued []rpcMessage
+	wake   chan bool
+}
+
+func newRPCClient(conf *APIConfig, events func(rpcMessage)) (*rpcClient, error) {
+	tlsConf, err := conf.clientTLS()
+	if err != nil {
+		return nil, err
+	}
+	self := &rpcClient{
+		addr:    conf.srndAddr,
+		secret:  conf.secret,
+		tls:     tlsConf,
+		events:  events,
+		pending: make(map[int64]chan rpcMessage),
+		wake:    make(chan bool, 1),
+	}
+	if events != nil {
+		go self.eventLoop()
+	}
+	return self, nil
+}
+
+// connect to srnd, with tls if we have it and only over unix sockets and loopback if not
+func (self *rpcClient) dial() (net.Conn, error) {
+	conn, err := dialBind(self.addr)
+	if err != nil {
+		return nil, err
+	}
+	if self.tls != nil {
+		tc := tls.Client(conn, self.tls)
+		tc.SetDeadline(time.Now().Add(rpcTimeout))
+		err = tc.Handshake()
+		tc.SetDeadline(time.Time{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+	if !rpcLocalAddr(conn.RemoteAddr()) {
+		conn.Close()
+		return nil, RPCNotLocal
+	}
+	return conn, nil
+}
+
+// connect to srnd and stay connected
+func (self *rpcClient) Run() {
+	backoff := time.Second
+	for {
+		conn, err := self.dial()
+		if err == nil {
+			done := make(chan bool)
+			self.access.Lock()
+			self.conn = conn
+			self.enc = json.NewEncoder(conn)
+			self.access.Unlock()
+			go func() {
+				self.readLoop(conn)
+				done <- true
+			}()
+			var version string
+			err = self.Call("auth", rpcAuth{Secret: self.secret}, &version)
+			if err == nil {
+				err = self.subscribe()
+			}
+			if err == nil {
+				log.Println("connected to srnd", version, "at", self.addr)
+				backoff = time.Second
+			}
+			if err != nil {
+				conn.Close()
+			}
+			<-done
+			self.access.Lock()
+			self.conn = nil
+			self.access.Unlock()
+			if err == nil {
+				err = errors.New("connection lost")
+			}
+		}
+		log.Println("api socket to srnd at", self.addr, err, "reconnecting in", backoff)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// subscribe to events from the last one we got
+// if srnd can't give us all we missed we queue a resync for the events func
+func (self *rpcClient) subscribe() (err error) {
+	self.access.Lock()
+	sub := rpcSubscribe{Epoch: self.epoch, Seq: self.seq}
+	self.access.Unlock()
+	var result rpcSubscribed
+	err = self.Call("subscribe", sub, &result)
+	if err != nil {
+		return
+	}
+	self.access.Lock()
+	if result.Missed {
+		self.epoch = result.Epoch
+		if self.seq < result.Seq || sub.Epoch != result.Epoch {
+			self.seq = result.Seq
+		}
+	}
+	self.access.Unlock()
+	if result.Missed {
+		self.queue(rpcMessage{Method: "resync"})
+	}
+	return
+}
+
+// connect to srnd once and authenticate, for tools that ask something and go away
+func (self *rpcClient) Dial() (version string, err error) {
+	var conn net.Conn
+	conn, err = self.dial()
+	if err != nil {
+		return
+	}
+	self.access.Lock()
+	self.conn = conn
+	self.enc = json.NewEncoder(conn)
+	self.access.Unlock()
+	go self.readLoop(conn)
+	err = self.Call("auth", rpcAuth{Secret: self.secret}, &version)
+	if err != nil {
+		self.Close()
+	}
+	return
+}
+
+// drop the connection Dial made
+func (self *rpcClient) Close() {
+	self.access.Lock()
+	if self.conn != nil {
+		self.conn.Close()
+		self.conn = nil
+	}
+	self.access.Unlock()
+}
+
+// hand an event to the events func
+func (self *rpcClient) queue(msg rpcMessage) {
+	if self.events == nil {
+		return
+	}
+	self.access.Lock()
+	self.queued = append(self.queued, msg)
+	self.access.Unlock()
+	select {
+	case self.wake <- true:
+	default:
+	}
+}
+
+// run the events func on events in order, away from the read loop so it can call srnd
+func (self *rpcClient) eventLoop() {
+	for range self.wake {
+		self.access.Lock()
+		events := self.queued
+		self.queued = nil
+		self.access.Unlock()
+		for _, msg := range events {
+			self.events(msg)
+		}
+	}
+}
+
+func (self *rpcClient) readLoop(conn net.Conn) {
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg rpcMessage
+		if dec.Decode(&msg) != nil {
+			break
+		}
+		if msg.ID == 0 {
+			self.access.Lock()
+			if msg.Seq > self.seq {
+				self.seq = msg.Seq
+			}
+			self.access.Unlock()
+			self.queue(msg)
+			continue
+		}
+		self.access.Lock()
+		chnl, ok := self.pending[msg.ID]
+		delete(self.pending, msg.ID)
+		self.access.Unlock()
+		if ok {
+			chnl <- msg
+		}
+	}
+	conn.Close()
+	// nothing is coming back for what is still waiting
+	self.access.Lock()
+	for id, chnl := range self.pending {
+		chnl <- rpcMessage{ID: id, Error: RPCNotConnected.Error()}
+		delete(self.pending, id)
+	}
+	self.access.Unlock()
+}
+
+// ask srnd something, result is decoded into if not nil
+func (self *rpcClient) Call(method string, params, result interface{}) error {
+	var msg rpcMessage
+	var err error
+	msg.Method = method
+	if params != nil {
+		msg.Params, err = json.Marshal(params)
+		if err != nil {
+			return err
+		}
+	}
+	chnl := make(chan rpcMessage, 1)
+	self.access.Lock()
+	if self.conn == nil {
+		self.access.Unlock()
+		return RPCNotConnected
+	}
+	self.lastID++
+	msg.ID = self.lastID
+	self.pending[msg.ID] = chnl
+	self.conn.SetWriteDeadline(time.Now().Add(rpcTimeout))
+	err = self.enc.Encode(msg)
+	if err != nil {
+		delete(self.pending, msg.ID)
+	}
+	self.access.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case reply := <-chnl:
+		if reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+		if result != nil {
+			return json.Unmarshal(reply.Result, result)
+		}
+		return nil
+	case <-time.After(rpcTimeout):
+		self.access.Lock()
+		delete(self.pending, msg.ID)
+		self.access.Unlock()
+		return errors.New("srnd did not answer " + method)
+	}
+}
+
+// get an article from srnd we don't have and register it like srnd did
+// srnd filtered it already so it goes in without our filters
+func (self *NNTPDaemon) fetchRemoteArticle(msgid string) (err error) {
+	var data []byte
+	err = self.remote.Call("article", rpcArticle{MessageID: msgid}, &data)
+	if err != nil {
+		return
+	}
+	return storeArticle(self.store, msgid, data, func(r *bufio.Reader, f io.WriteCloser, hdr textproto.MIMEHeader) error {
+		var regErr error
+		err := read_message_body(r, hdr, self.store, f, false, func(nntp NNTPMessage) {
+			regErr = self.database.RegisterArticle(nntp)
+			if pk := nntp.Pubkey(); regErr == nil && pk != "" {
+				regErr = self.database.RegisterSigned(msgid, pk)
+			}
+		})
+		if err == nil {
+			err = regErr
+		}
+		return err
+	})
+}
+
+// remove our copy of an article srnd deleted
+func (self *NNTPDaemon) dropRemoteArticle(msgid, root, group string) {
+	discardArticle(self.database, self.store, msgid, group)
+	if root == "" || root == msgid {
+		self.database.DeleteThread(msgid)
+	}
+	self.database.DeleteArticle(msgid)
+}
+
+// catch up on what srnd did while we missed its events
+// only newsgroups where we have a different number of articles than srnd are looked at
+func (self *NNTPDaemon) remoteResync() {
+	var counts map[string]int64
+	err := self.remote.Call("newsgroups", nil, &counts)
+	if err != nil {
+		log.Println("cannot catch up with srnd", err)
+		return
+	}
+	changed := false
+	for group, count := range counts {
+		if have, _ := self.database.CountAllArticlesInGroup(group); have == count {
+			continue
+		}
+		var msgids []string
+		err = self.remote.Call("articles", rpcArticle{Newsgroup: group}, &msgids)
+		if err != nil {
+			log.Println("cannot catch up on", group, "with srnd", err)
+			continue
+		}
+		log.Println("catching up on", group, "with srnd")
+		changed = true
+		srnd := make(map[string]bool)
+		for _, msgid := range msgids {
+			srnd[msgid] = true
+			if !self.database.HasArticleLocal(msgid) {
+				if err = self.fetchRemoteArticle(msgid); err != nil {
+					log.Println("cannot get", msgid, "from srnd", err)
+				}
+			}
+		}
+		for _, msgid := range articlesInGroup(self.database, group) {
+			if !srnd[msgid] {
+				root, _, _, _ := self.database.GetInfoForMessage(msgid)
+				self.dropRemoteArticle(msgid, root, group)
+			}
+		}
+	}
+	if changed && self.cache != nil {
+		self.cache.RegenAll()
+	}
+}
+
+// an event from srnd for our frontend
+// articles we don't have yet are fetched first when we keep our own articles directory
+func (self *NNTPDaemon) remoteEvent(msg rpcMessage) {
+	var a rpcArticle
+	json.Unmarshal(msg.Params, &a)
+	switch msg.Method {
+	case "post":
+		if !self.database.HasArticleLocal(a.MessageID) {
+			if err := self.fetchRemoteArticle(a.MessageID); err != nil {
+				log.Println("cannot get", a.MessageID, "from srnd", err)
+				return
+			}
+		}
+		if a.Newsgroup == "ctl" {
+			self.mod.MessageChan() <- a.MessageID
+		}
+		if self.frontend.AllowNewsgroup(a.Newsgroup) {
+			self.frontend.PostsChan() <- frontendPost{a.MessageID, a.Reference, a.Newsgroup}
+		}
+	case "delete":
+		if self.database.HasArticleLocal(a.MessageID) {
+			self.dropRemoteArticle(a.MessageID, a.Reference, a.Newsgroup)
+		}
+		self.articleDeleted(a.MessageID, a.Reference, a.Newsgroup)
+	case "reload":
+		self.ReloadTemplates()
+	case "resync":
+		// ours, queued when srnd can't give us all the events we missed
+		self.remoteResync()
+	}
+}
+
+// run only the web frontend, srnd runs somewhere else and we reach it over [api] srnd
+// with our own database and articles directory we fetch what we need from srnd
+func (self *NNTPDaemon) RunFrontend() {
+	if self.conf.api == nil || self.conf.api.srndAddr == "" {
+		log.Fatal("set srnd in the api section of srnd.ini to where srnd takes frontends")
+	}
+	if self.conf.frontend["enable"] != "1" {
+		log.Fatal("the frontend is not enabled in srnd.ini")
+	}
+	self.instance_name = self.conf.daemon["instance_name"]
+	var err error
+	self.remote, err = newRPCClient(self.conf.api, self.remoteEvent)
+	if err != nil {
+		log.Fatal("bad tls for the api socket ", err)
+	}
+	self.startFrontend()
+	go self.remote.Run()
+	self.running = true
+	<-self.done
+}
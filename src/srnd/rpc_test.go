@@ -0,0 +1,104 @@
+package srnd
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRPCBacklog(t *testing.T) {
+
+	var b rpcBacklog
+	for i := 0; i < 3; i++ {
+		b.Add(rpcMessage{Method: "post"})
+	}
+	events, ok := b.Since(1)
+	if !ok || len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+		t.Error("wrong events since 1", ok, events)
+	}
+	events, ok = b.Since(3)
+	if !ok || len(events) != 0 {
+		t.Error("events after the last one", ok, events)
+	}
+	_, ok = b.Since(4)
+	if ok {
+		t.Error("events from the future were found")
+	}
+	for i := 0; i < rpcQueueSize; i++ {
+		b.Add(rpcMessage{Method: "post"})
+	}
+	if len(b.events) != rpcQueueSize {
+		t.Error("backlog kept", len(b.events), "events")
+	}
+	_, ok = b.Since(1)
+	if ok {
+		t.Error("events that are gone were found")
+	}
+	events, ok = b.Since(b.seq - 10)
+	if !ok || len(events) != 10 {
+		t.Error("recent events were not found", ok, len(events))
+	}
+
+}
+
+func testRPCServer() *rpcServer {
+	return &rpcServer{subs: make(map[*rpcConn]bool), epoch: "test"}
+}
+
+func TestRPCReplay(t *testing.T) {
+
+	srv := testRPCServer()
+	srv.broadcast("post", rpcArticle{MessageID: "<1@test>"})
+	srv.broadcast("post", rpcArticle{MessageID: "<2@test>"})
+	ours, theirs := net.Pipe()
+	defer theirs.Close()
+	c := &rpcConn{conn: ours, enc: json.NewEncoder(ours)}
+	result := srv.subscribe(c, rpcSubscribe{Epoch: "test", Seq: 1})
+	if result.Missed || result.Seq != 2 {
+		t.Error("bad subscribe result", result)
+	}
+	theirs.SetReadDeadline(time.Now().Add(time.Second))
+	var msg rpcMessage
+	err := json.NewDecoder(theirs).Decode(&msg)
+	if err != nil {
+		t.Error(err)
+	}
+	var a rpcArticle
+	json.Unmarshal(msg.Params, &a)
+	if msg.Seq != 2 || a.MessageID != "<2@test>" {
+		t.Error("wrong event replayed", msg.Seq, a.MessageID)
+	}
+	c2 := &rpcConn{conn: ours, enc: json.NewEncoder(ours)}
+	result = srv.subscribe(c2, rpcSubscribe{Epoch: "before", Seq: 1})
+	if !result.Missed {
+		t.Error("events from another epoch were replayed")
+	}
+
+}
+
+func TestRPCSlowFrontend(t *testing.T) {
+
+	srv := testRPCServer()
+	// nobody reads the other end
+	ours, theirs := net.Pipe()
+	defer theirs.Close()
+	c := &rpcConn{conn: ours, enc: json.NewEncoder(ours)}
+	srv.subscribe(c, rpcSubscribe{})
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < rpcQueueSize+2; i++ {
+			srv.broadcast("post", rpcArticle{MessageID: "<slow@test>"})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("broadcast waited for a stuck frontend")
+	}
+	if len(srv.subs) != 0 {
+		t.Error("stuck frontend was not dropped")
+	}
+
+}
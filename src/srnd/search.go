@@ -0,0 +1,160 @@
+//
+// search.go -- full text search of post bodies from the frontend
+//
+
+package srnd
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// search results per page
+const searchPageSize = 20
+
+// how much of a post we show around the first match
+const searchSnippetSize = 200
+
+// a post found by a search
+type searchResult struct {
+	Post PostModel
+	// escaped html with the match in <mark>
+	Snippet string
+}
+
+// a piece of message around the first match of text with the match highlighted
+// returned as escaped html
+func searchSnippet(message, text string, size int) string {
+	lower, lowertext := strings.ToLower(message), strings.ToLower(text)
+	idx := -1
+	// lowercasing must not move the match around
+	if len(text) > 0 && len(lower) == len(message) && len(lowertext) == len(text) {
+		idx = strings.Index(lower, lowertext)
+	}
+	if idx < 0 {
+		if len(message) > size {
+			message = truncateUTF8(message, size) + "..."
+		}
+		return html.EscapeString(message)
+	}
+	end := idx + len(text)
+	start := idx - (size-len(text))/2
+	if start < 0 {
+		start = 0
+	}
+	// don't cut runes in half
+	for start > 0 && !utf8.RuneStart(message[start]) {
+		start--
+	}
+	stop := start + size
+	if stop < end {
+		stop = end
+	}
+	if stop > len(message) {
+		stop = len(message)
+	}
+	for stop < len(message) && !utf8.RuneStart(message[stop]) {
+		stop++
+	}
+	snippet := html.EscapeString(message[start:idx]) + "<mark>" + html.EscapeString(message[idx:end]) + "</mark>" + html.EscapeString(message[end:stop])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if stop < len(message) {
+		snippet += "..."
+	}
+	return snippet
+}
+
+// cut str to at most size bytes without cutting a rune in half
+func truncateUTF8(str string, size int) string {
+	if len(str) <= size {
+		return str
+	}
+	for size > 0 && !utf8.RuneStart(str[size]) {
+		size--
+	}
+	return str[:size]
+}
+
+// parse a yyyy-mm-dd date from a search form, 0 if empty or bad
+func parseSearchDate(str string) int64 {
+	t, err := time.Parse("2006-01-02", str)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// GET /search?q=text&group=overchan.x&after=yyyy-mm-dd&before=yyyy-mm-dd&page=n
+func (self *httpFrontend) handle_search(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	text := strings.TrimSpace(q.Get("q"))
+	group := q.Get("group")
+	after := q.Get("after")
+	before := q.Get("before")
+	page, err := strconv.Atoi(q.Get("page"))
+	if err != nil || page < 0 {
+		page = 0
+	}
+	tr := i18nForRequest(r, group)
+	param := map[string]interface{}{
+		"prefix": self.prefix,
+		"query":  text,
+		"group":  group,
+		"after":  after,
+		"before": before,
+		"page":   page,
+		"i18n":   tr,
+		"navbar": template.renderTemplate("navbar.mustache", map[string]interface{}{
+			"name":     "Search",
+			"frontend": self.name,
+			"prefix":   self.prefix,
+			"i18n":     tr,
+		}),
+	}
+	if group != "" && (!self.AllowNewsgroup(group) || group == "ctl") {
+		wr.WriteHeader(400)
+		param["error"] = "no such board"
+		template.writeTemplate("search.mustache", param, wr)
+		return
+	}
+	if len(text) > 0 {
+		sp := SearchParams{
+			Text:      text,
+			Newsgroup: group,
+			After:     parseSearchDate(after),
+			Before:    parseSearchDate(before),
+			Offset:    page * searchPageSize,
+			Limit:     searchPageSize,
+		}
+		if sp.Before > 0 {
+			// include the whole day
+			sp.Before += 24 * 3600
+		}
+		posts, total, err := self.daemon.database.SearchPosts(self.prefix, sp)
+		if err != nil {
+			wr.WriteHeader(500)
+			param["error"] = err.Error()
+			template.writeTemplate("search.mustache", param, wr)
+			return
+		}
+		var results []searchResult
+		for _, p := range posts {
+			results = append(results, searchResult{Post: p, Snippet: searchSnippet(p.RenderBodyPre(), text, searchSnippetSize)})
+		}
+		param["results"] = results
+		param["total"] = total
+		if page > 0 {
+			param["prev"] = map[string]interface{}{"no": page - 1}
+		}
+		if int64(sp.Offset+len(posts)) < total {
+			param["next"] = map[string]interface{}{"no": page + 1}
+		}
+	}
+	template.writeTemplate("search.mustache", param, wr)
+}
@@ -0,0 +1,18 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestSearchSnippet(t *testing.T) {
+
+	s := searchSnippet("hello <b>World</b> bye", "world", 200)
+	if s != "hello &lt;b&gt;<mark>World</mark>&lt;/b&gt; bye" {
+		t.Error("bad snippet", s)
+	}
+	s = searchSnippet("aaaaaaaaaa needle bbbbbbbbbb", "needle", 10)
+	if s != "...a <mark>needle</mark> b..." {
+		t.Error("bad cut snippet", s)
+	}
+
+}
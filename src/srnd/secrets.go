@@ -0,0 +1,106 @@
+//
+// secrets.go -- reading secret options from files, the environment or a command instead of the ini files
+//
+
+package srnd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majestrate/configparser"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// what a secret option ends with to say where to read it from instead, password_file for password
+const secretFileSuffix = "_file"
+
+// read a secret from a reference, surrounding whitespace and the trailing newline are dropped
+// env:NAME reads an environment variable, exec:command args reads what the command prints,
+// so it can come from a secret store's cli, file:path or a plain path reads a file
+func readSecret(ref string) (secret string, err error) {
+	var data []byte
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := ref[4:]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New(name + " is not set")
+		}
+		data = []byte(v)
+	case strings.HasPrefix(ref, "exec:"):
+		args := strings.Fields(ref[5:])
+		if len(args) == 0 {
+			return "", errors.New("no command in " + ref)
+		}
+		data, err = exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", args[0], err)
+		}
+	default:
+		data, err = ioutil.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return
+		}
+	}
+	secret = strings.TrimSpace(string(data))
+	if secret == "" {
+		err = errors.New(ref + " is empty")
+	}
+	return
+}
+
+// read every secret a section has a _file option for, key -> secret
+// only options confSecret says are secret, hostname_file and the like are left alone
+// a secret set both inline and as a _file is an error so it's clear which one is used,
+// unless only one of them is from the environment or command line, env are the options those set
+func readSecretOptions(section string, opts, env map[string]string) (secrets map[string]string, err error) {
+	secrets = make(map[string]string)
+	var keys []string
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		base := strings.TrimSuffix(key, secretFileSuffix)
+		if base == key || base == "" || !confSecret(base) {
+			continue
+		}
+		if _, ok := opts[base]; ok {
+			_, baseEnv := env[base]
+			_, fileEnv := env[key]
+			if baseEnv && !fileEnv {
+				continue
+			} else if baseEnv == fileEnv {
+				return nil, fmt.Errorf("%s and %s are both set in [%s], set one", base, key, section)
+			}
+		}
+		secrets[base], err = readSecret(opts[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s in [%s]: %s", key, section, err)
+		}
+	}
+	return
+}
+
+// set the secrets the sections of srnd.ini have _file options for, after the environment and command line are applied
+func readConfigSecrets(conf *configparser.Configuration, environ []string) error {
+	for _, section := range configSections {
+		s, err := conf.Section(section)
+		if err != nil {
+			continue
+		}
+		opts := s.Options()
+		secrets, err := readSecretOptions(section, opts, configOverrides(section, opts, environ))
+		if err != nil {
+			return err
+		}
+		for key, secret := range secrets {
+			s.Add(key, secret)
+		}
+	}
+	return nil
+}
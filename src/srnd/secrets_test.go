@@ -0,0 +1,37 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSecretOptions(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	fname := filepath.Join(dir, "db")
+	ioutil.WriteFile(fname, []byte("hunter2\n"), 0600)
+	opts := map[string]string{"password_file": fname, "hostname_file": "onion.hostname"}
+	secrets, err := readSecretOptions("database", opts, nil)
+	if err != nil || len(secrets) != 1 || secrets["password"] != "hunter2" {
+		t.Error("bad secrets", secrets, err)
+	}
+	opts["password"] = "inline"
+	if _, err = readSecretOptions("database", opts, nil); err == nil {
+		t.Error("password and password_file both set")
+	}
+	if secrets, err = readSecretOptions("database", opts, map[string]string{"password": "inline"}); err != nil || len(secrets) != 0 {
+		t.Error("password from the environment does not win", secrets, err)
+	}
+	os.Setenv("SRND_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("SRND_TEST_SECRET")
+	if secret, err := readSecret("env:SRND_TEST_SECRET"); err != nil || secret != "s3cret" {
+		t.Error("bad secret from the environment", secret, err)
+	}
+	if _, err = readSecret(filepath.Join(dir, "missing")); err == nil {
+		t.Error("read a missing secret file")
+	}
+
+}
@@ -0,0 +1,82 @@
+//
+// shadow.go -- posts of shadow banned posters that only they get to see
+//
+
+package srnd
+
+import (
+	"sync"
+	"time"
+)
+
+// how long we keep showing a shadow banned poster their posts
+const shadowPostLifetime = time.Hour * 24
+
+type shadowPost struct {
+	// message-id of the thread it is in
+	root   string
+	model  PostModel
+	posted time.Time
+}
+
+// posts of shadow banned posters, never stored or federated
+// kept in memory by the poster's ip so only they see them
+type shadowPosts struct {
+	access sync.Mutex
+	posts  map[string][]shadowPost
+}
+
+func newShadowPosts() *shadowPosts {
+	return &shadowPosts{
+		posts: make(map[string][]shadowPost),
+	}
+}
+
+// drop posts that are too old, must hold the lock
+func (self *shadowPosts) expire(now time.Time) {
+	for addr, posts := range self.posts {
+		idx := 0
+		for idx < len(posts) && now.Sub(posts[idx].posted) > shadowPostLifetime {
+			idx++
+		}
+		if idx == len(posts) {
+			delete(self.posts, addr)
+		} else {
+			self.posts[addr] = posts[idx:]
+		}
+	}
+}
+
+// remember a post from addr in the thread root
+func (self *shadowPosts) Add(addr, root string, model PostModel) {
+	now := time.Now()
+	self.access.Lock()
+	self.expire(now)
+	self.posts[addr] = append(self.posts[addr], shadowPost{
+		root:   root,
+		model:  model,
+		posted: now,
+	})
+	self.access.Unlock()
+}
+
+// return true if nobody has shadow posts
+func (self *shadowPosts) Empty() bool {
+	self.access.Lock()
+	empty := len(self.posts) == 0
+	self.access.Unlock()
+	return empty
+}
+
+// get the message-id of the thread with this hash and the posts addr made in it
+func (self *shadowPosts) Thread(addr, roothash string) (root string, posts []PostModel) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, p := range self.posts[addr] {
+		if HashMessageID(p.root) == roothash && time.Since(p.posted) <= shadowPostLifetime {
+			root = p.root
+			posts = append(posts, p.model)
+		}
+	}
+	return
+}
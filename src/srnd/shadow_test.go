@@ -0,0 +1,23 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestShadowPosts(t *testing.T) {
+
+	s := newShadowPosts()
+	if !s.Empty() {
+		t.Error("new shadow posts not empty")
+	}
+	root := "<root@localhost>"
+	s.Add("1.2.3.4", root, &post{Message_id: "<reply@localhost>", Parent: root})
+	if _, posts := s.Thread("1.2.3.5", HashMessageID(root)); len(posts) != 0 {
+		t.Error("shadow post shown to someone else")
+	}
+	r, posts := s.Thread("1.2.3.4", HashMessageID(root))
+	if r != root || len(posts) != 1 {
+		t.Error("shadow post not shown to poster", r, len(posts))
+	}
+
+}
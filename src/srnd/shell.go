@@ -0,0 +1,412 @@
+//
+// shell.go -- an interactive prompt over the database and store for moderating in an emergency
+//
+
+package srnd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a command the shell knows
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(shell *adminShell, args []string) error
+}
+
+// the shell's state, talks to the database and store directly like the other tools
+type adminShell struct {
+	w      io.Writer
+	conf   *SRNdConfig
+	db     Database
+	store  ArticleStore
+	groups []string
+	// message-ids shown this session, for completion
+	seen map[string]bool
+}
+
+var shellCommands map[string]shellCommand
+
+// help lists shellCommands, so it can't be set up in the declaration
+func init() {
+	shellCommands = map[string]shellCommand{
+		"help":   {"help", "show this", (*adminShell).help},
+		"lookup": {"lookup message-id|hash", "show an article", (*adminShell).lookup},
+		"thread": {"thread message-id|hash", "show a thread with all its replies", (*adminShell).thread},
+		"group":  {"group newsgroup [threads]", "show the last bumped threads in a newsgroup", (*adminShell).group},
+		"delete": {"delete message-id|hash", "delete an article, or a thread with all its replies, and its files", (*adminShell).delete},
+		"ban":    {"ban " + strings.Join(banKinds, "|") + " value", "ban something", (*adminShell).ban},
+		"unban":  {"unban " + strings.Join(banKinds, "|") + " value", "lift a ban", (*adminShell).unban},
+		"bans":   {"bans [kind]", "list bans", (*adminShell).bans},
+		"feeds":  {"feeds", "show how the feeds of the running daemon are doing", (*adminShell).feeds},
+		"quit":   {"quit", "leave the shell", nil},
+	}
+}
+
+func (self *adminShell) help(args []string) error {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(self.w, "%-45s %s\n", shellCommands[name].usage, shellCommands[name].help)
+	}
+	return nil
+}
+
+// the message-id for a message-id or the hash of one
+func (self *adminShell) resolve(id string) (string, error) {
+	if ValidMessageID(id) {
+		return id, nil
+	}
+	var e ArticleEntry
+	var err error
+	if len(id) == 40 {
+		e, err = self.db.GetMessageIDByHash(id)
+	} else if len(id) >= 10 {
+		e, err = self.db.GetMessageIDByShortHash(id)
+	}
+	if err == nil && e.MessageID() == "" {
+		err = errors.New("no article " + id)
+	}
+	return e.MessageID(), err
+}
+
+// one line about an article
+func (self *adminShell) summary(msgid string) string {
+	self.seen[msgid] = true
+	hdr := self.store.GetHeaders(msgid)
+	if hdr == nil {
+		return msgid + " (not in store_dir)"
+	}
+	return fmt.Sprintf("%s %s %q by %q", msgid, hdr.Get("Date", ""), hdr.Get("Subject", ""), hdr.Get("From", ""))
+}
+
+func (self *adminShell) lookup(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + shellCommands["lookup"].usage)
+	}
+	msgid, err := self.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(self.w, self.summary(msgid))
+	if !self.db.HasArticle(msgid) {
+		if self.db.ArticleBanned(msgid) {
+			fmt.Fprintln(self.w, "banned")
+		} else {
+			fmt.Fprintln(self.w, "not in the database")
+		}
+		return nil
+	}
+	root, group, page, err := self.db.GetInfoForMessage(msgid)
+	if err != nil {
+		return err
+	}
+	self.seen[root] = true
+	fmt.Fprintln(self.w, "newsgroup", group, "page", page)
+	if root == msgid {
+		fmt.Fprintln(self.w, "root of a thread with", self.db.CountThreadReplies(root), "replies")
+	} else {
+		fmt.Fprintln(self.w, "reply to", root)
+	}
+	if hdr := self.store.GetHeaders(msgid); hdr != nil {
+		if pk := hdr.Get("X-Pubkey-Ed25519", ""); pk != "" {
+			fmt.Fprintln(self.w, "signed by", pk)
+		}
+		if addr := hdr.Get("X-Encrypted-Ip", ""); addr != "" {
+			fmt.Fprintln(self.w, "encrypted address", addr)
+		}
+	}
+	for _, att := range self.db.GetPostAttachments(msgid) {
+		fmt.Fprintln(self.w, "attachment", att)
+	}
+	return nil
+}
+
+func (self *adminShell) thread(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + shellCommands["thread"].usage)
+	}
+	msgid, err := self.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	root, _, _, err := self.db.GetInfoForMessage(msgid)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(self.w, self.summary(root))
+	for _, reply := range self.db.GetThreadReplies(root, 0, 0) {
+		fmt.Fprintln(self.w, "  "+self.summary(reply))
+	}
+	return nil
+}
+
+func (self *adminShell) group(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: " + shellCommands["group"].usage)
+	}
+	n := 10
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errors.New("bad number of threads " + args[1])
+		}
+	}
+	if !self.db.HasNewsgroup(args[0]) {
+		return errors.New("no newsgroup " + args[0])
+	}
+	for _, e := range self.db.GetLastBumpedThreads(args[0], n) {
+		fmt.Fprintf(self.w, "%s, %d replies\n", self.summary(e.MessageID()), self.db.CountThreadReplies(e.MessageID()))
+	}
+	return nil
+}
+
+func (self *adminShell) delete(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + shellCommands["delete"].usage)
+	}
+	msgid, err := self.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	core := expire{database: self.db, store: self.store}
+	root, _, _, err := self.db.GetInfoForMessage(msgid)
+	if err == nil && root == msgid {
+		replies := self.db.GetThreadReplies(root, 0, 0)
+		for _, reply := range replies {
+			core.remove(deleteEvent(self.store.GetFilename(reply)))
+		}
+		core.remove(deleteEvent(self.store.GetFilename(root)))
+		err = self.db.DeleteThread(root)
+		if err == nil {
+			fmt.Fprintln(self.w, "deleted thread", root, "with", len(replies), "replies")
+		}
+		return err
+	}
+	core.remove(deleteEvent(self.store.GetFilename(msgid)))
+	fmt.Fprintln(self.w, "deleted", msgid)
+	return nil
+}
+
+func (self *adminShell) ban(args []string) error {
+	return self.banOrUnban("ban", args)
+}
+
+func (self *adminShell) unban(args []string) error {
+	return self.banOrUnban("unban", args)
+}
+
+func (self *adminShell) banOrUnban(cmd string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: " + shellCommands[cmd].usage)
+	}
+	value, err := banValue(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if cmd == "ban" {
+		err = banAdd(self.db, args[0], value)
+	} else {
+		err = banRemove(self.db, args[0], value)
+	}
+	if err == nil {
+		fmt.Fprintln(self.w, cmd, args[0], value)
+	}
+	return err
+}
+
+func (self *adminShell) bans(args []string) error {
+	if len(args) > 1 || (len(args) == 1 && !validBanKind(args[0])) {
+		return errors.New("usage: " + shellCommands["bans"].usage)
+	}
+	kind := ""
+	if len(args) == 1 {
+		kind = args[0]
+	}
+	return banList(self.w, self.db, kind)
+}
+
+// ask the running daemon over the api socket, only it knows how its feeds are doing
+func (self *adminShell) feeds(args []string) error {
+	if self.conf.api == nil || self.conf.api.srndAddr == "" {
+		return errors.New("set srnd and secret in the api section of srnd.ini to see feeds")
+	}
+	client, err := newRPCClient(self.conf.api, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	var reports []feedHealth
+	err = client.Call("feeds", nil, &reports)
+	for _, h := range reports {
+		state := "down"
+		if h.Paused {
+			state = "paused"
+		} else if h.Connected > 0 {
+			state = fmt.Sprintf("%d connections", h.Connected)
+		}
+		fmt.Fprintf(self.w, "%s %s %s, backlog %d, sent %d received %d\n", h.Name, h.Addr, state, h.Backlog, h.ArticlesSent, h.ArticlesReceived)
+		if h.LastError != "" {
+			fmt.Fprintf(self.w, "  last error %s: %s\n", h.LastErrorTime.UTC().Format("2006-01-02 15:04:05"), h.LastError)
+		}
+	}
+	return err
+}
+
+// run one line, false once the shell should end
+func (self *adminShell) exec(line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return true
+	}
+	if args[0] == "quit" || args[0] == "exit" {
+		return false
+	}
+	cmd, ok := shellCommands[args[0]]
+	if !ok {
+		fmt.Fprintln(self.w, "no such command", args[0]+", try help")
+		return true
+	}
+	if err := cmd.run(self, args[1:]); err != nil {
+		fmt.Fprintln(self.w, err)
+	}
+	return true
+}
+
+// what the word after args can be
+func (self *adminShell) candidates(args []string) (words []string) {
+	if len(args) == 0 {
+		for name := range shellCommands {
+			words = append(words, name)
+		}
+		return
+	}
+	switch args[0] {
+	case "ban", "unban", "bans":
+		if len(args) == 1 {
+			return banKinds
+		} else if len(args) == 2 && args[1] == "newsgroup" {
+			return self.groups
+		}
+	case "group":
+		if len(args) == 1 {
+			return self.groups
+		}
+	case "lookup", "thread", "delete":
+		if len(args) == 1 {
+			for msgid := range self.seen {
+				words = append(words, msgid)
+			}
+		}
+	}
+	return
+}
+
+// complete the word that ends at pos from candidates, as far as the candidates that fit agree
+func shellComplete(line string, pos int, candidates func(args []string) []string) (string, int, bool) {
+	head := line[:pos]
+	args := strings.Fields(head)
+	word := ""
+	if len(args) > 0 && !strings.HasSuffix(head, " ") {
+		word = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+	var matches []string
+	for _, c := range candidates(args) {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(matches) == 1 && !strings.HasPrefix(line[pos:], " ") {
+		common += " "
+	}
+	if common == word {
+		return "", 0, false
+	}
+	head = head[:len(head)-len(word)] + common
+	return head + line[pos:], len(head), true
+}
+
+// an interactive shell, or with args run that one command
+// reads plain lines when stdin isn't a terminal so it can be scripted
+func ShellTool(w io.Writer, args []string) (err error) {
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	sh := &adminShell{
+		w:      w,
+		conf:   conf,
+		db:     db,
+		store:  createArticleStore(conf.store, db, nil, nil, nil, nil),
+		groups: db.GetAllNewsgroups(),
+		seen:   make(map[string]bool),
+	}
+	sort.Strings(sh.groups)
+	if len(args) > 0 {
+		sh.exec(strings.Join(args, " "))
+		return
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() && sh.exec(scanner.Text()) {
+		}
+		return scanner.Err()
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return
+	}
+	defer terminal.Restore(fd, state)
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, w}, "srnd> ")
+	term.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return shellComplete(line, pos, sh.candidates)
+	}
+	// the terminal turns newlines into what raw mode needs
+	sh.w = term
+	fmt.Fprintln(sh.w, "srnd", Version(), "shell, type help for commands")
+	for {
+		var line string
+		line, err = term.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil || !sh.exec(line) {
+			return
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestShellComplete(t *testing.T) {
+
+	candidates := func(args []string) []string {
+		if len(args) == 0 {
+			return []string{"ban", "bans", "lookup"}
+		}
+		return []string{"overchan.test", "overchan.tests"}
+	}
+	for _, c := range []struct {
+		line string
+		pos  int
+		want string
+		ok   bool
+	}{
+		{"lo", 2, "lookup ", true},
+		{"ba", 2, "ban", true},
+		{"ban", 3, "", false},
+		{"group ov", 8, "group overchan.test", true},
+		{"group ", 6, "group overchan.test", true},
+		{"x", 1, "", false},
+		{"lo foo", 2, "lookup foo", true},
+	} {
+		line, pos, ok := shellComplete(c.line, c.pos, candidates)
+		if ok != c.ok || line != c.want || (ok && pos > len(line)) {
+			t.Errorf("completing %q at %d gave %q %d %v", c.line, c.pos, line, pos, ok)
+		}
+	}
+
+}
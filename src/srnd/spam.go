@@ -0,0 +1,277 @@
+//
+// spam.go -- bayesian spam filter trained by mod actions
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// returned when a post scored as spam and was put into quarantine
+var ArticleQuarantined = errors.New("post is held for review by the mods")
+
+// how many tokens of a post we look at when scoring
+const spamInterestingTokens = 16
+
+// how often what the spam filter learned is written to disk
+const spamSaveInterval = time.Minute
+
+// on disk state of the spam filter
+type spamFilterState struct {
+	// token -> [times seen in spam, times seen in ham]
+	Tokens map[string][2]int64 `json:"tokens"`
+	Spam   int64               `json:"spam"`
+	Ham    int64               `json:"ham"`
+}
+
+type spamFilter struct {
+	access sync.Mutex
+	state  spamFilterState
+	// learned something since the last save
+	dirty bool
+	// file we keep our state in
+	fname string
+	// score at which posts are quarantined
+	threshold float64
+	// newsgroup -> threshold for that newsgroup
+	groups map[string]float64
+}
+
+func newSpamFilter(fname string, threshold float64, groups map[string]float64) *spamFilter {
+	return &spamFilter{
+		state: spamFilterState{
+			Tokens: make(map[string][2]int64),
+		},
+		fname:     fname,
+		threshold: threshold,
+		groups:    groups,
+	}
+}
+
+// load state from disk, a missing file is an empty filter
+func (self *spamFilter) Load() (err error) {
+	var f *os.File
+	f, err = os.Open(self.fname)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	defer f.Close()
+	var state spamFilterState
+	err = json.NewDecoder(f).Decode(&state)
+	if err == nil {
+		if state.Tokens == nil {
+			state.Tokens = make(map[string][2]int64)
+		}
+		self.access.Lock()
+		self.state = state
+		self.access.Unlock()
+	}
+	return
+}
+
+// save state to disk
+func (self *spamFilter) Save() (err error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	tmp := self.fname + ".tmp"
+	var f *os.File
+	f, err = os.Create(tmp)
+	if err != nil {
+		return
+	}
+	err = json.NewEncoder(f).Encode(&self.state)
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, self.fname)
+	}
+	if err == nil {
+		self.dirty = false
+	} else {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// get the text of a post that we classify
+func spamText(nntp NNTPMessage) string {
+	return nntp.Name() + " " + nntp.Subject() + " " + nntp.Message()
+}
+
+// split text into unique lower case tokens
+func spamTokens(text string) (tokens []string) {
+	seen := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		// keep urls in one piece
+		return !(unicode.IsLetter(r) || unicode.IsNumber(r) || strings.ContainsRune("$.:/-_'", r))
+	})
+	for _, word := range words {
+		word = strings.Trim(word, ".:/-_'")
+		if len(word) < 3 || len(word) > 64 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+	return
+}
+
+// learn from a post, spam is true if it was spam otherwise it was ham
+func (self *spamFilter) Train(text string, spam bool) {
+	if self == nil {
+		return
+	}
+	idx := 1
+	self.access.Lock()
+	self.dirty = true
+	if spam {
+		idx = 0
+		self.state.Spam++
+	} else {
+		self.state.Ham++
+	}
+	for _, tok := range spamTokens(text) {
+		counts := self.state.Tokens[tok]
+		counts[idx]++
+		self.state.Tokens[tok] = counts
+	}
+	self.access.Unlock()
+}
+
+// learn from a post, Run saves it with whatever else was learned
+func (self *spamFilter) Learn(nntp NNTPMessage, spam bool) {
+	if self == nil || nntp == nil {
+		return
+	}
+	self.Train(spamText(nntp), spam)
+}
+
+// save state if we learned anything since the last save
+func (self *spamFilter) Flush() {
+	if self == nil {
+		return
+	}
+	self.access.Lock()
+	dirty := self.dirty
+	self.access.Unlock()
+	if dirty {
+		err := self.Save()
+		if err != nil {
+			log.Println("failed to save spam filter", err)
+		}
+	}
+}
+
+// save what we learned every interval instead of on every mod action
+func (self *spamFilter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		self.Flush()
+	}
+}
+
+// probability that a token is spam, unknown tokens are neutral
+func (self *spamFilter) tokenProbability(tok string) float64 {
+	counts, ok := self.state.Tokens[tok]
+	if !ok {
+		return 0.5
+	}
+	s := float64(counts[0]) / float64(self.state.Spam+1)
+	h := float64(counts[1]) / float64(self.state.Ham+1)
+	p := s / (s + h)
+	// don't trust tokens we have barely seen
+	n := float64(counts[0] + counts[1])
+	p = (0.5 + n*p) / (1 + n)
+	return math.Max(0.01, math.Min(0.99, p))
+}
+
+// get the probability that text is spam
+// 0.5 if we don't know enough to tell
+func (self *spamFilter) Score(text string) float64 {
+	if self == nil {
+		return 0.5
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.state.Spam == 0 || self.state.Ham == 0 {
+		return 0.5
+	}
+	var probs []float64
+	for _, tok := range spamTokens(text) {
+		probs = append(probs, self.tokenProbability(tok))
+	}
+	// only use the tokens that say the most
+	for i := 1; i < len(probs); i++ {
+		for j := i; j > 0 && math.Abs(probs[j]-0.5) > math.Abs(probs[j-1]-0.5); j-- {
+			probs[j], probs[j-1] = probs[j-1], probs[j]
+		}
+	}
+	if len(probs) > spamInterestingTokens {
+		probs = probs[:spamInterestingTokens]
+	}
+	// combine in log space so we don't underflow
+	var logSpam, logHam float64
+	for _, p := range probs {
+		logSpam += math.Log(p)
+		logHam += math.Log(1 - p)
+	}
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
+
+// get the score at which posts in a newsgroup are quarantined
+func (self *spamFilter) Threshold(newsgroup string) float64 {
+	t, ok := self.groups[newsgroup]
+	if ok {
+		return t
+	}
+	return self.threshold
+}
+
+// return true and the score if this post should be quarantined
+func (self *spamFilter) IsSpam(nntp NNTPMessage) (bool, float64) {
+	if self == nil {
+		return false, 0
+	}
+	score := self.Score(spamText(nntp))
+	return score >= self.Threshold(nntp.Newsgroup()), score
+}
+
+// create the spam filter from config, nil if it is disabled
+func spamFilterFromConfig(conf *SRNdConfig) *spamFilter {
+	if conf.spam["enable"] != "1" {
+		return nil
+	}
+	fname := conf.spam["file"]
+	if fname == "" {
+		fname = "spam.json"
+	}
+	threshold, err := strconv.ParseFloat(conf.spam["threshold"], 64)
+	if err != nil {
+		threshold = 0.9
+	}
+	groups := make(map[string]float64)
+	for group, val := range conf.spam_thresholds {
+		t, err := strconv.ParseFloat(val, 64)
+		if err == nil {
+			groups[group] = t
+		} else {
+			log.Println("invalid spam threshold for", group, val)
+		}
+	}
+	f := newSpamFilter(fname, threshold, groups)
+	err = f.Load()
+	if err != nil {
+		log.Println("failed to load spam filter from", fname, err)
+	}
+	return f
+}
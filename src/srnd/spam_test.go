@@ -0,0 +1,59 @@
+package srnd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpamFilterScore(t *testing.T) {
+
+	f := newSpamFilter("", 0.9, map[string]float64{"overchan.test": 0.5})
+	if f.Score("anything at all") != 0.5 {
+		t.Error("untrained filter should be neutral")
+	}
+	for i := 0; i < 10; i++ {
+		f.Train("buy cheap pills now http://spam.example/pills", true)
+		f.Train("what do you think about the new release of this software", false)
+	}
+	spam := f.Score("cheap pills at http://spam.example/pills")
+	ham := f.Score("i think the release is fine")
+	if spam <= 0.9 {
+		t.Error("spam scored too low", spam)
+	}
+	if ham >= 0.5 {
+		t.Error("ham scored too high", ham)
+	}
+	if f.Threshold("overchan.test") != 0.5 || f.Threshold("overchan.other") != 0.9 {
+		t.Error("bad per group threshold")
+	}
+
+}
+
+func TestSpamFilterFlush(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	fname := filepath.Join(dir, "spam.json")
+	f := newSpamFilter(fname, 0.9, nil)
+	f.Train("buy cheap pills now", true)
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		t.Error("training should not save by itself", err)
+	}
+	f.Flush()
+	if _, err := os.Stat(fname); err != nil {
+		t.Error("flush did not save what we learned", err)
+	}
+	os.Remove(fname)
+	f.Flush()
+	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+		t.Error("flush saved with nothing new learned", err)
+	}
+	f.Train("what do you think about the release", false)
+	f.Flush()
+	g := newSpamFilter(fname, 0.9, nil)
+	if err := g.Load(); err != nil || g.state.Spam != 1 || g.state.Ham != 1 {
+		t.Error("saved filter did not load back", g.state, err)
+	}
+
+}
@@ -1,6 +1,10 @@
 package srnd
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
 
 func TestGenFeedsConfig(t *testing.T) {
 
@@ -29,3 +33,15 @@ func TestOpenFileWriter(t *testing.T) {
 	}
 
 }
+
+// a temporary directory for a test, done removes it
+func testDir(t *testing.T) (dir string, done func()) {
+
+	dir, err := ioutil.TempDir("", "srnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done = func() { os.RemoveAll(dir) }
+	return
+
+}
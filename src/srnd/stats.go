@@ -0,0 +1,249 @@
+//
+// stats.go -- how the node is doing, for the operator
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// days of posts the stats page graphs
+const statsDays = 30
+
+// a day on the posts graph
+type statsDay struct {
+	Day   string `json:"day"`
+	Count int64  `json:"count"`
+	// of the busiest day, for bar widths
+	Percent int64 `json:"percent"`
+}
+
+// a filesystem we keep things on
+type statsDisk struct {
+	Name        string
+	Path        string
+	Total       string
+	Free        string
+	UsedPercent int64
+	Error       string
+}
+
+// format a byte count for people
+func formatBytes(n uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	f := float64(n)
+	idx := 0
+	for f >= 1024 && idx < len(units)-1 {
+		f /= 1024
+		idx++
+	}
+	if idx == 0 {
+		return fmt.Sprintf("%d %s", n, units[idx])
+	}
+	return fmt.Sprintf("%.1f %s", f, units[idx])
+}
+
+// posts per day for the graph, busiest day is 100 percent
+func statsPostDays(posts []PostEntry) (days []statsDay) {
+	var most int64
+	for _, entry := range posts {
+		if entry.Count() > most {
+			most = entry.Count()
+		}
+	}
+	for _, entry := range posts {
+		day := statsDay{
+			Day:   entry.Time().UTC().Format("2006-01-02"),
+			Count: entry.Count(),
+		}
+		if most > 0 {
+			day.Percent = entry.Count() * 100 / most
+		}
+		days = append(days, day)
+	}
+	return
+}
+
+// how full the filesystem with path on it is
+func statsDiskUsage(name, path string) (disk statsDisk) {
+	disk.Name = name
+	disk.Path = path
+	total, free, err := diskUsage(path)
+	if err != nil {
+		disk.Error = err.Error()
+		return
+	}
+	disk.Total = formatBytes(total)
+	disk.Free = formatBytes(free)
+	if total > 0 {
+		disk.UsedPercent = int64((total - free) * 100 / total)
+	}
+	return
+}
+
+// how long a small query takes
+func databaseLatency(db Database) time.Duration {
+	start := time.Now()
+	db.HasNewsgroup("ctl")
+	return time.Since(start)
+}
+
+// GET /mod/stats
+func (self httpModUI) ServeModStats(wr http.ResponseWriter, r *http.Request) {
+	self.serveAuthedPage(wr, r, "admin", "modstats.mustache", func() map[string]interface{} {
+		db := self.daemon.database
+		return map[string]interface{}{
+			"posts":      statsPostDays(db.GetLastDaysPosts(statsDays)),
+			"feeds":      self.daemon.feedHealth(),
+			"thumbnails": self.daemon.store.ThumbnailsPending(),
+			"disks": []statsDisk{
+				statsDiskUsage("articles", self.daemon.conf.store["store_dir"]),
+				statsDiskUsage("attachments", self.daemon.store.AttachmentDir()),
+				statsDiskUsage("webroot", self.daemon.conf.frontend["webroot"]),
+			},
+			"db_latency": databaseLatency(db).String(),
+		}
+	})
+}
+
+// a newsgroup in srnd stats
+type statsGroup struct {
+	Newsgroup string     `json:"newsgroup"`
+	Articles  int64      `json:"articles"`
+	Threads   int64      `json:"threads"`
+	Posts     []statsDay `json:"posts_per_day"`
+}
+
+// a directory we keep files in and how much it holds
+type statsDir struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Files int64  `json:"files"`
+	Bytes uint64 `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// a key that signed posts
+type statsKey struct {
+	Pubkey string `json:"pubkey"`
+	Posts  int64  `json:"posts"`
+}
+
+// what srnd stats prints
+type statsReport struct {
+	Groups  []statsGroup `json:"groups"`
+	Dirs    []statsDir   `json:"dirs"`
+	TopKeys []statsKey   `json:"top_keys"`
+}
+
+// how many files and bytes are under path
+func statsDirUsage(name, path string) (dir statsDir) {
+	dir.Name = name
+	dir.Path = path
+	err := filepath.Walk(path, func(fpath string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			dir.Files++
+			dir.Bytes += uint64(info.Size())
+		}
+		return err
+	})
+	if err != nil {
+		dir.Error = err.Error()
+	}
+	return
+}
+
+// the n keys that signed the most articles, most first
+func statsTopKeys(signed map[string]string, n int) (keys []statsKey) {
+	posts := make(map[string]int64)
+	for _, pubkey := range signed {
+		posts[pubkey]++
+	}
+	for pubkey, count := range posts {
+		keys = append(keys, statsKey{pubkey, count})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Posts != keys[j].Posts {
+			return keys[i].Posts > keys[j].Posts
+		}
+		return keys[i].Pubkey < keys[j].Pubkey
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return
+}
+
+func (self statsReport) Print(w io.Writer) {
+	for _, g := range self.Groups {
+		fmt.Fprintf(w, "%s: %d articles in %d threads\n", g.Newsgroup, g.Articles, g.Threads)
+		for _, day := range g.Posts {
+			fmt.Fprintf(w, "  %s %d\n", day.Day, day.Count)
+		}
+	}
+	for _, dir := range self.Dirs {
+		if dir.Error != "" {
+			fmt.Fprintf(w, "%s (%s): %s\n", dir.Name, dir.Path, dir.Error)
+		} else {
+			fmt.Fprintf(w, "%s (%s): %d files, %s\n", dir.Name, dir.Path, dir.Files, formatBytes(dir.Bytes))
+		}
+	}
+	if len(self.TopKeys) > 0 {
+		fmt.Fprintln(w, "top posting keys:")
+		for _, k := range self.TopKeys {
+			fmt.Fprintf(w, "  %s %d\n", k.Pubkey, k.Posts)
+		}
+	}
+}
+
+// print how many articles each newsgroup has, posts per day, disk usage and who posts most
+// usage: [-json] [-days n] [-keys n]
+func StatsTool(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print json for monitoring scripts")
+	days := flags.Int64("days", 7, "days of posts per newsgroup")
+	nkeys := flags.Int("keys", 10, "how many of the top posting keys")
+	flags.Parse(args)
+	conf := ReadConfig()
+	db := toolDatabase(conf)
+	defer db.Close()
+	var report statsReport
+	groups := db.GetAllNewsgroups()
+	sort.Strings(groups)
+	for _, group := range groups {
+		articles, _ := db.CountAllArticlesInGroup(group)
+		report.Groups = append(report.Groups, statsGroup{
+			Newsgroup: group,
+			Articles:  articles,
+			Threads:   db.CountThreadsInGroup(group),
+			Posts:     statsPostDays(db.GetLastDaysPostsForGroup(group, *days)),
+		})
+	}
+	report.Dirs = []statsDir{
+		statsDirUsage("articles", conf.store["store_dir"]),
+		statsDirUsage("attachments", conf.store["attachments_dir"]),
+		statsDirUsage("thumbnails", conf.store["thumbs_dir"]),
+	}
+	signed, err := db.GetSignedArticles()
+	if err != nil {
+		log.Println("failed to get signed articles", err)
+	}
+	report.TopKeys = statsTopKeys(signed, *nkeys)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+}
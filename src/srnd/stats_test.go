@@ -0,0 +1,31 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+
+	if s := formatBytes(512); s != "512 B" {
+		t.Error("small sizes should be in bytes", s)
+	}
+	if s := formatBytes(3 * 1024 * 1024 * 1024 / 2); s != "1.5 GiB" {
+		t.Error("bad size", s)
+	}
+
+}
+
+func TestStatsTopKeys(t *testing.T) {
+
+	signed := map[string]string{
+		"<a@test>": "bb",
+		"<b@test>": "aa",
+		"<c@test>": "bb",
+		"<d@test>": "cc",
+	}
+	keys := statsTopKeys(signed, 2)
+	if len(keys) != 2 || keys[0] != (statsKey{"bb", 2}) || keys[1] != (statsKey{"aa", 1}) {
+		t.Error("bad top keys", keys)
+	}
+
+}
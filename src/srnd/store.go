@@ -7,8 +7,9 @@ package srnd
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"encoding/base32"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"mime"
@@ -18,6 +19,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ArticleStore interface {
@@ -46,6 +50,10 @@ type ArticleStore interface {
 	GetAllAttachments() ([]string, error)
 	// generate a thumbnail
 	GenerateThumbnail(fname string) error
+	// take the thumbnail programs and placeholder from a reloaded [articles]
+	ReloadThumbnails(config map[string]string) error
+	// how many thumbnails are being made right now
+	ThumbnailsPending() int64
 	// generate all thumbanils for this message
 	ThumbnailMessage(msgid string)
 	// did we enable compression?
@@ -53,11 +61,30 @@ type ArticleStore interface {
 	// process body of nntp message, register attachments and the article
 	// write the body into writer as we go through the body
 	// does NOT write mime header
-	ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body io.Reader) error
+	// posts are held for the mods if holdFrom names a feed in moderated intake
+	ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body io.Reader, holdFrom string) error
 	// register this post with the daemon
 	RegisterPost(nntp NNTPMessage) error
+	// register a post we are about to sign with pubkey, it counts against the quota of pubkey
+	RegisterPostAs(nntp NNTPMessage, pubkey string) error
 	// register signed message
 	RegisterSigned(msgid, pk string) error
+	// is the attachment with this sha512 banned?
+	FileBanned(hash []byte) bool
+	// delete every copy of the attachment with this sha512 and its thumbnails
+	PurgeAttachment(hash []byte) error
+	// move an article and its attachments into the trash, NoTrash if we delete for good
+	TrashArticle(msgid string, atts []string) error
+	// move a trashed article and its attachments back
+	RestoreArticle(msgid string, atts []string) error
+	// delete a trashed article and its attachments for good
+	EmptyTrash(msgid string, atts []string)
+	// create a file for an article we rejected, kept apart so it is never served or sent
+	CreateRejectedFile(msgid string) io.WriteCloser
+	// open an article we rejected for reading
+	OpenRejected(msgid string) (io.ReadCloser, error)
+	// delete an article we rejected
+	RemoveRejected(msgid string)
 
 	GetMessage(msgid string) NNTPMessage
 
@@ -65,20 +92,39 @@ type ArticleStore interface {
 	GetMessageSize(msgid string) (int64, error)
 }
 type articleStore struct {
+	// thumbnails being made, first so atomic access is aligned
+	thumbnailing int64
 	directory    string
 	temp         string
 	attachments  string
 	thumbs       string
 	database     Database
+	// guards the thumbnail settings below, they change on reload
+	thumb_mtx    sync.RWMutex
 	convert_path string
 	ffmpeg_path  string
 	sox_path     string
 	placeholder  string
-	compression  bool
-	compWriter   *gzip.Writer
+	// format new articles are stored in
+	compression string
+	// quarantines posts that look like spam, nil to let everything through
+	spam *spamFilter
+	// bans and quarantines floods, nil to let everything through
+	flood *floodDetector
+	// tells mods about quarantines and bans, nil to tell nobody
+	notify *modNotifier
+	// daily limits per signing key, nil for no limits
+	quota *pubkeyQuota
+	// where deleted articles wait to be restored, empty to delete for good
+	trash string
+	// where rejected articles wait for the mods
+	rejected string
 }
 
-func createArticleStore(config map[string]string, database Database) ArticleStore {
+// returned when a post has an attachment that is banned
+var AttachmentBanned = errors.New("attachment is banned")
+
+func createArticleStore(config map[string]string, database Database, spam *spamFilter, flood *floodDetector, notify *modNotifier, quota *pubkeyQuota) ArticleStore {
 	store := &articleStore{
 		directory:    config["store_dir"],
 		temp:         config["incoming_dir"],
@@ -89,7 +135,17 @@ func createArticleStore(config map[string]string, database Database) ArticleStor
 		sox_path:     config["sox_bin"],
 		placeholder:  config["placeholder_thumbnail"],
 		database:     database,
-		compression:  config["compression"] == "1",
+		compression:  articleCompressionFromConfig(config["compression"]),
+		spam:         spam,
+		flood:        flood,
+		notify:       notify,
+		quota:        quota,
+		trash:        config["trash_dir"],
+		rejected:     config["rejected_dir"],
+	}
+	if len(store.rejected) == 0 {
+		// configs from before there was rejected_dir
+		store.rejected = "rejected"
 	}
 	store.Init()
 	return store
@@ -100,7 +156,7 @@ func (self *articleStore) AttachmentDir() string {
 }
 
 func (self *articleStore) Compression() bool {
-	return self.compression
+	return self.compression != ArticleCompressionNone
 }
 
 func (self *articleStore) TempDir() string {
@@ -113,6 +169,12 @@ func (self *articleStore) Init() {
 	EnsureDir(self.temp)
 	EnsureDir(self.attachments)
 	EnsureDir(self.thumbs)
+	EnsureDir(self.rejected)
+	if len(self.trash) > 0 {
+		for _, d := range []string{"articles", "img", "thm"} {
+			EnsureDir(filepath.Join(self.trash, d))
+		}
+	}
 	if !CheckFile(self.convert_path) {
 		log.Fatal("cannot find executable for convert: ", self.convert_path, " not found")
 	}
@@ -175,7 +237,37 @@ func (self *articleStore) isVideo(fname string) bool {
 	return false
 }
 
+func (self *articleStore) ThumbnailsPending() int64 {
+	return atomic.LoadInt64(&self.thumbnailing)
+}
+
+// check the thumbnail settings in [articles] and use them from now on
+// keeps the old ones if a program is missing
+func (self *articleStore) ReloadThumbnails(config map[string]string) error {
+	for _, k := range []string{"convert_bin", "ffmpegthumbnailer_bin", "sox_bin"} {
+		if !CheckFile(config[k]) {
+			return errors.New("cannot find executable for " + k + ": " + config[k] + " not found")
+		}
+	}
+	placeholder := config["placeholder_thumbnail"]
+	if !CheckFile(placeholder) {
+		return errors.New("cannot find thumbnail placeholder file: " + placeholder + " not found")
+	}
+	self.thumb_mtx.Lock()
+	self.convert_path = config["convert_bin"]
+	self.ffmpeg_path = config["ffmpegthumbnailer_bin"]
+	self.sox_path = config["sox_bin"]
+	self.placeholder = placeholder
+	self.thumb_mtx.Unlock()
+	return nil
+}
+
 func (self *articleStore) GenerateThumbnail(fname string) error {
+	atomic.AddInt64(&self.thumbnailing, 1)
+	defer atomic.AddInt64(&self.thumbnailing, -1)
+	self.thumb_mtx.RLock()
+	convert_path, ffmpeg_path, sox_path, placeholder := self.convert_path, self.ffmpeg_path, self.sox_path, self.placeholder
+	self.thumb_mtx.RUnlock()
 	outfname := self.ThumbnailFilepath(fname)
 	infname := self.AttachmentFilepath(fname)
 	var cmd *exec.Cmd
@@ -184,17 +276,17 @@ func (self *articleStore) GenerateThumbnail(fname string) error {
 		if strings.HasSuffix(infname, ".gif") {
 			infname += "[0]"
 		}
-		cmd = exec.Command(self.convert_path, "-thumbnail", "200", infname, outfname)
+		cmd = exec.Command(convert_path, "-thumbnail", "200", infname, outfname)
 
 	} else if self.isAudio(fname) {
 		tmpfname := infname + ".wav"
-		cmd = exec.Command(self.ffmpeg_path, "-i", infname, tmpfname)
+		cmd = exec.Command(ffmpeg_path, "-i", infname, tmpfname)
 		var out []byte
 
 		out, err = cmd.CombinedOutput()
 
 		if err == nil {
-			cmd = exec.Command(self.sox_path, tmpfname, "-n", "spectrogram", "-a", "-d", "0:10", "-r", "-p", "6", "-x", "200", "-y", "150", "-o", outfname)
+			cmd = exec.Command(sox_path, tmpfname, "-n", "spectrogram", "-a", "-d", "0:10", "-r", "-p", "6", "-x", "200", "-y", "150", "-o", outfname)
 			out, err = cmd.CombinedOutput()
 		}
 		if err == nil {
@@ -205,13 +297,14 @@ func (self *articleStore) GenerateThumbnail(fname string) error {
 		DelFile(tmpfname)
 		return err
 	} else if self.isVideo(fname) || strings.HasSuffix(fname, ".txt") {
-		cmd = exec.Command(self.ffmpeg_path, "-i", infname, "-vf", "scale=300:200", "-vframes", "1", outfname)
+		cmd = exec.Command(ffmpeg_path, "-i", infname, "-vf", "scale=300:200", "-vframes", "1", outfname)
 	}
 	if cmd == nil {
 		log.Println("use placeholder for", infname)
-		os.Link(self.placeholder, outfname)
+		os.Link(placeholder, outfname)
 	} else {
-		exec_out, err := cmd.CombinedOutput()
+		var exec_out []byte
+		exec_out, err = cmd.CombinedOutput()
 		if err == nil {
 			log.Println("made thumbnail for", infname)
 		} else {
@@ -230,45 +323,166 @@ func (self *articleStore) GetAllAttachments() (names []string, err error) {
 	return
 }
 
+// articles are read in whatever format they were stored in, whatever compression is set to now
 func (self *articleStore) OpenMessage(msgid string) (rc io.ReadCloser, err error) {
-	fname := self.GetFilename(msgid)
-	var f *os.File
-	f, err = os.Open(fname)
-	if err == nil {
-		if self.compression {
-			// read gzip header
-			var hdr [2]byte
-			_, err = f.Read(hdr[:])
-			// seek back to beginning
-			f.Seek(0, 0)
+	return openArticleFile(self.GetFilename(msgid))
+}
+
+func (self *articleStore) RegisterPost(nntp NNTPMessage) (err error) {
+	return self.RegisterPostAs(nntp, nntp.Pubkey())
+}
+
+func (self *articleStore) RegisterPostAs(nntp NNTPMessage, pubkey string) (err error) {
+	for _, att := range nntp.Attachments() {
+		if self.FileBanned(att.Hash()) {
+			log.Println("attachment", att.Filename(), "in", nntp.MessageID(), "is banned")
+			return AttachmentBanned
+		}
+	}
+	if nntp.Newsgroup() != "ctl" {
+		err = self.checkQuota(pubkey, len(nntp.Attachments()))
+		if err != nil {
+			log.Println(nntp.MessageID(), "rejected:", err)
+			return
+		}
+		burst := self.flood.Check(nntp, time.Now())
+		if len(burst) > 0 {
+			self.quarantineFlood(nntp.MessageID(), burst)
+			return ArticleQuarantined
+		}
+		spam, score := self.spam.IsSpam(nntp)
+		if spam {
+			// hold it back until a mod looks at it
+			log.Printf("quarantine %s score=%.3f", nntp.MessageID(), score)
+			err = self.database.QuarantineArticle(nntp.MessageID(), nntp.Newsgroup(), score)
 			if err == nil {
-				if hdr[0] == 0x1f && hdr[1] == 0x8b {
-					// gzip header detected
-					rc, err = gzip.NewReader(f)
-				} else {
-					// fall back to uncompressed
-					rc = f
-				}
-			} else {
-				// error reading file
-				f.Close()
-				rc = nil
+				self.notify.Notify(ModNotification{Event: NotifyQuarantine, Newsgroup: nntp.Newsgroup(), MessageID: nntp.MessageID(), Reason: fmt.Sprintf("spam score %.3f", score)})
+				err = ArticleQuarantined
 			}
-			// will fall back to regular file if gzip header not found
-		} else {
-			// compression disabled
-			// assume uncompressed
-			rc = f
+			return
 		}
 	}
+	err = self.database.RegisterArticle(nntp)
 	return
 }
 
-func (self *articleStore) RegisterPost(nntp NNTPMessage) (err error) {
-	err = self.database.RegisterArticle(nntp)
+// count a post against the quota of the key that signed it
+func (self *articleStore) checkQuota(pubkey string, attachments int) error {
+	if self.quota == nil || pubkey == "" {
+		return nil
+	}
+	trusted, err := self.database.PubkeyTrusted(pubkey)
+	if err != nil {
+		log.Println("failed to check if", pubkey, "is trusted", err)
+	}
+	return self.quota.Allow(pubkey, attachments, trusted, time.Now())
+}
+
+// hold a post from a feed in moderated intake until a mod approves it
+func (self *articleStore) holdPost(nntp NNTPMessage, feed string) (err error) {
+	log.Println("holding", nntp.MessageID(), "from", feed, "for moderated intake")
+	err = self.database.HoldArticleFromFeed(nntp.MessageID(), nntp.Newsgroup(), feed)
+	if err == nil {
+		self.notify.Notify(ModNotification{Event: NotifyQuarantine, Newsgroup: nntp.Newsgroup(), MessageID: nntp.MessageID(), Reason: "moderated intake from " + feed})
+		err = ArticleQuarantined
+	}
+	return
+}
+
+// ban the addresses a flood came from for a while and hold every post in it for the mods
+func (self *articleStore) quarantineFlood(msgid string, burst []floodPost) {
+	addrs := floodAddrs(burst)
+	log.Println("flood of", len(burst), "posts ending with", msgid, "from", addrs)
+	expires := time.Now().Add(self.flood.BanDuration()).Unix()
+	for _, encaddr := range addrs {
+		err := self.database.BanEncAddrUntil(encaddr, expires)
+		if err == nil {
+			self.notify.Notify(ModNotification{Event: NotifyAutoBan, Target: encaddr, Reason: fmt.Sprintf("flood of %d posts", len(burst))})
+		} else {
+			log.Println("failed to ban flooding address", encaddr, err)
+		}
+	}
+	for _, post := range burst {
+		var err error
+		if post.msgid != msgid {
+			// already went through, take it down again until a mod looks at it
+			err = self.database.DeleteArticle(post.msgid)
+		}
+		if err == nil {
+			err = self.database.QuarantineArticle(post.msgid, post.newsgroup, 1)
+		}
+		if err == nil {
+			self.notify.Notify(ModNotification{Event: NotifyQuarantine, Newsgroup: post.newsgroup, MessageID: post.msgid, Reason: "flood"})
+		} else {
+			log.Println("failed to quarantine", post.msgid, err)
+		}
+	}
+}
+
+func (self *articleStore) FileBanned(hash []byte) bool {
+	if self.database == nil {
+		return false
+	}
+	banned, err := self.database.AttachmentBanned(hexify(hash))
+	if err != nil {
+		log.Println("failed to check attachment ban", err)
+	}
+	return banned
+}
+
+func (self *articleStore) PurgeAttachment(hash []byte) (err error) {
+	// attachments are named by the base32 of their hash plus extension
+	prefix := base32.StdEncoding.EncodeToString(hash)
+	var matches []string
+	matches, err = filepath.Glob(filepath.Join(self.attachments, prefix+"*"))
+	for _, fpath := range matches {
+		fname := filepath.Base(fpath)
+		log.Println("purge attachment", fname)
+		DelFile(fpath)
+		DelFile(self.ThumbnailFilepath(fname))
+	}
 	return
 }
 
+func (self *articleStore) TrashArticle(msgid string, atts []string) error {
+	if len(self.trash) == 0 || !ValidMessageID(msgid) {
+		return NoTrash
+	}
+	live, trashed := trashPaths(self, self.trash, msgid, atts)
+	for idx := range live {
+		err := os.Rename(live[idx], trashed[idx])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *articleStore) RestoreArticle(msgid string, atts []string) error {
+	if len(self.trash) == 0 || !ValidMessageID(msgid) {
+		return NoTrash
+	}
+	live, trashed := trashPaths(self, self.trash, msgid, atts)
+	for idx := range live {
+		err := os.Rename(trashed[idx], live[idx])
+		if err != nil && !(idx > 0 && os.IsNotExist(err)) {
+			// the article has to come back, thumbnails may never have existed
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *articleStore) EmptyTrash(msgid string, atts []string) {
+	if len(self.trash) == 0 || !ValidMessageID(msgid) {
+		return
+	}
+	_, trashed := trashPaths(self, self.trash, msgid, atts)
+	for _, f := range trashed {
+		DelFile(f)
+	}
+}
+
 func (self *articleStore) saveAttachment(att NNTPAttachment) {
 	fpath := att.Filepath()
 	upload := self.AttachmentFilepath(fpath)
@@ -299,13 +513,8 @@ func (self *articleStore) thumbnailAttachment(fpath string) {
 	}
 }
 
-func (self *articleStore) GetMessageSize(msgid string) (sz int64, err error) {
-	var info os.FileInfo
-	info, err = os.Stat(self.GetFilename(msgid))
-	if err == nil {
-		sz = info.Size()
-	}
-	return
+func (self *articleStore) GetMessageSize(msgid string) (int64, error) {
+	return articleFileSize(self.GetFilename(msgid))
 }
 
 // get the filepath for an attachment
@@ -335,7 +544,49 @@ func (self *articleStore) CreateFile(messageID string) io.WriteCloser {
 		log.Println("cannot open file", fname)
 		return nil
 	}
-	return file
+	w, err := compressArticleFile(file, self.compression)
+	if err != nil {
+		log.Println("cannot store", messageID, err)
+		file.Close()
+		DelFile(fname)
+		return nil
+	}
+	return w
+}
+
+// get the filename of an article we rejected
+func (self *articleStore) rejectedFilename(msgid string) string {
+	if !ValidMessageID(msgid) {
+		return ""
+	}
+	return filepath.Join(self.rejected, msgid)
+}
+
+func (self *articleStore) CreateRejectedFile(msgid string) io.WriteCloser {
+	fname := self.rejectedFilename(msgid)
+	if fname == "" || CheckFile(fname) {
+		return nil
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		log.Println("cannot open file", fname)
+		return nil
+	}
+	return f
+}
+
+func (self *articleStore) OpenRejected(msgid string) (io.ReadCloser, error) {
+	fname := self.rejectedFilename(msgid)
+	if fname == "" {
+		return nil, errors.New("invalid message-id " + msgid)
+	}
+	return openArticleFile(fname)
+}
+
+func (self *articleStore) RemoveRejected(msgid string) {
+	if fname := self.rejectedFilename(msgid); fname != "" {
+		DelFile(fname)
+	}
 }
 
 // return true if we have an article
@@ -368,8 +619,7 @@ func (self *articleStore) GetHeaders(messageID string) (hdr ArticleHeaders) {
 // get article with headers only
 func (self *articleStore) getMIMEHeader(messageID string) (hdr textproto.MIMEHeader) {
 	if ValidMessageID(messageID) {
-		fname := self.GetFilename(messageID)
-		f, err := os.Open(fname)
+		f, err := self.OpenMessage(messageID)
 		if f != nil {
 			r := bufio.NewReader(f)
 			hdr, err = readMIMEHeader(r)
@@ -382,9 +632,16 @@ func (self *articleStore) getMIMEHeader(messageID string) (hdr textproto.MIMEHea
 	return hdr
 }
 
-func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body io.Reader) (err error) {
+func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHeader, body io.Reader, holdFrom string) (err error) {
+	var regErr error
 	err = read_message_body(body, hdr, self, wr, false, func(nntp NNTPMessage) {
-		err = self.RegisterPost(nntp)
+		var err error
+		if len(holdFrom) > 0 && nntp.Newsgroup() != "ctl" {
+			err = self.holdPost(nntp, holdFrom)
+		} else {
+			err = self.RegisterPost(nntp)
+		}
+		regErr = err
 		if err == nil {
 			pk := hdr.Get("X-PubKey-Ed25519")
 			if len(pk) > 0 {
@@ -394,10 +651,13 @@ func (self *articleStore) ProcessMessageBody(wr io.Writer, hdr textproto.MIMEHea
 					log.Println("register signed failed", err)
 				}
 			}
-		} else {
+		} else if err != ArticleQuarantined {
 			log.Println("error procesing message body", err)
 		}
 	})
+	if err == nil && (regErr == ArticleQuarantined || regErr == AttachmentBanned || regErr == PubkeyPostQuota || regErr == PubkeyAttachmentQuota) {
+		err = regErr
+	}
 	return
 }
 
@@ -500,18 +760,24 @@ func read_message_body(body io.Reader, hdr map[string][]string, store ArticleSto
 			nntp.Reset()
 			return errors.New("invalid headers")
 		}
-		// process inner body
-		// verify message
-		err = verifyMessage(pk, sig, body, func(h map[string][]string, innerBody io.Reader) {
-			// handle inner message
-			err := read_message_body(innerBody, h, store, nil, true, callback)
+		// verify message before we look at the inner body
+		var innerHdr textproto.MIMEHeader
+		var innerBody io.Reader
+		innerHdr, innerBody, err = verifyMessage(pk, sig, body)
+		if err == nil {
+			err = checkSignedInner(nntp, innerHdr)
+		}
+		if err == nil {
+			// whoever reads the inner message needs to know who signed it
+			innerHdr.Set("X-Pubkey-Ed25519", pk)
+			err = read_message_body(innerBody, innerHdr, store, nil, true, callback)
 			if err != nil {
 				log.Println("error reading inner signed message", err)
 			}
-		})
-		if err != nil {
+		} else {
 			log.Println("error reading inner message", err)
 		}
+		nntp.Reset()
 	} else {
 		// plaintext attachment
 		b := new(bytes.Buffer)
@@ -7,16 +7,19 @@ package srnd
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/cbroglie/mustache"
 	tinyhtml "github.com/whyrusleeping/tinyhtml"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type templateEngine struct {
@@ -26,10 +29,14 @@ type templateEngine struct {
 	links_short map[string]string
 	// every newsgroup
 	groups map[string]GroupModel
-	// loaded templates
+	// loaded templates, filepath -> contents
 	templates map[string]string
+	// filepath -> modification time of loaded templates
+	mtimes map[string]time.Time
 	// root directory for templates
 	template_dir string
+	// root directory for per newsgroup template overrides, empty for none
+	board_template_dir string
 	// mutex for accessing links
 	links_mtx sync.RWMutex
 	// mutex for accessing shortlinks
@@ -40,19 +47,30 @@ type templateEngine struct {
 	templates_mtx sync.RWMutex
 	// do we want to minimize the html generated?
 	Minimize bool
+	// are we a read only mirror? post forms are left out
+	ReadOnly bool
+	// for finding posts quoted from other threads, nil if we can't
+	database Database
 }
 
-func (self *templateEngine) templateCached(name string) (ok bool) {
+func (self *templateEngine) templateCached(fpath string) (ok bool) {
 	self.templates_mtx.Lock()
-	_, ok = self.templates[name]
+	_, ok = self.templates[fpath]
 	self.templates_mtx.Unlock()
 	return
 }
 
 // explicitly reload a template
 func (self *templateEngine) reloadTemplate(name string) {
+	self.reloadTemplateFile(self.templateFilepath(name))
+}
+
+// explicitly reload a template file
+func (self *templateEngine) reloadTemplateFile(fpath string) {
+	t, mtime := self.loadTemplate(fpath)
 	self.templates_mtx.Lock()
-	self.templates[name] = self.loadTemplate(name)
+	self.templates[fpath] = t
+	self.mtimes[fpath] = mtime
 	self.templates_mtx.Unlock()
 }
 
@@ -72,10 +90,30 @@ func (self *templateEngine) reloadAllTemplates() {
 	self.templates_mtx.Unlock()
 	// for each template we have loaded, reload the contents from file
 	for _, tname := range loadThese {
-		self.reloadTemplate(tname)
+		self.reloadTemplateFile(tname)
 	}
 }
 
+// reload every loaded template whose file changed since we loaded it
+// return true if any did
+func (self *templateEngine) reloadChangedTemplates() (changed bool) {
+	mtimes := make(map[string]time.Time)
+	self.templates_mtx.Lock()
+	for fpath, mtime := range self.mtimes {
+		mtimes[fpath] = mtime
+	}
+	self.templates_mtx.Unlock()
+	for fpath, mtime := range mtimes {
+		st, err := os.Stat(fpath)
+		if err == nil && !st.ModTime().Equal(mtime) {
+			log.Println("template", fpath, "changed, reloading")
+			self.reloadTemplateFile(fpath)
+			changed = true
+		}
+	}
+	return
+}
+
 // update the link -> url cache given our current model
 func updateLinkCache() {
 	// clear existing cache
@@ -212,6 +250,32 @@ func (self *templateEngine) findLink(hash string) (url string) {
 	return
 }
 
+// find the url of a post we don't have a page of in memory and remember it
+func (self *templateEngine) resolveLink(hash, prefix string) (url string) {
+	if self.database == nil {
+		return
+	}
+	e, err := self.database.GetMessageIDByShortHash(hash)
+	if err != nil || e.MessageID() == "" || e.Newsgroup() == "ctl" {
+		return
+	}
+	root, _, _, err := self.database.GetInfoForMessage(e.MessageID())
+	if err != nil {
+		return
+	}
+	url = Backlink{MessageID: e.MessageID(), Root: root}.URL(prefix)
+	if len(hash) == 10 {
+		self.links_short_mtx.Lock()
+		self.links_short[hash] = url
+		self.links_short_mtx.Unlock()
+	} else {
+		self.links_mtx.Lock()
+		self.links[hash] = url
+		self.links_mtx.Unlock()
+	}
+	return
+}
+
 // get the filepath to a template
 func (self *templateEngine) templateFilepath(name string) string {
 	if strings.Count(name, "..") > 0 {
@@ -220,9 +284,25 @@ func (self *templateEngine) templateFilepath(name string) string {
 	return filepath.Join(self.template_dir, name)
 }
 
-// load a template from file, return as string
-func (self *templateEngine) loadTemplate(name string) (t string) {
-	b, err := ioutil.ReadFile(self.templateFilepath(name))
+// get the filepath to a template for a newsgroup
+// the newsgroup's override if it has one
+func (self *templateEngine) boardTemplateFilepath(group, name string) string {
+	if len(self.board_template_dir) > 0 && newsgroupValidFormat(group) && strings.Count(name, "..") == 0 {
+		fpath := filepath.Join(self.board_template_dir, group, name)
+		if CheckFile(fpath) {
+			return fpath
+		}
+	}
+	return self.templateFilepath(name)
+}
+
+// load a template from file, return as string with its modification time
+func (self *templateEngine) loadTemplate(fpath string) (t string, mtime time.Time) {
+	st, err := os.Stat(fpath)
+	if err == nil {
+		mtime = st.ModTime()
+	}
+	b, err := ioutil.ReadFile(fpath)
 	if err == nil {
 		t = string(b)
 	} else {
@@ -232,23 +312,27 @@ func (self *templateEngine) loadTemplate(name string) (t string) {
 	return
 }
 
-// get a template, if it's not cached load from file and cache it
-func (self *templateEngine) getTemplate(name string) (t string) {
-	if !self.templateCached(name) {
-		self.templates_mtx.Lock()
-		self.templates[name] = self.loadTemplate(name)
-		self.templates_mtx.Unlock()
+// get a template file, if it's not cached load from file and cache it
+func (self *templateEngine) getTemplateFile(fpath string) (t string) {
+	if !self.templateCached(fpath) {
+		self.reloadTemplateFile(fpath)
 	}
 	self.templates_mtx.Lock()
-	t, _ = self.templates[name]
+	t, _ = self.templates[fpath]
 	self.templates_mtx.Unlock()
 	return
 }
 
-// render a template, self explanitory
-func (self *templateEngine) renderTemplate(name string, obj map[string]interface{}) string {
-	t := self.getTemplate(name)
-	obj["i18n"] = i18nProvider
+// get a template, if it's not cached load from file and cache it
+func (self *templateEngine) getTemplate(name string) string {
+	return self.getTemplateFile(self.templateFilepath(name))
+}
+
+// render template contents
+func (self *templateEngine) render(t string, obj map[string]interface{}) string {
+	if _, ok := obj["i18n"]; !ok {
+		obj["i18n"] = i18nProvider
+	}
 	s, err := mustache.Render(t, obj)
 	if err == nil {
 		return s
@@ -257,9 +341,28 @@ func (self *templateEngine) renderTemplate(name string, obj map[string]interface
 	}
 }
 
+// render a template, self explanitory
+func (self *templateEngine) renderTemplate(name string, obj map[string]interface{}) string {
+	return self.render(self.getTemplate(name), obj)
+}
+
 // write a template to an io.Writer
 func (self *templateEngine) writeTemplate(name string, obj map[string]interface{}, wr io.Writer) (err error) {
-	str := self.renderTemplate(name, obj)
+	return self.write(self.renderTemplate(name, obj), wr)
+}
+
+// write a template to an io.Writer using the newsgroup's override if it has one
+// rendered in the newsgroup's locale unless obj has translations
+func (self *templateEngine) writeBoardTemplate(group, name string, obj map[string]interface{}, wr io.Writer) (err error) {
+	if _, ok := obj["i18n"]; !ok {
+		obj["i18n"] = i18nForBoard(group)
+	}
+	obj["read_only"] = self.ReadOnly
+	return self.write(self.render(self.getTemplateFile(self.boardTemplateFilepath(group, name)), obj), wr)
+}
+
+// write rendered markup to an io.Writer, minimized if we want that
+func (self *templateEngine) write(str string, wr io.Writer) (err error) {
 	var r io.Reader
 	r = bytes.NewBufferString(str)
 	if self.Minimize {
@@ -310,20 +413,28 @@ func (self *templateEngine) obtainBoard(prefix, frontend, group string, update b
 	return
 }
 
-func (self *templateEngine) genCatalog(prefix, frontend, group string, wr io.Writer, db Database) {
+func (self *templateEngine) genCatalog(prefix, frontend, group string, wr io.Writer, db Database, json bool) {
 	board := self.obtainBoard(prefix, frontend, group, false, db)
 	catalog := new(catalogModel)
 	catalog.prefix = prefix
 	catalog.frontend = frontend
 	catalog.board = group
 
+	counts, err := db.GetGroupThreadCounts(group)
+	if err != nil {
+		log.Println("failed to get thread counts for catalog", group, err)
+	}
 	for page, bm := range board {
 		for _, th := range bm.Threads() {
-			th.Update(db)
-			catalog.threads = append(catalog.threads, &catalogItemModel{op: th.OP(), page: page, replycount: len(th.Replies())})
+			c := counts[th.OP().MessageID()]
+			catalog.threads = append(catalog.threads, &catalogItemModel{op: th.OP(), page: page, replycount: int(c.Replies), imagecount: int(c.Images)})
 		}
 	}
-	self.writeTemplate("catalog.mustache", map[string]interface{}{"board": catalog}, wr)
+	if json {
+		self.renderJSON(wr, catalog)
+	} else {
+		self.writeBoardTemplate(group, "catalog.mustache", map[string]interface{}{"board": catalog}, wr)
+	}
 }
 
 // generate a board page
@@ -352,8 +463,9 @@ func (self *templateEngine) genBoardPage(allowFiles bool, prefix, frontend, news
 		p := board[page]
 		self.renderJSON(wr, p)
 	} else {
-		form := renderPostForm(prefix, newsgroup, "", allowFiles)
-		self.writeTemplate("board.mustache", map[string]interface{}{"board": board[page], "page": page, "form": form}, wr)
+		settings, _ := db.GetBoardSettings(newsgroup)
+		form := renderPostForm(prefix, newsgroup, "", allowFiles && settings.Attachments)
+		self.writeBoardTemplate(newsgroup, "board.mustache", map[string]interface{}{"board": board[page], "page": page, "form": form, "description": settings.Description, "inline_media": settings.InlineMedia}, wr)
 	}
 }
 
@@ -375,8 +487,40 @@ func (self *templateEngine) genUkko(prefix, frontend string, wr io.Writer, datab
 }
 
 func (self *templateEngine) genUkkoPaginated(prefix, frontend string, wr io.Writer, database Database, page int, json bool) {
+	self.genOverboard(prefix, frontend, wr, database, page, nil, json)
+}
+
+// get the root posts for a page of the overboard leaving out threads in excluded newsgroups
+func overboardThreads(database Database, page int, exclude map[string]bool) (roots []ArticleEntry) {
+	skip := page * 10
+	offset := 0
+	for len(roots) < 10 {
+		ents := database.GetLastBumpedThreadsPaginated("", 50, offset)
+		if len(ents) == 0 {
+			break
+		}
+		offset += len(ents)
+		for _, ent := range ents {
+			if exclude[ent.Newsgroup()] {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			roots = append(roots, ent)
+			if len(roots) == 10 {
+				break
+			}
+		}
+	}
+	return
+}
+
+// generate a page of the overboard without the newsgroups in exclude
+func (self *templateEngine) genOverboard(prefix, frontend string, wr io.Writer, database Database, page int, exclude map[string]bool, json bool) {
 	var threads []ThreadModel
-	for _, article := range database.GetLastBumpedThreadsPaginated("", 10, page*10) {
+	for _, article := range overboardThreads(database, page, exclude) {
 		// get the newsgroup and root post id
 		newsgroup := article[1]
 		// get first thread
@@ -400,6 +544,14 @@ func (self *templateEngine) genUkkoPaginated(prefix, frontend string, wr io.Writ
 	}
 	updateLinkCache()
 	obj := map[string]interface{}{"prefix": prefix, "threads": threads, "page": page}
+	if len(exclude) > 0 {
+		var groups []string
+		for group := range exclude {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		obj["exclude"] = strings.Join(groups, ",")
+	}
 	if page > 0 {
 		obj["prev"] = map[string]interface{}{"no": page - 1}
 	}
@@ -443,7 +595,7 @@ func (self *templateEngine) genThread(allowFiles bool, root ArticleEntry, prefix
 				self.renderJSON(wr, t)
 			} else {
 				form := renderPostForm(prefix, newsgroup, msgid, allowFiles)
-				self.writeTemplate("thread.mustache", map[string]interface{}{"thread": t, "board": pagemodel, "form": form}, wr)
+				self.writeBoardTemplate(newsgroup, "thread.mustache", map[string]interface{}{"thread": t, "board": pagemodel, "form": form, "inline_media": inlineMedia(newsgroup, db)}, wr)
 			}
 			return
 		}
@@ -466,7 +618,7 @@ func (self *templateEngine) genThread(allowFiles bool, root ArticleEntry, prefix
 					self.renderJSON(wr, t)
 				} else {
 					form := renderPostForm(prefix, newsgroup, msgid, allowFiles)
-					self.writeTemplate("thread.mustache", map[string]interface{}{"thread": t, "board": pagemodel, "form": form}, wr)
+					self.writeBoardTemplate(newsgroup, "thread.mustache", map[string]interface{}{"thread": t, "board": pagemodel, "form": form}, wr)
 				}
 				self.groups_mtx.Lock()
 				self.groups[newsgroup] = b
@@ -479,11 +631,118 @@ func (self *templateEngine) genThread(allowFiles bool, root ArticleEntry, prefix
 	*/
 }
 
+// render a thread for the archive without a post form
+// works for threads that fell off the board, returns the thread or nil if we don't have it
+func (self *templateEngine) genArchivedThread(allowFiles bool, root, newsgroup, prefix, frontend string, wr io.Writer, db Database) ThreadModel {
+	op := db.GetPostModel(prefix, root)
+	if op == nil {
+		return nil
+	}
+	t := createThreadModel(op)
+	t.Update(db)
+	navbar := map[string]interface{}{
+		"name":     "Archive of " + newsgroup,
+		"frontend": frontend,
+		"prefix":   prefix,
+		"links": []LinkModel{
+			linkModel{
+				text: "Archive index",
+				link: fmt.Sprintf("%sarchive/%s/index.html", prefix, newsgroup),
+			},
+		},
+	}
+	self.writeBoardTemplate(newsgroup, "archived_thread.mustache", map[string]interface{}{"thread": t, "prefix": prefix, "newsgroup": newsgroup, "navbar": self.renderTemplate("navbar.mustache", navbar)}, wr)
+	return t
+}
+
+// render the archive index for a board
+func (self *templateEngine) genArchiveIndex(prefix, frontend, newsgroup string, threads []archivedThread, wr io.Writer) {
+	navbar := map[string]interface{}{
+		"name":     "Archive of " + newsgroup,
+		"frontend": frontend,
+		"prefix":   prefix,
+	}
+	self.writeBoardTemplate(newsgroup, "archive.mustache", map[string]interface{}{"threads": threads, "prefix": prefix, "newsgroup": newsgroup, "navbar": self.renderTemplate("navbar.mustache", navbar)}, wr)
+}
+
+// render a thread with extra posts that only the poster viewing it gets to see
+// if we don't have the root post the extra posts are the whole thread
+func (self *templateEngine) genShadowThread(allowFiles bool, root, newsgroup, prefix, frontend string, wr io.Writer, db Database, extra []PostModel) {
+	var posts []PostModel
+	var page BoardModel
+	board := self.obtainBoard(prefix, frontend, newsgroup, false, db)
+	if len(board) > 0 {
+		page = board[0]
+	}
+	for _, pagemodel := range board {
+		t := pagemodel.GetThread(root)
+		if t != nil {
+			t.Update(db)
+			posts = append([]PostModel{t.OP()}, t.Replies()...)
+			page = pagemodel
+			break
+		}
+	}
+	posts = append(posts, extra...)
+	t := &thread{
+		allowFiles: allowFiles,
+		prefix:     prefix,
+		Posts:      posts,
+	}
+	form := renderPostForm(prefix, newsgroup, root, allowFiles)
+	self.writeBoardTemplate(newsgroup, "thread.mustache", map[string]interface{}{"thread": t, "board": page, "form": form, "inline_media": inlineMedia(newsgroup, db)}, wr)
+}
+
+// render one page of a thread, offset is how many replies come before the ones we show
+// pagination goes to the template as is for the links between pages
+func (self *templateEngine) genThreadPage(allowFiles bool, op PostModel, replies []PostModel, offset, total int, prefix, frontend string, pagination map[string]interface{}, wr io.Writer, db Database) {
+	root := op.MessageID()
+	newsgroup := op.Board()
+	var page BoardModel
+	board := self.obtainBoard(prefix, frontend, newsgroup, false, db)
+	if len(board) > 0 {
+		page = board[0]
+	}
+	for _, pagemodel := range board {
+		if pagemodel.GetThread(root) != nil {
+			page = pagemodel
+			break
+		}
+	}
+	t := &thread{
+		allowFiles:         allowFiles,
+		prefix:             prefix,
+		Posts:              append([]PostModel{op}, replies...),
+		offset:             offset,
+		truncatedPostCount: total - len(replies),
+		sticky:             db.CheckThreadFlag(root, ThreadSticky),
+		locked:             db.CheckThreadFlag(root, ThreadLocked),
+		cycle:              db.CheckThreadFlag(root, ThreadCycle),
+	}
+	for _, p := range t.Posts {
+		loadBacklinks(p, db)
+	}
+	form := renderPostForm(prefix, newsgroup, root, allowFiles)
+	self.writeBoardTemplate(newsgroup, "thread.mustache", map[string]interface{}{"thread": t, "board": page, "form": form, "pagination": pagination, "inline_media": inlineMedia(newsgroup, db)}, wr)
+}
+
 // change the directory we are using for templates
 func (self *templateEngine) changeTemplateDir(dirname string) {
 	log.Println("change template directory to", dirname)
+	self.templates_mtx.Lock()
 	self.template_dir = dirname
-	self.reloadAllTemplates()
+	// loaded from the old directory
+	self.templates = make(map[string]string)
+	self.mtimes = make(map[string]time.Time)
+	self.templates_mtx.Unlock()
+}
+
+// change the directory we look for per newsgroup template overrides in, empty for none
+func (self *templateEngine) changeBoardTemplateDir(dirname string) {
+	if len(dirname) > 0 {
+		log.Println("per newsgroup template overrides in", dirname)
+	}
+	self.board_template_dir = dirname
 }
 
 func (self *templateEngine) createNotFoundHandler(prefix, frontend string) (h http.Handler) {
@@ -517,6 +776,7 @@ func newTemplateEngine(dir string) *templateEngine {
 	return &templateEngine{
 		groups:       make(map[string]GroupModel),
 		templates:    make(map[string]string),
+		mtimes:       make(map[string]time.Time),
 		template_dir: dir,
 		links:        make(map[string]string),
 		links_short:  make(map[string]string),
@@ -525,13 +785,27 @@ func newTemplateEngine(dir string) *templateEngine {
 
 var template = newTemplateEngine(defaultTemplateDir())
 
+// does the board play audio and video in the page?
+func inlineMedia(newsgroup string, db Database) bool {
+	settings, _ := db.GetBoardSettings(newsgroup)
+	return settings.InlineMedia
+}
+
 func renderPostForm(prefix, board, op_msg_id string, files bool) string {
+	if template.ReadOnly {
+		return ""
+	}
+	return guardPostForm(template.renderTemplate("postform.mustache", postFormParam(prefix, board, op_msg_id, files)), prefix, "")
+}
+
+// template param for a post form that nobody filled in yet
+func postFormParam(prefix, board, op_msg_id string, files bool) map[string]interface{} {
 	url := prefix + "post/" + board
 	button := "New Thread"
 	if op_msg_id != "" {
 		button = "Reply"
 	}
-	return template.renderTemplate("postform.mustache", map[string]interface{}{"post_url": url, "reference": op_msg_id, "button": button, "files": files, "prefix": prefix})
+	return map[string]interface{}{"post_url": url, "reference": op_msg_id, "button": button, "files": files, "prefix": prefix, "honeypot": postHoneypotField, "token_field": postTokenField, "board": board}
 }
 
 // generate misc graphs
@@ -583,10 +857,12 @@ func (self *templateEngine) genFrontPage(top_count int, prefix, frontend_name st
 		// posts total
 		all := db.CountPostsInGroup(group, 0)
 		frontpage_graph = append(frontpage_graph, boardPageRow{
-			All:   all,
-			Day:   day,
-			Hour:  hour,
-			Board: group,
+			All:      all,
+			Day:      day,
+			Hour:     hour,
+			Board:    group,
+			Threads:  db.CountThreadsInGroup(group),
+			LastPost: db.GetLastPostTimeInGroup(group),
 		})
 	}
 
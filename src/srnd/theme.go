@@ -0,0 +1,130 @@
+//
+// theme.go -- css themes readers pick for themselves, kept in a cookie
+//
+
+package srnd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cookie holding the reader's theme
+const themeCookie = "theme"
+
+// return true if name can be a theme, themes are css files named name.css
+func validThemeName(name string) bool {
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// where we look for themes, operator themes in the webroot win over shipped ones
+func (self *httpFrontend) themeDirs() []string {
+	return []string{
+		filepath.Join(self.webroot_dir, "themes"),
+		filepath.Join(self.static_dir, "static", "themes"),
+	}
+}
+
+// names of every theme we have
+func (self *httpFrontend) themes() (themes []string) {
+	seen := make(map[string]bool)
+	for _, dir := range self.themeDirs() {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			name := strings.TrimSuffix(f.Name(), ".css")
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".css") && validThemeName(name) && !seen[name] {
+				seen[name] = true
+				themes = append(themes, name)
+			}
+		}
+	}
+	sort.Strings(themes)
+	return
+}
+
+// the css file for a theme, empty if we don't have it
+func (self *httpFrontend) themeFile(name string) string {
+	if validThemeName(name) {
+		for _, dir := range self.themeDirs() {
+			fpath := filepath.Join(dir, name+".css")
+			if CheckFile(fpath) {
+				return fpath
+			}
+		}
+	}
+	return ""
+}
+
+// the theme the reader picked or the default
+func (self *httpFrontend) readerTheme(r *http.Request) string {
+	c, err := r.Cookie(themeCookie)
+	if err == nil && self.themeFile(c.Value) != "" {
+		return c.Value
+	}
+	return self.defaultTheme
+}
+
+// GET /theme.css
+// the stylesheet of the reader's theme, pages link this so they stay static
+func (self *httpFrontend) handle_theme_css(wr http.ResponseWriter, r *http.Request) {
+	wr.Header().Set("Content-Type", "text/css; charset=utf-8")
+	wr.Header().Set("Cache-Control", "private, no-cache")
+	wr.Header().Set("Vary", "Cookie")
+	fpath := self.themeFile(self.readerTheme(r))
+	if fpath == "" {
+		// no theme, the page's own css is all there is
+		return
+	}
+	http.ServeFile(wr, r, fpath)
+}
+
+// GET /theme lists the themes, GET /theme?name=x picks one and goes back where the reader came from
+func (self *httpFrontend) handle_theme(wr http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		template.writeTemplate("theme.mustache", map[string]interface{}{
+			"prefix":  self.prefix,
+			"themes":  self.themes(),
+			"current": self.readerTheme(r),
+			"i18n":    i18nForRequest(r, ""),
+		}, wr)
+		return
+	}
+	if self.themeFile(name) == "" {
+		wr.WriteHeader(404)
+		template.writeTemplate("404.mustache", map[string]interface{}{"prefix": self.prefix, "i18n": i18nForRequest(r, "")}, wr)
+		return
+	}
+	http.SetCookie(wr, &http.Cookie{
+		Name:   themeCookie,
+		Value:  name,
+		Path:   self.prefix,
+		MaxAge: 365 * 24 * 3600,
+	})
+	redirectBack(wr, r, self.prefix)
+}
+
+// redirect to the page the request came from if it is one of ours, otherwise to fallback
+func redirectBack(wr http.ResponseWriter, r *http.Request, fallback string) {
+	back := fallback
+	u, err := url.Parse(r.Referer())
+	if err == nil && u.Host == requestHost(r) && len(u.Path) > 0 {
+		back = u.RequestURI()
+	}
+	http.Redirect(wr, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,127 @@
+//
+// threadpage.go -- long threads a page of replies at a time
+//
+
+package srnd
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// how many replies /thread/{id}/last links show
+const lastRepliesDefault = 50
+
+// work out which replies are on a page of a thread with total replies and size per page
+// offset is how many of the oldest replies come before the page
+// pages start at 1, ok is false if there is no such page
+func threadPageRange(total, page, size int) (offset, pages int, ok bool) {
+	pages = (total + size - 1) / size
+	if pages < 1 {
+		pages = 1
+	}
+	if page < 1 || page > pages {
+		return
+	}
+	offset = (page - 1) * size
+	ok = true
+	return
+}
+
+// find the root post of a thread by its hash or message-id, nil if we don't have it
+func (self *httpFrontend) threadRoot(id string) PostModel {
+	db := self.daemon.database
+	msgid := id
+	if !ValidMessageID(msgid) {
+		e, err := db.GetMessageIDByHash(id)
+		if err != nil {
+			return nil
+		}
+		msgid = e.MessageID()
+	}
+	root, group, _, err := db.GetInfoForMessage(msgid)
+	if err != nil || group == "ctl" || !db.HasArticleLocal(root) {
+		return nil
+	}
+	return db.GetPostModel(self.prefix, root)
+}
+
+// links between the pages of a thread for the template
+func (self *httpFrontend) threadPagination(id string, page, pages int) map[string]interface{} {
+	var links []LinkModel
+	for p := 1; p <= pages; p++ {
+		links = append(links, linkModel{
+			text: strconv.Itoa(p),
+			link: fmt.Sprintf("%sthread/%s/page/%d", self.prefix, id, p),
+		})
+	}
+	param := map[string]interface{}{
+		"page":  page,
+		"pages": pages,
+		"links": links,
+		"full":  fmt.Sprintf("%sthread-%s.html", self.prefix, id),
+		"last":  fmt.Sprintf("%sthread/%s/last%d", self.prefix, id, lastRepliesDefault),
+	}
+	if page > 1 {
+		param["prev"] = fmt.Sprintf("%sthread/%s/page/%d", self.prefix, id, page-1)
+	}
+	if page > 0 && page < pages {
+		param["next"] = fmt.Sprintf("%sthread/%s/page/%d", self.prefix, id, page+1)
+	}
+	return param
+}
+
+func (self *httpFrontend) threadNotFound(wr http.ResponseWriter, r *http.Request) {
+	wr.WriteHeader(404)
+	template.writeTemplate("404.mustache", map[string]interface{}{"prefix": self.prefix, "i18n": i18nForRequest(r, "")}, wr)
+}
+
+// GET /thread/{id}/page/{page}
+// a page of replies of a long thread instead of all of them
+func (self *httpFrontend) handle_thread_page(wr http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	op := self.threadRoot(vars["id"])
+	if op == nil {
+		self.threadNotFound(wr, r)
+		return
+	}
+	page, _ := strconv.Atoi(vars["page"])
+	db := self.daemon.database
+	total := int(db.CountThreadReplies(op.MessageID()))
+	offset, pages, ok := threadPageRange(total, page, self.threadPageSize)
+	if !ok {
+		self.threadNotFound(wr, r)
+		return
+	}
+	replies := db.GetThreadReplyPostModelsPage(self.prefix, op.MessageID(), offset, self.threadPageSize)
+	id := HashMessageID(op.MessageID())
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Cache-Control", pageCacheControl)
+	template.genThreadPage(self.attachments, op, replies, offset, total, self.prefix, self.name, self.threadPagination(id, page, pages), wr, db)
+}
+
+// GET /thread/{id}/last{n}
+// only the newest n replies of a thread
+func (self *httpFrontend) handle_thread_last(wr http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	op := self.threadRoot(vars["id"])
+	if op == nil {
+		self.threadNotFound(wr, r)
+		return
+	}
+	n, _ := strconv.Atoi(vars["n"])
+	if n < 1 || n > self.threadPageSize && n > lastRepliesDefault {
+		self.threadNotFound(wr, r)
+		return
+	}
+	db := self.daemon.database
+	total := int(db.CountThreadReplies(op.MessageID()))
+	replies := db.GetThreadReplyPostModels(self.prefix, op.MessageID(), 0, n)
+	_, pages, _ := threadPageRange(total, 1, self.threadPageSize)
+	id := HashMessageID(op.MessageID())
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Cache-Control", pageCacheControl)
+	template.genThreadPage(self.attachments, op, replies, total-len(replies), total, self.prefix, self.name, self.threadPagination(id, 0, pages), wr, db)
+}
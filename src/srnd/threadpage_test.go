@@ -0,0 +1,24 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestThreadPageRange(t *testing.T) {
+
+	offset, pages, ok := threadPageRange(250, 1, 100)
+	if !ok || offset != 0 || pages != 3 {
+		t.Error("first page should start at the oldest reply", offset, pages, ok)
+	}
+	offset, _, ok = threadPageRange(250, 3, 100)
+	if !ok || offset != 200 {
+		t.Error("last page should start after the first two", offset, ok)
+	}
+	if _, _, ok = threadPageRange(250, 4, 100); ok {
+		t.Error("should not have pages past the end")
+	}
+	if _, pages, ok = threadPageRange(0, 1, 100); !ok || pages != 1 {
+		t.Error("threads without replies should have one page", pages, ok)
+	}
+
+}
@@ -4,8 +4,17 @@
 package srnd
 
 import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // worker for thumbnailer tool
@@ -28,23 +37,24 @@ func rethumb(chnl chan string, store ArticleStore) {
 // run thumbnailer with 4 threads
 func ThumbnailTool() {
 	conf := ReadConfig()
-	if conf == nil {
-		log.Println("cannot load config, ReadConfig() returned nil")
-		return
-	}
-	store := createArticleStore(conf.store, nil)
+	store := createArticleStore(conf.store, nil, nil, nil, nil, nil)
 	reThumbnail(4, store)
 }
 
-func RegenTool() {
-	conf := ReadConfig()
+// connect to the database in config for a tool
+func toolDatabase(conf *SRNdConfig) Database {
 	db_host := conf.database["host"]
 	db_port := conf.database["port"]
 	db_user := conf.database["user"]
 	db_passwd := conf.database["password"]
 	db_type := conf.database["type"]
 	db_sche := conf.database["schema"]
-	db := NewDatabase(db_type, db_sche, db_host, db_port, db_user, db_passwd)
+	return NewDatabase(db_type, db_sche, db_host, db_port, db_user, db_passwd)
+}
+
+func RegenTool() {
+	conf := ReadConfig()
+	db := toolDatabase(conf)
 	groups := db.GetAllNewsgroups()
 	if groups != nil {
 		for _, group := range groups {
@@ -79,9 +89,254 @@ func reThumbnail(threads int, store ArticleStore) {
 	log.Println("Rethumbnailing done")
 }
 
+// does an attachment need its thumbnail made again?
+// it does if there is none, if the attachment changed since, or if we were told to redo them all
+func thumbnailStale(attachment, thumbnail os.FileInfo, force bool) bool {
+	if force || thumbnail == nil {
+		return true
+	}
+	return thumbnail.ModTime().Before(attachment.ModTime())
+}
+
+// regenerate missing and outdated thumbnails, or all of them with -force after changing how they are made
+// usage: [-force] [-workers n]
+func RebuildThumbnailsTool(args []string) {
+	flags := flag.NewFlagSet("rebuild-thumbnails", flag.ExitOnError)
+	force := flags.Bool("force", false, "regenerate every thumbnail, not just missing or outdated ones")
+	workers := flags.Int("workers", runtime.NumCPU(), "how many thumbnails to make at once")
+	flags.Parse(args)
+	if *workers < 1 {
+		*workers = 1
+	}
+	conf := ReadConfig()
+	store := createArticleStore(conf.store, nil, nil, nil, nil, nil)
+	files, err := store.GetAllAttachments()
+	if err != nil {
+		log.Println("failed to read attachment directory", err)
+		return
+	}
+	var todo []string
+	for _, fname := range files {
+		att, err := os.Stat(store.AttachmentFilepath(fname))
+		if err != nil || !att.Mode().IsRegular() {
+			continue
+		}
+		thm, _ := os.Stat(store.ThumbnailFilepath(fname))
+		if thumbnailStale(att, thm, *force) {
+			todo = append(todo, fname)
+		}
+	}
+	log.Println(len(todo), "of", len(files), "attachments need thumbnails, using", *workers, "workers")
+	var done, failed int64
+	chnl := make(chan string)
+	var wg sync.WaitGroup
+	for n := 0; n < *workers; n++ {
+		wg.Add(1)
+		go func() {
+			for fname := range chnl {
+				// ffmpeg won't write over the old one
+				DelFile(store.ThumbnailFilepath(fname))
+				if store.GenerateThumbnail(fname) != nil {
+					atomic.AddInt64(&failed, 1)
+				}
+				atomic.AddInt64(&done, 1)
+			}
+			wg.Done()
+		}()
+	}
+	ticker := time.NewTicker(time.Second * 5)
+	go func() {
+		for range ticker.C {
+			log.Printf("rebuilt %d of %d thumbnails, %d failed", atomic.LoadInt64(&done), len(todo), atomic.LoadInt64(&failed))
+		}
+	}()
+	for _, fname := range todo {
+		chnl <- fname
+	}
+	close(chnl)
+	wg.Wait()
+	ticker.Stop()
+	log.Printf("rebuilt %d thumbnails, %d failed", done, failed)
+}
+
+// an article in the spool to put back in the database
+type reindexEntry struct {
+	msgid  string
+	root   bool
+	posted int64
+}
+
+// order articles so every thread is there before its replies, oldest first
+func sortReindex(entries []reindexEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].root != entries[j].root {
+			return entries[i].root
+		}
+		return entries[i].posted < entries[j].posted
+	})
+}
+
+// put every article in store_dir the database doesn't have back into it
+// for recovering from a lost or broken database, start from an empty one to rebuild everything
+func ReindexTool() {
+	conf := ReadConfig()
+	db := toolDatabase(conf)
+	defer db.Close()
+	db.CreateTables()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	f, err := os.Open(conf.store["store_dir"])
+	if err != nil {
+		log.Println("failed to open article spool", err)
+		return
+	}
+	names, err := f.Readdirnames(0)
+	f.Close()
+	if err != nil {
+		log.Println("failed to read article spool", err)
+		return
+	}
+	var entries []reindexEntry
+	for _, name := range names {
+		if !ValidMessageID(name) || db.HasArticle(name) || db.ArticleBanned(name) {
+			continue
+		}
+		hdr := store.GetHeaders(name)
+		if hdr == nil {
+			continue
+		}
+		ref := hdr.Get("References", "")
+		posted, _ := time.Parse(time.RFC1123Z, hdr.Get("Date", ""))
+		entries = append(entries, reindexEntry{msgid: name, root: ref == "" || ref == name, posted: posted.Unix()})
+	}
+	sortReindex(entries)
+	log.Println(len(entries), "of", len(names), "articles in the spool are missing from the database")
+	var failed int
+	for idx, e := range entries {
+		nntp := store.GetMessage(e.msgid)
+		if nntp == nil {
+			failed++
+			continue
+		}
+		err = db.RegisterArticle(nntp)
+		if err == nil && nntp.Pubkey() != "" {
+			err = db.RegisterSigned(e.msgid, nntp.Pubkey())
+		}
+		if err != nil {
+			log.Println("failed to reindex", e.msgid, err)
+			failed++
+		}
+		if (idx+1)%1000 == 0 {
+			log.Printf("reindexed %d of %d articles, %d failed", idx+1, len(entries), failed)
+		}
+	}
+	log.Printf("reindexed %d articles, %d failed", len(entries)-failed, failed)
+}
+
 // generate a keypair from the command line
-func KeygenTool() {
+// with a file name the secret key is written there instead of shown
+func KeygenTool(args ...string) {
 	pub, sec := newSignKeypair()
 	log.Println("public key:", pub)
+	if len(args) > 0 {
+		err := ioutil.WriteFile(args[0], []byte(sec+"\n"), 0600)
+		if err != nil {
+			log.Println("failed to save secret key", err)
+			return
+		}
+		log.Println("secret key saved to", args[0])
+		return
+	}
 	log.Println("secret key:", sec)
 }
+
+// make a key a mod or not, for a newsgroup or everywhere, right in the database
+// usage: grant|revoke|admin|unadmin|show pubkey [newsgroup]
+// so the first admin can be set up without crafting ctl messages by hand
+func ModKeyTool(action, pubkey, group string) (err error) {
+	if !validModKey(pubkey) {
+		return errors.New("bad public key " + pubkey)
+	}
+	if group != "" && !newsgroupValidFormat(group) {
+		return errors.New("bad newsgroup " + group)
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	switch action {
+	case "grant":
+		if group == "" {
+			err = db.MarkModPubkeyGlobal(pubkey)
+		} else {
+			err = db.MarkModPubkeyCanModGroup(pubkey, group)
+		}
+	case "revoke":
+		if group == "" {
+			err = db.UnMarkModPubkeyGlobal(pubkey)
+		} else {
+			err = db.UnMarkModPubkeyCanModGroup(pubkey, group)
+		}
+	case "admin":
+		err = db.MarkPubkeyAdmin(pubkey)
+	case "unadmin":
+		err = db.UnmarkPubkeyAdmin(pubkey)
+	case "show":
+		admin, _ := db.CheckAdminPubkey(pubkey)
+		log.Println("admin:", admin)
+		log.Println("global mod:", db.CheckModPubkeyGlobal(pubkey))
+		if group != "" {
+			log.Println("mod of", group+":", db.CheckModPubkeyCanModGroup(pubkey, group))
+		}
+		return
+	default:
+		return errors.New("no such action " + action)
+	}
+	if err == nil {
+		where := "everywhere"
+		if group != "" {
+			where = group
+		}
+		log.Println(action, pubkey, where)
+	}
+	return
+}
+
+// is this a hex ed25519 public key?
+func validModKey(pubkey string) bool {
+	data, err := hex.DecodeString(pubkey)
+	return err == nil && len(data) == 32
+}
+
+// train the spam filter on articles in the store or score them
+// usage: spam|ham|score message-id ...
+func SpamTool(action string, msgids []string) {
+	conf := ReadConfig()
+	filter := spamFilterFromConfig(conf)
+	if filter == nil {
+		log.Println("spam filter is not enabled in srnd.ini")
+		return
+	}
+	store := createArticleStore(conf.store, nil, nil, nil, nil, nil)
+	for _, msgid := range msgids {
+		nntp := store.GetMessage(msgid)
+		if nntp == nil {
+			log.Println("no such article", msgid)
+			continue
+		}
+		if action == "score" {
+			spam, score := filter.IsSpam(nntp)
+			log.Printf("%s score=%.3f spam=%v", msgid, score, spam)
+		} else {
+			filter.Train(spamText(nntp), action == "spam")
+			log.Println("trained", action, "on", msgid)
+		}
+	}
+	if action != "score" {
+		err := filter.Save()
+		if err != nil {
+			log.Println("failed to save spam filter", err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThumbnailStale(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	att := filepath.Join(dir, "att.png")
+	thm := filepath.Join(dir, "thm.jpg")
+	ioutil.WriteFile(att, []byte("a"), 0600)
+	ioutil.WriteFile(thm, []byte("t"), 0600)
+	now := time.Now()
+	os.Chtimes(att, now, now)
+	os.Chtimes(thm, now.Add(time.Minute), now.Add(time.Minute))
+	attInfo, _ := os.Stat(att)
+	thmInfo, _ := os.Stat(thm)
+	if thumbnailStale(attInfo, thmInfo, false) {
+		t.Error("a newer thumbnail is not stale")
+	}
+	if !thumbnailStale(attInfo, thmInfo, true) {
+		t.Error("force should redo every thumbnail")
+	}
+	if !thumbnailStale(attInfo, nil, false) {
+		t.Error("a missing thumbnail is stale")
+	}
+	os.Chtimes(att, now.Add(time.Hour), now.Add(time.Hour))
+	attInfo, _ = os.Stat(att)
+	if !thumbnailStale(attInfo, thmInfo, false) {
+		t.Error("a thumbnail older than its attachment is stale")
+	}
+
+}
+
+func TestSortReindex(t *testing.T) {
+
+	entries := []reindexEntry{
+		{msgid: "<reply2@test>", posted: 30},
+		{msgid: "<op2@test>", root: true, posted: 20},
+		{msgid: "<reply1@test>", posted: 15},
+		{msgid: "<op1@test>", root: true, posted: 10},
+	}
+	sortReindex(entries)
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.msgid)
+	}
+	if strings.Join(order, " ") != "<op1@test> <op2@test> <reply1@test> <reply2@test>" {
+		t.Error("threads should come before replies, oldest first", order)
+	}
+
+}
@@ -0,0 +1,216 @@
+//
+// tor.go -- tor control port integration
+//
+
+package srnd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+var TorAuthFailed = errors.New("tor control port authentication failed")
+var TorNoAuthMethod = errors.New("no usable tor control port authentication method")
+
+// tor control port settings
+type TorConfig struct {
+	enable bool
+	// address of the tor control port
+	control string
+	// password for HASHEDPASSWORD auth, empty for cookie / null auth
+	password string
+	// file we persist our onion service private key in
+	keyfile string
+	// file we write our onion hostname to
+	hostfile string
+	// the virtual port our onion service listens on
+	port string
+}
+
+// a connection to the tor control port that holds our onion service open
+type torController struct {
+	conn *textproto.Conn
+	// our onion address without the .onion suffix
+	serviceID string
+}
+
+// send a command to the control port
+// return all reply lines on 250 otherwise an error
+func (self *torController) command(format string, args ...interface{}) (lines []string, err error) {
+	err = self.conn.PrintfLine(format, args...)
+	if err != nil {
+		return
+	}
+	for {
+		var line string
+		line, err = self.conn.ReadLine()
+		if err != nil {
+			return
+		}
+		if len(line) < 4 {
+			err = errors.New("short reply from tor control port: " + line)
+			return
+		}
+		if line[:3] != "250" {
+			err = errors.New("tor control port: " + line)
+			return
+		}
+		lines = append(lines, line[4:])
+		if line[3] == ' ' {
+			// last line of reply
+			return
+		}
+	}
+}
+
+// authenticate with the control port using the first method we can
+func (self *torController) authenticate(password string) (err error) {
+	var lines []string
+	lines, err = self.command("PROTOCOLINFO 1")
+	if err != nil {
+		return
+	}
+	var methods, cookiefile string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "AUTH ") {
+			for _, part := range strings.Split(line[5:], " ") {
+				if strings.HasPrefix(part, "METHODS=") {
+					methods = part[8:]
+				} else if strings.HasPrefix(part, "COOKIEFILE=") {
+					cookiefile = strings.Trim(part[11:], "\"")
+				}
+			}
+		}
+	}
+	has := func(method string) bool {
+		for _, m := range strings.Split(methods, ",") {
+			if m == method {
+				return true
+			}
+		}
+		return false
+	}
+	if len(password) > 0 && has("HASHEDPASSWORD") {
+		_, err = self.command("AUTHENTICATE \"%s\"", strings.Replace(password, "\"", "\\\"", -1))
+	} else if has("COOKIE") && len(cookiefile) > 0 {
+		var cookie []byte
+		cookie, err = ioutil.ReadFile(cookiefile)
+		if err == nil {
+			_, err = self.command("AUTHENTICATE %s", hex.EncodeToString(cookie))
+		}
+	} else if has("NULL") {
+		_, err = self.command("AUTHENTICATE")
+	} else {
+		err = TorNoAuthMethod
+	}
+	if err != nil && err != TorNoAuthMethod {
+		log.Println("tor:", err)
+		err = TorAuthFailed
+	}
+	return
+}
+
+// create our onion service forwarding to target
+// reuses the private key in keyfile if it exists and saves a new one if it does not
+func (self *torController) addOnion(port, target, keyfile string) (err error) {
+	keyblob := "NEW:ED25519-V3"
+	var data []byte
+	data, err = ioutil.ReadFile(keyfile)
+	if err == nil {
+		keyblob = strings.Trim(string(data), "\r\n ")
+	} else if os.IsNotExist(err) {
+		err = nil
+	} else {
+		return
+	}
+	var lines []string
+	lines, err = self.command("ADD_ONION %s Port=%s,%s", keyblob, port, target)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ServiceID=") {
+			self.serviceID = line[10:]
+		} else if strings.HasPrefix(line, "PrivateKey=") {
+			// new key was made, persist it
+			err = ioutil.WriteFile(keyfile, []byte(line[11:]+"\n"), 0600)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if self.serviceID == "" {
+		err = errors.New("tor did not give us a service id")
+	}
+	return
+}
+
+// the full .onion hostname of our service
+func (self *torController) Hostname() string {
+	return self.serviceID + ".onion"
+}
+
+// close the control connection, this removes our onion service
+func (self *torController) Close() {
+	if self.conn != nil {
+		self.conn.PrintfLine("QUIT")
+		self.conn.Close()
+		self.conn = nil
+	}
+}
+
+// connect to the tor control port and set up an onion service that forwards to our nntp bind
+// the control connection must be kept open for the lifetime of the service
+// a unix socket bind is forwarded to as unix:/path, on the port from the tor section or 119
+func setupTorOnion(cfg *TorConfig, bind string) (ctl *torController, err error) {
+	var target, port string
+	if isUnixBind(bind) {
+		target = bind
+		port = "119"
+	} else {
+		var host string
+		host, port, err = net.SplitHostPort(bind)
+		if err != nil {
+			return
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			// wildcard bind, forward to loopback
+			host = "127.0.0.1"
+		}
+		target = net.JoinHostPort(host, port)
+	}
+	vport := cfg.port
+	if vport == "" {
+		vport = port
+	}
+	var c net.Conn
+	c, err = net.Dial("tcp", cfg.control)
+	if err != nil {
+		return
+	}
+	ctl = &torController{
+		conn: textproto.NewConn(c),
+	}
+	err = ctl.authenticate(cfg.password)
+	if err == nil {
+		err = ctl.addOnion(vport, target, cfg.keyfile)
+	}
+	if err == nil {
+		log.Printf("tor onion service for nntp at %s port %s", ctl.Hostname(), vport)
+		if cfg.hostfile != "" {
+			err = ioutil.WriteFile(cfg.hostfile, []byte(fmt.Sprintf("%s\n", ctl.Hostname())), 0644)
+		}
+	}
+	if err != nil {
+		ctl.Close()
+		ctl = nil
+	}
+	return
+}
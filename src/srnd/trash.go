@@ -0,0 +1,136 @@
+//
+// trash.go -- keep deleted articles around for a while so mods can undo deletes
+//
+
+package srnd
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// returned by the store when it has no trash and files are deleted for good
+var NoTrash = errors.New("no trash")
+
+// where each file of a trashed article lives and where it goes in the trash
+func trashPaths(store ArticleStore, trash, msgid string, atts []string) (live, trashed []string) {
+	live = append(live, store.GetFilename(msgid))
+	trashed = append(trashed, filepath.Join(trash, "articles", msgid))
+	for _, att := range atts {
+		live = append(live, store.AttachmentFilepath(att), store.ThumbnailFilepath(att))
+		trashed = append(trashed, filepath.Join(trash, "img", att), filepath.Join(trash, "thm", att+".jpg"))
+	}
+	return
+}
+
+// get rid of the files of an article, into the trash if there is one so it can be restored
+func discardArticle(db Database, store ArticleStore, msgid, newsgroup string) {
+	atts := db.GetPostAttachments(msgid)
+	err := store.TrashArticle(msgid, atts)
+	if err == nil {
+		root, _, _, _ := db.GetInfoForMessage(msgid)
+		err = db.TrashArticle(msgid, newsgroup, root, atts)
+		if err != nil {
+			log.Println("failed to record trashed article", msgid, err)
+		}
+		return
+	}
+	if err != NoTrash {
+		log.Println("failed to trash", msgid, "deleting it instead", err)
+	}
+	live, _ := trashPaths(store, "", msgid, atts)
+	for _, f := range live {
+		log.Printf("delete file: %s", f)
+		os.Remove(f)
+	}
+}
+
+// bring back a trashed article like it just came in
+func (self *NNTPDaemon) restoreTrashed(t TrashedArticle) (err error) {
+	err = self.store.RestoreArticle(t.MessageID, t.Attachments)
+	if err == nil {
+		err = self.database.UnbanArticle(t.MessageID)
+	}
+	if err != nil {
+		return
+	}
+	nntp := self.store.GetMessage(t.MessageID)
+	if nntp == nil {
+		return errors.New("cannot load restored article " + t.MessageID)
+	}
+	// puts back thread and newsgroup entries
+	err = self.database.RegisterArticle(nntp)
+	if err == nil {
+		err = self.database.ForgetTrashedArticle(t.MessageID)
+	}
+	if err == nil {
+		self.loadFromInfeed(t.MessageID)
+	}
+	return
+}
+
+// restore the trashed thread of msgid or every trashed article in newsgroup, roots before replies
+func (self *NNTPDaemon) restoreFromTrash(msgid, newsgroup string) (restored []string, err error) {
+	var trashed []TrashedArticle
+	trashed, err = self.database.GetTrashedArticles()
+	if err != nil {
+		return
+	}
+	for _, roots := range []bool{true, false} {
+		for _, t := range trashed {
+			if t.IsRoot() != roots {
+				continue
+			}
+			if (msgid != "" && t.MessageID != msgid && t.Root != msgid) || (newsgroup != "" && t.Newsgroup != newsgroup) {
+				continue
+			}
+			err = self.restoreTrashed(t)
+			if err != nil {
+				return
+			}
+			restored = append(restored, t.MessageID)
+		}
+	}
+	return
+}
+
+// delete everything trashed before a time for good
+func (self *NNTPDaemon) emptyTrash(before time.Time) (emptied int) {
+	trashed, err := self.database.GetTrashedArticles()
+	if err != nil {
+		log.Println("failed to get trashed articles", err)
+		return
+	}
+	for _, t := range trashed {
+		if t.Trashed >= before.Unix() {
+			// oldest first
+			break
+		}
+		self.store.EmptyTrash(t.MessageID, t.Attachments)
+		err = self.database.ForgetTrashedArticle(t.MessageID)
+		if err == nil {
+			emptied++
+		} else {
+			log.Println("failed to forget trashed article", t.MessageID, err)
+		}
+	}
+	return
+}
+
+// empty the trash of articles older than trash_hours every hour forever
+func (self *NNTPDaemon) trashMainloop() {
+	for {
+		hours := atomic.LoadInt64(&self.trash_hours)
+		if hours > 0 {
+			n := self.emptyTrash(time.Now().Add(-time.Duration(hours) * time.Hour))
+			if n > 0 {
+				log.Println("emptied", n, "articles from trash")
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,230 @@
+//
+// tripcode.go -- classic and secure chan tripcodes
+//
+
+package srnd
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"strings"
+)
+
+// des tables for crypt(3), 1 indexed like every des reference
+var (
+	desIP = [64]byte{
+		58, 50, 42, 34, 26, 18, 10, 2, 60, 52, 44, 36, 28, 20, 12, 4,
+		62, 54, 46, 38, 30, 22, 14, 6, 64, 56, 48, 40, 32, 24, 16, 8,
+		57, 49, 41, 33, 25, 17, 9, 1, 59, 51, 43, 35, 27, 19, 11, 3,
+		61, 53, 45, 37, 29, 21, 13, 5, 63, 55, 47, 39, 31, 23, 15, 7,
+	}
+	desFP = [64]byte{
+		40, 8, 48, 16, 56, 24, 64, 32, 39, 7, 47, 15, 55, 23, 63, 31,
+		38, 6, 46, 14, 54, 22, 62, 30, 37, 5, 45, 13, 53, 21, 61, 29,
+		36, 4, 44, 12, 52, 20, 60, 28, 35, 3, 43, 11, 51, 19, 59, 27,
+		34, 2, 42, 10, 50, 18, 58, 26, 33, 1, 41, 9, 49, 17, 57, 25,
+	}
+	desPC1C = [28]byte{
+		57, 49, 41, 33, 25, 17, 9, 1, 58, 50, 42, 34, 26, 18,
+		10, 2, 59, 51, 43, 35, 27, 19, 11, 3, 60, 52, 44, 36,
+	}
+	desPC1D = [28]byte{
+		63, 55, 47, 39, 31, 23, 15, 7, 62, 54, 46, 38, 30, 22,
+		14, 6, 61, 53, 45, 37, 29, 21, 13, 5, 28, 20, 12, 4,
+	}
+	desShifts = [16]int{1, 1, 2, 2, 2, 2, 2, 2, 1, 2, 2, 2, 2, 2, 2, 1}
+	desPC2C   = [24]byte{
+		14, 17, 11, 24, 1, 5, 3, 28, 15, 6, 21, 10,
+		23, 19, 12, 4, 26, 8, 16, 7, 27, 20, 13, 2,
+	}
+	desPC2D = [24]byte{
+		41, 52, 31, 37, 47, 55, 30, 40, 51, 45, 33, 48,
+		44, 49, 39, 56, 34, 53, 46, 42, 50, 36, 29, 32,
+	}
+	desE = [48]byte{
+		32, 1, 2, 3, 4, 5, 4, 5, 6, 7, 8, 9,
+		8, 9, 10, 11, 12, 13, 12, 13, 14, 15, 16, 17,
+		16, 17, 18, 19, 20, 21, 20, 21, 22, 23, 24, 25,
+		24, 25, 26, 27, 28, 29, 28, 29, 30, 31, 32, 1,
+	}
+	desP = [32]byte{
+		16, 7, 20, 21, 29, 12, 28, 17, 1, 15, 23, 26, 5, 18, 31, 10,
+		2, 8, 24, 14, 32, 27, 3, 9, 19, 13, 30, 6, 22, 11, 4, 25,
+	}
+	desS = [8][64]byte{
+		{14, 4, 13, 1, 2, 15, 11, 8, 3, 10, 6, 12, 5, 9, 0, 7,
+			0, 15, 7, 4, 14, 2, 13, 1, 10, 6, 12, 11, 9, 5, 3, 8,
+			4, 1, 14, 8, 13, 6, 2, 11, 15, 12, 9, 7, 3, 10, 5, 0,
+			15, 12, 8, 2, 4, 9, 1, 7, 5, 11, 3, 14, 10, 0, 6, 13},
+		{15, 1, 8, 14, 6, 11, 3, 4, 9, 7, 2, 13, 12, 0, 5, 10,
+			3, 13, 4, 7, 15, 2, 8, 14, 12, 0, 1, 10, 6, 9, 11, 5,
+			0, 14, 7, 11, 10, 4, 13, 1, 5, 8, 12, 6, 9, 3, 2, 15,
+			13, 8, 10, 1, 3, 15, 4, 2, 11, 6, 7, 12, 0, 5, 14, 9},
+		{10, 0, 9, 14, 6, 3, 15, 5, 1, 13, 12, 7, 11, 4, 2, 8,
+			13, 7, 0, 9, 3, 4, 6, 10, 2, 8, 5, 14, 12, 11, 15, 1,
+			13, 6, 4, 9, 8, 15, 3, 0, 11, 1, 2, 12, 5, 10, 14, 7,
+			1, 10, 13, 0, 6, 9, 8, 7, 4, 15, 14, 3, 11, 5, 2, 12},
+		{7, 13, 14, 3, 0, 6, 9, 10, 1, 2, 8, 5, 11, 12, 4, 15,
+			13, 8, 11, 5, 6, 15, 0, 3, 4, 7, 2, 12, 1, 10, 14, 9,
+			10, 6, 9, 0, 12, 11, 7, 13, 15, 1, 3, 14, 5, 2, 8, 4,
+			3, 15, 0, 6, 10, 1, 13, 8, 9, 4, 5, 11, 12, 7, 2, 14},
+		{2, 12, 4, 1, 7, 10, 11, 6, 8, 5, 3, 15, 13, 0, 14, 9,
+			14, 11, 2, 12, 4, 7, 13, 1, 5, 0, 15, 10, 3, 9, 8, 6,
+			4, 2, 1, 11, 10, 13, 7, 8, 15, 9, 12, 5, 6, 3, 0, 14,
+			11, 8, 12, 7, 1, 14, 2, 13, 6, 15, 0, 9, 10, 4, 5, 3},
+		{12, 1, 10, 15, 9, 2, 6, 8, 0, 13, 3, 4, 14, 7, 5, 11,
+			10, 15, 4, 2, 7, 12, 9, 5, 6, 1, 13, 14, 0, 11, 3, 8,
+			9, 14, 15, 5, 2, 8, 12, 3, 7, 0, 4, 10, 1, 13, 11, 6,
+			4, 3, 2, 12, 9, 5, 15, 10, 11, 14, 1, 7, 6, 0, 8, 13},
+		{4, 11, 2, 14, 15, 0, 8, 13, 3, 12, 9, 7, 5, 10, 6, 1,
+			13, 0, 11, 7, 4, 9, 1, 10, 14, 3, 5, 12, 2, 15, 8, 6,
+			1, 4, 11, 13, 12, 3, 7, 14, 10, 15, 6, 8, 0, 5, 9, 2,
+			6, 11, 13, 8, 1, 4, 10, 7, 9, 5, 0, 15, 14, 2, 3, 12},
+		{13, 2, 8, 4, 6, 15, 11, 1, 10, 9, 3, 14, 5, 0, 12, 7,
+			1, 15, 13, 8, 10, 3, 7, 4, 12, 5, 6, 11, 0, 14, 9, 2,
+			7, 11, 4, 1, 9, 12, 14, 2, 0, 6, 10, 13, 15, 3, 5, 8,
+			2, 1, 14, 7, 4, 10, 8, 13, 15, 12, 9, 0, 3, 5, 6, 11},
+	}
+)
+
+// traditional unix des crypt(3) of key with a 2 character salt
+// works on one bit per byte like the v7 implementation, we only do this once per post
+func desCrypt(key, salt string) string {
+	// 7 bits of each of the first 8 characters make the key
+	var block [66]byte
+	for i, c := 0, 0; c < len(key) && i < 64; c++ {
+		for j := uint(0); j < 7; j++ {
+			block[i] = (key[c] >> (6 - j)) & 1
+			i++
+		}
+		i++
+	}
+	// key schedule
+	var C, D [28]byte
+	var KS [16][48]byte
+	for i := 0; i < 28; i++ {
+		C[i] = block[desPC1C[i]-1]
+		D[i] = block[desPC1D[i]-1]
+	}
+	for i := 0; i < 16; i++ {
+		for k := 0; k < desShifts[i]; k++ {
+			c, d := C[0], D[0]
+			copy(C[:], C[1:])
+			copy(D[:], D[1:])
+			C[27], D[27] = c, d
+		}
+		for j := 0; j < 24; j++ {
+			KS[i][j] = C[desPC2C[j]-1]
+			KS[i][j+24] = D[desPC2D[j]-28-1]
+		}
+	}
+	// the salt perturbs the expansion
+	E := desE
+	out := make([]byte, 13)
+	for i := 0; i < 2; i++ {
+		c := byte('.')
+		if i < len(salt) {
+			c = salt[i]
+		}
+		out[i] = c
+		if c > 'Z' {
+			c -= 6
+		}
+		if c > '9' {
+			c -= 7
+		}
+		c -= '.'
+		for j := uint(0); j < 6; j++ {
+			if (c>>j)&1 == 1 {
+				k := 6*i + int(j)
+				E[k], E[k+24] = E[k+24], E[k]
+			}
+		}
+	}
+	// encrypt a block of zeros 25 times
+	for i := range block {
+		block[i] = 0
+	}
+	for n := 0; n < 25; n++ {
+		var LR [64]byte
+		for j := 0; j < 64; j++ {
+			LR[j] = block[desIP[j]-1]
+		}
+		L, R := LR[:32], LR[32:]
+		for i := 0; i < 16; i++ {
+			var tempL [32]byte
+			var preS [48]byte
+			var f [32]byte
+			copy(tempL[:], R)
+			for j := 0; j < 48; j++ {
+				preS[j] = R[E[j]-1] ^ KS[i][j]
+			}
+			for j := 0; j < 8; j++ {
+				t := 6 * j
+				k := desS[j][(preS[t]<<5)|(preS[t+1]<<3)|(preS[t+2]<<2)|(preS[t+3]<<1)|preS[t+4]|(preS[t+5]<<4)]
+				t = 4 * j
+				f[t] = (k >> 3) & 1
+				f[t+1] = (k >> 2) & 1
+				f[t+2] = (k >> 1) & 1
+				f[t+3] = k & 1
+			}
+			for j := 0; j < 32; j++ {
+				R[j] = L[j] ^ f[desP[j]-1]
+			}
+			copy(L, tempL[:])
+		}
+		for j := 0; j < 32; j++ {
+			L[j], R[j] = R[j], L[j]
+		}
+		for j := 0; j < 64; j++ {
+			block[j] = LR[desFP[j]-1]
+		}
+	}
+	// 6 bits per character
+	for i := 0; i < 11; i++ {
+		var c byte
+		for j := 0; j < 6; j++ {
+			c = (c << 1) | block[6*i+j]
+		}
+		c += '.'
+		if c > '9' {
+			c += 7
+		}
+		if c > 'Z' {
+			c += 6
+		}
+		out[i+2] = c
+	}
+	return string(out)
+}
+
+// make a classic 2ch style tripcode for a password, same password gives the same trip on every board
+func classicTripcode(password string) string {
+	salt := []byte((password + "H..")[1:3])
+	for i, c := range salt {
+		if c < '.' || c > 'z' {
+			salt[i] = '.'
+		} else if idx := strings.IndexByte(":;<=>?@[\\]^_`", c); idx >= 0 {
+			salt[i] = "ABCDEFGabcdef"[idx]
+		}
+	}
+	trip := desCrypt(password, string(salt))
+	return "!" + trip[len(trip)-10:]
+}
+
+// make a secure tripcode for a password, only this server's secret can make it
+func secureTripcode(password, secret string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(password))
+	return "!!" + base64.StdEncoding.EncodeToString(mac.Sum(nil))[:10]
+}
+
+// get the tripcode at the end of a poster's name, empty if there is none
+func nameTripcode(name string) string {
+	idx := strings.LastIndex(name, " !")
+	if idx >= 0 {
+		return name[idx+1:]
+	}
+	return ""
+}
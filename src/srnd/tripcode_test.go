@@ -0,0 +1,25 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestTripcode(t *testing.T) {
+
+	if desCrypt("test", "aa") != "aaqPiZY5xR5l." {
+		t.Error("bad crypt", desCrypt("test", "aa"))
+	}
+	if classicTripcode("tea") != "!WokonZwxw2" {
+		t.Error("bad classic tripcode", classicTripcode("tea"))
+	}
+	if classicTripcode("") == "" {
+		t.Error("empty password should still make a tripcode")
+	}
+	if secureTripcode("tea", "a") == secureTripcode("tea", "b") {
+		t.Error("secure tripcode does not depend on the secret")
+	}
+	if nameTripcode("anon !!abcdefghij") != "!!abcdefghij" || nameTripcode("anon") != "" {
+		t.Error("bad tripcode from name")
+	}
+
+}
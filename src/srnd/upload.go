@@ -0,0 +1,410 @@
+//
+// upload.go -- tus style resumable uploads so big files make it over flaky circuits
+//
+
+package srnd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the tus protocol version we speak
+const tusVersion = "1.0.0"
+
+var NoSuchUpload = errors.New("no such upload")
+var UploadNotDone = errors.New("upload is not finished")
+var UploadsFull = errors.New("too many uploads right now, try again later")
+
+// what we know about an upload, kept next to its data
+type uploadInfo struct {
+	Length   int64  `json:"length"`
+	Filename string `json:"filename"`
+	Filetype string `json:"filetype"`
+	Spoiler  bool   `json:"spoiler"`
+	Created  int64  `json:"created"`
+}
+
+type uploadStore struct {
+	access sync.Mutex
+	dir    string
+	// biggest upload we take
+	maxSize int64
+	// most bytes all uploads we hold can add up to, 0 for no limit
+	maxTotal int64
+	// bytes the uploads we hold will have when they are done
+	used int64
+	// how long unfinished and unused uploads are kept
+	expire time.Duration
+	// uploads being written to right now
+	busy map[string]bool
+}
+
+// return true if id can be an upload id
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parse tus Upload-Metadata, comma separated keys and base64 values
+func parseUploadMetadata(str string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(str, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		var value string
+		if len(parts) > 1 {
+			b, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				continue
+			}
+			value = string(b)
+		}
+		meta[parts[0]] = value
+	}
+	return meta
+}
+
+func (self *uploadStore) dataFile(id string) string {
+	return filepath.Join(self.dir, id+".part")
+}
+
+func (self *uploadStore) infoFile(id string) string {
+	return filepath.Join(self.dir, id+".json")
+}
+
+// where a finished upload goes once a post has claimed it
+func (self *uploadStore) takenFile(id string) string {
+	return filepath.Join(self.dir, id+".taken")
+}
+
+// read an upload's info
+func (self *uploadStore) readInfo(id string) (info uploadInfo, err error) {
+	var data []byte
+	data, err = ioutil.ReadFile(self.infoFile(id))
+	if err != nil {
+		err = NoSuchUpload
+		return
+	}
+	err = json.Unmarshal(data, &info)
+	return
+}
+
+// count the uploads left from before we started against the total
+func (self *uploadStore) load() {
+	files, err := ioutil.ReadDir(self.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+		if validUploadID(id) {
+			info, err := self.readInfo(id)
+			if err == nil {
+				self.used += info.Length
+			}
+		}
+	}
+}
+
+// get an upload and how much of it we have
+func (self *uploadStore) Info(id string) (info uploadInfo, offset int64, err error) {
+	if !validUploadID(id) {
+		err = NoSuchUpload
+		return
+	}
+	info, err = self.readInfo(id)
+	if err != nil {
+		return
+	}
+	var st os.FileInfo
+	st, err = os.Stat(self.dataFile(id))
+	if err == nil {
+		offset = st.Size()
+	}
+	return
+}
+
+// start a new upload, returns its id
+func (self *uploadStore) Create(info uploadInfo) (id string, err error) {
+	if info.Length <= 0 || info.Length > self.maxSize {
+		err = errors.New("upload is too big")
+		return
+	}
+	// the whole length is counted now so the disk can't fill up while uploads are half done
+	self.access.Lock()
+	if self.maxTotal > 0 && self.used+info.Length > self.maxTotal {
+		self.access.Unlock()
+		err = UploadsFull
+		return
+	}
+	self.used += info.Length
+	self.access.Unlock()
+	id = randStr(32)
+	info.Created = timeNow()
+	var data []byte
+	data, err = json.Marshal(info)
+	if err == nil {
+		err = ioutil.WriteFile(self.dataFile(id), nil, 0600)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(self.infoFile(id), data, 0600)
+	}
+	if err != nil {
+		os.Remove(self.dataFile(id))
+		self.access.Lock()
+		self.used -= info.Length
+		self.access.Unlock()
+	}
+	return
+}
+
+// add a chunk at offset, returns the new offset
+func (self *uploadStore) Append(id string, offset int64, r io.Reader) (int64, error) {
+	self.access.Lock()
+	if self.busy[id] {
+		self.access.Unlock()
+		return offset, errors.New("upload is busy")
+	}
+	self.busy[id] = true
+	self.access.Unlock()
+	defer func() {
+		self.access.Lock()
+		delete(self.busy, id)
+		self.access.Unlock()
+	}()
+	info, have, err := self.Info(id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != have {
+		return have, errors.New("upload offset does not match")
+	}
+	f, err := os.OpenFile(self.dataFile(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return have, err
+	}
+	// keep what made it even if the circuit drops halfway
+	n, err := io.Copy(f, io.LimitReader(r, info.Length-have))
+	f.Close()
+	return have + n, err
+}
+
+// remove an upload
+func (self *uploadStore) Remove(id string) {
+	if validUploadID(id) {
+		self.access.Lock()
+		self.forget(id)
+		self.access.Unlock()
+		os.Remove(self.dataFile(id))
+	}
+}
+
+// remove an upload's info and stop counting it, access must be held
+// only the one that removes the info file stops counting it
+func (self *uploadStore) forget(id string) {
+	info, err := self.readInfo(id)
+	if os.Remove(self.infoFile(id)) == nil && err == nil {
+		self.used -= info.Length
+	}
+}
+
+// take a finished upload to attach to a post, it is gone from the store after
+// the data is moved away first so two posts with the same id can't both get it
+func (self *uploadStore) Take(id string) (att postAttachment, err error) {
+	if self == nil || !validUploadID(id) {
+		err = NoSuchUpload
+		return
+	}
+	self.access.Lock()
+	if self.busy[id] {
+		self.access.Unlock()
+		err = UploadNotDone
+		return
+	}
+	info, offset, err := self.Info(id)
+	if err == nil && offset != info.Length {
+		err = UploadNotDone
+	}
+	if err == nil && os.Rename(self.dataFile(id), self.takenFile(id)) != nil {
+		err = NoSuchUpload
+	}
+	if err == nil {
+		self.forget(id)
+	}
+	self.access.Unlock()
+	if err != nil {
+		return
+	}
+	defer os.Remove(self.takenFile(id))
+	var data []byte
+	data, err = ioutil.ReadFile(self.takenFile(id))
+	if err != nil {
+		return
+	}
+	filetype := info.Filetype
+	if filetype == "" {
+		filetype = http.DetectContentType(data)
+	}
+	att = postAttachment{
+		Filename: info.Filename,
+		Filetype: filetype,
+		Filedata: base64.StdEncoding.EncodeToString(data),
+		Spoiler:  info.Spoiler,
+	}
+	return
+}
+
+// remove uploads nobody finished or used in time
+func (self *uploadStore) Run() {
+	if self == nil {
+		return
+	}
+	for {
+		time.Sleep(time.Minute * 10)
+		files, err := ioutil.ReadDir(self.dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if time.Since(f.ModTime()) > self.expire {
+				if id := strings.TrimSuffix(f.Name(), ".json"); validUploadID(id) {
+					self.Remove(id)
+				} else {
+					os.Remove(filepath.Join(self.dir, f.Name()))
+				}
+			}
+		}
+	}
+}
+
+// set the tus headers every upload response has
+func tusHeaders(wr http.ResponseWriter) {
+	wr.Header().Set("Tus-Resumable", tusVersion)
+	wr.Header().Set("Cache-Control", "no-store")
+}
+
+// OPTIONS /upload
+func (self *httpFrontend) handle_upload_options(wr http.ResponseWriter, r *http.Request) {
+	tusHeaders(wr)
+	wr.Header().Set("Tus-Version", tusVersion)
+	wr.Header().Set("Tus-Extension", "creation,termination")
+	wr.Header().Set("Tus-Max-Size", strconv.FormatInt(self.uploads.maxSize, 10))
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// POST /upload with Upload-Length and optionally Upload-Metadata with filename, filetype and spoiler
+func (self *httpFrontend) handle_upload_create(wr http.ResponseWriter, r *http.Request) {
+	tusHeaders(wr)
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		http.Error(wr, "bad Upload-Length", http.StatusBadRequest)
+		return
+	}
+	meta := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	name := filepath.Base(meta["filename"])
+	if name == "." || name == string(filepath.Separator) {
+		name = "file"
+	}
+	id, err := self.uploads.Create(uploadInfo{Length: length, Filename: name, Filetype: meta["filetype"], Spoiler: meta["spoiler"] == "1"})
+	if err == UploadsFull {
+		http.Error(wr, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(wr, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	wr.Header().Set("Location", self.prefix+"upload/"+id)
+	wr.WriteHeader(http.StatusCreated)
+}
+
+// HEAD /upload/{id} tells how much we have so the client knows where to go on
+func (self *httpFrontend) handle_upload_head(wr http.ResponseWriter, r *http.Request) {
+	tusHeaders(wr)
+	info, offset, err := self.uploads.Info(mux.Vars(r)["id"])
+	if err != nil {
+		wr.WriteHeader(http.StatusNotFound)
+		return
+	}
+	wr.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	wr.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	wr.WriteHeader(http.StatusOK)
+}
+
+// PATCH /upload/{id} with Upload-Offset adds a chunk
+func (self *httpFrontend) handle_upload_patch(wr http.ResponseWriter, r *http.Request) {
+	tusHeaders(wr)
+	defer r.Body.Close()
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		wr.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(wr, "bad Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	offset, err = self.uploads.Append(id, offset, r.Body)
+	if err == NoSuchUpload {
+		wr.WriteHeader(http.StatusNotFound)
+		return
+	}
+	wr.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		log.Println("upload", id, "stopped at", offset, err)
+		http.Error(wr, err.Error(), http.StatusConflict)
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /upload/{id}
+func (self *httpFrontend) handle_upload_delete(wr http.ResponseWriter, r *http.Request) {
+	tusHeaders(wr)
+	self.uploads.Remove(mux.Vars(r)["id"])
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+// create the upload store from frontend config, nil if uploads are disabled
+func uploadStoreFromConfig(config map[string]string, tempdir string) *uploadStore {
+	if config["resumable_uploads"] != "1" {
+		return nil
+	}
+	dir := filepath.Join(tempdir, "uploads")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Println("cannot make upload directory, resumable uploads disabled", err)
+		return nil
+	}
+	self := &uploadStore{
+		dir:      dir,
+		maxSize:  int64(mapGetInt(config, "resumable_upload_max_size", 64*1024*1024)),
+		maxTotal: int64(mapGetInt(config, "resumable_upload_max_total", 1024*1024*1024)),
+		expire:   time.Duration(mapGetInt(config, "resumable_upload_expire", 86400)) * time.Second,
+		busy:     make(map[string]bool),
+	}
+	self.load()
+	return self
+}
@@ -0,0 +1,85 @@
+package srnd
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+
+	meta := parseUploadMetadata("filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential")
+	if meta["filename"] != "world_domination_plan.pdf" {
+		t.Error("bad filename", meta)
+	}
+	if _, ok := meta["is_confidential"]; !ok {
+		t.Error("keys without values should be kept", meta)
+	}
+
+}
+
+func TestUploadStoreTotal(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	store := &uploadStore{dir: dir, maxSize: 10, maxTotal: 15, busy: make(map[string]bool)}
+	id, err := store.Create(uploadInfo{Length: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Create(uploadInfo{Length: 10}); err != UploadsFull {
+		t.Error("uploads went over the total", err)
+	}
+	store.Remove(id)
+	store.Remove(id)
+	if store.used != 0 {
+		t.Error("removed upload still counted", store.used)
+	}
+	if _, err = store.Create(uploadInfo{Length: 10}); err != nil {
+		t.Error("no room after removing an upload", err)
+	}
+	reloaded := &uploadStore{dir: dir}
+	reloaded.load()
+	if reloaded.used != 10 {
+		t.Error("uploads from before a restart not counted", reloaded.used)
+	}
+
+}
+
+func TestUploadStoreTake(t *testing.T) {
+
+	dir, done := testDir(t)
+	defer done()
+	store := &uploadStore{dir: dir, maxSize: 10, busy: make(map[string]bool)}
+	id, err := store.Create(uploadInfo{Length: 5, Filename: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Take(id); err != UploadNotDone {
+		t.Error("took an unfinished upload", err)
+	}
+	if _, err = store.Append(id, 0, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var taken int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Take(id); err == nil {
+				atomic.AddInt32(&taken, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if taken != 1 {
+		t.Error("upload was taken", taken, "times")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 || store.used != 0 {
+		t.Error("taken upload left behind", len(files), store.used)
+	}
+
+}
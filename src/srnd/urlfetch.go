@@ -0,0 +1,178 @@
+//
+// urlfetch.go -- attach files to web posts from a url the frontend downloads
+//
+
+package srnd
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+var NoURLAttachments = errors.New("this frontend does not attach files from urls")
+var URLAttachmentTooBig = errors.New("file at url is too big")
+
+// addresses we never fetch from when we connect out directly
+// nat64 and ipv4 translated addresses can carry any ipv4 address so they are all out
+var privateNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "240.0.0.0/4", "::1/128", "::ffff:0:0:0/96", "64:ff9b::/96", "fc00::/7", "fe80::/10"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		privateNets = append(privateNets, n)
+	}
+}
+
+// return true if we may connect to ip for a poster
+// ipv4 mapped addresses in ::ffff:0:0/96 are checked as the ipv4 address they carry
+func publicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+type urlFetcher struct {
+	client *http.Client
+	// biggest file we take
+	maxSize int64
+	// mime type prefixes we take
+	types []string
+}
+
+// dial only public addresses so posters can't make us reach into our own network
+func dialPublic(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if publicIP(ip.IP) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		}
+	}
+	return nil, errors.New("will not fetch from " + host)
+}
+
+// does a file of this type go in a post?
+func (self *urlFetcher) allowedType(mimetype string) bool {
+	for _, t := range self.types {
+		if strings.HasPrefix(mimetype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// download a file to attach to a post
+func (self *urlFetcher) Fetch(link string) (att postAttachment, err error) {
+	if self == nil {
+		err = NoURLAttachments
+		return
+	}
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		err = errors.New("bad attachment url")
+		return
+	}
+	resp, err := self.client.Get(u.String())
+	if err != nil {
+		log.Println("cannot fetch attachment", u, err)
+		err = errors.New("cannot fetch attachment from url")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("attachment url gave us %s", resp.Status)
+		return
+	}
+	if resp.ContentLength > self.maxSize {
+		err = URLAttachmentTooBig
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, self.maxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > self.maxSize {
+		err = URLAttachmentTooBig
+		return
+	}
+	// what the file is and not what the server says it is
+	mimetype := http.DetectContentType(data)
+	if idx := strings.Index(mimetype, ";"); idx > 0 {
+		mimetype = mimetype[:idx]
+	}
+	if !self.allowedType(mimetype) {
+		err = errors.New("attachments can't be " + mimetype)
+		return
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = "file"
+	}
+	if exts, _ := mime.ExtensionsByType(mimetype); len(exts) > 0 && path.Ext(name) == "" {
+		name += exts[0]
+	}
+	att = postAttachment{
+		Filename: name,
+		Filetype: mimetype,
+		Filedata: base64.StdEncoding.EncodeToString(data),
+	}
+	return
+}
+
+// create the url fetcher from frontend config, nil if it is disabled
+// proxy_type and proxy_addr work like they do for feeds, none to connect directly
+func urlFetcherFromConfig(config map[string]string, daemon *NNTPDaemon) *urlFetcher {
+	if config["url_attachments"] != "1" {
+		return nil
+	}
+	proxyType := strings.ToLower(config["url_attachment_proxy_type"])
+	proxyAddr := config["url_attachment_proxy_addr"]
+	transport := &http.Transport{}
+	if proxyType == "" || proxyType == "none" {
+		transport.DialContext = dialPublic
+	} else {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return daemon.dialOut(proxyType, proxyAddr, addr)
+		}
+	}
+	self := &urlFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(mapGetInt(config, "url_attachment_timeout", 60)) * time.Second,
+		},
+		maxSize: int64(mapGetInt(config, "url_attachment_max_size", 8*1024*1024)),
+	}
+	for _, t := range strings.Split(config["url_attachment_types"], ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			self.types = append(self.types, t)
+		}
+	}
+	log.Println("attachments from urls enabled, proxy", proxyType, proxyAddr)
+	return self
+}
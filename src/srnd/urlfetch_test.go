@@ -0,0 +1,21 @@
+package srnd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "198.18.0.1", "240.0.0.1", "::1", "fe80::1", "::ffff:127.0.0.1", "::ffff:0:7f00:1", "64:ff9b::7f00:1"} {
+		if publicIP(net.ParseIP(addr)) {
+			t.Error("address should not be public", addr)
+		}
+	}
+	for _, addr := range []string{"1.1.1.1", "::ffff:1.1.1.1", "2606:4700::1111"} {
+		if !publicIP(net.ParseIP(addr)) {
+			t.Error("address should be public", addr)
+		}
+	}
+
+}
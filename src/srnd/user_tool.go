@@ -0,0 +1,89 @@
+//
+// user_tool.go -- managing nntp logins from the shell
+//
+
+package srnd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var NoSuchUser = errors.New("no such user")
+
+// add, change, disable and list nntp logins
+// usage: list | add name password [role] | rm name | passwd name password | role name role | disable name | enable name
+// changes take effect the next time the login logs in
+func UserTool(w io.Writer, args []string) (err error) {
+	usage := errors.New("usage: user list | add name password [reader|poster|feeder|mod] | rm name | passwd name password | role name reader|poster|feeder|mod | disable name | enable name")
+	if len(args) == 0 {
+		return usage
+	}
+	nargs := map[string]int{"list": 1, "add": 3, "rm": 2, "passwd": 3, "role": 3, "disable": 2, "enable": 2}
+	n, ok := nargs[args[0]]
+	if !ok || !(len(args) == n || (args[0] == "add" && len(args) == 4)) {
+		return usage
+	}
+	role := NNTPRoleFeeder
+	if args[0] == "role" {
+		role = args[2]
+	} else if args[0] == "add" && len(args) == 4 {
+		role = args[3]
+	}
+	if !validNNTPRole(role) {
+		return errors.New("no such role " + role + ", one of reader poster feeder mod")
+	}
+	conf, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	if args[0] == "list" {
+		var users []NNTPUser
+		users, err = db.GetNNTPUsers()
+		for _, u := range users {
+			if u.Disabled {
+				fmt.Fprintln(w, u.Username, u.Role, "disabled")
+			} else {
+				fmt.Fprintln(w, u.Username, u.Role)
+			}
+		}
+		return
+	}
+	username := args[1]
+	var user *NNTPUser
+	user, err = db.GetNNTPUser(username)
+	if err != nil {
+		return
+	}
+	if args[0] == "add" {
+		if user != nil {
+			return errors.New("user " + username + " exists")
+		}
+		err = db.AddNNTPLogin(username, args[2])
+		if err == nil {
+			err = db.SetNNTPUserRole(username, role)
+		}
+	} else if user == nil {
+		return NoSuchUser
+	} else {
+		switch args[0] {
+		case "rm":
+			err = db.RemoveNNTPLogin(username)
+		case "passwd":
+			err = db.SetNNTPPassword(username, args[2])
+		case "role":
+			err = db.SetNNTPUserRole(username, role)
+		case "disable":
+			err = db.SetNNTPUserDisabled(username, true)
+		case "enable":
+			err = db.SetNNTPUserDisabled(username, false)
+		}
+	}
+	if err == nil {
+		fmt.Fprintln(w, args[0], username)
+	}
+	return
+}
@@ -133,19 +133,6 @@ func (self int64Sorter) Swap(i, j int) {
 	self[i] = tmp
 }
 
-// obtain the "real" ip address
-func getRealIP(name string) string {
-	if len(name) > 0 {
-		ip, err := net.ResolveIPAddr("ip", name)
-		if err == nil {
-			if ip.IP.IsGlobalUnicast() {
-				return ip.IP.String()
-			}
-		}
-	}
-	return ""
-}
-
 // check that we have permission to access this
 // fatal on fail
 func checkPerms(fname string) {
@@ -383,6 +370,15 @@ func parseTripcodeSecret(str string) []byte {
 	return raw
 }
 
+// parse a hex ed25519 seed to sign a post with, nil if it is not one
+func parseSigningKey(str string) []byte {
+	raw := unhex(strings.TrimSpace(str))
+	if len(raw) != nacl.CryptoSignSeedLen() {
+		return nil
+	}
+	return raw
+}
+
 // generate a login salt for nntp users
 func genLoginCredSalt() (salt string) {
 	salt = randStr(128)
@@ -584,21 +580,25 @@ func extractRealIP(r *http.Request) (ip string, err error) {
 	ip, _, err = net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		log.Println("extract real ip: ", err)
+		return
+	}
+	// only our own reverse proxies get to say who the client is
+	if !trustedProxy(ip) {
+		return
 	}
-	// TODO: have in config upstream proxy ip and check for that
-	if strings.HasPrefix(ip, "127.") {
-		// if it's loopback check headers for reverse proxy headers
-		// TODO: make sure this isn't a tor user being sneaky
-		ip = getRealIP(r.Header.Get("X-Real-IP"))
-		if ip == "" {
-			// try X-Forwarded-For if X-Real-IP not set
-			_ip := r.Header.Get("X-Forwarded-For")
-			parts := strings.Split(_ip, ",")
-			_ip = parts[0]
-			ip = getRealIP(_ip)
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real, nil
+	}
+	// walk back through the proxies, the first address that isn't one of ours is the client
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		addr := strings.TrimSpace(forwarded[idx])
+		if net.ParseIP(addr) == nil {
+			break
 		}
-		if ip == "" {
-			ip = "127.0.0.1"
+		ip = addr
+		if !trustedProxy(addr) {
+			break
 		}
 	}
 	return
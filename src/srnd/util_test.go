@@ -0,0 +1,23 @@
+package srnd
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExtractRealIP(t *testing.T) {
+
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8, 127.0.0.1")
+	ip, _ := extractRealIP(r)
+	if ip != "5.6.7.8" {
+		t.Error("should take the last address our proxies did not add", ip)
+	}
+	r.RemoteAddr = "9.9.9.9:1234"
+	ip, _ = extractRealIP(r)
+	if ip != "9.9.9.9" {
+		t.Error("should not believe headers from other addresses", ip)
+	}
+
+}
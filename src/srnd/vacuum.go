@@ -0,0 +1,133 @@
+//
+// vacuum.go -- removing attachment files and thumbnails no article has any more
+//
+
+package srnd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// thumbnails older than minAge whose attachment file is gone
+func orphanThumbnails(thumbs string, store ArticleStore, minAge time.Duration) (orphans []string, err error) {
+	var infos []os.FileInfo
+	infos, err = ioutil.ReadDir(thumbs)
+	if err != nil {
+		return
+	}
+	before := time.Now().Add(-minAge)
+	for _, info := range infos {
+		fname := strings.TrimSuffix(info.Name(), ".jpg")
+		if _, ok := attachmentFileHash(fname); !ok || !info.Mode().IsRegular() || !info.ModTime().Before(before) {
+			continue
+		}
+		if !CheckFile(store.AttachmentFilepath(fname)) {
+			orphans = append(orphans, info.Name())
+		}
+	}
+	return
+}
+
+// remove a file and say how big it was, 0 if it wasn't there
+func vacuumFile(fpath string, dryRun bool) (int64, error) {
+	info, err := os.Stat(fpath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if !dryRun {
+		err = os.Remove(fpath)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+	}
+	return info.Size(), err
+}
+
+// delete attachment files no article in the database has, their thumbnails, and thumbnails of files that are gone
+// usage: [-dry-run] [-min-age duration]
+// safe with the daemon running, files younger than -min-age may belong to an article being stored
+// and every file is checked against the database again right before it goes
+func VacuumAttachmentsTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("vacuum-attachments", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print what would be removed and remove nothing")
+	minAge := flags.Duration("min-age", orphanAttachmentAge, "leave files younger than this alone")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *minAge < 0 {
+		return errors.New("usage: vacuum-attachments [-dry-run] [-min-age duration]")
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+
+	var orphans []string
+	orphans, err = orphanAttachments(db, store, *minAge)
+	if err != nil {
+		return
+	}
+	var files, thumbs int
+	var reclaimed int64
+	for _, fname := range orphans {
+		hash, _ := attachmentFileHash(fname)
+		var referenced bool
+		referenced, err = db.AttachmentReferenced(hash)
+		if err != nil {
+			return
+		}
+		if referenced {
+			// an article with it came in since we looked
+			continue
+		}
+		var size int64
+		size, err = vacuumFile(store.AttachmentFilepath(fname), *dryRun)
+		if err != nil {
+			return
+		}
+		files++
+		reclaimed += size
+		fmt.Fprintf(w, "%s attachment %s %s\n", verb, fname, formatBytes(uint64(size)))
+		size, err = vacuumFile(store.ThumbnailFilepath(fname), *dryRun)
+		if err != nil {
+			return
+		}
+		if size > 0 {
+			thumbs++
+			reclaimed += size
+		}
+	}
+
+	dir := conf.store["thumbs_dir"]
+	orphans, err = orphanThumbnails(dir, store, *minAge)
+	if err != nil {
+		return
+	}
+	for _, name := range orphans {
+		var size int64
+		size, err = vacuumFile(filepath.Join(dir, name), *dryRun)
+		if err != nil {
+			return
+		}
+		thumbs++
+		reclaimed += size
+		fmt.Fprintf(w, "%s thumbnail %s %s\n", verb, name, formatBytes(uint64(size)))
+	}
+	fmt.Fprintf(w, "%s %d attachments and %d thumbnails, %s reclaimed\n", verb, files, thumbs, formatBytes(uint64(reclaimed)))
+	return
+}
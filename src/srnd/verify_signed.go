@@ -0,0 +1,113 @@
+//
+// verify_signed.go -- checking the signatures of every signed article in the spool again
+//
+
+package srnd
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// check the article in r is msgid, signed by pubkey, and its signature still holds
+// returns the newsgroup it says it is in
+func verifySignedArticle(r io.Reader, msgid, pubkey string) (newsgroup string, err error) {
+	br := bufio.NewReader(r)
+	hdr, err := readMIMEHeader(br)
+	if err != nil {
+		return
+	}
+	outer := &nntpArticle{headers: ArticleHeaders(hdr)}
+	newsgroup = outer.Newsgroup()
+	if outer.MessageID() != msgid {
+		err = fmt.Errorf("file has message-id %s", outer.MessageID())
+		return
+	}
+	media_type, _, _ := mime.ParseMediaType(outer.ContentType())
+	if media_type != "message/rfc822" {
+		err = errors.New("article is not signed")
+		return
+	}
+	if !strings.EqualFold(outer.Pubkey(), pubkey) {
+		err = fmt.Errorf("signed by %s, not %s", outer.Pubkey(), pubkey)
+		return
+	}
+	inner, _, err := verifyMessage(pubkey, hdr.Get("X-Signature-Ed25519-Sha512"), br)
+	if err == nil {
+		err = checkSignedInner(outer, inner)
+	}
+	return
+}
+
+// what to do with signed articles that don't verify
+var verifySignedActions = []string{"none", "report", "quarantine"}
+
+// verify the signature of every article the database has a pubkey for
+// usage: [-action none|report|quarantine]
+// report files a mod report for each article that fails, quarantine takes it down until a mod looks at it
+func VerifySignedTool(w io.Writer, args []string) (err error) {
+	flags := flag.NewFlagSet("verify-signed", flag.ExitOnError)
+	action := flags.String("action", "report", "what to do with articles that fail, one of "+strings.Join(verifySignedActions, " "))
+	flags.Parse(args)
+	valid := false
+	for _, a := range verifySignedActions {
+		valid = valid || a == *action
+	}
+	if !valid || flags.NArg() != 0 {
+		return errors.New("usage: verify-signed [-action " + strings.Join(verifySignedActions, "|") + "]")
+	}
+	conf := ReadConfig()
+	if conf == nil {
+		return errors.New("cannot load config")
+	}
+	db := toolDatabase(conf)
+	defer db.Close()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+
+	var signed map[string]string
+	signed, err = db.GetSignedArticles()
+	if err != nil {
+		return
+	}
+	msgids := make([]string, 0, len(signed))
+	for msgid := range signed {
+		msgids = append(msgids, msgid)
+	}
+	sort.Strings(msgids)
+	var failed, missing int
+	for _, msgid := range msgids {
+		r, e := store.OpenMessage(msgid)
+		if e != nil {
+			missing++
+			continue
+		}
+		newsgroup, e := verifySignedArticle(r, msgid, signed[msgid])
+		r.Close()
+		if e == nil {
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "%s in %s: %s\n", msgid, newsgroup, e)
+		reason := "signature does not verify: " + e.Error()
+		switch *action {
+		case "report":
+			err = db.AddReport(msgid, newsgroup, reason)
+		case "quarantine":
+			err = db.DeleteArticle(msgid)
+			if err == nil {
+				err = db.QuarantineArticle(msgid, newsgroup, 1)
+			}
+		}
+		if err != nil {
+			return errors.New(msgid + ": " + err.Error())
+		}
+	}
+	fmt.Fprintf(w, "%d signed articles, %d failed, %d missing from store_dir\n", len(msgids), failed, missing)
+	return
+}
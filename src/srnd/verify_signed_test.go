@@ -0,0 +1,27 @@
+package srnd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignedArticle(t *testing.T) {
+
+	msgid := genMessageID("test.tld")
+	nntp := newPlaintextArticle("hello", "test@test.tld", "test", "test", "test.tld", msgid, "overchan.test")
+	var buff bytes.Buffer
+	nntp.WriteTo(&buff)
+	newsgroup, err := verifySignedArticle(bytes.NewReader(buff.Bytes()), msgid, strings.Repeat("a", 64))
+	if err == nil {
+		t.Error("an unsigned article verified")
+	}
+	if newsgroup != "overchan.test" {
+		t.Errorf("newsgroup is %q", newsgroup)
+	}
+	_, err = verifySignedArticle(bytes.NewReader(buff.Bytes()), "<other@test.tld>", strings.Repeat("a", 64))
+	if err == nil || !strings.Contains(err.Error(), "message-id") {
+		t.Errorf("an article under another message-id gave %v", err)
+	}
+
+}
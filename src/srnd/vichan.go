@@ -0,0 +1,133 @@
+//
+// vichan.go -- boards.json and catalog.json shaped like vichan's under /vichan/ so imageboard apps work with us
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// a board in boards.json
+type vichanBoard struct {
+	Board           string `json:"board"`
+	Title           string `json:"title"`
+	MetaDescription string `json:"meta_description"`
+	// we don't know, so no
+	WorkSafe int   `json:"ws_board"`
+	Pages    int64 `json:"pages"`
+	PerPage  int   `json:"per_page"`
+}
+
+// a thread in catalog.json, its op with counts
+type vichanThread struct {
+	No       int64  `json:"no"`
+	Resto    int64  `json:"resto"`
+	Time     int64  `json:"time"`
+	Name     string `json:"name"`
+	Trip     string `json:"trip,omitempty"`
+	Sub      string `json:"sub,omitempty"`
+	Com      string `json:"com"`
+	Replies  int64  `json:"replies"`
+	Images   int64  `json:"images"`
+	Sticky   int    `json:"sticky,omitempty"`
+	Locked   int    `json:"locked,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Ext      string `json:"ext,omitempty"`
+	Tim      string `json:"tim,omitempty"`
+	Spoiler  int    `json:"spoiler,omitempty"`
+	// our own, so apps that know us don't have to map numbers back
+	MessageID string `json:"message_id"`
+}
+
+// a page of catalog.json
+type vichanCatalogPage struct {
+	Page    int            `json:"page"`
+	Threads []vichanThread `json:"threads"`
+}
+
+func vichanBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// make the catalog entry for a thread's op
+func vichanThreadFor(op PostModel, counts ThreadCounts, db Database) (t vichanThread) {
+	t.No, _ = db.GetNNTPIDForMessageID(op.Board(), op.MessageID())
+	if p, ok := op.(*post); ok {
+		t.Time = p.Posted
+	}
+	t.Name = op.Name()
+	t.Trip = op.Trip()
+	t.Sub = op.Subject()
+	t.Com = op.RenderTruncatedBody()
+	t.Replies = counts.Replies
+	t.Images = counts.Images
+	t.Sticky = vichanBool(db.CheckThreadFlag(op.MessageID(), ThreadSticky))
+	t.Locked = vichanBool(db.CheckThreadFlag(op.MessageID(), ThreadLocked))
+	t.MessageID = op.MessageID()
+	if atts := op.Attachments(); len(atts) > 0 {
+		ext := filepath.Ext(atts[0].Source())
+		t.Filename = strings.TrimSuffix(atts[0].Filename(), filepath.Ext(atts[0].Filename()))
+		t.Ext = ext
+		t.Tim = atts[0].Hash()
+		t.Spoiler = vichanBool(atts[0].Spoiler())
+	}
+	return
+}
+
+// GET /vichan/boards.json
+func (self *httpFrontend) handle_vichan_boards(wr http.ResponseWriter, r *http.Request) {
+	db := self.daemon.database
+	boards := []vichanBoard{}
+	for _, group := range db.GetAllNewsgroups() {
+		if !self.AllowNewsgroup(group) || group == "ctl" {
+			continue
+		}
+		if banned, _ := db.NewsgroupBanned(group); banned {
+			continue
+		}
+		settings, _ := db.GetBoardSettings(group)
+		title := settings.Description
+		if title == "" {
+			title = group
+		}
+		boards = append(boards, vichanBoard{
+			Board:           group,
+			Title:           title,
+			MetaDescription: settings.Description,
+			Pages:           db.GetGroupPageCount(group),
+			PerPage:         settings.ThreadsPerPage,
+		})
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wr).Encode(map[string]interface{}{"boards": boards})
+}
+
+// GET /vichan/{board}/catalog.json
+func (self *httpFrontend) handle_vichan_catalog(wr http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["board"]
+	db := self.daemon.database
+	if !newsgroupValidFormat(group) || !self.AllowNewsgroup(group) || group == "ctl" || !db.HasNewsgroup(group) {
+		api_v1_error(wr, 404, errors.New("no such board"))
+		return
+	}
+	counts, _ := db.GetGroupThreadCounts(group)
+	pages := []vichanCatalogPage{}
+	for page, bm := range template.obtainBoard(self.prefix, self.name, group, false, db) {
+		p := vichanCatalogPage{Page: page, Threads: []vichanThread{}}
+		for _, th := range bm.Threads() {
+			p.Threads = append(p.Threads, vichanThreadFor(th.OP(), counts[th.OP().MessageID()], db))
+		}
+		pages = append(pages, p)
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(wr).Encode(pages)
+}
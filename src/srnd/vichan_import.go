@@ -0,0 +1,261 @@
+//
+// vichan_import.go -- bringing threads from vichan and 4chan api json dumps over, so a board moving here keeps its history
+//
+
+package srnd
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// biggest file we download for an imported post
+const vichanMaxMedia = 64 * 1024 * 1024
+
+var exp_vichan_br = regexp.MustCompile(`(?i)<br\s*/?>`)
+var exp_vichan_tag = regexp.MustCompile(`<[^>]*>`)
+var exp_vichan_quote = regexp.MustCompile(`>>(\d+)`)
+var exp_vichan_tim = regexp.MustCompile(`^\d+$`)
+var exp_vichan_ext = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+
+// tim is a number in 4chan dumps and a string in vichan's
+type vichanTim string
+
+func (self *vichanTim) UnmarshalJSON(data []byte) error {
+	*self = vichanTim(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// a file of a post in a dump
+type vichanFile struct {
+	Tim      vichanTim `json:"tim"`
+	Ext      string    `json:"ext"`
+	Filename string    `json:"filename"`
+}
+
+// tim and ext make the path we fetch, anything but a number and an extension could leave the media directory
+func (self vichanFile) Valid() bool {
+	return exp_vichan_tim.MatchString(string(self.Tim)) && exp_vichan_ext.MatchString(self.Ext)
+}
+
+// a post in a dump
+type vichanPost struct {
+	No    int64  `json:"no"`
+	Resto int64  `json:"resto"`
+	Time  int64  `json:"time"`
+	Name  string `json:"name"`
+	Trip  string `json:"trip"`
+	Sub   string `json:"sub"`
+	Com   string `json:"com"`
+	vichanFile
+	// vichan's posts with more than one file
+	ExtraFiles []vichanFile `json:"extra_files"`
+}
+
+// a thread of a dump, res/123.json
+type vichanThreadDump struct {
+	Posts []vichanPost `json:"posts"`
+}
+
+// the message-id a post of a dump gets, the same every time so importing again skips it
+func vichanMessageID(group string, no int64, instance string) string {
+	return fmt.Sprintf("<%x@%s>", sha1.Sum([]byte(group+"/"+strconv.FormatInt(no, 10))), instance)
+}
+
+// turn a post's html back into text, quotes of posts we have point at them the way we quote
+func vichanComment(com string, numbers map[int64]string) string {
+	text := exp_vichan_br.ReplaceAllString(com, "\n")
+	text = html.UnescapeString(exp_vichan_tag.ReplaceAllString(text, ""))
+	return exp_vichan_quote.ReplaceAllStringFunc(text, func(quote string) string {
+		no, _ := strconv.ParseInt(quote[2:], 10, 64)
+		if msgid, ok := numbers[no]; ok {
+			return ">>" + ShortHashMessageID(msgid)
+		}
+		return quote
+	})
+}
+
+// read a dump or a file from a url or a local path
+func vichanFetch(client *http.Client, where string) ([]byte, error) {
+	if !strings.HasPrefix(where, "http://") && !strings.HasPrefix(where, "https://") {
+		return ioutil.ReadFile(where)
+	}
+	resp, err := client.Get(where)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(where + ": " + resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, vichanMaxMedia+1))
+	if err == nil && len(data) > vichanMaxMedia {
+		err = URLAttachmentTooBig
+	}
+	return data, err
+}
+
+// import threads from vichan or 4chan api json dumps into a newsgroup
+// usage: [-media url-or-dir] newsgroup thread.json ...
+// files are fetched from media followed by tim and ext, vichan's is https://site/board/src/
+func ImportVichanTool(args []string) {
+	flags := flag.NewFlagSet("import-vichan", flag.ExitOnError)
+	media := flags.String("media", "", "where the files of the dump are, a url or a directory, empty to import without them")
+	flags.Parse(args)
+	if flags.NArg() < 2 || !newsgroupValidFormat(flags.Arg(0)) {
+		log.Println("usage: import-vichan [-media url-or-dir] newsgroup thread.json ...")
+		return
+	}
+	group := flags.Arg(0)
+	conf := ReadConfig()
+	instance := conf.daemon["instance_name"]
+	db := toolDatabase(conf)
+	defer db.Close()
+	db.CreateTables()
+	store := createArticleStore(conf.store, db, nil, nil, nil, nil)
+	client := &http.Client{Timeout: time.Minute}
+	// post number -> message-id, for quotes between threads
+	numbers := make(map[int64]string)
+	var threads []vichanThreadDump
+	for _, where := range flags.Args()[1:] {
+		var dump vichanThreadDump
+		data, err := vichanFetch(client, where)
+		if err == nil {
+			err = json.Unmarshal(data, &dump)
+		}
+		if err != nil || len(dump.Posts) == 0 {
+			log.Println("skipping", where, err)
+			continue
+		}
+		for _, p := range dump.Posts {
+			numbers[p.No] = vichanMessageID(group, p.No, instance)
+		}
+		threads = append(threads, dump)
+	}
+	var imported, skipped int
+	for _, dump := range threads {
+		posts := dump.Posts
+		// op first
+		sort.SliceStable(posts, func(i, j int) bool {
+			if (posts[i].Resto == 0) != (posts[j].Resto == 0) {
+				return posts[i].Resto == 0
+			}
+			return posts[i].No < posts[j].No
+		})
+		for _, p := range posts {
+			msgid := numbers[p.No]
+			if db.HasArticle(msgid) || store.HasArticle(msgid) {
+				skipped++
+				continue
+			}
+			err := importVichanPost(p, msgid, numbers, group, instance, *media, client, store, db)
+			if err == nil {
+				imported++
+			} else {
+				log.Println("failed to import post", p.No, err)
+				skipped++
+			}
+		}
+	}
+	log.Printf("imported %d posts from %d threads into %s, %d skipped", imported, len(threads), group, skipped)
+}
+
+// make the article for a post, with its files, and store it
+func importVichanPost(p vichanPost, msgid string, numbers map[int64]string, group, instance, media string, client *http.Client, store ArticleStore, db Database) (err error) {
+	name := html.UnescapeString(p.Name)
+	if name == "" {
+		name = "Anonymous"
+	}
+	if p.Trip != "" {
+		name += " " + p.Trip
+	}
+	nntp := newPlaintextArticle(vichanComment(p.Com, numbers), "poster@"+instance, html.UnescapeString(p.Sub), name, instance, msgid, group)
+	nntp.Headers().Set("Date", time.Unix(p.Time, 0).UTC().Format(time.RFC1123Z))
+	if p.Resto != 0 {
+		root, ok := numbers[p.Resto]
+		if !ok {
+			return errors.New("thread " + strconv.FormatInt(p.Resto, 10) + " is not in the dump")
+		}
+		nntp.Headers().Set("References", root)
+	}
+	var saved []string
+	if media != "" {
+		for _, f := range append([]vichanFile{p.vichanFile}, p.ExtraFiles...) {
+			if f.Tim == "" && f.Ext == "" {
+				continue
+			}
+			if !f.Valid() {
+				log.Println("bad file", string(f.Tim)+f.Ext, "for post", p.No, "not importing it")
+				continue
+			}
+			fname := string(f.Tim) + f.Ext
+			var data []byte
+			if strings.HasPrefix(media, "http://") || strings.HasPrefix(media, "https://") {
+				data, err = vichanFetch(client, strings.TrimSuffix(media, "/")+"/"+fname)
+			} else {
+				data, err = vichanFetch(client, filepath.Join(media, fname))
+			}
+			if err != nil {
+				log.Println("no file", fname, "for post", p.No, err)
+				err = nil
+				continue
+			}
+			mimetype := mime.TypeByExtension(f.Ext)
+			if mimetype == "" {
+				mimetype = "application/octet-stream"
+			}
+			att := createAttachment(mimetype, html.UnescapeString(f.Filename)+f.Ext, strings.NewReader(base64.StdEncoding.EncodeToString(data)))
+			if att == nil {
+				continue
+			}
+			nntp.Attach(att)
+			err = att.Save(store.AttachmentDir())
+			if err != nil {
+				break
+			}
+			saved = append(saved, att.Filepath())
+			if !CheckFile(store.ThumbnailFilepath(att.Filepath())) {
+				store.GenerateThumbnail(att.Filepath())
+			}
+		}
+	}
+	nntp.Pack()
+	if err == nil {
+		f := store.CreateFile(msgid)
+		if f == nil {
+			err = errors.New("failed to store article")
+		} else {
+			err = nntp.WriteTo(f)
+			f.Close()
+			if err == nil {
+				err = db.RegisterArticle(nntp)
+			}
+			if err != nil {
+				DelFile(store.GetFilename(msgid))
+			}
+		}
+	}
+	if err != nil {
+		for _, fname := range saved {
+			DelFile(filepath.Join(store.AttachmentDir(), fname))
+			DelFile(store.ThumbnailFilepath(fname))
+		}
+	}
+	return
+}
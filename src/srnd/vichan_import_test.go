@@ -0,0 +1,41 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestVichanComment(t *testing.T) {
+
+	msgid := "<test@example.tld>"
+	com := `<a href="#p12" class="quotelink">&gt;&gt;12</a><br><span class="quote">&gt;implying</span><br/>&gt;&gt;99 &amp; more`
+	text := vichanComment(com, map[int64]string{12: msgid})
+	if text != ">>"+ShortHashMessageID(msgid)+"\n>implying\n>>99 & more" {
+		t.Errorf("bad comment %q", text)
+	}
+
+}
+
+func TestVichanFileValid(t *testing.T) {
+
+	good := []vichanFile{{Tim: "1500000000123", Ext: ".png"}, {Tim: "42", Ext: ".webm"}}
+	for _, f := range good {
+		if !f.Valid() {
+			t.Error("good file is not valid", f)
+		}
+	}
+	bad := []vichanFile{
+		{Tim: "../../etc/passwd", Ext: ".png"},
+		{Tim: "123", Ext: "/../x"},
+		{Tim: "123", Ext: ".PNG"},
+		{Tim: "123", Ext: ".toolong"},
+		{Tim: "12a", Ext: ".png"},
+		{Tim: "", Ext: ".png"},
+		{Tim: "123", Ext: ""},
+	}
+	for _, f := range bad {
+		if f.Valid() {
+			t.Error("bad file is valid", f)
+		}
+	}
+
+}
@@ -0,0 +1,163 @@
+//
+// watch.go -- threads readers watch from their browser with new reply counts
+//
+
+package srnd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cookie holding the hashes of the threads a reader watches
+const watchCookie = "watched"
+
+// the most threads one reader can watch
+const watchLimit = 50
+
+// a watched thread and how many replies it got since the reader last looked
+type watchedThread struct {
+	Hash      string `json:"hash"`
+	Newsgroup string `json:"newsgroup"`
+	Subject   string `json:"subject"`
+	URL       string `json:"url"`
+	Replies   int64  `json:"replies"`
+	New       int64  `json:"new"`
+	Since     int64  `json:"since"`
+}
+
+// return true if str looks like the hash of a message id
+func validThreadHash(str string) bool {
+	return len(str) == 40 && strings.Trim(str, "0123456789abcdef") == ""
+}
+
+// the thread hashes in the watch cookie
+func watchedHashes(r *http.Request) (hashes []string) {
+	c, err := r.Cookie(watchCookie)
+	if err != nil {
+		return
+	}
+	for _, h := range strings.Split(c.Value, "-") {
+		if validThreadHash(h) && len(hashes) < watchLimit {
+			hashes = append(hashes, h)
+		}
+	}
+	return
+}
+
+// parse hash[:since],hash[:since] where since defaults to since
+func parseWatchList(str string, since int64) map[string]int64 {
+	list := make(map[string]int64)
+	for _, part := range strings.Split(str, ",") {
+		parts := strings.SplitN(part, ":", 2)
+		if !validThreadHash(parts[0]) || len(list) >= watchLimit {
+			continue
+		}
+		list[parts[0]] = since
+		if len(parts) == 2 {
+			t, err := strconv.ParseInt(parts[1], 10, 64)
+			if err == nil {
+				list[parts[0]] = t
+			}
+		}
+	}
+	return list
+}
+
+// look up a watched thread, the thread of the post if hash is a reply
+func (self *httpFrontend) watchedThread(hash string, since int64) (w watchedThread, err error) {
+	db := self.daemon.database
+	var e ArticleEntry
+	e, err = db.GetMessageIDByHash(hash)
+	if err != nil || e.MessageID() == "" {
+		err = errors.New("no such thread")
+		return
+	}
+	var root, group string
+	root, group, _, err = db.GetInfoForMessage(e.MessageID())
+	if err != nil || group == "ctl" || !self.AllowNewsgroup(group) {
+		err = errors.New("no such thread")
+		return
+	}
+	w.Hash = HashMessageID(root)
+	w.Newsgroup = group
+	w.URL = self.prefix + "thread-" + w.Hash + ".html"
+	w.Replies = db.CountThreadReplies(root)
+	w.New = db.CountThreadRepliesSince(root, since)
+	w.Since = since
+	op := db.GetPostModel(self.prefix, root)
+	if op != nil {
+		w.Subject = op.Subject()
+	}
+	return
+}
+
+// GET /api/v1/watch?threads=hash[:since],...&since=unixtime
+// new reply counts of threads since a time, the threads in the watch cookie if none are given
+func (self *httpFrontend) handle_api_v1_watch(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+	list := parseWatchList(q.Get("threads"), since)
+	if len(list) == 0 {
+		for _, h := range watchedHashes(r) {
+			list[h] = since
+		}
+	}
+	threads := []watchedThread{}
+	for hash, t := range list {
+		w, err := self.watchedThread(hash, t)
+		if err == nil {
+			threads = append(threads, w)
+		}
+	}
+	wr.Header().Add("Content-Type", "text/json; encoding=UTF-8")
+	wr.Header().Set("Cache-Control", "private, no-cache")
+	json.NewEncoder(wr).Encode(map[string]interface{}{
+		"threads": threads,
+		// pass this as since next time
+		"now": timeNow(),
+	})
+}
+
+// GET /watch lists watched threads, /watch?add=hash and /watch?remove=hash change the list
+func (self *httpFrontend) handle_watch(wr http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	add, remove := q.Get("add"), q.Get("remove")
+	if add == "" && remove == "" {
+		var threads []watchedThread
+		for _, h := range watchedHashes(r) {
+			w, err := self.watchedThread(h, 0)
+			if err == nil {
+				threads = append(threads, w)
+			}
+		}
+		template.writeTemplate("watch.mustache", map[string]interface{}{
+			"prefix":  self.prefix,
+			"threads": threads,
+			"i18n":    i18nForRequest(r, ""),
+		}, wr)
+		return
+	}
+	var hashes []string
+	for _, h := range watchedHashes(r) {
+		if h != add && h != remove {
+			hashes = append(hashes, h)
+		}
+	}
+	if validThreadHash(add) {
+		if len(hashes) >= watchLimit {
+			hashes = hashes[1:]
+		}
+		hashes = append(hashes, add)
+	}
+	http.SetCookie(wr, &http.Cookie{
+		Name:   watchCookie,
+		Value:  strings.Join(hashes, "-"),
+		Path:   self.prefix,
+		MaxAge: 365 * 24 * 3600,
+	})
+	redirectBack(wr, r, self.prefix)
+}
@@ -0,0 +1,15 @@
+package srnd
+
+import (
+	"testing"
+)
+
+func TestParseWatchList(t *testing.T) {
+
+	h := HashMessageID("<test@example.com>")
+	l := parseWatchList(h+":100,bad:5,"+HashMessageID("<other@example.com>"), 42)
+	if len(l) != 2 || l[h] != 100 || l[HashMessageID("<other@example.com>")] != 42 {
+		t.Error("bad watch list", l)
+	}
+
+}
@@ -14,6 +14,12 @@ func main() {
 	daemon := new(srnd.NNTPDaemon)
 	if len(os.Args) > 1 {
 		action := os.Args[1]
+		if action == "setup" || action == "run" || action == "frontend" || action == "checkconf" || action == "doctor" {
+			// flags that override srnd.ini and the environment
+			if args := srnd.ParseConfigFlags(action, os.Args[2:]); len(args) > 0 {
+				log.Fatalf("unexpected arguments %q", args)
+			}
+		}
 		if action == "setup" {
 			log.Println("Setting up SRNd base...")
 			daemon.Setup()
@@ -30,7 +36,134 @@ func main() {
 				daemon.End()
 				os.Exit(0)
 			}()
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			go func() {
+				for range hup {
+					daemon.Reload()
+				}
+			}()
 			daemon.Run()
+		} else if action == "frontend" {
+			// just the web frontend, srnd runs elsewhere
+			log.Printf("Starting frontend %s...", srnd.Version())
+			daemon.Setup()
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt)
+			signal.Notify(c, syscall.SIGTERM)
+			go func() {
+				<-c
+				log.Println("Shutting down...")
+				daemon.End()
+				os.Exit(0)
+			}()
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			go func() {
+				for range hup {
+					daemon.ReloadTemplates()
+				}
+			}()
+			daemon.RunFrontend()
+		} else if action == "import-mbox" {
+			if len(os.Args) == 4 {
+				srnd.ImportMboxTool(os.Args[2], os.Args[3])
+			} else {
+				fmt.Fprintf(os.Stdout, "Usage: %s import-mbox mbox-or-maildir newsgroup\n", os.Args[0])
+			}
+		} else if action == "export-mbox" {
+			if len(os.Args) == 3 || len(os.Args) == 4 {
+				thread := ""
+				if len(os.Args) == 4 {
+					thread = os.Args[3]
+				}
+				srnd.ExportMboxTool(os.Stdout, os.Args[2], thread)
+			} else {
+				fmt.Fprintf(os.Stdout, "Usage: %s export-mbox newsgroup [thread-message-id] > file.mbox\n", os.Args[0])
+			}
+		} else if action == "doctor" {
+			if srnd.DoctorTool(os.Stdout) > 0 {
+				os.Exit(1)
+			}
+		} else if action == "expire" {
+			srnd.ExpireTool(os.Args[2:])
+		} else if action == "stats" {
+			srnd.StatsTool(os.Args[2:])
+		} else if action == "ban" {
+			err := srnd.BanTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "user" {
+			err := srnd.UserTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "checkconf" {
+			if srnd.CheckConfTool(os.Stdout) > 0 {
+				os.Exit(1)
+			}
+		} else if action == "bench" {
+			err := srnd.BenchTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "backup" {
+			err := srnd.BackupTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "restore" {
+			err := srnd.RestoreTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "vacuum-attachments" {
+			err := srnd.VacuumAttachmentsTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "verify-signed" {
+			err := srnd.VerifySignedTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "shell" {
+			err := srnd.ShellTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "post" {
+			err := srnd.PostTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "recompress" {
+			err := srnd.RecompressTool(os.Stdout, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else if action == "keygen" {
+			// with a file the secret key goes there
+			srnd.KeygenTool(os.Args[2:]...)
+		} else if action == "modkey" {
+			if len(os.Args) == 4 || len(os.Args) == 5 {
+				group := ""
+				if len(os.Args) == 5 {
+					group = os.Args[4]
+				}
+				err := srnd.ModKeyTool(os.Args[2], os.Args[3], group)
+				if err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				fmt.Fprintf(os.Stdout, "Usage: %s modkey [grant|revoke|admin|unadmin|show] pubkey [newsgroup]\n", os.Args[0])
+			}
+		} else if action == "mod" {
+			err := srnd.ModClientTool(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
 		} else if action == "tool" {
 			if len(os.Args) > 2 {
 				tool := os.Args[2]
@@ -59,8 +192,20 @@ func main() {
 					}
 				} else if tool == "rethumb" {
 					srnd.ThumbnailTool()
+				} else if tool == "rebuild-thumbnails" {
+					srnd.RebuildThumbnailsTool(os.Args[3:])
+				} else if tool == "reindex" {
+					srnd.ReindexTool()
+				} else if tool == "import-vichan" {
+					srnd.ImportVichanTool(os.Args[3:])
 				} else if tool == "keygen" {
 					srnd.KeygenTool()
+				} else if tool == "spam" {
+					if len(os.Args) >= 5 && (os.Args[3] == "spam" || os.Args[3] == "ham" || os.Args[3] == "score") {
+						srnd.SpamTool(os.Args[3], os.Args[4:])
+					} else {
+						fmt.Fprintf(os.Stdout, "Usage: %s tool spam [spam|ham|score] message-id ...\n", os.Args[0])
+					}
 				} else if tool == "nntp" {
 					if len(os.Args) >= 5 {
 						action := os.Args[3]
@@ -83,15 +228,16 @@ func main() {
 						fmt.Fprintf(os.Stdout, "Usage: %s tool nntp [add-login|del-login]\n", os.Args[0])
 					}
 				} else {
-					fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod]\n", os.Args[0])
+					fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|rebuild-thumbnails|reindex|import-vichan|keygen|nntp|mod|spam]\n", os.Args[0])
 				}
 			} else {
-				fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|keygen|nntp|mod]\n", os.Args[0])
+				fmt.Fprintf(os.Stdout, "Usage: %s tool [rethumb|rebuild-thumbnails|reindex|import-vichan|keygen|nntp|mod|spam]\n", os.Args[0])
 			}
 		} else {
 			log.Println("Invalid action:", action)
 		}
 	} else {
-		fmt.Fprintf(os.Stdout, "Usage: %s [setup|run|tool]\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "Usage: %s [setup|run|frontend|checkconf|doctor|expire|stats|bench|backup|restore|vacuum-attachments|verify-signed|shell|post|recompress|ban|user|keygen|modkey|mod|import-mbox|export-mbox|tool]\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "setup, run, frontend, checkconf and doctor take flags like -bind and -set section.key=value over srnd.ini, see %s run -h\n", os.Args[0])
 	}
 }